@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"btidy/pkg/usecase"
+)
+
+func buildCacheCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cache",
+		Short: "Maintain the hash cache",
+		Long: `Maintains the hash cache in .btidy/hashcache.
+
+flatten, duplicate, manifest and pre-operation snapshots record the hash of
+every file they read, keyed by device, inode, size, modification time and
+change time, and reuse it while those are unchanged. Entries of files that
+changed are dropped when the file is hashed again; entries of files that
+were moved or deleted stay until pruned.
+
+Use --no-cache on any command to hash every file again.`,
+	}
+
+	cmd.AddCommand(buildCachePruneCommand())
+
+	return cmd
+}
+
+func buildCachePruneCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "prune [path]",
+		Short: "Drop cached hashes of files that are gone or changed",
+		Long: `Drops the entries of the hash cache whose file no longer exists at the
+path it was hashed at, or changed since.
+
+Examples:
+  btidy cache prune --dry-run ./backup  # Count stale entries
+  btidy cache prune ./backup            # Drop them`,
+		Args: cobra.ExactArgs(1),
+		RunE: runCachePrune,
+	}
+}
+
+func runCachePrune(cmd *cobra.Command, args []string) error {
+	printDryRunBanner()
+
+	execution, err := newUseCaseService().RunCachePrune(cmd.Context(), usecase.CachePruneRequest{
+		TargetDir: args[0],
+		DryRun:    dryRun,
+	})
+	if err != nil {
+		return err
+	}
+
+	printCommandHeader("CACHE PRUNE", execution.RootDir)
+	fmt.Printf("Cache file: %s\n", execution.CachePath)
+	fmt.Println()
+
+	printSummary(
+		fmt.Sprintf("Cached files: %d", execution.Entries),
+		fmt.Sprintf("Pruned:       %d", execution.Pruned),
+		fmt.Sprintf("Remaining:    %d", execution.Entries-execution.Pruned),
+	)
+	printDryRunHint()
+
+	return nil
+}
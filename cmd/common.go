@@ -2,11 +2,17 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/spf13/cobra"
+
+	"btidy/pkg/hasher"
+	"btidy/pkg/keeper"
 	"btidy/pkg/usecase"
 )
 
@@ -21,11 +27,49 @@ func skipDirs() []string {
 	return append([]string(nil), defaultSkipDirs...)
 }
 
+var (
+	keepStrategy   string
+	keepPreferDirs []string
+	keepAvoidDirs  []string
+)
+
+// addKeepFlags registers the flags that choose which copy of duplicate
+// content is kept.
+func addKeepFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&keepStrategy, "keep", string(keeper.StrategyFirstLexical),
+		"Copy to keep: oldest, newest, shortest-path, deepest-path or first-lexical")
+	cmd.Flags().StringArrayVar(&keepPreferDirs, "prefer-dir", nil,
+		"Keep copies under directories matching this glob first (repeatable; earlier wins)")
+	cmd.Flags().StringArrayVar(&keepAvoidDirs, "avoid-dir", nil,
+		"Keep copies under directories matching this glob only when no other copy exists (repeatable)")
+}
+
+// keepPolicy returns the keep policy selected by the flags of addKeepFlags.
+func keepPolicy() (keeper.Policy, error) {
+	strategy, err := keeper.ParseStrategy(keepStrategy)
+	if err != nil {
+		return keeper.Policy{}, err
+	}
+
+	policy := keeper.Policy{
+		Strategy:   strategy,
+		PreferDirs: keepPreferDirs,
+		AvoidDirs:  keepAvoidDirs,
+	}
+	if err := policy.Validate(); err != nil {
+		return keeper.Policy{}, err
+	}
+
+	return policy, nil
+}
+
 func newUseCaseService() *usecase.Service {
 	return usecase.New(usecase.Options{
-		SkipFiles:  skipFiles(),
-		SkipDirs:   skipDirs(),
-		NoSnapshot: noSnapshot,
+		SkipFiles:     skipFiles(),
+		SkipDirs:      skipDirs(),
+		NoSnapshot:    noSnapshot,
+		NoCache:       noCache,
+		HashAlgorithm: hasher.Algorithm(hashAlgo),
 	})
 }
 
@@ -60,6 +104,8 @@ type fileCommandExecutionInfo struct {
 	collectDuration time.Duration
 	snapshotPath    string
 	journalPath     string
+	interrupted     bool
+	hashCacheErr    error
 }
 
 func infoFromMeta(m usecase.WorkflowMeta) fileCommandExecutionInfo {
@@ -69,6 +115,8 @@ func infoFromMeta(m usecase.WorkflowMeta) fileCommandExecutionInfo {
 		collectDuration: m.CollectDuration,
 		snapshotPath:    m.SnapshotPath,
 		journalPath:     m.JournalPath,
+		interrupted:     m.Interrupted,
+		hashCacheErr:    m.HashCacheErr,
 	}
 }
 
@@ -86,10 +134,31 @@ func runFileCommand[T any](
 	execution, err = execute(progress)
 	progress.Stop()
 	if err != nil {
+		// A run that failed part way still journaled what it did; point
+		// the user at it so those operations can be undone.
+		if info := executionInfo(execution); info.journalPath != "" {
+			printExecutionHeader(command, info, trailingBlankLine, printExtraHeader)
+			if !info.interrupted {
+				printFailed("the journal covers the operations performed; run undo to reverse them")
+			}
+		}
 		return execution, false, err
 	}
 
 	info := executionInfo(execution)
+	printExecutionHeader(command, info, trailingBlankLine, printExtraHeader)
+
+	if info.fileCount == 0 {
+		fmt.Println("No files to process.")
+		return execution, true, nil
+	}
+
+	return execution, false, nil
+}
+
+// printExecutionHeader prints what a file command ran on: the header, the
+// snapshot and journal it wrote, and whether it was interrupted.
+func printExecutionHeader(command string, info fileCommandExecutionInfo, trailingBlankLine bool, printExtraHeader func()) {
 	printCommandHeader(command, info.rootDir)
 	if info.snapshotPath != "" {
 		fmt.Printf("Snapshot: %s\n", info.snapshotPath)
@@ -101,13 +170,10 @@ func runFileCommand[T any](
 		printExtraHeader()
 	}
 	printFoundFiles(info.fileCount, info.collectDuration, trailingBlankLine)
-
-	if info.fileCount == 0 {
-		fmt.Println("No files to process.")
-		return execution, true, nil
+	if info.interrupted {
+		printInterrupted("the journal covers the operations performed")
 	}
-
-	return execution, false, nil
+	printHashCacheWarning(info.hashCacheErr)
 }
 
 func runWorkersFileCommand[T any](
@@ -166,6 +232,30 @@ func printDetailedOperations[T any](operations []T, printOperation func(T), isEr
 	}
 }
 
+// printInterrupted tells the user that the command was stopped by a signal
+// and that what follows only covers the work done before.
+func printInterrupted(detail string) {
+	fmt.Printf("INTERRUPTED: stopped before all operations ran; %s\n", detail)
+	fmt.Println()
+}
+
+// printFailed reports a command that stopped on an error after performing
+// some of its operations.
+func printFailed(detail string) {
+	fmt.Printf("FAILED: stopped on an error before all operations ran; %s\n", detail)
+	fmt.Println()
+}
+
+// printHashCacheWarning reports a hash cache that could not be saved. The
+// command still succeeded; only the next run is slower.
+func printHashCacheWarning(err error) {
+	if err == nil {
+		return
+	}
+	fmt.Printf("WARNING: %v; the next run hashes files again\n", err)
+	fmt.Println()
+}
+
 func printDryRunHint() {
 	if !dryRun {
 		return
@@ -175,6 +265,43 @@ func printDryRunHint() {
 	fmt.Println("Run without --dry-run to apply changes.")
 }
 
+// parseSize parses a byte count such as "500M", "10G" or "4096". Suffixes
+// K, M, G and T (optionally followed by "B" or "iB") are powers of 1024, to
+// match formatBytes. An empty string means zero.
+func parseSize(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	upper := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "IB"), "B")
+	multiplier := int64(1)
+	if upper != "" {
+		switch upper[len(upper)-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		case 'T':
+			multiplier = 1 << 40
+		}
+		if multiplier > 1 {
+			upper = upper[:len(upper)-1]
+		}
+	}
+
+	n, err := strconv.ParseInt(upper, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	if n > math.MaxInt64/multiplier {
+		return 0, fmt.Errorf("size %q is too large", s)
+	}
+
+	return n * multiplier, nil
+}
+
 func formatBytes(bytes int64) string {
 	const (
 		KB = 1024
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// captureStdout returns what run prints to standard output.
+func captureStdout(t *testing.T, run func()) string {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() {
+		os.Stdout = stdout
+	}()
+
+	output := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		output <- string(data)
+	}()
+
+	run()
+	require.NoError(t, w.Close())
+	return <-output
+}
+
+func TestRunFileCommand_FailurePrintsJournal(t *testing.T) {
+	errFailed := errors.New("archive failed")
+	info := fileCommandExecutionInfo{rootDir: "/backup", fileCount: 3, journalPath: "/backup/.btidy/journal/run.jsonl"}
+	run := func(info fileCommandExecutionInfo) (string, error) {
+		var err error
+		output := captureStdout(t, func() {
+			_, _, err = runFileCommand(
+				"UNZIP",
+				false,
+				func(*progressReporter) (fileCommandExecutionInfo, error) { return info, errFailed },
+				func(info fileCommandExecutionInfo) fileCommandExecutionInfo { return info },
+				nil,
+			)
+		})
+		return output, err
+	}
+
+	t.Run("partial run shows the journal to undo", func(t *testing.T) {
+		output, err := run(info)
+		require.ErrorIs(t, err, errFailed)
+
+		assert.Contains(t, output, "Command: UNZIP")
+		assert.Contains(t, output, "Journal: /backup/.btidy/journal/run.jsonl")
+		assert.Contains(t, output, "FAILED: stopped on an error")
+		assert.NotContains(t, output, "INTERRUPTED")
+	})
+
+	t.Run("interrupted run is reported as interrupted", func(t *testing.T) {
+		interrupted := info
+		interrupted.interrupted = true
+		output, err := run(interrupted)
+		require.ErrorIs(t, err, errFailed)
+
+		assert.Contains(t, output, "Journal: /backup/.btidy/journal/run.jsonl")
+		assert.Contains(t, output, "INTERRUPTED")
+		assert.NotContains(t, output, "FAILED")
+	})
+
+	t.Run("run without a journal prints no header", func(t *testing.T) {
+		output, err := run(fileCommandExecutionInfo{})
+		require.ErrorIs(t, err, errFailed)
+
+		assert.NotContains(t, output, "Command:")
+		assert.NotContains(t, output, "FAILED")
+	})
+}
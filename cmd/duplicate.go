@@ -9,41 +9,102 @@ import (
 	"btidy/pkg/usecase"
 )
 
+var (
+	duplicateLink    string
+	duplicateAgainst string
+	duplicateDirs    bool
+)
+
 func buildDuplicateCommand() *cobra.Command {
-	return &cobra.Command{
+	cmd := &cobra.Command{
 		Use:   "duplicate [path]",
 		Short: "Find and remove duplicate files by content hash",
 		Long: `Finds and removes duplicate files using content hashing:
   - Groups files by size (fast pre-filter)
-  - Computes SHA256 hash to identify true duplicates
+  - Computes an XXH128 hash to identify true duplicates (--hash-algo=sha256
+    or blake3 for a cryptographic hash)
   - Uses partial hashing for large files (performance optimization)
   - Keeps one copy, removes the rest
+  - With --link=hard or --link=reflink, replaces each duplicate with a hard
+    link or a copy-on-write clone (btrfs, XFS) of the kept copy instead, so
+    every path keeps existing; the duplicate goes to trash, and undo
+    restores it as an independent file. Duplicates on another device than
+    the kept copy, or on a filesystem without reflinks, are skipped
+  - With --against, removes only files whose content is already in a
+    reference directory, or in a manifest made by 'btidy manifest'; the
+    reference is never touched, and files that only duplicate each other
+    are left alone
+  - With --dirs, first finds whole directories whose content is identical,
+    by a hash built from the files they hold, and removes every copy but
+    one as a unit, largest first, instead of leaving an empty skeleton;
+    undo restores the directory as a whole. A directory is only removed
+    when everything in it, files btidy otherwise skips included, matches
+    the kept copy
+
+Which copy is kept is decided, in order, by:
+  - --prefer-dir globs: copies under an earlier matching directory win
+  - --avoid-dir globs: copies under a matching directory lose
+  - --keep: oldest, newest, shortest-path, deepest-path or first-lexical
+    (default)
+  - the path in lexical order, so the choice is always the same
+A glob without a "/" matches any directory name; one with a "/" matches a
+directory path relative to the target. --verbose shows why each copy was
+kept.
 
-This is safe and reliable - files are only considered duplicates
-if their content is byte-for-byte identical (verified by SHA256).
+This is safe and reliable - files are only removed if their content is
+byte-for-byte identical (compared byte by byte after an XXH128 match,
+or verified by the cryptographic hash selected with --hash-algo).
 
 Examples:
   btidy duplicate --dry-run ./backup   # Preview (recommended!)
   btidy duplicate ./backup             # Apply changes
   btidy duplicate -v ./backup          # Verbose output
+  btidy duplicate --keep=oldest --prefer-dir=Photos --avoid-dir='*copy*' ./backup
+  btidy duplicate --link=hard ./backup # Keep every path, share the data
+  btidy duplicate --against=/mnt/archive ./backup  # Drop what is already archived
+  btidy duplicate --dirs ./backup      # Remove identical directories whole
 
 Use --dry-run first to review what would be deleted!`,
 		Args: cobra.ExactArgs(1),
 		RunE: runDuplicate,
 	}
+
+	addKeepFlags(cmd)
+	cmd.Flags().StringVar(&duplicateLink, "link", "",
+		"Replace duplicates with links to the kept copy instead of removing them: hard or reflink")
+	cmd.Flags().StringVar(&duplicateAgainst, "against", "",
+		"Remove only files already present in this reference directory or manifest file")
+	cmd.Flags().BoolVar(&duplicateDirs, "dirs", false,
+		"Remove directories identical to another one as a unit before single files")
+
+	return cmd
 }
 
-func runDuplicate(_ *cobra.Command, args []string) error {
+func runDuplicate(cmd *cobra.Command, args []string) error {
+	keep, err := keepPolicy()
+	if err != nil {
+		return err
+	}
+
+	link, err := deduplicator.ParseLinkMode(duplicateLink)
+	if err != nil {
+		return err
+	}
+
 	execution, empty, err := runWorkersFileCommand(
 		"DUPLICATE",
 		false,
 		args[0],
 		func(targetDir string, isDryRun bool, workerCount int, onProgress usecase.ProgressCallback) (usecase.DuplicateExecution, error) {
-			return newUseCaseService().RunDuplicate(usecase.DuplicateRequest{
-				TargetDir:  targetDir,
-				DryRun:     isDryRun,
-				Workers:    workerCount,
-				OnProgress: onProgress,
+			return newUseCaseService().RunDuplicate(cmd.Context(), usecase.DuplicateRequest{
+				TargetDir:   targetDir,
+				DryRun:      isDryRun,
+				Workers:     workerCount,
+				Keep:        keep,
+				Link:        link,
+				Against:     duplicateAgainst,
+				Directories: duplicateDirs,
+				OnProgress:  onProgress,
 			})
 		},
 		func(execution usecase.DuplicateExecution) fileCommandExecutionInfo {
@@ -61,18 +122,36 @@ func runDuplicate(_ *cobra.Command, args []string) error {
 
 	result := execution.Result
 
+	if duplicateDirs {
+		printDetailedOperations(result.DirOperations, printDuplicateDirectory, func(op deduplicator.DirectoryOperation) bool {
+			return op.Error != nil
+		})
+	}
 	printDetailedOperations(result.Operations, printDuplicateOperation, func(op deduplicator.DeleteOperation) bool {
 		return op.Error != nil
 	})
 
-	printSummary(
+	lines := []string{
 		fmt.Sprintf("Total files:      %d", result.TotalFiles),
 		fmt.Sprintf("Duplicates found: %d", result.DuplicatesFound),
 		fmt.Sprintf("Deleted:          %d", result.DeletedCount),
+	}
+	if link != deduplicator.LinkNone {
+		lines = append(lines, fmt.Sprintf("Linked:           %d", result.LinkedCount))
+	}
+	if duplicateDirs {
+		lines = append(lines,
+			fmt.Sprintf("Duplicate dirs:   %d", result.DirDuplicatesFound),
+			fmt.Sprintf("Dirs deleted:     %d", result.DirsDeletedCount),
+		)
+	}
+	lines = append(lines,
 		fmt.Sprintf("Skipped:          %d", result.SkippedCount),
 		fmt.Sprintf("Errors:           %d", result.ErrorCount),
 		"Space recovered:  "+formatBytes(result.BytesRecovered),
 	)
+
+	printSummary(lines...)
 	printDryRunHint()
 
 	return nil
@@ -84,10 +163,34 @@ func printDuplicateOperation(op deduplicator.DeleteOperation) {
 		fmt.Printf("ERROR: %s: %v\n", op.Path, op.Error)
 	case op.Skipped:
 		fmt.Printf("SKIP: %s (%s)\n", op.Path, op.SkipReason)
+	case op.Link != deduplicator.LinkNone:
+		fmt.Printf("LINK (%s): %s\n", op.Link, op.Path)
+		fmt.Printf("   KEPT: %s\n", op.OriginalOf)
+		if verbose {
+			fmt.Printf("   WHY:  %s\n", op.KeepReason)
+			fmt.Printf("   HASH: %s\n", op.Hash)
+		}
 	default:
 		fmt.Printf("DELETE: %s\n", op.Path)
 		fmt.Printf("   KEPT: %s\n", op.OriginalOf)
 		if verbose {
+			fmt.Printf("   WHY:  %s\n", op.KeepReason)
+			fmt.Printf("   HASH: %s\n", op.Hash)
+		}
+	}
+}
+
+func printDuplicateDirectory(op deduplicator.DirectoryOperation) {
+	switch {
+	case op.Error != nil:
+		fmt.Printf("ERROR: %s: %v\n", op.Path, op.Error)
+	case op.Skipped:
+		fmt.Printf("SKIP DIR: %s (%s)\n", op.Path, op.SkipReason)
+	default:
+		fmt.Printf("DELETE DIR: %s (%d files, %s)\n", op.Path, op.Files, formatBytes(op.Size))
+		fmt.Printf("   KEPT: %s\n", op.OriginalOf)
+		if verbose {
+			fmt.Printf("   WHY:  %s\n", op.KeepReason)
 			fmt.Printf("   HASH: %s\n", op.Hash)
 		}
 	}
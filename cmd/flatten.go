@@ -10,11 +10,13 @@ import (
 )
 
 func buildFlattenCommand() *cobra.Command {
-	return &cobra.Command{
+	cmd := &cobra.Command{
 		Use:   "flatten [path]",
 		Short: "Move all files to root directory, remove duplicates",
 		Long: `Moves all files to root directory:
-  - Removes true duplicates (same content hash)
+  - Removes true duplicates (same content hash), keeping a copy already
+    in the root, or else the one chosen by --keep, --prefer-dir and
+    --avoid-dir as for the duplicate command
   - Adds suffix for name conflicts
   - Deletes empty directories
 
@@ -22,6 +24,7 @@ Examples:
   btidy flatten --dry-run ./backup   # Preview changes
   btidy flatten ./backup             # Apply changes
   btidy flatten -v ./backup          # Verbose output
+  btidy flatten --keep=newest ./backup
 
 Before:
   backup/
@@ -37,18 +40,28 @@ After:
 		Args: cobra.ExactArgs(1),
 		RunE: runFlatten,
 	}
+
+	addKeepFlags(cmd)
+
+	return cmd
 }
 
-func runFlatten(_ *cobra.Command, args []string) error {
+func runFlatten(cmd *cobra.Command, args []string) error {
+	keep, err := keepPolicy()
+	if err != nil {
+		return err
+	}
+
 	execution, empty, err := runWorkersFileCommand(
 		"FLATTEN",
 		true,
 		args[0],
 		func(targetDir string, isDryRun bool, workerCount int, onProgress usecase.ProgressCallback) (usecase.FlattenExecution, error) {
-			return newUseCaseService().RunFlatten(usecase.FlattenRequest{
+			return newUseCaseService().RunFlatten(cmd.Context(), usecase.FlattenRequest{
 				TargetDir:  targetDir,
 				DryRun:     isDryRun,
 				Workers:    workerCount,
+				Keep:       keep,
 				OnProgress: onProgress,
 			})
 		},
@@ -93,6 +106,9 @@ func printFlattenOperation(op flattener.MoveOperation) {
 	case op.Duplicate:
 		fmt.Printf("DUPLICATE: %s\n", op.OriginalPath)
 		fmt.Printf("   KEPT: %s\n", op.NewPath)
+		if verbose && op.KeepReason != "" {
+			fmt.Printf("   WHY:  %s\n", op.KeepReason)
+		}
 	case op.Skipped:
 		fmt.Printf("SKIP: %s (%s)\n", op.OriginalPath, op.SkipReason)
 	default:
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/spf13/cobra"
+
+	"btidy/pkg/unzipper"
+	"btidy/pkg/usecase"
+)
+
+// lsArchiveOptions holds the ls-archive flags.
+type lsArchiveOptions struct {
+	jsonOutput   bool
+	find         string
+	encoding     string
+	passwordFile string
+}
+
+func buildLsArchiveCommand() *cobra.Command {
+	var opts lsArchiveOptions
+
+	cmd := &cobra.Command{
+		Use:   "ls-archive [archive|path]",
+		Short: "List archive contents without extracting",
+		Long: `Lists the entries of an archive, or of every archive under a directory,
+without extracting or changing anything:
+  - Prints each entry's path, size, compressed size, method, modification
+    time and CRC-32, as far as the archive format records them
+  - Reads zip, 7z and tar archives (plain or wrapped in gzip, bzip2, xz or
+    zstd), detected by content
+  - Descends into nested archives in memory, up to 256 MiB each and 8
+    levels deep; a nested entry is shown as outer.zip!inner.tar!path
+  - With --find, prints only entries whose name matches a glob; a pattern
+    with a "/" is matched against the whole path inside the archive
+  - With --json, prints one JSON object per entry and line
+
+Examples:
+  btidy ls-archive ./backup/photos-2019.zip
+  btidy ls-archive --find '*.pdf' ./backup          # Every PDF in every archive
+  btidy ls-archive --find 'tax/2019/*' ./backup     # Match the whole member path
+  btidy ls-archive --json ./backup | jq -r .path`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runLsArchive(cmd.Context(), args, opts)
+		},
+	}
+
+	cmd.Flags().BoolVar(&opts.jsonOutput, "json", false, "Print one JSON object per entry instead of a table")
+	cmd.Flags().StringVar(&opts.find, "find", "", "Only list entries whose name (or path, when the pattern has a /) matches this glob")
+	cmd.Flags().StringVar(&opts.encoding, "zip-encoding", string(unzipper.NameEncodingCP437),
+		"Code page for zip entry names without the UTF-8 flag: cp437, cp1252 or shift-jis")
+	cmd.Flags().StringVar(&opts.passwordFile, "password-file", "",
+		"File with one password per line, used to open encrypted nested archives")
+
+	return cmd
+}
+
+func runLsArchive(ctx context.Context, args []string, opts lsArchiveOptions) error {
+	nameEncoding, err := unzipper.ParseNameEncoding(opts.encoding)
+	if err != nil {
+		return err
+	}
+
+	passwords, err := readPasswordFile(opts.passwordFile)
+	if err != nil {
+		return err
+	}
+
+	printEntry := printListedEntry
+	if opts.jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		printEntry = func(entry unzipper.ListedEntry) error {
+			return encoder.Encode(entry)
+		}
+	} else {
+		fmt.Printf("%12s %12s  %-16s %-19s  %-8s  %s\n", "SIZE", "COMPRESSED", "METHOD", "MODIFIED", "CRC32", "PATH")
+	}
+
+	execution, err := newUseCaseService().RunListArchive(ctx, usecase.ListArchiveRequest{
+		Path:         args[0],
+		Find:         opts.find,
+		NameEncoding: nameEncoding,
+		Passwords:    passwords,
+		OnEntry:      printEntry,
+	})
+	if err != nil {
+		return err
+	}
+
+	result := execution.Result
+	for _, listErr := range result.Errors {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", listErr)
+	}
+
+	if !opts.jsonOutput {
+		fmt.Println()
+		printSummary(
+			fmt.Sprintf("Archives Read:   %d", result.Archives),
+			fmt.Sprintf("Entries:         %d", result.Entries),
+			fmt.Sprintf("Entries Listed:  %d", execution.Matched),
+			fmt.Sprintf("Errors:          %d", len(result.Errors)),
+		)
+	}
+
+	if len(result.Errors) > 0 {
+		return fmt.Errorf("%d archives could not be read in full", len(result.Errors))
+	}
+
+	return nil
+}
+
+// printListedEntry prints entry as one row of the ls-archive table.
+// Values an archive does not record are shown as "-".
+func printListedEntry(entry unzipper.ListedEntry) error {
+	size, compressed := "-", "-"
+	if entry.Type == "file" {
+		size = strconv.FormatInt(entry.Size, 10)
+	}
+	if entry.CompressedSize > 0 {
+		compressed = strconv.FormatInt(entry.CompressedSize, 10)
+	}
+
+	modified := "-"
+	if !entry.Modified.IsZero() {
+		modified = entry.Modified.Local().Format("2006-01-02 15:04:05")
+	}
+
+	location := entry.Location()
+	switch entry.Type {
+	case "dir":
+		location += "/"
+	case "symlink", "hardlink":
+		location += " -> " + entry.LinkTarget
+	}
+	if entry.NotListed != "" {
+		location += " (not listed: " + entry.NotListed + ")"
+	}
+
+	fmt.Printf("%12s %12s  %-16s %-19s  %-8s  %s\n",
+		size, compressed, orDash(entry.Method), modified, orDash(entry.CRC32), location)
+
+	return nil
+}
+
+// orDash returns s, or "-" when s is empty.
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
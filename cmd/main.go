@@ -1,6 +1,16 @@
 package main
 
-import "os"
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// exitInterrupted is the exit status after a run was stopped by a signal,
+// as shells report for processes killed by SIGINT.
+const exitInterrupted = 130
 
 func main() {
 	rootCmd := buildRootCommand()
@@ -8,12 +18,29 @@ func main() {
 	rootCmd.AddCommand(buildRenameCommand())
 	rootCmd.AddCommand(buildFlattenCommand())
 	rootCmd.AddCommand(buildDuplicateCommand())
+	rootCmd.AddCommand(buildSimilarCommand())
 	rootCmd.AddCommand(buildManifestCommand())
+	rootCmd.AddCommand(buildLsArchiveCommand())
 	rootCmd.AddCommand(buildOrganizeCommand())
 	rootCmd.AddCommand(buildUndoCommand())
 	rootCmd.AddCommand(buildPurgeCommand())
+	rootCmd.AddCommand(buildCacheCommand())
+
+	// The first Ctrl-C cancels the context: workflows finish the operation
+	// in flight, write their journal and summary, and return. The default
+	// handling is then restored, so a second Ctrl-C quits at once.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+		fmt.Fprintln(os.Stderr, "\ninterrupted: finishing the operation in progress (press Ctrl-C again to quit now)")
+	}()
 
-	if err := rootCmd.Execute(); err != nil {
+	err := rootCmd.ExecuteContext(ctx)
+	if ctx.Err() != nil {
+		os.Exit(exitInterrupted)
+	}
+	if err != nil {
 		os.Exit(1)
 	}
 }
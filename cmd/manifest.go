@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -15,7 +16,9 @@ func buildManifestCommand() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "manifest [path]",
 		Short: "Create a cryptographic inventory of all files",
-		Long: `Creates a manifest (JSON file) containing SHA256 hashes of all files.
+		Long: `Creates a manifest (JSON file) containing SHA256 hashes of all files,
+or BLAKE3 hashes with --hash-algo=blake3. BLAKE3 hashes are recorded as
+"blake3:<hex>"; undo and duplicate --against read either kind.
 
 Safety:
   - All manifest reads are contained within the target directory
@@ -31,6 +34,7 @@ Examples:
   btidy manifest ./backup -o inventory.json
   btidy manifest /path/to/photos -o before.json
   btidy manifest --workers 8 ./backup -o manifest.json
+  btidy manifest --hash-algo=blake3 ./backup -o manifest.json
 
 Typical safe workflow:
   1. btidy manifest /backup -o before.json
@@ -38,8 +42,8 @@ Typical safe workflow:
   3. btidy manifest /backup -o after.json
   4. compare hashes between manifests`,
 		Args: cobra.ExactArgs(1),
-		RunE: func(_ *cobra.Command, args []string) error {
-			return runManifest(args, outputPath)
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runManifest(cmd.Context(), args, outputPath)
 		},
 	}
 
@@ -48,11 +52,11 @@ Typical safe workflow:
 	return cmd
 }
 
-func runManifest(args []string, outputPath string) error {
+func runManifest(ctx context.Context, args []string, outputPath string) error {
 	progress := startProgress("collecting")
 	fmt.Println("Collecting files and computing hashes...")
 
-	execution, err := newUseCaseService().RunManifest(usecase.ManifestRequest{
+	execution, err := newUseCaseService().RunManifest(ctx, usecase.ManifestRequest{
 		TargetDir:  args[0],
 		OutputPath: outputPath,
 		Workers:    workers,
@@ -71,6 +75,7 @@ func runManifest(args []string, outputPath string) error {
 
 	fmt.Printf("\nCompleted in %v\n", execution.Duration.Round(time.Millisecond))
 	fmt.Println()
+	printHashCacheWarning(execution.HashCacheErr)
 	printSummary(
 		fmt.Sprintf("Total files:    %d", execution.Manifest.FileCount()),
 		fmt.Sprintf("Unique files:   %d", execution.Manifest.UniqueFileCount()),
@@ -42,12 +42,12 @@ After:
 	}
 }
 
-func runOrganize(_ *cobra.Command, args []string) error {
+func runOrganize(cmd *cobra.Command, args []string) error {
 	execution, empty, err := runFileCommand(
 		"ORGANIZE",
 		true,
 		func(progress *progressReporter) (usecase.OrganizeExecution, error) {
-			return newUseCaseService().RunOrganize(usecase.OrganizeRequest{
+			return newUseCaseService().RunOrganize(cmd.Context(), usecase.OrganizeRequest{
 				TargetDir: args[0],
 				DryRun:    dryRun,
 				OnProgress: func(stage string, processed, total int) {
@@ -50,7 +50,7 @@ Examples:
 	return cmd
 }
 
-func runPurge(_ *cobra.Command, args []string) error {
+func runPurge(cmd *cobra.Command, args []string) error {
 	olderThan, parseErr := parseOlderThan(purgeOlderStr)
 	if parseErr != nil {
 		return parseErr
@@ -70,7 +70,7 @@ func runPurge(_ *cobra.Command, args []string) error {
 
 	prog := startProgress("purging")
 
-	execution, err := newUseCaseService().RunPurge(usecase.PurgeRequest{
+	execution, err := newUseCaseService().RunPurge(cmd.Context(), usecase.PurgeRequest{
 		TargetDir: args[0],
 		RunID:     purgeRunID,
 		OlderThan: olderThan,
@@ -95,6 +95,9 @@ func runPurge(_ *cobra.Command, args []string) error {
 	}
 
 	printTrashRuns(execution)
+	if execution.Interrupted {
+		printInterrupted("the remaining runs are still in trash")
+	}
 	printPurgeOperations(execution)
 
 	printSummary(
@@ -31,12 +31,12 @@ After:  "2018-06-15_my_document.pdf"`,
 	}
 }
 
-func runRename(_ *cobra.Command, args []string) error {
+func runRename(cmd *cobra.Command, args []string) error {
 	execution, empty, err := runFileCommand(
 		"RENAME",
 		true,
 		func(progress *progressReporter) (usecase.RenameExecution, error) {
-			return newUseCaseService().RunRename(usecase.RenameRequest{
+			return newUseCaseService().RunRename(cmd.Context(), usecase.RenameRequest{
 				TargetDir: args[0],
 				DryRun:    dryRun,
 				OnProgress: func(stage string, processed, total int) {
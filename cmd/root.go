@@ -4,6 +4,8 @@ import (
 	"runtime"
 
 	"github.com/spf13/cobra"
+
+	"btidy/pkg/hasher"
 )
 
 // version is set at build time via -ldflags.
@@ -14,6 +16,8 @@ var (
 	verbose    bool
 	workers    int
 	noSnapshot bool
+	noCache    bool
+	hashAlgo   string
 )
 
 func buildRootCommand() *cobra.Command {
@@ -21,18 +25,25 @@ func buildRootCommand() *cobra.Command {
 		Use:     "btidy",
 		Version: version,
 		Short:   "Organize backup files by unzipping, renaming, and flattening directory structures",
+		PersistentPreRunE: func(_ *cobra.Command, _ []string) error {
+			_, err := hasher.ParseAlgorithm(hashAlgo)
+			return err
+		},
 		Long: `btidy helps clean up backup directories. Every destructive operation is
 reversible through soft-delete, journaling, and undo.
 
 Commands:
-  unzip      Extracts zip archives recursively and removes extracted archives
+  unzip      Extracts zip, 7z and tar archives recursively and removes extracted archives
   rename     Renames files in place with consistent naming
   flatten    Moves all files to root directory, removes duplicates by content hash
   organize   Groups files into subdirectories by file extension
   duplicate  Finds and removes duplicate files by content hash
+  similar    Finds images that show the same picture by perceptual hash
   manifest   Creates a cryptographic inventory of all files
+  ls-archive Lists archive contents, nested archives included, without extracting
   undo       Reverses the most recent operation using its journal
   purge      Permanently deletes trashed files (only irrecoverable command)
+  cache      Maintains the hash cache in .btidy/hashcache
 
 Examples:
   # Typical workflow: unzip, rename, flatten, organize, deduplicate
@@ -57,6 +68,12 @@ Examples:
   # Skip pre-operation manifest snapshot
   btidy flatten --no-snapshot /path/to/backup
 
+  # Hash every file again instead of trusting the hash cache
+  btidy duplicate --no-cache /path/to/backup
+
+  # Group duplicates by SHA-256 instead of the faster XXH128
+  btidy duplicate --hash-algo=sha256 /path/to/backup
+
   # Manual manifest workflow
   btidy manifest /backup -o before.json
   btidy flatten /backup
@@ -67,18 +84,27 @@ Safety:
   Every mutation is journaled to .btidy/journal/ for undo support.
   A manifest snapshot is saved to .btidy/manifests/ before each operation.
   Advisory file locking prevents concurrent btidy processes.
+  Manifests and snapshots use SHA-256, or BLAKE3 with --hash-algo=blake3.
+  File hashes are cached in .btidy/hashcache and reused while a file's
+  device, inode, size, modification and change times are unchanged.
 
 Compression:
-  ZIP methods store (0) and deflate (8) are supported.
-  Deflate64 (method 9) archives are currently skipped.
+  ZIP methods store (0), deflate (8) and deflate64 (9) are supported.
+  Archives using any other method are skipped and left in place.
+  Tar archives may be uncompressed or wrapped in gzip, bzip2, xz or zstd.
+  7z archives may use LZMA, LZMA2, Deflate, Deflate64, BZip2 or stored,
+  optionally with 7zAES; archives using other methods are left in place.
 
   The tool will NEVER modify files outside the specified directory.`,
 	}
 
 	cmd.PersistentFlags().BoolVar(&dryRun, "dry-run", false, "Show what would be done without making changes")
 	cmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Verbose output")
-	cmd.PersistentFlags().IntVar(&workers, "workers", runtime.NumCPU(), "Number of parallel workers for hashing")
+	cmd.PersistentFlags().IntVar(&workers, "workers", runtime.NumCPU(), "Number of parallel workers for hashing and archive extraction")
 	cmd.PersistentFlags().BoolVar(&noSnapshot, "no-snapshot", false, "Skip pre-operation manifest snapshot")
+	cmd.PersistentFlags().StringVar(&hashAlgo, "hash-algo", "",
+		"Hash algorithm: sha256, blake3 or xxh128 (default xxh128 to find duplicates, sha256 for manifests)")
+	cmd.PersistentFlags().BoolVar(&noCache, "no-cache", false, "Hash every file instead of reusing hashes cached in .btidy/hashcache")
 
 	return cmd
 }
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"btidy/pkg/similarity"
+	"btidy/pkg/usecase"
+)
+
+var (
+	similarTrash    bool
+	similarHash     string
+	similarDistance int
+)
+
+func buildSimilarCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "similar [path]",
+		Short: "Find images that show the same picture by perceptual hash",
+		Long: `Finds near-duplicate images that content hashing misses, such as a photo
+re-encoded at another JPEG quality or saved at another resolution:
+  - Decodes every JPEG, PNG and GIF and computes a 64-bit perceptual hash
+    (--hash=phash, the default, or the faster --hash=dhash)
+  - Groups images whose hash is within --distance bits of the image with
+    the highest resolution, which is suggested to keep (then the largest
+    file, then the first path in lexical order)
+  - Only reports the groups by default; with --trash, moves every image of
+    a group but the kept one to trash, journaled for undo
+
+Perceptual hashes can match different pictures that look alike, such as
+burst shots. Lower --distance to be stricter, and review the report before
+using --trash.
+
+Examples:
+  btidy similar ./photos                   # Report groups of similar images
+  btidy similar --distance=4 ./photos      # Stricter matching
+  btidy similar --trash --dry-run ./photos # Preview what would be trashed
+  btidy similar --trash ./photos           # Trash all but the kept image`,
+		Args: cobra.ExactArgs(1),
+		RunE: runSimilar,
+	}
+
+	cmd.Flags().BoolVar(&similarTrash, "trash", false,
+		"Move every similar image but the kept one to trash instead of only reporting")
+	cmd.Flags().StringVar(&similarHash, "hash", string(similarity.AlgorithmPHash),
+		"Perceptual hash: phash or dhash")
+	cmd.Flags().IntVar(&similarDistance, "distance", similarity.DefaultMaxDistance,
+		"Maximum number of differing hash bits (0-64) for images to be similar")
+
+	return cmd
+}
+
+func runSimilar(cmd *cobra.Command, args []string) error {
+	algo, err := similarity.ParseAlgorithm(similarHash)
+	if err != nil {
+		return err
+	}
+
+	execution, empty, err := runWorkersFileCommand(
+		"SIMILAR",
+		false,
+		args[0],
+		func(targetDir string, isDryRun bool, workerCount int, onProgress usecase.ProgressCallback) (usecase.SimilarExecution, error) {
+			return newUseCaseService().RunSimilar(cmd.Context(), usecase.SimilarRequest{
+				TargetDir:   targetDir,
+				DryRun:      isDryRun,
+				Workers:     workerCount,
+				Trash:       similarTrash,
+				Algorithm:   algo,
+				MaxDistance: similarDistance,
+				OnProgress:  onProgress,
+			})
+		},
+		func(execution usecase.SimilarExecution) fileCommandExecutionInfo {
+			return infoFromMeta(execution.Meta())
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if empty {
+		return nil
+	}
+
+	fmt.Println("Hashing images and comparing them...")
+
+	result := execution.Result
+	for _, group := range result.Groups {
+		printSimilarGroup(group)
+	}
+	if verbose {
+		for _, failed := range result.Failed {
+			fmt.Printf("UNREADABLE: %s (%v)\n", failed.Path, failed.Error)
+		}
+	}
+	fmt.Println()
+
+	if similarTrash {
+		printDetailedOperations(result.Operations, printSimilarOperation, func(op similarity.TrashOperation) bool {
+			return op.Error != nil
+		})
+	}
+
+	lines := []string{
+		fmt.Sprintf("Total files:     %d", result.TotalFiles),
+		fmt.Sprintf("Images hashed:   %d", result.ImagesHashed),
+		fmt.Sprintf("Unreadable:      %d", len(result.Failed)),
+		fmt.Sprintf("Groups:          %d", len(result.Groups)),
+		fmt.Sprintf("Similar images:  %d", result.SimilarFound),
+	}
+	if similarTrash {
+		lines = append(lines,
+			fmt.Sprintf("Trashed:         %d", result.TrashedCount),
+			fmt.Sprintf("Errors:          %d", result.ErrorCount),
+			"Space recovered: "+formatBytes(result.BytesRecovered),
+		)
+	}
+
+	printSummary(lines...)
+	if !similarTrash && len(result.Groups) > 0 {
+		fmt.Println()
+		fmt.Println("Run with --trash to move the similar images to trash.")
+	}
+	printDryRunHint()
+
+	return nil
+}
+
+func printSimilarGroup(group similarity.Group) {
+	fmt.Printf("KEEP: %s (%dx%d, %s)\n", group.Keep.Path, group.Keep.Width, group.Keep.Height, formatBytes(group.Keep.Size))
+	if verbose {
+		fmt.Printf("   WHY:  %s\n", group.KeepReason)
+	}
+	for _, match := range group.Similar {
+		fmt.Printf("   SIMILAR: %s (%dx%d, %s, distance %d)\n",
+			match.Path, match.Width, match.Height, formatBytes(match.Size), match.Distance)
+	}
+}
+
+func printSimilarOperation(op similarity.TrashOperation) {
+	if op.Error != nil {
+		fmt.Printf("ERROR: %s: %v\n", op.Path, op.Error)
+		return
+	}
+	fmt.Printf("TRASH: %s\n", op.Path)
+	if verbose {
+		fmt.Printf("   KEPT: %s\n", op.KeptPath)
+	}
+}
@@ -17,7 +17,10 @@ func buildUndoCommand() *cobra.Command {
 		Long: `Reverses the most recent btidy operation by replaying its journal in reverse:
   - Trashed files are restored to their original locations
   - Renamed files are moved back to their original names
-  - Extract operations are skipped (archive is restored via its trash entry)
+  - Files created by --decompress are removed if unchanged
+  - Extracted files are removed if unchanged, or moved to trash if edited
+    since the unzip; directories created by the unzip are removed when empty,
+    and the archive is restored from trash
 
 The journal is marked as rolled back after a successful undo, preventing
 it from being undone again.
@@ -35,12 +38,12 @@ Examples:
 	return cmd
 }
 
-func runUndo(_ *cobra.Command, args []string) error {
+func runUndo(cmd *cobra.Command, args []string) error {
 	printDryRunBanner()
 
 	progress := startProgress("undoing")
 
-	execution, err := newUseCaseService().RunUndo(usecase.UndoRequest{
+	execution, err := newUseCaseService().RunUndo(cmd.Context(), usecase.UndoRequest{
 		TargetDir: args[0],
 		RunID:     undoRunID,
 		DryRun:    dryRun,
@@ -58,6 +61,9 @@ func runUndo(_ *cobra.Command, args []string) error {
 	fmt.Printf("Journal: %s\n", execution.JournalPath)
 	fmt.Printf("Run ID:  %s\n", execution.RunID)
 	fmt.Println()
+	if execution.Interrupted {
+		printInterrupted("run undo again to reverse the rest")
+	}
 
 	printDetailedOperations(execution.Operations, printUndoOperation, func(op usecase.UndoOperation) bool {
 		return op.Error != nil
@@ -66,6 +72,8 @@ func runUndo(_ *cobra.Command, args []string) error {
 	printSummary(
 		fmt.Sprintf("Restored:  %d", execution.RestoredCount),
 		fmt.Sprintf("Reversed:  %d", execution.ReversedCount),
+		fmt.Sprintf("Removed:   %d", execution.RemovedCount),
+		fmt.Sprintf("Trashed:   %d", execution.TrashedCount),
 		fmt.Sprintf("Skipped:   %d", execution.SkippedCount),
 		fmt.Sprintf("Errors:    %d", execution.ErrorCount),
 	)
@@ -86,5 +94,11 @@ func printUndoOperation(op usecase.UndoOperation) {
 	case op.Action == "reverse-rename":
 		fmt.Printf("REVERSE: %s\n", op.Dest)
 		fmt.Printf("     TO: %s\n", op.Source)
+	case op.Action == "remove" && op.EntryType == "decompress":
+		fmt.Printf("REMOVE: %s\n", op.Dest)
+	case op.Action == "remove":
+		fmt.Printf("REMOVE: %s\n", op.Source)
+	case op.Action == "trash":
+		fmt.Printf("TRASH: %s (changed since extraction)\n", op.Source)
 	}
 }
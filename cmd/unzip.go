@@ -2,6 +2,9 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/spf13/cobra"
 
@@ -9,38 +12,161 @@ import (
 	"btidy/pkg/usecase"
 )
 
+var (
+	unzipDecompress bool
+	unzipInto       string
+	unzipEncoding   string
+	unzipSymlinks   string
+	unzipSalvage    bool
+	unzipPasswords  string
+
+	unzipMaxDepth       int
+	unzipMaxRatio       float64
+	unzipMaxTotalSize   string
+	unzipMaxArchiveSize string
+	unzipSkipSpaceCheck bool
+	unzipInMemorySize   string
+)
+
 func buildUnzipCommand() *cobra.Command {
-	return &cobra.Command{
+	cmd := &cobra.Command{
 		Use:   "unzip [path]",
-		Short: "Extract zip archives recursively and remove extracted archives",
-		Long: `Extracts .zip archives recursively:
-  - Finds all .zip files in the target directory tree
-  - Extracts archive contents in place (next to each archive)
-  - Recursively extracts nested zip archives
+		Short: "Extract zip, 7z and tar archives recursively and remove extracted archives",
+		Long: `Extracts .zip, .7z and tar archives recursively:
+  - Finds all zip, 7z and tar archives (.tar, .tar.gz/.tgz, .tar.bz2,
+    .tar.xz, .tar.zst) in the target directory tree, detected by content
+  - Extracts archive contents in place (next to each archive), or with
+    --into=archive-name into a sibling directory named after the archive
+    (photos-2019.zip -> photos-2019/), or with --into=fresh into a sibling
+    directory whose name does not exist yet (photos-2019-1/, ...)
+  - Keeps each entry's archived modification time and permission bits, so
+    a later rename dates files by the archive rather than by today
+  - Decodes zip entry names written by legacy tools without the UTF-8 flag
+    using --zip-encoding (default cp437), or the Info-ZIP Unicode Path extra
+    field when the archive carries one
+  - Reads split zip archives (name.z01, name.z02, ..., name.zip) as one
+    archive and removes all volumes together; a set with a missing volume
+    is skipped and kept
+  - Recursively extracts nested archives, extracting up to --workers
+    archives of the same nesting level at once; archives whose target
+    directories overlap never run at the same time
+  - Extracts nested archives up to --in-memory-size (default 64M) straight
+    from memory, so only their files are written; larger ones, and ones
+    that cannot be read in full, are written out and extracted by a later
+    pass
+  - Trashes, without extracting, archives that are byte-identical to one
+    already extracted in the same run
+  - Skips, and keeps, archives that nest deeper than --max-depth, compress
+    more than --max-ratio, exceed --max-archive-size or --max-total-size,
+    or do not fit in the free disk space
+  - With --salvage, scans zip archives whose central directory is damaged
+    or missing (e.g. truncated copies) for local file headers, extracts
+    every entry whose CRC-32 verifies, reports the others, and never
+    removes the archive
+  - Detects zip archives with encrypted entries (traditional PKWARE
+    ZipCrypto or WinZip AES) before writing anything and skips them, or,
+    with --password-file, decrypts them with the first password in the
+    file that verifies; an archive with an entry no password decrypts is
+    skipped with nothing extracted
+  - Reads 7z archives (LZMA, LZMA2, Deflate, Deflate64, BZip2 or stored,
+    optionally 7zAES encrypted, solid or not) in pure Go; archives using
+    other methods (PPMd, BCJ filters, ...) are skipped and kept
   - Removes each archive only after successful extraction
+  - With --decompress, also decompresses standalone .gz, .bz2, .xz and
+    .zst files (e.g. dump.sql.gz -> dump.sql) and trashes the original
 
 Safety:
   - Rejects archive entries that escape the target directory
-  - Rejects symlink archive entries
+  - Handles zip, 7z and tar symlinks per --archive-symlinks: skip (default)
+    extracts none, materialize writes a copy of the linked archive member,
+    create makes real links only when they point inside the target
+    directory; refused links, device nodes and FIFOs are skipped and reported
+  - Materializes tar hard links as copies of the linked member
   - Keeps source archive if extraction fails
 
 Examples:
   btidy unzip --dry-run ./backup     # Preview extraction plan
   btidy unzip ./backup               # Extract archives and remove them
-  btidy unzip -v ./backup            # Verbose operation output`,
+  btidy unzip -v ./backup            # Verbose operation output
+  btidy unzip --decompress ./backup  # Also decompress loose .gz/.bz2/.xz/.zst files
+  btidy unzip --into=archive-name ./backup  # Extract each archive into its own directory
+  btidy unzip --zip-encoding=cp1252 ./backup # Names from Windows ANSI tools
+  btidy unzip --archive-symlinks=materialize ./backup # Copy linked files instead of skipping them
+  btidy unzip --max-total-size=200G ./backup # Stop extracting after 200 GiB
+  btidy unzip --salvage ./backup     # Recover what verifies from truncated zips
+  btidy unzip --password-file=pw.txt ./backup # Decrypt zips and 7z archives with any of the listed passwords`,
 		Args: cobra.ExactArgs(1),
 		RunE: runUnzip,
 	}
+
+	cmd.Flags().BoolVar(&unzipDecompress, "decompress", false, "Also decompress standalone .gz, .bz2, .xz and .zst files in place")
+	cmd.Flags().StringVar(&unzipInto, "into", string(unzipper.LayoutParent), "Extraction layout: parent, archive-name or fresh")
+	cmd.Flags().StringVar(&unzipEncoding, "zip-encoding", string(unzipper.NameEncodingCP437),
+		"Code page for zip entry names without the UTF-8 flag: cp437, cp1252 or shift-jis")
+	cmd.Flags().StringVar(&unzipSymlinks, "archive-symlinks", string(unzipper.SymlinksSkip),
+		"Symlink entries: skip, materialize (copy the linked file) or create (only links inside the target)")
+	cmd.Flags().BoolVar(&unzipSalvage, "salvage", false,
+		"Extract the CRC-verified entries of zip archives with a damaged or missing central directory, keeping the archive")
+	cmd.Flags().StringVar(&unzipPasswords, "password-file", "",
+		"File with one password per line, tried in order on encrypted zip entries and 7z archives")
+	cmd.Flags().IntVar(&unzipMaxDepth, "max-depth", unzipper.DefaultMaxDepth, "Deepest archive nesting level to extract (0 = unlimited)")
+	cmd.Flags().Float64Var(&unzipMaxRatio, "max-ratio", unzipper.DefaultMaxRatio, "Largest compression ratio allowed for an entry of 1 MiB or more (0 = unlimited)")
+	cmd.Flags().StringVar(&unzipMaxTotalSize, "max-total-size", "", "Most bytes to extract in one run, e.g. 500G (empty = unlimited)")
+	cmd.Flags().StringVar(&unzipMaxArchiveSize, "max-archive-size", "", "Most bytes to extract from one archive, e.g. 50G (empty = unlimited)")
+	cmd.Flags().BoolVar(&unzipSkipSpaceCheck, "skip-free-space-check", false, "Extract even when an archive does not fit in the free disk space")
+	cmd.Flags().StringVar(&unzipInMemorySize, "in-memory-size", "64M",
+		"Largest nested archive to extract from memory without writing it to disk (0 = always write nested archives)")
+
+	return cmd
 }
 
-func runUnzip(_ *cobra.Command, args []string) error {
+func runUnzip(cmd *cobra.Command, args []string) error {
+	layout, err := unzipper.ParseLayout(unzipInto)
+	if err != nil {
+		return err
+	}
+
+	nameEncoding, err := unzipper.ParseNameEncoding(unzipEncoding)
+	if err != nil {
+		return err
+	}
+
+	symlinks, err := unzipper.ParseSymlinkPolicy(unzipSymlinks)
+	if err != nil {
+		return err
+	}
+
+	limits, err := unzipLimits()
+	if err != nil {
+		return err
+	}
+
+	passwords, err := readPasswordFile(unzipPasswords)
+	if err != nil {
+		return err
+	}
+
+	inMemoryLimit, err := parseSize(unzipInMemorySize)
+	if err != nil {
+		return fmt.Errorf("--in-memory-size: %w", err)
+	}
+
 	execution, empty, err := runFileCommand(
 		"UNZIP",
 		true,
 		func(progress *progressReporter) (usecase.UnzipExecution, error) {
-			return newUseCaseService().RunUnzip(usecase.UnzipRequest{
-				TargetDir: args[0],
-				DryRun:    dryRun,
+			return newUseCaseService().RunUnzip(cmd.Context(), usecase.UnzipRequest{
+				TargetDir:             args[0],
+				DryRun:                dryRun,
+				DecompressSingleFiles: unzipDecompress,
+				Layout:                layout,
+				NameEncoding:          nameEncoding,
+				Limits:                &limits,
+				Symlinks:              symlinks,
+				Workers:               workers,
+				Salvage:               unzipSalvage,
+				Passwords:             passwords,
+				InMemoryLimit:         &inMemoryLimit,
 				OnProgress: func(stage string, processed, total int) {
 					progress.Report(stage, processed, total)
 				},
@@ -49,7 +175,9 @@ func runUnzip(_ *cobra.Command, args []string) error {
 		func(execution usecase.UnzipExecution) fileCommandExecutionInfo {
 			return infoFromMeta(execution.Meta())
 		},
-		nil,
+		func() {
+			fmt.Printf("Workers: %d\n", workers)
+		},
 	)
 	if err != nil {
 		return err
@@ -60,7 +188,7 @@ func runUnzip(_ *cobra.Command, args []string) error {
 
 	result := execution.Result
 	if result.ArchivesFound == 0 {
-		fmt.Println("no zip archives to process")
+		fmt.Println("no archives to process")
 		fmt.Println()
 	}
 
@@ -73,9 +201,14 @@ func runUnzip(_ *cobra.Command, args []string) error {
 		fmt.Sprintf("Archives Found:     %d", result.ArchivesFound),
 		fmt.Sprintf("Archives Processed: %d", result.ArchivesProcessed),
 		fmt.Sprintf("Archives Extracted: %d", result.ExtractedArchives),
+		fmt.Sprintf("Archives In Memory: %d", result.StreamedArchives),
 		fmt.Sprintf("Archives Skipped:   %d", result.SkippedCount),
 		fmt.Sprintf("Archives Deleted:   %d", result.DeletedArchives),
+		fmt.Sprintf("Duplicate Archives: %d", result.DuplicateArchives),
+		fmt.Sprintf("Archives Salvaged:  %d", result.SalvagedArchives),
 		fmt.Sprintf("Files Extracted:    %d", result.ExtractedFiles),
+		fmt.Sprintf("Files Decompressed: %d", result.DecompressedFiles),
+		fmt.Sprintf("Bytes Extracted:    %s", formatBytes(result.ExtractedBytes)),
 		fmt.Sprintf("Dir Entries:        %d", result.ExtractedDirs),
 		fmt.Sprintf("Errors:             %d", result.ErrorCount),
 	)
@@ -84,14 +217,89 @@ func runUnzip(_ *cobra.Command, args []string) error {
 	return nil
 }
 
+// unzipLimits builds the zip-bomb limits from the unzip flags.
+func unzipLimits() (unzipper.Limits, error) {
+	maxTotal, err := parseSize(unzipMaxTotalSize)
+	if err != nil {
+		return unzipper.Limits{}, fmt.Errorf("--max-total-size: %w", err)
+	}
+
+	maxArchive, err := parseSize(unzipMaxArchiveSize)
+	if err != nil {
+		return unzipper.Limits{}, fmt.Errorf("--max-archive-size: %w", err)
+	}
+
+	return unzipper.Limits{
+		MaxDepth:        unzipMaxDepth,
+		MaxTotalBytes:   maxTotal,
+		MaxArchiveBytes: maxArchive,
+		MaxRatio:        unzipMaxRatio,
+		CheckFreeSpace:  !unzipSkipSpaceCheck,
+	}, nil
+}
+
+// readPasswordFile returns the passwords listed one per line in path,
+// skipping empty lines. An empty path means no passwords.
+func readPasswordFile(path string) ([]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path) //nolint:gosec // the password file is named by the user on the command line
+	if err != nil {
+		return nil, fmt.Errorf("--password-file: %w", err)
+	}
+
+	var passwords []string
+	for line := range strings.Lines(string(data)) {
+		line = strings.TrimRight(line, "\r\n")
+		if line != "" {
+			passwords = append(passwords, line)
+		}
+	}
+	if len(passwords) == 0 {
+		return nil, fmt.Errorf("--password-file: %s lists no passwords", path)
+	}
+
+	return passwords, nil
+}
+
 func printUnzipOperation(op unzipper.ExtractOperation) {
 	switch {
 	case op.Error != nil:
 		fmt.Printf("ERROR: %s: %v\n", op.ArchivePath, op.Error)
 	case op.Skipped:
 		fmt.Printf("SKIP: %s (%s)\n", op.ArchivePath, op.SkipReason)
+	case op.Duplicate:
+		fmt.Printf("DUPLICATE: %s\n", op.ArchivePath)
+		fmt.Printf("  SAME AS: %s\n", op.DuplicateOf)
+		if dryRun {
+			fmt.Println("DELETE: duplicate archive (dry-run)")
+		} else {
+			fmt.Println("DELETE: duplicate archive")
+		}
+	case op.Decompressed:
+		fmt.Printf("DECOMPRESS: %s\n", op.ArchivePath)
+		fmt.Printf("        TO: %s\n", op.OutputPath)
+		if op.DeletedArchive {
+			if dryRun {
+				fmt.Println("DELETE: compressed original (dry-run)")
+			} else {
+				fmt.Println("DELETE: compressed original")
+			}
+		}
 	default:
 		fmt.Printf("UNZIP: %s\n", op.ArchivePath)
+		if op.Salvaged {
+			fmt.Println("  SALVAGED: central directory damaged, read from local headers")
+		}
+		if len(op.Parts) > 0 {
+			fmt.Printf("  VOLUMES: %d (%s ... %s)\n", len(op.Parts)+1,
+				filepath.Base(op.Parts[0]), filepath.Base(op.ArchivePath))
+		}
+		if op.DestDir != "" && op.DestDir != filepath.Dir(op.ArchivePath) {
+			fmt.Printf("  INTO: %s\n", op.DestDir)
+		}
 		fmt.Printf(" FILES: %d\n", op.ExtractedFiles)
 		fmt.Printf("  DIRS: %d\n", op.ExtractedDirs)
 		if op.SkippedEntries > 0 {
@@ -100,6 +308,14 @@ func printUnzipOperation(op unzipper.ExtractOperation) {
 				fmt.Printf("  ENTRY ERROR: %s\n", entryErr)
 			}
 		}
+		for _, streamed := range op.StreamedArchives {
+			location := streamed.Location(filepath.Base(op.ArchivePath))
+			if streamed.DuplicateOf != "" {
+				fmt.Printf("  NESTED DUPLICATE: %s (same as %s)\n", location, streamed.DuplicateOf)
+				continue
+			}
+			fmt.Printf("  NESTED IN MEMORY: %s\n", location)
+		}
 		if op.NestedArchives > 0 {
 			fmt.Printf("NESTED: %d\n", op.NestedArchives)
 		}
@@ -109,6 +325,8 @@ func printUnzipOperation(op unzipper.ExtractOperation) {
 			} else {
 				fmt.Println("DELETE: source archive")
 			}
+		} else if op.Salvaged {
+			fmt.Println("DELETE: source archive kept (salvaged)")
 		} else {
 			fmt.Println("DELETE: source archive not removed")
 		}
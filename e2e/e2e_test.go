@@ -1608,6 +1608,7 @@ func TestEndToEndUndo_ReversesUnzip(t *testing.T) {
 	assertCommandSucceeded(t, "undo unzip", undoResult)
 
 	assertExists(t, archivePath)
+	assertMissing(t, filepath.Join(root, "extracted.txt"))
 }
 
 func TestEndToEndUndo_SequentialOperations(t *testing.T) {
@@ -1842,8 +1843,8 @@ func TestEndToEndUnzip_NoArchives(t *testing.T) {
 	result := runBinary(t, binPath, "unzip", root)
 	assertCommandSucceeded(t, "unzip no archives", result)
 
-	if !strings.Contains(result.stdout, "no zip archives to process") {
-		t.Fatalf("expected 'no zip archives to process' in output\n%s", result.stdout)
+	if !strings.Contains(result.stdout, "no archives to process") {
+		t.Fatalf("expected 'no archives to process' in output\n%s", result.stdout)
 	}
 }
 
@@ -2562,9 +2563,8 @@ func TestEndToEndDataLoss_FullPipelineUndoRoundTrip(t *testing.T) {
 	// The archive should be restored too.
 	assertExists(t, archivePath)
 
-	// The bonus file from the archive should still exist
-	// (unzip undo restores the archive from trash but doesn't remove extracted files).
-	assertExists(t, filepath.Join(root, "bonus.txt"))
+	// The bonus file extracted from the archive is removed by the unzip undo.
+	assertMissing(t, filepath.Join(root, "bonus.txt"))
 }
 
 func TestEndToEndDataLoss_UndoRejectsCorruptedTrash(t *testing.T) {
@@ -0,0 +1,412 @@
+package testutil
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash/crc32"
+	"io/fs"
+	"os"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// SevenZipMethod selects the coder of a 7z fixture.
+type SevenZipMethod string
+
+// 7z coders the fixture writer can produce.
+const (
+	SevenZipCopy  SevenZipMethod = "copy"
+	SevenZipLZMA  SevenZipMethod = "lzma"
+	SevenZipLZMA2 SevenZipMethod = "lzma2"
+)
+
+// SevenZipEntry is one member of a 7z fixture. Directories set Dir, and
+// symlinks set Mode to include fs.ModeSymlink with Body as the target.
+type SevenZipEntry struct {
+	Name     string
+	Body     []byte
+	Dir      bool
+	Mode     fs.FileMode
+	Modified time.Time
+}
+
+// SevenZipOptions controls how a 7z fixture is written.
+type SevenZipOptions struct {
+	// Method compresses file data; empty means SevenZipLZMA2.
+	Method SevenZipMethod
+
+	// Solid stores all file data in one folder instead of one per file.
+	Solid bool
+
+	// CompressHeader stores the header LZMA-compressed, as 7-Zip does by
+	// default.
+	CompressHeader bool
+
+	// Password encrypts file data with 7zAES, and EncryptHeader the
+	// header as well, which implies CompressHeader.
+	Password      string
+	EncryptHeader bool
+
+	// RawMethod, when set, replaces the coder ID recorded for file data,
+	// to produce archives with methods a reader does not implement.
+	RawMethod []byte
+}
+
+// sevenZipFolder is the packed data of one fixture folder.
+type sevenZipFolder struct {
+	packed     []byte
+	coders     [][]byte // coder records, main coder first
+	unpack     []uint64 // per coder output
+	bindPairs  bool
+	sizes      []uint64 // file sizes in the folder
+	crcs       []uint32
+	folderSize uint64
+}
+
+// WriteSevenZip writes entries as a 7z archive to path.
+func WriteSevenZip(t *testing.T, path string, entries []SevenZipEntry, opts SevenZipOptions) {
+	t.Helper()
+
+	if opts.Method == "" {
+		opts.Method = SevenZipLZMA2
+	}
+
+	var folders []sevenZipFolder
+	var pending []SevenZipEntry
+	flush := func() {
+		if len(pending) == 0 {
+			return
+		}
+		folders = append(folders, encodeSevenZipFolder(t, pending, opts))
+		pending = nil
+	}
+	for _, e := range entries {
+		if e.Dir || len(e.Body) == 0 {
+			continue
+		}
+		pending = append(pending, e)
+		if !opts.Solid {
+			flush()
+		}
+	}
+	flush()
+
+	var packed bytes.Buffer
+	for _, f := range folders {
+		packed.Write(f.packed)
+	}
+	header := sevenZipHeader(entries, folders)
+
+	if opts.CompressHeader || opts.EncryptHeader {
+		headerOpts := SevenZipOptions{Method: SevenZipLZMA}
+		if opts.EncryptHeader {
+			headerOpts.Password = opts.Password
+		}
+		f := encodeSevenZipFolder(t, []SevenZipEntry{{Body: header}}, headerOpts)
+
+		var encoded bytes.Buffer
+		encoded.WriteByte(0x17)
+		writeSevenZipStreams(&encoded, uint64(packed.Len()), []sevenZipFolder{f}, true)
+		packed.Write(f.packed)
+		header = encoded.Bytes()
+	}
+
+	start := make([]byte, 32)
+	copy(start, []byte{'7', 'z', 0xbc, 0xaf, 0x27, 0x1c, 0, 4})
+	binary.LittleEndian.PutUint64(start[12:], uint64(packed.Len()))
+	binary.LittleEndian.PutUint64(start[20:], uint64(len(header)))
+	binary.LittleEndian.PutUint32(start[28:], crc32.ChecksumIEEE(header))
+	binary.LittleEndian.PutUint32(start[8:], crc32.ChecksumIEEE(start[12:]))
+
+	out := append(start, packed.Bytes()...)
+	out = append(out, header...)
+	require.NoError(t, os.WriteFile(path, out, 0o644))
+}
+
+func encodeSevenZipFolder(t *testing.T, entries []SevenZipEntry, opts SevenZipOptions) sevenZipFolder {
+	t.Helper()
+
+	var data []byte
+	f := sevenZipFolder{}
+	for _, e := range entries {
+		data = append(data, e.Body...)
+		f.sizes = append(f.sizes, uint64(len(e.Body)))
+		f.crcs = append(f.crcs, crc32.ChecksumIEEE(e.Body))
+	}
+	f.folderSize = uint64(len(data))
+
+	var method, props []byte
+	var compressed []byte
+	switch opts.Method {
+	case SevenZipCopy:
+		method, compressed = []byte{0x00}, data
+	case SevenZipLZMA:
+		var buf bytes.Buffer
+		w, err := lzma.WriterConfig{DictCap: 1 << 16, SizeInHeader: true, Size: int64(len(data))}.NewWriter(&buf)
+		require.NoError(t, err)
+		_, err = w.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		method = []byte{0x03, 0x01, 0x01}
+		props = buf.Bytes()[:5]
+		compressed = buf.Bytes()[lzma.HeaderLen:]
+	default:
+		var buf bytes.Buffer
+		w, err := lzma.Writer2Config{DictCap: 1 << 16}.NewWriter2(&buf)
+		require.NoError(t, err)
+		_, err = w.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		method = []byte{0x21}
+		props = []byte{lzma.EncodeDictCap(1 << 16)}
+		compressed = buf.Bytes()
+	}
+	if opts.RawMethod != nil {
+		method = opts.RawMethod
+	}
+	f.coders = [][]byte{sevenZipCoder(method, props)}
+	f.unpack = []uint64{f.folderSize}
+	f.packed = compressed
+
+	if opts.Password != "" {
+		salt := []byte("0123456789abcdef")
+		iv := []byte("fedcba9876543210")
+		const cycles = 8
+		aesProps := append([]byte{0xc0 | cycles, 0xff}, salt...)
+		aesProps = append(aesProps, iv...)
+
+		padded := append([]byte{}, compressed...)
+		for len(padded)%aes.BlockSize != 0 {
+			padded = append(padded, 0)
+		}
+		block, err := aes.NewCipher(sevenZipAESKey(opts.Password, salt, cycles))
+		require.NoError(t, err)
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(padded, padded)
+
+		f.coders = append(f.coders, sevenZipCoder([]byte{0x06, 0xf1, 0x07, 0x01}, aesProps))
+		f.unpack = append(f.unpack, uint64(len(compressed)))
+		f.bindPairs = true
+		f.packed = padded
+	}
+
+	return f
+}
+
+func sevenZipAESKey(password string, salt []byte, cycles int) []byte {
+	var pw []byte
+	for _, u := range utf16.Encode([]rune(password)) {
+		pw = binary.LittleEndian.AppendUint16(pw, u)
+	}
+
+	h := sha256.New()
+	var counter [8]byte
+	for i := uint64(0); i < 1<<cycles; i++ {
+		binary.LittleEndian.PutUint64(counter[:], i)
+		h.Write(salt)
+		h.Write(pw)
+		h.Write(counter[:])
+	}
+
+	return h.Sum(nil)
+}
+
+func sevenZipCoder(method, props []byte) []byte {
+	flags := byte(len(method))
+	if props != nil {
+		flags |= 0x20
+	}
+	out := append([]byte{flags}, method...)
+	if props != nil {
+		out = appendSevenZipNumber(out, uint64(len(props)))
+		out = append(out, props...)
+	}
+
+	return out
+}
+
+// appendSevenZipNumber appends v in the 7z variable-length encoding; the
+// fixtures only need the 1, 2, 3 and 9 byte forms.
+func appendSevenZipNumber(b []byte, v uint64) []byte {
+	switch {
+	case v < 0x80:
+		return append(b, byte(v))
+	case v < 0x4000:
+		return append(b, 0x80|byte(v>>8), byte(v))
+	case v < 0x200000:
+		return append(b, 0xc0|byte(v>>16), byte(v), byte(v>>8))
+	default:
+		b = append(b, 0xff)
+		return binary.LittleEndian.AppendUint64(b, v)
+	}
+}
+
+func writeSevenZipStreams(buf *bytes.Buffer, packPos uint64, folders []sevenZipFolder, folderCRCs bool) {
+	num := func(v uint64) { buf.Write(appendSevenZipNumber(nil, v)) }
+
+	buf.WriteByte(0x06) // PackInfo
+	num(packPos)
+	num(uint64(len(folders)))
+	buf.WriteByte(0x09)
+	for _, f := range folders {
+		num(uint64(len(f.packed)))
+	}
+	buf.WriteByte(0x00)
+
+	buf.WriteByte(0x07) // UnpackInfo
+	buf.WriteByte(0x0b)
+	num(uint64(len(folders)))
+	buf.WriteByte(0x00)
+	for _, f := range folders {
+		num(uint64(len(f.coders)))
+		for _, c := range f.coders {
+			buf.Write(c)
+		}
+		if f.bindPairs {
+			// The AES output (stream 1) feeds the decompressor input.
+			num(0)
+			num(1)
+		}
+	}
+	buf.WriteByte(0x0c)
+	for _, f := range folders {
+		for _, size := range f.unpack {
+			num(size)
+		}
+	}
+	if folderCRCs {
+		buf.WriteByte(0x0a)
+		buf.WriteByte(0x01)
+		for _, f := range folders {
+			// Folders with a CRC hold a single stream, the header.
+			_ = binary.Write(buf, binary.LittleEndian, f.crcs[0])
+		}
+	}
+	buf.WriteByte(0x00)
+
+	if !folderCRCs {
+		buf.WriteByte(0x08) // SubStreamsInfo
+		buf.WriteByte(0x0d)
+		for _, f := range folders {
+			num(uint64(len(f.sizes)))
+		}
+		buf.WriteByte(0x09)
+		for _, f := range folders {
+			for _, size := range f.sizes[:len(f.sizes)-1] {
+				num(size)
+			}
+		}
+		buf.WriteByte(0x0a)
+		buf.WriteByte(0x01)
+		for _, f := range folders {
+			for _, crc := range f.crcs {
+				_ = binary.Write(buf, binary.LittleEndian, crc)
+			}
+		}
+		buf.WriteByte(0x00)
+	}
+
+	buf.WriteByte(0x00)
+}
+
+func sevenZipHeader(entries []SevenZipEntry, folders []sevenZipFolder) []byte {
+	var buf bytes.Buffer
+	num := func(v uint64) { buf.Write(appendSevenZipNumber(nil, v)) }
+
+	buf.WriteByte(0x01) // Header
+	if len(folders) > 0 {
+		buf.WriteByte(0x04) // MainStreamsInfo
+		writeSevenZipStreams(&buf, 0, folders, false)
+	}
+
+	buf.WriteByte(0x05) // FilesInfo
+	num(uint64(len(entries)))
+
+	emptyStream := make([]bool, len(entries))
+	var emptyFile []bool
+	for i, e := range entries {
+		if e.Dir || len(e.Body) == 0 {
+			emptyStream[i] = true
+			emptyFile = append(emptyFile, !e.Dir)
+		}
+	}
+	if len(emptyFile) > 0 {
+		writeSevenZipProperty(&buf, 0x0e, sevenZipBits(emptyStream))
+		writeSevenZipProperty(&buf, 0x0f, sevenZipBits(emptyFile))
+	}
+
+	names := []byte{0}
+	for _, e := range entries {
+		for _, u := range utf16.Encode([]rune(e.Name)) {
+			names = binary.LittleEndian.AppendUint16(names, u)
+		}
+		names = append(names, 0, 0)
+	}
+	writeSevenZipProperty(&buf, 0x11, names)
+
+	times := []byte{1, 0}
+	attrs := []byte{1, 0}
+	for _, e := range entries {
+		modified := e.Modified
+		if modified.IsZero() {
+			modified = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+		}
+		ft := uint64(modified.UnixNano()/100) + 116444736000000000
+		times = binary.LittleEndian.AppendUint64(times, ft)
+		attrs = binary.LittleEndian.AppendUint32(attrs, sevenZipAttributes(e))
+	}
+	writeSevenZipProperty(&buf, 0x14, times)
+	writeSevenZipProperty(&buf, 0x15, attrs)
+
+	buf.WriteByte(0x00)
+	buf.WriteByte(0x00)
+
+	return buf.Bytes()
+}
+
+func sevenZipAttributes(e SevenZipEntry) uint32 {
+	mode := e.Mode
+	if mode == 0 {
+		mode = 0o644
+		if e.Dir {
+			mode = 0o755
+		}
+	}
+
+	unix := uint32(mode.Perm())
+	attrs := uint32(0x8000)
+	switch {
+	case e.Dir:
+		unix |= 0o040000
+		attrs |= 0x10
+	case mode&fs.ModeSymlink != 0:
+		unix |= 0o120000
+	default:
+		unix |= 0o100000
+	}
+
+	return attrs | unix<<16
+}
+
+func writeSevenZipProperty(buf *bytes.Buffer, id byte, data []byte) {
+	buf.WriteByte(id)
+	buf.Write(appendSevenZipNumber(nil, uint64(len(data))))
+	buf.Write(data)
+}
+
+func sevenZipBits(bits []bool) []byte {
+	out := make([]byte, (len(bits)+7)/8)
+	for i, set := range bits {
+		if set {
+			out[i/8] |= 0x80 >> (i % 8)
+		}
+	}
+
+	return out
+}
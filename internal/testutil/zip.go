@@ -0,0 +1,143 @@
+package testutil
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// WriteSplitZip writes entries as a split zip archive the way Info-ZIP
+// "zip -s" does: name.z01, name.z02, ... of volumeSize bytes each, then
+// name.zip holding the rest and the central directory. It returns the
+// tail path and the volume paths.
+func WriteSplitZip(t *testing.T, dir, name string, entries map[string][]byte, volumeSize int) (string, []string) {
+	t.Helper()
+
+	names := make([]string, 0, len(entries))
+	for entryName := range entries {
+		names = append(names, entryName)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, entryName := range names {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: entryName, Method: zip.Store})
+		require.NoError(t, err)
+		_, err = w.Write(entries[entryName])
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	whole := buf.Bytes()
+
+	eocd := bytes.LastIndex(whole, []byte{0x50, 0x4b, 0x05, 0x06})
+	require.GreaterOrEqual(t, eocd, 0)
+	dirOffset := int(binary.LittleEndian.Uint32(whole[eocd+16:]))
+	dirSize := int(binary.LittleEndian.Uint32(whole[eocd+12:]))
+
+	// The first volume starts with the split archive signature, which
+	// shifts every local header by four bytes.
+	data := binary.LittleEndian.AppendUint32(nil, 0x08074b50)
+	data = append(data, whole[:dirOffset]...)
+
+	var volumes [][]byte
+	for len(data) > volumeSize {
+		volumes = append(volumes, data[:volumeSize])
+		data = data[volumeSize:]
+	}
+	tail := append([]byte(nil), data...)
+	lastDisk := len(volumes)
+	require.Positive(t, lastDisk, "entries must span more than one volume")
+
+	// Central directory records are 46 bytes plus the name, extra field
+	// and comment; each gets its volume number and in-volume offset.
+	centralDir := append([]byte(nil), whole[dirOffset:dirOffset+dirSize]...)
+	for pos := 0; pos < len(centralDir); {
+		abs := int(binary.LittleEndian.Uint32(centralDir[pos+42:])) + 4
+		binary.LittleEndian.PutUint16(centralDir[pos+34:], uint16(abs/volumeSize))
+		binary.LittleEndian.PutUint32(centralDir[pos+42:], uint32(abs%volumeSize))
+		pos += 46 +
+			int(binary.LittleEndian.Uint16(centralDir[pos+28:])) +
+			int(binary.LittleEndian.Uint16(centralDir[pos+30:])) +
+			int(binary.LittleEndian.Uint16(centralDir[pos+32:]))
+	}
+
+	end := append([]byte(nil), whole[eocd:]...)
+	binary.LittleEndian.PutUint16(end[4:], uint16(lastDisk))
+	binary.LittleEndian.PutUint16(end[6:], uint16(lastDisk))
+	binary.LittleEndian.PutUint32(end[16:], uint32(len(tail)))
+	tail = append(append(tail, centralDir...), end...)
+
+	parts := make([]string, 0, len(volumes))
+	for i, volume := range volumes {
+		part := filepath.Join(dir, fmt.Sprintf("%s.z%02d", name, i+1))
+		require.NoError(t, os.WriteFile(part, volume, 0o644))
+		parts = append(parts, part)
+	}
+	tailPath := filepath.Join(dir, name+".zip")
+	require.NoError(t, os.WriteFile(tailPath, tail, 0o644))
+
+	return tailPath, parts
+}
+
+// WriteZipCryptoZip writes entries as a stored zip archive encrypted with
+// password using traditional PKWARE encryption, as "zip -P" does.
+func WriteZipCryptoZip(t *testing.T, path string, entries map[string][]byte, password string) {
+	t.Helper()
+
+	names := make([]string, 0, len(entries))
+	for entryName := range entries {
+		names = append(names, entryName)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, entryName := range names {
+		body := entries[entryName]
+		crc := crc32.ChecksumIEEE(body)
+
+		keys := [3]uint32{0x12345678, 0x23456789, 0x34567890}
+		update := func(b byte) {
+			keys[0] = crc32.IEEETable[byte(keys[0])^b] ^ keys[0]>>8
+			keys[1] = (keys[1]+keys[0]&0xff)*134775813 + 1
+			keys[2] = crc32.IEEETable[byte(keys[2])^byte(keys[1]>>24)] ^ keys[2]>>8
+		}
+		for i := 0; i < len(password); i++ {
+			update(password[i])
+		}
+
+		// The last header byte is the high byte of the CRC-32, which
+		// readers check the password against.
+		plain := append(make([]byte, 11), byte(crc>>24))
+		plain = append(plain, body...)
+		data := make([]byte, len(plain))
+		for i, b := range plain {
+			temp := keys[2] | 2
+			data[i] = b ^ byte((temp*(temp^1))>>8)
+			update(b)
+		}
+
+		w, err := zw.CreateRaw(&zip.FileHeader{
+			Name:               entryName,
+			Method:             zip.Store,
+			Flags:              0x1,
+			CRC32:              crc,
+			CompressedSize64:   uint64(len(data)),
+			UncompressedSize64: uint64(len(body)),
+		})
+		require.NoError(t, err)
+		_, err = w.Write(data)
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0o644))
+}
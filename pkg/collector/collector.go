@@ -2,6 +2,7 @@
 package collector
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"time"
@@ -48,13 +49,17 @@ func New(opts Options) *Collector {
 }
 
 // Collect walks the directory tree and collects metadata for all files.
-func (c *Collector) Collect(rootDir string) ([]FileInfo, error) {
+// It stops with the context's error once ctx is done.
+func (c *Collector) Collect(ctx context.Context, rootDir string) ([]FileInfo, error) {
 	var files []FileInfo
 
 	err := filepath.Walk(rootDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
 
 		// Skip directories in skip list
 		if info.IsDir() {
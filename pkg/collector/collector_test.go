@@ -1,6 +1,7 @@
 package collector
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"testing"
@@ -48,7 +49,7 @@ func setupTestDir(t *testing.T) string {
 func collectFiles(t *testing.T, c *Collector, root string) []FileInfo {
 	t.Helper()
 
-	files, err := c.Collect(root)
+	files, err := c.Collect(t.Context(), root)
 	require.NoError(t, err)
 
 	return files
@@ -152,10 +153,20 @@ func TestCollector_Collect_EmptyDir(t *testing.T) {
 func TestCollector_Collect_NonExistentDir(t *testing.T) {
 	c := New(Options{})
 
-	_, err := c.Collect("/nonexistent/path/that/does/not/exist")
+	_, err := c.Collect(t.Context(), "/nonexistent/path/that/does/not/exist")
 	assert.Error(t, err, "expected error for nonexistent directory")
 }
 
+func TestCollector_Collect_Canceled(t *testing.T) {
+	tmpDir := setupTestDir(t)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+
+	_, err := New(Options{}).Collect(ctx, tmpDir)
+	require.ErrorIs(t, err, context.Canceled)
+}
+
 func TestFileInfo_ModTime(t *testing.T) {
 	tmpDir := t.TempDir()
 
@@ -2,11 +2,15 @@
 // It uses a hybrid approach for performance:
 // 1. Group files by size (instant filter - different sizes can't be duplicates)
 // 2. For same-size files, compute partial hash (first + last 4KB) for quick comparison
-// 3. For files with matching partial hash, compute full SHA256 to confirm
-// This approach is both fast and reliable - no false positives possible.
+// 3. For files with matching partial hash, compute the full hash, XXH128 by
+// default (see [DefaultHashAlgorithm])
+// 4. Before removing a file matched by a non-cryptographic hash, compare it
+// byte for byte with the kept copy
+// This approach is fast, and no file is removed on a hash match alone.
 package deduplicator
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
@@ -14,6 +18,7 @@ import (
 
 	"btidy/pkg/collector"
 	"btidy/pkg/hasher"
+	"btidy/pkg/keeper"
 	"btidy/pkg/progress"
 	"btidy/pkg/safepath"
 	"btidy/pkg/trash"
@@ -24,13 +29,20 @@ import (
 // situations with errors.Is.
 var ErrContentChanged = errors.New("file content changed since hash was computed")
 
+// ErrHashCollision indicates that a file and its kept copy have equal
+// hashes but different bytes, which a non-cryptographic hash allows.
+var ErrHashCollision = errors.New("files with equal hashes differ")
+
 // DeleteOperation represents a single delete operation.
 type DeleteOperation struct {
 	Path       string // Path of file to delete
 	OriginalOf string // Path of the original file this is a duplicate of
+	KeepReason string // Why OriginalOf was kept rather than this file
+	Reference  bool   // OriginalOf is a file of a read-only Reference, outside the root
 	Size       int64
-	Hash       string // SHA256 hash of the file
-	TrashedTo  string // Trash destination (empty when trasher is nil)
+	Hash       string   // Content hash of the file, algorithm-prefixed unless SHA-256
+	TrashedTo  string   // Trash destination (empty when trasher is nil)
+	Link       LinkMode // Kind of link that replaced the file; empty when it was removed
 	Skipped    bool
 	SkipReason string
 	Error      error
@@ -38,13 +50,19 @@ type DeleteOperation struct {
 
 // Result contains the results of a deduplication operation.
 type Result struct {
-	Operations      []DeleteOperation
-	TotalFiles      int
-	DuplicatesFound int
-	DeletedCount    int
-	SkippedCount    int
-	ErrorCount      int
-	BytesRecovered  int64
+	Operations []DeleteOperation
+	// DirOperations are the directories removed as a unit, largest first;
+	// only set with [WithDirectories].
+	DirOperations      []DirectoryOperation
+	TotalFiles         int
+	DuplicatesFound    int
+	DirDuplicatesFound int
+	DeletedCount       int
+	DirsDeletedCount   int
+	LinkedCount        int
+	SkippedCount       int
+	ErrorCount         int
+	BytesRecovered     int64
 }
 
 // Deduplicator identifies and removes duplicate files using content hashing.
@@ -53,8 +71,45 @@ type Deduplicator struct {
 	validator *safepath.Validator
 	hasher    *hasher.Hasher
 	trasher   *trash.Trasher
+	policy    keeper.Policy
+	cache     *hasher.Cache
+	algo      hasher.Algorithm
+	link      LinkMode
+	dirs      bool
+}
+
+// Option configures a Deduplicator.
+type Option func(*Deduplicator)
+
+// WithKeepPolicy selects which copy of each group of duplicates is kept.
+// The default keeps the copy whose path sorts first.
+func WithKeepPolicy(policy keeper.Policy) Option {
+	return func(d *Deduplicator) {
+		d.policy = policy
+	}
 }
 
+// WithHashCache makes the deduplicator look up and record file hashes in
+// cache, so files unchanged since an earlier run are not read again.
+func WithHashCache(cache *hasher.Cache) Option {
+	return func(d *Deduplicator) {
+		d.cache = cache
+	}
+}
+
+// WithHashAlgorithm selects the algorithm files are grouped by. The default
+// is [DefaultHashAlgorithm].
+func WithHashAlgorithm(algo hasher.Algorithm) Option {
+	return func(d *Deduplicator) {
+		d.algo = algo
+	}
+}
+
+// DefaultHashAlgorithm is the algorithm files are grouped by unless
+// [WithHashAlgorithm] selects another. It is fast rather than
+// cryptographic: grouping only needs distinct content to hash apart.
+const DefaultHashAlgorithm = hasher.AlgorithmXXH128
+
 const (
 	progressStageHashing  = "hashing"
 	progressStageDeleting = "deleting"
@@ -77,35 +132,69 @@ func NewWithWorkers(rootDir string, dryRun bool, workers int) (*Deduplicator, er
 
 // NewWithValidator creates a new Deduplicator with an existing validator.
 // An optional trasher enables soft-delete (move to trash) instead of permanent removal.
-func NewWithValidator(validator *safepath.Validator, dryRun bool, workers int, trasher *trash.Trasher) (*Deduplicator, error) {
+func NewWithValidator(
+	validator *safepath.Validator,
+	dryRun bool,
+	workers int,
+	trasher *trash.Trasher,
+	opts ...Option,
+) (*Deduplicator, error) {
 	if validator == nil {
 		return nil, errors.New("validator is required")
 	}
 
-	return &Deduplicator{
+	d := &Deduplicator{
 		dryRun:    dryRun,
 		validator: validator,
-		hasher:    hasher.New(hasher.WithWorkers(workers)),
 		trasher:   trasher,
-	}, nil
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	algo, err := hasher.ParseAlgorithm(string(d.algo))
+	if err != nil {
+		return nil, err
+	}
+	if d.algo == "" {
+		algo = DefaultHashAlgorithm
+	}
+	d.hasher = hasher.New(hasher.WithWorkers(workers), hasher.WithAlgorithm(algo), hasher.WithCache(d.cache))
+
+	if err := d.policy.Validate(); err != nil {
+		return nil, err
+	}
+
+	if _, err := ParseLinkMode(string(d.link)); err != nil {
+		return nil, err
+	}
+
+	if d.dirs && d.link != LinkNone {
+		return nil, errors.New("duplicate directories cannot be replaced with links")
+	}
+
+	return d, nil
 }
 
 // DuplicateGroup represents a group of files that are duplicates of each other.
 type DuplicateGroup struct {
-	Hash  string             // SHA256 hash shared by all files
-	Size  int64              // Size shared by all files
-	Keep  collector.FileInfo // File to keep (first found)
-	Dupes []collector.FileInfo
+	Hash       string             // Content hash shared by all files
+	Size       int64              // Size shared by all files
+	Keep       collector.FileInfo // File to keep, chosen by the keep policy
+	KeepReason string             // Why Keep was chosen over the others
+	Dupes      []collector.FileInfo
 }
 
 // FindDuplicates analyzes files and identifies duplicates using content hashing.
 // Returns a Result containing all duplicate files that should be deleted.
 func (d *Deduplicator) FindDuplicates(files []collector.FileInfo) Result {
-	return d.FindDuplicatesWithProgress(files, nil)
+	return d.FindDuplicatesWithProgress(context.Background(), files, nil)
 }
 
 // FindDuplicatesWithProgress analyzes files and reports stage progress.
-func (d *Deduplicator) FindDuplicatesWithProgress(files []collector.FileInfo, onProgress func(stage string, processed, total int)) Result {
+// Once ctx is done no further file is removed: the removal in progress
+// completes and the result holds the operations performed so far.
+func (d *Deduplicator) FindDuplicatesWithProgress(ctx context.Context, files []collector.FileInfo, onProgress func(stage string, processed, total int)) Result {
 	result := Result{
 		TotalFiles: len(files),
 		Operations: make([]DeleteOperation, 0),
@@ -124,23 +213,32 @@ func (d *Deduplicator) FindDuplicatesWithProgress(files []collector.FileInfo, on
 	})
 	result.Operations = append(result.Operations, invalidReadOps...)
 	if len(invalidReadOps) > 0 {
-		sort.Slice(result.Operations, func(i, j int) bool {
-			return result.Operations[i].Path < result.Operations[j].Path
-		})
+		sortOperations(result.Operations)
 		result.calculateCounts()
 		return result
 	}
 
+	// Whole identical directories go first; their files are not
+	// considered again.
+	if d.dirs {
+		var removedDirs []string
+		result.DirOperations, removedDirs = d.findDuplicateDirectories(ctx, safeFiles, onProgress)
+		safeFiles = withoutDirectories(safeFiles, removedDirs)
+	}
+
 	// Step 1: Group by size (files with unique sizes cannot be duplicates).
 	sizeGroups := groupBySize(safeFiles)
 
 	// Step 2: For each size group with multiple files, find duplicates by hash.
 	for _, group := range sizeGroups {
+		if ctx.Err() != nil {
+			break
+		}
 		if len(group) < 2 {
 			continue
 		}
 
-		duplicateGroups := d.findDuplicatesInSizeGroup(group, onProgress)
+		duplicateGroups := d.findDuplicatesInSizeGroup(ctx, group, onProgress)
 		deleteTotal := 0
 		for i := range duplicateGroups {
 			deleteTotal += len(duplicateGroups[i].Dupes)
@@ -149,7 +247,11 @@ func (d *Deduplicator) FindDuplicatesWithProgress(files []collector.FileInfo, on
 		deleteProcessed := 0
 		for i := range duplicateGroups {
 			for j := range duplicateGroups[i].Dupes {
+				if ctx.Err() != nil {
+					break
+				}
 				op := d.deleteFile(duplicateGroups[i].Dupes[j], duplicateGroups[i].Keep.Path, duplicateGroups[i].Hash)
+				op.KeepReason = duplicateGroups[i].KeepReason
 				result.Operations = append(result.Operations, op)
 				deleteProcessed++
 				progress.EmitStage(onProgress, progressStageDeleting, deleteProcessed, deleteTotal)
@@ -158,9 +260,7 @@ func (d *Deduplicator) FindDuplicatesWithProgress(files []collector.FileInfo, on
 	}
 
 	// Sort operations by path for deterministic output.
-	sort.Slice(result.Operations, func(i, j int) bool {
-		return result.Operations[i].Path < result.Operations[j].Path
-	})
+	sortOperations(result.Operations)
 
 	result.calculateCounts()
 
@@ -178,11 +278,28 @@ func (r *Result) calculateCounts() {
 			r.ErrorCount++
 		case op.Skipped:
 			r.SkippedCount++
+		case op.Link != LinkNone:
+			r.LinkedCount++
+			r.BytesRecovered += op.Size
 		default:
 			r.DeletedCount++
 			r.BytesRecovered += op.Size
 		}
 	}
+
+	for _, op := range r.DirOperations {
+		r.DirDuplicatesFound++
+
+		switch {
+		case op.Error != nil:
+			r.ErrorCount++
+		case op.Skipped:
+			r.SkippedCount++
+		default:
+			r.DirsDeletedCount++
+			r.BytesRecovered += op.Size
+		}
+	}
 }
 
 // groupBySize groups files by their size.
@@ -195,7 +312,7 @@ func groupBySize(files []collector.FileInfo) map[int64][]collector.FileInfo {
 }
 
 // findDuplicatesInSizeGroup finds duplicates among files of the same size.
-func (d *Deduplicator) findDuplicatesInSizeGroup(files []collector.FileInfo, onProgress func(stage string, processed, total int)) []DuplicateGroup {
+func (d *Deduplicator) findDuplicatesInSizeGroup(ctx context.Context, files []collector.FileInfo, onProgress func(stage string, processed, total int)) []DuplicateGroup {
 	if len(files) < 2 {
 		return nil
 	}
@@ -204,22 +321,22 @@ func (d *Deduplicator) findDuplicatesInSizeGroup(files []collector.FileInfo, onP
 
 	// For small files, go straight to full hash.
 	if size <= hasher.SmallFileThreshold {
-		return d.findDuplicatesByFullHash(files, onProgress)
+		return d.findDuplicatesByFullHash(ctx, files, onProgress)
 	}
 
 	// For larger files, use partial hash first.
-	return d.findDuplicatesByPartialThenFullHash(files, onProgress)
+	return d.findDuplicatesByPartialThenFullHash(ctx, files, onProgress)
 }
 
-// findDuplicatesByFullHash groups files by their full SHA256 hash.
-func (d *Deduplicator) findDuplicatesByFullHash(files []collector.FileInfo, onProgress func(stage string, processed, total int)) []DuplicateGroup {
-	return buildDuplicateGroups(d.groupFilesByHash(files, d.hasher.HashFilesWithSizes, progressStageHashing, onProgress))
+// findDuplicatesByFullHash groups files by their full content hash.
+func (d *Deduplicator) findDuplicatesByFullHash(ctx context.Context, files []collector.FileInfo, onProgress func(stage string, processed, total int)) []DuplicateGroup {
+	return d.buildDuplicateGroups(d.groupFilesByHash(ctx, files, d.hasher.HashFilesWithSizes, progressStageHashing, onProgress))
 }
 
 // findDuplicatesByPartialThenFullHash uses partial hash for initial grouping,
 // then confirms with full hash.
-func (d *Deduplicator) findDuplicatesByPartialThenFullHash(files []collector.FileInfo, onProgress func(stage string, processed, total int)) []DuplicateGroup {
-	partialGroups := d.groupFilesByHash(files, d.hasher.HashPartialFilesWithSizes, progressStageHashing, onProgress)
+func (d *Deduplicator) findDuplicatesByPartialThenFullHash(ctx context.Context, files []collector.FileInfo, onProgress func(stage string, processed, total int)) []DuplicateGroup {
+	partialGroups := d.groupFilesByHash(ctx, files, d.hasher.HashPartialFilesWithSizes, progressStageHashing, onProgress)
 
 	// Second pass: for groups with multiple files, confirm with full hash.
 	var result []DuplicateGroup
@@ -228,14 +345,14 @@ func (d *Deduplicator) findDuplicatesByPartialThenFullHash(files []collector.Fil
 			continue
 		}
 		// These files have matching partial hash - compute full hash to confirm.
-		confirmed := d.findDuplicatesByFullHash(group, onProgress)
+		confirmed := d.findDuplicatesByFullHash(ctx, group, onProgress)
 		result = append(result, confirmed...)
 	}
 
 	return result
 }
 
-func (d *Deduplicator) groupFilesByHash(files []collector.FileInfo, hashFn func([]hasher.FileToHash) <-chan hasher.HashResult, stage string, onProgress func(stage string, processed, total int)) map[string][]collector.FileInfo {
+func (d *Deduplicator) groupFilesByHash(ctx context.Context, files []collector.FileInfo, hashFn func(context.Context, []hasher.FileToHash) <-chan hasher.HashResult, stage string, onProgress func(stage string, processed, total int)) map[string][]collector.FileInfo {
 	hashGroups := make(map[string][]collector.FileInfo)
 	toHash := make([]hasher.FileToHash, 0, len(files))
 	fileByPath := make(map[string]collector.FileInfo, len(files))
@@ -250,7 +367,7 @@ func (d *Deduplicator) groupFilesByHash(files []collector.FileInfo, hashFn func(
 
 	processed := 0
 	total := len(files)
-	for result := range hashFn(toHash) {
+	for result := range hashFn(ctx, toHash) {
 		processed++
 		progress.EmitStage(onProgress, stage, processed, total)
 
@@ -267,8 +384,9 @@ func (d *Deduplicator) groupFilesByHash(files []collector.FileInfo, hashFn func(
 	return hashGroups
 }
 
-// buildDuplicateGroups converts hash groups to DuplicateGroup slice.
-func buildDuplicateGroups(hashGroups map[string][]collector.FileInfo) []DuplicateGroup {
+// buildDuplicateGroups converts hash groups to DuplicateGroup slice, keeping
+// the file the keep policy ranks first in each group.
+func (d *Deduplicator) buildDuplicateGroups(hashGroups map[string][]collector.FileInfo) []DuplicateGroup {
 	groups := make([]DuplicateGroup, 0, len(hashGroups))
 
 	for hash, files := range hashGroups {
@@ -276,23 +394,22 @@ func buildDuplicateGroups(hashGroups map[string][]collector.FileInfo) []Duplicat
 			continue
 		}
 
-		// Sort files by path for deterministic "keep" selection.
-		sort.Slice(files, func(i, j int) bool {
-			return files[i].Path < files[j].Path
-		})
+		reason := d.policy.Choose(d.validator.Root(), files)
 
 		groups = append(groups, DuplicateGroup{
-			Hash:  hash,
-			Size:  files[0].Size,
-			Keep:  files[0],
-			Dupes: files[1:],
+			Hash:       hash,
+			Size:       files[0].Size,
+			Keep:       files[0],
+			KeepReason: reason,
+			Dupes:      files[1:],
 		})
 	}
 
 	return groups
 }
 
-// deleteFile creates a delete operation and optionally performs the deletion.
+// deleteFile creates a delete operation and optionally performs the deletion,
+// or replaces the file with a link to the kept one when a [LinkMode] is set.
 func (d *Deduplicator) deleteFile(file collector.FileInfo, originalPath, hash string) DeleteOperation {
 	op := DeleteOperation{
 		Path:       file.Path,
@@ -301,37 +418,110 @@ func (d *Deduplicator) deleteFile(file collector.FileInfo, originalPath, hash st
 		Hash:       hash,
 	}
 
+	return d.removeDuplicate(op, true)
+}
+
+// removeDuplicate performs op unless in dry-run mode: it checks that the
+// kept file op.OriginalOf still exists when verifyKept is set, re-hashes
+// the duplicate, confirms a match of a non-cryptographic hash byte for
+// byte, then removes it or replaces it with a link. Files matched in a
+// [Reference] are never linked.
+func (d *Deduplicator) removeDuplicate(op DeleteOperation, verifyKept bool) DeleteOperation {
 	// Validate path is within root.
-	if err := d.validator.ValidatePathForRead(file.Path); err != nil {
+	if err := d.validator.ValidatePathForRead(op.Path); err != nil {
 		op.Error = fmt.Errorf("path escapes root: %w", err)
 		return op
 	}
 
+	link := d.link
+	if op.Reference {
+		link = LinkNone
+	}
+
 	// Perform deletion if not dry run.
 	if !d.dryRun {
 		// Verify the kept file still exists before deleting the duplicate.
-		if _, err := os.Lstat(originalPath); err != nil {
-			op.Error = fmt.Errorf("kept file missing, refusing to delete duplicate: %w", err)
-			return op
+		if verifyKept {
+			if _, err := os.Lstat(op.OriginalOf); err != nil {
+				op.Error = fmt.Errorf("kept file missing, refusing to delete duplicate: %w", err)
+				return op
+			}
 		}
 
 		// Re-hash the file to confirm it hasn't changed since initial hash.
-		currentHash, err := d.hasher.ComputeHash(file.Path)
+		currentHash, err := d.hasherFor(op.Hash).ComputeHash(op.Path)
 		if err != nil {
 			op.Error = fmt.Errorf("re-hash before delete: %w", err)
 			return op
 		}
-		if currentHash != hash {
+		if currentHash != op.Hash {
 			op.Error = fmt.Errorf("content changed since hashing: %w", ErrContentChanged)
 			return op
 		}
+		if err := confirmIdentical(op, verifyKept); err != nil {
+			op.Error = err
+			return op
+		}
 
-		op.TrashedTo, op.Error = d.trashOrRemove(file.Path)
+		if link != LinkNone {
+			d.replaceWithLink(&op)
+			return op
+		}
+
+		op.TrashedTo, op.Error = d.trashOrRemove(op.Path)
 	}
 
+	op.Link = link
+
 	return op
 }
 
+// confirmIdentical compares the duplicate of op with its kept file byte for
+// byte when op.Hash is of a non-cryptographic algorithm, since such a hash
+// alone cannot justify removing a file. The kept file must be on disk,
+// as verifyKept says it is.
+func confirmIdentical(op DeleteOperation, verifyKept bool) error {
+	algo, err := hasher.AlgorithmOf(op.Hash)
+	if err != nil {
+		return err
+	}
+	if algo.Cryptographic() {
+		return nil
+	}
+	if !verifyKept {
+		return fmt.Errorf("a %s match cannot be confirmed without the kept file: %w", algo, ErrHashCollision)
+	}
+
+	same, err := hasher.SameContent(op.Path, op.OriginalOf)
+	if err != nil {
+		return fmt.Errorf("compare with kept file: %w", err)
+	}
+	if !same {
+		return fmt.Errorf("refusing to delete: %w", ErrHashCollision)
+	}
+
+	return nil
+}
+
+// replaceWithLink replaces the duplicate of op with a link to its kept
+// file. A duplicate that cannot be linked where it is, because it is on
+// another device, the filesystem cannot clone files, or it already is a
+// hard link to the kept file, is skipped and left untouched.
+func (d *Deduplicator) replaceWithLink(op *DeleteOperation) {
+	trashedTo, err := d.linkFile(op.Path, op.OriginalOf)
+	switch {
+	case errors.Is(err, ErrCrossDevice), errors.Is(err, ErrReflinkUnsupported), errors.Is(err, errAlreadyLinked):
+		op.Skipped = true
+		op.SkipReason = err.Error()
+	case err != nil:
+		op.TrashedTo = trashedTo
+		op.Error = err
+	default:
+		op.TrashedTo = trashedTo
+		op.Link = d.link
+	}
+}
+
 // trashOrRemove soft-deletes a file when a trasher is configured, otherwise
 // permanently removes it. Returns the trash destination (empty on hard delete).
 func (d *Deduplicator) trashOrRemove(path string) (string, error) {
@@ -346,6 +536,13 @@ func (d *Deduplicator) trashOrRemove(path string) (string, error) {
 	return "", nil
 }
 
+// sortOperations sorts operations by path for deterministic output.
+func sortOperations(ops []DeleteOperation) {
+	sort.Slice(ops, func(i, j int) bool {
+		return ops[i].Path < ops[j].Path
+	})
+}
+
 // DryRun returns whether the deduplicator is in dry-run mode.
 func (d *Deduplicator) DryRun() bool {
 	return d.dryRun
@@ -356,8 +553,19 @@ func (d *Deduplicator) Root() string {
 	return d.validator.Root()
 }
 
-// ComputeFileHash computes and returns the SHA256 hash of a file.
-// Exported for use by callers who need to verify file hashes.
+// hasherFor returns a hasher computing hashes in the format of hash, which
+// may come from a reference hashed with another algorithm.
+func (d *Deduplicator) hasherFor(hash string) *hasher.Hasher {
+	algo, err := hasher.AlgorithmOf(hash)
+	if err != nil || algo == d.hasher.Algorithm() {
+		return d.hasher
+	}
+	return d.hasher.Using(algo)
+}
+
+// ComputeFileHash computes and returns the SHA-256 hash of a file, the
+// default of [hasher.New], not the [DefaultHashAlgorithm] files are
+// grouped by. Exported for use by callers who need to verify file hashes.
 func ComputeFileHash(path string) (string, error) {
 	h := hasher.New()
 	return h.ComputeHash(path)
@@ -1,6 +1,7 @@
 package deduplicator
 
 import (
+	"context"
 	"crypto/rand"
 	"os"
 	"path/filepath"
@@ -13,6 +14,7 @@ import (
 	"btidy/internal/testutil"
 	"btidy/pkg/collector"
 	"btidy/pkg/hasher"
+	"btidy/pkg/keeper"
 	"btidy/pkg/metadata"
 	"btidy/pkg/safepath"
 	"btidy/pkg/trash"
@@ -33,6 +35,35 @@ func createTestFileBytes(t *testing.T, path string, content []byte, modTime time
 	testutil.CreateFileBytesWithModTime(t, path, content, modTime)
 }
 
+// newTestDeduplicator returns a deduplicator for root that moves the files
+// it removes to the trash of a duplicate run.
+func newTestDeduplicator(t *testing.T, root string, dryRun bool, opts ...Option) *Deduplicator {
+	t.Helper()
+
+	v, err := safepath.New(root)
+	require.NoError(t, err)
+	metaDir, err := metadata.Init(root, v)
+	require.NoError(t, err)
+	trasher, err := trash.New(metaDir, metaDir.RunID("duplicate"), v)
+	require.NoError(t, err)
+
+	d, err := NewWithValidator(v, dryRun, 1, trasher, opts...)
+	require.NoError(t, err)
+
+	return d
+}
+
+// collectTestFiles collects the files under root, leaving out the .btidy
+// metadata directory.
+func collectTestFiles(t *testing.T, root string) []collector.FileInfo {
+	t.Helper()
+
+	files, err := collector.New(collector.Options{SkipDirs: []string{".btidy"}}).Collect(t.Context(), root)
+	require.NoError(t, err)
+
+	return files
+}
+
 // Test FindDuplicates with no duplicates (all unique content).
 func TestDeduplicator_FindDuplicates_NoDuplicates(t *testing.T) {
 	tmpDir := setupTestDir(t)
@@ -44,7 +75,7 @@ func TestDeduplicator_FindDuplicates_NoDuplicates(t *testing.T) {
 	createTestFile(t, filepath.Join(tmpDir, "file3.txt"), "unique content 3", modTime)
 
 	c := collector.New(collector.Options{})
-	files, err := c.Collect(tmpDir)
+	files, err := c.Collect(t.Context(), tmpDir)
 	require.NoError(t, err)
 
 	d, err := New(tmpDir, true)
@@ -69,7 +100,7 @@ func TestDeduplicator_FindDuplicates_IdenticalContent(t *testing.T) {
 	createTestFile(t, filepath.Join(tmpDir, "duplicate.txt"), content, modTime)
 
 	c := collector.New(collector.Options{})
-	files, err := c.Collect(tmpDir)
+	files, err := c.Collect(t.Context(), tmpDir)
 	require.NoError(t, err)
 	require.Len(t, files, 2)
 
@@ -89,6 +120,36 @@ func TestDeduplicator_FindDuplicates_IdenticalContent(t *testing.T) {
 	require.NoError(t, err)
 }
 
+// Test FindDuplicatesWithProgress stops removing files once canceled.
+func TestDeduplicator_FindDuplicates_StopsWhenCanceled(t *testing.T) {
+	tmpDir := setupTestDir(t)
+
+	modTime := time.Date(2018, 6, 15, 12, 0, 0, 0, time.UTC)
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		createTestFile(t, filepath.Join(tmpDir, name), "same content", modTime)
+	}
+
+	files, err := collector.New(collector.Options{}).Collect(t.Context(), tmpDir)
+	require.NoError(t, err)
+
+	d, err := New(tmpDir, false)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	result := d.FindDuplicatesWithProgress(ctx, files, func(stage string, _, _ int) {
+		if stage == progressStageDeleting {
+			cancel()
+		}
+	})
+
+	require.Len(t, result.Operations, 1, "the removal in progress completes, no other starts")
+	assert.Equal(t, 1, result.DeletedCount)
+	assert.NoFileExists(t, result.Operations[0].Path)
+	assert.FileExists(t, filepath.Join(tmpDir, "a.txt"))
+	assert.FileExists(t, filepath.Join(tmpDir, "c.txt"))
+}
+
 // Test FindDuplicates actually deletes files.
 func TestDeduplicator_FindDuplicates_ActualDelete(t *testing.T) {
 	tmpDir := setupTestDir(t)
@@ -101,7 +162,7 @@ func TestDeduplicator_FindDuplicates_ActualDelete(t *testing.T) {
 	createTestFile(t, filepath.Join(tmpDir, "bbb.txt"), content, modTime)
 
 	c := collector.New(collector.Options{})
-	files, err := c.Collect(tmpDir)
+	files, err := c.Collect(t.Context(), tmpDir)
 	require.NoError(t, err)
 
 	// Real run (not dry run).
@@ -132,7 +193,7 @@ func TestDeduplicator_FindDuplicates_MultipleDuplicates(t *testing.T) {
 	createTestFile(t, filepath.Join(tmpDir, "file4.txt"), content, modTime)
 
 	c := collector.New(collector.Options{})
-	files, err := c.Collect(tmpDir)
+	files, err := c.Collect(t.Context(), tmpDir)
 	require.NoError(t, err)
 	require.Len(t, files, 4)
 
@@ -162,7 +223,7 @@ func TestDeduplicator_FindDuplicates_SameSizeDifferentContent(t *testing.T) {
 	createTestFile(t, filepath.Join(tmpDir, "file2.txt"), "bbbbbbbbbb", modTime)
 
 	c := collector.New(collector.Options{})
-	files, err := c.Collect(tmpDir)
+	files, err := c.Collect(t.Context(), tmpDir)
 	require.NoError(t, err)
 
 	d, err := New(tmpDir, true)
@@ -185,7 +246,7 @@ func TestDeduplicator_FindDuplicates_DifferentSizes(t *testing.T) {
 	createTestFile(t, filepath.Join(tmpDir, "large.txt"), "this is a larger file", modTime)
 
 	c := collector.New(collector.Options{})
-	files, err := c.Collect(tmpDir)
+	files, err := c.Collect(t.Context(), tmpDir)
 	require.NoError(t, err)
 
 	d, err := New(tmpDir, true)
@@ -223,7 +284,7 @@ func TestDeduplicator_FindDuplicates_RealWorldScenario(t *testing.T) {
 	createTestFile(t, filepath.Join(tmpDir, "2017-08-03_4_kills_eco_round_1.flv"), videoContent, modTime)
 
 	c := collector.New(collector.Options{})
-	files, err := c.Collect(tmpDir)
+	files, err := c.Collect(t.Context(), tmpDir)
 	require.NoError(t, err)
 
 	d, err := New(tmpDir, false)
@@ -259,7 +320,7 @@ func TestDeduplicator_FindDuplicates_MixedFiles(t *testing.T) {
 	createTestFile(t, filepath.Join(tmpDir, "doc_copy.pdf"), "document text", modTime)
 
 	c := collector.New(collector.Options{})
-	files, err := c.Collect(tmpDir)
+	files, err := c.Collect(t.Context(), tmpDir)
 	require.NoError(t, err)
 	require.Len(t, files, 6)
 
@@ -293,7 +354,7 @@ func TestDeduplicator_FindDuplicates_Recursive(t *testing.T) {
 	createTestFile(t, filepath.Join(tmpDir, "root.txt"), content, modTime)
 
 	c := collector.New(collector.Options{})
-	files, err := c.Collect(tmpDir)
+	files, err := c.Collect(t.Context(), tmpDir)
 	require.NoError(t, err)
 	require.Len(t, files, 3)
 
@@ -347,7 +408,7 @@ func TestDeduplicator_FindDuplicates_BytesRecovered(t *testing.T) {
 	createTestFile(t, filepath.Join(tmpDir, "c.txt"), content, modTime)
 
 	c := collector.New(collector.Options{})
-	files, err := c.Collect(tmpDir)
+	files, err := c.Collect(t.Context(), tmpDir)
 	require.NoError(t, err)
 
 	d, err := New(tmpDir, false)
@@ -372,7 +433,7 @@ func TestDeduplicator_FindDuplicates_DeterministicOrdering(t *testing.T) {
 	createTestFile(t, filepath.Join(tmpDir, "m.txt"), content, modTime)
 
 	c := collector.New(collector.Options{})
-	files, err := c.Collect(tmpDir)
+	files, err := c.Collect(t.Context(), tmpDir)
 	require.NoError(t, err)
 
 	d, err := New(tmpDir, true)
@@ -456,7 +517,7 @@ func TestDeduplicator_FindDuplicates_LargeFiles(t *testing.T) {
 	createTestFileBytes(t, filepath.Join(tmpDir, "large2.bin"), largeContent, modTime)
 
 	c := collector.New(collector.Options{})
-	files, err := c.Collect(tmpDir)
+	files, err := c.Collect(t.Context(), tmpDir)
 	require.NoError(t, err)
 	require.Len(t, files, 2)
 
@@ -489,7 +550,7 @@ func TestDeduplicator_FindDuplicates_LargeFilesDifferentContent(t *testing.T) {
 	createTestFileBytes(t, filepath.Join(tmpDir, "large2.bin"), content2, modTime)
 
 	c := collector.New(collector.Options{})
-	files, err := c.Collect(tmpDir)
+	files, err := c.Collect(t.Context(), tmpDir)
 	require.NoError(t, err)
 
 	d, err := New(tmpDir, true)
@@ -525,7 +586,7 @@ func TestDeduplicator_FindDuplicates_LargeFilesMiddleDiffers(t *testing.T) {
 	createTestFileBytes(t, filepath.Join(tmpDir, "file2.bin"), content2, modTime)
 
 	c := collector.New(collector.Options{})
-	files, err := c.Collect(tmpDir)
+	files, err := c.Collect(t.Context(), tmpDir)
 	require.NoError(t, err)
 
 	d, err := New(tmpDir, true)
@@ -547,7 +608,7 @@ func TestDeduplicator_FindDuplicates_EmptyFiles(t *testing.T) {
 	createTestFile(t, filepath.Join(tmpDir, "empty2.txt"), "", modTime)
 
 	c := collector.New(collector.Options{})
-	files, err := c.Collect(tmpDir)
+	files, err := c.Collect(t.Context(), tmpDir)
 	require.NoError(t, err)
 
 	d, err := New(tmpDir, false)
@@ -570,7 +631,7 @@ func TestDeduplicator_FindDuplicates_HashInOperations(t *testing.T) {
 	createTestFile(t, filepath.Join(tmpDir, "b.txt"), content, modTime)
 
 	c := collector.New(collector.Options{})
-	files, err := c.Collect(tmpDir)
+	files, err := c.Collect(t.Context(), tmpDir)
 	require.NoError(t, err)
 
 	d, err := New(tmpDir, true)
@@ -578,7 +639,15 @@ func TestDeduplicator_FindDuplicates_HashInOperations(t *testing.T) {
 	result := d.FindDuplicates(files)
 
 	require.Len(t, result.Operations, 1)
-	assert.NotEmpty(t, result.Operations[0].Hash)
+	assert.Regexp(t, `^xxh128:[0-9a-f]{32}$`, result.Operations[0].Hash) // default grouping algorithm
+
+	v, err := safepath.New(tmpDir)
+	require.NoError(t, err)
+	d, err = NewWithValidator(v, true, 1, nil, WithHashAlgorithm(hasher.AlgorithmSHA256))
+	require.NoError(t, err)
+	result = d.FindDuplicates(files)
+
+	require.Len(t, result.Operations, 1)
 	assert.Len(t, result.Operations[0].Hash, 64) // SHA256 hex
 }
 
@@ -594,7 +663,7 @@ func TestDeduplicator_FindDuplicates_OriginalOfReference(t *testing.T) {
 	createTestFile(t, filepath.Join(tmpDir, "duplicate.txt"), content, modTime)
 
 	c := collector.New(collector.Options{})
-	files, err := c.Collect(tmpDir)
+	files, err := c.Collect(t.Context(), tmpDir)
 	require.NoError(t, err)
 
 	d, err := New(tmpDir, true)
@@ -629,7 +698,7 @@ func TestDeduplicator_FindDuplicates_MultipleDuplicateGroups(t *testing.T) {
 	createTestFile(t, filepath.Join(tmpDir, "unique.txt"), "unique", modTime)
 
 	c := collector.New(collector.Options{})
-	files, err := c.Collect(tmpDir)
+	files, err := c.Collect(t.Context(), tmpDir)
 	require.NoError(t, err)
 
 	d, err := New(tmpDir, false)
@@ -683,7 +752,7 @@ func TestDeduplicator_FindDuplicates_KeepsDeterministically(t *testing.T) {
 	createTestFile(t, filepath.Join(tmpDir, "mmm.txt"), content, modTime)
 
 	c := collector.New(collector.Options{})
-	files, err := c.Collect(tmpDir)
+	files, err := c.Collect(t.Context(), tmpDir)
 	require.NoError(t, err)
 
 	d, err := New(tmpDir, false)
@@ -754,7 +823,7 @@ func TestDeduplicator_FindDuplicates_UnsafeSymlinkFailsBeforeDeletes(t *testing.
 	}
 
 	c := collector.New(collector.Options{})
-	files, err := c.Collect(tmpDir)
+	files, err := c.Collect(t.Context(), tmpDir)
 	require.NoError(t, err)
 
 	d, err := New(tmpDir, false)
@@ -796,7 +865,7 @@ func TestDeduplicator_FindDuplicates_TrashesFilesWhenTrasherProvided(t *testing.
 	require.NoError(t, err)
 
 	c := collector.New(collector.Options{SkipDirs: []string{".btidy"}})
-	files, err := c.Collect(tmpDir)
+	files, err := c.Collect(t.Context(), tmpDir)
 	require.NoError(t, err)
 	require.Len(t, files, 2)
 
@@ -866,3 +935,116 @@ func TestDeduplicator_DeleteFile_RefusesWhenContentChanged(t *testing.T) {
 	// Duplicate must still exist on disk.
 	assert.FileExists(t, dupPath, "duplicate must be preserved when content changed")
 }
+
+func TestDeduplicator_DeleteFile_RefusesFastHashCollision(t *testing.T) {
+	tmpDir := setupTestDir(t)
+	modTime := time.Date(2018, 6, 15, 12, 0, 0, 0, time.UTC)
+
+	// Two files of the same size that, as with a crafted XXH128 collision,
+	// are paired by their hash although their bytes differ.
+	originalPath := filepath.Join(tmpDir, "original.txt")
+	dupPath := filepath.Join(tmpDir, "duplicate.txt")
+	createTestFile(t, originalPath, "kept content", modTime)
+	createTestFile(t, dupPath, "other bytes!", modTime)
+
+	v, err := safepath.New(tmpDir)
+	require.NoError(t, err)
+	d, err := NewWithValidator(v, false, 1, nil)
+	require.NoError(t, err)
+
+	dupHash, err := d.hasher.ComputeHash(dupPath)
+	require.NoError(t, err)
+	dupFile := collector.FileInfo{Path: dupPath, Dir: tmpDir, Name: "duplicate.txt", Size: 12, ModTime: modTime}
+
+	op := d.deleteFile(dupFile, originalPath, dupHash)
+	require.ErrorIs(t, op.Error, ErrHashCollision)
+	assert.FileExists(t, dupPath, "a duplicate is only removed once its bytes are confirmed")
+
+	// A cryptographic hash is trusted without the byte comparison.
+	d, err = NewWithValidator(v, false, 1, nil, WithHashAlgorithm(hasher.AlgorithmSHA256))
+	require.NoError(t, err)
+	dupHash, err = d.hasher.ComputeHash(dupPath)
+	require.NoError(t, err)
+
+	op = d.deleteFile(dupFile, originalPath, dupHash)
+	require.NoError(t, op.Error)
+	assert.NoFileExists(t, dupPath)
+}
+
+func TestDeduplicator_FindDuplicates_KeepPolicy(t *testing.T) {
+	old := time.Date(2009, 1, 1, 0, 0, 0, 0, time.UTC)
+	recent := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		policy     keeper.Policy
+		wantKept   string
+		wantReason string
+	}{
+		{
+			name:       "default keeps first lexical",
+			wantKept:   "Backup copy/photo.jpg",
+			wantReason: "first in lexical order",
+		},
+		{
+			name:       "oldest",
+			policy:     keeper.Policy{Strategy: keeper.StrategyOldest},
+			wantKept:   "Photos/2019/trip/photo.jpg",
+			wantReason: "oldest modification time",
+		},
+		{
+			name:       "deepest path",
+			policy:     keeper.Policy{Strategy: keeper.StrategyDeepestPath},
+			wantKept:   "Photos/2019/trip/photo.jpg",
+			wantReason: "deepest path",
+		},
+		{
+			name:       "preferred directory beats strategy",
+			policy:     keeper.Policy{Strategy: keeper.StrategyOldest, PreferDirs: []string{"inbox"}},
+			wantKept:   "inbox/photo.jpg",
+			wantReason: `in preferred directory "inbox"`,
+		},
+		{
+			name:       "avoided directory loses",
+			policy:     keeper.Policy{AvoidDirs: []string{"Backup*"}},
+			wantKept:   "Photos/2019/trip/photo.jpg",
+			wantReason: "outside avoided directories, first in lexical order",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			createTestFile(t, filepath.Join(tmpDir, "Backup copy", "photo.jpg"), "pixels", recent)
+			createTestFile(t, filepath.Join(tmpDir, "Photos", "2019", "trip", "photo.jpg"), "pixels", old)
+			createTestFile(t, filepath.Join(tmpDir, "inbox", "photo.jpg"), "pixels", recent)
+
+			c := collector.New(collector.Options{})
+			files, err := c.Collect(t.Context(), tmpDir)
+			require.NoError(t, err)
+
+			v, err := safepath.New(tmpDir)
+			require.NoError(t, err)
+			d, err := NewWithValidator(v, true, 1, nil, WithKeepPolicy(tt.policy))
+			require.NoError(t, err)
+
+			result := d.FindDuplicates(files)
+			require.Len(t, result.Operations, 2)
+			for _, op := range result.Operations {
+				assert.Equal(t, filepath.Join(tmpDir, filepath.FromSlash(tt.wantKept)), op.OriginalOf)
+				assert.Equal(t, tt.wantReason, op.KeepReason)
+			}
+		})
+	}
+}
+
+func TestNewWithValidator_RejectsInvalidKeepPolicy(t *testing.T) {
+	v, err := safepath.New(t.TempDir())
+	require.NoError(t, err)
+
+	_, err = NewWithValidator(v, true, 1, nil, WithKeepPolicy(keeper.Policy{Strategy: "largest"}))
+	require.Error(t, err)
+
+	_, err = NewWithValidator(v, true, 1, nil, WithKeepPolicy(keeper.Policy{PreferDirs: []string{"[photos"}}))
+	require.Error(t, err)
+}
@@ -0,0 +1,420 @@
+package deduplicator
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"btidy/pkg/collector"
+	"btidy/pkg/hasher"
+	"btidy/pkg/progress"
+)
+
+const progressStageHashingDirs = "hashing directories"
+
+// DirectoryOperation represents removing a directory whose whole content is
+// identical to that of another directory, which is kept.
+type DirectoryOperation struct {
+	Path       string // Directory to remove
+	OriginalOf string // Identical directory that is kept
+	KeepReason string // Why OriginalOf was kept rather than this directory
+	Files      int    // Number of files in the directory, subdirectories included
+	Size       int64  // Total size of those files
+	Hash       string // Merkle hash of the directory, see HashDirectory
+	TrashedTo  string // Trash destination (empty when trasher is nil)
+	Skipped    bool
+	SkipReason string
+	Error      error
+}
+
+// WithDirectories makes the deduplicator look for whole directories with
+// identical content before single files. Of each set of identical
+// directories one is kept, chosen by the keep policy, and every other one
+// is removed as a unit, largest first; the files of removed directories are
+// not considered again. A directory is only removed when its content on
+// disk, files btidy otherwise skips included, still matches the kept one.
+// It cannot be combined with [WithLinkMode].
+func WithDirectories(enabled bool) Option {
+	return func(d *Deduplicator) {
+		d.dirs = enabled
+	}
+}
+
+// dirNode is a directory of the collected tree.
+type dirNode struct {
+	path    string
+	files   []collector.FileInfo // files directly in the directory
+	subdirs []*dirNode
+	count   int   // files in the subtree
+	size    int64 // bytes in the subtree
+	latest  collector.FileInfo
+	hash    string // Merkle hash of the collected subtree; empty when unknown
+}
+
+// findDuplicateDirectories removes every directory identical to another,
+// largest first. It returns the operations and the directories removed, or
+// that would be removed in dry-run mode. Once ctx is done no further
+// directory is removed.
+func (d *Deduplicator) findDuplicateDirectories(ctx context.Context, files []collector.FileInfo, onProgress func(stage string, processed, total int)) ([]DirectoryOperation, []string) {
+	nodes := buildDirTree(d.validator.Root(), files)
+	groups := d.hashDirTree(ctx, nodes, onProgress)
+
+	var ops []DirectoryOperation
+	var removed []string
+	for _, group := range groups {
+		if ctx.Err() != nil {
+			break
+		}
+		members := make([]*dirNode, 0, len(group))
+		for _, node := range group {
+			if !underAny(node.path, removed) {
+				members = append(members, node)
+			}
+		}
+		if len(members) < 2 {
+			continue
+		}
+
+		keep, reason := d.chooseDirectory(members)
+		keptHash, keptErr := HashDirectory(keep.path)
+		for _, node := range members {
+			if node == keep {
+				continue
+			}
+			if ctx.Err() != nil {
+				break
+			}
+
+			op := d.removeDirectory(node, keep, keptHash, keptErr)
+			op.KeepReason = reason
+			ops = append(ops, op)
+			if op.Error == nil && !op.Skipped {
+				removed = append(removed, node.path)
+			}
+		}
+	}
+
+	return ops, removed
+}
+
+// buildDirTree returns the directories under root that hold collected
+// files, root excluded, with their subtree totals.
+func buildDirTree(root string, files []collector.FileInfo) map[string]*dirNode {
+	nodes := make(map[string]*dirNode)
+
+	var nodeFor func(path string) *dirNode
+	nodeFor = func(path string) *dirNode {
+		if node, ok := nodes[path]; ok {
+			return node
+		}
+		node := &dirNode{path: path}
+		nodes[path] = node
+		if parentPath := filepath.Dir(path); parentPath != root && parentPath != path {
+			parent := nodeFor(parentPath)
+			parent.subdirs = append(parent.subdirs, node)
+		}
+		return node
+	}
+
+	for _, file := range files {
+		if file.Dir == root || !isUnder(file.Dir, root) {
+			continue
+		}
+
+		nodeFor(file.Dir).files = append(nodeFor(file.Dir).files, file)
+		for dir := file.Dir; dir != root; dir = filepath.Dir(dir) {
+			node := nodes[dir]
+			node.count++
+			node.size += file.Size
+			if file.ModTime.After(node.latest.ModTime) {
+				node.latest = file
+			}
+		}
+	}
+
+	return nodes
+}
+
+// hashDirTree computes the Merkle hash of every directory that has the
+// same file count and size as another one, and returns the sets of
+// directories with the same hash, largest first.
+func (d *Deduplicator) hashDirTree(ctx context.Context, nodes map[string]*dirNode, onProgress func(stage string, processed, total int)) [][]*dirNode {
+	type shape struct {
+		count int
+		size  int64
+	}
+	byShape := make(map[shape][]*dirNode)
+	for _, node := range nodes {
+		byShape[shape{node.count, node.size}] = append(byShape[shape{node.count, node.size}], node)
+	}
+
+	var candidates []*dirNode
+	for _, group := range byShape {
+		if len(group) > 1 {
+			candidates = append(candidates, group...)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	fileHashes := d.hashSubtreeFiles(ctx, candidates, onProgress)
+
+	byHash := make(map[string][]*dirNode)
+	for _, node := range candidates {
+		if hash := node.merkle(fileHashes); hash != "" {
+			byHash[hash] = append(byHash[hash], node)
+		}
+	}
+
+	groups := make([][]*dirNode, 0, len(byHash))
+	for _, group := range byHash {
+		if len(group) > 1 {
+			sort.Slice(group, func(i, j int) bool { return group[i].path < group[j].path })
+			groups = append(groups, group)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		a, b := groups[i][0], groups[j][0]
+		if a.size != b.size {
+			return a.size > b.size
+		}
+		if a.count != b.count {
+			return a.count > b.count
+		}
+		return a.path < b.path
+	})
+
+	return groups
+}
+
+// hashSubtreeFiles hashes every file under the candidate directories.
+func (d *Deduplicator) hashSubtreeFiles(ctx context.Context, candidates []*dirNode, onProgress func(stage string, processed, total int)) map[string]string {
+	seen := make(map[string]struct{})
+	var toHash []hasher.FileToHash
+	var visit func(node *dirNode)
+	visit = func(node *dirNode) {
+		if _, ok := seen[node.path]; ok {
+			return
+		}
+		seen[node.path] = struct{}{}
+		for _, file := range node.files {
+			toHash = append(toHash, hasher.FileToHash{Path: file.Path, Size: file.Size})
+		}
+		for _, sub := range node.subdirs {
+			visit(sub)
+		}
+	}
+	for _, node := range candidates {
+		visit(node)
+	}
+
+	hashes := make(map[string]string, len(toHash))
+	processed := 0
+	for result := range d.hasher.HashFilesWithSizes(ctx, toHash) {
+		processed++
+		progress.EmitStage(onProgress, progressStageHashingDirs, processed, len(toHash))
+		if result.Error == nil {
+			hashes[result.Path] = result.Hash
+		}
+	}
+
+	return hashes
+}
+
+// merkle returns the Merkle hash of the collected subtree of node, or ""
+// when a file in it could not be hashed.
+func (n *dirNode) merkle(fileHashes map[string]string) string {
+	if n.hash != "" {
+		return n.hash
+	}
+
+	entries := make([]merkleEntry, 0, len(n.files)+len(n.subdirs))
+	for _, file := range n.files {
+		hash, ok := fileHashes[file.Path]
+		if !ok {
+			return ""
+		}
+		entries = append(entries, merkleEntry{kind: 'f', name: file.Name, hash: hash})
+	}
+	for _, sub := range n.subdirs {
+		hash := sub.merkle(fileHashes)
+		if hash == "" {
+			return ""
+		}
+		entries = append(entries, merkleEntry{kind: 'd', name: filepath.Base(sub.path), hash: hash})
+	}
+
+	n.hash = merkleHash(entries)
+	return n.hash
+}
+
+// chooseDirectory picks the directory to keep among identical ones with
+// the keep policy. A directory is ranked as if it were its most recently
+// modified file, placed in the directory itself, so directory patterns
+// match the directory and its parents, and modification-time strategies
+// compare the newest file of each copy.
+func (d *Deduplicator) chooseDirectory(nodes []*dirNode) (*dirNode, string) {
+	byPath := make(map[string]*dirNode, len(nodes))
+	infos := make([]collector.FileInfo, len(nodes))
+	for i, node := range nodes {
+		byPath[node.path] = node
+		infos[i] = collector.FileInfo{
+			Path:    node.path,
+			Dir:     node.path,
+			Name:    filepath.Base(node.path),
+			ModTime: node.latest.ModTime,
+		}
+	}
+
+	reason := d.policy.Choose(d.validator.Root(), infos)
+	return byPath[infos[0].Path], reason
+}
+
+// removeDirectory creates a directory operation and performs it unless in
+// dry-run mode. The directory must match the kept one on disk, whose
+// Merkle hash is keptHash, or the operation is skipped.
+func (d *Deduplicator) removeDirectory(node, keep *dirNode, keptHash string, keptErr error) DirectoryOperation {
+	op := DirectoryOperation{
+		Path:       node.path,
+		OriginalOf: keep.path,
+		Files:      node.count,
+		Size:       node.size,
+	}
+
+	if err := d.validator.ValidatePathForWrite(op.Path); err != nil {
+		op.Error = fmt.Errorf("path escapes root: %w", err)
+		return op
+	}
+	if keptErr != nil {
+		op.Error = fmt.Errorf("hash kept directory: %w", keptErr)
+		return op
+	}
+
+	hash, err := HashDirectory(op.Path)
+	if err != nil {
+		op.Error = fmt.Errorf("hash directory: %w", err)
+		return op
+	}
+	op.Hash = hash
+	if hash != keptHash {
+		op.Skipped = true
+		op.SkipReason = "content on disk differs from the kept directory"
+		return op
+	}
+
+	if d.dryRun {
+		return op
+	}
+
+	if d.trasher != nil {
+		op.TrashedTo, op.Error = d.trasher.TrashWithDest(op.Path)
+		return op
+	}
+
+	if err := os.RemoveAll(op.Path); err != nil {
+		op.Error = fmt.Errorf("failed to delete directory: %w", err)
+	}
+
+	return op
+}
+
+// merkleEntry is an entry of a directory in its Merkle hash.
+type merkleEntry struct {
+	kind byte // 'f' regular file, 'd' directory, 'l' symlink
+	name string
+	hash string
+}
+
+// merkleHash hashes the entries of a directory sorted by name. The name of
+// the directory itself is not part of its hash.
+func merkleHash(entries []merkleEntry) string {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+
+	h := sha256.New()
+	for _, entry := range entries {
+		fmt.Fprintf(h, "%c %s %s\x00", entry.kind, entry.hash, entry.name)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// HashDirectory computes the Merkle hash of the directory at path from
+// what is on disk: the SHA256 of every regular file, the target of every
+// symlink, and the names of all entries, subdirectories recursively. Two
+// directories have the same hash when their content is identical, whatever
+// their own names. Other file types, such as devices, are an error.
+func HashDirectory(path string) (string, error) {
+	dirEntries, err := os.ReadDir(path)
+	if err != nil {
+		return "", err
+	}
+
+	h := hasher.New()
+	entries := make([]merkleEntry, 0, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+		entryPath := filepath.Join(path, dirEntry.Name())
+
+		var entry merkleEntry
+		switch mode := dirEntry.Type(); {
+		case mode.IsDir():
+			entry.kind = 'd'
+			entry.hash, err = HashDirectory(entryPath)
+		case mode&os.ModeSymlink != 0:
+			var target string
+			target, err = os.Readlink(entryPath)
+			entry.kind = 'l'
+			if err == nil {
+				entry.hash, err = h.ComputeDataHash([]byte(target))
+			}
+		case mode.IsRegular():
+			entry.kind = 'f'
+			entry.hash, err = h.ComputeHash(entryPath)
+		default:
+			err = errors.New("unsupported file type: " + entryPath)
+		}
+		if err != nil {
+			return "", err
+		}
+
+		entry.name = dirEntry.Name()
+		entries = append(entries, entry)
+	}
+
+	return merkleHash(entries), nil
+}
+
+// isUnder reports whether path is dir or inside it.
+func isUnder(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
+
+// underAny reports whether path is one of dirs or inside one of them.
+func underAny(path string, dirs []string) bool {
+	for _, dir := range dirs {
+		if isUnder(path, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutDirectories returns the files that are not in any of dirs.
+func withoutDirectories(files []collector.FileInfo, dirs []string) []collector.FileInfo {
+	if len(dirs) == 0 {
+		return files
+	}
+
+	kept := make([]collector.FileInfo, 0, len(files))
+	for _, file := range files {
+		if !underAny(file.Dir, dirs) {
+			kept = append(kept, file)
+		}
+	}
+	return kept
+}
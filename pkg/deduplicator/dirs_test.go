@@ -0,0 +1,144 @@
+package deduplicator
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"btidy/pkg/keeper"
+	"btidy/pkg/safepath"
+)
+
+// dirsFixture writes 2019-trip/ and an identical "copy of 2019-trip/",
+// each holding a.jpg and day2/b.jpg, and other/, which holds another copy
+// of a.jpg next to a file of its own.
+func dirsFixture(t *testing.T) string {
+	t.Helper()
+
+	root := t.TempDir()
+	modTime := time.Date(2019, 7, 1, 12, 0, 0, 0, time.UTC)
+	for _, dir := range []string{"2019-trip", "copy of 2019-trip"} {
+		createTestFile(t, filepath.Join(root, dir, "a.jpg"), "beach", modTime)
+		createTestFile(t, filepath.Join(root, dir, "day2", "b.jpg"), "mountain", modTime)
+	}
+	createTestFile(t, filepath.Join(root, "other", "a.jpg"), "beach", modTime)
+	createTestFile(t, filepath.Join(root, "other", "c.txt"), "notes", modTime)
+
+	return root
+}
+
+// newDirsDeduplicator returns a [newTestDeduplicator] that also matches
+// whole directories.
+func newDirsDeduplicator(t *testing.T, root string, dryRun bool, opts ...Option) *Deduplicator {
+	t.Helper()
+
+	return newTestDeduplicator(t, root, dryRun, append([]Option{WithDirectories(true)}, opts...)...)
+}
+
+func TestDeduplicator_FindDuplicates_Directories(t *testing.T) {
+	root := dirsFixture(t)
+	d := newDirsDeduplicator(t, root, false)
+
+	result := d.FindDuplicatesWithProgress(t.Context(), collectTestFiles(t, root), nil)
+
+	require.Len(t, result.DirOperations, 1, "the nested day2 copies go with their parents")
+	op := result.DirOperations[0]
+	require.NoError(t, op.Error)
+	assert.Equal(t, filepath.Join(root, "copy of 2019-trip"), op.Path)
+	assert.Equal(t, filepath.Join(root, "2019-trip"), op.OriginalOf)
+	assert.Equal(t, "first in lexical order", op.KeepReason)
+	assert.Equal(t, 2, op.Files)
+	assert.Equal(t, int64(len("beach")+len("mountain")), op.Size)
+	assert.NotEmpty(t, op.Hash)
+	assert.NoDirExists(t, op.Path)
+	assert.FileExists(t, filepath.Join(op.TrashedTo, "day2", "b.jpg"), "the directory is trashed as a unit")
+
+	require.Len(t, result.Operations, 1, "files outside identical directories are deduplicated one by one")
+	assert.Equal(t, filepath.Join(root, "other", "a.jpg"), result.Operations[0].Path)
+	assert.Equal(t, filepath.Join(root, "2019-trip", "a.jpg"), result.Operations[0].OriginalOf)
+
+	assert.Equal(t, 1, result.DirDuplicatesFound)
+	assert.Equal(t, 1, result.DirsDeletedCount)
+	assert.Equal(t, 1, result.DeletedCount)
+	assert.Equal(t, int64(len("beach")*2+len("mountain")), result.BytesRecovered)
+	assert.FileExists(t, filepath.Join(root, "2019-trip", "day2", "b.jpg"))
+	assert.FileExists(t, filepath.Join(root, "other", "c.txt"))
+}
+
+func TestDeduplicator_FindDuplicates_DirectoriesFollowKeepPolicy(t *testing.T) {
+	root := dirsFixture(t)
+	d := newDirsDeduplicator(t, root, true, WithKeepPolicy(keeper.Policy{PreferDirs: []string{"copy*"}}))
+
+	result := d.FindDuplicatesWithProgress(t.Context(), collectTestFiles(t, root), nil)
+
+	require.Len(t, result.DirOperations, 1)
+	assert.Equal(t, filepath.Join(root, "2019-trip"), result.DirOperations[0].Path)
+	assert.Equal(t, `in preferred directory "copy*"`, result.DirOperations[0].KeepReason)
+}
+
+func TestDeduplicator_FindDuplicates_DirectoriesDryRun(t *testing.T) {
+	root := dirsFixture(t)
+	d := newDirsDeduplicator(t, root, true)
+
+	result := d.FindDuplicatesWithProgress(t.Context(), collectTestFiles(t, root), nil)
+
+	assert.Equal(t, 1, result.DirsDeletedCount)
+	assert.Len(t, result.Operations, 1, "files of a directory planned for removal are not listed again")
+	assert.DirExists(t, filepath.Join(root, "copy of 2019-trip"))
+	assert.FileExists(t, filepath.Join(root, "other", "a.jpg"))
+}
+
+func TestDeduplicator_FindDuplicates_DirectoryWithUncollectedFileIsKept(t *testing.T) {
+	root := dirsFixture(t)
+	files := collectTestFiles(t, root)
+	// A file the collector would skip, such as .DS_Store, still makes the
+	// directories differ on disk.
+	createTestFile(t, filepath.Join(root, "copy of 2019-trip", ".DS_Store"), "finder", time.Now())
+
+	d := newDirsDeduplicator(t, root, false)
+	result := d.FindDuplicatesWithProgress(t.Context(), files, nil)
+
+	require.NotEmpty(t, result.DirOperations)
+	assert.True(t, result.DirOperations[0].Skipped)
+	assert.Equal(t, "content on disk differs from the kept directory", result.DirOperations[0].SkipReason)
+	assert.FileExists(t, filepath.Join(root, "copy of 2019-trip", ".DS_Store"))
+	assert.NoFileExists(t, filepath.Join(root, "copy of 2019-trip", "a.jpg"), "the files are deduplicated one by one instead")
+}
+
+func TestDeduplicator_RejectsDirectoriesWithLinks(t *testing.T) {
+	v, err := safepath.New(t.TempDir())
+	require.NoError(t, err)
+
+	_, err = NewWithValidator(v, true, 1, nil, WithDirectories(true), WithLinkMode(LinkHard))
+	require.Error(t, err)
+}
+
+func TestHashDirectory(t *testing.T) {
+	root := t.TempDir()
+	modTime := time.Now()
+	for _, dir := range []string{"a", "b", "c"} {
+		createTestFile(t, filepath.Join(root, dir, "x.txt"), "x", modTime)
+		createTestFile(t, filepath.Join(root, dir, "sub", "y.txt"), "y", modTime)
+	}
+	require.NoError(t, os.Rename(filepath.Join(root, "c", "sub", "y.txt"), filepath.Join(root, "c", "sub", "z.txt")))
+	require.NoError(t, os.Symlink("x.txt", filepath.Join(root, "b", "link")))
+
+	hashA, err := HashDirectory(filepath.Join(root, "a"))
+	require.NoError(t, err)
+	hashB, err := HashDirectory(filepath.Join(root, "b"))
+	require.NoError(t, err)
+	hashC, err := HashDirectory(filepath.Join(root, "c"))
+	require.NoError(t, err)
+
+	assert.NotEqual(t, hashA, hashB, "a symlink is part of the content")
+	assert.NotEqual(t, hashA, hashC, "names are part of the content")
+
+	require.NoError(t, os.Remove(filepath.Join(root, "b", "link")))
+	hashB, err = HashDirectory(filepath.Join(root, "b"))
+	require.NoError(t, err)
+	assert.Equal(t, hashA, hashB, "the name of the directory itself is not")
+}
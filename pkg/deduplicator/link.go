@@ -0,0 +1,136 @@
+package deduplicator
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// LinkMode selects how a duplicate is replaced when it is not simply removed.
+type LinkMode string
+
+const (
+	// LinkNone removes duplicates. It is the default.
+	LinkNone LinkMode = ""
+
+	// LinkHard replaces each duplicate with a hard link to the kept file.
+	LinkHard LinkMode = "hard"
+
+	// LinkReflink replaces each duplicate with a copy-on-write clone of the
+	// kept file (FICLONE on Linux, supported by btrfs and XFS). Unlike a
+	// hard link the clone stays an independent file that shares storage
+	// until either copy is written.
+	LinkReflink LinkMode = "reflink"
+)
+
+var (
+	// ErrCrossDevice is returned when a duplicate cannot be linked because
+	// it lives on a different device than the kept file.
+	ErrCrossDevice = errors.New("duplicate is on a different device than the kept file")
+
+	// ErrReflinkUnsupported is returned when the filesystem, or the
+	// platform, cannot clone files.
+	ErrReflinkUnsupported = errors.New("filesystem does not support reflinks")
+
+	// errAlreadyLinked is returned by linkFile when the duplicate is
+	// already a hard link to the kept file.
+	errAlreadyLinked = errors.New("already a hard link to the kept file")
+)
+
+// ParseLinkMode converts a command-line value into a [LinkMode]. An empty
+// value selects [LinkNone].
+func ParseLinkMode(value string) (LinkMode, error) {
+	switch mode := LinkMode(value); mode {
+	case LinkNone, LinkHard, LinkReflink:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid link mode %q (want %s or %s)", value, LinkHard, LinkReflink)
+	}
+}
+
+// WithLinkMode makes the deduplicator replace duplicates with links to the
+// kept file instead of removing them, so every path keeps existing. The
+// duplicate itself is trashed, so undo can restore it as an independent
+// copy.
+func WithLinkMode(mode LinkMode) Option {
+	return func(d *Deduplicator) {
+		d.link = mode
+	}
+}
+
+// linkFile replaces the file at path with a link to keptPath. The link is
+// made under a temporary name next to path first, so a link the filesystem
+// refuses leaves path untouched. It returns where the replaced file was
+// trashed (empty on hard delete).
+func (d *Deduplicator) linkFile(path, keptPath string) (string, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return "", fmt.Errorf("stat duplicate: %w", err)
+	}
+
+	if d.link == LinkHard {
+		if keptInfo, statErr := os.Stat(keptPath); statErr == nil && os.SameFile(info, keptInfo) {
+			return "", errAlreadyLinked
+		}
+	}
+
+	tmpPath := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".btidy-link")
+	if err := d.validator.ValidatePathForWrite(tmpPath); err != nil {
+		return "", fmt.Errorf("link path escapes root: %w", err)
+	}
+
+	if err := d.makeLink(keptPath, tmpPath, info); err != nil {
+		return "", err
+	}
+
+	trashedTo, err := d.trashOrRemove(path)
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return "", err
+	}
+
+	if err := d.validator.SafeRename(tmpPath, path); err != nil {
+		_ = os.Remove(tmpPath)
+		if trashedTo != "" {
+			if restoreErr := d.trasher.Restore(trashedTo); restoreErr != nil {
+				return trashedTo, fmt.Errorf("failed to put link in place: %w (duplicate left in trash: %w)", err, restoreErr)
+			}
+		}
+		return "", fmt.Errorf("failed to put link in place: %w", err)
+	}
+
+	return trashedTo, nil
+}
+
+// makeLink creates linkPath as a link of the configured mode to keptPath.
+// A reflink keeps the permission bits and modification time of the
+// duplicate it replaces, described by info.
+func (d *Deduplicator) makeLink(keptPath, linkPath string, info os.FileInfo) error {
+	var err error
+	switch d.link {
+	case LinkHard:
+		err = os.Link(keptPath, linkPath)
+	case LinkReflink:
+		err = reflink(keptPath, linkPath, info.Mode().Perm())
+		if err == nil {
+			err = os.Chtimes(linkPath, info.ModTime(), info.ModTime())
+		}
+	case LinkNone:
+		err = errors.New("no link mode configured")
+	}
+	if err == nil {
+		return nil
+	}
+
+	_ = os.Remove(linkPath)
+	if errors.Is(err, syscall.EXDEV) {
+		return ErrCrossDevice
+	}
+	if errors.Is(err, ErrReflinkUnsupported) {
+		return ErrReflinkUnsupported
+	}
+
+	return fmt.Errorf("failed to create %s link: %w", d.link, err)
+}
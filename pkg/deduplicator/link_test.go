@@ -0,0 +1,123 @@
+package deduplicator
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"btidy/pkg/collector"
+	"btidy/pkg/safepath"
+)
+
+// linkFixture writes keep.txt and x-copy/dup.txt with the same content to
+// a new directory and returns it with a deduplicator that links in mode.
+func linkFixture(t *testing.T, mode LinkMode) (string, *Deduplicator) {
+	t.Helper()
+
+	tmpDir := t.TempDir()
+	createTestFile(t, filepath.Join(tmpDir, "keep.txt"), "identical content", time.Date(2018, 6, 15, 12, 0, 0, 0, time.UTC))
+	createTestFile(t, filepath.Join(tmpDir, "x-copy", "dup.txt"), "identical content", time.Date(2009, 1, 2, 3, 4, 5, 0, time.UTC))
+
+	return tmpDir, newTestDeduplicator(t, tmpDir, false, WithLinkMode(mode))
+}
+
+func TestParseLinkMode(t *testing.T) {
+	for _, value := range []string{"", "hard", "reflink"} {
+		mode, err := ParseLinkMode(value)
+		require.NoError(t, err)
+		assert.Equal(t, LinkMode(value), mode)
+	}
+
+	_, err := ParseLinkMode("soft")
+	require.Error(t, err)
+}
+
+func TestDeduplicator_FindDuplicates_LinkHard(t *testing.T) {
+	tmpDir, d := linkFixture(t, LinkHard)
+	keptPath := filepath.Join(tmpDir, "keep.txt")
+	dupPath := filepath.Join(tmpDir, "x-copy", "dup.txt")
+
+	result := d.FindDuplicates(collectTestFiles(t, tmpDir))
+	assert.Equal(t, 1, result.LinkedCount)
+	assert.Zero(t, result.DeletedCount)
+	assert.Equal(t, int64(len("identical content")), result.BytesRecovered)
+
+	require.Len(t, result.Operations, 1)
+	op := result.Operations[0]
+	require.NoError(t, op.Error)
+	assert.Equal(t, LinkHard, op.Link)
+	assert.Equal(t, keptPath, op.OriginalOf)
+
+	keptInfo, err := os.Stat(keptPath)
+	require.NoError(t, err)
+	dupInfo, err := os.Stat(dupPath)
+	require.NoError(t, err)
+	assert.True(t, os.SameFile(keptInfo, dupInfo), "the duplicate path is a hard link to the kept file")
+
+	trashed, err := os.ReadFile(op.TrashedTo)
+	require.NoError(t, err)
+	assert.Equal(t, "identical content", string(trashed), "the replaced duplicate is kept in trash")
+	assert.NoFileExists(t, filepath.Join(tmpDir, "x-copy", ".dup.txt.btidy-link"))
+
+	// A second run finds the two names of one file and leaves them alone.
+	result = d.FindDuplicates(collectTestFiles(t, tmpDir))
+	require.Len(t, result.Operations, 1)
+	assert.True(t, result.Operations[0].Skipped)
+	assert.Equal(t, "already a hard link to the kept file", result.Operations[0].SkipReason)
+}
+
+func TestDeduplicator_FindDuplicates_LinkReflink(t *testing.T) {
+	tmpDir, d := linkFixture(t, LinkReflink)
+	dupPath := filepath.Join(tmpDir, "x-copy", "dup.txt")
+
+	result := d.FindDuplicates(collectTestFiles(t, tmpDir))
+	require.Len(t, result.Operations, 1)
+	op := result.Operations[0]
+	require.NoError(t, op.Error)
+
+	if op.Skipped {
+		// Most test filesystems cannot clone; the duplicate must be untouched.
+		assert.Equal(t, ErrReflinkUnsupported.Error(), op.SkipReason)
+		assert.Zero(t, result.LinkedCount)
+		assert.Empty(t, op.TrashedTo)
+		assert.FileExists(t, dupPath)
+		return
+	}
+
+	assert.Equal(t, LinkReflink, op.Link)
+	info, err := os.Stat(dupPath)
+	require.NoError(t, err)
+	assert.Equal(t, 2009, info.ModTime().Year(), "a clone keeps the duplicate's modification time")
+	keptInfo, err := os.Stat(filepath.Join(tmpDir, "keep.txt"))
+	require.NoError(t, err)
+	assert.False(t, os.SameFile(keptInfo, info), "a clone is an independent file")
+}
+
+func TestDeduplicator_FindDuplicates_LinkDryRun(t *testing.T) {
+	tmpDir := t.TempDir()
+	createTestFile(t, filepath.Join(tmpDir, "a.txt"), "same", time.Now())
+	createTestFile(t, filepath.Join(tmpDir, "b.txt"), "same", time.Now())
+
+	v, err := safepath.New(tmpDir)
+	require.NoError(t, err)
+	d, err := NewWithValidator(v, true, 1, nil, WithLinkMode(LinkHard))
+	require.NoError(t, err)
+
+	files, err := collector.New(collector.Options{}).Collect(t.Context(), tmpDir)
+	require.NoError(t, err)
+	result := d.FindDuplicates(files)
+
+	assert.Equal(t, 1, result.LinkedCount)
+	require.Len(t, result.Operations, 1)
+	assert.Equal(t, LinkHard, result.Operations[0].Link)
+
+	aInfo, err := os.Stat(filepath.Join(tmpDir, "a.txt"))
+	require.NoError(t, err)
+	bInfo, err := os.Stat(filepath.Join(tmpDir, "b.txt"))
+	require.NoError(t, err)
+	assert.False(t, os.SameFile(aInfo, bInfo))
+}
@@ -0,0 +1,212 @@
+package deduplicator
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sort"
+
+	"btidy/pkg/collector"
+	"btidy/pkg/hasher"
+	"btidy/pkg/manifest"
+	"btidy/pkg/progress"
+	"btidy/pkg/safepath"
+)
+
+const progressStageReference = "hashing reference"
+
+// referenceKeepReason is the keep reason of a file matched in a reference.
+const referenceKeepReason = "in the reference"
+
+// Reference is a read-only set of files, outside the tree being
+// deduplicated, whose content counts as already kept. Files of the
+// reference are never touched.
+type Reference struct {
+	// hashes maps content hashes to the reference paths holding them.
+	hashes map[string][]string
+
+	// sizes holds the size of every reference file, so target files no
+	// reference file could match are not hashed.
+	sizes map[int64]struct{}
+
+	// pending are directory files not hashed yet; only those whose size
+	// matches a target file are hashed.
+	pending []collector.FileInfo
+
+	// mounted reports whether the reference paths are on disk, so a match
+	// can be checked to still exist before its copy is trashed.
+	mounted bool
+
+	// algorithms are the hash algorithms of a manifest reference. Files of
+	// the target are hashed with each of them to be matched. A directory
+	// reference is hashed with the algorithm of the deduplicator.
+	algorithms []hasher.Algorithm
+}
+
+// NewManifestReference returns the files of m as a reference. Their
+// content is taken from the manifest hashes, so the tree the manifest was
+// made from does not have to be available; its paths are named under the
+// manifest's root path. The manifest may have been hashed with any
+// algorithm.
+func NewManifestReference(m *manifest.Manifest) *Reference {
+	ref := &Reference{
+		hashes:     make(map[string][]string),
+		sizes:      make(map[int64]struct{}, len(m.Entries)),
+		algorithms: m.Algorithms(),
+	}
+
+	for hash, paths := range m.HashIndex() {
+		for _, path := range paths {
+			ref.hashes[hash] = append(ref.hashes[hash], filepath.Join(m.RootPath, filepath.FromSlash(path)))
+		}
+	}
+	for _, entry := range m.Entries {
+		ref.sizes[entry.Size] = struct{}{}
+	}
+
+	return ref
+}
+
+// NewDirReference returns files, collected from a reference directory, as
+// a reference. They are hashed on demand, and only when a file of the
+// target has the same size.
+func NewDirReference(files []collector.FileInfo) *Reference {
+	ref := &Reference{
+		hashes:  make(map[string][]string),
+		sizes:   make(map[int64]struct{}, len(files)),
+		pending: files,
+		mounted: true,
+	}
+	for _, file := range files {
+		ref.sizes[file.Size] = struct{}{}
+	}
+
+	return ref
+}
+
+// hashPending hashes the pending directory files whose size is in sizes.
+func (r *Reference) hashPending(ctx context.Context, h *hasher.Hasher, sizes map[int64]struct{}, onProgress func(stage string, processed, total int)) {
+	toHash := make([]hasher.FileToHash, 0, len(r.pending))
+	for _, file := range r.pending {
+		if _, ok := sizes[file.Size]; ok {
+			toHash = append(toHash, hasher.FileToHash{Path: file.Path, Size: file.Size})
+		}
+	}
+	r.pending = nil
+
+	processed := 0
+	for result := range h.HashFilesWithSizes(ctx, toHash) {
+		processed++
+		progress.EmitStage(onProgress, progressStageReference, processed, len(toHash))
+
+		if result.Error == nil {
+			r.hashes[result.Hash] = append(r.hashes[result.Hash], result.Path)
+		}
+	}
+
+	for hash := range r.hashes {
+		sort.Strings(r.hashes[hash])
+	}
+}
+
+// FindDuplicatesAgainst removes every file whose content matches a file of
+// ref, which is kept and never touched. Files that only duplicate each
+// other are left alone. Operations name the matching reference file in
+// OriginalOf and are marked with Reference. Once ctx is done no further
+// file is removed.
+func (d *Deduplicator) FindDuplicatesAgainst(
+	ctx context.Context,
+	files []collector.FileInfo,
+	ref *Reference,
+	onProgress func(stage string, processed, total int),
+) Result {
+	result := Result{
+		TotalFiles: len(files),
+		Operations: make([]DeleteOperation, 0),
+	}
+
+	if len(files) == 0 {
+		return result
+	}
+
+	safeFiles, invalidReadOps := safepath.ValidateReadPaths(d.validator, files, func(file collector.FileInfo, err error) DeleteOperation {
+		return DeleteOperation{
+			Path:  file.Path,
+			Size:  file.Size,
+			Error: fmt.Errorf("path escapes root: %w", err),
+		}
+	})
+	if len(invalidReadOps) > 0 {
+		result.Operations = append(result.Operations, invalidReadOps...)
+		sortOperations(result.Operations)
+		result.calculateCounts()
+		return result
+	}
+
+	// Only files with the size of a reference file can match one.
+	candidates := make([]collector.FileInfo, 0, len(safeFiles))
+	sizes := make(map[int64]struct{})
+	for _, file := range safeFiles {
+		if _, ok := ref.sizes[file.Size]; ok {
+			candidates = append(candidates, file)
+			sizes[file.Size] = struct{}{}
+		}
+	}
+	if len(ref.pending) > 0 {
+		ref.hashPending(ctx, d.hasher, sizes, onProgress)
+	}
+
+	algorithms := ref.algorithms
+	if ref.mounted {
+		algorithms = []hasher.Algorithm{d.hasher.Algorithm()}
+	} else {
+		// The files of a manifest cannot be compared byte for byte, so
+		// only its cryptographic hashes are trusted to match.
+		algorithms = slices.DeleteFunc(slices.Clone(algorithms), func(algo hasher.Algorithm) bool {
+			return !algo.Cryptographic()
+		})
+	}
+
+	var matches []DeleteOperation
+	matched := make(map[string]struct{})
+	for _, algo := range algorithms {
+		hashGroups := d.groupFilesByHash(ctx, candidates, d.hasher.Using(algo).HashFilesWithSizes, progressStageHashing, onProgress)
+		for hash, group := range hashGroups {
+			paths, ok := ref.hashes[hash]
+			if !ok {
+				continue
+			}
+			for _, file := range group {
+				if _, ok := matched[file.Path]; ok {
+					continue
+				}
+				matched[file.Path] = struct{}{}
+				matches = append(matches, DeleteOperation{Path: file.Path, OriginalOf: paths[0], Size: file.Size, Hash: hash})
+			}
+		}
+	}
+	sortOperations(matches)
+
+	for i := range matches {
+		if ctx.Err() != nil {
+			break
+		}
+		result.Operations = append(result.Operations, d.deleteReferenceMatch(matches[i], ref))
+		progress.EmitStage(onProgress, progressStageDeleting, i+1, len(matches))
+	}
+
+	result.calculateCounts()
+
+	return result
+}
+
+// deleteReferenceMatch removes the file of op, whose content is held by
+// the reference file op.OriginalOf. A reference on disk must still hold
+// that file; one read from a manifest is trusted.
+func (d *Deduplicator) deleteReferenceMatch(op DeleteOperation, ref *Reference) DeleteOperation {
+	op.Reference = true
+	op.KeepReason = referenceKeepReason
+
+	return d.removeDuplicate(op, ref.mounted)
+}
@@ -0,0 +1,138 @@
+package deduplicator
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"btidy/pkg/hasher"
+	"btidy/pkg/manifest"
+	"btidy/pkg/safepath"
+)
+
+// referenceFixture writes a reference directory holding archived.txt and a
+// target directory holding a copy of it, two files that only duplicate
+// each other, and a file of the same size as the reference file but other
+// content. It returns both directories with a deduplicator for the target.
+func referenceFixture(t *testing.T) (refDir, targetDir string, d *Deduplicator) {
+	t.Helper()
+
+	modTime := time.Date(2018, 6, 15, 12, 0, 0, 0, time.UTC)
+	refDir = t.TempDir()
+	targetDir = t.TempDir()
+	createTestFile(t, filepath.Join(refDir, "2018", "archived.txt"), "archived content", modTime)
+	createTestFile(t, filepath.Join(targetDir, "copy of archived.txt"), "archived content", modTime)
+	createTestFile(t, filepath.Join(targetDir, "a.txt"), "only in the target", modTime)
+	createTestFile(t, filepath.Join(targetDir, "b.txt"), "only in the target", modTime)
+	createTestFile(t, filepath.Join(targetDir, "same-size.txt"), "ARCHIVED CONTENT", modTime)
+
+	return refDir, targetDir, newTestDeduplicator(t, targetDir, false)
+}
+
+func assertOnlyArchivedCopyRemoved(t *testing.T, result Result, refPath, targetDir string) {
+	t.Helper()
+
+	require.Len(t, result.Operations, 1)
+	op := result.Operations[0]
+	require.NoError(t, op.Error)
+	assert.Equal(t, filepath.Join(targetDir, "copy of archived.txt"), op.Path)
+	assert.Equal(t, refPath, op.OriginalOf)
+	assert.True(t, op.Reference)
+	assert.Equal(t, "in the reference", op.KeepReason)
+	assert.NotEmpty(t, op.TrashedTo)
+	assert.Equal(t, 1, result.DeletedCount)
+	assert.Equal(t, 1, result.DuplicatesFound)
+
+	assert.NoFileExists(t, op.Path)
+	assert.FileExists(t, filepath.Join(targetDir, "a.txt"), "duplicates within the target are left alone")
+	assert.FileExists(t, filepath.Join(targetDir, "b.txt"))
+	assert.FileExists(t, filepath.Join(targetDir, "same-size.txt"))
+}
+
+func TestDeduplicator_FindDuplicatesAgainst_Directory(t *testing.T) {
+	refDir, targetDir, d := referenceFixture(t)
+	refPath := filepath.Join(refDir, "2018", "archived.txt")
+
+	ref := NewDirReference(collectTestFiles(t, refDir))
+	result := d.FindDuplicatesAgainst(t.Context(), collectTestFiles(t, targetDir), ref, nil)
+
+	assertOnlyArchivedCopyRemoved(t, result, refPath, targetDir)
+	content, err := os.ReadFile(refPath)
+	require.NoError(t, err)
+	assert.Equal(t, "archived content", string(content), "the reference is never touched")
+}
+
+func TestDeduplicator_FindDuplicatesAgainst_ManifestOfUnmountedTree(t *testing.T) {
+	refDir, targetDir, d := referenceFixture(t)
+
+	gen, err := manifest.NewGenerator(refDir, 1)
+	require.NoError(t, err)
+	m, err := gen.Generate(t.Context(), manifest.GenerateOptions{})
+	require.NoError(t, err)
+	require.NoError(t, os.RemoveAll(refDir))
+
+	result := d.FindDuplicatesAgainst(t.Context(), collectTestFiles(t, targetDir), NewManifestReference(m), nil)
+
+	assertOnlyArchivedCopyRemoved(t, result, filepath.Join(m.RootPath, "2018", "archived.txt"), targetDir)
+}
+
+func TestDeduplicator_FindDuplicatesAgainst_ManifestOfOtherAlgorithm(t *testing.T) {
+	refDir, targetDir, d := referenceFixture(t)
+	require.Equal(t, DefaultHashAlgorithm, d.hasher.Algorithm())
+
+	v, err := safepath.New(refDir)
+	require.NoError(t, err)
+	gen, err := manifest.NewGeneratorWithValidator(v, 1, hasher.WithAlgorithm(hasher.AlgorithmBLAKE3))
+	require.NoError(t, err)
+	m, err := gen.Generate(t.Context(), manifest.GenerateOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []hasher.Algorithm{hasher.AlgorithmBLAKE3}, m.Algorithms())
+
+	result := d.FindDuplicatesAgainst(t.Context(), collectTestFiles(t, targetDir), NewManifestReference(m), nil)
+
+	assertOnlyArchivedCopyRemoved(t, result, filepath.Join(refDir, "2018", "archived.txt"), targetDir)
+	assert.Regexp(t, `^blake3:`, result.Operations[0].Hash, "the match is recorded with the hash of the manifest")
+}
+
+func TestDeduplicator_FindDuplicatesAgainst_IgnoresFastHashesOfManifest(t *testing.T) {
+	refDir, targetDir, d := referenceFixture(t)
+
+	v, err := safepath.New(refDir)
+	require.NoError(t, err)
+	gen, err := manifest.NewGeneratorWithValidator(v, 1, hasher.WithAlgorithm(hasher.AlgorithmXXH128))
+	require.NoError(t, err)
+	m, err := gen.Generate(t.Context(), manifest.GenerateOptions{})
+	require.NoError(t, err)
+
+	result := d.FindDuplicatesAgainst(t.Context(), collectTestFiles(t, targetDir), NewManifestReference(m), nil)
+
+	assert.Empty(t, result.Operations, "an XXH128 match cannot be confirmed against files that are not on disk")
+	assert.FileExists(t, filepath.Join(targetDir, "copy of archived.txt"))
+}
+
+func TestDeduplicator_FindDuplicatesAgainst_SkipsVanishedReferenceFile(t *testing.T) {
+	refDir, targetDir, d := referenceFixture(t)
+	refPath := filepath.Join(refDir, "2018", "archived.txt")
+
+	ref := NewDirReference(collectTestFiles(t, refDir))
+	require.NoError(t, os.Remove(refPath))
+	result := d.FindDuplicatesAgainst(t.Context(), collectTestFiles(t, targetDir), ref, nil)
+
+	assert.Empty(t, result.Operations)
+	assert.FileExists(t, filepath.Join(targetDir, "copy of archived.txt"), "a copy is only removed while the reference holds it")
+}
+
+func TestDeduplicator_FindDuplicatesAgainst_DryRun(t *testing.T) {
+	refDir, targetDir, _ := referenceFixture(t)
+
+	d, err := New(targetDir, true)
+	require.NoError(t, err)
+
+	result := d.FindDuplicatesAgainst(t.Context(), collectTestFiles(t, targetDir), NewDirReference(collectTestFiles(t, refDir)), nil)
+	assert.Equal(t, 1, result.DeletedCount)
+	assert.FileExists(t, filepath.Join(targetDir, "copy of archived.txt"))
+}
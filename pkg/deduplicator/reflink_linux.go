@@ -0,0 +1,42 @@
+//go:build linux
+
+package deduplicator
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// ficlone is the FICLONE ioctl request, _IOW(0x94, 9, int).
+const ficlone = 0x40049409
+
+// reflink creates dst as a copy-on-write clone of src with permission
+// bits perm, using the FICLONE ioctl.
+func reflink(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, out.Fd(), ficlone, in.Fd())
+	closeErr := out.Close()
+
+	switch {
+	case errno == 0:
+		return closeErr
+	case errors.Is(errno, syscall.EXDEV):
+		return errno
+	case errors.Is(errno, syscall.EOPNOTSUPP), errors.Is(errno, syscall.EINVAL), errors.Is(errno, syscall.ENOTTY):
+		return ErrReflinkUnsupported
+	default:
+		return fmt.Errorf("FICLONE: %w", errno)
+	}
+}
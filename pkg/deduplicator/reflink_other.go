@@ -0,0 +1,11 @@
+//go:build !linux
+
+package deduplicator
+
+import "os"
+
+// reflink reports [ErrReflinkUnsupported]; cloning is only implemented
+// on Linux.
+func reflink(_, _ string, _ os.FileMode) error {
+	return ErrReflinkUnsupported
+}
@@ -0,0 +1,583 @@
+// Package deflate64 implements a pure-Go decompressor for the Deflate64
+// ("enhanced deflate") format, ZIP compression method 9.
+//
+// Deflate64 is a PKWARE extension of RFC 1951 produced by Windows Explorer's
+// "Send to compressed folder" for large files. It differs from deflate in
+// three places only:
+//   - the sliding window is 64 KiB instead of 32 KiB,
+//   - length code 285 carries 16 extra bits (base 3) instead of meaning 258,
+//   - distance codes 30 and 31 are valid and carry 14 extra bits.
+package deflate64
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	// windowSize is the Deflate64 history window (64 KiB).
+	windowSize = 1 << 16
+
+	// windowMask wraps history positions into the window ring buffer.
+	windowMask = windowSize - 1
+
+	// maxCodeBits is the longest Huffman code length permitted by the format.
+	maxCodeBits = 15
+
+	// fastBits is the number of bits resolved by the lookup table in a
+	// single step. Longer codes fall back to canonical bit-by-bit decoding.
+	fastBits = 9
+
+	// numLitLenCodes is the number of literal/length symbols (0-287).
+	numLitLenCodes = 288
+
+	// numDistCodes is the number of distance symbols in Deflate64 (0-31).
+	numDistCodes = 32
+
+	// numCodeLenCodes is the number of code-length alphabet symbols.
+	numCodeLenCodes = 19
+
+	// endOfBlock is the literal/length symbol terminating a block.
+	endOfBlock = 256
+)
+
+// ErrCorrupt is returned when the compressed stream is malformed.
+var ErrCorrupt = errors.New("deflate64: corrupt input")
+
+// lengthBase and lengthExtra describe length symbols 257-285. The final
+// entry is the Deflate64-specific code 285 (base 3, 16 extra bits).
+var (
+	lengthBase = [29]uint16{
+		3, 4, 5, 6, 7, 8, 9, 10, 11, 13, 15, 17, 19, 23, 27, 31,
+		35, 43, 51, 59, 67, 83, 99, 115, 131, 163, 195, 227, 3,
+	}
+	lengthExtra = [29]uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 2, 2,
+		3, 3, 3, 3, 4, 4, 4, 4, 5, 5, 5, 5, 16,
+	}
+)
+
+// distBase and distExtra describe distance symbols 0-31. Symbols 30 and 31
+// are only valid in Deflate64 and reach back into the 64 KiB window.
+var (
+	distBase = [numDistCodes]uint32{
+		1, 2, 3, 4, 5, 7, 9, 13, 17, 25, 33, 49, 65, 97, 129, 193,
+		257, 385, 513, 769, 1025, 1537, 2049, 3073, 4097, 6145, 8193, 12289, 16385, 24577, 32769, 49153,
+	}
+	distExtra = [numDistCodes]uint8{
+		0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 6,
+		7, 7, 8, 8, 9, 9, 10, 10, 11, 11, 12, 12, 13, 13, 14, 14,
+	}
+)
+
+// codeLenOrder is the permutation in which code-length code lengths are
+// transmitted in a dynamic block header (RFC 1951 §3.2.7).
+var codeLenOrder = [numCodeLenCodes]uint8{16, 17, 18, 0, 8, 7, 9, 6, 10, 5, 11, 4, 12, 3, 13, 2, 14, 1, 15}
+
+// huffman is a canonical Huffman decoding table. Codes up to fastBits long
+// are resolved through fast; longer codes use count/symbols.
+type huffman struct {
+	count   [maxCodeBits + 1]uint16
+	symbols []uint16
+	// fast maps the next fastBits input bits (LSB first) to a packed
+	// symbol<<4 | length entry. A zero length marks a slow-path code.
+	fast [1 << fastBits]uint16
+}
+
+// init builds the decoding tables from per-symbol code lengths. Over-
+// subscribed code sets are rejected; incomplete sets are accepted because
+// encoders legitimately emit them for single-symbol distance trees.
+func (h *huffman) init(lengths []uint8) error {
+	h.count = [maxCodeBits + 1]uint16{}
+	h.fast = [1 << fastBits]uint16{}
+	for _, l := range lengths {
+		h.count[l]++
+	}
+	h.count[0] = 0
+
+	left := 1
+	for bits := 1; bits <= maxCodeBits; bits++ {
+		left <<= 1
+		left -= int(h.count[bits])
+		if left < 0 {
+			return fmt.Errorf("%w: over-subscribed huffman code", ErrCorrupt)
+		}
+	}
+
+	var offsets [maxCodeBits + 2]uint16
+	for bits := 1; bits <= maxCodeBits; bits++ {
+		offsets[bits+1] = offsets[bits] + h.count[bits]
+	}
+
+	h.symbols = make([]uint16, offsets[maxCodeBits+1])
+	for sym, l := range lengths {
+		if l == 0 {
+			continue
+		}
+		h.symbols[offsets[l]] = uint16(sym)
+		offsets[l]++
+	}
+
+	// Populate the fast table by walking codes in canonical order.
+	code := 0
+	index := 0
+	for bits := 1; bits <= fastBits; bits++ {
+		for range int(h.count[bits]) {
+			reversed := reverseBits(code, bits)
+			entry := h.symbols[index]<<4 | uint16(bits)
+			for fill := reversed; fill < 1<<fastBits; fill += 1 << bits {
+				h.fast[fill] = entry
+			}
+			code++
+			index++
+		}
+		code <<= 1
+	}
+
+	return nil
+}
+
+// reverseBits reverses the low n bits of code.
+func reverseBits(code, n int) int {
+	out := 0
+	for range n {
+		out = out<<1 | code&1
+		code >>= 1
+	}
+	return out
+}
+
+// bitReader reads LSB-first bit fields from a byte stream.
+type bitReader struct {
+	r     io.ByteReader
+	bits  uint64
+	nbits uint
+	err   error
+}
+
+// fill tries to buffer at least n bits. It returns false when the stream
+// ends before n bits are available; the buffered bits remain usable.
+func (b *bitReader) fill(n uint) bool {
+	for b.nbits < n {
+		if b.err != nil {
+			return false
+		}
+		c, err := b.r.ReadByte()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			b.err = err
+			return false
+		}
+		b.bits |= uint64(c) << b.nbits
+		b.nbits += 8
+	}
+	return true
+}
+
+// readBits consumes and returns n bits (n <= 32).
+func (b *bitReader) readBits(n uint) (uint32, error) {
+	if n == 0 {
+		return 0, nil
+	}
+	if !b.fill(n) {
+		return 0, b.err
+	}
+	v := uint32(b.bits & (1<<n - 1))
+	b.bits >>= n
+	b.nbits -= n
+	return v, nil
+}
+
+// alignToByte discards buffered bits up to the next byte boundary.
+func (b *bitReader) alignToByte() {
+	drop := b.nbits % 8
+	b.bits >>= drop
+	b.nbits -= drop
+}
+
+// decodeSymbol reads one Huffman-coded symbol using table h.
+func (b *bitReader) decodeSymbol(h *huffman) (uint16, error) {
+	// A short fill near the end of the stream is fine: the table entry is
+	// only trusted when its code length fits in the buffered bits.
+	b.fill(fastBits)
+	if b.nbits > 0 {
+		entry := h.fast[b.bits&(1<<fastBits-1)]
+		if l := uint(entry & 0xf); l != 0 && l <= b.nbits {
+			b.bits >>= l
+			b.nbits -= l
+			return entry >> 4, nil
+		}
+	}
+
+	// Slow path: canonical decoding one bit at a time.
+	code, first, index := 0, 0, 0
+	for bits := 1; bits <= maxCodeBits; bits++ {
+		bit, err := b.readBits(1)
+		if err != nil {
+			return 0, err
+		}
+		code |= int(bit)
+		count := int(h.count[bits])
+		if code-first < count {
+			return h.symbols[index+code-first], nil
+		}
+		index += count
+		first += count
+		first <<= 1
+		code <<= 1
+	}
+
+	return 0, fmt.Errorf("%w: invalid huffman code", ErrCorrupt)
+}
+
+// decompressor is the streaming Deflate64 decoder state.
+type decompressor struct {
+	br bitReader
+
+	// history is the 64 KiB ring buffer of previously produced output.
+	history [windowSize]byte
+	// hpos is the total number of bytes produced, used modulo windowSize.
+	hpos uint64
+
+	inBlock    bool
+	finalBlock bool
+	done       bool
+	stored     bool
+	storedLeft uint32
+
+	litLen huffman
+	dist   huffman
+
+	// copyLen and copyDist describe an in-progress back-reference.
+	copyLen  uint32
+	copyDist uint32
+
+	err error
+}
+
+// NewReader returns an [io.ReadCloser] that decompresses the Deflate64 stream
+// read from r. Its signature matches [archive/zip.Decompressor] so it can be
+// registered for ZIP method 9.
+func NewReader(r io.Reader) io.ReadCloser {
+	br, ok := r.(io.ByteReader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+
+	return &decompressor{br: bitReader{r: br}}
+}
+
+// Close releases the decoder. It never fails; the underlying reader is not
+// closed because it is owned by the caller.
+func (d *decompressor) Close() error {
+	if d.err == nil {
+		d.err = errors.New("deflate64: reader is closed")
+	}
+	return nil
+}
+
+// Read implements [io.Reader].
+func (d *decompressor) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if d.err != nil {
+			return n, d.err
+		}
+
+		switch {
+		case d.copyLen > 0:
+			n += d.copyMatch(p[n:])
+		case d.done:
+			d.err = io.EOF
+		case !d.inBlock:
+			d.err = d.readBlockHeader()
+		case d.stored:
+			var m int
+			m, d.err = d.copyStored(p[n:])
+			n += m
+		default:
+			var m int
+			m, d.err = d.decodeHuffman(p[n:])
+			n += m
+		}
+	}
+
+	return n, nil
+}
+
+// emit appends one output byte to both p and the history window.
+func (d *decompressor) emit(p []byte, i int, c byte) {
+	p[i] = c
+	d.history[d.hpos&windowMask] = c
+	d.hpos++
+}
+
+// copyMatch resolves as much of the pending back-reference as fits in p.
+func (d *decompressor) copyMatch(p []byte) int {
+	n := 0
+	for d.copyLen > 0 && n < len(p) {
+		c := d.history[(d.hpos-uint64(d.copyDist))&windowMask]
+		d.emit(p, n, c)
+		n++
+		d.copyLen--
+	}
+	return n
+}
+
+// readBlockHeader parses the three-bit block header and any table
+// definitions that follow it.
+func (d *decompressor) readBlockHeader() error {
+	if d.finalBlock {
+		d.done = true
+		return nil
+	}
+
+	header, err := d.br.readBits(3)
+	if err != nil {
+		return err
+	}
+	d.finalBlock = header&1 == 1
+
+	switch header >> 1 {
+	case 0:
+		return d.readStoredHeader()
+	case 1:
+		d.initFixedTables()
+	case 2:
+		if err := d.readDynamicTables(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("%w: reserved block type", ErrCorrupt)
+	}
+
+	d.stored = false
+	d.inBlock = true
+	return nil
+}
+
+// readStoredHeader reads LEN/NLEN of an uncompressed block.
+func (d *decompressor) readStoredHeader() error {
+	d.br.alignToByte()
+
+	length, err := d.br.readBits(16)
+	if err != nil {
+		return err
+	}
+	nlength, err := d.br.readBits(16)
+	if err != nil {
+		return err
+	}
+	if uint16(length) != ^uint16(nlength) {
+		return fmt.Errorf("%w: stored block length mismatch", ErrCorrupt)
+	}
+
+	d.stored = true
+	d.storedLeft = length
+	d.inBlock = true
+	return nil
+}
+
+// copyStored copies raw bytes of a stored block into p.
+func (d *decompressor) copyStored(p []byte) (int, error) {
+	n := 0
+	for d.storedLeft > 0 && n < len(p) {
+		c, err := d.br.readBits(8)
+		if err != nil {
+			return n, err
+		}
+		d.emit(p, n, byte(c))
+		n++
+		d.storedLeft--
+	}
+	if d.storedLeft == 0 {
+		d.inBlock = false
+	}
+	return n, nil
+}
+
+// initFixedTables installs the predefined Huffman codes (RFC 1951 §3.2.6),
+// extended to all 32 Deflate64 distance symbols.
+func (d *decompressor) initFixedTables() {
+	var lengths [numLitLenCodes + numDistCodes]uint8
+	for i := range numLitLenCodes {
+		switch {
+		case i < 144:
+			lengths[i] = 8
+		case i < 256:
+			lengths[i] = 9
+		case i < 280:
+			lengths[i] = 7
+		default:
+			lengths[i] = 8
+		}
+	}
+	for i := range numDistCodes {
+		lengths[numLitLenCodes+i] = 5
+	}
+
+	// The fixed code sets are well-formed, so init cannot fail.
+	_ = d.litLen.init(lengths[:numLitLenCodes])
+	_ = d.dist.init(lengths[numLitLenCodes:])
+}
+
+// readDynamicTables reads the code-length code and then the literal/length
+// and distance code lengths of a dynamic Huffman block.
+func (d *decompressor) readDynamicTables() error {
+	hlit, err := d.br.readBits(5)
+	if err != nil {
+		return err
+	}
+	hdist, err := d.br.readBits(5)
+	if err != nil {
+		return err
+	}
+	hclen, err := d.br.readBits(4)
+	if err != nil {
+		return err
+	}
+
+	nlit := int(hlit) + 257
+	ndist := int(hdist) + 1
+	nclen := int(hclen) + 4
+	if nlit > 286 {
+		return fmt.Errorf("%w: too many literal/length codes", ErrCorrupt)
+	}
+
+	var clLengths [numCodeLenCodes]uint8
+	for i := range nclen {
+		v, readErr := d.br.readBits(3)
+		if readErr != nil {
+			return readErr
+		}
+		clLengths[codeLenOrder[i]] = uint8(v)
+	}
+
+	var clTable huffman
+	if initErr := clTable.init(clLengths[:]); initErr != nil {
+		return initErr
+	}
+
+	lengths := make([]uint8, nlit+ndist)
+	for i := 0; i < len(lengths); {
+		sym, decodeErr := d.br.decodeSymbol(&clTable)
+		if decodeErr != nil {
+			return decodeErr
+		}
+
+		if sym < 16 {
+			lengths[i] = uint8(sym)
+			i++
+			continue
+		}
+
+		var repeat uint32
+		var value uint8
+		switch sym {
+		case 16:
+			if i == 0 {
+				return fmt.Errorf("%w: repeat with no previous length", ErrCorrupt)
+			}
+			value = lengths[i-1]
+			repeat, err = d.br.readBits(2)
+			repeat += 3
+		case 17:
+			repeat, err = d.br.readBits(3)
+			repeat += 3
+		default:
+			repeat, err = d.br.readBits(7)
+			repeat += 11
+		}
+		if err != nil {
+			return err
+		}
+		if i+int(repeat) > len(lengths) {
+			return fmt.Errorf("%w: code lengths overflow", ErrCorrupt)
+		}
+		for range repeat {
+			lengths[i] = value
+			i++
+		}
+	}
+
+	if lengths[endOfBlock] == 0 {
+		return fmt.Errorf("%w: missing end-of-block code", ErrCorrupt)
+	}
+
+	if initErr := d.litLen.init(lengths[:nlit]); initErr != nil {
+		return initErr
+	}
+	return d.dist.init(lengths[nlit:])
+}
+
+// decodeHuffman decodes literals and back-references of a compressed block
+// into p until p is full or the block ends.
+func (d *decompressor) decodeHuffman(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		sym, err := d.br.decodeSymbol(&d.litLen)
+		if err != nil {
+			return n, err
+		}
+
+		switch {
+		case sym < endOfBlock:
+			d.emit(p, n, byte(sym))
+			n++
+			continue
+		case sym == endOfBlock:
+			d.inBlock = false
+			return n, nil
+		}
+
+		length, dist, err := d.readMatch(sym)
+		if err != nil {
+			return n, err
+		}
+
+		d.copyLen = length
+		d.copyDist = dist
+		n += d.copyMatch(p[n:])
+	}
+
+	return n, nil
+}
+
+// readMatch decodes the length extra bits for symbol sym and the following
+// distance code, validating the distance against produced history.
+func (d *decompressor) readMatch(sym uint16) (length, dist uint32, err error) {
+	idx := int(sym) - 257
+	if idx >= len(lengthBase) {
+		return 0, 0, fmt.Errorf("%w: invalid length symbol %d", ErrCorrupt, sym)
+	}
+
+	extra, err := d.br.readBits(uint(lengthExtra[idx]))
+	if err != nil {
+		return 0, 0, err
+	}
+	length = uint32(lengthBase[idx]) + extra
+
+	distSym, err := d.br.decodeSymbol(&d.dist)
+	if err != nil {
+		return 0, 0, err
+	}
+	if int(distSym) >= numDistCodes {
+		return 0, 0, fmt.Errorf("%w: invalid distance symbol %d", ErrCorrupt, distSym)
+	}
+
+	extra, err = d.br.readBits(uint(distExtra[distSym]))
+	if err != nil {
+		return 0, 0, err
+	}
+	dist = distBase[distSym] + extra
+
+	if uint64(dist) > d.hpos || dist > windowSize {
+		return 0, 0, fmt.Errorf("%w: distance %d exceeds history", ErrCorrupt, dist)
+	}
+
+	return length, dist, nil
+}
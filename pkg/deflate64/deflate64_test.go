@@ -0,0 +1,210 @@
+package deflate64
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// bitWriter emits LSB-first bit fields for hand-built test streams.
+type bitWriter struct {
+	buf   bytes.Buffer
+	bits  uint64
+	nbits uint
+}
+
+func (w *bitWriter) writeBits(v uint32, n uint) {
+	w.bits |= uint64(v) << w.nbits
+	w.nbits += n
+	for w.nbits >= 8 {
+		w.buf.WriteByte(byte(w.bits))
+		w.bits >>= 8
+		w.nbits -= 8
+	}
+}
+
+// writeCode writes a Huffman code MSB-first, as required by RFC 1951.
+func (w *bitWriter) writeCode(code uint32, n uint) {
+	w.writeBits(uint32(reverseBits(int(code), int(n))), n)
+}
+
+// writeFixedLitLen writes a literal/length symbol using the fixed code.
+func (w *bitWriter) writeFixedLitLen(sym int) {
+	switch {
+	case sym < 144:
+		w.writeCode(uint32(0x30+sym), 8)
+	case sym < 256:
+		w.writeCode(uint32(0x190+sym-144), 9)
+	case sym < 280:
+		w.writeCode(uint32(sym-256), 7)
+	default:
+		w.writeCode(uint32(0xc0+sym-280), 8)
+	}
+}
+
+func (w *bitWriter) bytes() []byte {
+	if w.nbits > 0 {
+		w.buf.WriteByte(byte(w.bits))
+		w.bits, w.nbits = 0, 0
+	}
+	return w.buf.Bytes()
+}
+
+func decompress(t *testing.T, data []byte) ([]byte, error) {
+	t.Helper()
+
+	r := NewReader(bytes.NewReader(data))
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
+
+func TestReader_StoredBlock(t *testing.T) {
+	t.Parallel()
+
+	payload := []byte("stored payload")
+	stream := append([]byte{0x01, byte(len(payload)), 0x00, ^byte(len(payload)), 0xff}, payload...)
+
+	got, err := decompress(t, stream)
+	require.NoError(t, err)
+	assert.Equal(t, payload, got)
+}
+
+func TestReader_DeflateCompatibleStreams(t *testing.T) {
+	t.Parallel()
+
+	// A four-letter alphabet keeps every back-reference far below 258 bytes,
+	// so the deflate encoding never uses symbol 285 and is valid Deflate64.
+	rng := rand.New(rand.NewPCG(1, 2)) //nolint:gosec // deterministic test data
+	payload := make([]byte, 200_000)
+	for i := range payload {
+		payload[i] = "acgt"[rng.IntN(4)]
+	}
+
+	for _, level := range []int{flate.HuffmanOnly, flate.BestSpeed, flate.DefaultCompression, flate.BestCompression} {
+		var buf bytes.Buffer
+		fw, err := flate.NewWriter(&buf, level)
+		require.NoError(t, err)
+		_, err = fw.Write(payload)
+		require.NoError(t, err)
+		require.NoError(t, fw.Close())
+
+		got, err := decompress(t, buf.Bytes())
+		require.NoError(t, err, "level %d", level)
+		assert.Equal(t, payload, got, "level %d", level)
+	}
+}
+
+func TestReader_Deflate64Extensions(t *testing.T) {
+	t.Parallel()
+
+	t.Run("length code 285 uses 16 extra bits", func(t *testing.T) {
+		t.Parallel()
+
+		var w bitWriter
+		w.writeBits(1, 1) // BFINAL
+		w.writeBits(1, 2) // fixed Huffman
+		w.writeFixedLitLen('z')
+		w.writeFixedLitLen(285)
+		w.writeBits(1000-3, 16) // length 1000
+		w.writeCode(0, 5)       // distance 1
+		w.writeFixedLitLen(endOfBlock)
+
+		got, err := decompress(t, w.bytes())
+		require.NoError(t, err)
+		assert.Equal(t, bytes.Repeat([]byte("z"), 1001), got)
+	})
+
+	t.Run("distance codes 30 and 31 reach the 64 KiB window", func(t *testing.T) {
+		t.Parallel()
+
+		prefix := make([]byte, 60_000)
+		for i := range prefix {
+			prefix[i] = byte(i * 7)
+		}
+
+		var w bitWriter
+		w.writeBits(0, 1) // stored block, not final
+		w.writeBits(0, 2)
+		w.bytes()
+		w.buf.Write([]byte{byte(len(prefix)), byte(len(prefix) >> 8), ^byte(len(prefix)), ^byte(len(prefix) >> 8)})
+		w.buf.Write(prefix)
+
+		w.writeBits(1, 1)
+		w.writeBits(1, 2)
+		w.writeFixedLitLen(257) // length 3
+		w.writeCode(31, 5)
+		w.writeBits(59_999-49_153, 14) // distance 59999 -> prefix[1:4]
+		w.writeFixedLitLen(257)
+		w.writeCode(30, 5)
+		w.writeBits(40_000-32_769, 14) // distance 40000
+		w.writeFixedLitLen(endOfBlock)
+
+		got, err := decompress(t, w.bytes())
+		require.NoError(t, err)
+		require.Len(t, got, len(prefix)+6)
+		assert.Equal(t, prefix[1:4], got[60_000:60_003])
+		assert.Equal(t, got[60_003-40_000:60_006-40_000], got[60_003:60_006])
+	})
+}
+
+func TestReader_CorruptInput(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		stream  func() []byte
+		wantErr error
+	}{
+		{
+			name:    "reserved block type",
+			stream:  func() []byte { return []byte{0x07} },
+			wantErr: ErrCorrupt,
+		},
+		{
+			name:    "stored length mismatch",
+			stream:  func() []byte { return []byte{0x01, 0x02, 0x00, 0x00, 0x00, 'a', 'b'} },
+			wantErr: ErrCorrupt,
+		},
+		{
+			name: "distance beyond history",
+			stream: func() []byte {
+				var w bitWriter
+				w.writeBits(1, 1)
+				w.writeBits(1, 2)
+				w.writeFixedLitLen(257)
+				w.writeCode(4, 5)
+				w.writeBits(0, 1)
+				return w.bytes()
+			},
+			wantErr: ErrCorrupt,
+		},
+		{
+			name: "truncated stream",
+			stream: func() []byte {
+				var w bitWriter
+				w.writeBits(1, 1)
+				w.writeBits(1, 2)
+				w.writeFixedLitLen('a')
+				return w.bytes()
+			},
+			wantErr: io.ErrUnexpectedEOF,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := decompress(t, tc.stream())
+			require.Error(t, err)
+			assert.True(t, errors.Is(err, tc.wantErr), "got %v", err)
+		})
+	}
+}
@@ -2,6 +2,7 @@
 package flattener
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
@@ -9,6 +10,7 @@ import (
 
 	"btidy/pkg/collector"
 	"btidy/pkg/hasher"
+	"btidy/pkg/keeper"
 	"btidy/pkg/progress"
 	"btidy/pkg/safepath"
 	"btidy/pkg/sanitizer"
@@ -20,12 +22,17 @@ import (
 // situations with errors.Is.
 var ErrContentChanged = errors.New("file content changed since hash was computed")
 
+// ErrHashCollision indicates that a file and its kept copy have equal
+// hashes but different bytes, which a non-cryptographic hash allows.
+var ErrHashCollision = errors.New("files with equal hashes differ")
+
 // MoveOperation represents a single move operation.
 type MoveOperation struct {
 	OriginalPath string
 	NewPath      string
-	Hash         string // SHA256 content hash
+	Hash         string // Content hash, algorithm-prefixed unless SHA-256
 	Duplicate    bool   // true if this file was deleted as duplicate
+	KeepReason   string // Why the kept copy (NewPath) was chosen, for duplicates
 	TrashedTo    string // Trash destination (empty when trasher is nil)
 	Skipped      bool   // true if skipped (e.g., already in root)
 	SkipReason   string
@@ -50,8 +57,43 @@ type Flattener struct {
 	validator *safepath.Validator
 	hasher    *hasher.Hasher
 	trasher   *trash.Trasher
+	policy    keeper.Policy
+	cache     *hasher.Cache
+	algo      hasher.Algorithm
+}
+
+// Option configures a Flattener.
+type Option func(*Flattener)
+
+// WithKeepPolicy selects which copy of each set of duplicates is moved to
+// the root and kept. The default keeps the copy whose path sorts first.
+// A copy already in the root is always kept.
+func WithKeepPolicy(policy keeper.Policy) Option {
+	return func(f *Flattener) {
+		f.policy = policy
+	}
 }
 
+// WithHashCache makes the flattener look up and record file hashes in
+// cache, so files unchanged since an earlier run are not read again.
+func WithHashCache(cache *hasher.Cache) Option {
+	return func(f *Flattener) {
+		f.cache = cache
+	}
+}
+
+// WithHashAlgorithm selects the algorithm duplicates are detected with.
+func WithHashAlgorithm(algo hasher.Algorithm) Option {
+	return func(f *Flattener) {
+		f.algo = algo
+	}
+}
+
+// DefaultHashAlgorithm is the algorithm duplicates are detected with
+// unless [WithHashAlgorithm] selects another: XXH128, which is much faster
+// than SHA-256 and only has to tell files of distinct content apart.
+const DefaultHashAlgorithm = hasher.AlgorithmXXH128
+
 const (
 	progressStageHashing = "hashing"
 	progressStageMoving  = "moving"
@@ -74,28 +116,55 @@ func NewWithWorkers(rootDir string, dryRun bool, workers int) (*Flattener, error
 
 // NewWithValidator creates a new Flattener with an existing validator.
 // An optional trasher enables soft-delete (move to trash) instead of permanent removal.
-func NewWithValidator(validator *safepath.Validator, dryRun bool, workers int, trasher *trash.Trasher) (*Flattener, error) {
+func NewWithValidator(
+	validator *safepath.Validator,
+	dryRun bool,
+	workers int,
+	trasher *trash.Trasher,
+	opts ...Option,
+) (*Flattener, error) {
 	if validator == nil {
 		return nil, errors.New("validator is required")
 	}
 
-	return &Flattener{
+	f := &Flattener{
 		rootDir:   validator.Root(),
 		dryRun:    dryRun,
 		validator: validator,
-		hasher:    hasher.New(hasher.WithWorkers(workers)),
 		trasher:   trasher,
-	}, nil
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+
+	algo, err := hasher.ParseAlgorithm(string(f.algo))
+	if err != nil {
+		return nil, err
+	}
+	if f.algo == "" {
+		algo = DefaultHashAlgorithm
+	}
+	f.hasher = hasher.New(hasher.WithWorkers(workers), hasher.WithAlgorithm(algo), hasher.WithCache(f.cache))
+
+	if err := f.policy.Validate(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
 }
 
 // FlattenFiles moves all files to root directory, removing duplicates.
-// Duplicates are identified by SHA256 content hash - this is safe and reliable.
+// Duplicates are identified by content hash, XXH128 by default (see
+// [DefaultHashAlgorithm]), and confirmed byte for byte before removal
+// unless the hash is cryptographic.
 func (f *Flattener) FlattenFiles(files []collector.FileInfo) Result {
-	return f.FlattenFilesWithProgress(files, nil)
+	return f.FlattenFilesWithProgress(context.Background(), files, nil)
 }
 
-// FlattenFilesWithProgress moves files and reports stage progress.
-func (f *Flattener) FlattenFilesWithProgress(files []collector.FileInfo, onProgress func(stage string, processed, total int)) Result {
+// FlattenFilesWithProgress moves files and reports stage progress. Once
+// ctx is done no further file is moved or removed: the result holds the
+// operations performed so far, and empty directories are left in place.
+func (f *Flattener) FlattenFilesWithProgress(ctx context.Context, files []collector.FileInfo, onProgress func(stage string, processed, total int)) Result {
 	result := Result{
 		TotalFiles: len(files),
 		Operations: make([]MoveOperation, 0, len(files)),
@@ -106,7 +175,7 @@ func (f *Flattener) FlattenFilesWithProgress(files []collector.FileInfo, onProgr
 	}
 
 	// Step 1: Pre-compute hashes for all files using parallel hashing.
-	fileHashes, invalidReadErrors := f.computeHashes(files, func(processed, total int) {
+	fileHashes, invalidReadErrors := f.computeHashes(ctx, files, func(processed, total int) {
 		progress.EmitStage(onProgress, progressStageHashing, processed, total)
 	})
 	if len(invalidReadErrors) > 0 {
@@ -127,16 +196,11 @@ func (f *Flattener) FlattenFilesWithProgress(files []collector.FileInfo, onProgr
 		return result
 	}
 
-	// Step 2: Track seen content hashes to detect duplicates.
-	seenHash := make(map[string]string) // hash -> path of first occurrence
-
-	// Track name conflicts (same name but different content).
-	nameCount := make(map[string]int)
+	// Step 2: Move files, removing every copy of duplicate content but the
+	// one the keep policy chooses.
+	ops := f.processFiles(ctx, files, fileHashes, onProgress)
 
-	totalFiles := len(files)
-	for i := range files {
-		hash := fileHashes[files[i].Path]
-		op := f.processFile(&files[i], hash, seenHash, nameCount)
+	for _, op := range ops {
 		result.Operations = append(result.Operations, op)
 
 		if op.Error != nil {
@@ -148,20 +212,131 @@ func (f *Flattener) FlattenFilesWithProgress(files []collector.FileInfo, onProgr
 		} else {
 			result.MovedCount++
 		}
-
-		progress.EmitStage(onProgress, progressStageMoving, i+1, totalFiles)
 	}
 
 	// Remove empty directories if not dry run.
-	if !f.dryRun {
+	if !f.dryRun && ctx.Err() == nil {
 		result.DeletedDirsCount = f.removeEmptyDirs()
 	}
 
 	return result
 }
 
-// computeHashes pre-computes SHA256 hashes for all files using parallel hashing.
-func (f *Flattener) computeHashes(files []collector.FileInfo, onProgress func(processed, total int)) (hashes map[string]string, invalidReadErrors map[string]error) {
+// processFiles moves or removes each file and returns the operations in the
+// order of files. The kept copy of each set of duplicates is processed
+// before the others, so they are detected as its duplicates. Files not
+// processed when ctx is done have no operation.
+func (f *Flattener) processFiles(
+	ctx context.Context,
+	files []collector.FileInfo,
+	fileHashes map[string]string,
+	onProgress func(stage string, processed, total int),
+) []MoveOperation {
+	keepers := f.chooseKeepers(files, fileHashes)
+
+	// Track seen content hashes to detect duplicates.
+	seenHash := make(map[string]string) // hash -> path of the kept copy
+
+	// Track name conflicts (same name but different content).
+	nameCount := make(map[string]int)
+
+	// keepReasons holds why the kept copy was chosen, once it is in place.
+	keepReasons := make(map[string]string)
+
+	ops := make([]MoveOperation, len(files))
+	processed := make([]bool, len(files))
+	done := 0
+	process := func(i int) {
+		if processed[i] || ctx.Err() != nil {
+			return
+		}
+		processed[i] = true
+
+		hash := fileHashes[files[i].Path]
+		op := f.processFile(&files[i], hash, seenHash, nameCount)
+		if choice, ok := keepers[hash]; ok && i == choice.index && op.Error == nil {
+			keepReasons[hash] = choice.reason
+		}
+		if op.Duplicate {
+			op.KeepReason = keepReasons[hash]
+		}
+		ops[i] = op
+
+		done++
+		progress.EmitStage(onProgress, progressStageMoving, done, len(files))
+	}
+
+	for i := range files {
+		if choice, ok := keepers[fileHashes[files[i].Path]]; ok {
+			process(choice.index)
+		}
+		process(i)
+	}
+
+	performed := ops[:0]
+	for i := range ops {
+		if processed[i] {
+			performed = append(performed, ops[i])
+		}
+	}
+
+	return performed
+}
+
+// keeperChoice is the copy kept of a set of duplicates, by index into the
+// flattened files, and why it was chosen.
+type keeperChoice struct {
+	index  int
+	reason string
+}
+
+// chooseKeepers picks the copy kept of each set of files with the same
+// content hash, keyed by that hash. A copy already in the root always
+// wins, since it is not moved; the keep policy only ranks the others, or
+// the root copies when there are several.
+func (f *Flattener) chooseKeepers(files []collector.FileInfo, fileHashes map[string]string) map[string]keeperChoice {
+	byHash := make(map[string][]int)
+	for i := range files {
+		if hash := fileHashes[files[i].Path]; hash != "" {
+			byHash[hash] = append(byHash[hash], i)
+		}
+	}
+
+	keepers := make(map[string]keeperChoice)
+	for hash, indexes := range byHash {
+		if len(indexes) < 2 {
+			continue
+		}
+
+		var inRoot []int
+		for _, i := range indexes {
+			if files[i].Dir == f.rootDir {
+				inRoot = append(inRoot, i)
+			}
+		}
+		if len(inRoot) == 1 {
+			keepers[hash] = keeperChoice{index: inRoot[0], reason: "already in root"}
+			continue
+		}
+		if len(inRoot) > 1 {
+			indexes = inRoot
+		}
+
+		candidates := make([]collector.FileInfo, len(indexes))
+		indexByPath := make(map[string]int, len(indexes))
+		for j, i := range indexes {
+			candidates[j] = files[i]
+			indexByPath[files[i].Path] = i
+		}
+		reason := f.policy.Choose(f.rootDir, candidates)
+		keepers[hash] = keeperChoice{index: indexByPath[candidates[0].Path], reason: reason}
+	}
+
+	return keepers
+}
+
+// computeHashes pre-computes content hashes for all files using parallel hashing.
+func (f *Flattener) computeHashes(ctx context.Context, files []collector.FileInfo, onProgress func(processed, total int)) (hashes map[string]string, invalidReadErrors map[string]error) {
 	hashes = make(map[string]string, len(files))
 	invalidReadErrors = make(map[string]error)
 
@@ -182,7 +357,7 @@ func (f *Flattener) computeHashes(files []collector.FileInfo, onProgress func(pr
 	// Hash files in parallel.
 	processed := 0
 	total := len(toHash)
-	for result := range f.hasher.HashFilesWithSizes(toHash) {
+	for result := range f.hasher.HashFilesWithSizes(ctx, toHash) {
 		processed++
 		progress.Emit(onProgress, processed, total)
 
@@ -258,7 +433,8 @@ func (f *Flattener) processFile(file *collector.FileInfo, hash string, seenHash
 }
 
 // handleDuplicate records a duplicate and optionally deletes it after verifying
-// the kept copy still exists.
+// the kept copy still exists and, for a non-cryptographic hash, holds the
+// same bytes.
 func (f *Flattener) handleDuplicate(op *MoveOperation, dupPath, existingPath string) MoveOperation {
 	op.Duplicate = true
 	op.NewPath = existingPath // reference to the kept file
@@ -280,6 +456,17 @@ func (f *Flattener) handleDuplicate(op *MoveOperation, dupPath, existingPath str
 			op.Error = fmt.Errorf("content changed since hashing: %w", ErrContentChanged)
 			return *op
 		}
+		if !f.hasher.Algorithm().Cryptographic() {
+			same, err := hasher.SameContent(dupPath, existingPath)
+			if err != nil {
+				op.Error = fmt.Errorf("compare with kept file: %w", err)
+				return *op
+			}
+			if !same {
+				op.Error = fmt.Errorf("refusing to delete: %w", ErrHashCollision)
+				return *op
+			}
+		}
 
 		op.TrashedTo, op.Error = f.trashOrRemove(dupPath)
 	}
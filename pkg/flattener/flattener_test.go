@@ -1,9 +1,11 @@
 package flattener
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"testing"
 	"time"
 
@@ -12,6 +14,7 @@ import (
 
 	"btidy/internal/testutil"
 	"btidy/pkg/collector"
+	"btidy/pkg/keeper"
 	"btidy/pkg/metadata"
 	"btidy/pkg/safepath"
 	"btidy/pkg/trash"
@@ -26,7 +29,7 @@ func collectFiles(t *testing.T, root string) []collector.FileInfo {
 	t.Helper()
 
 	c := collector.New(collector.Options{})
-	files, err := c.Collect(root)
+	files, err := c.Collect(t.Context(), root)
 	require.NoError(t, err)
 
 	return files
@@ -59,6 +62,32 @@ func TestFlattener_FlattenFiles_Basic(t *testing.T) {
 	assert.True(t, os.IsNotExist(err))
 }
 
+func TestFlattener_FlattenFiles_StopsWhenCanceled(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	modTime := time.Date(2018, 6, 15, 12, 0, 0, 0, time.UTC)
+	createTestFile(t, filepath.Join(tmpDir, "a", "one.txt"), "one", modTime)
+	createTestFile(t, filepath.Join(tmpDir, "b", "two.txt"), "two", modTime)
+
+	f, err := New(tmpDir, false)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	result := f.FlattenFilesWithProgress(ctx, collectFiles(t, tmpDir), func(stage string, _, _ int) {
+		if stage == progressStageMoving {
+			cancel()
+		}
+	})
+
+	require.Len(t, result.Operations, 1, "the move in progress completes, no other starts")
+	assert.Equal(t, 1, result.MovedCount)
+	assert.Zero(t, result.DeletedDirsCount, "empty directories are left in place")
+	assert.FileExists(t, filepath.Join(tmpDir, "one.txt"))
+	assert.FileExists(t, filepath.Join(tmpDir, "b", "two.txt"))
+	assert.DirExists(t, filepath.Join(tmpDir, "a"))
+}
+
 func TestFlattener_FlattenFiles_DryRun(t *testing.T) {
 	tmpDir := t.TempDir()
 
@@ -328,7 +357,7 @@ func TestFlattener_FlattenFiles_DuplicatePreservedWhenKeptFileDisappears(t *test
 	require.NoError(t, err)
 
 	// Pre-compute hashes (same as FlattenFiles does internally).
-	fileHashes, _ := f.computeHashes(files, nil)
+	fileHashes, _ := f.computeHashes(t.Context(), files, nil)
 	seenHash := make(map[string]string)
 	nameCount := make(map[string]int)
 
@@ -374,7 +403,7 @@ func TestFlattener_FlattenFiles_TrashesFilesWhenTrasherProvided(t *testing.T) {
 	require.NoError(t, err)
 
 	c := collector.New(collector.Options{SkipDirs: []string{".btidy"}})
-	files, err := c.Collect(tmpDir)
+	files, err := c.Collect(t.Context(), tmpDir)
 	require.NoError(t, err)
 	require.Len(t, files, 2)
 
@@ -452,7 +481,7 @@ func TestFlattener_FlattenFiles_DuplicatePreservedWhenContentChanged(t *testing.
 	require.NoError(t, err)
 
 	// Pre-compute hashes (same as FlattenFiles does internally).
-	fileHashes, _ := f.computeHashes(files, nil)
+	fileHashes, _ := f.computeHashes(t.Context(), files, nil)
 	seenHash := make(map[string]string)
 	nameCount := make(map[string]int)
 
@@ -474,3 +503,93 @@ func TestFlattener_FlattenFiles_DuplicatePreservedWhenContentChanged(t *testing.
 	// The duplicate should still exist on disk.
 	assert.FileExists(t, dupeFile)
 }
+
+// Test that a duplicate found by a fast hash is kept unless its bytes match.
+func TestFlattener_FlattenFiles_DuplicatePreservedOnHashCollision(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	modTime := time.Date(2018, 6, 15, 12, 0, 0, 0, time.UTC)
+	keptFile := filepath.Join(tmpDir, "kept.txt")
+	dupeFile := filepath.Join(tmpDir, "dir", "file.txt")
+	createTestFile(t, keptFile, "kept content", modTime)
+	createTestFile(t, dupeFile, "other bytes!", modTime)
+
+	files := collectFiles(t, tmpDir)
+	f, err := New(tmpDir, false)
+	require.NoError(t, err)
+	fileHashes, _ := f.computeHashes(t.Context(), files, nil)
+
+	// Pair the two files as a crafted XXH128 collision would.
+	dupe := files[slices.IndexFunc(files, func(file collector.FileInfo) bool { return file.Path == dupeFile })]
+	seenHash := map[string]string{fileHashes[dupeFile]: keptFile}
+
+	op := f.processFile(&dupe, fileHashes[dupeFile], seenHash, make(map[string]int))
+	require.ErrorIs(t, op.Error, ErrHashCollision)
+	assert.True(t, op.Duplicate)
+	assert.FileExists(t, dupeFile)
+}
+
+func TestFlattener_FlattenFiles_KeepPolicy(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	createTestFile(t, filepath.Join(tmpDir, "a", "photo.jpg"), "pixels", time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC))
+	createTestFile(t, filepath.Join(tmpDir, "b", "photo.jpg"), "pixels", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	createTestFile(t, filepath.Join(tmpDir, "c", "photo.jpg"), "other pixels", time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	files := collectFiles(t, tmpDir)
+	require.Len(t, files, 3)
+
+	v, err := safepath.New(tmpDir)
+	require.NoError(t, err)
+	f, err := NewWithValidator(v, false, 1, nil, WithKeepPolicy(keeper.Policy{Strategy: keeper.StrategyNewest}))
+	require.NoError(t, err)
+	result := f.FlattenFiles(files)
+
+	assert.Equal(t, 2, result.MovedCount)
+	assert.Equal(t, 1, result.DuplicatesCount)
+	require.Len(t, result.Operations, 3)
+
+	// Operations stay in file order although b/photo.jpg was moved first.
+	dup := result.Operations[0]
+	assert.Equal(t, filepath.Join(tmpDir, "a", "photo.jpg"), dup.OriginalPath)
+	assert.True(t, dup.Duplicate)
+	assert.Equal(t, filepath.Join(tmpDir, "photo.jpg"), dup.NewPath)
+	assert.Equal(t, "newest modification time", dup.KeepReason)
+
+	assert.Equal(t, filepath.Join(tmpDir, "photo.jpg"), result.Operations[1].NewPath)
+	assert.Equal(t, filepath.Join(tmpDir, "photo_1.jpg"), result.Operations[2].NewPath)
+
+	info, err := os.Stat(filepath.Join(tmpDir, "photo.jpg"))
+	require.NoError(t, err)
+	assert.Equal(t, 2020, info.ModTime().Year(), "the newest copy is the one kept")
+}
+
+func TestFlattener_FlattenFiles_KeepPolicyKeepsCopyInRoot(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	modTime := time.Date(2018, 6, 15, 12, 0, 0, 0, time.UTC)
+	createTestFile(t, filepath.Join(tmpDir, "z.txt"), "content", modTime)
+	createTestFile(t, filepath.Join(tmpDir, "Preferred", "a.txt"), "content", modTime)
+
+	files := collectFiles(t, tmpDir)
+	require.Len(t, files, 2)
+
+	v, err := safepath.New(tmpDir)
+	require.NoError(t, err)
+	f, err := NewWithValidator(v, true, 1, nil, WithKeepPolicy(keeper.Policy{PreferDirs: []string{"Preferred"}}))
+	require.NoError(t, err)
+	result := f.FlattenFiles(files)
+
+	assert.Equal(t, 1, result.SkippedCount)
+	assert.Equal(t, 1, result.DuplicatesCount)
+
+	var dup MoveOperation
+	for _, op := range result.Operations {
+		if op.Duplicate {
+			dup = op
+		}
+	}
+	assert.Equal(t, filepath.Join(tmpDir, "Preferred", "a.txt"), dup.OriginalPath)
+	assert.Equal(t, filepath.Join(tmpDir, "z.txt"), dup.NewPath)
+	assert.Equal(t, "already in root", dup.KeepReason)
+}
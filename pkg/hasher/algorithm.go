@@ -0,0 +1,83 @@
+package hasher
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+
+	"github.com/zeebo/blake3"
+	"github.com/zeebo/xxh3"
+)
+
+// Algorithm names a content hash algorithm.
+type Algorithm string
+
+const (
+	// AlgorithmSHA256 is SHA-256, the default.
+	AlgorithmSHA256 Algorithm = "sha256"
+	// AlgorithmBLAKE3 is BLAKE3 with a 256-bit digest: cryptographic, and
+	// several times faster than SHA-256 on most CPUs.
+	AlgorithmBLAKE3 Algorithm = "blake3"
+	// AlgorithmXXH128 is the 128-bit XXH3 hash: much faster still, but not
+	// cryptographic, so files can be crafted to collide. It suits grouping
+	// files by content, not inventories that must resist tampering.
+	AlgorithmXXH128 Algorithm = "xxh128"
+)
+
+// ParseAlgorithm parses an algorithm name. The empty string selects
+// SHA-256.
+func ParseAlgorithm(value string) (Algorithm, error) {
+	switch algo := Algorithm(value); algo {
+	case "":
+		return AlgorithmSHA256, nil
+	case AlgorithmSHA256, AlgorithmBLAKE3, AlgorithmXXH128:
+		return algo, nil
+	default:
+		return "", fmt.Errorf("invalid hash algorithm %q (want sha256, blake3 or xxh128)", value)
+	}
+}
+
+// Cryptographic reports whether collisions of the algorithm cannot be
+// found in practice.
+func (a Algorithm) Cryptographic() bool {
+	return a == AlgorithmSHA256 || a == AlgorithmBLAKE3
+}
+
+// newHash returns a fresh hash.Hash of the algorithm.
+func (a Algorithm) newHash() hash.Hash {
+	switch a {
+	case AlgorithmBLAKE3:
+		return blake3.New()
+	case AlgorithmXXH128:
+		return xxh3.New128()
+	default:
+		return sha256.New()
+	}
+}
+
+// format encodes a digest of the algorithm as a hash string. SHA-256
+// digests are plain hex, as in manifests and journals written before the
+// algorithm was selectable; other digests are prefixed with "<algorithm>:".
+func (a Algorithm) format(sum []byte) string {
+	if a == AlgorithmSHA256 {
+		return hex.EncodeToString(sum)
+	}
+	return string(a) + ":" + hex.EncodeToString(sum)
+}
+
+// AlgorithmOf returns the algorithm of a hash string produced by a Hasher,
+// so the hash can be checked against a file by a Hasher using the same
+// algorithm. A hash without prefix is SHA-256.
+func AlgorithmOf(hash string) (Algorithm, error) {
+	name, _, found := strings.Cut(hash, ":")
+	if !found {
+		return AlgorithmSHA256, nil
+	}
+	algo, err := ParseAlgorithm(name)
+	if err != nil || algo == AlgorithmSHA256 {
+		return "", fmt.Errorf("unknown hash algorithm in %q", hash)
+	}
+	return algo, nil
+}
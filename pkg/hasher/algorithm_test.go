@@ -0,0 +1,99 @@
+package hasher
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAlgorithm(t *testing.T) {
+	t.Parallel()
+
+	algo, err := ParseAlgorithm("")
+	require.NoError(t, err)
+	assert.Equal(t, AlgorithmSHA256, algo)
+
+	for _, value := range []string{"sha256", "blake3", "xxh128"} {
+		algo, err = ParseAlgorithm(value)
+		require.NoError(t, err)
+		assert.Equal(t, Algorithm(value), algo)
+	}
+
+	_, err = ParseAlgorithm("md5")
+	require.Error(t, err)
+
+	assert.True(t, AlgorithmSHA256.Cryptographic())
+	assert.True(t, AlgorithmBLAKE3.Cryptographic())
+	assert.False(t, AlgorithmXXH128.Cryptographic())
+}
+
+func TestHasher_AlgorithmsFormatHashes(t *testing.T) {
+	t.Parallel()
+
+	// Digests of the empty input from the reference implementations.
+	tests := map[Algorithm]string{
+		AlgorithmSHA256: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		AlgorithmBLAKE3: "blake3:af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262",
+		AlgorithmXXH128: "xxh128:99aa06d3014798d86001c324468d497f",
+	}
+
+	path := createTestFile(t, t.TempDir(), "empty", "")
+	for algo, want := range tests {
+		h := New(WithAlgorithm(algo))
+		assert.Equal(t, algo, h.Algorithm())
+
+		hash, err := h.ComputeHash(path)
+		require.NoError(t, err)
+		assert.Equal(t, want, hash)
+		dataHash, err := h.ComputeDataHash(nil)
+		require.NoError(t, err)
+		assert.Equal(t, want, dataHash)
+
+		parsed, err := AlgorithmOf(hash)
+		require.NoError(t, err)
+		assert.Equal(t, algo, parsed)
+	}
+}
+
+func TestHasher_InvalidAlgorithmFailsEveryHash(t *testing.T) {
+	t.Parallel()
+
+	path := createTestFile(t, t.TempDir(), "a.txt", "hello")
+	for _, h := range []*Hasher{New(WithAlgorithm("md5")), New().Using("md5")} {
+		_, err := h.ComputeHash(path)
+		require.ErrorContains(t, err, `invalid hash algorithm "md5"`)
+		_, err = h.ComputePartialHash(path, 5)
+		require.Error(t, err)
+		_, err = h.ComputeDataHash(nil)
+		require.Error(t, err)
+		for result := range h.HashFiles(t.Context(), []string{path}) {
+			require.Error(t, result.Error)
+		}
+	}
+}
+
+func TestAlgorithmOf_RejectsUnknownPrefix(t *testing.T) {
+	t.Parallel()
+
+	for _, hash := range []string{"md5:d41d8cd98f00b204e9800998ecf8427e", "sha256:e3b0", ":e3b0"} {
+		_, err := AlgorithmOf(hash)
+		require.Error(t, err, hash)
+	}
+}
+
+func TestHasher_UsingKeepsOtherSettings(t *testing.T) {
+	t.Parallel()
+
+	h := New(WithWorkers(3))
+	other := h.Using(AlgorithmBLAKE3)
+
+	assert.Equal(t, AlgorithmSHA256, h.Algorithm())
+	assert.Equal(t, AlgorithmBLAKE3, other.Algorithm())
+	assert.Equal(t, 3, other.Workers())
+
+	path := createTestFile(t, t.TempDir(), "a.txt", "hello")
+	hash, err := other.ComputeHash(path)
+	require.NoError(t, err)
+	assert.Contains(t, hash, "blake3:")
+}
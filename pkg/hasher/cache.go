@@ -0,0 +1,251 @@
+package hasher
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// cacheVersion is bumped whenever the on-disk cache format changes; a cache
+// file of another version is discarded.
+const cacheVersion = 2
+
+// fileID identifies a file independently of its path.
+type fileID struct {
+	Dev uint64
+	Ino uint64
+}
+
+// fileStamp holds the attributes that change whenever the content of a file
+// may have changed. The change time cannot be set by users, so rewriting a
+// file and restoring its modification time still invalidates its entry.
+type fileStamp struct {
+	Size       int64
+	ModTime    int64 // nanoseconds since the Unix epoch
+	ChangeTime int64 // nanoseconds since the Unix epoch
+}
+
+// cacheEntry is the cached hashes of one file, by algorithm.
+type cacheEntry struct {
+	Path    string // path the file was last hashed at, for pruning
+	Stamp   fileStamp
+	Full    map[Algorithm]string
+	Partial map[Algorithm]string
+}
+
+// cacheFile is the on-disk layout of a Cache.
+type cacheFile struct {
+	Version int
+	IDs     []fileID
+	Entries []cacheEntry
+}
+
+// Cache is a persistent store of file hashes, keyed by device, inode, size,
+// modification time and change time, so unchanged files are not read again
+// on later runs. A Hasher consults it transparently when created with
+// [WithCache]. An entry whose file no longer has the recorded size and
+// times is dropped on lookup. On platforms where device and inode numbers
+// are unavailable the cache stays empty.
+//
+// A nil *Cache is valid and caches nothing. Cache is safe for concurrent
+// use.
+type Cache struct {
+	path    string
+	mu      sync.Mutex
+	entries map[fileID]cacheEntry
+	dirty   bool
+}
+
+// OpenCache loads the cache stored at path. A missing file yields an empty
+// cache; so does a file written by another version of the format, which is
+// replaced on the next Save.
+func OpenCache(path string) (*Cache, error) {
+	c := &Cache{path: path, entries: make(map[fileID]cacheEntry)}
+
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open hash cache: %w", err)
+	}
+	defer f.Close()
+
+	var stored cacheFile
+	if err := gob.NewDecoder(f).Decode(&stored); err != nil || stored.Version != cacheVersion || len(stored.IDs) != len(stored.Entries) {
+		c.dirty = true
+		return c, nil //nolint:nilerr // an unreadable cache is rebuilt rather than failing the run
+	}
+
+	for i, id := range stored.IDs {
+		c.entries[id] = stored.Entries[i]
+	}
+
+	return c, nil
+}
+
+// Len returns the number of files in the cache.
+func (c *Cache) Len() int {
+	if c == nil {
+		return 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.entries)
+}
+
+// Save writes the cache back to its file if it changed since it was
+// opened. The file is replaced atomically.
+func (c *Cache) Save() error {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.dirty {
+		return nil
+	}
+
+	stored := cacheFile{
+		Version: cacheVersion,
+		IDs:     make([]fileID, 0, len(c.entries)),
+		Entries: make([]cacheEntry, 0, len(c.entries)),
+	}
+	for id, entry := range c.entries {
+		stored.IDs = append(stored.IDs, id)
+		stored.Entries = append(stored.Entries, entry)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("create hash cache: %w", err)
+	}
+	tmpPath := tmp.Name()
+
+	if err := gob.NewEncoder(tmp).Encode(stored); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("write hash cache: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("write hash cache: %w", err)
+	}
+	if err := os.Rename(tmpPath, c.path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("replace hash cache: %w", err)
+	}
+
+	c.dirty = false
+	return nil
+}
+
+// Prune drops the entries of files that no longer exist at the path they
+// were hashed at, or that changed since. It returns the number of entries
+// dropped.
+func (c *Cache) Prune() int {
+	if c == nil {
+		return 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	removed := 0
+	for id, entry := range c.entries {
+		info, err := os.Lstat(entry.Path)
+		if err == nil {
+			if curID, stamp, ok := statKey(info); ok && curID == id && stamp == entry.Stamp {
+				continue
+			}
+		}
+		delete(c.entries, id)
+		removed++
+	}
+	if removed > 0 {
+		c.dirty = true
+	}
+
+	return removed
+}
+
+// lookup returns the hash of the file described by info with algo, full
+// or partial, if it is cached and the file is unchanged since.
+func (c *Cache) lookup(info os.FileInfo, algo Algorithm, partial bool) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	id, stamp, ok := statKey(info)
+	if !ok {
+		return "", false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[id]
+	if !ok {
+		return "", false
+	}
+	if entry.Stamp != stamp {
+		delete(c.entries, id)
+		c.dirty = true
+		return "", false
+	}
+
+	hashes := entry.Full
+	if partial {
+		hashes = entry.Partial
+	}
+	hash, ok := hashes[algo]
+	return hash, ok
+}
+
+// store records the hash of the file at path described by info. The hash
+// is only recorded when after is the same file unchanged, so a file written
+// to while it was being read is not cached.
+func (c *Cache) store(path string, info, after os.FileInfo, algo Algorithm, partial bool, hash string) {
+	if c == nil {
+		return
+	}
+	id, stamp, ok := statKey(info)
+	if !ok {
+		return
+	}
+	if afterID, afterStamp, ok := statKey(after); !ok || afterID != id || afterStamp != stamp {
+		return
+	}
+	if absPath, err := filepath.Abs(path); err == nil {
+		path = absPath
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[id]
+	if !ok || entry.Stamp != stamp {
+		entry = cacheEntry{Stamp: stamp}
+	}
+	if entry.Full == nil {
+		entry.Full = make(map[Algorithm]string)
+	}
+	if entry.Partial == nil {
+		entry.Partial = make(map[Algorithm]string)
+	}
+	entry.Path = path
+	if partial {
+		entry.Partial[algo] = hash
+	} else {
+		entry.Full[algo] = hash
+	}
+	c.entries[id] = entry
+	c.dirty = true
+}
@@ -0,0 +1,146 @@
+package hasher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// openTestCache opens an empty cache in a temporary directory, skipping the
+// test on platforms where files cannot be identified.
+func openTestCache(t *testing.T) *Cache {
+	t.Helper()
+
+	info, err := os.Stat(t.TempDir())
+	require.NoError(t, err)
+	if _, _, ok := statKey(info); !ok {
+		t.Skip("device and inode numbers are unavailable on this platform")
+	}
+
+	c, err := OpenCache(filepath.Join(t.TempDir(), "hashcache"))
+	require.NoError(t, err)
+	return c
+}
+
+// poison replaces every cached hash, so a lookup that hits the cache is
+// told apart from a file read.
+func poison(c *Cache) {
+	for _, entry := range c.entries {
+		for algo := range entry.Full {
+			entry.Full[algo] = "cached-full"
+		}
+		for algo := range entry.Partial {
+			entry.Partial[algo] = "cached-partial"
+		}
+	}
+}
+
+func TestCache_ReusesHashesOfUnchangedFile(t *testing.T) {
+	c := openTestCache(t)
+	path := createTestFile(t, t.TempDir(), "a.txt", "hello")
+	h := New(WithCache(c))
+
+	full, err := h.ComputeHash(path)
+	require.NoError(t, err)
+	assert.Equal(t, expectedHash("hello"), full)
+	partial, err := h.ComputePartialHash(path, 5)
+	require.NoError(t, err)
+	assert.Equal(t, 1, c.Len(), "full and partial hashes share the entry of the file")
+
+	poison(c)
+	full, err = h.ComputeHash(path)
+	require.NoError(t, err)
+	assert.Equal(t, "cached-full", full)
+	partial2, err := h.ComputePartialHash(path, 5)
+	require.NoError(t, err)
+	assert.Equal(t, "cached-partial", partial2)
+	assert.NotEqual(t, partial, partial2)
+
+	hash, err := h.ComputePartialHash(path, 4)
+	require.NoError(t, err)
+	assert.NotEqual(t, "cached-partial", hash, "a partial hash for another size is computed")
+
+	hash, err = h.Using(AlgorithmXXH128).ComputeHash(path)
+	require.NoError(t, err)
+	assert.Contains(t, hash, "xxh128:", "hashes are cached per algorithm")
+}
+
+func TestCache_InvalidatesChangedFile(t *testing.T) {
+	c := openTestCache(t)
+	path := createTestFile(t, t.TempDir(), "a.txt", "hello")
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	h := New(WithCache(c))
+
+	_, err = h.ComputeHash(path)
+	require.NoError(t, err)
+	poison(c)
+
+	// Same size and modification time: only the change time tells.
+	time.Sleep(20 * time.Millisecond)
+	require.NoError(t, os.WriteFile(path, []byte("world"), 0o600))
+	require.NoError(t, os.Chtimes(path, info.ModTime(), info.ModTime()))
+
+	hash, err := h.ComputeHash(path)
+	require.NoError(t, err)
+	assert.Equal(t, expectedHash("world"), hash)
+}
+
+func TestCache_SaveReopenAndPrune(t *testing.T) {
+	c := openTestCache(t)
+	dir := t.TempDir()
+	kept := createTestFile(t, dir, "kept.txt", "kept")
+	gone := createTestFile(t, dir, "gone.txt", "gone")
+	changed := createTestFile(t, dir, "changed.txt", "changed")
+	h := New(WithCache(c))
+
+	for result := range h.HashFiles(t.Context(), []string{kept, gone, changed}) {
+		require.NoError(t, result.Error)
+	}
+	require.NoError(t, c.Save())
+
+	reopened, err := OpenCache(c.path)
+	require.NoError(t, err)
+	require.Equal(t, 3, reopened.Len())
+
+	require.NoError(t, os.Remove(gone))
+	require.NoError(t, os.WriteFile(changed, []byte("changed again"), 0o600))
+
+	assert.Equal(t, 2, reopened.Prune())
+	assert.Equal(t, 1, reopened.Len())
+	require.NoError(t, reopened.Save())
+
+	reopened, err = OpenCache(c.path)
+	require.NoError(t, err)
+	assert.Equal(t, 1, reopened.Len())
+}
+
+func TestOpenCache_UnreadableFileStartsEmpty(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hashcache")
+	require.NoError(t, os.WriteFile(path, []byte("not a cache"), 0o600))
+
+	c, err := OpenCache(path)
+	require.NoError(t, err)
+	assert.Zero(t, c.Len())
+
+	require.NoError(t, c.Save())
+	c, err = OpenCache(path)
+	require.NoError(t, err)
+	assert.Zero(t, c.Len())
+}
+
+func TestCache_NilCachesNothing(t *testing.T) {
+	var c *Cache
+	path := createTestFile(t, t.TempDir(), "a.txt", "hello")
+
+	hash, err := New(WithCache(c)).ComputeHash(path)
+	require.NoError(t, err)
+	assert.Equal(t, expectedHash("hello"), hash)
+	assert.Zero(t, c.Len())
+	assert.Zero(t, c.Prune())
+	require.NoError(t, c.Save())
+}
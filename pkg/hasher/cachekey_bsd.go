@@ -0,0 +1,22 @@
+//go:build darwin || freebsd || netbsd
+
+package hasher
+
+import (
+	"os"
+	"syscall"
+)
+
+// statKey returns the identity and stamp of the file described by info.
+func statKey(info os.FileInfo) (fileID, fileStamp, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, fileStamp{}, false
+	}
+
+	return fileID{Dev: uint64(st.Dev), Ino: st.Ino}, fileStamp{ //nolint:gosec // device numbers are reinterpreted, not compared by magnitude
+		Size:       info.Size(),
+		ModTime:    info.ModTime().UnixNano(),
+		ChangeTime: st.Ctimespec.Nano(),
+	}, true
+}
@@ -0,0 +1,23 @@
+//go:build linux
+
+package hasher
+
+import (
+	"os"
+	"syscall"
+)
+
+// statKey returns the identity and stamp of the file described by info.
+func statKey(info os.FileInfo) (fileID, fileStamp, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, fileStamp{}, false
+	}
+
+	//nolint:unconvert // Dev is narrower on some architectures
+	return fileID{Dev: uint64(st.Dev), Ino: st.Ino}, fileStamp{
+		Size:       info.Size(),
+		ModTime:    info.ModTime().UnixNano(),
+		ChangeTime: st.Ctim.Nano(),
+	}, true
+}
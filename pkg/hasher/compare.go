@@ -0,0 +1,55 @@
+package hasher
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+)
+
+// compareChunkSize is the number of bytes read from each file at a time
+// by [SameContent].
+const compareChunkSize = 64 * 1024
+
+// SameContent reports whether the files at a and b hold the same bytes.
+// It confirms a match found with a non-cryptographic algorithm, whose
+// collisions can be crafted, before anything is removed because of it.
+func SameContent(a, b string) (bool, error) {
+	fa, err := os.Open(a)
+	if err != nil {
+		return false, err
+	}
+	defer fa.Close()
+
+	fb, err := os.Open(b)
+	if err != nil {
+		return false, err
+	}
+	defer fb.Close()
+
+	bufA := make([]byte, compareChunkSize)
+	bufB := make([]byte, compareChunkSize)
+	for {
+		na, errA := io.ReadFull(fa, bufA)
+		if errA != nil && !isEndOfFile(errA) {
+			return false, errA
+		}
+		nb, errB := io.ReadFull(fb, bufB)
+		if errB != nil && !isEndOfFile(errB) {
+			return false, errB
+		}
+
+		if !bytes.Equal(bufA[:na], bufB[:nb]) {
+			return false, nil
+		}
+		if errA != nil || errB != nil {
+			// Equal so far; the files match only if both ended here.
+			return errA != nil && errB != nil, nil
+		}
+	}
+}
+
+// isEndOfFile reports whether err from [io.ReadFull] means the file ended.
+func isEndOfFile(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
@@ -0,0 +1,49 @@
+package hasher
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSameContent(t *testing.T) {
+	t.Parallel()
+
+	long := strings.Repeat("x", compareChunkSize)
+	tests := []struct {
+		name string
+		a, b string
+		want bool
+	}{
+		{name: "identical", a: "same bytes", b: "same bytes", want: true},
+		{name: "both empty", a: "", b: "", want: true},
+		{name: "one byte differs", a: "same bytes", b: "same bytez"},
+		{name: "prefix", a: "same", b: "same bytes"},
+		{name: "identical across chunks", a: long + "tail", b: long + "tail", want: true},
+		{name: "longer by a chunk boundary", a: long, b: long + "!"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			a := createTestFile(t, dir, "a", tc.a)
+			b := createTestFile(t, dir, "b", tc.b)
+
+			got, err := SameContent(a, b)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+
+			got, err = SameContent(b, a)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+
+	_, err := SameContent(filepath.Join(t.TempDir(), "missing"), createTestFile(t, t.TempDir(), "a", ""))
+	require.Error(t, err)
+}
@@ -1,9 +1,8 @@
-// Package hasher provides SHA256 file hashing utilities with parallel processing support.
+// Package hasher provides file hashing utilities with parallel processing support.
 package hasher
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
+	"context"
 	"errors"
 	"io"
 	"os"
@@ -27,9 +26,15 @@ type HashResult struct {
 	Error error
 }
 
-// Hasher computes SHA256 hashes of files with optional parallel processing.
+// Hasher computes content hashes of files with optional parallel processing.
 type Hasher struct {
 	workers int
+	algo    Algorithm
+	cache   *Cache
+
+	// err is set by New when the selected algorithm is invalid, and
+	// returned by every hashing method.
+	err error
 }
 
 // Option configures a Hasher.
@@ -45,44 +50,100 @@ func WithWorkers(n int) Option {
 	}
 }
 
+// WithAlgorithm selects the hash algorithm. Default is SHA-256. An
+// algorithm [ParseAlgorithm] rejects makes every hash of the Hasher fail.
+func WithAlgorithm(algo Algorithm) Option {
+	return func(h *Hasher) {
+		h.algo = algo
+	}
+}
+
+// WithCache makes the hasher look up full and partial hashes in cache
+// before reading a file, and record the hashes it computes there. A nil
+// cache disables caching.
+func WithCache(cache *Cache) Option {
+	return func(h *Hasher) {
+		h.cache = cache
+	}
+}
+
 // New creates a new Hasher with the given options.
 func New(opts ...Option) *Hasher {
 	h := &Hasher{
 		workers: runtime.NumCPU(),
+		algo:    AlgorithmSHA256,
 	}
 	for _, opt := range opts {
 		opt(h)
 	}
+	h.algo, h.err = ParseAlgorithm(string(h.algo))
 	return h
 }
 
-// ComputeHash computes the full SHA256 hash of a file.
+// Using returns a hasher like h that uses algo instead of its own
+// algorithm, such as to check a hash recorded by another run.
+func (h *Hasher) Using(algo Algorithm) *Hasher {
+	other := *h
+	other.algo, other.err = ParseAlgorithm(string(algo))
+	return &other
+}
+
+// ComputeHash computes the full hash of a file. The hash is hex encoded
+// and, unless SHA-256, prefixed with the algorithm (see [AlgorithmOf]).
 func (h *Hasher) ComputeHash(path string) (string, error) {
+	if h.err != nil {
+		return "", h.err
+	}
+
 	f, err := os.Open(path)
 	if err != nil {
 		return "", err
 	}
 	defer f.Close()
 
-	hash := sha256.New()
-	if _, err := io.Copy(hash, f); err != nil {
-		return "", err
+	return h.cached(path, f, false, 0, func() (string, error) {
+		hash := h.algo.newHash()
+		if _, err := io.Copy(hash, f); err != nil {
+			return "", err
+		}
+
+		return h.algo.format(hash.Sum(nil)), nil
+	})
+}
+
+// ComputeDataHash computes the hash of data held in memory, as
+// [Hasher.ComputeHash] would for a file with that content.
+func (h *Hasher) ComputeDataHash(data []byte) (string, error) {
+	if h.err != nil {
+		return "", h.err
 	}
 
-	return hex.EncodeToString(hash.Sum(nil)), nil
+	hash := h.algo.newHash()
+	hash.Write(data)
+	return h.algo.format(hash.Sum(nil)), nil
 }
 
 // ComputePartialHash computes hash of first and last PartialHashSize bytes.
 // This is much faster than full hash for large files and catches most differences.
 // For files smaller than SmallFileThreshold, it hashes the entire file.
 func (h *Hasher) ComputePartialHash(path string, size int64) (string, error) {
+	if h.err != nil {
+		return "", h.err
+	}
+
 	f, err := os.Open(path)
 	if err != nil {
 		return "", err
 	}
 	defer f.Close()
 
-	hash := sha256.New()
+	return h.cached(path, f, true, size, func() (string, error) {
+		return partialHash(f, size, h.algo)
+	})
+}
+
+func partialHash(f *os.File, size int64, algo Algorithm) (string, error) {
+	hash := algo.newHash()
 
 	// Read first chunk.
 	buf := make([]byte, PartialHashSize)
@@ -105,19 +166,48 @@ func (h *Hasher) ComputePartialHash(path string, size int64) (string, error) {
 		hash.Write(buf[:n])
 	}
 
-	return hex.EncodeToString(hash.Sum(nil)), nil
+	return algo.format(hash.Sum(nil)), nil
+}
+
+// cached returns the hash of the open file f at path from the cache, or
+// computes it with compute and records it. A partial hash computed for a
+// size other than the file's own is not cached.
+func (h *Hasher) cached(path string, f *os.File, partial bool, size int64, compute func() (string, error)) (string, error) {
+	if h.cache == nil {
+		return compute()
+	}
+
+	info, err := f.Stat()
+	if err != nil || (partial && info.Size() != size) {
+		return compute()
+	}
+	if hash, ok := h.cache.lookup(info, h.algo, partial); ok {
+		return hash, nil
+	}
+
+	hash, err := compute()
+	if err != nil {
+		return "", err
+	}
+
+	if after, statErr := f.Stat(); statErr == nil {
+		h.cache.store(path, info, after, h.algo, partial, hash)
+	}
+
+	return hash, nil
 }
 
 // HashFiles computes hashes for multiple files concurrently.
 // Returns a channel that will receive HashResult for each file.
-// The channel is closed when all files have been processed.
-func (h *Hasher) HashFiles(paths []string) <-chan HashResult {
+// The channel is closed when all files have been processed, or early once
+// ctx is done, in which case files not started yet have no result.
+func (h *Hasher) HashFiles(ctx context.Context, paths []string) <-chan HashResult {
 	files := make([]FileToHash, len(paths))
 	for i, path := range paths {
 		files[i] = FileToHash{Path: path}
 	}
 
-	return h.hashFilesConcurrently(files, func(file FileToHash) HashResult {
+	return h.hashFilesConcurrently(ctx, files, func(file FileToHash) HashResult {
 		hash, err := h.ComputeHash(file.Path)
 		var size int64
 		if err == nil {
@@ -144,8 +234,8 @@ type FileToHash struct {
 
 // HashFilesWithSizes computes hashes for files with known sizes.
 // This allows the hasher to use size information for optimizations.
-func (h *Hasher) HashFilesWithSizes(files []FileToHash) <-chan HashResult {
-	return h.hashFilesConcurrently(files, func(file FileToHash) HashResult {
+func (h *Hasher) HashFilesWithSizes(ctx context.Context, files []FileToHash) <-chan HashResult {
+	return h.hashFilesConcurrently(ctx, files, func(file FileToHash) HashResult {
 		hash, err := h.ComputeHash(file.Path)
 		return HashResult{
 			Path:  file.Path,
@@ -158,8 +248,8 @@ func (h *Hasher) HashFilesWithSizes(files []FileToHash) <-chan HashResult {
 
 // HashPartialFilesWithSizes computes partial hashes for files with known sizes.
 // This allows parallel pre-filtering for large file comparisons.
-func (h *Hasher) HashPartialFilesWithSizes(files []FileToHash) <-chan HashResult {
-	return h.hashFilesConcurrently(files, func(file FileToHash) HashResult {
+func (h *Hasher) HashPartialFilesWithSizes(ctx context.Context, files []FileToHash) <-chan HashResult {
+	return h.hashFilesConcurrently(ctx, files, func(file FileToHash) HashResult {
 		hash, err := h.ComputePartialHash(file.Path, file.Size)
 		return HashResult{
 			Path:  file.Path,
@@ -170,7 +260,9 @@ func (h *Hasher) HashPartialFilesWithSizes(files []FileToHash) <-chan HashResult
 	})
 }
 
-func (h *Hasher) hashFilesConcurrently(files []FileToHash, hashFn func(FileToHash) HashResult) <-chan HashResult {
+// hashFilesConcurrently feeds files to the workers until all are hashed or
+// ctx is done. Files being hashed when ctx is done are still reported.
+func (h *Hasher) hashFilesConcurrently(ctx context.Context, files []FileToHash, hashFn func(FileToHash) HashResult) <-chan HashResult {
 	results := make(chan HashResult, h.workers)
 
 	go func() {
@@ -189,8 +281,16 @@ func (h *Hasher) hashFilesConcurrently(files []FileToHash, hashFn func(FileToHas
 			}()
 		}
 
+	feed:
 		for _, file := range files {
-			work <- file
+			if ctx.Err() != nil {
+				break
+			}
+			select {
+			case <-ctx.Done():
+				break feed
+			case work <- file:
+			}
 		}
 		close(work)
 
@@ -200,6 +300,11 @@ func (h *Hasher) hashFilesConcurrently(files []FileToHash, hashFn func(FileToHas
 	return results
 }
 
+// Algorithm returns the hash algorithm configured.
+func (h *Hasher) Algorithm() Algorithm {
+	return h.algo
+}
+
 // Workers returns the number of worker goroutines configured.
 func (h *Hasher) Workers() int {
 	return h.workers
@@ -1,6 +1,7 @@
 package hasher
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"os"
@@ -112,6 +113,25 @@ func TestHasher_ComputeHash_ErrorScenarios(t *testing.T) {
 	}
 }
 
+func TestHasher_ComputeDataHash(t *testing.T) {
+	t.Parallel()
+
+	content := "nested archive bytes"
+	path := createTestFile(t, t.TempDir(), "test.bin", content)
+
+	h := New()
+	fileHash, err := h.ComputeHash(path)
+	require.NoError(t, err)
+
+	dataHash, err := h.ComputeDataHash([]byte(content))
+	require.NoError(t, err)
+	assert.Equal(t, fileHash, dataHash)
+
+	emptyHash, err := h.ComputeDataHash(nil)
+	require.NoError(t, err)
+	assert.Equal(t, expectedHash(""), emptyHash)
+}
+
 func TestHasher_ComputePartialHash(t *testing.T) {
 	t.Parallel()
 
@@ -234,7 +254,7 @@ func TestHasher_HashFiles(t *testing.T) {
 		}
 
 		h := New(WithWorkers(2))
-		results := h.HashFiles(paths)
+		results := h.HashFiles(t.Context(), paths)
 
 		gotHashes := make(map[string]string)
 		for result := range results {
@@ -248,7 +268,7 @@ func TestHasher_HashFiles(t *testing.T) {
 	t.Run("empty input", func(t *testing.T) {
 		t.Parallel()
 		h := New()
-		results := h.HashFiles(nil)
+		results := h.HashFiles(t.Context(), nil)
 
 		count := 0
 		for range results {
@@ -264,7 +284,7 @@ func TestHasher_HashFiles(t *testing.T) {
 		badPath := filepath.Join(tmpDir, "nonexistent.txt")
 
 		h := New()
-		results := h.HashFiles([]string{goodPath, badPath})
+		results := h.HashFiles(t.Context(), []string{goodPath, badPath})
 
 		var goodResult, badResult HashResult
 		for result := range results {
@@ -306,7 +326,7 @@ func TestHasher_HashFilesWithSizes(t *testing.T) {
 	}
 
 	h := New()
-	results := h.HashFilesWithSizes(toHash)
+	results := h.HashFilesWithSizes(t.Context(), toHash)
 
 	count := 0
 	for result := range results {
@@ -346,7 +366,7 @@ func TestHasher_HashPartialFilesWithSizes(t *testing.T) {
 		expected[path] = hash
 	}
 
-	results := h.HashPartialFilesWithSizes(toHash)
+	results := h.HashPartialFilesWithSizes(t.Context(), toHash)
 
 	got := make(map[string]string)
 	for result := range results {
@@ -379,7 +399,7 @@ func TestHasher_HashFiles_Parallel_Correctness(t *testing.T) {
 	}
 
 	h := New(WithWorkers(8))
-	results := h.HashFiles(paths)
+	results := h.HashFiles(t.Context(), paths)
 
 	gotHashes := make(map[string]string)
 	for result := range results {
@@ -414,7 +434,7 @@ func TestHasher_HashFiles_NoRaceCondition(t *testing.T) {
 	// Run multiple times to increase chance of catching race conditions
 	for range 5 {
 		h := New(WithWorkers(16))
-		results := h.HashFiles(paths)
+		results := h.HashFiles(t.Context(), paths)
 
 		var mu sync.Mutex
 		hashes := make(map[string]string)
@@ -430,6 +450,25 @@ func TestHasher_HashFiles_NoRaceCondition(t *testing.T) {
 	}
 }
 
+func TestHasher_HashFiles_StopsWhenCanceled(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	paths := []string{
+		createTestFile(t, tmpDir, "a.txt", "a"),
+		createTestFile(t, tmpDir, "b.txt", "b"),
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+
+	count := 0
+	for range New(WithWorkers(1)).HashFiles(ctx, paths) {
+		count++
+	}
+	assert.Zero(t, count, "no file is started once the context is done")
+}
+
 func TestHasher_SameContentSameHash(t *testing.T) {
 	t.Parallel()
 
@@ -511,7 +550,7 @@ func BenchmarkHasher_HashFiles_Parallel(b *testing.B) {
 	b.ResetTimer()
 
 	for range b.N {
-		for result := range h.HashFiles(paths) {
+		for result := range h.HashFiles(b.Context(), paths) {
 			_ = result // drain results
 		}
 	}
@@ -19,10 +19,11 @@ import (
 // Entry represents a single filesystem mutation logged to the journal.
 type Entry struct {
 	Timestamp time.Time `json:"ts"`
-	Type      string    `json:"type"`           // "trash", "replace", "rename", "mkdir", "extract"
+	Type      string    `json:"type"`           // "trash", "replace", "rename", "mkdir", "extract", "decompress", "duplicate", "link", "trash-dir"
 	Source    string    `json:"src"`            // original path (relative to root)
 	Dest      string    `json:"dst,omitempty"`  // new path (relative to root)
-	Hash      string    `json:"hash,omitempty"` // content hash at time of operation
+	Hash      string    `json:"hash,omitempty"` // content hash at time of operation, "<algorithm>:<hex>" unless SHA-256
+	Kept      string    `json:"kept,omitempty"` // file kept in its place (relative to root, or absolute for a reference outside it)
 	Success   bool      `json:"ok"`             // true after mutation completes
 }
 
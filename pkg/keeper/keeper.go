@@ -0,0 +1,241 @@
+// Package keeper decides which of several files with identical content is
+// kept when the others are removed as duplicates.
+//
+// Candidates are ranked by, in order: the first --prefer-dir pattern their
+// directory matches, whether it matches an --avoid-dir pattern, the
+// [Strategy], and finally their path in lexical order, so the choice is
+// always deterministic.
+package keeper
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"btidy/pkg/collector"
+)
+
+// Strategy selects the copy kept among candidates that the directory
+// patterns rank equally.
+type Strategy string
+
+const (
+	// StrategyFirstLexical keeps the copy whose path sorts first.
+	StrategyFirstLexical Strategy = "first-lexical"
+
+	// StrategyOldest keeps the copy with the oldest modification time.
+	StrategyOldest Strategy = "oldest"
+
+	// StrategyNewest keeps the copy with the newest modification time.
+	StrategyNewest Strategy = "newest"
+
+	// StrategyShortestPath keeps the copy with the shortest path.
+	StrategyShortestPath Strategy = "shortest-path"
+
+	// StrategyDeepestPath keeps the copy nested in the most directories.
+	StrategyDeepestPath Strategy = "deepest-path"
+)
+
+// ParseStrategy converts a command-line value into a [Strategy].
+func ParseStrategy(value string) (Strategy, error) {
+	switch strategy := Strategy(value); strategy {
+	case StrategyFirstLexical, StrategyOldest, StrategyNewest, StrategyShortestPath, StrategyDeepestPath:
+		return strategy, nil
+	default:
+		return "", fmt.Errorf("invalid keep strategy %q (want %s, %s, %s, %s or %s)",
+			value, StrategyFirstLexical, StrategyOldest, StrategyNewest, StrategyShortestPath, StrategyDeepestPath)
+	}
+}
+
+// Policy chooses the copy to keep. The zero value keeps the copy whose
+// path sorts first.
+type Policy struct {
+	// Strategy breaks ties left by the directory patterns; empty means
+	// [StrategyFirstLexical].
+	Strategy Strategy
+
+	// PreferDirs are glob patterns for directories whose copies are kept
+	// first; a copy matching an earlier pattern beats one matching a later
+	// pattern. A pattern without a "/" matches any single directory name,
+	// one with a "/" matches a directory path relative to the root. A copy
+	// matches when its directory or any of its parents does.
+	PreferDirs []string
+
+	// AvoidDirs are glob patterns, matched like PreferDirs, for
+	// directories whose copies are only kept when every copy matches.
+	AvoidDirs []string
+}
+
+// Validate reports an unknown strategy or a malformed pattern.
+func (p Policy) Validate() error {
+	if p.Strategy != "" {
+		if _, err := ParseStrategy(string(p.Strategy)); err != nil {
+			return err
+		}
+	}
+
+	for _, pattern := range slices.Concat(p.PreferDirs, p.AvoidDirs) {
+		if _, err := path.Match(cleanPattern(pattern), ""); err != nil {
+			return fmt.Errorf("invalid directory pattern %q: %w", pattern, err)
+		}
+	}
+
+	return nil
+}
+
+// candidate is a file with the attributes it is ranked by.
+type candidate struct {
+	file        collector.FileInfo
+	preferRank  int
+	preferredBy string
+	avoided     bool
+	depth       int
+}
+
+// Choose sorts files, which all have the same content and live under root,
+// so the copy to keep comes first. It returns why that copy was kept: the
+// ranking rules that set it apart from the other copies, most significant
+// first, joined by ", ". It returns "" when files holds fewer than two
+// copies.
+func (p Policy) Choose(root string, files []collector.FileInfo) string {
+	if len(files) < 2 {
+		return ""
+	}
+
+	candidates := make([]candidate, len(files))
+	for i, file := range files {
+		candidates[i] = p.rank(root, file)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		_, _, less := p.compare(candidates[i], candidates[j])
+		return less
+	})
+	for i := range candidates {
+		files[i] = candidates[i].file
+	}
+
+	reasons := make(map[rule]string)
+	for _, other := range candidates[1:] {
+		r, reason, _ := p.compare(candidates[0], other)
+		reasons[r] = reason
+	}
+
+	parts := make([]string, 0, len(reasons))
+	for r := rulePreferDir; r <= ruleLexical; r++ {
+		if reason, ok := reasons[r]; ok {
+			parts = append(parts, reason)
+		}
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// rank computes the attributes file is ranked by.
+func (p Policy) rank(root string, file collector.FileInfo) candidate {
+	c := candidate{file: file, preferRank: len(p.PreferDirs)}
+
+	dir := "."
+	if rel, err := filepath.Rel(root, file.Dir); err == nil {
+		dir = filepath.ToSlash(rel)
+	}
+	if dir != "." {
+		c.depth = strings.Count(dir, "/") + 1
+	}
+
+	for i, pattern := range p.PreferDirs {
+		if matchDir(pattern, dir) {
+			c.preferRank = i
+			c.preferredBy = pattern
+			break
+		}
+	}
+	for _, pattern := range p.AvoidDirs {
+		if matchDir(pattern, dir) {
+			c.avoided = true
+			break
+		}
+	}
+
+	return c
+}
+
+// rule is a ranking rule, in order of significance.
+type rule int
+
+const (
+	rulePreferDir rule = iota
+	ruleAvoidDir
+	ruleStrategy
+	ruleLexical
+)
+
+// compare reports whether a ranks before b, with the rule that decided
+// and a description of it.
+func (p Policy) compare(a, b candidate) (decidedBy rule, reason string, less bool) {
+	if a.preferRank != b.preferRank {
+		if a.preferRank < b.preferRank {
+			return rulePreferDir, fmt.Sprintf("in preferred directory %q", a.preferredBy), true
+		}
+		return rulePreferDir, fmt.Sprintf("in preferred directory %q", b.preferredBy), false
+	}
+
+	if a.avoided != b.avoided {
+		return ruleAvoidDir, "outside avoided directories", b.avoided
+	}
+
+	switch p.Strategy {
+	case StrategyOldest:
+		if !a.file.ModTime.Equal(b.file.ModTime) {
+			return ruleStrategy, "oldest modification time", a.file.ModTime.Before(b.file.ModTime)
+		}
+	case StrategyNewest:
+		if !a.file.ModTime.Equal(b.file.ModTime) {
+			return ruleStrategy, "newest modification time", a.file.ModTime.After(b.file.ModTime)
+		}
+	case StrategyShortestPath:
+		if len(a.file.Path) != len(b.file.Path) {
+			return ruleStrategy, "shortest path", len(a.file.Path) < len(b.file.Path)
+		}
+	case StrategyDeepestPath:
+		if a.depth != b.depth {
+			return ruleStrategy, "deepest path", a.depth > b.depth
+		}
+	case StrategyFirstLexical, "":
+	}
+
+	return ruleLexical, "first in lexical order", a.file.Path < b.file.Path
+}
+
+// matchDir reports whether pattern matches dir, a slash-separated path
+// relative to the root, or one of its parents. The root itself matches no
+// pattern.
+func matchDir(pattern, dir string) bool {
+	if dir == "." {
+		return false
+	}
+
+	pattern = cleanPattern(pattern)
+	parts := strings.Split(dir, "/")
+	for i := range parts {
+		subject := parts[i]
+		if strings.Contains(pattern, "/") {
+			subject = strings.Join(parts[:i+1], "/")
+		}
+		if ok, _ := path.Match(pattern, subject); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// cleanPattern strips the "./" and "/" a user may wrap a directory
+// pattern in.
+func cleanPattern(pattern string) string {
+	pattern = strings.TrimPrefix(filepath.ToSlash(pattern), "./")
+	return strings.Trim(pattern, "/")
+}
@@ -0,0 +1,145 @@
+package keeper
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"btidy/pkg/collector"
+)
+
+const root = "/backup"
+
+func fileAt(rel string, modTime time.Time) collector.FileInfo {
+	path := filepath.Join(root, filepath.FromSlash(rel))
+	return collector.FileInfo{
+		Path:    path,
+		Dir:     filepath.Dir(path),
+		Name:    filepath.Base(path),
+		ModTime: modTime,
+	}
+}
+
+func keptName(t *testing.T, files []collector.FileInfo) string {
+	t.Helper()
+
+	rel, err := filepath.Rel(root, files[0].Path)
+	require.NoError(t, err)
+	return filepath.ToSlash(rel)
+}
+
+func TestParseStrategy(t *testing.T) {
+	for _, value := range []string{"first-lexical", "oldest", "newest", "shortest-path", "deepest-path"} {
+		strategy, err := ParseStrategy(value)
+		require.NoError(t, err)
+		assert.Equal(t, Strategy(value), strategy)
+	}
+
+	_, err := ParseStrategy("largest")
+	require.Error(t, err)
+}
+
+func TestPolicy_Validate(t *testing.T) {
+	require.NoError(t, Policy{}.Validate())
+	require.NoError(t, Policy{Strategy: StrategyNewest, PreferDirs: []string{"Photos/*"}, AvoidDirs: []string{"*copy*"}}.Validate())
+	require.Error(t, Policy{Strategy: "largest"}.Validate())
+	require.Error(t, Policy{AvoidDirs: []string{"[tmp"}}.Validate())
+}
+
+func TestPolicy_Choose(t *testing.T) {
+	early := time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC)
+	late := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		policy     Policy
+		wantKept   string
+		wantReason string
+	}{
+		{
+			name:       "zero value keeps first lexical",
+			wantKept:   "a/b/c/photo.jpg",
+			wantReason: "first in lexical order",
+		},
+		{
+			name:       "oldest",
+			policy:     Policy{Strategy: StrategyOldest},
+			wantKept:   "old copy/photo.jpg",
+			wantReason: "oldest modification time",
+		},
+		{
+			name:       "newest ties fall back to lexical order",
+			policy:     Policy{Strategy: StrategyNewest},
+			wantKept:   "a/b/c/photo.jpg",
+			wantReason: "newest modification time, first in lexical order",
+		},
+		{
+			name:       "shortest path",
+			policy:     Policy{Strategy: StrategyShortestPath},
+			wantKept:   "x/photo.jpg",
+			wantReason: "shortest path",
+		},
+		{
+			name:       "deepest path",
+			policy:     Policy{Strategy: StrategyDeepestPath},
+			wantKept:   "a/b/c/photo.jpg",
+			wantReason: "deepest path",
+		},
+		{
+			name:       "earlier preferred pattern wins",
+			policy:     Policy{PreferDirs: []string{"x", "a/b"}},
+			wantKept:   "x/photo.jpg",
+			wantReason: `in preferred directory "x"`,
+		},
+		{
+			name:       "pattern with a slash matches a parent path",
+			policy:     Policy{PreferDirs: []string{"a/*"}},
+			wantKept:   "a/b/c/photo.jpg",
+			wantReason: `in preferred directory "a/*"`,
+		},
+		{
+			name:       "avoided directories lose",
+			policy:     Policy{Strategy: StrategyNewest, AvoidDirs: []string{"a", "x"}},
+			wantKept:   "old copy/photo.jpg",
+			wantReason: "outside avoided directories",
+		},
+		{
+			name:       "preferred beats avoided",
+			policy:     Policy{PreferDirs: []string{"c"}, AvoidDirs: []string{"a"}},
+			wantKept:   "a/b/c/photo.jpg",
+			wantReason: `in preferred directory "c"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := []collector.FileInfo{
+				fileAt("x/photo.jpg", late),
+				fileAt("old copy/photo.jpg", early),
+				fileAt("a/b/c/photo.jpg", late),
+			}
+
+			reason := tt.policy.Choose(root, files)
+			assert.Equal(t, tt.wantKept, keptName(t, files))
+			assert.Equal(t, tt.wantReason, reason)
+			assert.Len(t, files, 3)
+		})
+	}
+}
+
+func TestPolicy_ChooseSingleFile(t *testing.T) {
+	files := []collector.FileInfo{fileAt("photo.jpg", time.Time{})}
+	assert.Empty(t, Policy{}.Choose(root, files))
+}
+
+func TestMatchDir(t *testing.T) {
+	assert.True(t, matchDir("Photos", "Photos"))
+	assert.True(t, matchDir("Photos", "archive/Photos/2019"))
+	assert.True(t, matchDir("*copy*", "Backup copy"))
+	assert.True(t, matchDir("./Photos/2019/", "Photos/2019/trip"))
+	assert.False(t, matchDir("Photos/2019", "archive/Photos/2019"))
+	assert.False(t, matchDir("Photos", "."))
+}
@@ -4,6 +4,7 @@
 package manifest
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -62,8 +63,9 @@ func NewGenerator(rootDir string, workers int) (*Generator, error) {
 }
 
 // NewGeneratorWithValidator creates a new manifest generator with an existing
-// path validator.
-func NewGeneratorWithValidator(validator *safepath.Validator, workers int) (*Generator, error) {
+// path validator. Hasher options, such as [hasher.WithCache], are passed on
+// to the hasher of the generator.
+func NewGeneratorWithValidator(validator *safepath.Validator, workers int, hashOpts ...hasher.Option) (*Generator, error) {
 	if validator == nil {
 		return nil, errors.New("validator is required")
 	}
@@ -72,6 +74,7 @@ func NewGeneratorWithValidator(validator *safepath.Validator, workers int) (*Gen
 	if workers > 0 {
 		opts = append(opts, hasher.WithWorkers(workers))
 	}
+	opts = append(opts, hashOpts...)
 
 	return &Generator{
 		rootDir:   validator.Root(),
@@ -80,15 +83,16 @@ func NewGeneratorWithValidator(validator *safepath.Validator, workers int) (*Gen
 	}, nil
 }
 
-// Generate creates a manifest of all files in the directory.
-func (g *Generator) Generate(opts GenerateOptions) (*Manifest, error) {
+// Generate creates a manifest of all files in the directory. It returns
+// the context's error when ctx is done before every file is hashed.
+func (g *Generator) Generate(ctx context.Context, opts GenerateOptions) (*Manifest, error) {
 	// Collect all files
 	c := collector.New(collector.Options{
 		SkipFiles: opts.SkipFiles,
 		SkipDirs:  opts.SkipDirs,
 	})
 
-	files, err := c.Collect(g.rootDir)
+	files, err := c.Collect(ctx, g.rootDir)
 	if err != nil {
 		return nil, fmt.Errorf("failed to collect files: %w", err)
 	}
@@ -128,7 +132,7 @@ func (g *Generator) Generate(opts GenerateOptions) (*Manifest, error) {
 	}
 
 	// Hash files in parallel
-	results := g.hasher.HashFilesWithSizes(toHash)
+	results := g.hasher.HashFilesWithSizes(ctx, toHash)
 
 	processed := 0
 	total := len(readableFiles)
@@ -161,6 +165,10 @@ func (g *Generator) Generate(opts GenerateOptions) (*Manifest, error) {
 		}
 	}
 
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	// Sort entries by path for deterministic output
 	sort.Slice(manifest.Entries, func(i, j int) bool {
 		return manifest.Entries[i].Path < manifest.Entries[j].Path
@@ -217,6 +225,24 @@ func (m *Manifest) HashIndex() map[string][]string {
 	return index
 }
 
+// Algorithms returns the hash algorithms of the manifest entries, sorted.
+// Entries whose algorithm is unknown are left out.
+func (m *Manifest) Algorithms() []hasher.Algorithm {
+	seen := make(map[hasher.Algorithm]struct{})
+	for _, entry := range m.Entries {
+		if algo, err := hasher.AlgorithmOf(entry.Hash); err == nil {
+			seen[algo] = struct{}{}
+		}
+	}
+
+	algos := make([]hasher.Algorithm, 0, len(seen))
+	for algo := range seen {
+		algos = append(algos, algo)
+	}
+	sort.Slice(algos, func(i, j int) bool { return algos[i] < algos[j] })
+	return algos
+}
+
 // TotalSize returns the total size of all files in the manifest.
 func (m *Manifest) TotalSize() int64 {
 	var total int64
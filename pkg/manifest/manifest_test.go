@@ -1,6 +1,7 @@
 package manifest
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"os"
@@ -65,7 +66,7 @@ func TestGenerator_Generate_EmptyDirectory(t *testing.T) {
 	g, err := NewGenerator(tmpDir, 0)
 	require.NoError(t, err)
 
-	m, err := g.Generate(GenerateOptions{})
+	m, err := g.Generate(t.Context(), GenerateOptions{})
 	require.NoError(t, err)
 
 	assert.Equal(t, 1, m.Version)
@@ -84,7 +85,7 @@ func TestGenerator_Generate_SingleFile(t *testing.T) {
 	g, err := NewGenerator(tmpDir, 0)
 	require.NoError(t, err)
 
-	m, err := g.Generate(GenerateOptions{})
+	m, err := g.Generate(t.Context(), GenerateOptions{})
 	require.NoError(t, err)
 
 	require.Len(t, m.Entries, 1)
@@ -112,7 +113,7 @@ func TestGenerator_Generate_MultipleFiles(t *testing.T) {
 	g, err := NewGenerator(tmpDir, 0)
 	require.NoError(t, err)
 
-	m, err := g.Generate(GenerateOptions{})
+	m, err := g.Generate(t.Context(), GenerateOptions{})
 	require.NoError(t, err)
 
 	assert.Len(t, m.Entries, len(files))
@@ -142,7 +143,7 @@ func TestGenerator_Generate_WithSkipFiles(t *testing.T) {
 	g, err := NewGenerator(tmpDir, 0)
 	require.NoError(t, err)
 
-	m, err := g.Generate(GenerateOptions{
+	m, err := g.Generate(t.Context(), GenerateOptions{
 		SkipFiles: []string{".DS_Store", "Thumbs.db"},
 	})
 	require.NoError(t, err)
@@ -165,7 +166,7 @@ func TestGenerator_Generate_WithProgress(t *testing.T) {
 	require.NoError(t, err)
 
 	var progressCalls []int
-	m, err := g.Generate(GenerateOptions{
+	m, err := g.Generate(t.Context(), GenerateOptions{
 		OnProgress: func(processed, _ int, _ string) {
 			progressCalls = append(progressCalls, processed)
 		},
@@ -200,11 +201,27 @@ func TestGenerator_Generate_UnsafeSymlinkRejected(t *testing.T) {
 	g, err := NewGenerator(rootDir, 0)
 	require.NoError(t, err)
 
-	_, err = g.Generate(GenerateOptions{})
+	_, err = g.Generate(t.Context(), GenerateOptions{})
 	require.Error(t, err)
 	require.ErrorIs(t, err, safepath.ErrSymlinkEscape)
 }
 
+func TestGenerator_Generate_Canceled(t *testing.T) {
+	t.Parallel()
+
+	rootDir := t.TempDir()
+	testutil.CreateFile(t, filepath.Join(rootDir, "file.txt"), "content")
+
+	g, err := NewGenerator(rootDir, 0)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+
+	_, err = g.Generate(ctx, GenerateOptions{})
+	require.ErrorIs(t, err, context.Canceled)
+}
+
 func TestManifest_SaveLoad_RoundTrip(t *testing.T) {
 	t.Parallel()
 	tmpDir := t.TempDir()
@@ -354,7 +371,7 @@ func TestManifest_RelativePaths(t *testing.T) {
 	g, err := NewGenerator(tmpDir, 0)
 	require.NoError(t, err)
 
-	m, err := g.Generate(GenerateOptions{})
+	m, err := g.Generate(t.Context(), GenerateOptions{})
 	require.NoError(t, err)
 
 	// All paths should be relative
@@ -376,7 +393,7 @@ func TestGenerator_Generate_DuplicateContent(t *testing.T) {
 	g, err := NewGenerator(tmpDir, 0)
 	require.NoError(t, err)
 
-	m, err := g.Generate(GenerateOptions{})
+	m, err := g.Generate(t.Context(), GenerateOptions{})
 	require.NoError(t, err)
 
 	assert.Equal(t, 3, m.FileCount())
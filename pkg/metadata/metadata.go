@@ -53,6 +53,11 @@ func (d *Dir) ManifestPath(runID string) string {
 	return filepath.Join(d.root, "manifests", runID+".json")
 }
 
+// HashCachePath returns the path of the persistent hash cache.
+func (d *Dir) HashCachePath() string {
+	return filepath.Join(d.root, "hashcache")
+}
+
 // LockPath returns the advisory lock file path.
 func (d *Dir) LockPath() string {
 	return filepath.Join(d.root, "lock")
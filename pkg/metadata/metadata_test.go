@@ -103,6 +103,18 @@ func TestDir_LockPath(t *testing.T) {
 	assert.Equal(t, expected, d.LockPath())
 }
 
+func TestDir_HashCachePath(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	v := newValidator(t, root)
+	d, err := Init(root, v)
+	require.NoError(t, err)
+
+	expected := filepath.Join(root, DirName, "hashcache")
+	assert.Equal(t, expected, d.HashCachePath())
+}
+
 func TestDir_RunID_Format(t *testing.T) {
 	t.Parallel()
 
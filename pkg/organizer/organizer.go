@@ -2,6 +2,7 @@
 package organizer
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"path/filepath"
@@ -65,11 +66,13 @@ func NewWithValidator(validator *safepath.Validator, dryRun bool) (*Organizer, e
 
 // OrganizeFiles groups all files into extension subdirectories.
 func (o *Organizer) OrganizeFiles(files []collector.FileInfo) Result {
-	return o.OrganizeFilesWithProgress(files, nil)
+	return o.OrganizeFilesWithProgress(context.Background(), files, nil)
 }
 
 // OrganizeFilesWithProgress groups files and reports per-file progress.
-func (o *Organizer) OrganizeFilesWithProgress(files []collector.FileInfo, onProgress func(processed, total int)) Result {
+// Once ctx is done no further file is moved; the result holds the
+// operations performed so far.
+func (o *Organizer) OrganizeFilesWithProgress(ctx context.Context, files []collector.FileInfo, onProgress func(processed, total int)) Result {
 	result := Result{
 		TotalFiles: len(files),
 		Operations: make([]MoveOperation, 0, len(files)),
@@ -99,6 +102,10 @@ func (o *Organizer) OrganizeFilesWithProgress(files []collector.FileInfo, onProg
 
 	totalFiles := len(files)
 	for i := range files {
+		if ctx.Err() != nil {
+			break
+		}
+
 		op := o.processFile(&files[i], nameCount, createdDirs)
 		result.Operations = append(result.Operations, op)
 
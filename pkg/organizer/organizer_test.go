@@ -1,6 +1,7 @@
 package organizer
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"testing"
@@ -23,7 +24,7 @@ func collectFiles(t *testing.T, root string) []collector.FileInfo {
 	t.Helper()
 
 	c := collector.New(collector.Options{})
-	files, err := c.Collect(root)
+	files, err := c.Collect(t.Context(), root)
 	require.NoError(t, err)
 
 	return files
@@ -375,10 +376,31 @@ func TestOrganizer_ProgressCallback(t *testing.T) {
 	require.NoError(t, err)
 
 	var progressCalls []int
-	result := o.OrganizeFilesWithProgress(files, func(processed, _ int) {
+	result := o.OrganizeFilesWithProgress(t.Context(), files, func(processed, _ int) {
 		progressCalls = append(progressCalls, processed)
 	})
 
 	assert.Equal(t, 2, result.TotalFiles)
 	assert.Equal(t, []int{1, 2}, progressCalls)
 }
+
+func TestOrganizer_StopsWhenCanceled(t *testing.T) {
+	tmpDir := t.TempDir()
+	modTime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	createTestFile(t, filepath.Join(tmpDir, "a.pdf"), "pdf", modTime)
+	createTestFile(t, filepath.Join(tmpDir, "b.txt"), "txt", modTime)
+
+	o, err := New(tmpDir, false)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	result := o.OrganizeFilesWithProgress(ctx, collectFiles(t, tmpDir), func(_, _ int) {
+		cancel()
+	})
+
+	require.Len(t, result.Operations, 1, "the move in progress completes, no other starts")
+	assert.Equal(t, 1, result.MovedCount)
+	assert.FileExists(t, filepath.Join(tmpDir, "b.txt"))
+}
@@ -3,6 +3,7 @@
 package renamer
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
@@ -90,11 +91,13 @@ func NewWithValidator(validator *safepath.Validator, dryRun bool, trasher *trash
 // RenameFiles renames all files in the given list according to the naming conventions.
 // Files are renamed in place (same directory).
 func (r *Renamer) RenameFiles(files []collector.FileInfo) Result {
-	return r.RenameFilesWithProgress(files, nil)
+	return r.RenameFilesWithProgress(context.Background(), files, nil)
 }
 
 // RenameFilesWithProgress renames files and reports per-file progress.
-func (r *Renamer) RenameFilesWithProgress(files []collector.FileInfo, onProgress func(processed, total int)) Result {
+// Once ctx is done no further file is renamed; the result holds the
+// operations performed so far.
+func (r *Renamer) RenameFilesWithProgress(ctx context.Context, files []collector.FileInfo, onProgress func(processed, total int)) Result {
 	result := Result{
 		TotalFiles: len(files),
 		Operations: make([]RenameOperation, 0, len(files)),
@@ -119,6 +122,10 @@ func (r *Renamer) RenameFilesWithProgress(files []collector.FileInfo, onProgress
 	dirNames := make(map[string]map[string]nameUsage) // dir -> name -> usage
 
 	for i, f := range files {
+		if ctx.Err() != nil {
+			break
+		}
+
 		op := r.processFile(f, dirNames)
 		result.Operations = append(result.Operations, op)
 
@@ -32,7 +32,7 @@ func collectFiles(t *testing.T, root string) []collector.FileInfo {
 	t.Helper()
 
 	c := collector.New(collector.Options{})
-	files, err := c.Collect(root)
+	files, err := c.Collect(t.Context(), root)
 	require.NoError(t, err)
 
 	return files
@@ -0,0 +1,143 @@
+package sevenzip
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf16"
+)
+
+const (
+	// aesCyclesMask selects the key derivation work factor, a power of
+	// two, from the first property byte.
+	aesCyclesMask = 0x3f
+
+	// aesCyclesPlain means the key is the salt and password themselves,
+	// without hashing.
+	aesCyclesPlain = 0x3f
+
+	// aesMaxCycles is the largest work factor 7-Zip accepts; it writes 19.
+	aesMaxCycles = 24
+
+	// aesKeyLen is the key length of 7zAES, which always uses AES-256.
+	aesKeyLen = 32
+)
+
+// decodeAES decrypts 7zAES data: AES-256 in CBC mode, keyed with a SHA-256
+// of the salt and the UTF-16LE password repeated 2^cycles times. The
+// properties hold the work factor, salt and IV.
+func decodeAES(r *Reader, props []byte, in io.Reader, _ uint64) (io.Reader, error) {
+	if r.password == "" {
+		return nil, ErrPasswordRequired
+	}
+	if len(props) == 0 {
+		return nil, fmt.Errorf("%w: 7zAES without properties", ErrFormat)
+	}
+
+	cycles := props[0] & aesCyclesMask
+	var salt, iv []byte
+	if props[0]&0xc0 != 0 {
+		if len(props) < 2 {
+			return nil, fmt.Errorf("%w: short 7zAES properties", ErrFormat)
+		}
+		saltLen := int(props[0]>>7&1) + int(props[1]>>4)
+		ivLen := int(props[0]>>6&1) + int(props[1]&0x0f)
+		if len(props) < 2+saltLen+ivLen {
+			return nil, fmt.Errorf("%w: short 7zAES properties", ErrFormat)
+		}
+		salt = props[2 : 2+saltLen]
+		iv = props[2+saltLen : 2+saltLen+ivLen]
+	}
+	if cycles > aesMaxCycles && cycles != aesCyclesPlain {
+		return nil, fmt.Errorf("%w: 7zAES work factor 2^%d", ErrAlgorithm, cycles)
+	}
+
+	block, err := aes.NewCipher(r.aesKey(salt, cycles))
+	if err != nil {
+		return nil, err
+	}
+
+	var fullIV [aes.BlockSize]byte
+	copy(fullIV[:], iv)
+
+	return &cbcReader{r: in, mode: cipher.NewCBCDecrypter(block, fullIV[:])}, nil
+}
+
+// aesKey derives the 7zAES key for the reader's password, caching it per
+// salt and work factor.
+func (r *Reader) aesKey(salt []byte, cycles byte) []byte {
+	cacheKey := string(append([]byte{cycles}, salt...))
+	if key, ok := r.keys[cacheKey]; ok {
+		return key
+	}
+
+	units := utf16.Encode([]rune(r.password))
+	password := make([]byte, 0, 2*len(units))
+	for _, u := range units {
+		password = binary.LittleEndian.AppendUint16(password, u)
+	}
+
+	key := make([]byte, aesKeyLen)
+	if cycles == aesCyclesPlain {
+		copy(key, append(append([]byte{}, salt...), password...))
+	} else {
+		h := sha256.New()
+		var counter [8]byte
+		for i := uint64(0); i < 1<<cycles; i++ {
+			binary.LittleEndian.PutUint64(counter[:], i)
+			h.Write(salt)
+			h.Write(password)
+			h.Write(counter[:])
+		}
+		key = h.Sum(key[:0])
+	}
+
+	if r.keys == nil {
+		r.keys = make(map[string][]byte)
+	}
+	r.keys[cacheKey] = key
+
+	return key
+}
+
+// cbcReader decrypts a CBC stream whose length is a multiple of the block
+// size.
+type cbcReader struct {
+	r    io.Reader
+	mode cipher.BlockMode
+	buf  [32 * aes.BlockSize]byte
+	out  []byte
+	err  error
+}
+
+func (c *cbcReader) Read(p []byte) (int, error) {
+	for len(c.out) == 0 {
+		if c.err != nil {
+			return 0, c.err
+		}
+
+		n, err := io.ReadFull(c.r, c.buf[:])
+		switch {
+		case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+			c.err = io.EOF
+		case err != nil:
+			c.err = err
+		}
+		if n%aes.BlockSize != 0 {
+			c.err = fmt.Errorf("%w: encrypted data is not a whole number of blocks", ErrFormat)
+			n -= n % aes.BlockSize
+		}
+
+		c.mode.CryptBlocks(c.buf[:n], c.buf[:n])
+		c.out = c.buf[:n]
+	}
+
+	n := copy(p, c.out)
+	c.out = c.out[n:]
+
+	return n, nil
+}
@@ -0,0 +1,199 @@
+package sevenzip
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/flate"
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	"github.com/ulikunitz/xz/lzma"
+
+	"btidy/pkg/deflate64"
+)
+
+// Coder method IDs, as stored in the header.
+const (
+	methodCopy      = "\x00"
+	methodLZMA2     = "\x21"
+	methodLZMA      = "\x03\x01\x01"
+	methodDeflate   = "\x04\x01\x08"
+	methodDeflate64 = "\x04\x01\x09"
+	methodBZip2     = "\x04\x02\x02"
+	methodAES       = "\x06\xf1\x07\x01"
+)
+
+// decoderFunc returns the decoded form of in, which size bytes of output
+// are read from. props are the coder properties from the header.
+type decoderFunc func(r *Reader, props []byte, in io.Reader, size uint64) (io.Reader, error)
+
+// decoders maps the implemented method IDs to their decoders.
+var decoders = map[string]decoderFunc{
+	methodCopy:      decodeCopy,
+	methodLZMA:      decodeLZMA,
+	methodLZMA2:     decodeLZMA2,
+	methodDeflate:   decodeDeflate,
+	methodDeflate64: decodeDeflate64,
+	methodBZip2:     decodeBZip2,
+	methodAES:       decodeAES,
+}
+
+// methodNames names well-known methods in error messages.
+var methodNames = map[string]string{
+	methodCopy:         "Copy",
+	methodLZMA:         "LZMA",
+	methodLZMA2:        "LZMA2",
+	methodDeflate:      "Deflate",
+	methodDeflate64:    "Deflate64",
+	methodBZip2:        "BZip2",
+	methodAES:          "7zAES",
+	"\x03":             "Delta",
+	"\x03\x03\x01\x03": "BCJ",
+	"\x03\x03\x01\x1b": "BCJ2",
+	"\x03\x03\x02\x05": "PPC",
+	"\x03\x03\x04\x01": "IA64",
+	"\x03\x03\x05\x01": "ARM",
+	"\x03\x03\x07\x01": "ARMT",
+	"\x03\x03\x08\x05": "SPARC",
+	"\x0a":             "ARM64",
+	"\x03\x04\x01":     "PPMd",
+	"\x04\xf7\x11\x01": "Zstandard",
+}
+
+// methodName returns a readable name for method.
+func methodName(method []byte) string {
+	if name, ok := methodNames[string(method)]; ok {
+		return name
+	}
+
+	return "0x" + hex.EncodeToString(method)
+}
+
+// checkFolder returns an error wrapping [ErrAlgorithm] when f uses a coder
+// this package does not implement. Only chains of single-stream coders are
+// supported, which excludes BCJ2.
+func checkFolder(f *folder) error {
+	for _, c := range f.coders {
+		if _, ok := decoders[string(c.method)]; !ok {
+			return fmt.Errorf("%w %s", ErrAlgorithm, methodName(c.method))
+		}
+		if c.numIn != 1 || c.numOut != 1 {
+			return fmt.Errorf("%w %s with %d streams", ErrAlgorithm, methodName(c.method), c.numIn+c.numOut)
+		}
+	}
+
+	return nil
+}
+
+// folderReader returns the decoded data of folder fi of si.
+func (r *Reader) folderReader(si *streamsInfo, fi int) (io.Reader, error) {
+	f := si.folders[fi]
+	if err := checkFolder(f); err != nil {
+		return nil, err
+	}
+
+	return r.coderOutput(si, f, f.mainOut(), len(f.coders))
+}
+
+// coderOutput returns output stream out of folder f, decoding its inputs
+// recursively. depth bounds the recursion against bind pairs that form a
+// cycle.
+func (r *Reader) coderOutput(si *streamsInfo, f *folder, out, depth int) (io.Reader, error) {
+	if depth == 0 {
+		return nil, fmt.Errorf("%w: coder chain loops", ErrFormat)
+	}
+
+	ci, in, ok := f.coderForOut(out)
+	if !ok {
+		return nil, fmt.Errorf("%w: coder output out of range", ErrFormat)
+	}
+	c := f.coders[ci]
+
+	var input io.Reader
+	if bp, bound := f.bindPairForIn(in); bound {
+		var err error
+		if input, err = r.coderOutput(si, f, bp.out, depth-1); err != nil {
+			return nil, err
+		}
+	} else {
+		packed := -1
+		for i, p := range f.packed {
+			if p == in {
+				packed = f.firstPack + i
+			}
+		}
+		if packed < 0 {
+			return nil, fmt.Errorf("%w: coder input is not connected", ErrFormat)
+		}
+		input = io.NewSectionReader(r.r, si.packOffsets[packed], int64(si.packSizes[packed])) //nolint:gosec // checked against the file size
+	}
+
+	size := f.unpackSizes[out]
+	dec, err := decoders[string(c.method)](r, c.props, input, size)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.LimitReader(dec, int64(min(size, 1<<62))), nil //nolint:gosec // clamped above
+}
+
+func decodeCopy(_ *Reader, _ []byte, in io.Reader, _ uint64) (io.Reader, error) {
+	return in, nil
+}
+
+// decodeLZMA reads a raw LZMA stream. The 5 property bytes match the start
+// of a classic .lzma header, which is completed with the known size.
+func decodeLZMA(_ *Reader, props []byte, in io.Reader, size uint64) (io.Reader, error) {
+	if len(props) != 5 {
+		return nil, fmt.Errorf("%w: LZMA properties of %d bytes", ErrFormat, len(props))
+	}
+
+	header := make([]byte, lzma.HeaderLen)
+	copy(header, props)
+	for i := range 8 {
+		header[5+i] = byte(size >> (8 * i))
+	}
+
+	dec, err := lzma.NewReader(io.MultiReader(bytes.NewReader(header), in))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrFormat, err)
+	}
+
+	return dec, nil
+}
+
+// decodeLZMA2 reads an LZMA2 chunk stream. Its single property byte
+// encodes the dictionary size, which is capped at the output size because
+// no match can reach further back.
+func decodeLZMA2(_ *Reader, props []byte, in io.Reader, size uint64) (io.Reader, error) {
+	if len(props) != 1 {
+		return nil, fmt.Errorf("%w: LZMA2 properties of %d bytes", ErrFormat, len(props))
+	}
+
+	dictCap, err := lzma.DecodeDictCap(props[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrFormat, err)
+	}
+	dictCap = min(dictCap, int64(min(size, lzma.MaxDictCap))) //nolint:gosec // clamped to MaxDictCap
+	dictCap = max(dictCap, lzma.MinDictCap)
+
+	dec, err := lzma.Reader2Config{DictCap: int(dictCap)}.NewReader2(in)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrFormat, err)
+	}
+
+	return dec, nil
+}
+
+func decodeDeflate(_ *Reader, _ []byte, in io.Reader, _ uint64) (io.Reader, error) {
+	return flate.NewReader(in), nil
+}
+
+func decodeDeflate64(_ *Reader, _ []byte, in io.Reader, _ uint64) (io.Reader, error) {
+	return deflate64.NewReader(in), nil
+}
+
+func decodeBZip2(_ *Reader, _ []byte, in io.Reader, _ uint64) (io.Reader, error) {
+	return bzip2.NewReader(in), nil
+}
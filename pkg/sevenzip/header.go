@@ -0,0 +1,943 @@
+package sevenzip
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+	"unicode/utf16"
+)
+
+// Property IDs of the 7z header.
+const (
+	idEnd                   = 0x00
+	idHeader                = 0x01
+	idArchiveProperties     = 0x02
+	idAdditionalStreamsInfo = 0x03
+	idMainStreamsInfo       = 0x04
+	idFilesInfo             = 0x05
+	idPackInfo              = 0x06
+	idUnpackInfo            = 0x07
+	idSubStreamsInfo        = 0x08
+	idSize                  = 0x09
+	idCRC                   = 0x0a
+	idFolder                = 0x0b
+	idCodersUnpackSize      = 0x0c
+	idNumUnpackStream       = 0x0d
+	idEmptyStream           = 0x0e
+	idEmptyFile             = 0x0f
+	idName                  = 0x11
+	idMTime                 = 0x14
+	idWinAttributes         = 0x15
+	idEncodedHeader         = 0x17
+)
+
+const (
+	// maxCoders bounds the coders of one folder; 7-Zip writes at most 4.
+	maxCoders = 32
+
+	// coderFlagComplex, coderFlagProps and coderFlagAlternative are the
+	// bits of a coder's flag byte besides the method ID length.
+	coderFlagComplex     = 0x10
+	coderFlagProps       = 0x20
+	coderFlagAlternative = 0x80
+
+	// fileTimeEpochDelta is the number of 100 ns FILETIME intervals
+	// between 1601-01-01 and the Unix epoch.
+	fileTimeEpochDelta = 116444736000000000
+)
+
+// coder is one step of a folder's decoding chain.
+type coder struct {
+	method []byte
+	numIn  int
+	numOut int
+	props  []byte
+}
+
+// bindPair connects the output stream out of one coder to the input
+// stream in of another.
+type bindPair struct {
+	in  int
+	out int
+}
+
+// folder is one packed stream decoded through a chain of coders.
+type folder struct {
+	coders    []coder
+	bindPairs []bindPair
+
+	// packed lists the coder input streams fed from packed streams.
+	packed []int
+
+	// unpackSizes holds the size of every coder output stream.
+	unpackSizes []uint64
+
+	crc    uint32
+	hasCRC bool
+
+	// firstPack is the index of the folder's first packed stream.
+	firstPack int
+
+	// numSubstreams is the number of files stored in the folder.
+	numSubstreams int
+}
+
+// mainOut returns the output stream that no other coder consumes: the
+// decoded folder data.
+func (f *folder) mainOut() int {
+	for out := range f.unpackSizes {
+		if _, bound := f.bindPairForOut(out); !bound {
+			return out
+		}
+	}
+
+	return 0
+}
+
+func (f *folder) unpackSize() uint64 {
+	if len(f.unpackSizes) == 0 {
+		return 0
+	}
+
+	return f.unpackSizes[f.mainOut()]
+}
+
+func (f *folder) bindPairForOut(out int) (bindPair, bool) {
+	for _, bp := range f.bindPairs {
+		if bp.out == out {
+			return bp, true
+		}
+	}
+
+	return bindPair{}, false
+}
+
+func (f *folder) bindPairForIn(in int) (bindPair, bool) {
+	for _, bp := range f.bindPairs {
+		if bp.in == in {
+			return bp, true
+		}
+	}
+
+	return bindPair{}, false
+}
+
+// coderForOut returns the coder that produces output stream out, and the
+// index of that coder's first input stream.
+func (f *folder) coderForOut(out int) (int, int, bool) {
+	firstIn, firstOut := 0, 0
+	for i, c := range f.coders {
+		if out < firstOut+c.numOut {
+			return i, firstIn, true
+		}
+		firstIn += c.numIn
+		firstOut += c.numOut
+	}
+
+	return 0, 0, false
+}
+
+func (f *folder) encrypted() bool {
+	for _, c := range f.coders {
+		if string(c.method) == methodAES {
+			return true
+		}
+	}
+
+	return false
+}
+
+// substream is the data of one file inside a folder.
+type substream struct {
+	folder int
+	offset uint64
+	size   uint64
+	crc    uint32
+	hasCRC bool
+}
+
+// streamsInfo describes the packed streams of an archive, the folders that
+// decode them and how the decoded data splits into files.
+type streamsInfo struct {
+	// packOffsets holds the absolute file offset of each packed stream.
+	packOffsets []int64
+	packSizes   []uint64
+	folders     []*folder
+	substreams  []substream
+}
+
+// headerReader decodes the primitive types of the 7z header.
+type headerReader struct {
+	buf []byte
+	pos int
+}
+
+func (h *headerReader) remaining() int {
+	return len(h.buf) - h.pos
+}
+
+func (h *headerReader) byte() (byte, error) {
+	if h.pos >= len(h.buf) {
+		return 0, fmt.Errorf("%w: header ends early", ErrFormat)
+	}
+	b := h.buf[h.pos]
+	h.pos++
+
+	return b, nil
+}
+
+func (h *headerReader) bytes(n uint64) ([]byte, error) {
+	if n > uint64(h.remaining()) { //nolint:gosec // remaining is never negative
+		return nil, fmt.Errorf("%w: header ends early", ErrFormat)
+	}
+	b := h.buf[h.pos : h.pos+int(n)] //nolint:gosec // bounded by the buffer above
+	h.pos += int(n)                  //nolint:gosec // bounded by the buffer above
+
+	return b, nil
+}
+
+// number reads a 7z variable-length integer: the count of leading one bits
+// in the first byte gives the number of little-endian bytes that follow,
+// and the rest of the first byte holds the high bits.
+func (h *headerReader) number() (uint64, error) {
+	first, err := h.byte()
+	if err != nil {
+		return 0, err
+	}
+
+	var value uint64
+	mask := byte(0x80)
+	for i := range 8 {
+		if first&mask == 0 {
+			high := uint64(first & (mask - 1))
+			return value | high<<(8*i), nil
+		}
+		b, err := h.byte()
+		if err != nil {
+			return 0, err
+		}
+		value |= uint64(b) << (8 * i)
+		mask >>= 1
+	}
+
+	return value, nil
+}
+
+// count reads a number of items that follow in the header. Every item
+// takes at least one bit, which bounds the count by the header size.
+func (h *headerReader) count() (int, error) {
+	n, err := h.number()
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64(h.remaining())*8+8 { //nolint:gosec // remaining is never negative
+		return 0, fmt.Errorf("%w: count %d exceeds the header size", ErrFormat, n)
+	}
+
+	return int(n), nil //nolint:gosec // bounded by the header size above
+}
+
+func (h *headerReader) uint32() (uint32, error) {
+	b, err := h.bytes(4)
+	if err != nil {
+		return 0, err
+	}
+
+	return binary.LittleEndian.Uint32(b), nil
+}
+
+func (h *headerReader) uint64() (uint64, error) {
+	b, err := h.bytes(8)
+	if err != nil {
+		return 0, err
+	}
+
+	return binary.LittleEndian.Uint64(b), nil
+}
+
+func (h *headerReader) expect(id byte) error {
+	b, err := h.byte()
+	if err != nil {
+		return err
+	}
+	if b != id {
+		return fmt.Errorf("%w: found header property %#x instead of %#x", ErrFormat, b, id)
+	}
+
+	return nil
+}
+
+// bitVector reads n bits, most significant bit first.
+func (h *headerReader) bitVector(n int) ([]bool, error) {
+	b, err := h.bytes(uint64((n + 7) / 8)) //nolint:gosec // n is a bounded count
+	if err != nil {
+		return nil, err
+	}
+
+	bits := make([]bool, n)
+	for i := range bits {
+		bits[i] = b[i/8]&(0x80>>(i%8)) != 0
+	}
+
+	return bits, nil
+}
+
+// definedVector reads a bit vector preceded by an "all defined" flag.
+func (h *headerReader) definedVector(n int) ([]bool, error) {
+	all, err := h.byte()
+	if err != nil {
+		return nil, err
+	}
+	if all == 0 {
+		return h.bitVector(n)
+	}
+
+	bits := make([]bool, n)
+	for i := range bits {
+		bits[i] = true
+	}
+
+	return bits, nil
+}
+
+// digests reads n optional CRC-32 values.
+func (h *headerReader) digests(n int) ([]uint32, []bool, error) {
+	defined, err := h.definedVector(n)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	crcs := make([]uint32, n)
+	for i := range crcs {
+		if !defined[i] {
+			continue
+		}
+		if crcs[i], err = h.uint32(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return crcs, defined, nil
+}
+
+// noExternal reads the "external" flag of a header property, which points
+// the data into an additional stream; 7-Zip never writes it.
+func (h *headerReader) noExternal() error {
+	external, err := h.byte()
+	if err != nil {
+		return err
+	}
+	if external != 0 {
+		return fmt.Errorf("%w: external header data", ErrAlgorithm)
+	}
+
+	return nil
+}
+
+// readHeader reads the archive header proper, after its property ID.
+func (r *Reader) readHeader(h *headerReader, size int64) error {
+	id, err := h.byte()
+	if err != nil {
+		return err
+	}
+
+	if id == idArchiveProperties {
+		if err := skipArchiveProperties(h); err != nil {
+			return err
+		}
+		if id, err = h.byte(); err != nil {
+			return err
+		}
+	}
+
+	if id == idAdditionalStreamsInfo {
+		if _, err := readStreamsInfo(h, size); err != nil {
+			return err
+		}
+		if id, err = h.byte(); err != nil {
+			return err
+		}
+	}
+
+	if id == idMainStreamsInfo {
+		if r.streams, err = readStreamsInfo(h, size); err != nil {
+			return err
+		}
+		if id, err = h.byte(); err != nil {
+			return err
+		}
+	}
+
+	if id == idFilesInfo {
+		if err := r.readFilesInfo(h); err != nil {
+			return err
+		}
+		if id, err = h.byte(); err != nil {
+			return err
+		}
+	}
+
+	if id != idEnd {
+		return fmt.Errorf("%w: unexpected header property %#x", ErrFormat, id)
+	}
+
+	return nil
+}
+
+func skipArchiveProperties(h *headerReader) error {
+	for {
+		id, err := h.byte()
+		if err != nil {
+			return err
+		}
+		if id == idEnd {
+			return nil
+		}
+		n, err := h.number()
+		if err != nil {
+			return err
+		}
+		if _, err := h.bytes(n); err != nil {
+			return err
+		}
+	}
+}
+
+// readStreamsInfo reads the pack, unpack and substreams information of an
+// archive of size bytes.
+func readStreamsInfo(h *headerReader, size int64) (*streamsInfo, error) {
+	si := &streamsInfo{}
+	readSubstreams := false
+
+	for {
+		id, err := h.byte()
+		if err != nil {
+			return nil, err
+		}
+
+		switch id {
+		case idPackInfo:
+			err = si.readPackInfo(h, size)
+		case idUnpackInfo:
+			err = si.readUnpackInfo(h)
+		case idSubStreamsInfo:
+			readSubstreams = true
+			err = si.readSubStreamsInfo(h)
+		case idEnd:
+			if !readSubstreams {
+				si.defaultSubstreams()
+			}
+			return si, si.check()
+		default:
+			err = fmt.Errorf("%w: unexpected streams property %#x", ErrFormat, id)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (si *streamsInfo) readPackInfo(h *headerReader, size int64) error {
+	packPos, err := h.number()
+	if err != nil {
+		return err
+	}
+	n, err := h.count()
+	if err != nil {
+		return err
+	}
+
+	for {
+		id, err := h.byte()
+		if err != nil {
+			return err
+		}
+
+		switch id {
+		case idSize:
+			si.packSizes = make([]uint64, n)
+			for i := range si.packSizes {
+				if si.packSizes[i], err = h.number(); err != nil {
+					return err
+				}
+			}
+		case idCRC:
+			if _, _, err := h.digests(n); err != nil {
+				return err
+			}
+		case idEnd:
+			return si.layoutPackStreams(packPos, size)
+		default:
+			return fmt.Errorf("%w: unexpected pack property %#x", ErrFormat, id)
+		}
+	}
+}
+
+// layoutPackStreams computes the file offset of every packed stream and
+// rejects streams that extend past the end of the file.
+func (si *streamsInfo) layoutPackStreams(packPos uint64, size int64) error {
+	end := uint64(max(size, 0))
+	offset := signatureHeaderLen + packPos
+	si.packOffsets = make([]int64, len(si.packSizes))
+	for i, packSize := range si.packSizes {
+		if offset > end || packSize > end-offset {
+			return fmt.Errorf("%w: packed data lies beyond the end of the file (truncated archive?)", ErrFormat)
+		}
+		si.packOffsets[i] = int64(offset) //nolint:gosec // bounded by the file size above
+		offset += packSize
+	}
+
+	return nil
+}
+
+func (si *streamsInfo) readUnpackInfo(h *headerReader) error {
+	if err := h.expect(idFolder); err != nil {
+		return err
+	}
+	n, err := h.count()
+	if err != nil {
+		return err
+	}
+	if err := h.noExternal(); err != nil {
+		return err
+	}
+
+	si.folders = make([]*folder, n)
+	firstPack := 0
+	for i := range si.folders {
+		f, err := readFolder(h)
+		if err != nil {
+			return err
+		}
+		f.firstPack = firstPack
+		firstPack += len(f.packed)
+		si.folders[i] = f
+	}
+
+	if err := h.expect(idCodersUnpackSize); err != nil {
+		return err
+	}
+	for _, f := range si.folders {
+		for i := range f.unpackSizes {
+			if f.unpackSizes[i], err = h.number(); err != nil {
+				return err
+			}
+		}
+	}
+
+	for {
+		id, err := h.byte()
+		if err != nil {
+			return err
+		}
+
+		switch id {
+		case idCRC:
+			crcs, defined, err := h.digests(n)
+			if err != nil {
+				return err
+			}
+			for i, f := range si.folders {
+				f.crc, f.hasCRC = crcs[i], defined[i]
+			}
+		case idEnd:
+			return nil
+		default:
+			return fmt.Errorf("%w: unexpected unpack property %#x", ErrFormat, id)
+		}
+	}
+}
+
+func readFolder(h *headerReader) (*folder, error) {
+	numCoders, err := h.count()
+	if err != nil {
+		return nil, err
+	}
+	if numCoders == 0 || numCoders > maxCoders {
+		return nil, fmt.Errorf("%w: folder with %d coders", ErrFormat, numCoders)
+	}
+
+	f := &folder{coders: make([]coder, numCoders)}
+	totalIn, totalOut := 0, 0
+	for i := range f.coders {
+		flags, err := h.byte()
+		if err != nil {
+			return nil, err
+		}
+		if flags&coderFlagAlternative != 0 {
+			return nil, fmt.Errorf("%w: alternative coder methods", ErrAlgorithm)
+		}
+
+		c := coder{numIn: 1, numOut: 1}
+		if c.method, err = h.bytes(uint64(flags & 0x0f)); err != nil {
+			return nil, err
+		}
+		if flags&coderFlagComplex != 0 {
+			if c.numIn, err = h.count(); err != nil {
+				return nil, err
+			}
+			if c.numOut, err = h.count(); err != nil {
+				return nil, err
+			}
+		}
+		if flags&coderFlagProps != 0 {
+			n, err := h.number()
+			if err != nil {
+				return nil, err
+			}
+			if c.props, err = h.bytes(n); err != nil {
+				return nil, err
+			}
+		}
+
+		totalIn += c.numIn
+		totalOut += c.numOut
+		f.coders[i] = c
+	}
+	if totalOut == 0 || totalIn > maxCoders*4 || totalOut > maxCoders*4 {
+		return nil, fmt.Errorf("%w: folder with %d inputs and %d outputs", ErrFormat, totalIn, totalOut)
+	}
+
+	f.bindPairs = make([]bindPair, totalOut-1)
+	for i := range f.bindPairs {
+		in, err := h.number()
+		if err != nil {
+			return nil, err
+		}
+		out, err := h.number()
+		if err != nil {
+			return nil, err
+		}
+		if in >= uint64(totalIn) || out >= uint64(totalOut) { //nolint:gosec // totals are small positive counts
+			return nil, fmt.Errorf("%w: bind pair out of range", ErrFormat)
+		}
+		f.bindPairs[i] = bindPair{in: int(in), out: int(out)} //nolint:gosec // bounded above
+	}
+
+	numPacked := totalIn - len(f.bindPairs)
+	if numPacked < 1 {
+		return nil, fmt.Errorf("%w: folder without packed streams", ErrFormat)
+	}
+	if numPacked == 1 {
+		for in := range totalIn {
+			if _, bound := f.bindPairForIn(in); !bound {
+				f.packed = []int{in}
+				break
+			}
+		}
+		if len(f.packed) == 0 {
+			return nil, fmt.Errorf("%w: folder without packed streams", ErrFormat)
+		}
+	} else {
+		f.packed = make([]int, numPacked)
+		for i := range f.packed {
+			in, err := h.number()
+			if err != nil {
+				return nil, err
+			}
+			if in >= uint64(totalIn) { //nolint:gosec // totalIn is a small positive count
+				return nil, fmt.Errorf("%w: packed stream out of range", ErrFormat)
+			}
+			f.packed[i] = int(in) //nolint:gosec // bounded above
+		}
+	}
+
+	f.unpackSizes = make([]uint64, totalOut)
+
+	return f, nil
+}
+
+func (si *streamsInfo) readSubStreamsInfo(h *headerReader) error {
+	for _, f := range si.folders {
+		f.numSubstreams = 1
+	}
+
+	id, err := h.byte()
+	if err != nil {
+		return err
+	}
+
+	if id == idNumUnpackStream {
+		for _, f := range si.folders {
+			if f.numSubstreams, err = h.count(); err != nil {
+				return err
+			}
+		}
+		if id, err = h.byte(); err != nil {
+			return err
+		}
+	}
+
+	si.substreams = nil
+	for fi, f := range si.folders {
+		if f.numSubstreams == 0 {
+			continue
+		}
+
+		var offset uint64
+		for range f.numSubstreams - 1 {
+			if id != idSize {
+				return fmt.Errorf("%w: substream sizes missing", ErrFormat)
+			}
+			size, err := h.number()
+			if err != nil {
+				return err
+			}
+			si.substreams = append(si.substreams, substream{folder: fi, offset: offset, size: size})
+			offset += size
+		}
+
+		total := f.unpackSize()
+		if offset > total {
+			return fmt.Errorf("%w: substreams exceed their folder", ErrFormat)
+		}
+		last := substream{folder: fi, offset: offset, size: total - offset}
+		if f.numSubstreams == 1 {
+			last.crc, last.hasCRC = f.crc, f.hasCRC
+		}
+		si.substreams = append(si.substreams, last)
+	}
+	if id == idSize {
+		if id, err = h.byte(); err != nil {
+			return err
+		}
+	}
+
+	for {
+		switch id {
+		case idCRC:
+			if err := si.readSubstreamDigests(h); err != nil {
+				return err
+			}
+		case idEnd:
+			return nil
+		default:
+			return fmt.Errorf("%w: unexpected substreams property %#x", ErrFormat, id)
+		}
+		if id, err = h.byte(); err != nil {
+			return err
+		}
+	}
+}
+
+// readSubstreamDigests reads the CRC-32 of every substream whose folder
+// does not already provide it.
+func (si *streamsInfo) readSubstreamDigests(h *headerReader) error {
+	var missing []int
+	i := 0
+	for _, f := range si.folders {
+		for range f.numSubstreams {
+			if f.numSubstreams != 1 || !f.hasCRC {
+				missing = append(missing, i)
+			}
+			i++
+		}
+	}
+
+	crcs, defined, err := h.digests(len(missing))
+	if err != nil {
+		return err
+	}
+	for j, i := range missing {
+		si.substreams[i].crc, si.substreams[i].hasCRC = crcs[j], defined[j]
+	}
+
+	return nil
+}
+
+// defaultSubstreams stores one file per folder, for archives without
+// substreams information.
+func (si *streamsInfo) defaultSubstreams() {
+	si.substreams = make([]substream, len(si.folders))
+	for i, f := range si.folders {
+		f.numSubstreams = 1
+		si.substreams[i] = substream{folder: i, size: f.unpackSize(), crc: f.crc, hasCRC: f.hasCRC}
+	}
+}
+
+// check rejects folders that refer to packed streams the archive lacks.
+func (si *streamsInfo) check() error {
+	for _, f := range si.folders {
+		if f.firstPack+len(f.packed) > len(si.packOffsets) {
+			return fmt.Errorf("%w: folder refers to missing packed streams", ErrFormat)
+		}
+	}
+
+	return nil
+}
+
+func (r *Reader) readFilesInfo(h *headerReader) error {
+	n, err := h.count()
+	if err != nil {
+		return err
+	}
+
+	files := make([]*File, n)
+	for i := range files {
+		files[i] = &File{reader: r, folder: -1}
+	}
+
+	var emptyStream, emptyFile []bool
+	numEmpty := 0
+	for {
+		propType, err := h.number()
+		if err != nil {
+			return err
+		}
+		if propType == idEnd {
+			break
+		}
+
+		size, err := h.number()
+		if err != nil {
+			return err
+		}
+		data, err := h.bytes(size)
+		if err != nil {
+			return err
+		}
+		prop := &headerReader{buf: data}
+
+		switch propType {
+		case idEmptyStream:
+			if emptyStream, err = prop.bitVector(n); err != nil {
+				return err
+			}
+			numEmpty = 0
+			for _, empty := range emptyStream {
+				if empty {
+					numEmpty++
+				}
+			}
+		case idEmptyFile:
+			if emptyFile, err = prop.bitVector(numEmpty); err != nil {
+				return err
+			}
+		case idName:
+			err = readNames(prop, files)
+		case idMTime:
+			err = readTimes(prop, files)
+		case idWinAttributes:
+			err = readAttributes(prop, files)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return r.assignStreams(files, emptyStream, emptyFile)
+}
+
+// assignStreams hands the substreams out to the files that have data, in
+// order, and marks the others as directories or empty files.
+func (r *Reader) assignStreams(files []*File, emptyStream, emptyFile []bool) error {
+	next, emptyIndex := 0, 0
+	for i, f := range files {
+		if emptyStream != nil && emptyStream[i] {
+			isEmptyFile := emptyIndex < len(emptyFile) && emptyFile[emptyIndex]
+			f.IsDir = !isEmptyFile
+			emptyIndex++
+			continue
+		}
+
+		if next >= len(r.streams.substreams) {
+			return fmt.Errorf("%w: more files than data streams", ErrFormat)
+		}
+		s := r.streams.substreams[next]
+		next++
+
+		f.folder, f.offset, f.Size = s.folder, s.offset, s.size
+		f.CRC32, f.HasCRC = s.crc, s.hasCRC
+	}
+	if next != len(r.streams.substreams) {
+		return fmt.Errorf("%w: data streams without files", ErrFormat)
+	}
+
+	r.File = files
+
+	return nil
+}
+
+// readNames reads the NUL-terminated UTF-16LE member names.
+func readNames(h *headerReader, files []*File) error {
+	if err := h.noExternal(); err != nil {
+		return err
+	}
+
+	data := h.buf[h.pos:]
+	if len(data)%2 != 0 {
+		return fmt.Errorf("%w: odd length of member names", ErrFormat)
+	}
+
+	var units []uint16
+	i := 0
+	for j := 0; j < len(data); j += 2 {
+		unit := binary.LittleEndian.Uint16(data[j:])
+		if unit != 0 {
+			units = append(units, unit)
+			continue
+		}
+		if i == len(files) {
+			return fmt.Errorf("%w: more names than members", ErrFormat)
+		}
+		files[i].Name = string(utf16.Decode(units))
+		units = units[:0]
+		i++
+	}
+	if i != len(files) {
+		return fmt.Errorf("%w: fewer names than members", ErrFormat)
+	}
+
+	return nil
+}
+
+// readTimes reads modification times as Windows FILETIME values.
+func readTimes(h *headerReader, files []*File) error {
+	defined, err := h.definedVector(len(files))
+	if err != nil {
+		return err
+	}
+	if err := h.noExternal(); err != nil {
+		return err
+	}
+
+	for i, f := range files {
+		if !defined[i] {
+			continue
+		}
+		ft, err := h.uint64()
+		if err != nil {
+			return err
+		}
+		f.Modified = fileTime(ft)
+	}
+
+	return nil
+}
+
+func readAttributes(h *headerReader, files []*File) error {
+	defined, err := h.definedVector(len(files))
+	if err != nil {
+		return err
+	}
+	if err := h.noExternal(); err != nil {
+		return err
+	}
+
+	for i, f := range files {
+		if !defined[i] {
+			continue
+		}
+		if f.Attributes, err = h.uint32(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// fileTime converts a FILETIME, 100 ns intervals since 1601-01-01 UTC.
+func fileTime(ft uint64) time.Time {
+	ticks := int64(ft - fileTimeEpochDelta) //nolint:gosec // two's complement gives times before 1970
+	return time.Unix(ticks/1e7, ticks%1e7*100)
+}
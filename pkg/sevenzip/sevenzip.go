@@ -0,0 +1,442 @@
+// Package sevenzip implements a pure-Go reader for 7z archives as written
+// by 7-Zip, p7zip and libarchive.
+//
+// A 7z archive stores file data in folders: each folder is one stream of
+// packed data run through a chain of coders (a compression method,
+// optionally preceded by AES-256 encryption), and a solid folder holds the
+// bodies of many files back to back. The header describing folders and
+// files sits at the end of the archive and is usually itself compressed,
+// and encrypted when the archive was created with header encryption.
+//
+// Supported coders are Copy, LZMA, LZMA2, Deflate, Deflate64, BZip2 and
+// 7zAES. Folders using any other coder (PPMd, the BCJ/BCJ2 executable
+// filters, Zstandard, ...) are reported by [Reader.CheckMethods].
+//
+// Reference: 7zFormat.txt in the 7-Zip source distribution.
+package sevenzip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+)
+
+const (
+	// signatureHeaderLen is the size of the fixed header at the start of
+	// every archive: signature, version, and the location of the header.
+	signatureHeaderLen = 32
+
+	// maxHeaderSize caps the decoded size of a compressed header, which is
+	// read into memory in full.
+	maxHeaderSize = 1 << 30
+
+	// maxHeaderNesting is how many times a header may be wrapped in an
+	// encoded header before the archive is rejected.
+	maxHeaderNesting = 4
+)
+
+// signature starts every 7z archive.
+var signature = []byte{'7', 'z', 0xbc, 0xaf, 0x27, 0x1c}
+
+var (
+	// ErrFormat is returned for files that are not 7z archives or whose
+	// header is malformed.
+	ErrFormat = errors.New("sevenzip: not a valid 7z archive")
+
+	// ErrAlgorithm is returned for folders that use a coder this package
+	// does not implement.
+	ErrAlgorithm = errors.New("sevenzip: unsupported method")
+
+	// ErrChecksum is returned when decoded data does not match its CRC-32.
+	ErrChecksum = errors.New("sevenzip: checksum error")
+
+	// ErrPasswordRequired is returned when encrypted data is read without
+	// a password.
+	ErrPasswordRequired = errors.New("sevenzip: password required")
+
+	// ErrPassword is returned when an encrypted header does not decode
+	// with the password supplied: the password is wrong or the data is
+	// damaged.
+	ErrPassword = errors.New("sevenzip: wrong password or damaged data")
+)
+
+// Windows file attributes recorded in the header.
+const (
+	attrReadOnly  = 0x1
+	attrDirectory = 0x10
+
+	// attrUnixExtension marks attributes whose high 16 bits hold a Unix
+	// st_mode, as written by p7zip and libarchive.
+	attrUnixExtension = 0x8000
+)
+
+// Unix file type bits of st_mode.
+const (
+	unixTypeMask    = 0o170000
+	unixTypeDir     = 0o040000
+	unixTypeRegular = 0o100000
+	unixTypeSymlink = 0o120000
+)
+
+// Reader reads the files of a 7z archive. Opening a file in a solid folder
+// decodes the folder from its start, so files are cheapest to read in
+// archive order. A Reader is not safe for concurrent use.
+type Reader struct {
+	// File lists the archive members in archive order.
+	File []*File
+
+	r        io.ReaderAt
+	streams  *streamsInfo
+	password string
+
+	// keys caches derived AES keys, which take a deliberately slow
+	// computation per salt.
+	keys map[string][]byte
+
+	// cursor is the folder currently being decoded, positioned after the
+	// last file read from it.
+	cursor *folderCursor
+
+	// opened counts calls to [File.Open]; only the latest reader is valid.
+	opened int
+}
+
+// File is one member of a 7z archive.
+type File struct {
+	// Name is the member path, with "/" as separator.
+	Name string
+
+	// Modified is the modification time, or the zero time when the
+	// archive does not record one.
+	Modified time.Time
+
+	// Attributes holds the Windows attributes, with a Unix st_mode in the
+	// high 16 bits when attrUnixExtension is set.
+	Attributes uint32
+
+	// Size is the uncompressed size.
+	Size uint64
+
+	// CRC32 is the checksum of the uncompressed data; HasCRC reports
+	// whether the archive records one.
+	CRC32  uint32
+	HasCRC bool
+
+	// IsDir reports whether the member is a directory.
+	IsDir bool
+
+	reader *Reader
+
+	// folder is the index of the folder holding the data, or -1 for
+	// members without data; offset is where the data starts in the
+	// decoded folder.
+	folder int
+	offset uint64
+}
+
+// NewReader returns a Reader for the 7z archive of size bytes read from r.
+// password decrypts encrypted folders and an encrypted header; it may be
+// empty for archives without encryption. An encrypted header needs the
+// password to be read at all: without one NewReader returns an error
+// wrapping [ErrPasswordRequired], and with a wrong one an error wrapping
+// [ErrPassword].
+func NewReader(r io.ReaderAt, size int64, password string) (*Reader, error) {
+	var start [signatureHeaderLen]byte
+	if _, err := r.ReadAt(start[:], 0); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrFormat, err)
+	}
+	if !bytes.Equal(start[:len(signature)], signature) {
+		return nil, ErrFormat
+	}
+	if crc32.ChecksumIEEE(start[12:]) != binary.LittleEndian.Uint32(start[8:]) {
+		return nil, fmt.Errorf("%w: start header checksum mismatch", ErrFormat)
+	}
+
+	zr := &Reader{r: r, password: password, streams: &streamsInfo{}}
+
+	nextOffset := binary.LittleEndian.Uint64(start[12:])
+	nextSize := binary.LittleEndian.Uint64(start[20:])
+	nextCRC := binary.LittleEndian.Uint32(start[28:])
+	if nextSize == 0 {
+		// An archive without members.
+		return zr, nil
+	}
+
+	available := uint64(max(size-signatureHeaderLen, 0))
+	if nextOffset > available || nextSize > available-nextOffset {
+		return nil, fmt.Errorf("%w: header lies beyond the end of the file (truncated archive?)", ErrFormat)
+	}
+
+	buf := make([]byte, nextSize)
+	if _, err := r.ReadAt(buf, signatureHeaderLen+int64(nextOffset)); err != nil { //nolint:gosec // bounded by size above
+		return nil, fmt.Errorf("%w: %w", ErrFormat, err)
+	}
+	if crc32.ChecksumIEEE(buf) != nextCRC {
+		return nil, fmt.Errorf("%w: header checksum mismatch", ErrFormat)
+	}
+
+	for range maxHeaderNesting {
+		h := &headerReader{buf: buf}
+		id, err := h.byte()
+		if err != nil {
+			return nil, err
+		}
+
+		switch id {
+		case idHeader:
+			if err := zr.readHeader(h, size); err != nil {
+				return nil, err
+			}
+			return zr, nil
+		case idEncodedHeader:
+			si, err := readStreamsInfo(h, size)
+			if err != nil {
+				return nil, err
+			}
+			if buf, err = zr.decodeHeader(si); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("%w: unexpected header property %#x", ErrFormat, id)
+		}
+	}
+
+	return nil, fmt.Errorf("%w: header nested too deeply", ErrFormat)
+}
+
+// decodeHeader decodes the header stored in the first folder of si.
+func (r *Reader) decodeHeader(si *streamsInfo) ([]byte, error) {
+	if len(si.folders) == 0 {
+		return nil, fmt.Errorf("%w: encoded header has no data", ErrFormat)
+	}
+
+	f := si.folders[0]
+	size := f.unpackSize()
+	if size > maxHeaderSize {
+		return nil, fmt.Errorf("%w: header of %d bytes is too large", ErrFormat, size)
+	}
+	if err := checkFolder(f); err != nil {
+		return nil, fmt.Errorf("header: %w", err)
+	}
+
+	buf, err := r.readFolder(si, 0, size)
+	if err != nil && f.encrypted() && !errors.Is(err, ErrPasswordRequired) {
+		return nil, fmt.Errorf("%w: %w", ErrPassword, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+// readFolder decodes all size bytes of folder fi of si and checks them
+// against the folder's CRC-32.
+func (r *Reader) readFolder(si *streamsInfo, fi int, size uint64) ([]byte, error) {
+	fr, err := r.folderReader(si, fi)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(fr, buf); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrFormat, err)
+	}
+
+	f := si.folders[fi]
+	if f.hasCRC && crc32.ChecksumIEEE(buf) != f.crc {
+		return nil, ErrChecksum
+	}
+
+	return buf, nil
+}
+
+// CheckMethods returns an error wrapping [ErrAlgorithm] that names the
+// first member stored with a coder this package does not implement.
+func (r *Reader) CheckMethods() error {
+	for _, f := range r.File {
+		if f.folder < 0 {
+			continue
+		}
+		if err := checkFolder(r.streams.folders[f.folder]); err != nil {
+			return fmt.Errorf("entry %q: %w", f.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// Mode returns the permission and type bits of f. Archives written on
+// Windows record no permissions, so their members get 0644 (0444 when
+// read-only) and directories 0755.
+func (f *File) Mode() fs.FileMode {
+	if f.Attributes&attrUnixExtension != 0 {
+		unix := f.Attributes >> 16
+		mode := fs.FileMode(unix & 0o777)
+		switch unix & unixTypeMask {
+		case unixTypeDir:
+			mode |= fs.ModeDir
+		case unixTypeSymlink:
+			mode |= fs.ModeSymlink
+		case unixTypeRegular, 0:
+		default:
+			mode |= fs.ModeIrregular
+		}
+		if f.IsDir {
+			mode |= fs.ModeDir
+		}
+		return mode
+	}
+
+	switch {
+	case f.IsDir:
+		return fs.ModeDir | 0o755
+	case f.Attributes&attrReadOnly != 0:
+		return 0o444
+	default:
+		return 0o644
+	}
+}
+
+// Encrypted reports whether the data of f is encrypted.
+func (f *File) Encrypted() bool {
+	return f.folder >= 0 && f.reader.streams.folders[f.folder].encrypted()
+}
+
+// Method names the coders f is stored with, outermost first and joined by
+// "+", such as "LZMA2+7zAES". It is empty for members without data.
+func (f *File) Method() string {
+	if f.folder < 0 {
+		return ""
+	}
+
+	fo := f.reader.streams.folders[f.folder]
+	var names []string
+	out := fo.mainOut()
+	for range fo.coders {
+		ci, in, ok := fo.coderForOut(out)
+		if !ok {
+			break
+		}
+		names = append(names, methodName(fo.coders[ci].method))
+
+		bp, bound := fo.bindPairForIn(in)
+		if !bound {
+			break
+		}
+		out = bp.out
+	}
+
+	return strings.Join(names, "+")
+}
+
+// Open returns the uncompressed body of f. The reader fails at the end of
+// the data with [ErrChecksum] when the body does not match the recorded
+// CRC-32. Opening a file closes the reader of the previous one.
+func (f *File) Open() (io.ReadCloser, error) {
+	if f.folder < 0 {
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	}
+
+	r := f.reader
+	c := r.cursor
+	if c == nil || c.folder != f.folder || c.pos > f.offset {
+		fr, err := r.folderReader(r.streams, f.folder)
+		if err != nil {
+			return nil, err
+		}
+		c = &folderCursor{folder: f.folder, r: fr}
+		r.cursor = c
+	}
+
+	if skip := f.offset - c.pos; skip > 0 {
+		if _, err := io.CopyN(io.Discard, c, int64(skip)); err != nil { //nolint:gosec // offsets are bounded by the folder size
+			r.cursor = nil
+			return nil, fmt.Errorf("%w: %w", ErrFormat, err)
+		}
+	}
+
+	r.opened++
+
+	return &fileReader{
+		file:   f,
+		cursor: c,
+		opened: r.opened,
+		left:   f.Size,
+		hash:   crc32.NewIEEE(),
+	}, nil
+}
+
+// folderCursor is a decoded folder stream and the number of bytes read
+// from it so far.
+type folderCursor struct {
+	folder int
+	r      io.Reader
+	pos    uint64
+}
+
+func (c *folderCursor) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.pos += uint64(n) //nolint:gosec // n is never negative
+
+	return n, err
+}
+
+// fileReader reads the body of one file from its folder cursor.
+type fileReader struct {
+	file   *File
+	cursor *folderCursor
+	opened int
+	left   uint64
+	hash   hash.Hash32
+	err    error
+}
+
+func (fr *fileReader) Read(p []byte) (int, error) {
+	if fr.err != nil {
+		return 0, fr.err
+	}
+	if fr.file.reader.opened != fr.opened {
+		fr.err = errors.New("sevenzip: file reader used after opening another file")
+		return 0, fr.err
+	}
+
+	if fr.left == 0 {
+		fr.err = io.EOF
+		if fr.file.HasCRC && fr.hash.Sum32() != fr.file.CRC32 {
+			fr.err = ErrChecksum
+		}
+		return 0, fr.err
+	}
+
+	if uint64(len(p)) > fr.left {
+		p = p[:fr.left]
+	}
+	n, err := fr.cursor.Read(p)
+	fr.hash.Write(p[:n])
+	fr.left -= uint64(n) //nolint:gosec // n is never negative
+
+	switch {
+	case errors.Is(err, io.EOF) && fr.left > 0:
+		fr.err = io.ErrUnexpectedEOF
+		return n, fr.err
+	case err != nil && !errors.Is(err, io.EOF):
+		fr.err = err
+		return n, err
+	}
+
+	return n, nil
+}
+
+// Close implements [io.Closer]. The folder stays open so the next file in
+// archive order can be read without decoding the folder again.
+func (fr *fileReader) Close() error {
+	return nil
+}
@@ -0,0 +1,240 @@
+package sevenzip
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"btidy/internal/testutil"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func fixtureEntries() []testutil.SevenZipEntry {
+	return []testutil.SevenZipEntry{
+		{Name: "docs", Dir: true},
+		{Name: "docs/a.txt", Body: []byte(strings.Repeat("alpha ", 500))},
+		{Name: "docs/b.txt", Body: []byte("bravo")},
+		{Name: "empty.txt"},
+		{Name: "run.sh", Body: []byte("#!/bin/sh\n"), Mode: 0o755},
+		{Name: "latest", Body: []byte("docs/a.txt"), Mode: fs.ModeSymlink | 0o777},
+	}
+}
+
+func openFixture(t *testing.T, path, password string) (*Reader, error) {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return NewReader(bytes.NewReader(data), int64(len(data)), password)
+}
+
+func readAll(t *testing.T, f *File) []byte {
+	t.Helper()
+
+	rc, err := f.Open()
+	require.NoError(t, err)
+	body, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	return body
+}
+
+func TestHeaderReaderNumber(t *testing.T) {
+	tests := []struct {
+		encoded []byte
+		want    uint64
+	}{
+		{encoded: []byte{0x00}, want: 0},
+		{encoded: []byte{0x7f}, want: 0x7f},
+		{encoded: []byte{0x80, 0x80}, want: 0x80},
+		{encoded: []byte{0xbf, 0xff}, want: 0x3fff},
+		{encoded: []byte{0xc1, 0x02, 0x03}, want: 0x010302},
+		{encoded: []byte{0xff, 1, 2, 3, 4, 5, 6, 7, 8}, want: 0x0807060504030201},
+	}
+
+	for _, tt := range tests {
+		h := &headerReader{buf: tt.encoded}
+		got, err := h.number()
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, got, "% x", tt.encoded)
+		assert.Zero(t, h.remaining())
+	}
+
+	_, err := (&headerReader{buf: []byte{0xc1, 0x02}}).number()
+	assert.ErrorIs(t, err, ErrFormat)
+}
+
+func TestReadArchive(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   testutil.SevenZipOptions
+		method string
+	}{
+		{name: "copy", opts: testutil.SevenZipOptions{Method: testutil.SevenZipCopy}, method: "Copy"},
+		{name: "lzma", opts: testutil.SevenZipOptions{Method: testutil.SevenZipLZMA}, method: "LZMA"},
+		{name: "lzma2", opts: testutil.SevenZipOptions{Method: testutil.SevenZipLZMA2}, method: "LZMA2"},
+		{name: "solid lzma2 with compressed header", opts: testutil.SevenZipOptions{Solid: true, CompressHeader: true}, method: "LZMA2"},
+		{name: "solid lzma", opts: testutil.SevenZipOptions{Method: testutil.SevenZipLZMA, Solid: true}, method: "LZMA"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "fixture.7z")
+			testutil.WriteSevenZip(t, path, fixtureEntries(), tt.opts)
+
+			r, err := openFixture(t, path, "")
+			require.NoError(t, err)
+			require.NoError(t, r.CheckMethods())
+			require.Len(t, r.File, len(fixtureEntries()))
+
+			for i, want := range fixtureEntries() {
+				f := r.File[i]
+				assert.Equal(t, want.Name, f.Name)
+				assert.Equal(t, want.Dir, f.IsDir, want.Name)
+				assert.Equal(t, time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), f.Modified.UTC(), want.Name)
+				assert.False(t, f.Encrypted())
+				if want.Dir {
+					assert.Equal(t, fs.ModeDir|0o755, f.Mode())
+					continue
+				}
+
+				assert.Equal(t, uint64(len(want.Body)), f.Size, want.Name)
+				if len(want.Body) > 0 {
+					assert.Equal(t, tt.method, f.Method(), want.Name)
+				}
+				assert.Equal(t, string(want.Body), string(readAll(t, f)), want.Name)
+			}
+
+			assert.Equal(t, fs.FileMode(0o644), r.File[1].Mode())
+			assert.Equal(t, fs.FileMode(0o755), r.File[4].Mode())
+			assert.Equal(t, fs.ModeSymlink|0o777, r.File[5].Mode())
+		})
+	}
+}
+
+func TestReadArchiveOutOfOrder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "solid.7z")
+	testutil.WriteSevenZip(t, path, fixtureEntries(), testutil.SevenZipOptions{Solid: true})
+
+	r, err := openFixture(t, path, "")
+	require.NoError(t, err)
+
+	// Going back in a solid folder decodes it again from its start.
+	assert.Equal(t, "#!/bin/sh\n", string(readAll(t, r.File[4])))
+	assert.Equal(t, "bravo", string(readAll(t, r.File[2])))
+
+	// A reader abandoned half-way is skipped past by the next file.
+	rc, err := r.File[1].Open()
+	require.NoError(t, err)
+	_, err = io.ReadFull(rc, make([]byte, 10))
+	require.NoError(t, err)
+	assert.Equal(t, "bravo", string(readAll(t, r.File[2])))
+
+	_, err = rc.Read(make([]byte, 10))
+	assert.Error(t, err, "a reader is invalid once another file is opened")
+}
+
+func TestReadEncryptedArchive(t *testing.T) {
+	entries := fixtureEntries()
+
+	t.Run("encrypted data", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "locked.7z")
+		testutil.WriteSevenZip(t, path, entries, testutil.SevenZipOptions{Password: "s3cret", Solid: true, CompressHeader: true})
+
+		r, err := openFixture(t, path, "")
+		require.NoError(t, err)
+		require.Len(t, r.File, len(entries))
+		assert.True(t, r.File[1].Encrypted())
+		assert.False(t, r.File[0].Encrypted())
+		assert.Equal(t, "LZMA2+7zAES", r.File[1].Method())
+		_, err = r.File[1].Open()
+		require.ErrorIs(t, err, ErrPasswordRequired)
+
+		r, err = openFixture(t, path, "wrong")
+		require.NoError(t, err)
+		rc, err := r.File[1].Open()
+		if err == nil {
+			_, err = io.ReadAll(rc)
+		}
+		assert.Error(t, err)
+
+		r, err = openFixture(t, path, "s3cret")
+		require.NoError(t, err)
+		assert.Equal(t, string(entries[1].Body), string(readAll(t, r.File[1])))
+		assert.Equal(t, string(entries[4].Body), string(readAll(t, r.File[4])))
+	})
+
+	t.Run("encrypted header", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "hidden.7z")
+		testutil.WriteSevenZip(t, path, entries, testutil.SevenZipOptions{Password: "s3cret", EncryptHeader: true})
+
+		_, err := openFixture(t, path, "")
+		require.ErrorIs(t, err, ErrPasswordRequired)
+
+		_, err = openFixture(t, path, "wrong")
+		require.ErrorIs(t, err, ErrPassword)
+
+		r, err := openFixture(t, path, "s3cret")
+		require.NoError(t, err)
+		require.Len(t, r.File, len(entries))
+		assert.Equal(t, string(entries[2].Body), string(readAll(t, r.File[2])))
+	})
+}
+
+func TestCheckMethods(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ppmd.7z")
+	testutil.WriteSevenZip(t, path, fixtureEntries(), testutil.SevenZipOptions{
+		Method:    testutil.SevenZipCopy,
+		RawMethod: []byte{0x03, 0x04, 0x01},
+	})
+
+	r, err := openFixture(t, path, "")
+	require.NoError(t, err)
+
+	err = r.CheckMethods()
+	require.ErrorIs(t, err, ErrAlgorithm)
+	assert.Contains(t, err.Error(), `entry "docs/a.txt"`)
+	assert.Contains(t, err.Error(), "PPMd")
+
+	_, err = r.File[1].Open()
+	assert.ErrorIs(t, err, ErrAlgorithm)
+}
+
+func TestReadDamagedArchive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fixture.7z")
+	testutil.WriteSevenZip(t, path, fixtureEntries(), testutil.SevenZipOptions{Method: testutil.SevenZipCopy})
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	t.Run("corrupt data", func(t *testing.T) {
+		damaged := bytes.Clone(data)
+		i := bytes.Index(damaged, []byte("bravo"))
+		require.Positive(t, i)
+		damaged[i] ^= 0xff
+
+		r, err := NewReader(bytes.NewReader(damaged), int64(len(damaged)), "")
+		require.NoError(t, err)
+		rc, err := r.File[2].Open()
+		require.NoError(t, err)
+		_, err = io.ReadAll(rc)
+		assert.ErrorIs(t, err, ErrChecksum)
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		_, err := NewReader(bytes.NewReader(data[:len(data)/2]), int64(len(data)/2), "")
+		assert.ErrorIs(t, err, ErrFormat)
+	})
+
+	t.Run("not a 7z archive", func(t *testing.T) {
+		plain := []byte(strings.Repeat("not an archive ", 10))
+		_, err := NewReader(bytes.NewReader(plain), int64(len(plain)), "")
+		assert.ErrorIs(t, err, ErrFormat)
+	})
+}
@@ -0,0 +1,201 @@
+package similarity
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+// Algorithm selects the perceptual hash images are compared by.
+type Algorithm string
+
+const (
+	// AlgorithmDHash compares the brightness of neighbouring cells of a 9x8
+	// thumbnail. It is fast and robust to re-encoding and resizing.
+	AlgorithmDHash Algorithm = "dhash"
+
+	// AlgorithmPHash compares the low frequencies of a 32x32 thumbnail's
+	// discrete cosine transform with their median. It is slower than dHash
+	// but also robust to small brightness and contrast changes. It is the
+	// default.
+	AlgorithmPHash Algorithm = "phash"
+)
+
+// ParseAlgorithm converts a command-line value into an [Algorithm]. An
+// empty value selects [AlgorithmPHash].
+func ParseAlgorithm(value string) (Algorithm, error) {
+	switch algo := Algorithm(value); algo {
+	case "":
+		return AlgorithmPHash, nil
+	case AlgorithmDHash, AlgorithmPHash:
+		return algo, nil
+	default:
+		return "", fmt.Errorf("invalid hash algorithm %q (want %s or %s)", value, AlgorithmDHash, AlgorithmPHash)
+	}
+}
+
+// Hash is a 64-bit perceptual hash. Images that look alike have hashes
+// that differ in few bits.
+type Hash uint64
+
+// Distance returns the number of bits in which h and other differ, their
+// Hamming distance.
+func (h Hash) Distance(other Hash) int {
+	return bits.OnesCount64(uint64(h ^ other))
+}
+
+// String returns h as 16 hexadecimal digits.
+func (h Hash) String() string {
+	return fmt.Sprintf("%016x", uint64(h))
+}
+
+// HashImage computes the perceptual hash of img with algo.
+func HashImage(img image.Image, algo Algorithm) Hash {
+	if algo == AlgorithmDHash {
+		return dHash(img)
+	}
+	return pHash(img)
+}
+
+// dHash sets one bit per pair of horizontally adjacent cells of a 9x8
+// grayscale thumbnail, when the left cell is darker.
+func dHash(img image.Image) Hash {
+	const width, height = 9, 8
+	cells := thumbnail(img, width, height)
+
+	var h Hash
+	for y := range height {
+		for x := range width - 1 {
+			h <<= 1
+			if cells[y*width+x] < cells[y*width+x+1] {
+				h |= 1
+			}
+		}
+	}
+
+	return h
+}
+
+// pHash sets one bit per coefficient of the 8x8 lowest frequencies of the
+// DCT of a 32x32 grayscale thumbnail, when it is above their median. The
+// DC coefficient, the mean brightness, is left out of the median.
+func pHash(img image.Image) Hash {
+	const size, lowFreq = 32, 8
+	coeffs := dct2D(thumbnail(img, size, size), size)
+
+	low := make([]float64, 0, lowFreq*lowFreq)
+	for y := range lowFreq {
+		for x := range lowFreq {
+			low = append(low, coeffs[y*size+x])
+		}
+	}
+
+	sorted := append([]float64(nil), low[1:]...)
+	sort.Float64s(sorted)
+	median := (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+
+	var h Hash
+	for _, c := range low {
+		h <<= 1
+		if c > median {
+			h |= 1
+		}
+	}
+
+	return h
+}
+
+// thumbnail scales img down, or up, to width x height grayscale cells.
+// Each cell is the mean luminance of the source pixels it covers, so the
+// result hardly depends on the source resolution.
+func thumbnail(img image.Image, width, height int) []float64 {
+	bounds := img.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	cells := make([]float64, width*height)
+	if srcW == 0 || srcH == 0 {
+		return cells
+	}
+
+	luma := luminance(img)
+	for cy := range height {
+		y0, y1 := span(cy, height, srcH)
+		for cx := range width {
+			x0, x1 := span(cx, width, srcW)
+
+			var sum float64
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					sum += luma(bounds.Min.X+x, bounds.Min.Y+y)
+				}
+			}
+			cells[cy*width+cx] = sum / float64((x1-x0)*(y1-y0))
+		}
+	}
+
+	return cells
+}
+
+// span returns the source range [start, end) that cell i of n covers in a
+// source of size pixels. The range holds at least one pixel.
+func span(i, n, size int) (start, end int) {
+	start = i * size / n
+	end = (i + 1) * size / n
+	if end <= start {
+		end = start + 1
+	}
+	return start, end
+}
+
+// luminance returns a function reading the luminance of a pixel of img,
+// reading the planes of the common decoded types directly.
+func luminance(img image.Image) func(x, y int) float64 {
+	switch src := img.(type) {
+	case *image.YCbCr:
+		return func(x, y int) float64 {
+			return float64(src.Y[src.YOffset(x, y)])
+		}
+	case *image.Gray:
+		return func(x, y int) float64 {
+			return float64(src.Pix[src.PixOffset(x, y)])
+		}
+	default:
+		return func(x, y int) float64 {
+			gray, _ := color.GrayModel.Convert(img.At(x, y)).(color.Gray)
+			return float64(gray.Y)
+		}
+	}
+}
+
+// dct2D returns the two-dimensional DCT-II of the size x size values.
+func dct2D(values []float64, size int) []float64 {
+	cosines := make([]float64, size*size)
+	for k := range size {
+		for n := range size {
+			cosines[k*size+n] = math.Cos(math.Pi / float64(size) * (float64(n) + 0.5) * float64(k))
+		}
+	}
+
+	transform := func(in []float64, offset, stride int, out []float64) {
+		for k := range size {
+			var sum float64
+			for n := range size {
+				sum += in[offset+n*stride] * cosines[k*size+n]
+			}
+			out[offset+k*stride] = sum
+		}
+	}
+
+	rows := make([]float64, size*size)
+	for y := range size {
+		transform(values, y*size, 1, rows)
+	}
+	coeffs := make([]float64, size*size)
+	for x := range size {
+		transform(rows, x, size, coeffs)
+	}
+
+	return coeffs
+}
@@ -0,0 +1,501 @@
+// Package similarity finds images that show the same picture although their
+// bytes differ, such as copies re-encoded at another JPEG quality or saved
+// at another resolution, by comparing perceptual hashes.
+//
+// Each JPEG, PNG and GIF is decoded and reduced to a 64-bit [Hash]. Images
+// are grouped around the one with the highest resolution: every image
+// within the maximum Hamming distance of it joins its group. Because all
+// members are compared with the image that is kept, and not only with each
+// other, a chain of slightly different images never ends up in one group.
+package similarity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"  // Register the GIF decoder.
+	_ "image/jpeg" // Register the JPEG decoder.
+	_ "image/png"  // Register the PNG decoder.
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"btidy/pkg/collector"
+	"btidy/pkg/hasher"
+	"btidy/pkg/progress"
+	"btidy/pkg/safepath"
+	"btidy/pkg/trash"
+)
+
+// DefaultMaxDistance is the default maximum Hamming distance between the
+// hashes of two images considered the same picture.
+const DefaultMaxDistance = 8
+
+// maxPixels bounds the images decoded, so a crafted header cannot make
+// the decoder allocate gigabytes.
+const maxPixels = 1 << 27
+
+// ErrImageChanged indicates that an image was modified between hashing and
+// trashing.
+var ErrImageChanged = errors.New("image changed since it was hashed")
+
+const (
+	progressStageHashing   = "hashing images"
+	progressStageComparing = "comparing"
+	progressStageTrashing  = "trashing"
+)
+
+// Image is a decoded image with its perceptual hash.
+type Image struct {
+	Path    string
+	Size    int64
+	ModTime time.Time
+	Width   int
+	Height  int
+	Hash    Hash
+}
+
+// Pixels returns the resolution of the image in pixels.
+func (i Image) Pixels() int {
+	return i.Width * i.Height
+}
+
+// Match is an image similar to the kept image of its group.
+type Match struct {
+	Image
+	Distance int // Hamming distance to the hash of the kept image
+}
+
+// Group is a set of images that show the same picture.
+type Group struct {
+	Keep       Image  // Image suggested to keep
+	KeepReason string // Why Keep was chosen over the others
+	Similar    []Match
+}
+
+// TrashOperation represents moving one similar image to trash.
+type TrashOperation struct {
+	Path      string // Similar image to trash
+	KeptPath  string // Image of the group that is kept
+	Distance  int    // Hamming distance to the kept image
+	Size      int64
+	Hash      string // SHA256 of the image when it was trashed
+	TrashedTo string // Trash destination (empty when trasher is nil)
+	Error     error
+}
+
+// FailedImage is an image that could not be decoded.
+type FailedImage struct {
+	Path  string
+	Error error
+}
+
+// Result contains the results of a similarity search.
+type Result struct {
+	Groups         []Group
+	Operations     []TrashOperation
+	Failed         []FailedImage
+	TotalFiles     int
+	ImagesHashed   int
+	SimilarFound   int
+	TrashedCount   int
+	ErrorCount     int
+	BytesRecovered int64
+}
+
+// Finder groups similar images and optionally trashes all but the kept one
+// of each group.
+type Finder struct {
+	dryRun      bool
+	validator   *safepath.Validator
+	hasher      *hasher.Hasher
+	trasher     *trash.Trasher
+	workers     int
+	algorithm   Algorithm
+	maxDistance int
+}
+
+// Option configures a Finder.
+type Option func(*Finder)
+
+// WithAlgorithm selects the perceptual hash; the default is
+// [AlgorithmPHash].
+func WithAlgorithm(algo Algorithm) Option {
+	return func(f *Finder) {
+		f.algorithm = algo
+	}
+}
+
+// WithMaxDistance sets the maximum Hamming distance, from 0 to 64, between
+// the hash of an image and that of the kept image of its group. The
+// default is [DefaultMaxDistance].
+func WithMaxDistance(distance int) Option {
+	return func(f *Finder) {
+		f.maxDistance = distance
+	}
+}
+
+// NewWithValidator creates a new Finder with an existing validator. In
+// dry-run mode the groups and planned operations are reported but nothing
+// is trashed. An optional trasher enables soft-delete (move to trash)
+// instead of permanent removal.
+func NewWithValidator(
+	validator *safepath.Validator,
+	dryRun bool,
+	workers int,
+	trasher *trash.Trasher,
+	opts ...Option,
+) (*Finder, error) {
+	if validator == nil {
+		return nil, errors.New("validator is required")
+	}
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	f := &Finder{
+		dryRun:      dryRun,
+		validator:   validator,
+		hasher:      hasher.New(),
+		trasher:     trasher,
+		workers:     workers,
+		algorithm:   AlgorithmPHash,
+		maxDistance: DefaultMaxDistance,
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+
+	algo, err := ParseAlgorithm(string(f.algorithm))
+	if err != nil {
+		return nil, err
+	}
+	f.algorithm = algo
+
+	if f.maxDistance < 0 || f.maxDistance > 64 {
+		return nil, fmt.Errorf("invalid maximum distance %d (want 0 to 64)", f.maxDistance)
+	}
+
+	return f, nil
+}
+
+// IsImage reports whether name has the extension of an image format the
+// finder decodes: JPEG, PNG or GIF.
+func IsImage(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".jpg", ".jpeg", ".jpe", ".png", ".gif":
+		return true
+	default:
+		return false
+	}
+}
+
+// FindSimilarWithProgress hashes the images among files, groups those that
+// show the same picture, and trashes every image of a group but the kept
+// one unless in dry-run mode. Once ctx is done no further image is hashed
+// or trashed; images are not grouped when hashing was cut short.
+func (f *Finder) FindSimilarWithProgress(ctx context.Context, files []collector.FileInfo, onProgress func(stage string, processed, total int)) Result {
+	result := Result{
+		TotalFiles: len(files),
+		Operations: make([]TrashOperation, 0),
+	}
+
+	candidates := make([]collector.FileInfo, 0)
+	for _, file := range files {
+		if IsImage(file.Name) {
+			candidates = append(candidates, file)
+		}
+	}
+	if len(candidates) == 0 {
+		return result
+	}
+
+	safeFiles, invalidReadOps := safepath.ValidateReadPaths(f.validator, candidates, func(file collector.FileInfo, err error) TrashOperation {
+		return TrashOperation{
+			Path:  file.Path,
+			Size:  file.Size,
+			Error: fmt.Errorf("path escapes root: %w", err),
+		}
+	})
+	if len(invalidReadOps) > 0 {
+		result.Operations = append(result.Operations, invalidReadOps...)
+		result.calculateCounts()
+		return result
+	}
+
+	images, failed := f.hashImages(ctx, safeFiles, onProgress)
+	result.ImagesHashed = len(images)
+	result.Failed = failed
+	if ctx.Err() != nil {
+		return result
+	}
+	result.Groups = f.groupImages(images, onProgress)
+
+	total := 0
+	for _, group := range result.Groups {
+		total += len(group.Similar)
+	}
+	for _, group := range result.Groups {
+		for _, match := range group.Similar {
+			if ctx.Err() != nil {
+				break
+			}
+			result.Operations = append(result.Operations, f.trashImage(match, group.Keep))
+			progress.EmitStage(onProgress, progressStageTrashing, len(result.Operations), total)
+		}
+	}
+
+	result.calculateCounts()
+
+	return result
+}
+
+func (r *Result) calculateCounts() {
+	for _, op := range r.Operations {
+		if op.KeptPath != "" {
+			r.SimilarFound++
+		}
+		if op.Error != nil {
+			r.ErrorCount++
+			continue
+		}
+		r.TrashedCount++
+		r.BytesRecovered += op.Size
+	}
+}
+
+// hashImages decodes and hashes files on the finder's workers. Images are
+// returned in the order of files; those that cannot be decoded are
+// returned as failed. Files not started when ctx is done are left out.
+func (f *Finder) hashImages(ctx context.Context, files []collector.FileInfo, onProgress func(stage string, processed, total int)) ([]Image, []FailedImage) {
+	type hashed struct {
+		image Image
+		err   error
+		done  bool
+	}
+
+	results := make([]hashed, len(files))
+	indexes := make(chan int)
+	done := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for range min(f.workers, len(files)) {
+		wg.Go(func() {
+			for i := range indexes {
+				img, err := f.hashImage(files[i])
+				results[i] = hashed{image: img, err: err, done: true}
+				done <- struct{}{}
+			}
+		})
+	}
+
+	go func() {
+		for i := range files {
+			if ctx.Err() != nil {
+				break
+			}
+			select {
+			case <-ctx.Done():
+			case indexes <- i:
+			}
+		}
+		close(indexes)
+		wg.Wait()
+		close(done)
+	}()
+
+	processed := 0
+	for range done {
+		processed++
+		progress.EmitStage(onProgress, progressStageHashing, processed, len(files))
+	}
+
+	images := make([]Image, 0, len(files))
+	var failed []FailedImage
+	for i, r := range results {
+		if !r.done {
+			continue
+		}
+		if r.err != nil {
+			failed = append(failed, FailedImage{Path: files[i].Path, Error: r.err})
+			continue
+		}
+		images = append(images, r.image)
+	}
+
+	return images, failed
+}
+
+// hashImage decodes file and computes its perceptual hash.
+func (f *Finder) hashImage(file collector.FileInfo) (Image, error) {
+	in, err := os.Open(file.Path)
+	if err != nil {
+		return Image{}, fmt.Errorf("open image: %w", err)
+	}
+	defer in.Close()
+
+	config, _, err := image.DecodeConfig(in)
+	if err != nil {
+		return Image{}, fmt.Errorf("decode image: %w", err)
+	}
+	if config.Width*config.Height > maxPixels {
+		return Image{}, fmt.Errorf("image of %dx%d pixels is too large to decode", config.Width, config.Height)
+	}
+
+	if _, err := in.Seek(0, io.SeekStart); err != nil {
+		return Image{}, fmt.Errorf("rewind image: %w", err)
+	}
+	img, _, err := image.Decode(in)
+	if err != nil {
+		return Image{}, fmt.Errorf("decode image: %w", err)
+	}
+
+	bounds := img.Bounds()
+	return Image{
+		Path:    file.Path,
+		Size:    file.Size,
+		ModTime: file.ModTime,
+		Width:   bounds.Dx(),
+		Height:  bounds.Dy(),
+		Hash:    HashImage(img, f.algorithm),
+	}, nil
+}
+
+// groupImages groups images around the best-ranked one not grouped yet:
+// every remaining image within the maximum distance of it joins its group.
+// Only groups of at least two images are returned.
+func (f *Finder) groupImages(images []Image, onProgress func(stage string, processed, total int)) []Group {
+	sort.SliceStable(images, func(i, j int) bool {
+		_, less := compareImages(images[i], images[j])
+		return less
+	})
+
+	grouped := make([]bool, len(images))
+	var groups []Group
+	for i, keep := range images {
+		progress.EmitStage(onProgress, progressStageComparing, i+1, len(images))
+		if grouped[i] {
+			continue
+		}
+
+		group := Group{Keep: keep}
+		reasons := make(map[string]struct{})
+		for j := i + 1; j < len(images); j++ {
+			if grouped[j] {
+				continue
+			}
+			distance := keep.Hash.Distance(images[j].Hash)
+			if distance > f.maxDistance {
+				continue
+			}
+			grouped[j] = true
+			group.Similar = append(group.Similar, Match{Image: images[j], Distance: distance})
+			reason, _ := compareImages(keep, images[j])
+			reasons[reason] = struct{}{}
+		}
+
+		if len(group.Similar) > 0 {
+			group.KeepReason = joinReasons(reasons)
+			groups = append(groups, group)
+		}
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Keep.Path < groups[j].Keep.Path
+	})
+
+	return groups
+}
+
+// Keep reasons, in order of significance.
+var keepReasons = []string{"highest resolution", "largest file", "first in lexical order"}
+
+// compareImages reports whether a ranks before b as the image to keep: the
+// higher resolution wins, then the larger file, as the one less likely to
+// have been re-encoded at a lower quality, then the path in lexical order.
+// It also returns the reason that decided.
+func compareImages(a, b Image) (reason string, less bool) {
+	if a.Pixels() != b.Pixels() {
+		return keepReasons[0], a.Pixels() > b.Pixels()
+	}
+	if a.Size != b.Size {
+		return keepReasons[1], a.Size > b.Size
+	}
+	return keepReasons[2], a.Path < b.Path
+}
+
+// joinReasons joins the reasons that set the kept image apart, most
+// significant first.
+func joinReasons(reasons map[string]struct{}) string {
+	parts := make([]string, 0, len(reasons))
+	for _, reason := range keepReasons {
+		if _, ok := reasons[reason]; ok {
+			parts = append(parts, reason)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// trashImage creates a trash operation for match and performs it unless in
+// dry-run mode. The kept image must still exist and match must be
+// unchanged since it was hashed.
+func (f *Finder) trashImage(match Match, keep Image) TrashOperation {
+	op := TrashOperation{
+		Path:     match.Path,
+		KeptPath: keep.Path,
+		Distance: match.Distance,
+		Size:     match.Size,
+	}
+
+	if err := f.validator.ValidatePathForWrite(op.Path); err != nil {
+		op.Error = fmt.Errorf("path escapes root: %w", err)
+		return op
+	}
+
+	if f.dryRun {
+		return op
+	}
+
+	if _, err := os.Lstat(keep.Path); err != nil {
+		op.Error = fmt.Errorf("kept image missing, refusing to trash similar image: %w", err)
+		return op
+	}
+
+	info, err := os.Lstat(op.Path)
+	if err != nil {
+		op.Error = fmt.Errorf("stat image: %w", err)
+		return op
+	}
+	if info.Size() != match.Size || !info.ModTime().Equal(match.ModTime) {
+		op.Error = ErrImageChanged
+		return op
+	}
+
+	op.Hash, err = f.hasher.ComputeHash(op.Path)
+	if err != nil {
+		op.Error = fmt.Errorf("hash before trash: %w", err)
+		return op
+	}
+
+	if f.trasher != nil {
+		op.TrashedTo, op.Error = f.trasher.TrashWithDest(op.Path)
+		return op
+	}
+
+	if err := f.validator.SafeRemove(op.Path); err != nil {
+		op.Error = fmt.Errorf("failed to delete: %w", err)
+	}
+
+	return op
+}
+
+// DryRun returns whether the finder is in dry-run mode.
+func (f *Finder) DryRun() bool {
+	return f.dryRun
+}
@@ -0,0 +1,284 @@
+package similarity
+
+import (
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"btidy/pkg/collector"
+	"btidy/pkg/metadata"
+	"btidy/pkg/safepath"
+	"btidy/pkg/trash"
+)
+
+// picture renders a mix of waves at width x height. The waves are defined
+// in coordinates relative to the size, so every size shows the same
+// picture; seed selects the picture.
+func picture(width, height int, seed int64) *image.RGBA {
+	rng := rand.New(rand.NewSource(seed))
+	type wave struct{ fu, fv, phase, amplitude float64 }
+	waves := make([]wave, 12)
+	for i := range waves {
+		waves[i] = wave{
+			fu:        rng.Float64() * 6,
+			fv:        rng.Float64() * 6,
+			phase:     rng.Float64() * 2 * math.Pi,
+			amplitude: 10 + rng.Float64()*20,
+		}
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			u, v := float64(x)/float64(width), float64(y)/float64(height)
+			value := 128.0
+			for _, w := range waves {
+				value += w.amplitude * math.Sin(2*math.Pi*(w.fu*u+w.fv*v)+w.phase)
+			}
+			c := uint8(max(0, min(255, value)))
+			img.Set(x, y, color.RGBA{R: c, G: c / 2, B: 255 - c, A: 255})
+		}
+	}
+	return img
+}
+
+func writeImage(t *testing.T, path string, img image.Image) {
+	t.Helper()
+
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	out, err := os.Create(path)
+	require.NoError(t, err)
+	defer out.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".png":
+		require.NoError(t, png.Encode(out, img))
+	case ".gif":
+		require.NoError(t, gif.Encode(out, img, nil))
+	default:
+		quality := 95
+		if img.Bounds().Dx() < 300 {
+			quality = 40
+		}
+		require.NoError(t, jpeg.Encode(out, img, &jpeg.Options{Quality: quality}))
+	}
+}
+
+// similarFixture writes the same picture as a large JPEG, a small low
+// quality JPEG, a PNG and a GIF, an unrelated picture, a file with an
+// image extension that is not an image, and a text file.
+func similarFixture(t *testing.T) string {
+	t.Helper()
+
+	root := t.TempDir()
+	writeImage(t, filepath.Join(root, "originals", "beach.jpg"), picture(640, 480, 0))
+	writeImage(t, filepath.Join(root, "beach-small.jpg"), picture(160, 120, 0))
+	writeImage(t, filepath.Join(root, "export", "beach.png"), picture(320, 240, 0))
+	writeImage(t, filepath.Join(root, "beach.gif"), picture(200, 150, 0))
+	writeImage(t, filepath.Join(root, "mountain.jpg"), picture(640, 480, 1))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "broken.jpg"), []byte("not an image"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "notes.txt"), []byte("notes"), 0o600))
+
+	return root
+}
+
+func similarNames(t *testing.T, root string, group Group) []string {
+	t.Helper()
+
+	names := make([]string, 0, len(group.Similar))
+	for _, match := range group.Similar {
+		rel, err := filepath.Rel(root, match.Path)
+		require.NoError(t, err)
+		names = append(names, filepath.ToSlash(rel))
+	}
+	return names
+}
+
+func TestParseAlgorithm(t *testing.T) {
+	algo, err := ParseAlgorithm("")
+	require.NoError(t, err)
+	assert.Equal(t, AlgorithmPHash, algo)
+
+	for _, value := range []string{"dhash", "phash"} {
+		algo, err = ParseAlgorithm(value)
+		require.NoError(t, err)
+		assert.Equal(t, Algorithm(value), algo)
+	}
+
+	_, err = ParseAlgorithm("ahash")
+	require.Error(t, err)
+}
+
+func TestHashDistance(t *testing.T) {
+	assert.Zero(t, Hash(0xff).Distance(0xff))
+	assert.Equal(t, 64, Hash(0).Distance(^Hash(0)))
+	assert.Equal(t, 2, Hash(0b1010).Distance(0b0000))
+	assert.Equal(t, "00000000000000ff", Hash(0xff).String())
+}
+
+func TestHashImage_ResizeAndReencodeKeepHashClose(t *testing.T) {
+	for _, algo := range []Algorithm{AlgorithmDHash, AlgorithmPHash} {
+		t.Run(string(algo), func(t *testing.T) {
+			large := HashImage(picture(640, 480, 0), algo)
+			small := HashImage(picture(64, 48, 0), algo)
+			other := HashImage(picture(640, 480, 1), algo)
+
+			assert.LessOrEqual(t, large.Distance(small), DefaultMaxDistance)
+			assert.Greater(t, large.Distance(other), DefaultMaxDistance)
+		})
+	}
+}
+
+func TestHashImage_TinyImage(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 3, 2))
+	assert.NotPanics(t, func() {
+		HashImage(img, AlgorithmDHash)
+		HashImage(img, AlgorithmPHash)
+	})
+}
+
+func TestIsImage(t *testing.T) {
+	assert.True(t, IsImage("a.JPG"))
+	assert.True(t, IsImage("a.jpeg"))
+	assert.True(t, IsImage("a.png"))
+	assert.True(t, IsImage("a.gif"))
+	assert.False(t, IsImage("a.heic"))
+	assert.False(t, IsImage("jpg"))
+}
+
+func TestNewWithValidator_RejectsInvalidOptions(t *testing.T) {
+	v, err := safepath.New(t.TempDir())
+	require.NoError(t, err)
+
+	_, err = NewWithValidator(v, true, 1, nil, WithMaxDistance(65))
+	require.Error(t, err)
+	_, err = NewWithValidator(v, true, 1, nil, WithAlgorithm("ahash"))
+	require.Error(t, err)
+	_, err = NewWithValidator(nil, true, 1, nil)
+	require.Error(t, err)
+}
+
+func TestFinder_FindSimilar_GroupsAroundHighestResolution(t *testing.T) {
+	for _, algo := range []Algorithm{AlgorithmDHash, AlgorithmPHash} {
+		t.Run(string(algo), func(t *testing.T) {
+			root := similarFixture(t)
+			v, err := safepath.New(root)
+			require.NoError(t, err)
+			f, err := NewWithValidator(v, true, 2, nil, WithAlgorithm(algo))
+			require.NoError(t, err)
+			files, err := collector.New(collector.Options{}).Collect(t.Context(), root)
+			require.NoError(t, err)
+
+			result := f.FindSimilarWithProgress(t.Context(), files, nil)
+			assert.Equal(t, 7, result.TotalFiles)
+			assert.Equal(t, 5, result.ImagesHashed)
+			require.Len(t, result.Failed, 1)
+			assert.Equal(t, filepath.Join(root, "broken.jpg"), result.Failed[0].Path)
+
+			require.Len(t, result.Groups, 1)
+			group := result.Groups[0]
+			assert.Equal(t, filepath.Join(root, "originals", "beach.jpg"), group.Keep.Path)
+			assert.Equal(t, 640, group.Keep.Width)
+			assert.Equal(t, "highest resolution", group.KeepReason)
+			assert.ElementsMatch(t, []string{"beach-small.jpg", "export/beach.png", "beach.gif"}, similarNames(t, root, group))
+			for _, match := range group.Similar {
+				assert.LessOrEqual(t, match.Distance, DefaultMaxDistance)
+			}
+
+			assert.Equal(t, 3, result.SimilarFound)
+			assert.Len(t, result.Operations, 3)
+			assert.Zero(t, result.ErrorCount)
+			assert.FileExists(t, filepath.Join(root, "beach-small.jpg"), "dry run trashes nothing")
+		})
+	}
+}
+
+func TestFinder_FindSimilar_ZeroDistanceOnlyGroupsIdenticalPictures(t *testing.T) {
+	root := t.TempDir()
+	writeImage(t, filepath.Join(root, "a.png"), picture(320, 240, 0))
+	writeImage(t, filepath.Join(root, "b.png"), picture(320, 240, 0))
+	writeImage(t, filepath.Join(root, "c.jpg"), picture(100, 75, 1))
+
+	v, err := safepath.New(root)
+	require.NoError(t, err)
+	f, err := NewWithValidator(v, true, 2, nil, WithMaxDistance(0))
+	require.NoError(t, err)
+	files, err := collector.New(collector.Options{}).Collect(t.Context(), root)
+	require.NoError(t, err)
+
+	result := f.FindSimilarWithProgress(t.Context(), files, nil)
+
+	require.Len(t, result.Groups, 1)
+	assert.Equal(t, filepath.Join(root, "a.png"), result.Groups[0].Keep.Path)
+	assert.Equal(t, "first in lexical order", result.Groups[0].KeepReason)
+	assert.Equal(t, []string{"b.png"}, similarNames(t, root, result.Groups[0]))
+}
+
+func TestFinder_FindSimilar_TrashesAllButKept(t *testing.T) {
+	root := similarFixture(t)
+	v, err := safepath.New(root)
+	require.NoError(t, err)
+	metaDir, err := metadata.Init(root, v)
+	require.NoError(t, err)
+	trasher, err := trash.New(metaDir, metaDir.RunID("similar"), v)
+	require.NoError(t, err)
+	f, err := NewWithValidator(v, false, 2, trasher)
+	require.NoError(t, err)
+	files, err := collector.New(collector.Options{SkipDirs: []string{".btidy"}}).Collect(t.Context(), root)
+	require.NoError(t, err)
+
+	result := f.FindSimilarWithProgress(t.Context(), files, nil)
+	require.Equal(t, 3, result.TrashedCount)
+	assert.Zero(t, result.ErrorCount)
+	assert.Positive(t, result.BytesRecovered)
+
+	for _, op := range result.Operations {
+		assert.Equal(t, filepath.Join(root, "originals", "beach.jpg"), op.KeptPath)
+		assert.NotEmpty(t, op.Hash)
+		assert.NoFileExists(t, op.Path)
+		assert.FileExists(t, op.TrashedTo)
+	}
+	assert.FileExists(t, filepath.Join(root, "originals", "beach.jpg"))
+	assert.FileExists(t, filepath.Join(root, "mountain.jpg"))
+	assert.FileExists(t, filepath.Join(root, "broken.jpg"))
+}
+
+func TestFinder_TrashImage_RefusesChangedImage(t *testing.T) {
+	root := t.TempDir()
+	keptPath := filepath.Join(root, "a.png")
+	similarPath := filepath.Join(root, "b.jpg")
+	writeImage(t, keptPath, picture(320, 240, 0))
+	writeImage(t, similarPath, picture(160, 120, 0))
+
+	v, err := safepath.New(root)
+	require.NoError(t, err)
+	metaDir, err := metadata.Init(root, v)
+	require.NoError(t, err)
+	trasher, err := trash.New(metaDir, metaDir.RunID("similar"), v)
+	require.NoError(t, err)
+	f, err := NewWithValidator(v, false, 2, trasher)
+	require.NoError(t, err)
+
+	info, err := os.Stat(similarPath)
+	require.NoError(t, err)
+	stale := Match{Image: Image{Path: similarPath, Size: info.Size() + 1, ModTime: info.ModTime()}}
+
+	op := f.trashImage(stale, Image{Path: keptPath})
+	require.ErrorIs(t, op.Error, ErrImageChanged)
+	assert.FileExists(t, similarPath)
+
+	op = f.trashImage(Match{Image: Image{Path: similarPath, Size: info.Size(), ModTime: info.ModTime()}}, Image{Path: filepath.Join(root, "gone.png")})
+	require.Error(t, op.Error)
+	assert.FileExists(t, similarPath, "nothing is trashed once the kept image is gone")
+}
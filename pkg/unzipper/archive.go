@@ -0,0 +1,248 @@
+package unzipper
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"time"
+)
+
+// entryKind classifies an archive member by what extraction must create.
+type entryKind int
+
+const (
+	// entryKindFile is a regular file whose body is written to disk.
+	entryKindFile entryKind = iota
+
+	// entryKindDir is a directory entry.
+	entryKindDir
+
+	// entryKindSymlink is a symbolic link; linkTarget holds the link text.
+	entryKindSymlink
+
+	// entryKindHardlink is a tar hard link; linkTarget names an earlier
+	// entry in the same archive.
+	entryKindHardlink
+
+	// entryKindOther covers device nodes, FIFOs and other special files
+	// that are never extracted.
+	entryKindOther
+)
+
+// String names the kind as listed by [Unzipper.ListArchives].
+func (k entryKind) String() string {
+	switch k {
+	case entryKindFile:
+		return "file"
+	case entryKindDir:
+		return "dir"
+	case entryKindSymlink:
+		return "symlink"
+	case entryKindHardlink:
+		return "hardlink"
+	default:
+		return "other"
+	}
+}
+
+// archiveEntry is a format-neutral view of one archive member. It lets the
+// extraction code treat zip, 7z and tar members the same way.
+type archiveEntry struct {
+	// name is the entry path as stored in the archive, decoded to UTF-8
+	// for zip entries written with a legacy code page.
+	name string
+
+	// kind determines how the entry is materialized.
+	kind entryKind
+
+	// mode carries the permission bits recorded for the entry.
+	mode fs.FileMode
+
+	// linkTarget is the symlink text or hardlink target for link entries.
+	linkTarget string
+
+	// modTime is the entry's modification time, or the zero time when the
+	// archive does not record one.
+	modTime time.Time
+
+	// size is the uncompressed size recorded for file entries.
+	size int64
+
+	// compressedSize is the stored size of a zip entry. It is zero for tar
+	// and 7z entries, which may be compressed as one stream.
+	compressedSize int64
+
+	// method names how the entry body is compressed (and encrypted), for
+	// listings.
+	method string
+
+	// crc32 is the recorded checksum of the entry body when hasCRC is set.
+	crc32  uint32
+	hasCRC bool
+
+	// damaged is set for entries found by a salvage scan whose data could
+	// not be verified; they are reported and never extracted.
+	damaged error
+
+	// open returns the decompressed entry body. For streaming formats the
+	// returned reader is only valid until the visitor callback returns.
+	open func() (io.ReadCloser, error)
+}
+
+// archiveSource is an opened archive whose entries can be visited in order.
+type archiveSource interface {
+	// validate reports archive-level problems that must stop extraction
+	// before anything is written, such as unsupported compression methods.
+	validate() error
+
+	// walk calls fn for each entry in archive order and stops at the first
+	// error returned by fn or encountered while reading the archive.
+	walk(fn func(archiveEntry) error) error
+
+	// Close releases the underlying file handle.
+	Close() error
+}
+
+// openArchive opens filePath as any supported archive format. Zip is tried
+// first because it is by far the most common format in our backups; 7z and
+// tar (plain or compressed) are tried next. The zip error is returned when
+// no format matches, since it is the most descriptive for the
+// historical "not a zip file" case. cfg supplies the reading settings,
+// such as the legacy code page for zip entry names.
+//
+// With cfg.salvage set, a file that starts like a zip archive but matches
+// neither format is read from its local file headers by
+// [openSalvageArchive].
+func openArchive(filePath string, cfg extractConfig) (archiveSource, error) {
+	zr, zipErr := openArchiveReader(filePath)
+	if zipErr == nil {
+		zr.nameEncoding = cfg.nameEncoding
+		zr.passwords = cfg.passwords
+		return zr, nil
+	}
+
+	sr, sevenZipErr := openSevenZipArchive(filePath, cfg.passwords)
+	if sevenZipErr == nil {
+		return sr, nil
+	}
+	if !errors.Is(sevenZipErr, errNotSevenZipArchive) {
+		return nil, sevenZipErr
+	}
+
+	tr, tarErr := openTarArchive(filePath)
+	if tarErr == nil {
+		return tr, nil
+	}
+
+	if errors.Is(tarErr, errNotTarArchive) {
+		if cfg.salvage && !errors.Is(zipErr, errSplitVolumeMissing) {
+			if sr, salvageErr := openSalvageArchive(filePath, cfg.nameEncoding); salvageErr == nil {
+				return sr, nil
+			}
+		}
+		return nil, zipErr
+	}
+
+	return nil, errors.Join(zipErr, tarErr)
+}
+
+// openArchiveData opens an archive held in memory, such as one nested in
+// another archive, as zip, 7z or tar, with the reading settings of cfg.
+// Split volumes and salvage scans need the archive on disk and are not
+// tried.
+func openArchiveData(data []byte, cfg extractConfig) (archiveSource, error) {
+	noClose := func() error { return nil }
+
+	src := bytes.NewReader(data)
+	zr, zipErr := zip.NewReader(src, src.Size())
+	if zipErr == nil {
+		registerDecompressors(zr)
+		return &archiveReader{
+			files:        zr.File,
+			closeFn:      noClose,
+			nameEncoding: cfg.nameEncoding,
+			passwords:    cfg.passwords,
+		}, nil
+	}
+
+	sr, sevenZipErr := newSevenZipArchive(src, src.Size(), noClose, cfg.passwords)
+	if sevenZipErr == nil {
+		return sr, nil
+	}
+	if !errors.Is(sevenZipErr, errNotSevenZipArchive) {
+		return nil, sevenZipErr
+	}
+
+	tr, tarErr := newTarArchive(bytes.NewReader(data), noClose)
+	if tarErr == nil {
+		return tr, nil
+	}
+	if errors.Is(tarErr, errNotTarArchive) {
+		return nil, zipErr
+	}
+
+	return nil, errors.Join(zipErr, tarErr)
+}
+
+// validate implements [archiveSource] for zip archives by rejecting
+// entries that use compression methods this package cannot decode, and
+// encrypted entries that none of the supplied passwords decrypts.
+func (r *archiveReader) validate() error {
+	if err := validateCompressionMethods(r.files); err != nil {
+		return err
+	}
+
+	return r.unlock()
+}
+
+// walk implements [archiveSource] for zip archives.
+func (r *archiveReader) walk(fn func(archiveEntry) error) error {
+	for _, f := range r.files {
+		open := func() (io.ReadCloser, error) {
+			return r.open(f)
+		}
+
+		kind := entryKindFile
+		var linkTarget string
+		switch {
+		case f.FileInfo().IsDir():
+			kind = entryKindDir
+		case f.Mode()&fs.ModeSymlink != 0:
+			// Zip stores a symlink as an entry whose body is the link text.
+			target, err := zipLinkTarget(f, open, r.nameEncoding)
+			if err != nil {
+				return fmt.Errorf("failed to read symlink target of %s: %w", f.Name, err)
+			}
+			kind = entryKindSymlink
+			linkTarget = target
+		}
+
+		// Modified already prefers the extended-timestamp (0x5455) and NTFS
+		// extra fields over the two-second MS-DOS time when present.
+		entry := archiveEntry{
+			name:       zipEntryName(f, r.nameEncoding),
+			kind:       kind,
+			mode:       f.Mode().Perm(),
+			linkTarget: linkTarget,
+			modTime:    f.Modified,
+			open:       open,
+
+			size:           clampSize(f.UncompressedSize64),
+			compressedSize: clampSize(f.CompressedSize64),
+
+			method: zipEntryMethod(f),
+		}
+		if kind != entryKindDir {
+			entry.crc32, entry.hasCRC = zipEntryCRC(f)
+		}
+
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
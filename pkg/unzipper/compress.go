@@ -0,0 +1,121 @@
+package unzipper
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// compressionFormat identifies a single-stream compression wrapper such as
+// the ".gz" in ".tar.gz".
+type compressionFormat int
+
+const (
+	// compressionNone means the stream is not compressed.
+	compressionNone compressionFormat = iota
+
+	// compressionGzip is RFC 1952 gzip.
+	compressionGzip
+
+	// compressionBzip2 is bzip2.
+	compressionBzip2
+
+	// compressionXz is the xz container format.
+	compressionXz
+
+	// compressionZstd is Zstandard.
+	compressionZstd
+)
+
+// compressionMagicLen is the number of leading bytes needed to recognize
+// every supported compression format.
+const compressionMagicLen = 6
+
+var (
+	// gzipMagic is the gzip member header signature (RFC 1952 §2.3.1).
+	gzipMagic = []byte{0x1f, 0x8b}
+
+	// bzip2Magic is the bzip2 stream signature "BZh".
+	bzip2Magic = []byte("BZh")
+
+	// xzMagic is the xz stream header magic (xz file format §2.1.1.1).
+	xzMagic = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+
+	// zstdMagic is the Zstandard frame magic number 0xFD2FB528 (LE).
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// String returns the conventional short name of the format.
+func (c compressionFormat) String() string {
+	switch c {
+	case compressionGzip:
+		return "gzip"
+	case compressionBzip2:
+		return "bzip2"
+	case compressionXz:
+		return "xz"
+	case compressionZstd:
+		return "zstd"
+	default:
+		return "none"
+	}
+}
+
+// detectCompression peeks at the start of r and reports which compression
+// wrapper, if any, the stream uses. No bytes are consumed.
+func detectCompression(r *bufio.Reader) compressionFormat {
+	// A short peek is expected for tiny files; whatever was read is enough
+	// to rule formats in or out.
+	head, _ := r.Peek(compressionMagicLen)
+
+	switch {
+	case bytes.HasPrefix(head, gzipMagic):
+		return compressionGzip
+	case bytes.HasPrefix(head, bzip2Magic):
+		return compressionBzip2
+	case bytes.HasPrefix(head, xzMagic):
+		return compressionXz
+	case bytes.HasPrefix(head, zstdMagic):
+		return compressionZstd
+	default:
+		return compressionNone
+	}
+}
+
+// newDecompressor wraps r with a decoder for format. For compressionNone the
+// reader is returned unchanged. The caller must close the returned reader;
+// closing it does not close r.
+func newDecompressor(format compressionFormat, r io.Reader) (io.ReadCloser, error) {
+	switch format {
+	case compressionNone:
+		return io.NopCloser(r), nil
+	case compressionGzip:
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("open gzip stream: %w", err)
+		}
+		return zr, nil
+	case compressionBzip2:
+		return io.NopCloser(bzip2.NewReader(r)), nil
+	case compressionXz:
+		xr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("open xz stream: %w", err)
+		}
+		return io.NopCloser(xr), nil
+	case compressionZstd:
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, fmt.Errorf("open zstd stream: %w", err)
+		}
+		return zr.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("unknown compression format %d", format)
+	}
+}
@@ -0,0 +1,78 @@
+package unzipper
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"btidy/pkg/hasher"
+)
+
+// mkdirAllTracked creates dir and any missing parents like [os.MkdirAll],
+// and appends every directory it actually created to op.CreatedDirs.
+// Directories that already existed are never recorded, so undo only
+// removes what the extraction introduced.
+func mkdirAllTracked(dir string, perm os.FileMode, op *ExtractOperation) error {
+	var missing []string
+	for current := dir; ; {
+		_, err := os.Lstat(current)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		missing = append(missing, current)
+
+		parent := filepath.Dir(current)
+		if parent == current {
+			break
+		}
+		current = parent
+	}
+
+	if err := os.MkdirAll(dir, perm); err != nil {
+		return err
+	}
+
+	for i := len(missing) - 1; i >= 0; i-- {
+		op.CreatedDirs = append(op.CreatedDirs, missing[i])
+	}
+
+	return nil
+}
+
+// createdByThisExtraction reports whether path was already written by the
+// extraction op describes.
+func (op *ExtractOperation) createdByThisExtraction(path string) bool {
+	_, ok := op.createdIndex[path]
+	return ok
+}
+
+// recordCreatedFile adds path to op.CreatedFiles, along with the chain of
+// the nested archive being extracted. When withHash is set the file content
+// is hashed so undo can tell whether it changed since. A path written a
+// second time keeps its original position with the new hash.
+func (op *ExtractOperation) recordCreatedFile(path string, withHash bool) error {
+	created := CreatedFile{Path: path, Nested: op.nested}
+	if withHash {
+		hash, err := hasher.New().ComputeHash(path)
+		if err != nil {
+			return err
+		}
+		created.Hash = hash
+	}
+
+	if i, ok := op.createdIndex[path]; ok {
+		op.CreatedFiles[i] = created
+		return nil
+	}
+
+	if op.createdIndex == nil {
+		op.createdIndex = make(map[string]int)
+	}
+	op.createdIndex[path] = len(op.CreatedFiles)
+	op.CreatedFiles = append(op.CreatedFiles, created)
+
+	return nil
+}
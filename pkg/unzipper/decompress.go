@@ -0,0 +1,177 @@
+package unzipper
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"btidy/pkg/collector"
+	"btidy/pkg/hasher"
+)
+
+// singleFileSuffixes maps the file extensions recognized for standalone
+// compressed files to the compression format their content must carry.
+// The extension is stripped to name the decompressed output.
+var singleFileSuffixes = map[string]compressionFormat{
+	".gz":  compressionGzip,
+	".bz2": compressionBzip2,
+	".xz":  compressionXz,
+	".zst": compressionZstd,
+}
+
+// singleFileCompression reports the compression format of a standalone
+// compressed file such as "dump.sql.gz". A file qualifies only when its
+// extension is one of [singleFileSuffixes], its magic bytes match that
+// extension, and it is not a compressed tarball (which [isArchive] handles).
+func singleFileCompression(filePath string) (compressionFormat, bool) {
+	want, ok := singleFileSuffixes[strings.ToLower(filepath.Ext(filePath))]
+	if !ok {
+		return compressionNone, false
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return compressionNone, false
+	}
+	got := detectCompression(bufio.NewReader(f))
+	_ = f.Close()
+
+	if got != want || isArchive(filePath) {
+		return compressionNone, false
+	}
+
+	return got, true
+}
+
+// filterOnlyCompressedFiles returns the standalone compressed files in blob.
+func filterOnlyCompressedFiles(blob []collector.FileInfo) []collector.FileInfo {
+	filteredBlob := make([]collector.FileInfo, 0)
+	for _, f := range blob {
+		if _, ok := singleFileCompression(filepath.Join(f.Dir, f.Name)); ok {
+			filteredBlob = append(filteredBlob, f)
+		}
+	}
+
+	return filteredBlob
+}
+
+// decompressedOutputPath returns the path a standalone compressed file is
+// decompressed to: the same directory and name with the compression
+// extension removed.
+func decompressedOutputPath(filePath string) string {
+	return strings.TrimSuffix(filePath, filepath.Ext(filePath))
+}
+
+// processCompressedFile decompresses a standalone compressed file next to
+// itself, then removes the original (to trash when a trasher is
+// configured). A file already present at the output path is backed up
+// through [backupExistingFile] first. In dry-run mode only the output path
+// is validated and reported.
+//
+// The output is capped at [maxDecompressedSize] bytes, like archive entries
+// written by [extractFile].
+func (u *Unzipper) processCompressedFile(
+	file collector.FileInfo,
+	filePath string,
+	format compressionFormat,
+) (ExtractOperation, error) {
+	op := ExtractOperation{
+		ArchivePath:  filePath,
+		Decompressed: true,
+		OutputPath:   decompressedOutputPath(filePath),
+	}
+
+	if err := u.validator.ValidatePathForWrite(op.OutputPath); err != nil {
+		op.Error = fmt.Errorf("illegal output path %s: %w", op.OutputPath, err)
+		return op, op.Error
+	}
+
+	if u.dryRun {
+		op.ExtractedFiles = 1
+		op.ExtractionComplete = true
+		return op, nil
+	}
+
+	if err := u.decompressFile(file, filePath, format, &op); err != nil {
+		op.Error = err
+		return op, err
+	}
+	op.ExtractedFiles = 1
+	op.ExtractionComplete = true
+
+	if isArchive(op.OutputPath) {
+		op.NestedArchives++
+	}
+
+	trashedTo, rmErr := u.removeArchive(filePath)
+	if rmErr != nil {
+		op.Error = rmErr
+		return op, rmErr
+	}
+	op.TrashedTo = trashedTo
+
+	return op, nil
+}
+
+// decompressFile writes the decompressed content of filePath to
+// op.OutputPath and records the output hash for journaling.
+func (u *Unzipper) decompressFile(
+	file collector.FileInfo,
+	filePath string,
+	format compressionFormat,
+	op *ExtractOperation,
+) error {
+	if err := u.validator.ValidatePathForRead(filePath); err != nil {
+		return fmt.Errorf("illegal source path %s: %w", filePath, err)
+	}
+
+	src, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", filePath, err)
+	}
+	defer func() {
+		_ = src.Close()
+	}()
+
+	info, err := src.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", filePath, err)
+	}
+
+	dec, err := newDecompressor(format, bufio.NewReader(src))
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", file.Name, err)
+	}
+	defer func() {
+		_ = dec.Close()
+	}()
+
+	replaced, replacedFile, replaceErr := backupExistingFile(op.OutputPath, u.trasher)
+	if replaceErr != nil {
+		return fmt.Errorf("failed to backup existing target %s: %w", op.OutputPath, replaceErr)
+	}
+	if replaced {
+		op.ReplacedFiles = append(op.ReplacedFiles, replacedFile)
+	}
+
+	if writeErr := writeFileFromReader(dec, op.OutputPath, info.Mode().Perm()); writeErr != nil {
+		_ = os.Remove(op.OutputPath)
+		return fmt.Errorf("failed to decompress %s: %w", file.Name, writeErr)
+	}
+
+	// The compressed file's own timestamp is the best date available for
+	// its content, so the output inherits it.
+	if timeErr := os.Chtimes(op.OutputPath, info.ModTime(), info.ModTime()); timeErr != nil {
+		return fmt.Errorf("set modification time on %s: %w", op.OutputPath, timeErr)
+	}
+
+	outputHash, err := hasher.New().ComputeHash(op.OutputPath)
+	if err != nil {
+		return fmt.Errorf("hash decompressed output: %w", err)
+	}
+	op.OutputHash = outputHash
+
+	return nil
+}
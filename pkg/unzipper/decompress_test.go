@@ -0,0 +1,193 @@
+package unzipper
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"btidy/pkg/metadata"
+	"btidy/pkg/safepath"
+	"btidy/pkg/trash"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSingleFileCompression(t *testing.T) {
+	root := t.TempDir()
+
+	write := func(name string, content []byte) string {
+		path := filepath.Join(root, name)
+		require.NoError(t, os.WriteFile(path, content, 0o644))
+		return path
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		want   compressionFormat
+		wantOK bool
+	}{
+		{name: "gzip file", path: write("dump.sql.gz", gzipBytes(t, []byte("sql"))), want: compressionGzip, wantOK: true},
+		{name: "xz file", path: write("data.csv.xz", xzBytes(t, []byte("csv"))), want: compressionXz, wantOK: true},
+		{name: "zstd file", path: write("data.csv.zst", zstdBytes(t, []byte("csv"))), want: compressionZstd, wantOK: true},
+		{name: "uppercase extension", path: write("LOG.GZ", gzipBytes(t, []byte("log"))), want: compressionGzip, wantOK: true},
+		{name: "gzipped tarball is an archive", path: write("backup.tar.gz", gzipBytes(t, buildTar(t, []tarMember{
+			{name: "a.txt", typeflag: tar.TypeReg, body: "a"},
+		})))},
+		{name: "extension without matching magic", path: write("fake.gz", []byte("plain text"))},
+		{name: "magic without extension", path: write("noext", gzipBytes(t, []byte("x")))},
+		{name: "extension for another format", path: write("wrong.bz2", gzipBytes(t, []byte("x")))},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := singleFileCompression(tc.path)
+			assert.Equal(t, tc.wantOK, ok)
+			if tc.wantOK {
+				assert.Equal(t, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestExtractWithSingleFileDecompression(t *testing.T) {
+	newUnzipper := func(t *testing.T, root string, dryRun bool, withTrash bool) *Unzipper {
+		t.Helper()
+
+		v, err := safepath.New(root)
+		require.NoError(t, err)
+
+		var trasher *trash.Trasher
+		if withTrash {
+			metaDir, initErr := metadata.Init(root, v)
+			require.NoError(t, initErr)
+			trasher, err = trash.New(metaDir, "test-run", v)
+			require.NoError(t, err)
+		}
+
+		uz, err := NewWithValidator(v, dryRun, trasher, WithSingleFileDecompression(true))
+		require.NoError(t, err)
+		return uz
+	}
+
+	t.Run("decompresses each format and trashes the original", func(t *testing.T) {
+		root := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(root, "dump.sql.gz"), gzipBytes(t, []byte("sql")), 0o644))
+		require.NoError(t, os.WriteFile(filepath.Join(root, "app.log.xz"), xzBytes(t, []byte("log")), 0o644))
+		require.NoError(t, os.WriteFile(filepath.Join(root, "data.csv.zst"), zstdBytes(t, []byte("csv")), 0o644))
+
+		uz := newUnzipper(t, root, false, true)
+		files, err := getAllFilesRecursively(t.Context(), root)
+		require.NoError(t, err)
+
+		result, err := uz.ExtractArchivesWithProgressRecursively(t.Context(), files, nil)
+		require.NoError(t, err)
+
+		assert.Equal(t, 3, result.DecompressedFiles)
+		assert.Equal(t, 0, result.ExtractedArchives)
+		assert.Equal(t, 0, result.ErrorCount)
+
+		for _, tc := range []struct{ compressed, output, want string }{
+			{compressed: "dump.sql.gz", output: "dump.sql", want: "sql"},
+			{compressed: "app.log.xz", output: "app.log", want: "log"},
+			{compressed: "data.csv.zst", output: "data.csv", want: "csv"},
+		} {
+			content, readErr := os.ReadFile(filepath.Join(root, tc.output))
+			require.NoError(t, readErr)
+			assert.Equal(t, tc.want, string(content))
+			assert.NoFileExists(t, filepath.Join(root, tc.compressed))
+		}
+
+		for _, op := range result.Operations {
+			assert.True(t, op.Decompressed)
+			assert.True(t, op.DeletedArchive)
+			assert.NotEmpty(t, op.TrashedTo)
+			assert.NotEmpty(t, op.OutputHash)
+			assert.FileExists(t, op.TrashedTo)
+		}
+	})
+
+	t.Run("backs up an existing output file", func(t *testing.T) {
+		root := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(root, "notes.txt"), []byte("old"), 0o644))
+		require.NoError(t, os.WriteFile(filepath.Join(root, "notes.txt.gz"), gzipBytes(t, []byte("new")), 0o644))
+
+		uz := newUnzipper(t, root, false, true)
+		files, err := getAllFilesRecursively(t.Context(), root)
+		require.NoError(t, err)
+
+		result, err := uz.ExtractArchivesWithProgressRecursively(t.Context(), files, nil)
+		require.NoError(t, err)
+		require.Len(t, result.Operations, 1)
+		require.Len(t, result.Operations[0].ReplacedFiles, 1)
+
+		content, err := os.ReadFile(filepath.Join(root, "notes.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "new", string(content))
+
+		backup, err := os.ReadFile(result.Operations[0].ReplacedFiles[0].TrashedTo)
+		require.NoError(t, err)
+		assert.Equal(t, "old", string(backup))
+	})
+
+	t.Run("decompressed zip is extracted recursively", func(t *testing.T) {
+		root := t.TempDir()
+
+		zipPath := filepath.Join(root, "inner.zip")
+		writeZipWithEntries(t, zipPath, map[string][]byte{"inside.txt": []byte("inside")})
+		zipData, err := os.ReadFile(zipPath)
+		require.NoError(t, err)
+		require.NoError(t, os.Remove(zipPath))
+		require.NoError(t, os.WriteFile(filepath.Join(root, "inner.zip.gz"), gzipBytes(t, zipData), 0o644))
+
+		uz := newUnzipper(t, root, false, false)
+		files, err := getAllFilesRecursively(t.Context(), root)
+		require.NoError(t, err)
+
+		result, err := uz.ExtractArchivesWithProgressRecursively(t.Context(), files, nil)
+		require.NoError(t, err)
+
+		assert.Equal(t, 1, result.DecompressedFiles)
+		assert.Equal(t, 1, result.ExtractedArchives)
+
+		content, err := os.ReadFile(filepath.Join(root, "inside.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "inside", string(content))
+	})
+
+	t.Run("dry run leaves files untouched", func(t *testing.T) {
+		root := t.TempDir()
+		compressedPath := filepath.Join(root, "dump.sql.gz")
+		require.NoError(t, os.WriteFile(compressedPath, gzipBytes(t, []byte("sql")), 0o644))
+
+		uz := newUnzipper(t, root, true, false)
+		files, err := getAllFilesRecursively(t.Context(), root)
+		require.NoError(t, err)
+
+		result, err := uz.ExtractArchivesWithProgressRecursively(t.Context(), files, nil)
+		require.NoError(t, err)
+
+		assert.Equal(t, 1, result.DecompressedFiles)
+		assert.FileExists(t, compressedPath)
+		assert.NoFileExists(t, filepath.Join(root, "dump.sql"))
+	})
+
+	t.Run("disabled by default", func(t *testing.T) {
+		root := t.TempDir()
+		compressedPath := filepath.Join(root, "dump.sql.gz")
+		require.NoError(t, os.WriteFile(compressedPath, gzipBytes(t, []byte("sql")), 0o644))
+
+		uz, err := New(root, false)
+		require.NoError(t, err)
+		files, err := getAllFilesRecursively(t.Context(), root)
+		require.NoError(t, err)
+
+		result, err := uz.ExtractArchivesWithProgressRecursively(t.Context(), files, nil)
+		require.NoError(t, err)
+
+		assert.Equal(t, 0, result.ArchivesFound)
+		assert.FileExists(t, compressedPath)
+	})
+}
@@ -0,0 +1,24 @@
+//go:build !windows
+
+package unzipper
+
+import (
+	"math"
+	"syscall"
+)
+
+// freeSpace reports the bytes available to unprivileged users on the
+// filesystem holding dir, using statfs(2).
+func freeSpace(dir string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+
+	bsize := uint64(st.Bsize) //nolint:gosec,unconvert // Bsize is positive; its type differs per platform
+	if bsize != 0 && st.Bavail > math.MaxInt64/bsize {
+		return math.MaxInt64, nil
+	}
+
+	return int64(st.Bavail * bsize), nil //nolint:gosec // bounded above
+}
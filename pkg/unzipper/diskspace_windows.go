@@ -0,0 +1,35 @@
+//go:build windows
+
+package unzipper
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var (
+	modkernel32             = syscall.NewLazyDLL("kernel32.dll")
+	procGetDiskFreeSpaceExW = modkernel32.NewProc("GetDiskFreeSpaceExW")
+)
+
+// freeSpace reports the bytes available to the calling user on the volume
+// holding dir, using GetDiskFreeSpaceExW.
+func freeSpace(dir string) (int64, error) {
+	path, err := syscall.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	var available uint64
+	r1, _, callErr := procGetDiskFreeSpaceExW.Call(
+		uintptr(unsafe.Pointer(path)),
+		uintptr(unsafe.Pointer(&available)),
+		0,
+		0,
+	)
+	if r1 == 0 {
+		return 0, callErr
+	}
+
+	return clampSize(available), nil
+}
@@ -0,0 +1,509 @@
+// Zip entries can be encrypted in two ways that this package reads:
+//
+//   - Traditional PKWARE encryption ("ZipCrypto", PKZIP APPNOTE.TXT §6.1):
+//     a 12-byte header precedes the compressed data, and the whole stream
+//     is XORed with a keystream from three CRC-32 based keys.
+//   - WinZip AES (AE-1 and AE-2): the entry method is 99 and an extra field
+//     0x9901 names the key strength and the real compression method. The
+//     data is salt, a 2-byte password verifier, the compressed data in AES
+//     CTR mode with a little-endian counter starting at 1, and a 10-byte
+//     HMAC-SHA1 of the ciphertext. Keys come from PBKDF2-HMAC-SHA1 with
+//     1000 iterations.
+//
+// Reference: https://www.winzip.com/en/support/aes-encryption/
+//
+// Before anything is written, every encrypted entry is decrypted in full
+// and checked (CRC-32 for ZipCrypto and AE-1, the HMAC for AES) with the
+// passwords supplied, so a wrong password never leaves files behind.
+package unzipper
+
+import (
+	"archive/zip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/sha1" //nolint:gosec // WinZip AES fixes HMAC-SHA1 and PBKDF2-SHA1
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"strings"
+)
+
+const (
+	// zipAESMethod is the compression method recorded for WinZip AES
+	// entries; the real method is in the AES extra field.
+	zipAESMethod uint16 = 99
+
+	// zipAESExtraID is the WinZip AES extra field ID.
+	zipAESExtraID = 0x9901
+
+	// zipAESExtraLen is the size of the WinZip AES extra field data:
+	// version, vendor ID "AE", key strength and compression method.
+	zipAESExtraLen = 7
+
+	// zipAESVersion1 is AE-1, which keeps the CRC-32 of the entry; AE-2
+	// stores zero instead.
+	zipAESVersion1 = 1
+
+	// zipAESVerifierLen and zipAESMACLen are the sizes of the password
+	// verifier before the ciphertext and the authentication code after it.
+	zipAESVerifierLen = 2
+	zipAESMACLen      = 10
+
+	// zipAESIterations is the PBKDF2 iteration count fixed by WinZip.
+	zipAESIterations = 1000
+
+	// zipCryptoHeaderLen is the size of the traditional PKWARE encryption
+	// header that precedes the compressed data.
+	zipCryptoHeaderLen = 12
+
+	// zipFlagStrongEncryption is general purpose bit 6: PKWARE Strong
+	// Encryption, which this package does not implement.
+	zipFlagStrongEncryption = 0x40
+)
+
+var (
+	// errEncryptedEntries is returned for archives with encrypted entries
+	// when no passwords were supplied.
+	errEncryptedEntries = errors.New("encrypted entries need a password (use --password-file)")
+
+	// errWrongPassword is returned when none of the supplied passwords
+	// decrypts an encrypted entry.
+	errWrongPassword = errors.New("no supplied password decrypts")
+
+	// errAESAuthentication is returned when the HMAC of a WinZip AES entry
+	// does not match its data.
+	errAESAuthentication = errors.New("AES authentication code mismatch")
+)
+
+// zipCipher identifies how a zip entry is encrypted.
+type zipCipher int
+
+const (
+	cipherNone zipCipher = iota
+	cipherZipCrypto
+	cipherAES
+)
+
+// zipEncryption describes the encryption of one zip entry.
+type zipEncryption struct {
+	cipher zipCipher
+
+	// method is the compression method of the decrypted data.
+	method uint16
+
+	// keyLen is the AES key length in bytes, and version the AE-1 or AE-2
+	// format version.
+	keyLen  int
+	version uint16
+}
+
+// entryEncryption returns how f is encrypted. Entries that use PKWARE
+// Strong Encryption or a malformed AES extra field are rejected with an
+// error wrapping [zip.ErrAlgorithm].
+func entryEncryption(f *zip.File) (zipEncryption, error) {
+	if f.Flags&zipFlagEncrypted == 0 {
+		return zipEncryption{cipher: cipherNone, method: f.Method}, nil
+	}
+	if f.Flags&zipFlagStrongEncryption != 0 {
+		return zipEncryption{}, fmt.Errorf("entry %q uses PKWARE strong encryption: %w", f.Name, zip.ErrAlgorithm)
+	}
+	if f.Method != zipAESMethod {
+		return zipEncryption{cipher: cipherZipCrypto, method: f.Method}, nil
+	}
+
+	field, ok := zipExtraField(f.Extra, zipAESExtraID)
+	if !ok || len(field) < zipAESExtraLen || string(field[2:4]) != "AE" {
+		return zipEncryption{}, fmt.Errorf("entry %q has no valid AES extra field: %w", f.Name, zip.ErrAlgorithm)
+	}
+
+	enc := zipEncryption{
+		cipher:  cipherAES,
+		version: binary.LittleEndian.Uint16(field[0:]),
+		method:  binary.LittleEndian.Uint16(field[5:]),
+	}
+	switch field[4] {
+	case 1:
+		enc.keyLen = 16
+	case 2:
+		enc.keyLen = 24
+	case 3:
+		enc.keyLen = 32
+	default:
+		return zipEncryption{}, fmt.Errorf("entry %q has unknown AES key strength %d: %w", f.Name, field[4], zip.ErrAlgorithm)
+	}
+
+	return enc, nil
+}
+
+// entryMethod returns the compression method of f's data once decrypted.
+func entryMethod(f *zip.File) uint16 {
+	if enc, err := entryEncryption(f); err == nil {
+		return enc.method
+	}
+
+	return f.Method
+}
+
+// unlock finds, for every encrypted entry, the first supplied password
+// that decrypts it and verifies, and remembers it for [archiveReader.open].
+// The result is computed once per reader.
+func (r *archiveReader) unlock() error {
+	if r.unlocked {
+		return r.unlockErr
+	}
+	r.unlocked = true
+	r.unlockErr = r.findPasswords()
+
+	return r.unlockErr
+}
+
+func (r *archiveReader) findPasswords() error {
+	var (
+		encrypted []string
+		locked    []string
+	)
+	for _, f := range r.files {
+		enc, err := entryEncryption(f)
+		if err != nil {
+			return err
+		}
+		if enc.cipher == cipherNone {
+			continue
+		}
+
+		encrypted = append(encrypted, f.Name)
+		if len(r.passwords) == 0 {
+			continue
+		}
+
+		password, ok := r.findPassword(f, enc)
+		if !ok {
+			locked = append(locked, f.Name)
+			continue
+		}
+		if r.entryPasswords == nil {
+			r.entryPasswords = make(map[*zip.File]string)
+		}
+		r.entryPasswords[f] = password
+	}
+
+	switch {
+	case len(encrypted) > 0 && len(r.passwords) == 0:
+		return fmt.Errorf("%w: %s", errEncryptedEntries, strings.Join(encrypted, ", "))
+	case len(locked) > 0:
+		return fmt.Errorf("%w: %s", errWrongPassword, strings.Join(locked, ", "))
+	}
+
+	return nil
+}
+
+// findPassword returns the first supplied password that decrypts f,
+// trying the password that worked for the previous entry first.
+func (r *archiveReader) findPassword(f *zip.File, enc zipEncryption) (string, bool) {
+	candidates := r.passwords
+	if r.lastPassword != "" {
+		candidates = append([]string{r.lastPassword}, r.passwords...)
+	}
+
+	for _, password := range candidates {
+		if verifyEntryPassword(f, enc, password) {
+			r.lastPassword = password
+			return password, true
+		}
+	}
+
+	return "", false
+}
+
+// verifyEntryPassword reports whether password decrypts f, by reading the
+// whole entry and checking its CRC-32 or HMAC.
+func verifyEntryPassword(f *zip.File, enc zipEncryption, password string) bool {
+	rc, err := openEncryptedEntry(f, enc, password)
+	if err != nil {
+		return false
+	}
+	defer func() {
+		_ = rc.Close()
+	}()
+
+	_, err = io.Copy(io.Discard, rc)
+	return err == nil
+}
+
+// open returns the decompressed body of f, decrypting it with the password
+// found by [archiveReader.unlock].
+func (r *archiveReader) open(f *zip.File) (io.ReadCloser, error) {
+	enc, err := entryEncryption(f)
+	if err != nil {
+		return nil, err
+	}
+	if enc.cipher == cipherNone {
+		return f.Open()
+	}
+
+	if unlockErr := r.unlock(); unlockErr != nil {
+		return nil, unlockErr
+	}
+
+	return openEncryptedEntry(f, enc, r.entryPasswords[f])
+}
+
+// openEncryptedEntry returns the decrypted and decompressed body of f. The
+// reader fails at the end of the data when the CRC-32 or, for AES, the
+// authentication code does not match.
+func openEncryptedEntry(f *zip.File, enc zipEncryption, password string) (io.ReadCloser, error) {
+	raw, err := f.OpenRaw()
+	if err != nil {
+		return nil, err
+	}
+
+	var plain io.Reader
+	switch enc.cipher {
+	case cipherZipCrypto:
+		plain, err = newZipCryptoReader(raw, f, password)
+	default:
+		plain, err = newAESReader(raw, int64(f.CompressedSize64), enc.keyLen, password)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	dec, err := zipDecompressor(enc.method, plain)
+	if err != nil {
+		return nil, err
+	}
+
+	if enc.cipher == cipherAES {
+		dec = &drainingReader{rc: dec, src: plain}
+
+		// AE-2 stores no CRC-32; its HMAC already authenticates the data.
+		if enc.version != zipAESVersion1 {
+			return dec, nil
+		}
+	}
+
+	return &checksumReader{rc: dec, hash: crc32.NewIEEE(), want: f.CRC32, size: f.UncompressedSize64}, nil
+}
+
+// zipCryptoKeys holds the three keys of traditional PKWARE encryption.
+type zipCryptoKeys [3]uint32
+
+func newZipCryptoKeys(password string) *zipCryptoKeys {
+	keys := &zipCryptoKeys{0x12345678, 0x23456789, 0x34567890}
+	for i := 0; i < len(password); i++ {
+		keys.update(password[i])
+	}
+
+	return keys
+}
+
+func (k *zipCryptoKeys) update(b byte) {
+	k[0] = crc32Update(k[0], b)
+	k[1] = (k[1]+k[0]&0xff)*134775813 + 1
+	k[2] = crc32Update(k[2], byte(k[1]>>24))
+}
+
+func (k *zipCryptoKeys) decrypt(b byte) byte {
+	temp := k[2] | 2
+	plain := b ^ byte((temp*(temp^1))>>8)
+	k.update(plain)
+
+	return plain
+}
+
+// crc32Update is the single-byte CRC-32 step used by the key schedule.
+func crc32Update(crc uint32, b byte) uint32 {
+	return crc32.IEEETable[byte(crc)^b] ^ crc>>8
+}
+
+// zipCryptoReader decrypts traditional PKWARE encrypted data.
+type zipCryptoReader struct {
+	r    io.Reader
+	keys *zipCryptoKeys
+}
+
+// newZipCryptoReader consumes and checks the encryption header of f. The
+// last header byte must match the high byte of the CRC-32, or of the MS-DOS
+// time for entries written with a data descriptor; a mismatch means a wrong
+// password.
+func newZipCryptoReader(raw io.Reader, f *zip.File, password string) (*zipCryptoReader, error) {
+	keys := newZipCryptoKeys(password)
+
+	var header [zipCryptoHeaderLen]byte
+	if _, err := io.ReadFull(raw, header[:]); err != nil {
+		return nil, err
+	}
+	for i := range header {
+		header[i] = keys.decrypt(header[i])
+	}
+
+	check := byte(f.CRC32 >> 24)
+	if f.Flags&zipFlagDataDescriptor != 0 {
+		check = byte(f.ModifiedTime >> 8) //nolint:staticcheck // the MS-DOS time is what the header is checked against
+	}
+	if header[zipCryptoHeaderLen-1] != check {
+		return nil, errWrongPassword
+	}
+
+	return &zipCryptoReader{r: raw, keys: keys}, nil
+}
+
+func (z *zipCryptoReader) Read(p []byte) (int, error) {
+	n, err := z.r.Read(p)
+	for i := range p[:n] {
+		p[i] = z.keys.decrypt(p[i])
+	}
+
+	return n, err
+}
+
+// aesReader decrypts WinZip AES data and checks its authentication code
+// once the ciphertext has been read.
+type aesReader struct {
+	r      io.Reader
+	raw    io.Reader
+	mac    hash.Hash
+	stream cipher.Stream
+	done   bool
+}
+
+// newAESReader reads the salt and password verifier from raw, which holds
+// size bytes, and derives the keys. A verifier mismatch means a wrong
+// password.
+func newAESReader(raw io.Reader, size int64, keyLen int, password string) (*aesReader, error) {
+	saltLen := keyLen / 2
+	dataLen := size - int64(saltLen) - zipAESVerifierLen - zipAESMACLen
+	if dataLen < 0 {
+		return nil, fmt.Errorf("AES entry too short: %w", zip.ErrFormat)
+	}
+
+	prefix := make([]byte, saltLen+zipAESVerifierLen)
+	if _, err := io.ReadFull(raw, prefix); err != nil {
+		return nil, err
+	}
+	salt, verifier := prefix[:saltLen], prefix[saltLen:]
+
+	keys, err := pbkdf2.Key(sha1.New, password, salt, zipAESIterations, 2*keyLen+zipAESVerifierLen)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare(keys[2*keyLen:], verifier) != 1 {
+		return nil, errWrongPassword
+	}
+
+	block, err := aes.NewCipher(keys[:keyLen])
+	if err != nil {
+		return nil, err
+	}
+
+	return &aesReader{
+		r:      io.LimitReader(raw, dataLen),
+		raw:    raw,
+		mac:    hmac.New(sha1.New, keys[keyLen:2*keyLen]),
+		stream: &winZipCTR{block: block},
+	}, nil
+}
+
+func (a *aesReader) Read(p []byte) (int, error) {
+	n, err := a.r.Read(p)
+	a.mac.Write(p[:n])
+	a.stream.XORKeyStream(p[:n], p[:n])
+
+	if errors.Is(err, io.EOF) && !a.done {
+		a.done = true
+		var code [zipAESMACLen]byte
+		if _, readErr := io.ReadFull(a.raw, code[:]); readErr != nil {
+			return n, readErr
+		}
+		if !hmac.Equal(a.mac.Sum(nil)[:zipAESMACLen], code[:]) {
+			return n, errAESAuthentication
+		}
+	}
+
+	return n, err
+}
+
+// drainingReader reads the rest of src once rc, a decompressor reading
+// from src, reports the end of its stream. A decompressor stops at its own
+// end marker and may leave the last ciphertext bytes unread, so without the
+// drain [aesReader] would never reach the authentication code.
+type drainingReader struct {
+	rc      io.ReadCloser
+	src     io.Reader
+	drained bool
+}
+
+func (d *drainingReader) Read(p []byte) (int, error) {
+	n, err := d.rc.Read(p)
+	if errors.Is(err, io.EOF) && !d.drained {
+		d.drained = true
+		if _, drainErr := io.Copy(io.Discard, d.src); drainErr != nil {
+			return n, drainErr
+		}
+	}
+
+	return n, err
+}
+
+func (d *drainingReader) Close() error {
+	return d.rc.Close()
+}
+
+// winZipCTR is AES in counter mode as WinZip uses it: the counter is a
+// 128-bit little-endian integer starting at 1, which crypto/cipher's
+// big-endian CTR cannot express.
+type winZipCTR struct {
+	block   cipher.Block
+	counter [aes.BlockSize]byte
+	stream  [aes.BlockSize]byte
+	used    int
+}
+
+func (c *winZipCTR) XORKeyStream(dst, src []byte) {
+	for i := range src {
+		if c.used == 0 || c.used == aes.BlockSize {
+			for j := range c.counter {
+				c.counter[j]++
+				if c.counter[j] != 0 {
+					break
+				}
+			}
+			c.block.Encrypt(c.stream[:], c.counter[:])
+			c.used = 0
+		}
+		dst[i] = src[i] ^ c.stream[c.used]
+		c.used++
+	}
+}
+
+// checksumReader fails at the end of the data when its CRC-32 or size
+// differs from the values recorded in the archive.
+type checksumReader struct {
+	rc   io.ReadCloser
+	hash hash.Hash32
+	want uint32
+	size uint64
+	read uint64
+}
+
+func (c *checksumReader) Read(p []byte) (int, error) {
+	n, err := c.rc.Read(p)
+	c.hash.Write(p[:n])
+	c.read += uint64(n)
+
+	if errors.Is(err, io.EOF) && (c.hash.Sum32() != c.want || c.read != c.size) {
+		return n, zip.ErrChecksum
+	}
+
+	return n, err
+}
+
+func (c *checksumReader) Close() error {
+	return c.rc.Close()
+}
@@ -0,0 +1,299 @@
+package unzipper
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha1" //nolint:gosec // WinZip AES fixes HMAC-SHA1 and PBKDF2-SHA1
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// encryptedMember is one entry of an encrypted zip fixture. An empty
+// password stores the entry unencrypted; keyLen selects WinZip AES with
+// that key length in bytes, and zero traditional PKWARE encryption.
+type encryptedMember struct {
+	name     string
+	body     string
+	password string
+	keyLen   int
+	version  uint16
+	method   uint16
+}
+
+// writeEncryptedZip writes members to zipPath, encrypting each with its own
+// password.
+func writeEncryptedZip(t *testing.T, zipPath string, members []encryptedMember) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, m := range members {
+		hdr := &zip.FileHeader{
+			Name:               m.name,
+			Method:             m.method,
+			CRC32:              crc32.ChecksumIEEE([]byte(m.body)),
+			UncompressedSize64: uint64(len(m.body)),
+		}
+		hdr.SetMode(0o644)
+
+		data := compressFixture(t, m.method, []byte(m.body))
+		switch {
+		case m.password == "":
+		case m.keyLen == 0:
+			hdr.Flags |= zipFlagEncrypted
+			data = zipCryptoEncrypt(t, data, m.password, byte(hdr.CRC32>>24))
+		default:
+			hdr.Flags |= zipFlagEncrypted
+			hdr.Method = zipAESMethod
+			hdr.Extra = aesExtraField(m.keyLen, m.version, m.method)
+			if m.version != zipAESVersion1 {
+				hdr.CRC32 = 0
+			}
+			data = aesEncrypt(t, data, m.password, m.keyLen)
+		}
+		hdr.CompressedSize64 = uint64(len(data))
+
+		w, err := zw.CreateRaw(hdr)
+		require.NoError(t, err)
+		_, err = w.Write(data)
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	require.NoError(t, os.WriteFile(zipPath, buf.Bytes(), 0o644))
+}
+
+func compressFixture(t *testing.T, method uint16, body []byte) []byte {
+	t.Helper()
+
+	if method == zip.Store {
+		return body
+	}
+
+	var buf bytes.Buffer
+	fw, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	require.NoError(t, err)
+	_, err = fw.Write(body)
+	require.NoError(t, err)
+	require.NoError(t, fw.Close())
+	return buf.Bytes()
+}
+
+func zipCryptoEncrypt(t *testing.T, data []byte, password string, check byte) []byte {
+	t.Helper()
+
+	header := make([]byte, zipCryptoHeaderLen)
+	_, err := rand.Read(header[:zipCryptoHeaderLen-1])
+	require.NoError(t, err)
+	header[zipCryptoHeaderLen-1] = check
+
+	keys := newZipCryptoKeys(password)
+	out := make([]byte, 0, len(header)+len(data))
+	for _, b := range append(header, data...) {
+		temp := keys[2] | 2
+		out = append(out, b^byte((temp*(temp^1))>>8))
+		keys.update(b)
+	}
+
+	return out
+}
+
+func aesExtraField(keyLen int, version, method uint16) []byte {
+	extra := binary.LittleEndian.AppendUint16(nil, zipAESExtraID)
+	extra = binary.LittleEndian.AppendUint16(extra, zipAESExtraLen)
+	extra = binary.LittleEndian.AppendUint16(extra, version)
+	extra = append(extra, 'A', 'E', byte(keyLen/8-1))
+	return binary.LittleEndian.AppendUint16(extra, method)
+}
+
+func aesEncrypt(t *testing.T, data []byte, password string, keyLen int) []byte {
+	t.Helper()
+
+	salt := make([]byte, keyLen/2)
+	_, err := rand.Read(salt)
+	require.NoError(t, err)
+
+	keys, err := pbkdf2.Key(sha1.New, password, salt, zipAESIterations, 2*keyLen+zipAESVerifierLen)
+	require.NoError(t, err)
+	block, err := aes.NewCipher(keys[:keyLen])
+	require.NoError(t, err)
+
+	ciphertext := make([]byte, len(data))
+	(&winZipCTR{block: block}).XORKeyStream(ciphertext, data)
+	mac := hmac.New(sha1.New, keys[keyLen:2*keyLen])
+	mac.Write(ciphertext)
+
+	out := append(append([]byte{}, salt...), keys[2*keyLen:]...)
+	out = append(out, ciphertext...)
+	return append(out, mac.Sum(nil)[:zipAESMACLen]...)
+}
+
+func TestWinZipCTR(t *testing.T) {
+	block, err := aes.NewCipher(make([]byte, 16))
+	require.NoError(t, err)
+
+	// The keystream is AES of the counters 1, 2, ... as little-endian
+	// 128-bit integers, carried across calls.
+	want := make([]byte, 0, 2*aes.BlockSize)
+	for i := byte(1); i <= 2; i++ {
+		var counter, stream [aes.BlockSize]byte
+		counter[0] = i
+		block.Encrypt(stream[:], counter[:])
+		want = append(want, stream[:]...)
+	}
+
+	ctr := &winZipCTR{block: block}
+	got := make([]byte, 2*aes.BlockSize)
+	ctr.XORKeyStream(got[:5], got[:5])
+	ctr.XORKeyStream(got[5:], got[5:])
+	assert.Equal(t, want, got)
+}
+
+func TestExtractEncryptedArchives(t *testing.T) {
+	body := strings.Repeat("confidential ", 100)
+
+	tests := []struct {
+		name   string
+		member encryptedMember
+	}{
+		{name: "zipcrypto stored", member: encryptedMember{method: zip.Store}},
+		{name: "zipcrypto deflated", member: encryptedMember{method: zip.Deflate}},
+		{name: "aes-128 AE-1", member: encryptedMember{method: zip.Deflate, keyLen: 16, version: zipAESVersion1}},
+		{name: "aes-192 AE-2", member: encryptedMember{method: zip.Store, keyLen: 24, version: 2}},
+		{name: "aes-256 AE-2", member: encryptedMember{method: zip.Deflate, keyLen: 32, version: 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secret := tt.member
+			secret.name, secret.body, secret.password = "secret/report.txt", body, "correct horse"
+
+			root := t.TempDir()
+			archivePath := filepath.Join(root, "locked.zip")
+			writeEncryptedZip(t, archivePath, []encryptedMember{
+				secret,
+				{name: "readme.txt", body: "plain", method: zip.Deflate},
+			})
+
+			result := runExtraction(t, root, false, WithPasswords([]string{"wrong", "correct horse"}))
+			assert.Equal(t, 1, result.ExtractedArchives)
+			assert.Zero(t, result.ErrorCount)
+			require.Len(t, result.Operations, 1)
+			assert.Equal(t, 2, result.Operations[0].ExtractedFiles)
+			assert.True(t, result.Operations[0].DeletedArchive)
+
+			content, err := os.ReadFile(filepath.Join(root, "secret", "report.txt"))
+			require.NoError(t, err)
+			assert.Equal(t, body, string(content))
+			assert.FileExists(t, filepath.Join(root, "readme.txt"))
+			assert.NoFileExists(t, archivePath)
+		})
+	}
+}
+
+func TestEncryptedArchivesAreSkipped(t *testing.T) {
+	members := []encryptedMember{
+		{name: "a.txt", body: "first", password: "one", method: zip.Deflate},
+		{name: "b.txt", body: "second", password: "two", keyLen: 32, version: 2, method: zip.Deflate},
+		{name: "plain.txt", body: "plain", method: zip.Store},
+	}
+
+	assertKept := func(t *testing.T, root string, result Result, reason string) {
+		t.Helper()
+
+		assert.Zero(t, result.ExtractedArchives)
+		assert.Zero(t, result.ErrorCount)
+		assert.Equal(t, 1, result.SkippedCount)
+		require.Len(t, result.Operations, 1)
+		assert.True(t, result.Operations[0].Skipped)
+		assert.Contains(t, result.Operations[0].SkipReason, reason)
+
+		assert.FileExists(t, filepath.Join(root, "locked.zip"))
+		for _, m := range members {
+			assert.NoFileExists(t, filepath.Join(root, m.name))
+		}
+	}
+
+	t.Run("without passwords", func(t *testing.T) {
+		for _, dryRun := range []bool{false, true} {
+			root := t.TempDir()
+			writeEncryptedZip(t, filepath.Join(root, "locked.zip"), members)
+
+			result := runExtraction(t, root, dryRun)
+			assertKept(t, root, result, errEncryptedEntries.Error()+": a.txt, b.txt")
+		}
+	})
+
+	t.Run("wrong password extracts nothing", func(t *testing.T) {
+		root := t.TempDir()
+		writeEncryptedZip(t, filepath.Join(root, "locked.zip"), members)
+
+		result := runExtraction(t, root, false, WithPasswords([]string{"one", "three"}))
+		assertKept(t, root, result, errWrongPassword.Error()+": b.txt")
+	})
+
+	t.Run("tampered AES data fails authentication", func(t *testing.T) {
+		root := t.TempDir()
+		archivePath := filepath.Join(root, "locked.zip")
+		writeEncryptedZip(t, archivePath, members[1:2])
+
+		data, err := os.ReadFile(archivePath)
+		require.NoError(t, err)
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		require.NoError(t, err)
+		offset, err := zr.File[0].DataOffset()
+		require.NoError(t, err)
+		// Flip a ciphertext byte past the salt and password verifier.
+		data[offset+16+zipAESVerifierLen] ^= 0xff
+		require.NoError(t, os.WriteFile(archivePath, data, 0o644))
+
+		result := runExtraction(t, root, false, WithPasswords([]string{"two"}))
+		assertKept(t, root, result, errWrongPassword.Error()+": b.txt")
+	})
+
+	t.Run("tampered AES authentication code is detected", func(t *testing.T) {
+		root := t.TempDir()
+		archivePath := filepath.Join(root, "locked.zip")
+		writeEncryptedZip(t, archivePath, members[1:2])
+
+		data, err := os.ReadFile(archivePath)
+		require.NoError(t, err)
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		require.NoError(t, err)
+		offset, err := zr.File[0].DataOffset()
+		require.NoError(t, err)
+		// The deflated body ends before the ciphertext does from the
+		// decompressor's point of view, so the code is only checked when
+		// the rest of the entry is drained.
+		data[offset+int64(zr.File[0].CompressedSize64)-1] ^= 0xff
+		require.NoError(t, os.WriteFile(archivePath, data, 0o644))
+
+		result := runExtraction(t, root, false, WithPasswords([]string{"two"}))
+		assertKept(t, root, result, errWrongPassword.Error()+": b.txt")
+	})
+
+	t.Run("each entry uses its own password", func(t *testing.T) {
+		root := t.TempDir()
+		writeEncryptedZip(t, filepath.Join(root, "locked.zip"), members)
+
+		result := runExtraction(t, root, false, WithPasswords([]string{"two", "one"}))
+		require.Len(t, result.Operations, 1)
+		assert.Equal(t, 3, result.Operations[0].ExtractedFiles)
+		for _, m := range members {
+			content, err := os.ReadFile(filepath.Join(root, m.name))
+			require.NoError(t, err)
+			assert.Equal(t, m.body, string(content))
+		}
+	})
+}
@@ -0,0 +1,78 @@
+package unzipper
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+)
+
+const (
+	// ownerFileBits are always kept on extracted files so btidy can still
+	// hash, move and overwrite them in later runs.
+	ownerFileBits fs.FileMode = 0o600
+
+	// ownerDirBits are always kept on extracted directories so their
+	// contents stay reachable and writable.
+	ownerDirBits fs.FileMode = 0o700
+)
+
+// dirMetadata is the archived mode and timestamp of a directory entry.
+// Directory times are applied after every entry is written, because
+// creating files inside a directory updates its modification time.
+type dirMetadata struct {
+	path    string
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+// applyFileMetadata sets the archived permission bits and modification time
+// on an extracted file. The owner always keeps read and write access. A zero
+// modTime (no timestamp in the archive) leaves the time untouched.
+func applyFileMetadata(path string, mode fs.FileMode, modTime time.Time) error {
+	if err := os.Chmod(path, mode.Perm()|ownerFileBits); err != nil {
+		return fmt.Errorf("set permissions: %w", err)
+	}
+
+	if modTime.IsZero() {
+		return nil
+	}
+
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		return fmt.Errorf("set modification time: %w", err)
+	}
+
+	return nil
+}
+
+// applyDirMetadata sets the archived mode and modification time on every
+// directory entry that this extraction created, deepest first so that
+// updating a child does not disturb its parent's timestamp. Directories that
+// existed before the extraction are left as they were.
+func applyDirMetadata(op *ExtractOperation) error {
+	created := make(map[string]bool, len(op.CreatedDirs))
+	for _, dir := range op.CreatedDirs {
+		created[dir] = true
+	}
+
+	for i := len(op.dirMetadata) - 1; i >= 0; i-- {
+		meta := op.dirMetadata[i]
+		if !created[meta.path] {
+			continue
+		}
+
+		if err := os.Chmod(meta.path, meta.mode.Perm()|ownerDirBits); err != nil {
+			return fmt.Errorf("set permissions on %s: %w", meta.path, err)
+		}
+
+		if meta.modTime.IsZero() {
+			continue
+		}
+
+		if err := os.Chtimes(meta.path, meta.modTime, meta.modTime); err != nil {
+			return fmt.Errorf("set modification time on %s: %w", meta.path, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,139 @@
+package unzipper
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"btidy/pkg/collector"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractPreservesEntryMetadata(t *testing.T) {
+	fileTime := time.Date(2013, time.June, 9, 14, 30, 17, 0, time.UTC)
+	dirTime := time.Date(2012, time.January, 2, 3, 4, 5, 0, time.UTC)
+
+	t.Run("zip entries keep modification time and permissions", func(t *testing.T) {
+		root := t.TempDir()
+		archivePath := filepath.Join(root, "old.zip")
+
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+
+		dirHeader := &zip.FileHeader{Name: "photos/", Modified: dirTime}
+		dirHeader.SetMode(os.ModeDir | 0o750)
+		_, err := zw.CreateHeader(dirHeader)
+		require.NoError(t, err)
+
+		// Odd seconds only survive through the extended-timestamp field;
+		// the MS-DOS time has two-second resolution.
+		fileHeader := &zip.FileHeader{Name: "photos/pic.jpg", Method: zip.Deflate, Modified: fileTime}
+		fileHeader.SetMode(0o640)
+		w, err := zw.CreateHeader(fileHeader)
+		require.NoError(t, err)
+		_, err = w.Write([]byte("jpeg"))
+		require.NoError(t, err)
+		require.NoError(t, zw.Close())
+		require.NoError(t, os.WriteFile(archivePath, buf.Bytes(), 0o644))
+
+		_, err = unzip(collector.FileInfo{Dir: root, Name: filepath.Base(archivePath), Path: archivePath})
+		require.NoError(t, err)
+
+		info, err := os.Stat(filepath.Join(root, "photos", "pic.jpg"))
+		require.NoError(t, err)
+		assert.True(t, info.ModTime().Equal(fileTime), "file mtime %v", info.ModTime())
+		assert.Equal(t, os.FileMode(0o640), info.Mode().Perm())
+
+		info, err = os.Stat(filepath.Join(root, "photos"))
+		require.NoError(t, err)
+		assert.True(t, info.ModTime().Equal(dirTime), "dir mtime %v", info.ModTime())
+		assert.Equal(t, os.FileMode(0o750), info.Mode().Perm())
+	})
+
+	t.Run("tar entries keep modification time", func(t *testing.T) {
+		root := t.TempDir()
+		archivePath := filepath.Join(root, "old.tar")
+
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		require.NoError(t, tw.WriteHeader(&tar.Header{
+			Name: "docs/", Typeflag: tar.TypeDir, Mode: 0o755, ModTime: dirTime,
+		}))
+		require.NoError(t, tw.WriteHeader(&tar.Header{
+			Name: "docs/readme.txt", Typeflag: tar.TypeReg, Mode: 0o600, Size: 2, ModTime: fileTime,
+		}))
+		_, err := tw.Write([]byte("hi"))
+		require.NoError(t, err)
+		require.NoError(t, tw.Close())
+		require.NoError(t, os.WriteFile(archivePath, buf.Bytes(), 0o644))
+
+		_, err = unzip(collector.FileInfo{Dir: root, Name: filepath.Base(archivePath), Path: archivePath})
+		require.NoError(t, err)
+
+		info, err := os.Stat(filepath.Join(root, "docs", "readme.txt"))
+		require.NoError(t, err)
+		assert.True(t, info.ModTime().Equal(fileTime), "file mtime %v", info.ModTime())
+		assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+		info, err = os.Stat(filepath.Join(root, "docs"))
+		require.NoError(t, err)
+		assert.True(t, info.ModTime().Equal(dirTime), "dir mtime %v", info.ModTime())
+	})
+
+	t.Run("existing directories are left untouched", func(t *testing.T) {
+		root := t.TempDir()
+		existing := filepath.Join(root, "docs")
+		require.NoError(t, os.MkdirAll(existing, 0o755))
+		before, err := os.Stat(existing)
+		require.NoError(t, err)
+
+		archivePath := filepath.Join(root, "docs.tar")
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		require.NoError(t, tw.WriteHeader(&tar.Header{
+			Name: "docs/", Typeflag: tar.TypeDir, Mode: 0o700, ModTime: dirTime,
+		}))
+		require.NoError(t, tw.Close())
+		require.NoError(t, os.WriteFile(archivePath, buf.Bytes(), 0o644))
+
+		_, err = unzip(collector.FileInfo{Dir: root, Name: filepath.Base(archivePath), Path: archivePath})
+		require.NoError(t, err)
+
+		after, err := os.Stat(existing)
+		require.NoError(t, err)
+		assert.Equal(t, before.Mode().Perm(), after.Mode().Perm())
+		assert.False(t, after.ModTime().Equal(dirTime))
+	})
+
+	t.Run("owner keeps write access to read-only entries", func(t *testing.T) {
+		root := t.TempDir()
+		archivePath := filepath.Join(root, "ro.tar")
+		require.NoError(t, os.WriteFile(archivePath, buildTarWithMode(t, "ro.txt", 0o444), 0o644))
+
+		_, err := unzip(collector.FileInfo{Dir: root, Name: filepath.Base(archivePath), Path: archivePath})
+		require.NoError(t, err)
+
+		info, err := os.Stat(filepath.Join(root, "ro.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o644), info.Mode().Perm())
+	})
+}
+
+func buildTarWithMode(t *testing.T, name string, mode int64) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: mode, Size: 1}))
+	_, err := tw.Write([]byte("x"))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+
+	return buf.Bytes()
+}
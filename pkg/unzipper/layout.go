@@ -0,0 +1,135 @@
+package unzipper
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"btidy/pkg/collector"
+)
+
+// Layout selects the directory an archive is extracted into.
+type Layout string
+
+const (
+	// LayoutParent extracts entries next to the archive, merging them with
+	// whatever already lives in the archive's directory.
+	LayoutParent Layout = "parent"
+
+	// LayoutArchiveName extracts entries into a sibling directory named
+	// after the archive ("photos-2019.zip" -> "photos-2019/"). An existing
+	// directory of that name is merged into.
+	LayoutArchiveName Layout = "archive-name"
+
+	// LayoutFresh is like LayoutArchiveName but never reuses an existing
+	// path: "photos-2019/" is taken, "photos-2019-1/" is tried, and so on.
+	LayoutFresh Layout = "fresh"
+)
+
+// maxFreshDirAttempts bounds the numeric suffixes tried by [LayoutFresh].
+const maxFreshDirAttempts = 10000
+
+// archiveNameSuffixes are stripped from an archive file name to derive its
+// directory name. Compound suffixes come first so "a.tar.gz" becomes "a",
+// not "a.tar".
+var archiveNameSuffixes = []string{
+	".tar.gz", ".tar.bz2", ".tar.xz", ".tar.zst",
+	".tgz", ".tbz2", ".tbz", ".txz", ".tzst",
+	".zip", ".7z", ".tar",
+}
+
+// errDestinationNotDir is returned when the directory an archive should be
+// extracted into already exists as a file.
+var errDestinationNotDir = errors.New("destination exists and is not a directory")
+
+// ParseLayout converts a command-line value into a [Layout].
+func ParseLayout(value string) (Layout, error) {
+	switch layout := Layout(value); layout {
+	case LayoutParent, LayoutArchiveName, LayoutFresh:
+		return layout, nil
+	default:
+		return "", fmt.Errorf("invalid extraction layout %q (want %s, %s or %s)",
+			value, LayoutParent, LayoutArchiveName, LayoutFresh)
+	}
+}
+
+// archiveDirName returns the directory name used for an archive by the
+// [LayoutArchiveName] and [LayoutFresh] layouts. When stripping the suffix
+// leaves nothing or nothing changes (an archive detected by content without
+// an extension), "_extracted" is appended so the directory cannot collide
+// with the archive file itself.
+func archiveDirName(archiveName string) string {
+	lower := strings.ToLower(archiveName)
+	stem := archiveName
+	for _, suffix := range archiveNameSuffixes {
+		if strings.HasSuffix(lower, suffix) {
+			stem = archiveName[:len(archiveName)-len(suffix)]
+			break
+		}
+	}
+	if stem == archiveName {
+		stem = strings.TrimSuffix(archiveName, filepath.Ext(archiveName))
+	}
+
+	if stem == "" || stem == archiveName {
+		return archiveName + "_extracted"
+	}
+
+	return stem
+}
+
+// destinationDir returns the directory archive is extracted into under the
+// configured layout. The directory is validated for writing but not
+// created; extraction creates it so it is journaled as a created directory.
+func (u *Unzipper) destinationDir(archive collector.FileInfo, archivePath string) (string, error) {
+	if u.layout == LayoutParent {
+		return archive.Dir, nil
+	}
+
+	base := filepath.Join(archive.Dir, archiveDirName(archive.Name))
+
+	var dir string
+	switch u.layout {
+	case LayoutArchiveName:
+		info, err := os.Lstat(base)
+		if err == nil && !info.IsDir() {
+			return "", fmt.Errorf("%w: %s", errDestinationNotDir, base)
+		}
+		dir = base
+	case LayoutFresh:
+		fresh, err := freshDir(base)
+		if err != nil {
+			return "", fmt.Errorf("choose destination for %s: %w", archivePath, err)
+		}
+		dir = fresh
+	default:
+		return "", fmt.Errorf("unsupported extraction layout %q", u.layout)
+	}
+
+	if err := u.validator.ValidatePathForWrite(dir); err != nil {
+		return "", fmt.Errorf("illegal destination %s: %w", dir, err)
+	}
+
+	return dir, nil
+}
+
+// freshDir returns base, or base with the first numeric suffix ("-1",
+// "-2", ...) for which nothing exists on disk yet.
+func freshDir(base string) (string, error) {
+	candidate := base
+	for i := 1; i <= maxFreshDirAttempts; i++ {
+		_, err := os.Lstat(candidate)
+		if errors.Is(err, os.ErrNotExist) {
+			return candidate, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		candidate = base + "-" + strconv.Itoa(i)
+	}
+
+	return "", fmt.Errorf("no free directory name after %d attempts: %s", maxFreshDirAttempts, base)
+}
@@ -0,0 +1,173 @@
+package unzipper
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"btidy/pkg/safepath"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLayout(t *testing.T) {
+	for _, value := range []string{"parent", "archive-name", "fresh"} {
+		layout, err := ParseLayout(value)
+		require.NoError(t, err)
+		assert.Equal(t, Layout(value), layout)
+	}
+
+	_, err := ParseLayout("sideways")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid extraction layout")
+}
+
+func TestArchiveDirName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "photos-2019.zip", want: "photos-2019"},
+		{name: "PHOTOS.ZIP", want: "PHOTOS"},
+		{name: "server.tar.gz", want: "server"},
+		{name: "server.tgz", want: "server"},
+		{name: "logs.tar.zst", want: "logs"},
+		{name: "data.tar", want: "data"},
+		{name: "release-1.2.zip", want: "release-1.2"},
+		{name: "odd.bin", want: "odd"},
+		{name: "noextension", want: "noextension_extracted"},
+		{name: ".zip", want: ".zip_extracted"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, archiveDirName(tc.name))
+		})
+	}
+}
+
+func TestExtractWithLayout(t *testing.T) {
+	run := func(t *testing.T, root string, layout Layout, dryRun bool) Result {
+		t.Helper()
+
+		v, err := safepath.New(root)
+		require.NoError(t, err)
+		uz, err := NewWithValidator(v, dryRun, nil, WithLayout(layout))
+		require.NoError(t, err)
+
+		files, err := getAllFilesRecursively(t.Context(), root)
+		require.NoError(t, err)
+
+		result, err := uz.ExtractArchivesWithProgressRecursively(t.Context(), files, nil)
+		require.NoError(t, err)
+		return result
+	}
+
+	t.Run("archive-name extracts into sibling directory", func(t *testing.T) {
+		root := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(root, "readme.txt"), []byte("existing"), 0o644))
+		writeZipWithEntries(t, filepath.Join(root, "photos-2019.zip"), map[string][]byte{
+			"readme.txt":    []byte("from archive"),
+			"album/pic.jpg": []byte("jpeg"),
+		})
+
+		result := run(t, root, LayoutArchiveName, false)
+		require.Len(t, result.Operations, 1)
+
+		op := result.Operations[0]
+		assert.Equal(t, filepath.Join(root, "photos-2019"), op.DestDir)
+		assert.Empty(t, op.ReplacedFiles, "no collision with files next to the archive")
+		assert.Equal(t, filepath.Join(root, "photos-2019"), op.CreatedDirs[0])
+
+		content, err := os.ReadFile(filepath.Join(root, "readme.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "existing", string(content))
+
+		content, err = os.ReadFile(filepath.Join(root, "photos-2019", "readme.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "from archive", string(content))
+		assert.FileExists(t, filepath.Join(root, "photos-2019", "album", "pic.jpg"))
+	})
+
+	t.Run("archive-name merges into existing directory", func(t *testing.T) {
+		root := t.TempDir()
+		require.NoError(t, os.MkdirAll(filepath.Join(root, "docs"), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(root, "docs", "old.txt"), []byte("old"), 0o644))
+		writeZipWithEntries(t, filepath.Join(root, "docs.zip"), map[string][]byte{"new.txt": []byte("new")})
+
+		result := run(t, root, LayoutArchiveName, false)
+		require.Len(t, result.Operations, 1)
+		assert.Empty(t, result.Operations[0].CreatedDirs)
+
+		assert.FileExists(t, filepath.Join(root, "docs", "old.txt"))
+		assert.FileExists(t, filepath.Join(root, "docs", "new.txt"))
+	})
+
+	t.Run("archive-name refuses a file in the way", func(t *testing.T) {
+		root := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(root, "docs"), []byte("a file"), 0o644))
+		writeZipWithEntries(t, filepath.Join(root, "docs.zip"), map[string][]byte{"new.txt": []byte("new")})
+
+		v, err := safepath.New(root)
+		require.NoError(t, err)
+		uz, err := NewWithValidator(v, false, nil, WithLayout(LayoutArchiveName))
+		require.NoError(t, err)
+		files, err := getAllFilesRecursively(t.Context(), root)
+		require.NoError(t, err)
+
+		_, err = uz.ExtractArchivesWithProgressRecursively(t.Context(), files, nil)
+		require.ErrorIs(t, err, errDestinationNotDir)
+		assert.FileExists(t, filepath.Join(root, "docs.zip"))
+	})
+
+	t.Run("fresh picks a non-conflicting name", func(t *testing.T) {
+		root := t.TempDir()
+		require.NoError(t, os.MkdirAll(filepath.Join(root, "docs"), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(root, "docs-1"), []byte("file"), 0o644))
+		writeZipWithEntries(t, filepath.Join(root, "docs.zip"), map[string][]byte{"new.txt": []byte("new")})
+
+		result := run(t, root, LayoutFresh, false)
+		require.Len(t, result.Operations, 1)
+		assert.Equal(t, filepath.Join(root, "docs-2"), result.Operations[0].DestDir)
+		assert.FileExists(t, filepath.Join(root, "docs-2", "new.txt"))
+		assert.NoFileExists(t, filepath.Join(root, "docs", "new.txt"))
+	})
+
+	t.Run("nested archives extract into their own directories", func(t *testing.T) {
+		root := t.TempDir()
+		inner := gzipBytes(t, buildTar(t, []tarMember{
+			{name: "deep.txt", typeflag: tar.TypeReg, body: "deep"},
+		}))
+		writeZipWithEntries(t, filepath.Join(root, "outer.zip"), map[string][]byte{
+			"inner.tar.gz": inner,
+			"top.txt":      []byte("top"),
+		})
+
+		result := run(t, root, LayoutArchiveName, false)
+		assert.Equal(t, 2, result.ExtractedArchives)
+
+		assert.FileExists(t, filepath.Join(root, "outer", "top.txt"))
+		assert.FileExists(t, filepath.Join(root, "outer", "inner", "deep.txt"))
+		assert.NoFileExists(t, filepath.Join(root, "outer", "inner.tar.gz"))
+	})
+
+	t.Run("dry run creates nothing", func(t *testing.T) {
+		root := t.TempDir()
+		writeZipWithEntries(t, filepath.Join(root, "docs.zip"), map[string][]byte{"new.txt": []byte("new")})
+
+		result := run(t, root, LayoutFresh, true)
+		require.Len(t, result.Operations, 1)
+		assert.Equal(t, filepath.Join(root, "docs"), result.Operations[0].DestDir)
+		assert.NoDirExists(t, filepath.Join(root, "docs"))
+	})
+}
+
+func TestNewWithValidatorRejectsUnknownLayout(t *testing.T) {
+	v, err := safepath.New(t.TempDir())
+	require.NoError(t, err)
+
+	_, err = NewWithValidator(v, false, nil, WithLayout("sideways"))
+	require.Error(t, err)
+}
@@ -0,0 +1,424 @@
+package unzipper
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+
+	"btidy/pkg/collector"
+)
+
+const (
+	// DefaultMaxDepth is the default archive nesting depth limit. Real
+	// backups rarely nest archives more than two or three levels deep,
+	// while recursive bombs such as 42.zip rely on many levels.
+	DefaultMaxDepth = 8
+
+	// DefaultMaxRatio is the default compression ratio limit. Ordinary
+	// data stays well below it; deflate tops out near 1032:1 on a single
+	// repeated byte, which is what bombs are made of.
+	DefaultMaxRatio = 200
+
+	// ratioCheckMinSize is the uncompressed size below which the ratio
+	// limit is not applied: a small file of zeros is harmless however well
+	// it compresses.
+	ratioCheckMinSize = 1 << 20
+)
+
+// errStopMeasuring ends an archive measurement early once its size is
+// already known to exceed the tightest byte limit.
+var errStopMeasuring = errors.New("measurement ceiling reached")
+
+// diskFreeSpace reports the bytes available to unprivileged users on the
+// filesystem holding dir. It is a variable so tests can simulate a full disk.
+var diskFreeSpace = freeSpace
+
+// Limits bounds what a run may extract, as a defence against decompression
+// bombs. Archives that would break a limit are skipped with a SkipReason and
+// left in place. A zero field disables that limit.
+type Limits struct {
+	// MaxDepth is the deepest archive nesting level that is extracted.
+	// Archives present when the run starts are level 1, archives found
+	// inside them level 2, and so on, whether a nested archive is
+	// extracted from disk or from memory.
+	MaxDepth int
+
+	// MaxTotalBytes caps the uncompressed bytes written by one run.
+	MaxTotalBytes int64
+
+	// MaxArchiveBytes caps the uncompressed bytes written for one archive.
+	MaxArchiveBytes int64
+
+	// MaxRatio caps the compression ratio (uncompressed / compressed) of
+	// each zip entry, or of a whole tar or standalone compressed file,
+	// that expands to at least 1 MiB.
+	MaxRatio float64
+
+	// CheckFreeSpace requires an archive's uncompressed size to fit in the
+	// free space of the filesystem it is extracted on.
+	CheckFreeSpace bool
+}
+
+// DefaultLimits returns the limits used when none are configured: nesting
+// depth, compression ratio and free disk space are checked, and the byte
+// budgets are left open.
+func DefaultLimits() Limits {
+	return Limits{
+		MaxDepth:       DefaultMaxDepth,
+		MaxRatio:       DefaultMaxRatio,
+		CheckFreeSpace: true,
+	}
+}
+
+// validate rejects negative limits.
+func (l Limits) validate() error {
+	if l.MaxDepth < 0 || l.MaxTotalBytes < 0 || l.MaxArchiveBytes < 0 || l.MaxRatio < 0 {
+		return errors.New("extraction limits must not be negative")
+	}
+
+	return nil
+}
+
+// archiveUsage is what extracting one archive would write.
+type archiveUsage struct {
+	// bytes is the uncompressed size. When partial is set it is only a
+	// lower bound.
+	bytes int64
+
+	// partial reports that measuring stopped at the byte ceiling.
+	partial bool
+
+	// ratio is the highest compression ratio seen, and ratioName the entry
+	// it belongs to ("" when the ratio is for the whole file).
+	ratio     float64
+	ratioName string
+}
+
+// noteRatio records the compression ratio of name when it expands to at
+// least [ratioCheckMinSize] bytes.
+func (a *archiveUsage) noteRatio(name string, size, compressed int64) {
+	if size < ratioCheckMinSize || compressed <= 0 {
+		return
+	}
+
+	if ratio := float64(size) / float64(compressed); ratio > a.ratio {
+		a.ratio = ratio
+		a.ratioName = name
+	}
+}
+
+// describeBytes renders the measured size for a skip reason.
+func (a archiveUsage) describeBytes() string {
+	if a.partial {
+		return fmt.Sprintf("at least %d bytes", a.bytes)
+	}
+
+	return fmt.Sprintf("%d bytes", a.bytes)
+}
+
+// checkLimits measures what processing archive would write and returns a
+// non-empty skip reason when that breaks one of the configured [Limits].
+// Nothing is written while measuring: zip sizes come from the central
+// directory, while tar archives and standalone compressed files are
+// decompressed and discarded.
+//
+// Archives of one batch may be checked concurrently, so the decision is
+// taken under the run state's lock and an archive that passes reserves its
+// size there; the caller releases it with [runState.finish]. Bytes reserved
+// by extractions still in flight are not yet visible as used disk space, so
+// they are subtracted from the free space reported by the filesystem.
+func (u *Unzipper) checkLimits(
+	archive collector.FileInfo,
+	archivePath string,
+	format compressionFormat,
+	single bool,
+	state *runState,
+) (archiveUsage, string, error) {
+	if reason := u.checkDepth(state.archiveDepth(archivePath)); reason != "" {
+		return archiveUsage{}, reason, nil
+	}
+
+	return u.reserveUsage(archive.Dir, state, func(ceiling int64) (archiveUsage, error) {
+		var (
+			usage archiveUsage
+			err   error
+		)
+		if single {
+			usage, err = measureCompressedFile(archivePath, format, ceiling)
+		} else {
+			usage, err = measureArchive(archivePath, u.config(), ceiling)
+		}
+		if err != nil {
+			return usage, fmt.Errorf("failed to measure %s: %w", archivePath, err)
+		}
+
+		return usage, nil
+	})
+}
+
+// checkNestedLimits is [Unzipper.checkLimits] for a nested archive held in
+// memory at nesting level depth, to be extracted on the filesystem holding
+// dir. It reports whether the archive can be read in full and fits the
+// limits; if so, its size is reserved like that of an archive on disk.
+func (u *Unzipper) checkNestedLimits(
+	data []byte,
+	cfg extractConfig,
+	dir string,
+	depth int,
+	state *runState,
+) (archiveUsage, bool) {
+	if u.checkDepth(depth) != "" {
+		return archiveUsage{}, false
+	}
+
+	usage, reason, err := u.reserveUsage(dir, state, func(ceiling int64) (archiveUsage, error) {
+		r, err := openArchiveData(data, cfg)
+		if err != nil {
+			return archiveUsage{}, err
+		}
+		defer func() {
+			_ = r.Close()
+		}()
+
+		if validateErr := r.validate(); validateErr != nil {
+			return archiveUsage{}, validateErr
+		}
+
+		return measureSource(r, cfg, ceiling, int64(len(data)))
+	})
+
+	return usage, err == nil && reason == ""
+}
+
+// checkDepth returns a skip reason when depth is deeper than
+// [Limits.MaxDepth] allows.
+func (u *Unzipper) checkDepth(depth int) string {
+	if limit := u.limits.MaxDepth; limit > 0 && depth > limit {
+		return fmt.Sprintf("nesting depth %d exceeds the limit of %d", depth, limit)
+	}
+
+	return ""
+}
+
+// reserveUsage measures an archive to be extracted on the filesystem
+// holding dir with measure, which stops at the ceiling it is given (-1 for
+// none), and checks the result against the byte, ratio and free space
+// limits. See [Unzipper.checkLimits].
+func (u *Unzipper) reserveUsage(
+	dir string,
+	state *runState,
+	measure func(ceiling int64) (archiveUsage, error),
+) (archiveUsage, string, error) {
+	limits := u.limits
+
+	state.mu.Lock()
+	usedBytes, inFlight := state.usedBytes, state.inFlight
+	state.mu.Unlock()
+
+	ceiling := int64(-1)
+	lower := func(limit int64) {
+		if limit >= 0 && (ceiling < 0 || limit < ceiling) {
+			ceiling = limit
+		}
+	}
+	if limits.MaxArchiveBytes > 0 {
+		lower(limits.MaxArchiveBytes)
+	}
+	if limits.MaxTotalBytes > 0 {
+		lower(max(limits.MaxTotalBytes-usedBytes, 0))
+	}
+	lower(u.freeBytes(dir, inFlight))
+
+	usage, err := measure(ceiling)
+	if err != nil {
+		return usage, "", err
+	}
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	usedBytes = state.usedBytes
+	free := u.freeBytes(dir, state.inFlight)
+
+	switch {
+	case limits.MaxArchiveBytes > 0 && usage.bytes > limits.MaxArchiveBytes:
+		return usage, fmt.Sprintf("expands to %s, above the per-archive limit of %d bytes",
+			usage.describeBytes(), limits.MaxArchiveBytes), nil
+	case limits.MaxRatio > 0 && usage.ratio > limits.MaxRatio && usage.ratioName != "":
+		return usage, fmt.Sprintf("entry %q has a compression ratio of %.0f:1, above the limit of %.0f:1",
+			usage.ratioName, usage.ratio, limits.MaxRatio), nil
+	case limits.MaxRatio > 0 && usage.ratio > limits.MaxRatio:
+		return usage, fmt.Sprintf("compression ratio of %.0f:1 is above the limit of %.0f:1",
+			usage.ratio, limits.MaxRatio), nil
+	case limits.MaxTotalBytes > 0 && usedBytes+usage.bytes > limits.MaxTotalBytes:
+		return usage, fmt.Sprintf("expands to %s, which would exceed the per-run limit of %d bytes (%d already extracted)",
+			usage.describeBytes(), limits.MaxTotalBytes, usedBytes), nil
+	case free >= 0 && usage.bytes > free:
+		return usage, fmt.Sprintf("expands to %s but only %d bytes are free", usage.describeBytes(), free), nil
+	}
+
+	state.usedBytes = addSize(state.usedBytes, usage.bytes)
+	state.inFlight += usage.bytes
+
+	return usage, "", nil
+}
+
+// freeBytes returns the free space on the filesystem holding dir minus the
+// bytes reserved, or -1 when free space is not checked or cannot be read.
+func (u *Unzipper) freeBytes(dir string, reserved int64) int64 {
+	if !u.limits.CheckFreeSpace {
+		return -1
+	}
+
+	n, err := diskFreeSpace(dir)
+	if err != nil {
+		slog.Debug("free space check unavailable", "path", dir, "error", err)
+		return -1
+	}
+
+	return max(n-reserved, 0)
+}
+
+// measureArchive sums the uncompressed size of every file an archive would
+// write, counting tar hard links, and symlinks under [SymlinksMaterialize],
+// as the copies they are extracted as. Zip
+// entries are rated individually; tar archives, which are compressed as one
+// stream, are rated as a whole against their size on disk. A ceiling of -1
+// measures the whole archive; otherwise measuring stops once the size
+// exceeds ceiling.
+func measureArchive(archivePath string, cfg extractConfig, ceiling int64) (archiveUsage, error) {
+	info, err := os.Stat(archivePath)
+	if err != nil {
+		return archiveUsage{}, err
+	}
+
+	r, err := openArchive(archivePath, cfg)
+	if err != nil {
+		return archiveUsage{}, err
+	}
+	defer func() {
+		_ = r.Close()
+	}()
+
+	return measureSource(r, cfg, ceiling, info.Size())
+}
+
+// measureSource is [measureArchive] for an opened archive r of
+// archiveSize bytes.
+func measureSource(r archiveSource, cfg extractConfig, ceiling, archiveSize int64) (archiveUsage, error) {
+	var usage archiveUsage
+	fileSizes := make(map[string]int64)
+	links := make(map[string]string)
+	perEntryRatio := false
+
+	walkErr := r.walk(func(entry archiveEntry) error {
+		if entry.damaged != nil {
+			return nil
+		}
+
+		size := entry.size
+		switch entry.kind {
+		case entryKindFile:
+			fileSizes[cleanMemberName(entry.name)] = size
+		case entryKindHardlink:
+			size = fileSizes[cleanMemberName(entry.linkTarget)]
+		case entryKindSymlink:
+			if cfg.symlinks == SymlinksMaterialize {
+				links[cleanMemberName(entry.name)] = linkSourceName(entry)
+			}
+			return nil
+		default:
+			return nil
+		}
+
+		usage.bytes = addSize(usage.bytes, size)
+		if entry.compressedSize > 0 {
+			perEntryRatio = true
+			usage.noteRatio(entry.name, size, entry.compressedSize)
+		}
+
+		if ceiling >= 0 && usage.bytes > ceiling {
+			usage.partial = true
+			return errStopMeasuring
+		}
+
+		return nil
+	})
+	if walkErr != nil && !errors.Is(walkErr, errStopMeasuring) {
+		return usage, walkErr
+	}
+
+	if !usage.partial {
+		usage.bytes = addSize(usage.bytes, measureLinkCopies(links, fileSizes))
+	}
+
+	if !perEntryRatio && !usage.partial {
+		usage.noteRatio("", usage.bytes, archiveSize)
+	}
+
+	return usage, nil
+}
+
+// measureCompressedFile decompresses a standalone compressed file into
+// [io.Discard] to learn its output size. See [measureArchive] for ceiling.
+func measureCompressedFile(filePath string, format compressionFormat, ceiling int64) (archiveUsage, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return archiveUsage{}, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	info, err := f.Stat()
+	if err != nil {
+		return archiveUsage{}, err
+	}
+
+	dec, err := newDecompressor(format, bufio.NewReader(f))
+	if err != nil {
+		return archiveUsage{}, err
+	}
+	defer func() {
+		_ = dec.Close()
+	}()
+
+	var src io.Reader = dec
+	if ceiling >= 0 {
+		src = io.LimitReader(dec, ceiling+1)
+	}
+
+	n, err := io.Copy(io.Discard, src)
+	if err != nil {
+		return archiveUsage{}, err
+	}
+
+	usage := archiveUsage{bytes: n, partial: ceiling >= 0 && n > ceiling}
+	usage.noteRatio("", n, info.Size())
+
+	return usage, nil
+}
+
+// addSize adds two sizes, saturating at [math.MaxInt64] so a forged archive
+// cannot wrap the total around to a small number.
+func addSize(a, b int64) int64 {
+	if b > math.MaxInt64-a {
+		return math.MaxInt64
+	}
+
+	return a + b
+}
+
+// clampSize converts a size recorded as uint64 to int64, saturating at
+// [math.MaxInt64].
+func clampSize(size uint64) int64 {
+	if size > math.MaxInt64 {
+		return math.MaxInt64
+	}
+
+	return int64(size)
+}
@@ -0,0 +1,201 @@
+package unzipper
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"btidy/pkg/safepath"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractLimits(t *testing.T) {
+	run := func(t *testing.T, root string, limits Limits, opts ...Option) Result {
+		t.Helper()
+
+		v, err := safepath.New(root)
+		require.NoError(t, err)
+		uz, err := NewWithValidator(v, false, nil, append([]Option{WithLimits(limits)}, opts...)...)
+		require.NoError(t, err)
+
+		files, err := getAllFilesRecursively(t.Context(), root)
+		require.NoError(t, err)
+
+		result, err := uz.ExtractArchivesWithProgressRecursively(t.Context(), files, nil)
+		require.NoError(t, err)
+		return result
+	}
+
+	skipReasons := func(result Result) []string {
+		var reasons []string
+		for _, op := range result.Operations {
+			if op.Skipped {
+				reasons = append(reasons, op.SkipReason)
+			}
+		}
+		return reasons
+	}
+
+	t.Run("nesting depth", func(t *testing.T) {
+		root := t.TempDir()
+		level3 := zipBytes(t, map[string][]byte{"deep.txt": []byte("deep")})
+		level2 := zipBytes(t, map[string][]byte{"level3.zip": level3})
+		writeZipWithEntries(t, filepath.Join(root, "level1.zip"), map[string][]byte{"level2.zip": level2})
+
+		result := run(t, root, Limits{MaxDepth: 2})
+
+		assert.Equal(t, 2, result.ExtractedArchives)
+		assert.Equal(t, 1, result.SkippedCount)
+		require.Len(t, skipReasons(result), 1)
+		assert.Contains(t, skipReasons(result)[0], "nesting depth 3 exceeds the limit of 2")
+		assert.FileExists(t, filepath.Join(root, "level3.zip"))
+		assert.NoFileExists(t, filepath.Join(root, "deep.txt"))
+	})
+
+	t.Run("per-archive bytes", func(t *testing.T) {
+		root := t.TempDir()
+		archivePath := filepath.Join(root, "big.zip")
+		writeZipWithEntries(t, archivePath, map[string][]byte{
+			"a.txt": bytes.Repeat([]byte("a"), 600),
+			"b.txt": bytes.Repeat([]byte("b"), 600),
+		})
+
+		result := run(t, root, Limits{MaxArchiveBytes: 1000})
+
+		assert.Equal(t, 0, result.ExtractedArchives)
+		require.Len(t, skipReasons(result), 1)
+		assert.Contains(t, skipReasons(result)[0], "above the per-archive limit of 1000 bytes")
+		assert.FileExists(t, archivePath)
+		assert.NoFileExists(t, filepath.Join(root, "a.txt"))
+	})
+
+	t.Run("per-run bytes", func(t *testing.T) {
+		root := t.TempDir()
+		writeZipWithEntries(t, filepath.Join(root, "first.zip"), map[string][]byte{"a.txt": bytes.Repeat([]byte("a"), 600)})
+		writeZipWithEntries(t, filepath.Join(root, "second.zip"), map[string][]byte{"b.txt": bytes.Repeat([]byte("b"), 600)})
+
+		result := run(t, root, Limits{MaxTotalBytes: 1000})
+
+		assert.Equal(t, 1, result.ExtractedArchives)
+		assert.Equal(t, int64(600), result.ExtractedBytes)
+		require.Len(t, skipReasons(result), 1)
+		assert.Contains(t, skipReasons(result)[0], "per-run limit of 1000 bytes (600 already extracted)")
+		assert.FileExists(t, filepath.Join(root, "a.txt"))
+		assert.FileExists(t, filepath.Join(root, "second.zip"))
+	})
+
+	t.Run("zip entry compression ratio", func(t *testing.T) {
+		root := t.TempDir()
+		archivePath := filepath.Join(root, "bomb.zip")
+		writeZipWithEntries(t, archivePath, map[string][]byte{"zeros.bin": make([]byte, 4<<20)})
+
+		result := run(t, root, DefaultLimits())
+
+		require.Len(t, skipReasons(result), 1)
+		assert.Contains(t, skipReasons(result)[0], `entry "zeros.bin" has a compression ratio of`)
+		assert.FileExists(t, archivePath)
+		assert.NoFileExists(t, filepath.Join(root, "zeros.bin"))
+	})
+
+	t.Run("compressed tar ratio", func(t *testing.T) {
+		root := t.TempDir()
+		archivePath := filepath.Join(root, "bomb.tar.gz")
+		data := buildTar(t, []tarMember{{name: "zeros.bin", typeflag: tar.TypeReg, body: string(make([]byte, 4<<20))}})
+		require.NoError(t, os.WriteFile(archivePath, gzipBytes(t, data), 0o644))
+
+		result := run(t, root, DefaultLimits())
+
+		require.Len(t, skipReasons(result), 1)
+		assert.Contains(t, skipReasons(result)[0], "compression ratio of")
+		assert.FileExists(t, archivePath)
+	})
+
+	t.Run("tar hard links count as copies", func(t *testing.T) {
+		root := t.TempDir()
+		archivePath := filepath.Join(root, "links.tar")
+		members := []tarMember{{name: "data.bin", typeflag: tar.TypeReg, body: string(bytes.Repeat([]byte("x"), 1000))}}
+		for _, name := range []string{"l1", "l2", "l3", "l4"} {
+			members = append(members, tarMember{name: name, typeflag: tar.TypeLink, linkname: "data.bin"})
+		}
+		require.NoError(t, os.WriteFile(archivePath, buildTar(t, members), 0o644))
+
+		result := run(t, root, Limits{MaxArchiveBytes: 3000})
+
+		require.Len(t, skipReasons(result), 1)
+		assert.Contains(t, skipReasons(result)[0], "expands to at least 4000 bytes")
+		assert.FileExists(t, archivePath)
+	})
+
+	t.Run("free disk space", func(t *testing.T) {
+		original := diskFreeSpace
+		t.Cleanup(func() { diskFreeSpace = original })
+		diskFreeSpace = func(string) (int64, error) { return 10, nil }
+
+		root := t.TempDir()
+		archivePath := filepath.Join(root, "docs.zip")
+		writeZipWithEntries(t, archivePath, map[string][]byte{"a.txt": bytes.Repeat([]byte("a"), 100)})
+
+		result := run(t, root, DefaultLimits())
+
+		require.Len(t, skipReasons(result), 1)
+		assert.Contains(t, skipReasons(result)[0], "only 10 bytes are free")
+		assert.FileExists(t, archivePath)
+	})
+
+	t.Run("standalone compressed file ratio", func(t *testing.T) {
+		root := t.TempDir()
+		compressedPath := filepath.Join(root, "zeros.bin.gz")
+		require.NoError(t, os.WriteFile(compressedPath, gzipBytes(t, make([]byte, 4<<20)), 0o644))
+
+		result := run(t, root, DefaultLimits(), WithSingleFileDecompression(true))
+
+		assert.Equal(t, 0, result.DecompressedFiles)
+		require.Len(t, skipReasons(result), 1)
+		assert.Contains(t, skipReasons(result)[0], "compression ratio of")
+		assert.FileExists(t, compressedPath)
+		assert.NoFileExists(t, filepath.Join(root, "zeros.bin"))
+	})
+
+	t.Run("records extracted bytes", func(t *testing.T) {
+		root := t.TempDir()
+		writeZipWithEntries(t, filepath.Join(root, "docs.zip"), map[string][]byte{
+			"a.txt": []byte("12345"),
+			"b.txt": []byte("123"),
+		})
+
+		result := run(t, root, DefaultLimits())
+
+		require.Len(t, result.Operations, 1)
+		assert.Equal(t, int64(8), result.Operations[0].ExtractedBytes)
+		assert.Equal(t, int64(8), result.ExtractedBytes)
+	})
+}
+
+func TestNewWithValidatorRejectsNegativeLimits(t *testing.T) {
+	v, err := safepath.New(t.TempDir())
+	require.NoError(t, err)
+
+	_, err = NewWithValidator(v, false, nil, WithLimits(Limits{MaxDepth: -1}))
+	require.Error(t, err)
+}
+
+func zipBytes(t *testing.T, entries map[string][]byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range entries {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write(content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+
+	return buf.Bytes()
+}
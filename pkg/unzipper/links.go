@@ -0,0 +1,171 @@
+package unzipper
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"btidy/pkg/safepath"
+)
+
+var (
+	// errLinkTargetAbsolute is reported for link entries whose target is an
+	// absolute path; such links can never be kept inside the extraction root.
+	errLinkTargetAbsolute = errors.New("link target is absolute")
+
+	// errLinkTargetEscapes is reported for link entries whose target resolves
+	// outside the extraction root.
+	errLinkTargetEscapes = errors.New("link target escapes root")
+
+	// errLinkTargetMissing is reported for hard link entries that name a
+	// member which was not extracted earlier in the same archive.
+	errLinkTargetMissing = errors.New("link target not extracted")
+)
+
+// validateLinkEntry checks that a symlink or hard link entry stays inside the
+// extraction root. Symlink targets are resolved relative to the directory of
+// the link itself; hard link targets name another archive member and are
+// resolved like any entry name. When validator is nil, baseDir (the archive's
+// directory) is used as the containment boundary. Non-link entries are
+// always accepted.
+func validateLinkEntry(baseDir, targetPath string, entry archiveEntry, validator *safepath.Validator) error {
+	switch entry.kind {
+	case entryKindSymlink:
+		linkTarget := filepath.FromSlash(entry.linkTarget)
+		if linkTarget == "" {
+			return fmt.Errorf("%w: empty target", errLinkTargetEscapes)
+		}
+		if filepath.IsAbs(linkTarget) || hasWindowsVolumePrefix(entry.linkTarget) {
+			return fmt.Errorf("%w: %s", errLinkTargetAbsolute, entry.linkTarget)
+		}
+
+		resolved := filepath.Join(filepath.Dir(targetPath), linkTarget)
+		if !linkTargetContained(baseDir, resolved, validator) {
+			return fmt.Errorf("%w: %s", errLinkTargetEscapes, entry.linkTarget)
+		}
+	case entryKindHardlink:
+		if _, err := resolveArchiveEntryPath(baseDir, entry.linkTarget, validator); err != nil {
+			return fmt.Errorf("%w: %s: %w", errLinkTargetEscapes, entry.linkTarget, err)
+		}
+	}
+
+	return nil
+}
+
+// linkTargetContained reports whether resolved lies within the validator's
+// root, or within baseDir when no validator is configured.
+func linkTargetContained(baseDir, resolved string, validator *safepath.Validator) bool {
+	if validator != nil {
+		return validator.ValidatePath(resolved) == nil
+	}
+
+	return resolved == baseDir || isSubPath(baseDir, resolved)
+}
+
+// extractSymlinkEntry creates a symbolic link at targetPath. Any non-directory
+// already at targetPath is removed first, since [os.Symlink] never replaces.
+// The created link is re-checked with [safepath.Validator.ValidateSymlink] and
+// removed again if it resolves outside root.
+func extractSymlinkEntry(entry archiveEntry, targetPath string, validator *safepath.Validator) error {
+	if err := removeExistingNonDir(targetPath); err != nil {
+		return err
+	}
+
+	if err := os.Symlink(filepath.FromSlash(entry.linkTarget), targetPath); err != nil {
+		return fmt.Errorf("failed to create symlink: %w", err)
+	}
+
+	if validator != nil {
+		if err := validator.ValidateSymlink(targetPath); err != nil {
+			_ = os.Remove(targetPath)
+			return fmt.Errorf("%w: %w", errLinkTargetEscapes, err)
+		}
+	}
+
+	return nil
+}
+
+// extractHardlinkEntry materializes a tar hard link by copying the content of
+// the previously extracted member it refers to. Copying rather than calling
+// [os.Link] keeps later mutations of one path from silently changing the
+// other, which matters for duplicate detection and undo.
+func extractHardlinkEntry(baseDir string, entry archiveEntry, targetPath string, validator *safepath.Validator) error {
+	sourcePath, err := resolveArchiveEntryPath(baseDir, entry.linkTarget, validator)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errLinkTargetEscapes, err)
+	}
+
+	return copyLinkSource(sourcePath, entry.linkTarget, targetPath, validator)
+}
+
+// copyLinkSource writes targetPath as a copy of the regular file at
+// sourcePath, which a link entry with the text linkTarget refers to. It is
+// shared by tar hard links and materialized symlinks.
+func copyLinkSource(sourcePath, linkTarget, targetPath string, validator *safepath.Validator) error {
+	if validator != nil {
+		if err := validator.ValidatePathForRead(sourcePath); err != nil {
+			return fmt.Errorf("%w: %w", errLinkTargetEscapes, err)
+		}
+	}
+
+	src, err := os.Open(sourcePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: %s", errLinkTargetMissing, linkTarget)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open link target: %w", err)
+	}
+	defer func() {
+		_ = src.Close()
+	}()
+
+	info, err := src.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat link target: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%w: %s is not a regular file", errLinkTargetMissing, linkTarget)
+	}
+
+	if sameFile(sourcePath, targetPath) {
+		return nil
+	}
+
+	if err := removeExistingNonDir(targetPath); err != nil {
+		return err
+	}
+
+	return writeFileFromReader(src, targetPath, info.Mode().Perm())
+}
+
+// removeExistingNonDir deletes whatever non-directory entry exists at path
+// without following symlinks. A missing path is not an error.
+func removeExistingNonDir(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return errors.New("target exists as directory")
+	}
+
+	return os.Remove(path)
+}
+
+// sameFile reports whether a and b refer to the same existing file.
+func sameFile(a, b string) bool {
+	ai, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bi, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+
+	return os.SameFile(ai, bi)
+}
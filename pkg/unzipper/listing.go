@@ -0,0 +1,352 @@
+package unzipper
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"btidy/pkg/collector"
+)
+
+const (
+	// maxNestedListSize is the largest nested archive that
+	// [Unzipper.ListArchives] reads into memory to list its entries.
+	maxNestedListSize = 256 << 20
+
+	// archiveSniffLen is how much of an entry is read to recognize an
+	// archive; it covers the "ustar" magic at offset 257 of a tar header.
+	archiveSniffLen = 512
+
+	// tarMagicOffset is where the "ustar" magic sits in a tar header.
+	tarMagicOffset = 257
+)
+
+var (
+	// zipLocalHeaderMagic and zipEmptyArchiveMagic start a zip archive:
+	// a local file header, or the end record of an archive without
+	// entries.
+	zipLocalHeaderMagic  = []byte("PK\x03\x04")
+	zipEmptyArchiveMagic = []byte("PK\x05\x06")
+
+	// tarMagic is the POSIX ustar magic; GNU tar writes "ustar " instead,
+	// which shares the prefix.
+	tarMagic = []byte("ustar")
+)
+
+// ListLocationSeparator separates an archive from the path of one of its
+// members in [ListedEntry.Location], as in "backup.zip!photos.tar!a.jpg".
+const ListLocationSeparator = "!"
+
+// ListedEntry describes one archive member reported by
+// [Unzipper.ListArchives].
+type ListedEntry struct {
+	// Archive is the archive file on disk, relative to the root directory
+	// and with "/" as separator.
+	Archive string `json:"archive"`
+
+	// Nested names the members, outermost first, that lead from Archive to
+	// the nested archive holding this entry. It is empty for the entries
+	// of Archive itself.
+	Nested []string `json:"nested,omitempty"`
+
+	// Path is the member path inside its archive.
+	Path string `json:"path"`
+
+	// Type is "file", "dir", "symlink", "hardlink" or "other".
+	Type string `json:"type"`
+
+	// Size is the uncompressed size of a file.
+	Size int64 `json:"size"`
+
+	// CompressedSize is the stored size of a zip entry. It is zero for
+	// tar and 7z entries, which may be compressed together.
+	CompressedSize int64 `json:"compressed_size,omitempty"`
+
+	// Method names how the body is compressed and encrypted, such as
+	// "deflate", "deflate+aes-256", "LZMA2" or, for tar, the compression
+	// of the whole stream.
+	Method string `json:"method,omitempty"`
+
+	// Modified is the recorded modification time, if any.
+	Modified time.Time `json:"modified,omitzero"`
+
+	// CRC32 is the recorded checksum as eight hex digits, or empty when
+	// the archive records none.
+	CRC32 string `json:"crc32,omitempty"`
+
+	// LinkTarget is the target of symlink and hard link entries.
+	LinkTarget string `json:"link_target,omitempty"`
+
+	// NotListed is set for nested archives whose entries are not listed,
+	// and says why: they nest too deep or are too large to read into
+	// memory.
+	NotListed string `json:"not_listed,omitempty"`
+}
+
+// Location returns the archive, the nested archives and the path of e
+// joined by [ListLocationSeparator].
+func (e ListedEntry) Location() string {
+	parts := append([]string{e.Archive}, e.Nested...)
+	return strings.Join(append(parts, e.Path), ListLocationSeparator)
+}
+
+// ListResult summarizes an archive listing.
+type ListResult struct {
+	// Archives counts the archives read, nested ones included.
+	Archives int
+
+	// Entries counts the entries reported.
+	Entries int
+
+	// Errors holds, for each archive that could not be read in full, an
+	// error naming it. Entries read before the failure are reported.
+	Errors []error
+}
+
+// errListingStopped wraps the error returned by a listing callback, so it
+// is told apart from errors reading an archive.
+type errListingStopped struct {
+	err error
+}
+
+func (e *errListingStopped) Error() string {
+	return e.err.Error()
+}
+
+func (e *errListingStopped) Unwrap() error {
+	return e.err
+}
+
+// ListArchives calls fn for every member of each archive among files,
+// without extracting anything. Nested archives of up to 256 MiB are read
+// into memory and their members reported after the entry holding them,
+// down to [Limits.MaxDepth] levels. Archives that cannot be read are
+// recorded in [ListResult.Errors] and the listing goes on; an error
+// returned by fn stops it and is returned.
+//
+// Encrypted members are listed, but nested archives inside them are only
+// opened when one of the configured passwords decrypts them.
+func (u *Unzipper) ListArchives(files []collector.FileInfo, fn func(ListedEntry) error) (ListResult, error) {
+	var res ListResult
+	cfg := u.config()
+
+	for _, file := range filterOnlyArchives(files) {
+		archivePath := filepath.Join(file.Dir, file.Name)
+		rel := archivePath
+		if relPath, err := filepath.Rel(u.validator.Root(), archivePath); err == nil {
+			rel = filepath.ToSlash(relPath)
+		}
+
+		if err := u.validator.ValidatePathForRead(archivePath); err != nil {
+			res.Errors = append(res.Errors, fmt.Errorf("failed to list %s: %w", rel, err))
+			continue
+		}
+
+		r, err := openArchive(archivePath, cfg)
+		if err != nil {
+			res.Errors = append(res.Errors, fmt.Errorf("failed to list %s: %w", rel, err))
+			continue
+		}
+
+		l := &archiveLister{cfg: cfg, maxDepth: u.limits.MaxDepth, fn: fn, res: &res, archive: rel}
+		err = l.list(r, nil, 1)
+		_ = r.Close()
+
+		var stopped *errListingStopped
+		if errors.As(err, &stopped) {
+			return res, stopped.err
+		}
+		if err != nil {
+			res.Errors = append(res.Errors, fmt.Errorf("failed to list %s: %w", rel, err))
+		}
+	}
+
+	return res, nil
+}
+
+// archiveLister lists one archive on disk and the archives nested in it.
+type archiveLister struct {
+	cfg      extractConfig
+	maxDepth int
+	fn       func(ListedEntry) error
+	res      *ListResult
+
+	// archive is the archive on disk, relative to the root directory.
+	archive string
+}
+
+// list reports the entries of r, which is nested at depth inside the
+// archive on disk through the members in nested. Errors reading a nested
+// archive are recorded and do not stop the listing of r.
+func (l *archiveLister) list(r archiveSource, nested []string, depth int) error {
+	l.res.Archives++
+
+	return r.walk(func(entry archiveEntry) error {
+		listed := listedEntry(entry)
+		listed.Archive = l.archive
+		listed.Nested = nested
+
+		var inner archiveSource
+		if entry.kind == entryKindFile && entry.damaged == nil {
+			inner, listed.NotListed = l.openNested(entry, depth+1)
+		}
+		if inner != nil {
+			defer func() {
+				_ = inner.Close()
+			}()
+		}
+
+		l.res.Entries++
+		if err := l.fn(listed); err != nil {
+			return &errListingStopped{err: err}
+		}
+		if inner == nil {
+			return nil
+		}
+
+		innerNested := append(append([]string(nil), nested...), listed.Path)
+		err := l.list(inner, innerNested, depth+1)
+
+		var stopped *errListingStopped
+		if errors.As(err, &stopped) {
+			return err
+		}
+		if err != nil {
+			location := ListedEntry{Archive: l.archive, Nested: nested, Path: listed.Path}.Location()
+			l.res.Errors = append(l.res.Errors, fmt.Errorf("failed to list %s: %w", location, err))
+		}
+
+		return nil
+	})
+}
+
+// openNested opens entry as an archive held in memory when its content
+// starts like one. It returns a nil source for entries that are not
+// archives or cannot be read, and, for archives that are not opened
+// because of depth or their size, the reason.
+func (l *archiveLister) openNested(entry archiveEntry, depth int) (archiveSource, string) {
+	rc, err := entry.open()
+	if err != nil {
+		return nil, ""
+	}
+	defer func() {
+		_ = rc.Close()
+	}()
+
+	head := make([]byte, archiveSniffLen)
+	n, err := io.ReadFull(rc, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, ""
+	}
+	head = head[:n]
+	if !looksLikeArchive(head) {
+		return nil, ""
+	}
+
+	if l.maxDepth > 0 && depth > l.maxDepth {
+		return nil, fmt.Sprintf("nesting limit of %d levels reached", l.maxDepth)
+	}
+	if entry.size > maxNestedListSize {
+		return nil, fmt.Sprintf("nested archive larger than %d MiB", maxNestedListSize>>20)
+	}
+
+	rest, err := io.ReadAll(io.LimitReader(rc, maxNestedListSize+1-int64(n)))
+	if err != nil {
+		return nil, fmt.Sprintf("cannot be read: %v", err)
+	}
+	if int64(n+len(rest)) > maxNestedListSize {
+		return nil, fmt.Sprintf("nested archive larger than %d MiB", maxNestedListSize>>20)
+	}
+
+	inner, err := openArchiveData(append(head, rest...), l.cfg)
+	if err != nil {
+		// A standalone compressed file, or a damaged archive.
+		return nil, ""
+	}
+
+	return inner, ""
+}
+
+// looksLikeArchive reports whether head, the start of a file, has the
+// signature of a zip, 7z or tar archive or of a compression wrapper that
+// may hold a tarball.
+func looksLikeArchive(head []byte) bool {
+	switch {
+	case bytes.HasPrefix(head, zipLocalHeaderMagic), bytes.HasPrefix(head, zipEmptyArchiveMagic):
+		return true
+	case bytes.HasPrefix(head, sevenZipSignature):
+		return true
+	case len(head) >= tarMagicOffset+len(tarMagic) && bytes.Equal(head[tarMagicOffset:tarMagicOffset+len(tarMagic)], tarMagic):
+		return true
+	default:
+		return detectCompression(bufio.NewReader(bytes.NewReader(head))) != compressionNone
+	}
+}
+
+// listedEntry converts entry into the fields of a [ListedEntry] that do
+// not depend on where the archive sits.
+func listedEntry(entry archiveEntry) ListedEntry {
+	listed := ListedEntry{
+		Path:       strings.TrimSuffix(normalizeArchiveEntryPath(entry.name), "/"),
+		Type:       entry.kind.String(),
+		Modified:   entry.modTime,
+		LinkTarget: entry.linkTarget,
+	}
+	if entry.kind != entryKindDir {
+		listed.Method = entry.method
+	}
+	if entry.kind == entryKindFile {
+		listed.Size = entry.size
+		listed.CompressedSize = entry.compressedSize
+	}
+	if entry.hasCRC {
+		listed.CRC32 = fmt.Sprintf("%08x", entry.crc32)
+	}
+
+	return listed
+}
+
+// zipEntryMethod names how f is stored for listings: its compression
+// method, followed by its encryption, as in "deflate+aes-256".
+func zipEntryMethod(f *zip.File) string {
+	enc, err := entryEncryption(f)
+	if err != nil {
+		return zipMethodLabel(f.Method) + "+encrypted"
+	}
+
+	name := zipMethodLabel(enc.method)
+	switch enc.cipher {
+	case cipherZipCrypto:
+		name += "+zipcrypto"
+	case cipherAES:
+		name += fmt.Sprintf("+aes-%d", enc.keyLen*8)
+	}
+
+	return name
+}
+
+// zipMethodLabel is [compressionMethodName] with the number of methods it
+// does not know.
+func zipMethodLabel(method uint16) string {
+	if name := compressionMethodName(method); name != "unknown" {
+		return name
+	}
+
+	return fmt.Sprintf("method %d", method)
+}
+
+// zipEntryCRC returns the CRC-32 recorded for f. AE-2 entries store zero
+// instead, so theirs is unknown.
+func zipEntryCRC(f *zip.File) (uint32, bool) {
+	if enc, err := entryEncryption(f); err != nil || (enc.cipher == cipherAES && enc.version != zipAESVersion1) {
+		return 0, false
+	}
+
+	return f.CRC32, true
+}
@@ -0,0 +1,186 @@
+package unzipper
+
+import (
+	"archive/tar"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"btidy/internal/testutil"
+	"btidy/pkg/safepath"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// zipFileBytes returns a zip archive holding entries.
+func zipFileBytes(t *testing.T, entries map[string][]byte) []byte {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "fixture.zip")
+	writeZipWithEntries(t, path, entries)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return data
+}
+
+// writeNestedFixture writes outer.zip to root, holding a gzipped tarball
+// that holds a 7z archive.
+func writeNestedFixture(t *testing.T, root string) {
+	t.Helper()
+
+	sevenZipPath := filepath.Join(t.TempDir(), "inner.7z")
+	testutil.WriteSevenZip(t, sevenZipPath, []testutil.SevenZipEntry{
+		{Name: "notes", Dir: true},
+		{Name: "notes/secret.txt", Body: []byte("hidden deep")},
+	}, testutil.SevenZipOptions{})
+	sevenZipData, err := os.ReadFile(sevenZipPath)
+	require.NoError(t, err)
+
+	tarball := buildTar(t, []tarMember{
+		{name: "deep/inner.7z", typeflag: tar.TypeReg, body: string(sevenZipData)},
+		{name: "deep/link", typeflag: tar.TypeSymlink, linkname: "inner.7z"},
+	})
+
+	writeZipWithEntries(t, filepath.Join(root, "outer.zip"), map[string][]byte{
+		"bundle.tgz": gzipBytes(t, tarball),
+	})
+}
+
+func listAll(t *testing.T, root string, opts ...Option) ([]ListedEntry, ListResult) {
+	t.Helper()
+
+	v, err := safepath.New(root)
+	require.NoError(t, err)
+	uz, err := NewWithValidator(v, true, nil, opts...)
+	require.NoError(t, err)
+	files, err := getAllFilesRecursively(t.Context(), root)
+	require.NoError(t, err)
+
+	var entries []ListedEntry
+	result, err := uz.ListArchives(files, func(entry ListedEntry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	require.NoError(t, err)
+	return entries, result
+}
+
+func locations(entries []ListedEntry) []string {
+	out := make([]string, 0, len(entries))
+	for _, e := range entries {
+		out = append(out, e.Location())
+	}
+	return out
+}
+
+func TestListArchivesDescendsIntoNestedArchives(t *testing.T) {
+	root := t.TempDir()
+	writeNestedFixture(t, root)
+
+	entries, result := listAll(t, root)
+	assert.Equal(t, 3, result.Archives)
+	assert.Equal(t, 5, result.Entries)
+	assert.Empty(t, result.Errors)
+	assert.Equal(t, []string{
+		"outer.zip!bundle.tgz",
+		"outer.zip!bundle.tgz!deep/inner.7z",
+		"outer.zip!bundle.tgz!deep/inner.7z!notes",
+		"outer.zip!bundle.tgz!deep/inner.7z!notes/secret.txt",
+		"outer.zip!bundle.tgz!deep/link",
+	}, locations(entries))
+
+	bundle := entries[0]
+	assert.Equal(t, "file", bundle.Type)
+	assert.Equal(t, "deflate", bundle.Method)
+	assert.Positive(t, bundle.CompressedSize)
+	assert.Len(t, bundle.CRC32, 8)
+	assert.Empty(t, bundle.Nested)
+
+	assert.Equal(t, "gzip", entries[1].Method)
+	assert.Equal(t, []string{"bundle.tgz"}, entries[1].Nested)
+
+	assert.Equal(t, "dir", entries[2].Type)
+	secret := entries[3]
+	assert.Equal(t, []string{"bundle.tgz", "deep/inner.7z"}, secret.Nested)
+	assert.Equal(t, int64(len("hidden deep")), secret.Size)
+	assert.Equal(t, "LZMA2", secret.Method)
+	assert.Equal(t, "612a32ef", secret.CRC32)
+
+	assert.Equal(t, "symlink", entries[4].Type)
+	assert.Equal(t, "inner.7z", entries[4].LinkTarget)
+
+	names, err := os.ReadDir(root)
+	require.NoError(t, err)
+	require.Len(t, names, 1, "listing writes nothing")
+}
+
+func TestListArchivesStopsAtMaxDepth(t *testing.T) {
+	root := t.TempDir()
+	writeNestedFixture(t, root)
+
+	limits := DefaultLimits()
+	limits.MaxDepth = 2
+	entries, result := listAll(t, root, WithLimits(limits))
+	assert.Equal(t, 2, result.Archives)
+	require.Len(t, entries, 3)
+	assert.Equal(t, "outer.zip!bundle.tgz!deep/inner.7z", entries[1].Location())
+	assert.Equal(t, "nesting limit of 2 levels reached", entries[1].NotListed)
+}
+
+func TestListArchivesReportsUnreadableNestedArchives(t *testing.T) {
+	root := t.TempDir()
+	tarball := gzipBytes(t, buildTar(t, []tarMember{
+		{name: "a.txt", typeflag: tar.TypeReg, body: "aaaa"},
+		{name: "b.txt", typeflag: tar.TypeReg, body: "bbbb"},
+	}))
+	writeZipWithEntries(t, filepath.Join(root, "outer.zip"), map[string][]byte{
+		"truncated.tgz": tarball[:len(tarball)-12],
+		"readme.txt":    []byte("plain"),
+	})
+
+	entries, result := listAll(t, root)
+	assert.Contains(t, locations(entries), "outer.zip!readme.txt")
+	assert.Contains(t, locations(entries), "outer.zip!truncated.tgz")
+	require.Len(t, result.Errors, 1)
+	assert.Contains(t, result.Errors[0].Error(), "outer.zip!truncated.tgz")
+}
+
+func TestListArchivesEncryptedEntries(t *testing.T) {
+	root := t.TempDir()
+	testutil.WriteZipCryptoZip(t, filepath.Join(root, "locked.zip"), map[string][]byte{
+		"inner.zip": zipFileBytes(t, map[string][]byte{"tax.txt": []byte("2019")}),
+	}, "hunter2")
+
+	entries, result := listAll(t, root)
+	require.Len(t, entries, 1, "encrypted nested archives are not opened without a password")
+	assert.Equal(t, "store+zipcrypto", entries[0].Method)
+	assert.Empty(t, result.Errors)
+
+	entries, _ = listAll(t, root, WithPasswords([]string{"hunter2"}))
+	assert.Equal(t, []string{"locked.zip!inner.zip", "locked.zip!inner.zip!tax.txt"}, locations(entries))
+}
+
+func TestListArchivesCallbackErrorStops(t *testing.T) {
+	root := t.TempDir()
+	writeNestedFixture(t, root)
+
+	uz, err := New(root, true)
+	require.NoError(t, err)
+	files, err := getAllFilesRecursively(t.Context(), root)
+	require.NoError(t, err)
+
+	stop := errors.New("found it")
+	calls := 0
+	result, err := uz.ListArchives(files, func(ListedEntry) error {
+		calls++
+		if calls == 2 {
+			return stop
+		}
+		return nil
+	})
+	require.ErrorIs(t, err, stop)
+	assert.Equal(t, 2, calls)
+	assert.Empty(t, result.Errors)
+}
@@ -0,0 +1,245 @@
+package unzipper
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"btidy/pkg/collector"
+	"btidy/pkg/hasher"
+)
+
+// DefaultInMemoryLimit is the default size up to which an archive nested
+// in another one is extracted straight from memory instead of being
+// written to disk first.
+const DefaultInMemoryLimit = 64 << 20
+
+// StreamedArchive describes an archive nested in the archive of an
+// [ExtractOperation] that was extracted from memory. It never existed on
+// disk; only its entries did.
+type StreamedArchive struct {
+	// Chain names the members, outermost first, that lead from
+	// ArchivePath to this archive; the last one is the archive itself.
+	Chain []string
+
+	// DestDir is the directory its entries were extracted into.
+	DestDir string
+
+	// DuplicateOf is set when the archive is byte-identical to one already
+	// extracted in this run, which it names; its entries were not
+	// extracted again and DestDir is empty. An archive that was itself
+	// extracted from memory is named by its [StreamedArchive.Location].
+	DuplicateOf string
+}
+
+// Location names the archive as the path of the archive on disk that holds
+// it, archivePath, followed by Chain, all joined by
+// [ListLocationSeparator].
+func (a StreamedArchive) Location(archivePath string) string {
+	return strings.Join(append([]string{archivePath}, a.Chain...), ListLocationSeparator)
+}
+
+// nestedExtractor extracts the archives found among the entries of the
+// archive being extracted directly from memory, so that only their leaf
+// files reach disk. Archives it cannot or may not extract this way (too
+// large, too deep, unreadable, encrypted without a matching password, or
+// breaking one of the [Limits]) are written to disk like any other file
+// and handled by the next pass, which reports why they were kept.
+type nestedExtractor struct {
+	u     *Unzipper
+	state *runState
+
+	// depth is the nesting level of the archive whose entries are being
+	// extracted.
+	depth int
+
+	// chain names the members leading from the archive on disk to the
+	// archive whose entries are being extracted; it is empty for the
+	// archive on disk.
+	chain []string
+}
+
+// newNestedExtractor returns the extractor for the nested archives of an
+// archive on disk at nesting level depth, or nil when in-memory
+// extraction is disabled.
+func (u *Unzipper) newNestedExtractor(state *runState, depth int) *nestedExtractor {
+	if u.inMemoryLimit <= 0 {
+		return nil
+	}
+
+	return &nestedExtractor{u: u, state: state, depth: depth}
+}
+
+// extract extracts entry, whose file would be written to targetPath, as an
+// archive from memory when it is one and may be. It reports whether it
+// did. When it did not, the returned entry replays whatever extract read
+// of the body and must be written to disk in place of entry.
+func (n *nestedExtractor) extract(
+	ctx context.Context,
+	entry archiveEntry,
+	targetPath string,
+	cfg extractConfig,
+	op *ExtractOperation,
+) (bool, archiveEntry, error) {
+	limit := n.u.inMemoryLimit
+	if entry.size > limit {
+		n.noteDepth(targetPath)
+		return false, entry, nil
+	}
+
+	rc, err := entry.open()
+	if err != nil {
+		return false, entry, fmt.Errorf("failed to open entry: %w", err)
+	}
+
+	head := make([]byte, archiveSniffLen)
+	read, err := io.ReadFull(rc, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		_ = rc.Close()
+		return false, entry, fmt.Errorf("failed to read entry: %w", err)
+	}
+	head = head[:read]
+	if !looksLikeArchive(head) {
+		entry.open = replayBody(head, rc)
+		return false, entry, nil
+	}
+	n.noteDepth(targetPath)
+
+	rest, err := io.ReadAll(io.LimitReader(rc, limit+1-int64(read)))
+	if err != nil {
+		_ = rc.Close()
+		return false, entry, fmt.Errorf("failed to read entry: %w", err)
+	}
+	data := append(head, rest...)
+	if int64(len(data)) > limit {
+		entry.open = replayBody(data, rc)
+		return false, entry, nil
+	}
+	_ = rc.Close()
+	entry.open = replayBody(data, nil)
+
+	streamed, err := n.extractData(ctx, data, entry.name, targetPath, cfg, op)
+	if err != nil {
+		return false, entry, fmt.Errorf("failed to extract nested archive %s: %w", entry.name, err)
+	}
+
+	return streamed, entry, nil
+}
+
+// extractData extracts the nested archive data, the body of member name,
+// into the directory the configured [Layout] gives a file at targetPath.
+// A byte-identical copy of an archive already extracted in this run is
+// only recorded, like [Unzipper.processDuplicateArchive] does for copies
+// on disk. It reports false, with nothing written, when the archive must
+// go to disk instead.
+func (n *nestedExtractor) extractData(
+	ctx context.Context,
+	data []byte,
+	name, targetPath string,
+	cfg extractConfig,
+	op *ExtractOperation,
+) (bool, error) {
+	chain := append(slices.Clone(n.chain), name)
+	hash, err := hasher.New().ComputeDataHash(data)
+	if err != nil {
+		return false, err
+	}
+	if kept, ok := n.state.keptCopy(hash); ok {
+		op.StreamedArchives = append(op.StreamedArchives, StreamedArchive{Chain: chain, DuplicateOf: kept})
+		return true, nil
+	}
+
+	depth := n.depth + 1
+	usage, ok := n.u.checkNestedLimits(data, cfg, filepath.Dir(targetPath), depth, n.state)
+	if !ok {
+		return false, nil
+	}
+
+	streamed := StreamedArchive{Chain: chain}
+	extracted := false
+	defer func() {
+		n.state.finish(usage.bytes, extracted, hash, streamed.Location(op.ArchivePath))
+	}()
+
+	destDir, err := n.u.nestedDestDir(targetPath)
+	if err != nil {
+		return false, nil //nolint:nilerr // the pass that finds the archive on disk reports the destination error
+	}
+
+	r, err := openArchiveData(data, cfg)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = r.Close()
+	}()
+	if err = r.validate(); err != nil {
+		return false, err
+	}
+
+	child := &nestedExtractor{
+		u:     n.u,
+		state: n.state,
+		depth: depth,
+		chain: chain,
+	}
+	cfg.nested = child
+
+	parentChain := op.nested
+	op.nested = child.chain
+	err = extractEntries(ctx, r, destDir, cfg, n.u.validator, n.u.trasher, op)
+	op.nested = parentChain
+	if err != nil {
+		return false, err
+	}
+
+	extracted = true
+	streamed.DestDir = destDir
+	op.StreamedArchives = append(op.StreamedArchives, streamed)
+	op.ExtractedBytes = addSize(op.ExtractedBytes, usage.bytes)
+
+	return true, nil
+}
+
+// noteDepth records the nesting level of an archive that a nested archive
+// writes to disk at path, so the pass that finds it checks the right
+// depth against [Limits.MaxDepth]. Files written by the archive on disk
+// itself are found at the level of the next pass anyway.
+func (n *nestedExtractor) noteDepth(path string) {
+	if len(n.chain) > 0 {
+		n.state.noteDepth(path, n.depth+1)
+	}
+}
+
+// nestedDestDir returns the directory a nested archive whose file would
+// be written to targetPath is extracted into under the configured layout.
+func (u *Unzipper) nestedDestDir(targetPath string) (string, error) {
+	archive := collector.FileInfo{
+		Path: targetPath,
+		Dir:  filepath.Dir(targetPath),
+		Name: filepath.Base(targetPath),
+	}
+
+	return u.destinationDir(archive, targetPath)
+}
+
+// replayBody returns an entry opener that yields data followed by the rest
+// of rc, the reader data was taken from. A nil rc means data is the whole
+// body.
+func replayBody(data []byte, rc io.ReadCloser) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		if rc == nil {
+			return io.NopCloser(bytes.NewReader(data)), nil
+		}
+
+		return struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(data), rc), rc}, nil
+	}
+}
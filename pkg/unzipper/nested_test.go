@@ -0,0 +1,156 @@
+package unzipper
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"btidy/internal/testutil"
+)
+
+// writeChainFixture writes outer.zip to root, holding bundle.tgz, which
+// holds deep/inner.7z, which holds notes/secret.txt.
+func writeChainFixture(t *testing.T, root string) {
+	t.Helper()
+
+	sevenZipPath := filepath.Join(t.TempDir(), "inner.7z")
+	testutil.WriteSevenZip(t, sevenZipPath, []testutil.SevenZipEntry{
+		{Name: "notes/secret.txt", Body: []byte("hidden deep")},
+	}, testutil.SevenZipOptions{})
+	sevenZipData, err := os.ReadFile(sevenZipPath)
+	require.NoError(t, err)
+
+	tarball := buildTar(t, []tarMember{
+		{name: "deep/inner.7z", typeflag: tar.TypeReg, body: string(sevenZipData)},
+		{name: "deep/readme.txt", typeflag: tar.TypeReg, body: "read me"},
+	})
+
+	writeZipWithEntries(t, filepath.Join(root, "outer.zip"), map[string][]byte{
+		"bundle.tgz": gzipBytes(t, tarball),
+		"top.txt":    []byte("top"),
+	})
+}
+
+func TestExtractNestedArchivesFromMemory(t *testing.T) {
+	root := t.TempDir()
+	writeChainFixture(t, root)
+
+	result := runExtraction(t, root, false)
+	assert.Equal(t, 1, result.ArchivesFound)
+	assert.Equal(t, 3, result.ExtractedArchives)
+	assert.Equal(t, 2, result.StreamedArchives)
+	assert.Equal(t, 3, result.ExtractedFiles)
+
+	content, err := os.ReadFile(filepath.Join(root, "deep", "notes", "secret.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "hidden deep", string(content))
+	assert.NoFileExists(t, filepath.Join(root, "bundle.tgz"))
+	assert.NoFileExists(t, filepath.Join(root, "deep", "inner.7z"))
+
+	require.Len(t, result.Operations, 1)
+	op := result.Operations[0]
+	assert.Equal(t, []StreamedArchive{
+		{Chain: []string{"bundle.tgz", "deep/inner.7z"}, DestDir: filepath.Join(root, "deep")},
+		{Chain: []string{"bundle.tgz"}, DestDir: root},
+	}, op.StreamedArchives)
+
+	nested := make(map[string][]string)
+	for _, created := range op.CreatedFiles {
+		rel, relErr := filepath.Rel(root, created.Path)
+		require.NoError(t, relErr)
+		nested[filepath.ToSlash(rel)] = created.Nested
+	}
+	assert.Equal(t, map[string][]string{
+		"top.txt":               nil,
+		"deep/readme.txt":       {"bundle.tgz"},
+		"deep/notes/secret.txt": {"bundle.tgz", "deep/inner.7z"},
+	}, nested)
+}
+
+func TestExtractNestedArchivesIntoArchiveNameLayout(t *testing.T) {
+	root := t.TempDir()
+	writeChainFixture(t, root)
+
+	result := runExtraction(t, root, false, WithLayout(LayoutArchiveName))
+	assert.Equal(t, 2, result.StreamedArchives)
+	assert.FileExists(t, filepath.Join(root, "outer", "bundle", "deep", "inner", "notes", "secret.txt"))
+	assert.FileExists(t, filepath.Join(root, "outer", "top.txt"))
+}
+
+func TestExtractNestedArchivesWithoutInMemoryLimit(t *testing.T) {
+	root := t.TempDir()
+	writeChainFixture(t, root)
+
+	result := runExtraction(t, root, false, WithInMemoryLimit(0))
+	assert.Equal(t, 3, result.ArchivesFound, "each level is written to disk and found by a later pass")
+	assert.Equal(t, 3, result.ExtractedArchives)
+	assert.Zero(t, result.StreamedArchives)
+	assert.FileExists(t, filepath.Join(root, "deep", "notes", "secret.txt"))
+}
+
+func TestExtractNestedArchivesLargerThanInMemoryLimit(t *testing.T) {
+	root := t.TempDir()
+	writeChainFixture(t, root)
+
+	// Both nested archives are larger than 64 bytes, so each is written to
+	// disk and extracted, then removed, by a later pass.
+	result := runExtraction(t, root, false, WithInMemoryLimit(64))
+	assert.Equal(t, 3, result.ArchivesFound)
+	assert.Zero(t, result.StreamedArchives)
+	assert.Equal(t, 3, result.ExtractedArchives)
+	assert.FileExists(t, filepath.Join(root, "deep", "notes", "secret.txt"))
+	assert.NoFileExists(t, filepath.Join(root, "bundle.tgz"))
+}
+
+func TestExtractNestedArchivesKeepsDepthLimit(t *testing.T) {
+	root := t.TempDir()
+	writeChainFixture(t, root)
+
+	limits := DefaultLimits()
+	limits.MaxDepth = 2
+	result := runExtraction(t, root, false, WithLimits(limits))
+
+	assert.Equal(t, 1, result.StreamedArchives)
+	assert.FileExists(t, filepath.Join(root, "deep", "readme.txt"))
+	assert.FileExists(t, filepath.Join(root, "deep", "inner.7z"), "the third level is written to disk and kept")
+	assert.NoFileExists(t, filepath.Join(root, "deep", "notes", "secret.txt"))
+
+	require.Len(t, result.Operations, 2)
+	skipped := result.Operations[1]
+	assert.True(t, skipped.Skipped)
+	assert.Equal(t, filepath.Join(root, "deep", "inner.7z"), skipped.ArchivePath)
+	assert.Equal(t, "nesting depth 3 exceeds the limit of 2", skipped.SkipReason)
+}
+
+func TestExtractNestedEncryptedArchiveGoesToDisk(t *testing.T) {
+	root := t.TempDir()
+	lockedPath := filepath.Join(t.TempDir(), "locked.zip")
+	testutil.WriteZipCryptoZip(t, lockedPath, map[string][]byte{"tax.txt": []byte("2019")}, "hunter2")
+	locked, err := os.ReadFile(lockedPath)
+	require.NoError(t, err)
+	writeZipWithEntries(t, filepath.Join(root, "outer.zip"), map[string][]byte{"locked.zip": locked})
+
+	result := runExtraction(t, root, false)
+	assert.Zero(t, result.StreamedArchives)
+	assert.FileExists(t, filepath.Join(root, "locked.zip"), "kept for a later run with a password")
+	require.Len(t, result.Operations, 2)
+	assert.True(t, result.Operations[1].Skipped)
+
+	result = runExtraction(t, root, false, WithPasswords([]string{"hunter2"}))
+	assert.Equal(t, 1, result.ExtractedArchives)
+	assert.FileExists(t, filepath.Join(root, "tax.txt"))
+}
+
+func TestExtractNestedArchivesDryRunWritesNothing(t *testing.T) {
+	root := t.TempDir()
+	writeChainFixture(t, root)
+
+	result := runExtraction(t, root, true)
+	assert.Zero(t, result.StreamedArchives)
+	assert.NoFileExists(t, filepath.Join(root, "top.txt"))
+	assert.FileExists(t, filepath.Join(root, "outer.zip"))
+}
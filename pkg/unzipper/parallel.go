@@ -0,0 +1,259 @@
+package unzipper
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+
+	"btidy/pkg/collector"
+	"btidy/pkg/hasher"
+)
+
+// runState is what one [Unzipper.ExtractArchivesWithProgressRecursively] run
+// shares between the archives it processes. Archives of one batch may run
+// concurrently, so everything except depth is guarded by mu.
+type runState struct {
+	// depth is the nesting level of the current batch. It only changes
+	// between batches.
+	depth int
+
+	mu sync.Mutex
+
+	// usedBytes is the uncompressed size of everything extracted so far,
+	// including extractions still in flight.
+	usedBytes int64
+
+	// inFlight is the part of usedBytes reserved by extractions that have
+	// not finished writing yet.
+	inFlight int64
+
+	// extracted maps the content hash of every archive extracted so far to
+	// its path, so byte-identical copies are not extracted again.
+	extracted map[string]string
+
+	// depths records the nesting level of archives written to disk by a
+	// nested archive extracted from memory. They are deeper than the batch
+	// that finds them.
+	depths map[string]int
+}
+
+// newRunState returns the state of a run that has not extracted anything.
+func newRunState() *runState {
+	return &runState{extracted: make(map[string]string), depths: make(map[string]int)}
+}
+
+// noteDepth records that the archive at path sits at nesting level depth.
+func (s *runState) noteDepth(path string, depth int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.depths[path] = depth
+}
+
+// archiveDepth returns the nesting level of the archive at path found by
+// the current batch.
+func (s *runState) archiveDepth(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return max(s.depth, s.depths[path])
+}
+
+// keptCopy returns the path of an already extracted archive with hash.
+func (s *runState) keptCopy(hash string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path, ok := s.extracted[hash]
+	return path, ok
+}
+
+// finish releases the bytes an archive reserved in [Unzipper.checkLimits].
+// When the archive was not extracted after all, the bytes are returned to
+// the run budget; otherwise its hash is recorded for duplicate detection.
+func (s *runState) finish(reserved int64, extracted bool, hash, archivePath string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.inFlight -= reserved
+	if !extracted {
+		s.usedBytes -= reserved
+		return
+	}
+
+	if hash != "" {
+		s.extracted[hash] = archivePath
+	}
+}
+
+// scopeLocks serializes archives whose write scopes overlap. Two scopes
+// overlap when one directory equals or contains the other.
+type scopeLocks struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	held map[string]int
+}
+
+// newScopeLocks returns an empty set of scope locks.
+func newScopeLocks() *scopeLocks {
+	l := &scopeLocks{held: make(map[string]int)}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// acquire blocks until no held scope overlaps dir, then holds dir.
+func (l *scopeLocks) acquire(dir string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for l.overlaps(dir) {
+		l.cond.Wait()
+	}
+	l.held[dir]++
+}
+
+// release gives up dir and wakes waiting archives.
+func (l *scopeLocks) release(dir string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.held[dir]--
+	if l.held[dir] == 0 {
+		delete(l.held, dir)
+	}
+	l.cond.Broadcast()
+}
+
+// overlaps reports whether dir overlaps a held scope. l.mu must be held.
+func (l *scopeLocks) overlaps(dir string) bool {
+	for held := range l.held {
+		if isSubPath(held, dir) || isSubPath(dir, held) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// writeScope returns the directory tree that processing archive may write
+// to. The archive-name layout confines an archive to its own sibling
+// directory; every other case (the parent layout, the fresh layout whose
+// names depend on what exists on disk, and standalone decompression) may
+// write anywhere under the archive's directory.
+func (u *Unzipper) writeScope(archive collector.FileInfo, archivePath string) string {
+	if u.layout != LayoutArchiveName {
+		return archive.Dir
+	}
+
+	if u.decompressSingleFiles {
+		if _, ok := singleFileCompression(archivePath); ok {
+			return archive.Dir
+		}
+	}
+
+	return filepath.Join(archive.Dir, archiveDirName(archive.Name))
+}
+
+// hashArchives hashes archives concurrently with the configured worker
+// count and returns the results keyed by path. Archives not hashed when ctx
+// is done have no result.
+func (u *Unzipper) hashArchives(ctx context.Context, archives []collector.FileInfo) map[string]hasher.HashResult {
+	paths := make([]string, len(archives))
+	for i, archive := range archives {
+		paths[i] = filepath.Join(archive.Dir, archive.Name)
+	}
+
+	hashes := make(map[string]hasher.HashResult, len(paths))
+	for result := range hasher.New(hasher.WithWorkers(u.workers)).HashFiles(ctx, paths) {
+		hashes[result.Path] = result
+	}
+
+	return hashes
+}
+
+// splitDuplicateWaves orders a batch into two waves. The first holds every
+// archive whose content hash has not appeared earlier in the batch; the
+// second holds the later copies, which must wait until the first copy has
+// been extracted so they are recognized as duplicates instead of being
+// extracted concurrently.
+func splitDuplicateWaves(paths []string, hashes map[string]hasher.HashResult) (first, second []int) {
+	seen := make(map[string]bool, len(paths))
+	for i, path := range paths {
+		hash := hashes[path].Hash
+		if hash != "" && seen[hash] {
+			second = append(second, i)
+			continue
+		}
+		seen[hash] = hash != ""
+		first = append(first, i)
+	}
+
+	return first, second
+}
+
+// batchRun collects the outcome of one batch while its archives run.
+type batchRun struct {
+	mu        sync.Mutex
+	ops       []ExtractOperation
+	errs      []error
+	ran       []bool
+	failed    bool
+	completed int
+}
+
+// runWave processes the archives at indexes with up to u.workers running at
+// once. Archives are started in order; one whose write scope overlaps a
+// running archive waits for it. No new archive is started after one fails
+// or once ctx is done.
+func (u *Unzipper) runWave(
+	ctx context.Context,
+	indexes []int,
+	archives []collector.FileInfo,
+	hashes map[string]hasher.HashResult,
+	state *runState,
+	batch *batchRun,
+	progress func(stage string, processed, total int),
+) {
+	slots := make(chan struct{}, u.workers)
+	locks := newScopeLocks()
+
+	var wg sync.WaitGroup
+	for _, i := range indexes {
+		slots <- struct{}{}
+
+		batch.mu.Lock()
+		failed := batch.failed
+		batch.mu.Unlock()
+		if failed || ctx.Err() != nil {
+			<-slots
+			break
+		}
+
+		archive := archives[i]
+		archivePath := filepath.Join(archive.Dir, archive.Name)
+		scope := u.writeScope(archive, archivePath)
+		locks.acquire(scope)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			defer locks.release(scope)
+
+			op, err := u.processHashedArchive(ctx, archive, archivePath, hashes[archivePath], state)
+
+			batch.mu.Lock()
+			defer batch.mu.Unlock()
+			batch.ops[i] = op
+			batch.errs[i] = err
+			batch.ran[i] = true
+			batch.failed = batch.failed || err != nil
+			batch.completed++
+			if progress != nil {
+				progress(progressStageExtracting, batch.completed, len(archives))
+			}
+		}()
+	}
+
+	wg.Wait()
+}
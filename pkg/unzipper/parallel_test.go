@@ -0,0 +1,260 @@
+package unzipper
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"btidy/pkg/collector"
+	"btidy/pkg/safepath"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScopeLocks(t *testing.T) {
+	acquired := func(l *scopeLocks, dir string) <-chan struct{} {
+		done := make(chan struct{})
+		go func() {
+			l.acquire(dir)
+			close(done)
+		}()
+		return done
+	}
+
+	t.Run("disjoint scopes run together", func(t *testing.T) {
+		l := newScopeLocks()
+		l.acquire(filepath.Join("root", "a"))
+
+		select {
+		case <-acquired(l, filepath.Join("root", "b")):
+		case <-time.After(time.Second):
+			t.Fatal("sibling scope was blocked")
+		}
+	})
+
+	for _, second := range []string{"root", filepath.Join("root", "a"), filepath.Join("root", "a", "b")} {
+		t.Run("overlapping scope waits: "+second, func(t *testing.T) {
+			l := newScopeLocks()
+			first := filepath.Join("root", "a")
+			l.acquire(first)
+
+			done := acquired(l, second)
+			select {
+			case <-done:
+				t.Fatal("overlapping scope was not blocked")
+			case <-time.After(50 * time.Millisecond):
+			}
+
+			l.release(first)
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("scope was not released")
+			}
+		})
+	}
+}
+
+func TestWriteScope(t *testing.T) {
+	root := t.TempDir()
+	archive := collector.FileInfo{Dir: root, Name: "photos.zip"}
+	archivePath := filepath.Join(root, archive.Name)
+
+	assert.Equal(t, root, (&Unzipper{layout: LayoutParent}).writeScope(archive, archivePath))
+	assert.Equal(t, root, (&Unzipper{layout: LayoutFresh}).writeScope(archive, archivePath))
+	assert.Equal(t, filepath.Join(root, "photos"), (&Unzipper{layout: LayoutArchiveName}).writeScope(archive, archivePath))
+
+	gz := collector.FileInfo{Dir: root, Name: "dump.sql.gz"}
+	gzPath := filepath.Join(root, gz.Name)
+	require.NoError(t, os.WriteFile(gzPath, gzipBytes(t, []byte("select 1;")), 0o644))
+	u := &Unzipper{layout: LayoutArchiveName, decompressSingleFiles: true}
+	assert.Equal(t, root, u.writeScope(gz, gzPath))
+}
+
+func TestExtractArchivesInParallel(t *testing.T) {
+	run := func(t *testing.T, root string, opts ...Option) (Result, []int) {
+		t.Helper()
+
+		v, err := safepath.New(root)
+		require.NoError(t, err)
+		uz, err := NewWithValidator(v, false, nil, opts...)
+		require.NoError(t, err)
+
+		files, err := getAllFilesRecursively(t.Context(), root)
+		require.NoError(t, err)
+
+		var (
+			mu        sync.Mutex
+			processed []int
+		)
+		result, err := uz.ExtractArchivesWithProgressRecursively(t.Context(), files, func(_ string, n, _ int) {
+			mu.Lock()
+			defer mu.Unlock()
+			processed = append(processed, n)
+		})
+		require.NoError(t, err)
+		return result, processed
+	}
+
+	t.Run("extracts every archive and keeps batch order", func(t *testing.T) {
+		root := t.TempDir()
+		for i := range 12 {
+			writeZipWithEntries(t, filepath.Join(root, fmt.Sprintf("part-%02d.zip", i)), map[string][]byte{
+				"a.txt":     fmt.Appendf(nil, "a%d", i),
+				"sub/b.txt": fmt.Appendf(nil, "b%d", i),
+			})
+		}
+
+		result, processed := run(t, root, WithLayout(LayoutArchiveName), WithWorkers(4))
+		assert.Equal(t, 12, result.ExtractedArchives)
+		assert.Equal(t, 24, result.ExtractedFiles)
+		require.Len(t, result.Operations, 12)
+
+		for i, op := range result.Operations {
+			name := fmt.Sprintf("part-%02d", i)
+			assert.Equal(t, filepath.Join(root, name+".zip"), op.ArchivePath)
+			content, err := os.ReadFile(filepath.Join(root, name, "sub", "b.txt"))
+			require.NoError(t, err)
+			assert.Equal(t, fmt.Sprintf("b%d", i), string(content))
+		}
+
+		assert.IsNonDecreasing(t, processed)
+		assert.Equal(t, 12, processed[len(processed)-1])
+	})
+
+	t.Run("archives in one directory share it safely", func(t *testing.T) {
+		root := t.TempDir()
+		for i := range 6 {
+			writeZipWithEntries(t, filepath.Join(root, fmt.Sprintf("p%d.zip", i)), map[string][]byte{
+				"shared/common.txt":          fmt.Appendf(nil, "from %d", i),
+				fmt.Sprintf("own/%d.txt", i): []byte("own"),
+			})
+		}
+
+		result, _ := run(t, root, WithWorkers(8))
+		assert.Equal(t, 6, result.ExtractedArchives)
+		assert.Zero(t, result.ErrorCount)
+
+		content, err := os.ReadFile(filepath.Join(root, "shared", "common.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "from 5", string(content), "archives in one directory are extracted in order")
+		for i := range 6 {
+			assert.FileExists(t, filepath.Join(root, "own", fmt.Sprintf("%d.txt", i)))
+		}
+	})
+
+	t.Run("later copies in the same batch are duplicates", func(t *testing.T) {
+		root := t.TempDir()
+		entries := map[string][]byte{"same.txt": []byte("same")}
+		for _, dir := range []string{"a", "b", "c"} {
+			require.NoError(t, os.MkdirAll(filepath.Join(root, dir), 0o755))
+			writeZipWithEntries(t, filepath.Join(root, dir, "copy.zip"), entries)
+		}
+
+		result, _ := run(t, root, WithWorkers(3))
+		assert.Equal(t, 1, result.ExtractedArchives)
+		assert.Equal(t, 2, result.DuplicateArchives)
+
+		require.Len(t, result.Operations, 3)
+		assert.False(t, result.Operations[0].Duplicate)
+		for _, op := range result.Operations[1:] {
+			assert.True(t, op.Duplicate)
+			assert.Equal(t, filepath.Join(root, "a", "copy.zip"), op.DuplicateOf)
+		}
+	})
+
+	t.Run("concurrent archives share the run budget", func(t *testing.T) {
+		root := t.TempDir()
+		for _, dir := range []string{"a", "b", "c"} {
+			require.NoError(t, os.MkdirAll(filepath.Join(root, dir), 0o755))
+			writeZipWithEntries(t, filepath.Join(root, dir, "data.zip"), map[string][]byte{
+				"data.bin": fmt.Appendf(nil, "%s%0999d", dir, 0),
+			})
+		}
+
+		limits := DefaultLimits()
+		limits.MaxTotalBytes = 2500
+		result, _ := run(t, root, WithWorkers(3), WithLimits(limits))
+
+		assert.Equal(t, 2, result.ExtractedArchives)
+		assert.Equal(t, 1, result.SkippedCount)
+		assert.LessOrEqual(t, result.ExtractedBytes, int64(2500))
+	})
+}
+
+func TestExtractArchivesStopsWhenCanceled(t *testing.T) {
+	root := t.TempDir()
+	for i := range 3 {
+		writeZipWithEntries(t, filepath.Join(root, fmt.Sprintf("part-%d.zip", i)), map[string][]byte{
+			"a.txt": fmt.Appendf(nil, "a%d", i),
+		})
+	}
+
+	v, err := safepath.New(root)
+	require.NoError(t, err)
+	uz, err := NewWithValidator(v, false, nil, WithLayout(LayoutArchiveName), WithWorkers(1))
+	require.NoError(t, err)
+	files, err := getAllFilesRecursively(t.Context(), root)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	result, err := uz.ExtractArchivesWithProgressRecursively(ctx, files, func(_ string, n, _ int) {
+		if n > 0 {
+			cancel()
+		}
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, result.ExtractedArchives, "the archive in progress completes, no other starts")
+	require.Len(t, result.Operations, 1)
+	assert.FileExists(t, filepath.Join(root, "part-1.zip"))
+	assert.FileExists(t, filepath.Join(root, "part-2.zip"))
+}
+
+// cancelAfterChecks is a context that reports itself canceled once its Err
+// method has been called more than left times.
+type cancelAfterChecks struct {
+	context.Context
+
+	left int
+}
+
+func (c *cancelAfterChecks) Err() error {
+	if c.left <= 0 {
+		return context.Canceled
+	}
+	c.left--
+	return nil
+}
+
+func TestExtractStopsMidArchiveWhenCanceled(t *testing.T) {
+	root := t.TempDir()
+	archivePath := filepath.Join(root, "data.zip")
+	writeZipWithEntries(t, archivePath, map[string][]byte{
+		"a.txt": []byte("a"),
+		"b.txt": []byte("b"),
+		"c.txt": []byte("c"),
+	})
+
+	v, err := safepath.New(root)
+	require.NoError(t, err)
+	destDir := filepath.Join(root, "data")
+	ctx := &cancelAfterChecks{Context: t.Context(), left: 1}
+
+	op, err := unzipInto(ctx, collector.FileInfo{Dir: root, Name: "data.zip", Path: archivePath}, destDir, extractConfig{}, v, nil)
+	require.ErrorIs(t, err, context.Canceled)
+
+	assert.ErrorIs(t, op.Error, context.Canceled)
+	assert.False(t, op.ExtractionComplete)
+	assert.Equal(t, 1, op.ExtractedFiles, "only the entry before cancellation is extracted")
+	require.Len(t, op.CreatedFiles, 1, "the written file is recorded for the journal")
+	assert.FileExists(t, op.CreatedFiles[0].Path)
+	assert.Equal(t, []string{destDir}, op.CreatedDirs)
+	assert.FileExists(t, archivePath)
+}
@@ -0,0 +1,552 @@
+// Salvage mode recovers entries from zip archives whose central directory
+// is damaged or missing, most often because a copy stopped part-way. Every
+// zip entry is preceded by a local file header carrying its name, method,
+// sizes and CRC-32, so the entries before the damage can still be found by
+// scanning the file from the front. Entries written with a data descriptor
+// (general purpose bit 3) store their sizes after the data; those are
+// decompressed to find where they end.
+//
+// Each entry is decompressed and checked against its CRC-32 before anything
+// is extracted. Entries that fail are reported and never written.
+//
+// Reference: PKZIP APPNOTE.TXT §4.3.7 (local file header) and §4.3.9 (data
+// descriptor).
+package unzipper
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"btidy/pkg/collector"
+	"btidy/pkg/deflate64"
+)
+
+const (
+	// Local file header signature (PKZIP spec §4.3.7).
+	zipLocalHeaderSignature = 0x04034b50
+
+	// Fixed size in bytes of a local file header.
+	zipLocalHeaderLen = 30
+
+	// Optional signature that precedes a data descriptor (PKZIP spec §4.3.9).
+	zipDataDescriptorSignature = 0x08074b50
+
+	// zipFlagEncrypted is general purpose bit 0: the entry is encrypted.
+	zipFlagEncrypted = 0x1
+
+	// zipFlagDataDescriptor is general purpose bit 3: CRC-32 and sizes
+	// follow the entry data instead of the local header.
+	zipFlagDataDescriptor = 0x8
+
+	// Extended timestamp extra field ID (Info-ZIP "UT").
+	zipExtendedTimestampExtraID = 0x5455
+
+	// salvageScanChunk is how much of the file is searched at a time for
+	// the next local header signature.
+	salvageScanChunk = 64 << 10
+)
+
+// errEntryUnrecoverable is reported for entries a salvage scan found but
+// could not verify. They are never extracted.
+var errEntryUnrecoverable = errors.New("unrecoverable entry")
+
+// salvageArchive is a zip archive read from its local file headers instead
+// of its central directory. See the package comment of this file.
+type salvageArchive struct {
+	file    *os.File
+	entries []salvagedEntry
+}
+
+// salvagedEntry is one local file header found by a salvage scan.
+type salvagedEntry struct {
+	name    string
+	dir     bool
+	method  uint16
+	modTime time.Time
+
+	// offset is where the entry data starts in the file.
+	offset         int64
+	compressedSize int64
+	size           int64
+
+	// damaged is set, wrapping [errEntryUnrecoverable], when the entry
+	// cannot be verified.
+	damaged error
+}
+
+// hasLocalHeaderSignature reports whether the file at path starts with a
+// zip local file header, as every zip archive written front to back does.
+func hasLocalHeaderSignature(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	var sig [4]byte
+	if _, readErr := io.ReadFull(f, sig[:]); readErr != nil {
+		return false
+	}
+
+	return binary.LittleEndian.Uint32(sig[:]) == zipLocalHeaderSignature
+}
+
+// filterSalvageCandidates returns the files that look like zip archives but
+// cannot be opened as one, which salvage mode tries to recover.
+func filterSalvageCandidates(files []collector.FileInfo) []collector.FileInfo {
+	candidates := make([]collector.FileInfo, 0)
+	for _, f := range files {
+		path := filepath.Join(f.Dir, f.Name)
+		if hasLocalHeaderSignature(path) && !isArchive(path) {
+			candidates = append(candidates, f)
+		}
+	}
+
+	return candidates
+}
+
+// openSalvageArchive scans the zip file at filePath for local file headers.
+// It fails when the file does not start with one, so only files that began
+// life as zip archives are salvaged.
+func openSalvageArchive(filePath string, enc NameEncoding) (*salvageArchive, error) {
+	if !hasLocalHeaderSignature(filePath) {
+		return nil, errors.New("no zip local file header at the start of the file")
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	entries, err := scanLocalHeaders(f, info.Size(), enc)
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("failed to scan for zip entries: %w", err)
+	}
+
+	return &salvageArchive{file: f, entries: entries}, nil
+}
+
+// validate implements [archiveSource]. Unsupported compression methods are
+// reported per entry as unrecoverable instead of rejecting the archive.
+func (a *salvageArchive) validate() error {
+	return nil
+}
+
+// walk implements [archiveSource]. Local headers carry no permission bits,
+// so entries get the default modes, and symlinks come out as regular files
+// holding the link text.
+func (a *salvageArchive) walk(fn func(archiveEntry) error) error {
+	for _, e := range a.entries {
+		entry := archiveEntry{
+			name:           e.name,
+			kind:           entryKindFile,
+			mode:           0o644,
+			modTime:        e.modTime,
+			size:           e.size,
+			compressedSize: e.compressedSize,
+			damaged:        e.damaged,
+			open: func() (io.ReadCloser, error) {
+				return openSalvagedEntry(a.file, e)
+			},
+		}
+		if e.dir {
+			entry.kind = entryKindDir
+			entry.mode = 0o755
+		}
+
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close implements [archiveSource].
+func (a *salvageArchive) Close() error {
+	return a.file.Close()
+}
+
+// scanLocalHeaders returns every entry whose local header is found in r,
+// in file order. The scan continues after each entry's data, or right
+// after its header when the data cannot be delimited, so entries following
+// a damaged one are still found.
+func scanLocalHeaders(r io.ReaderAt, size int64, enc NameEncoding) ([]salvagedEntry, error) {
+	var entries []salvagedEntry
+	for pos := int64(0); pos < size; {
+		off, found, err := nextSignature(r, zipLocalHeaderSignature, pos, size)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			break
+		}
+
+		entry, next, ok, err := readSalvagedEntry(r, off, size, enc)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			pos = off + 4
+			continue
+		}
+
+		entries = append(entries, entry)
+		pos = next
+	}
+
+	return entries, nil
+}
+
+// nextSignature returns the offset of the first occurrence of the record
+// signature sig in r at or after from.
+func nextSignature(r io.ReaderAt, sig uint32, from, size int64) (int64, bool, error) {
+	var pattern [4]byte
+	binary.LittleEndian.PutUint32(pattern[:], sig)
+
+	buf := make([]byte, salvageScanChunk)
+	for from < size {
+		n, err := r.ReadAt(buf[:min(int64(len(buf)), size-from)], from)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return 0, false, err
+		}
+		if i := bytes.Index(buf[:n], pattern[:]); i >= 0 {
+			return from + int64(i), true, nil
+		}
+		if n < len(pattern) {
+			break
+		}
+		// Step back so a signature split across two chunks is found.
+		from += int64(n - len(pattern) + 1)
+	}
+
+	return 0, false, nil
+}
+
+// readSalvagedEntry parses the local header at off and verifies the entry
+// data. It returns the entry and the offset where the scan continues; ok is
+// false when off does not hold a usable header at all.
+func readSalvagedEntry(r io.ReaderAt, off, size int64, enc NameEncoding) (salvagedEntry, int64, bool, error) {
+	if off+zipLocalHeaderLen > size {
+		return salvagedEntry{}, 0, false, nil
+	}
+
+	header := make([]byte, zipLocalHeaderLen)
+	if _, err := r.ReadAt(header, off); err != nil {
+		return salvagedEntry{}, 0, false, err
+	}
+
+	flags := binary.LittleEndian.Uint16(header[6:])
+	method := binary.LittleEndian.Uint16(header[8:])
+	dosTime := binary.LittleEndian.Uint16(header[10:])
+	dosDate := binary.LittleEndian.Uint16(header[12:])
+	crc := binary.LittleEndian.Uint32(header[14:])
+	compressedSize := int64(binary.LittleEndian.Uint32(header[18:]))
+	uncompressedSize := int64(binary.LittleEndian.Uint32(header[22:]))
+	nameLen := int64(binary.LittleEndian.Uint16(header[26:]))
+	extraLen := int64(binary.LittleEndian.Uint16(header[28:]))
+
+	nameStart := off + zipLocalHeaderLen
+	if nameLen == 0 || nameStart+nameLen > size {
+		return salvagedEntry{}, 0, false, nil
+	}
+
+	fields := make([]byte, min(nameLen+extraLen, size-nameStart))
+	if _, err := r.ReadAt(fields, nameStart); err != nil {
+		return salvagedEntry{}, 0, false, err
+	}
+	rawName := string(fields[:nameLen])
+	extra := fields[nameLen:]
+
+	name := decodeZipText(rawName, flags, enc)
+	if unicodeName, ok := unicodePathExtra(extra, rawName); ok {
+		name = unicodeName
+	}
+
+	entry := salvagedEntry{
+		name:           name,
+		dir:            strings.HasSuffix(normalizeArchiveEntryPath(name), "/"),
+		method:         method,
+		modTime:        localHeaderModTime(dosDate, dosTime, extra),
+		offset:         nameStart + nameLen + extraLen,
+		compressedSize: compressedSize,
+		size:           uncompressedSize,
+	}
+	if entry.offset > size {
+		entry.damaged = fmt.Errorf("%w: local header truncated", errEntryUnrecoverable)
+		return entry, size, true, nil
+	}
+
+	zip64 := false
+	if compressedSize == zip32Marker || uncompressedSize == zip32Marker {
+		if field, ok := zipExtraField(extra, zip64ExtraID); ok && len(field) >= 16 {
+			zip64 = true
+			entry.size = clampSize(binary.LittleEndian.Uint64(field[0:]))
+			entry.compressedSize = clampSize(binary.LittleEndian.Uint64(field[8:]))
+		}
+	}
+
+	switch {
+	case flags&zipFlagEncrypted != 0:
+		entry.damaged = fmt.Errorf("%w: encrypted", errEntryUnrecoverable)
+	case !isCompressionMethodSupported(method):
+		entry.damaged = fmt.Errorf("%w: unsupported compression method %s",
+			errEntryUnrecoverable, compressionMethodName(method))
+	case flags&zipFlagDataDescriptor != 0 && entry.compressedSize == 0 && !entry.dir:
+		return verifyStreamedEntry(r, entry, size, zip64)
+	default:
+		entry.damaged = verifySalvagedEntry(r, entry, size, crc)
+	}
+
+	// The next header follows the data. When the data runs past the end
+	// of the file, nothing after this header is a separate entry.
+	return entry, min(entry.offset+entry.compressedSize, size), true, nil
+}
+
+// verifySalvagedEntry decompresses an entry whose sizes are in its local
+// header and checks the result against the header's size and CRC-32.
+func verifySalvagedEntry(r io.ReaderAt, entry salvagedEntry, size int64, crc uint32) error {
+	if entry.offset+entry.compressedSize > size {
+		return fmt.Errorf("%w: data truncated", errEntryUnrecoverable)
+	}
+
+	rc, err := openSalvagedEntry(r, entry)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errEntryUnrecoverable, err)
+	}
+	defer func() {
+		_ = rc.Close()
+	}()
+
+	h := crc32.NewIEEE()
+	n, err := io.Copy(h, io.LimitReader(rc, entry.size+1))
+	switch {
+	case err != nil:
+		return fmt.Errorf("%w: %w", errEntryUnrecoverable, err)
+	case n != entry.size:
+		return fmt.Errorf("%w: size mismatch", errEntryUnrecoverable)
+	case h.Sum32() != crc:
+		return fmt.Errorf("%w: CRC-32 mismatch", errEntryUnrecoverable)
+	}
+
+	return nil
+}
+
+// verifyStreamedEntry handles an entry whose CRC-32 and sizes follow its
+// data in a data descriptor. Compressed data is decompressed to find where
+// it ends; stored data ends at the first descriptor that matches it. The
+// entry is then checked against the descriptor.
+func verifyStreamedEntry(r io.ReaderAt, entry salvagedEntry, size int64, zip64 bool) (salvagedEntry, int64, bool, error) {
+	if entry.method == zip.Store {
+		return verifyStoredStreamedEntry(r, entry, size, zip64)
+	}
+
+	counter := &countingReader{r: bufio.NewReader(io.NewSectionReader(r, entry.offset, size-entry.offset))}
+	var dec io.ReadCloser
+	if entry.method == deflate64Method {
+		dec = deflate64.NewReader(counter)
+	} else {
+		dec = flate.NewReader(counter)
+	}
+	defer func() {
+		_ = dec.Close()
+	}()
+
+	h := crc32.NewIEEE()
+	n, err := io.Copy(h, dec)
+	if err != nil {
+		entry.damaged = fmt.Errorf("%w: %w", errEntryUnrecoverable, err)
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			// The stream ran into the end of the file.
+			return entry, size, true, nil
+		}
+		return entry, entry.offset, true, nil
+	}
+	entry.compressedSize = counter.n
+	entry.size = n
+
+	descriptorOffset := entry.offset + counter.n
+	descriptor, descriptorLen, err := readDataDescriptor(r, descriptorOffset, size, zip64)
+	if err != nil {
+		return entry, 0, false, err
+	}
+	switch {
+	case descriptorLen == 0:
+		entry.damaged = fmt.Errorf("%w: data truncated", errEntryUnrecoverable)
+		return entry, size, true, nil
+	case descriptor.crc != h.Sum32():
+		entry.damaged = fmt.Errorf("%w: CRC-32 mismatch", errEntryUnrecoverable)
+	case descriptor.compressedSize != uint64(counter.n)&descriptor.sizeMask ||
+		descriptor.size != uint64(n)&descriptor.sizeMask:
+		entry.damaged = fmt.Errorf("%w: size mismatch", errEntryUnrecoverable)
+	}
+
+	return entry, descriptorOffset + descriptorLen, true, nil
+}
+
+// verifyStoredStreamedEntry finds the end of a stored entry written with a
+// data descriptor by trying each descriptor signature after its data start
+// until one records the distance to it as the size and a CRC-32 that
+// matches the data. Descriptors written without the signature cannot be
+// found this way.
+func verifyStoredStreamedEntry(r io.ReaderAt, entry salvagedEntry, size int64, zip64 bool) (salvagedEntry, int64, bool, error) {
+	for from := entry.offset; ; {
+		off, found, err := nextSignature(r, zipDataDescriptorSignature, from, size)
+		if err != nil {
+			return entry, 0, false, err
+		}
+		if !found {
+			entry.damaged = fmt.Errorf("%w: data truncated", errEntryUnrecoverable)
+			entry.compressedSize, entry.size = 0, 0
+			return entry, size, true, nil
+		}
+
+		descriptor, descriptorLen, err := readDataDescriptor(r, off, size, zip64)
+		if err != nil {
+			return entry, 0, false, err
+		}
+		length := uint64(off - entry.offset)
+		if descriptorLen > 0 && descriptor.compressedSize == length&descriptor.sizeMask && descriptor.size == descriptor.compressedSize {
+			candidate := entry
+			candidate.compressedSize = off - entry.offset
+			candidate.size = candidate.compressedSize
+			if verifySalvagedEntry(r, candidate, size, descriptor.crc) == nil {
+				return candidate, off + descriptorLen, true, nil
+			}
+		}
+
+		from = off + 1
+	}
+}
+
+// dataDescriptor holds the fields of a data descriptor. sizeMask keeps the
+// low 32 bits of an actual size when the descriptor stores 32-bit sizes.
+type dataDescriptor struct {
+	crc            uint32
+	compressedSize uint64
+	size           uint64
+	sizeMask       uint64
+}
+
+// readDataDescriptor reads the data descriptor at off, with or without its
+// optional signature. It returns a length of 0 when the file ends first.
+func readDataDescriptor(r io.ReaderAt, off, size int64, zip64 bool) (dataDescriptor, int64, error) {
+	buf := make([]byte, min(24, max(size-off, 0)))
+	if _, err := r.ReadAt(buf, off); err != nil && !errors.Is(err, io.EOF) {
+		return dataDescriptor{}, 0, err
+	}
+
+	var start int64
+	if len(buf) >= 4 && binary.LittleEndian.Uint32(buf) == zipDataDescriptorSignature {
+		start = 4
+	}
+
+	sizeLen := int64(4)
+	if zip64 {
+		sizeLen = 8
+	}
+	descriptorLen := start + 4 + 2*sizeLen
+	if int64(len(buf)) < descriptorLen {
+		return dataDescriptor{}, 0, nil
+	}
+
+	fields := buf[start:descriptorLen]
+	d := dataDescriptor{crc: binary.LittleEndian.Uint32(fields), sizeMask: zip32Marker}
+	if zip64 {
+		d.compressedSize = binary.LittleEndian.Uint64(fields[4:])
+		d.size = binary.LittleEndian.Uint64(fields[12:])
+		d.sizeMask = ^uint64(0)
+	} else {
+		d.compressedSize = uint64(binary.LittleEndian.Uint32(fields[4:]))
+		d.size = uint64(binary.LittleEndian.Uint32(fields[8:]))
+	}
+
+	return d, descriptorLen, nil
+}
+
+// openSalvagedEntry returns the decompressed data of a verified entry.
+func openSalvagedEntry(r io.ReaderAt, entry salvagedEntry) (io.ReadCloser, error) {
+	return zipDecompressor(entry.method, io.NewSectionReader(r, entry.offset, entry.compressedSize))
+}
+
+// countingReader counts the bytes read through it, including single bytes
+// read by decompressors that use [io.ByteReader] to avoid reading ahead.
+type countingReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
+}
+
+// localHeaderModTime returns the modification time of a local header: the
+// Unix time of an extended timestamp extra field when present, and the
+// MS-DOS date and time otherwise, read as UTC like archive/zip does.
+func localHeaderModTime(dosDate, dosTime uint16, extra []byte) time.Time {
+	if field, ok := zipExtraField(extra, zipExtendedTimestampExtraID); ok && len(field) >= 5 && field[0]&1 != 0 {
+		return time.Unix(int64(int32(binary.LittleEndian.Uint32(field[1:]))), 0)
+	}
+	if dosDate == 0 {
+		return time.Time{}
+	}
+
+	return time.Date(
+		int(dosDate>>9)+1980,
+		time.Month(dosDate>>5&0xf),
+		int(dosDate&0x1f),
+		int(dosTime>>11),
+		int(dosTime>>5&0x3f),
+		int(dosTime&0x1f)*2,
+		0,
+		time.UTC,
+	)
+}
+
+// zipExtraField returns the data of the first extra field with the given ID.
+func zipExtraField(extra []byte, id uint16) ([]byte, bool) {
+	for len(extra) >= 4 {
+		fieldID := binary.LittleEndian.Uint16(extra[0:2])
+		size := int(binary.LittleEndian.Uint16(extra[2:4]))
+		extra = extra[4:]
+		if size > len(extra) {
+			return nil, false
+		}
+		if fieldID == id {
+			return extra[:size], true
+		}
+		extra = extra[size:]
+	}
+
+	return nil, false
+}
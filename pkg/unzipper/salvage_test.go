@@ -0,0 +1,216 @@
+package unzipper
+
+import (
+	"archive/zip"
+	"bytes"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"btidy/pkg/safepath"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// salvageFixture holds the body of a zip archive without its central
+// directory, and the offset half-way through each entry's data.
+type salvageFixture struct {
+	data    []byte
+	middles []int
+}
+
+// buildStreamedZip writes members with archive/zip, which streams every
+// file with a data descriptor, and drops the central directory.
+func buildStreamedZip(t *testing.T, method uint16, members []zipMember) salvageFixture {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, m := range members {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: m.name, Method: method})
+		require.NoError(t, err)
+		_, err = w.Write([]byte(m.body))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	middles := make([]int, 0, len(zr.File))
+	for _, f := range zr.File {
+		offset, offsetErr := f.DataOffset()
+		require.NoError(t, offsetErr)
+		middles = append(middles, int(offset)+int(f.CompressedSize64)/2)
+	}
+
+	return salvageFixture{data: withoutCentralDirectory(t, buf.Bytes()), middles: middles}
+}
+
+// buildRawZip writes stored members whose CRC-32 and sizes are in the
+// local headers, as most desktop zip tools do, and drops the central
+// directory.
+func buildRawZip(t *testing.T, members []zipMember) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, m := range members {
+		w, err := zw.CreateRaw(&zip.FileHeader{
+			Name:               m.name,
+			Method:             zip.Store,
+			CRC32:              crc32.ChecksumIEEE([]byte(m.body)),
+			CompressedSize64:   uint64(len(m.body)),
+			UncompressedSize64: uint64(len(m.body)),
+		})
+		require.NoError(t, err)
+		_, err = w.Write([]byte(m.body))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+
+	return withoutCentralDirectory(t, buf.Bytes())
+}
+
+// withoutCentralDirectory cuts a zip archive just before its central
+// directory.
+func withoutCentralDirectory(t *testing.T, whole []byte) []byte {
+	t.Helper()
+
+	dirStart := bytes.Index(whole, []byte{0x50, 0x4b, 0x01, 0x02})
+	require.Positive(t, dirStart)
+	return whole[:dirStart]
+}
+
+func runExtraction(t *testing.T, root string, dryRun bool, opts ...Option) Result {
+	t.Helper()
+
+	v, err := safepath.New(root)
+	require.NoError(t, err)
+	uz, err := NewWithValidator(v, dryRun, nil, opts...)
+	require.NoError(t, err)
+
+	files, err := getAllFilesRecursively(t.Context(), root)
+	require.NoError(t, err)
+
+	result, err := uz.ExtractArchivesWithProgressRecursively(t.Context(), files, nil)
+	require.NoError(t, err)
+	return result
+}
+
+func TestSalvageTruncatedArchive(t *testing.T) {
+	members := []zipMember{
+		{name: "docs/a.txt", body: strings.Repeat("alpha ", 200)},
+		{name: "docs/b.txt", body: strings.Repeat("bravo ", 200)},
+		{name: "c.txt", body: strings.Repeat("charlie ", 200)},
+	}
+
+	for _, method := range []uint16{zip.Deflate, zip.Store} {
+		t.Run(compressionMethodName(method), func(t *testing.T) {
+			fixture := buildStreamedZip(t, method, members)
+			root := t.TempDir()
+			archivePath := filepath.Join(root, "backup.zip")
+			// Cut the copy half-way through the second entry.
+			require.NoError(t, os.WriteFile(archivePath, fixture.data[:fixture.middles[1]], 0o644))
+
+			result := runExtraction(t, root, false, WithSalvage(true))
+			assert.Equal(t, 1, result.ExtractedArchives)
+			assert.Equal(t, 1, result.SalvagedArchives)
+			assert.Zero(t, result.DeletedArchives)
+			require.Len(t, result.Operations, 1)
+
+			op := result.Operations[0]
+			assert.True(t, op.Salvaged)
+			assert.False(t, op.DeletedArchive)
+			assert.Equal(t, 1, op.ExtractedFiles)
+			assert.Equal(t, 1, op.SkippedEntries)
+			require.Len(t, op.EntryErrors, 1)
+			assert.Contains(t, op.EntryErrors[0], "docs/b.txt: "+errEntryUnrecoverable.Error())
+
+			content, err := os.ReadFile(filepath.Join(root, "docs", "a.txt"))
+			require.NoError(t, err)
+			assert.Equal(t, members[0].body, string(content))
+			assert.NoFileExists(t, filepath.Join(root, "docs", "b.txt"))
+			assert.FileExists(t, archivePath, "a salvaged archive is never deleted")
+		})
+	}
+
+	t.Run("missing central directory only", func(t *testing.T) {
+		fixture := buildStreamedZip(t, zip.Deflate, members)
+		root := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(root, "backup.zip"), fixture.data, 0o644))
+
+		result := runExtraction(t, root, false, WithSalvage(true))
+		require.Len(t, result.Operations, 1)
+		assert.Equal(t, 3, result.Operations[0].ExtractedFiles)
+		assert.Zero(t, result.Operations[0].SkippedEntries)
+		assert.FileExists(t, filepath.Join(root, "backup.zip"))
+	})
+
+	t.Run("ignored without salvage mode", func(t *testing.T) {
+		fixture := buildStreamedZip(t, zip.Deflate, members)
+		root := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(root, "backup.zip"), fixture.data, 0o644))
+
+		result := runExtraction(t, root, false)
+		assert.Zero(t, result.ArchivesFound)
+		assert.NoFileExists(t, filepath.Join(root, "docs", "a.txt"))
+	})
+
+	t.Run("dry run reports without writing", func(t *testing.T) {
+		fixture := buildStreamedZip(t, zip.Deflate, members)
+		root := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(root, "backup.zip"), fixture.data[:fixture.middles[2]], 0o644))
+
+		result := runExtraction(t, root, true, WithSalvage(true))
+		require.Len(t, result.Operations, 1)
+		assert.True(t, result.Operations[0].Salvaged)
+		assert.Equal(t, 2, result.Operations[0].ExtractedFiles)
+		assert.Equal(t, 1, result.Operations[0].SkippedEntries)
+		assert.NoFileExists(t, filepath.Join(root, "docs", "a.txt"))
+	})
+}
+
+func TestSalvageSkipsCorruptEntries(t *testing.T) {
+	members := []zipMember{
+		{name: "first.txt", body: "first entry"},
+		{name: "second.txt", body: "second entry"},
+		{name: "third.txt", body: "third entry"},
+	}
+	data := buildRawZip(t, members)
+
+	// Flip a byte inside the second entry's data.
+	i := bytes.Index(data, []byte("second entry"))
+	require.Positive(t, i)
+	data[i] ^= 0xff
+
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "backup.zip"), data, 0o644))
+
+	result := runExtraction(t, root, false, WithSalvage(true))
+	require.Len(t, result.Operations, 1)
+	op := result.Operations[0]
+	assert.Equal(t, 2, op.ExtractedFiles)
+	require.Len(t, op.EntryErrors, 1)
+	assert.Contains(t, op.EntryErrors[0], "second.txt")
+	assert.Contains(t, op.EntryErrors[0], "CRC-32 mismatch")
+
+	assert.FileExists(t, filepath.Join(root, "first.txt"))
+	assert.NoFileExists(t, filepath.Join(root, "second.txt"))
+	content, err := os.ReadFile(filepath.Join(root, "third.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "third entry", string(content))
+}
+
+func TestSalvageLeavesIntactArchivesAlone(t *testing.T) {
+	root := t.TempDir()
+	writeZipWithEntries(t, filepath.Join(root, "ok.zip"), map[string][]byte{"ok.txt": []byte("ok")})
+
+	result := runExtraction(t, root, false, WithSalvage(true))
+	require.Len(t, result.Operations, 1)
+	assert.False(t, result.Operations[0].Salvaged)
+	assert.True(t, result.Operations[0].DeletedArchive)
+	assert.Zero(t, result.SalvagedArchives)
+}
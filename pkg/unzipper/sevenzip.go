@@ -0,0 +1,281 @@
+package unzipper
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+
+	"btidy/pkg/sevenzip"
+)
+
+// errNotSevenZipArchive is returned by [openSevenZipArchive] when the file
+// does not start with the 7z signature.
+var errNotSevenZipArchive = errors.New("not a 7z archive")
+
+// sevenZipSignature is the first six bytes of every 7z archive.
+var sevenZipSignature = []byte{'7', 'z', 0xbc, 0xaf, 0x27, 0x1c}
+
+// sevenZipArchive is a 7z archive read with [sevenzip.Reader]. Members of
+// a solid block are decoded in one pass, so visiting them in archive order
+// reads the data once.
+type sevenZipArchive struct {
+	src    io.ReaderAt
+	size   int64
+	reader *sevenzip.Reader
+
+	// closeFn releases src.
+	closeFn func() error
+
+	// passwords are the candidates for encrypted archives; password is
+	// the one reader was opened with.
+	passwords []string
+	password  string
+
+	unlocked  bool
+	unlockErr error
+}
+
+// openSevenZipArchive opens filePath as a 7z archive. An archive whose
+// header is encrypted is opened with the first of passwords that decrypts
+// it. Returns an error wrapping [errNotSevenZipArchive] when the signature
+// does not match.
+func openSevenZipArchive(filePath string, passwords []string) (*sevenZipArchive, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	a, err := newSevenZipArchive(f, info.Size(), f.Close, passwords)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// newSevenZipArchive reads a 7z archive of size bytes from src, which
+// closeFn releases when the archive is closed. See [openSevenZipArchive].
+func newSevenZipArchive(src io.ReaderAt, size int64, closeFn func() error, passwords []string) (*sevenZipArchive, error) {
+	magic := make([]byte, len(sevenZipSignature))
+	if _, err := src.ReadAt(magic, 0); err != nil || !bytes.Equal(magic, sevenZipSignature) {
+		return nil, errNotSevenZipArchive
+	}
+
+	a := &sevenZipArchive{src: src, size: size, closeFn: closeFn, passwords: passwords}
+	var err error
+	a.reader, err = sevenzip.NewReader(src, size, "")
+	if errors.Is(err, sevenzip.ErrPasswordRequired) {
+		a.reader, err = a.openEncryptedHeader()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// openEncryptedHeader reads an archive whose file list is encrypted with
+// the first supplied password that decrypts it.
+func (a *sevenZipArchive) openEncryptedHeader() (*sevenzip.Reader, error) {
+	if len(a.passwords) == 0 {
+		return nil, fmt.Errorf("%w: the archive header is encrypted", errEncryptedEntries)
+	}
+
+	for _, password := range a.passwords {
+		r, err := sevenzip.NewReader(a.src, a.size, password)
+		if err == nil {
+			a.password = password
+			return r, nil
+		}
+		if !errors.Is(err, sevenzip.ErrPassword) {
+			return nil, err
+		}
+	}
+
+	return nil, fmt.Errorf("%w: the archive header", errWrongPassword)
+}
+
+// validate implements [archiveSource] by rejecting members stored with a
+// coder this package cannot decode, and encrypted members that none of the
+// supplied passwords decrypts.
+func (a *sevenZipArchive) validate() error {
+	if err := a.reader.CheckMethods(); err != nil {
+		return err
+	}
+
+	return a.unlock()
+}
+
+// unlock finds the password that decrypts every encrypted member and
+// reopens the archive with it. 7-Zip encrypts a whole archive with one
+// password, so, unlike zip, a single password must fit every member. The
+// result is computed once per archive.
+func (a *sevenZipArchive) unlock() error {
+	if a.unlocked {
+		return a.unlockErr
+	}
+	a.unlocked = true
+	a.unlockErr = a.findPassword()
+
+	return a.unlockErr
+}
+
+func (a *sevenZipArchive) findPassword() error {
+	var encrypted []string
+	for _, f := range a.reader.File {
+		if f.Encrypted() {
+			encrypted = append(encrypted, f.Name)
+		}
+	}
+
+	switch {
+	case len(encrypted) == 0:
+		return nil
+	case len(a.passwords) == 0:
+		return fmt.Errorf("%w: %s", errEncryptedEntries, strings.Join(encrypted, ", "))
+	}
+
+	candidates := a.passwords
+	if a.password != "" {
+		candidates = append([]string{a.password}, a.passwords...)
+	}
+
+	for _, password := range candidates {
+		r := a.reader
+		if password != a.password {
+			var err error
+			if r, err = sevenzip.NewReader(a.src, a.size, password); err != nil {
+				continue
+			}
+		}
+
+		if verifySevenZipPassword(r) {
+			a.reader = r
+			a.password = password
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: %s", errWrongPassword, strings.Join(encrypted, ", "))
+}
+
+// verifySevenZipPassword reports whether every encrypted member of r
+// decrypts and matches its CRC-32.
+func verifySevenZipPassword(r *sevenzip.Reader) bool {
+	for _, f := range r.File {
+		if !f.Encrypted() {
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return false
+		}
+		_, err = io.Copy(io.Discard, rc)
+		_ = rc.Close()
+		if err != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// open returns the body of member i, first finding the password when the
+// member is encrypted. Finding it may reopen the archive, so members are
+// looked up by index rather than held across the call.
+func (a *sevenZipArchive) open(i int) (io.ReadCloser, error) {
+	if a.reader.File[i].Encrypted() {
+		if err := a.unlock(); err != nil {
+			return nil, err
+		}
+	}
+
+	return a.reader.File[i].Open()
+}
+
+// walk implements [archiveSource] for 7z archives. Links are stored like
+// in zip, as members whose body is the link text.
+func (a *sevenZipArchive) walk(fn func(archiveEntry) error) error {
+	for i, f := range a.reader.File {
+		name := trimTarCurrentDirPrefix(f.Name)
+		if name == "" || name == "." {
+			continue
+		}
+
+		open := func() (io.ReadCloser, error) {
+			return a.open(i)
+		}
+
+		entry := archiveEntry{
+			name:    name,
+			kind:    entryKindFile,
+			mode:    f.Mode().Perm(),
+			modTime: f.Modified,
+			open:    open,
+
+			method: f.Method(),
+			crc32:  f.CRC32,
+			hasCRC: f.HasCRC,
+		}
+
+		switch mode := f.Mode(); {
+		case f.IsDir:
+			entry.kind = entryKindDir
+		case mode&fs.ModeSymlink != 0:
+			target, err := sevenZipLinkTarget(open)
+			if err != nil {
+				return fmt.Errorf("failed to read symlink target of %s: %w", f.Name, err)
+			}
+			entry.kind = entryKindSymlink
+			entry.linkTarget = target
+		case mode.Type() != 0:
+			entry.kind = entryKindOther
+		default:
+			entry.size = clampSize(f.Size)
+		}
+
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sevenZipLinkTarget reads the link text stored as a symlink member's body.
+func sevenZipLinkTarget(open func() (io.ReadCloser, error)) (string, error) {
+	rc, err := open()
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = rc.Close()
+	}()
+
+	data, err := io.ReadAll(io.LimitReader(rc, maxSymlinkTargetLen+1))
+	if err != nil {
+		return "", err
+	}
+	if len(data) > maxSymlinkTargetLen {
+		return "", errSymlinkTargetTooLong
+	}
+
+	return string(data), nil
+}
+
+// Close implements [archiveSource].
+func (a *sevenZipArchive) Close() error {
+	return a.closeFn()
+}
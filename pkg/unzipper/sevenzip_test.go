@@ -0,0 +1,259 @@
+package unzipper
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"btidy/internal/testutil"
+	"btidy/pkg/collector"
+	"btidy/pkg/metadata"
+	"btidy/pkg/safepath"
+	"btidy/pkg/trash"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sevenZipFixture() []testutil.SevenZipEntry {
+	return []testutil.SevenZipEntry{
+		{Name: "docs", Dir: true},
+		{Name: "docs/readme.txt", Body: []byte(strings.Repeat("read me ", 200))},
+		{Name: "top.txt", Body: []byte("top level")},
+		{Name: "run.sh", Body: []byte("#!/bin/sh\n"), Mode: 0o755},
+		{Name: "latest", Body: []byte("docs/readme.txt"), Mode: fs.ModeSymlink | 0o777},
+	}
+}
+
+func TestExtractSevenZipArchives(t *testing.T) {
+	tests := []struct {
+		name string
+		opts testutil.SevenZipOptions
+	}{
+		{name: "stored", opts: testutil.SevenZipOptions{Method: testutil.SevenZipCopy}},
+		{name: "lzma", opts: testutil.SevenZipOptions{Method: testutil.SevenZipLZMA}},
+		{name: "solid lzma2", opts: testutil.SevenZipOptions{Solid: true, CompressHeader: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			archivePath := filepath.Join(root, "backup.7z")
+			testutil.WriteSevenZip(t, archivePath, sevenZipFixture(), tt.opts)
+
+			result := runExtraction(t, root, false, WithSymlinkPolicy(SymlinksCreate))
+			assert.Equal(t, 1, result.ExtractedArchives)
+			assert.Equal(t, 1, result.DeletedArchives)
+			assert.Zero(t, result.ErrorCount)
+			assert.Equal(t, 4, result.ExtractedFiles)
+			assert.Equal(t, 1, result.ExtractedDirs)
+
+			content, err := os.ReadFile(filepath.Join(root, "docs", "readme.txt"))
+			require.NoError(t, err)
+			assert.Equal(t, strings.Repeat("read me ", 200), string(content))
+
+			info, err := os.Stat(filepath.Join(root, "run.sh"))
+			require.NoError(t, err)
+			assert.Equal(t, fs.FileMode(0o755), info.Mode().Perm())
+			assert.True(t, info.ModTime().Equal(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)))
+
+			target, err := os.Readlink(filepath.Join(root, "latest"))
+			require.NoError(t, err)
+			assert.Equal(t, "docs/readme.txt", target)
+
+			assert.NoFileExists(t, archivePath)
+		})
+	}
+}
+
+func TestExtractSevenZipTrashesOverwrittenFiles(t *testing.T) {
+	root := t.TempDir()
+	testutil.WriteSevenZip(t, filepath.Join(root, "backup.7z"), []testutil.SevenZipEntry{
+		{Name: "notes.txt", Body: []byte("from archive")},
+	}, testutil.SevenZipOptions{})
+	require.NoError(t, os.WriteFile(filepath.Join(root, "notes.txt"), []byte("local edit"), 0o644))
+
+	v, err := safepath.New(root)
+	require.NoError(t, err)
+	metaDir, err := metadata.Init(root, v)
+	require.NoError(t, err)
+	trasher, err := trash.New(metaDir, "7z-run", v)
+	require.NoError(t, err)
+	uz, err := NewWithValidator(v, false, trasher)
+	require.NoError(t, err)
+	files, err := getAllFilesRecursively(t.Context(), root)
+	require.NoError(t, err)
+
+	result, err := uz.ExtractArchivesWithProgressRecursively(t.Context(), files, nil)
+	require.NoError(t, err)
+	require.Len(t, result.Operations, 1)
+	op := result.Operations[0]
+	require.Len(t, op.ReplacedFiles, 1)
+	assert.FileExists(t, op.TrashedTo, "the archive is trashed, not deleted")
+
+	content, err := os.ReadFile(filepath.Join(root, "notes.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "from archive", string(content))
+
+	trashed, err := os.ReadFile(op.ReplacedFiles[0].TrashedTo)
+	require.NoError(t, err)
+	assert.Equal(t, "local edit", string(trashed))
+}
+
+func TestInspectSevenZipArchive(t *testing.T) {
+	root := t.TempDir()
+	archivePath := filepath.Join(root, "preview.7z")
+	testutil.WriteSevenZip(t, archivePath, sevenZipFixture(), testutil.SevenZipOptions{Solid: true})
+
+	result := runExtraction(t, root, true, WithSymlinkPolicy(SymlinksCreate))
+	require.Len(t, result.Operations, 1)
+	op := result.Operations[0]
+	assert.Equal(t, 1, op.ExtractedDirs)
+	assert.Equal(t, 4, op.ExtractedFiles)
+
+	assert.FileExists(t, archivePath)
+	assert.NoDirExists(t, filepath.Join(root, "docs"))
+}
+
+func TestSevenZipWithUnsupportedMethodIsSkipped(t *testing.T) {
+	for _, dryRun := range []bool{false, true} {
+		root := t.TempDir()
+		archivePath := filepath.Join(root, "ppmd.7z")
+		testutil.WriteSevenZip(t, archivePath, sevenZipFixture(), testutil.SevenZipOptions{
+			Method:    testutil.SevenZipCopy,
+			RawMethod: []byte{0x03, 0x04, 0x01},
+		})
+
+		result := runExtraction(t, root, dryRun)
+		assert.Zero(t, result.ExtractedArchives)
+		assert.Zero(t, result.ErrorCount)
+		assert.Equal(t, 1, result.SkippedCount)
+		require.Len(t, result.Operations, 1)
+		assert.True(t, result.Operations[0].Skipped)
+		assert.Contains(t, result.Operations[0].SkipReason, "PPMd")
+
+		assert.FileExists(t, archivePath)
+		assert.NoDirExists(t, filepath.Join(root, "docs"))
+	}
+}
+
+func TestEncryptedSevenZipArchives(t *testing.T) {
+	entries := sevenZipFixture()
+
+	assertKept := func(t *testing.T, root string, result Result, reason string) {
+		t.Helper()
+
+		assert.Zero(t, result.ExtractedArchives)
+		assert.Zero(t, result.ErrorCount)
+		assert.Equal(t, 1, result.SkippedCount)
+		require.Len(t, result.Operations, 1)
+		assert.True(t, result.Operations[0].Skipped)
+		assert.Contains(t, result.Operations[0].SkipReason, reason)
+
+		assert.FileExists(t, filepath.Join(root, "locked.7z"))
+		assert.NoDirExists(t, filepath.Join(root, "docs"))
+		assert.NoFileExists(t, filepath.Join(root, "top.txt"))
+	}
+
+	for _, encryptHeader := range []bool{false, true} {
+		opts := testutil.SevenZipOptions{Password: "correct horse", Solid: true, EncryptHeader: encryptHeader}
+		name := "encrypted data"
+		if encryptHeader {
+			name = "encrypted header"
+		}
+
+		t.Run(name, func(t *testing.T) {
+			t.Run("without passwords", func(t *testing.T) {
+				root := t.TempDir()
+				testutil.WriteSevenZip(t, filepath.Join(root, "locked.7z"), entries, opts)
+
+				result := runExtraction(t, root, false)
+				assertKept(t, root, result, errEncryptedEntries.Error())
+			})
+
+			t.Run("wrong password extracts nothing", func(t *testing.T) {
+				root := t.TempDir()
+				testutil.WriteSevenZip(t, filepath.Join(root, "locked.7z"), entries, opts)
+
+				result := runExtraction(t, root, false, WithPasswords([]string{"wrong"}))
+				assertKept(t, root, result, errWrongPassword.Error())
+			})
+
+			t.Run("with the password", func(t *testing.T) {
+				root := t.TempDir()
+				testutil.WriteSevenZip(t, filepath.Join(root, "locked.7z"), entries, opts)
+
+				result := runExtraction(t, root, false, WithPasswords([]string{"wrong", "correct horse"}))
+				assert.Equal(t, 1, result.ExtractedArchives)
+				assert.Zero(t, result.ErrorCount)
+
+				content, err := os.ReadFile(filepath.Join(root, "top.txt"))
+				require.NoError(t, err)
+				assert.Equal(t, "top level", string(content))
+				assert.NoFileExists(t, filepath.Join(root, "locked.7z"))
+			})
+		})
+	}
+}
+
+func TestExtractSevenZipRejectsTraversal(t *testing.T) {
+	root := t.TempDir()
+	archivePath := filepath.Join(root, "evil.7z")
+	testutil.WriteSevenZip(t, archivePath, []testutil.SevenZipEntry{
+		{Name: "../escape.txt", Body: []byte("nope")},
+	}, testutil.SevenZipOptions{})
+
+	uz, err := New(root, false)
+	require.NoError(t, err)
+	files, err := getAllFilesRecursively(t.Context(), root)
+	require.NoError(t, err)
+
+	_, err = uz.ExtractArchivesWithProgressRecursively(t.Context(), files, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "contains path traversal")
+
+	assert.FileExists(t, archivePath)
+	assert.NoFileExists(t, filepath.Join(filepath.Dir(root), "escape.txt"))
+}
+
+func TestExtractZipNestedInSevenZip(t *testing.T) {
+	root := t.TempDir()
+
+	innerPath := filepath.Join(t.TempDir(), "inner.zip")
+	writeZipWithEntries(t, innerPath, map[string][]byte{"inner.txt": []byte("from zip")})
+	inner, err := os.ReadFile(innerPath)
+	require.NoError(t, err)
+
+	testutil.WriteSevenZip(t, filepath.Join(root, "outer.7z"), []testutil.SevenZipEntry{
+		{Name: "bundle.zip", Body: inner},
+	}, testutil.SevenZipOptions{})
+
+	result := runExtraction(t, root, false)
+	assert.Equal(t, 2, result.ExtractedArchives)
+	assert.Zero(t, result.ErrorCount)
+
+	content, err := os.ReadFile(filepath.Join(root, "inner.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "from zip", string(content))
+	assert.NoFileExists(t, filepath.Join(root, "bundle.zip"))
+	assert.NoFileExists(t, filepath.Join(root, "outer.7z"))
+}
+
+func TestIsArchiveRecognizesSevenZip(t *testing.T) {
+	root := t.TempDir()
+
+	plain := filepath.Join(root, "plain.7z")
+	testutil.WriteSevenZip(t, plain, sevenZipFixture(), testutil.SevenZipOptions{})
+	assert.True(t, isArchive(plain))
+
+	hidden := filepath.Join(root, "hidden.7z")
+	testutil.WriteSevenZip(t, hidden, sevenZipFixture(), testutil.SevenZipOptions{Password: "pw", EncryptHeader: true})
+	assert.True(t, isArchive(hidden), "an encrypted header is reported, not ignored")
+
+	op, err := unzip(collector.FileInfo{Dir: root, Name: "hidden.7z", Path: hidden})
+	require.ErrorIs(t, err, errEncryptedEntries)
+	assert.Zero(t, op.ExtractedFiles)
+}
@@ -0,0 +1,510 @@
+// Split ("spanned") zip archives are written as numbered volumes, name.z01,
+// name.z02, ..., followed by name.zip, which holds the central directory.
+// Offsets in the central directory are relative to the volume ("disk") a
+// record starts on, so archive/zip cannot read the volumes even when they
+// are concatenated. openSplitArchive concatenates them behind an
+// [io.ReaderAt] and appends a rewritten central directory whose offsets are
+// absolute, so archive/zip sees one ordinary single-disk archive.
+//
+// Reference: PKZIP APPNOTE.TXT §8, "Splitting and Spanning ZIP files".
+package unzipper
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// Central directory file header signature (PKZIP spec §4.3.12).
+	zipCentralDirSignature = 0x02014b50
+
+	// Fixed size in bytes of a central directory file header.
+	zipCentralDirHeaderLen = 46
+
+	// ZIP64 End of Central Directory record signature (PKZIP spec §4.3.14).
+	zip64EndOfCentralDirSignature = 0x06064b50
+
+	// Fixed size in bytes of the ZIP64 End of Central Directory record.
+	zip64EndOfCentralDirLen = 56
+
+	// ZIP64 extended information extra field ID (PKZIP spec §4.5.3).
+	zip64ExtraID = 0x0001
+
+	// maxSplitVolumes bounds the volume count read from a damaged tail.
+	maxSplitVolumes = 9999
+)
+
+// errSplitVolumeMissing is returned when a volume of a split zip archive is
+// not next to its .zip tail. Such archives are skipped, never extracted in
+// part.
+var errSplitVolumeMissing = errors.New("split archive volume missing")
+
+// splitArchiveParts returns the volumes that precede the split zip tail at
+// tailPath, in order (name.z01, name.z02, ...). It returns nil for files
+// that are not the tail of a split archive, and an error wrapping
+// [errSplitVolumeMissing] that names every missing volume.
+func splitArchiveParts(tailPath string) ([]string, error) {
+	ext := filepath.Ext(tailPath)
+	if !strings.EqualFold(ext, ".zip") {
+		return nil, nil
+	}
+
+	// A tail that cannot be parsed is left to the regular zip reader,
+	// which reports the problem itself.
+	disks, err := splitDiskCount(tailPath)
+	if err != nil {
+		slog.Debug("split archive check failed", "path", tailPath, "error", err)
+		return nil, nil
+	}
+	if disks <= 1 {
+		return nil, nil
+	}
+
+	stem := strings.TrimSuffix(tailPath, ext)
+	parts := make([]string, 0, disks-1)
+	var missing []string
+	for i := 1; i < disks; i++ {
+		part, ok := findSplitPart(stem, ext, i)
+		if !ok {
+			missing = append(missing, filepath.Base(part))
+			continue
+		}
+		parts = append(parts, part)
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("%w: %s", errSplitVolumeMissing, strings.Join(missing, ", "))
+	}
+
+	return parts, nil
+}
+
+// findSplitPart returns the path of volume i for stem, matching the case of
+// the tail's extension first (".Z01" next to ".ZIP"). When no volume exists,
+// the expected path is returned with ok == false.
+func findSplitPart(stem, tailExt string, i int) (string, bool) {
+	name := fmt.Sprintf("%s.z%02d", stem, i)
+	candidates := []string{name, fmt.Sprintf("%s.Z%02d", stem, i)}
+	if tailExt == strings.ToUpper(tailExt) {
+		candidates[0], candidates[1] = candidates[1], candidates[0]
+	}
+
+	for _, candidate := range candidates {
+		if info, err := os.Stat(candidate); err == nil && info.Mode().IsRegular() {
+			return candidate, true
+		}
+	}
+
+	return name, false
+}
+
+// splitDiskCount returns the number of volumes recorded in the end of
+// central directory of the zip file at path: 1 for an ordinary archive, 0
+// when no end record is found.
+func splitDiskCount(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	end, found, err := readSplitDirectoryEnd(f, info.Size())
+	if err != nil || !found {
+		return 0, err
+	}
+	if end.disk >= maxSplitVolumes {
+		return 0, fmt.Errorf("implausible split volume number %d", end.disk)
+	}
+
+	return int(end.disk) + 1, nil
+}
+
+// splitDirectoryEnd holds what the end of central directory records of a
+// split tail say about the central directory.
+type splitDirectoryEnd struct {
+	// disk is the number of the last volume, which holds the end record.
+	disk uint32
+
+	// dirDisk is the volume the central directory starts on, and
+	// dirOffset its offset within that volume.
+	dirDisk   uint32
+	dirOffset uint64
+
+	dirSize uint64
+	records uint64
+}
+
+// readSplitDirectoryEnd parses the end of central directory record of the
+// volume r, including the ZIP64 record when the standard one only holds
+// markers. The ZIP64 record must be on the same volume.
+func readSplitDirectoryEnd(r io.ReaderAt, size int64) (splitDirectoryEnd, bool, error) {
+	eocdOffset, found, err := findZipEndOfCentralDirectory(r, size)
+	if err != nil || !found {
+		return splitDirectoryEnd{}, false, err
+	}
+
+	eocd := make([]byte, zipEndOfCentralDirLen)
+	if _, err := r.ReadAt(eocd, eocdOffset); err != nil {
+		return splitDirectoryEnd{}, false, err
+	}
+
+	end := splitDirectoryEnd{
+		disk:      uint32(binary.LittleEndian.Uint16(eocd[4:6])),
+		dirDisk:   uint32(binary.LittleEndian.Uint16(eocd[6:8])),
+		records:   uint64(binary.LittleEndian.Uint16(eocd[10:12])),
+		dirSize:   uint64(binary.LittleEndian.Uint32(eocd[12:16])),
+		dirOffset: uint64(binary.LittleEndian.Uint32(eocd[16:20])),
+	}
+
+	if !zipEndOfCentralDirectoryRequiresZip64(eocd) && end.disk != zip16Marker && end.dirDisk != zip16Marker {
+		return end, true, nil
+	}
+
+	locator := make([]byte, zip64EndOfCentralDirLocatorLen)
+	locatorOffset := eocdOffset - zip64EndOfCentralDirLocatorLen
+	if locatorOffset < 0 {
+		return end, true, nil
+	}
+	if _, err := r.ReadAt(locator, locatorOffset); err != nil {
+		return splitDirectoryEnd{}, false, err
+	}
+	if binary.LittleEndian.Uint32(locator[0:4]) != zip64EndOfCentralDirLocSignature {
+		return end, true, nil
+	}
+
+	// The locator gives the ZIP64 record's offset within its own volume;
+	// writers put it on the last volume together with the standard record.
+	record := make([]byte, zip64EndOfCentralDirLen)
+	recordOffset := int64(binary.LittleEndian.Uint64(locator[8:16]))
+	if recordOffset < 0 || recordOffset+zip64EndOfCentralDirLen > locatorOffset {
+		recordOffset = locatorOffset - zip64EndOfCentralDirLen
+	}
+	if _, err := r.ReadAt(record, recordOffset); err != nil {
+		return splitDirectoryEnd{}, false, err
+	}
+	if binary.LittleEndian.Uint32(record[0:4]) != zip64EndOfCentralDirSignature {
+		return splitDirectoryEnd{}, false, fmt.Errorf("%w: bad ZIP64 end of central directory", zip.ErrFormat)
+	}
+
+	return splitDirectoryEnd{
+		disk:      binary.LittleEndian.Uint32(record[16:20]),
+		dirDisk:   binary.LittleEndian.Uint32(record[20:24]),
+		records:   binary.LittleEndian.Uint64(record[32:40]),
+		dirSize:   binary.LittleEndian.Uint64(record[40:48]),
+		dirOffset: binary.LittleEndian.Uint64(record[48:56]),
+	}, true, nil
+}
+
+// volume is one file of a split archive placed at start in the
+// concatenated view.
+type volume struct {
+	r     io.ReaderAt
+	start int64
+	size  int64
+}
+
+// concatReaderAt presents volumes as one contiguous [io.ReaderAt].
+type concatReaderAt struct {
+	volumes []volume
+	size    int64
+}
+
+// newConcatReaderAt places readers of the given sizes one after another.
+func newConcatReaderAt(readers []io.ReaderAt, sizes []int64) *concatReaderAt {
+	c := &concatReaderAt{volumes: make([]volume, len(readers))}
+	for i, r := range readers {
+		c.volumes[i] = volume{r: r, start: c.size, size: sizes[i]}
+		c.size += sizes[i]
+	}
+
+	return c
+}
+
+// ReadAt implements [io.ReaderAt], splitting reads that cross volume
+// boundaries.
+func (c *concatReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		if pos >= c.size {
+			return n, io.EOF
+		}
+
+		i := sort.Search(len(c.volumes), func(i int) bool {
+			return c.volumes[i].start+c.volumes[i].size > pos
+		})
+		v := c.volumes[i]
+		want := min(int64(len(p)-n), v.start+v.size-pos)
+
+		m, err := v.r.ReadAt(p[n:n+int(want)], pos-v.start)
+		n += m
+		if int64(m) == want {
+			continue
+		}
+		if err == nil || errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return n, err
+	}
+
+	return n, nil
+}
+
+// openSplitArchive opens the split archive made of parts followed by the
+// tail at tailPath as one [archiveReader].
+func openSplitArchive(tailPath string, parts []string) (*archiveReader, error) {
+	paths := append(append([]string(nil), parts...), tailPath)
+
+	files := make([]*os.File, 0, len(paths))
+	closeAll := func() error {
+		var errs []error
+		for _, f := range files {
+			errs = append(errs, f.Close())
+		}
+		return errors.Join(errs...)
+	}
+
+	readers := make([]io.ReaderAt, 0, len(paths)+1)
+	sizes := make([]int64, 0, len(paths)+1)
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			_ = closeAll()
+			return nil, err
+		}
+		files = append(files, f)
+
+		info, err := f.Stat()
+		if err != nil {
+			_ = closeAll()
+			return nil, err
+		}
+		readers = append(readers, f)
+		sizes = append(sizes, info.Size())
+	}
+
+	volumes := newConcatReaderAt(readers, sizes)
+	trailer, err := rebuildSplitDirectory(volumes, files[len(files)-1], sizes[len(sizes)-1])
+	if err != nil {
+		_ = closeAll()
+		return nil, fmt.Errorf("failed to read split archive directory: %w", err)
+	}
+
+	readers = append(readers, bytes.NewReader(trailer))
+	sizes = append(sizes, int64(len(trailer)))
+	whole := newConcatReaderAt(readers, sizes)
+
+	zr, err := zip.NewReader(whole, whole.size)
+	if err != nil {
+		_ = closeAll()
+		return nil, err
+	}
+	registerDecompressors(zr)
+
+	return &archiveReader{files: zr.File, closeFn: closeAll}, nil
+}
+
+// rebuildSplitDirectory reads the central directory of the split archive
+// whose volumes are concatenated in volumes, and returns a copy with every
+// local header offset made absolute, followed by new end of central
+// directory records. Appended to volumes, it forms a single-disk archive.
+func rebuildSplitDirectory(volumes *concatReaderAt, tail io.ReaderAt, tailSize int64) ([]byte, error) {
+	end, found, err := readSplitDirectoryEnd(tail, tailSize)
+	if err != nil {
+		return nil, err
+	}
+	if !found || int(end.disk) != len(volumes.volumes)-1 || int(end.dirDisk) >= len(volumes.volumes) {
+		return nil, zip.ErrFormat
+	}
+
+	if end.dirOffset > uint64(volumes.size) || end.dirSize > uint64(volumes.size) {
+		return nil, zip.ErrFormat
+	}
+	dirStart := volumes.volumes[end.dirDisk].start + int64(end.dirOffset)
+	if dirStart+int64(end.dirSize) > volumes.size {
+		return nil, zip.ErrFormat
+	}
+
+	dir := make([]byte, end.dirSize)
+	if _, err := volumes.ReadAt(dir, dirStart); err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	var records uint64
+	for len(dir) > 0 {
+		n, err := rebuildCentralDirRecord(&out, dir, volumes)
+		if err != nil {
+			return nil, err
+		}
+		dir = dir[n:]
+		records++
+	}
+
+	writeDirectoryEnd(&out, records, uint64(out.Len()), uint64(volumes.size))
+
+	return out.Bytes(), nil
+}
+
+// rebuildCentralDirRecord writes the central directory record at the start
+// of dir to out with an absolute local header offset and a disk number of
+// zero, and returns the length of the record in dir.
+func rebuildCentralDirRecord(out *bytes.Buffer, dir []byte, volumes *concatReaderAt) (int, error) {
+	if len(dir) < zipCentralDirHeaderLen || binary.LittleEndian.Uint32(dir[0:4]) != zipCentralDirSignature {
+		return 0, zip.ErrFormat
+	}
+
+	nameLen := int(binary.LittleEndian.Uint16(dir[28:30]))
+	extraLen := int(binary.LittleEndian.Uint16(dir[30:32]))
+	commentLen := int(binary.LittleEndian.Uint16(dir[32:34]))
+	recordLen := zipCentralDirHeaderLen + nameLen + extraLen + commentLen
+	if len(dir) < recordLen {
+		return 0, zip.ErrFormat
+	}
+
+	header := append([]byte(nil), dir[:zipCentralDirHeaderLen]...)
+	name := dir[zipCentralDirHeaderLen : zipCentralDirHeaderLen+nameLen]
+	extra := dir[zipCentralDirHeaderLen+nameLen : zipCentralDirHeaderLen+nameLen+extraLen]
+	comment := dir[zipCentralDirHeaderLen+nameLen+extraLen : recordLen]
+
+	compressed := uint64(binary.LittleEndian.Uint32(header[20:24]))
+	uncompressed := uint64(binary.LittleEndian.Uint32(header[24:28]))
+	disk := uint32(binary.LittleEndian.Uint16(header[34:36]))
+	offset := uint64(binary.LittleEndian.Uint32(header[42:46]))
+
+	// ZIP64 values appear in a fixed order, each only when the header
+	// field holds the marker.
+	var otherExtra []byte
+	for rest := extra; len(rest) >= 4; {
+		id := binary.LittleEndian.Uint16(rest[0:2])
+		size := int(binary.LittleEndian.Uint16(rest[2:4]))
+		if 4+size > len(rest) {
+			break
+		}
+		field := rest[4 : 4+size]
+		if id != zip64ExtraID {
+			otherExtra = append(otherExtra, rest[:4+size]...)
+			rest = rest[4+size:]
+			continue
+		}
+		rest = rest[4+size:]
+
+		next := func(current uint64, marker bool) uint64 {
+			if !marker || len(field) < 8 {
+				return current
+			}
+			v := binary.LittleEndian.Uint64(field[:8])
+			field = field[8:]
+			return v
+		}
+		uncompressed = next(uncompressed, uncompressed == zip32Marker)
+		compressed = next(compressed, compressed == zip32Marker)
+		offset = next(offset, offset == zip32Marker)
+		if disk == zip16Marker && len(field) >= 4 {
+			disk = binary.LittleEndian.Uint32(field[:4])
+		}
+	}
+
+	if int(disk) >= len(volumes.volumes) {
+		return 0, fmt.Errorf("%w: entry %q on volume %d of %d", zip.ErrFormat, name, disk+1, len(volumes.volumes))
+	}
+	absolute := uint64(volumes.volumes[disk].start) + offset
+
+	var zip64 []byte
+	putMarked := func(headerOff int, v uint64) {
+		if v >= zip32Marker {
+			binary.LittleEndian.PutUint32(header[headerOff:headerOff+4], zip32Marker)
+			zip64 = binary.LittleEndian.AppendUint64(zip64, v)
+			return
+		}
+		binary.LittleEndian.PutUint32(header[headerOff:headerOff+4], uint32(v))
+	}
+	putMarked(24, uncompressed)
+	putMarked(20, compressed)
+	putMarked(42, absolute)
+	binary.LittleEndian.PutUint16(header[34:36], 0)
+
+	newExtra := otherExtra
+	if len(zip64) > 0 {
+		newExtra = binary.LittleEndian.AppendUint16(newExtra, zip64ExtraID)
+		newExtra = binary.LittleEndian.AppendUint16(newExtra, uint16(len(zip64)))
+		newExtra = append(newExtra, zip64...)
+	}
+	if len(newExtra) > 0xffff {
+		return 0, fmt.Errorf("%w: extra field too long for %q", zip.ErrFormat, name)
+	}
+	binary.LittleEndian.PutUint16(header[30:32], uint16(len(newExtra)))
+
+	out.Write(header)
+	out.Write(name)
+	out.Write(newExtra)
+	out.Write(comment)
+
+	return recordLen, nil
+}
+
+// writeDirectoryEnd appends the end of central directory record for a
+// single-disk archive to out, preceded by the ZIP64 record and locator when
+// a value does not fit the standard record. dirSize is the central
+// directory length and dirOffset its absolute offset.
+func writeDirectoryEnd(out *bytes.Buffer, records, dirSize, dirOffset uint64) {
+	needZip64 := records >= zip16Marker || dirSize >= zip32Marker || dirOffset >= zip32Marker
+
+	if needZip64 {
+		recordOffset := dirOffset + dirSize
+
+		var record []byte
+		record = binary.LittleEndian.AppendUint32(record, zip64EndOfCentralDirSignature)
+		record = binary.LittleEndian.AppendUint64(record, zip64EndOfCentralDirLen-12)
+		record = binary.LittleEndian.AppendUint16(record, 45) // version made by
+		record = binary.LittleEndian.AppendUint16(record, 45) // version needed
+		record = binary.LittleEndian.AppendUint32(record, 0)  // this disk
+		record = binary.LittleEndian.AppendUint32(record, 0)  // directory disk
+		record = binary.LittleEndian.AppendUint64(record, records)
+		record = binary.LittleEndian.AppendUint64(record, records)
+		record = binary.LittleEndian.AppendUint64(record, dirSize)
+		record = binary.LittleEndian.AppendUint64(record, dirOffset)
+		out.Write(record)
+
+		var locator []byte
+		locator = binary.LittleEndian.AppendUint32(locator, zip64EndOfCentralDirLocSignature)
+		locator = binary.LittleEndian.AppendUint32(locator, 0)
+		locator = binary.LittleEndian.AppendUint64(locator, recordOffset)
+		locator = binary.LittleEndian.AppendUint32(locator, 1)
+		out.Write(locator)
+
+		records = min(records, zip16Marker)
+		dirSize = min(dirSize, zip32Marker)
+		dirOffset = min(dirOffset, zip32Marker)
+	}
+
+	var eocd []byte
+	eocd = binary.LittleEndian.AppendUint32(eocd, zipEndOfCentralDirSignature)
+	eocd = binary.LittleEndian.AppendUint16(eocd, 0) // this disk
+	eocd = binary.LittleEndian.AppendUint16(eocd, 0) // directory disk
+	eocd = binary.LittleEndian.AppendUint16(eocd, uint16(records))
+	eocd = binary.LittleEndian.AppendUint16(eocd, uint16(records))
+	eocd = binary.LittleEndian.AppendUint32(eocd, uint32(dirSize))
+	eocd = binary.LittleEndian.AppendUint32(eocd, uint32(dirOffset))
+	eocd = binary.LittleEndian.AppendUint16(eocd, 0) // comment length
+	out.Write(eocd)
+}
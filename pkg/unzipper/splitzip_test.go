@@ -0,0 +1,164 @@
+package unzipper
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"btidy/internal/testutil"
+	"btidy/pkg/metadata"
+	"btidy/pkg/safepath"
+	"btidy/pkg/trash"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func splitFixtureEntries() map[string][]byte {
+	return map[string][]byte{
+		"a.txt":       []byte(strings.Repeat("a", 300)),
+		"docs/b.txt":  []byte(strings.Repeat("b", 700)),
+		"docs/c.json": []byte(`{"c": true}`),
+	}
+}
+
+func TestConcatReaderAt(t *testing.T) {
+	r := newConcatReaderAt(
+		[]io.ReaderAt{strings.NewReader("abc"), strings.NewReader(""), strings.NewReader("defg")},
+		[]int64{3, 0, 4},
+	)
+
+	p := make([]byte, 5)
+	n, err := r.ReadAt(p, 1)
+	require.NoError(t, err)
+	assert.Equal(t, "bcdef", string(p[:n]))
+
+	n, err = r.ReadAt(p, 5)
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Equal(t, "fg", string(p[:n]))
+
+	_, err = r.ReadAt(p, 7)
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestSplitArchiveParts(t *testing.T) {
+	t.Run("ordinary zip has no parts", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "plain.zip")
+		writeZipWithEntries(t, path, map[string][]byte{"a.txt": []byte("a")})
+
+		parts, err := splitArchiveParts(path)
+		require.NoError(t, err)
+		assert.Empty(t, parts)
+	})
+
+	t.Run("finds every volume in order", func(t *testing.T) {
+		tail, want := testutil.WriteSplitZip(t, t.TempDir(), "set", splitFixtureEntries(), 256)
+		require.Len(t, want, 4)
+
+		parts, err := splitArchiveParts(tail)
+		require.NoError(t, err)
+		assert.Equal(t, want, parts)
+	})
+
+	t.Run("names the missing volumes", func(t *testing.T) {
+		tail, parts := testutil.WriteSplitZip(t, t.TempDir(), "set", splitFixtureEntries(), 256)
+		require.NoError(t, os.Remove(parts[1]))
+		require.NoError(t, os.Remove(parts[3]))
+
+		_, err := splitArchiveParts(tail)
+		require.ErrorIs(t, err, errSplitVolumeMissing)
+		assert.Contains(t, err.Error(), "set.z02, set.z04")
+	})
+}
+
+func TestExtractSplitArchive(t *testing.T) {
+	run := func(t *testing.T, root string, dryRun bool, withTrash bool) Result {
+		t.Helper()
+
+		v, err := safepath.New(root)
+		require.NoError(t, err)
+
+		var trasher *trash.Trasher
+		if withTrash {
+			metaDir, initErr := metadata.Init(root, v)
+			require.NoError(t, initErr)
+			trasher, err = trash.New(metaDir, "split-run", v)
+			require.NoError(t, err)
+		}
+
+		uz, err := NewWithValidator(v, dryRun, trasher)
+		require.NoError(t, err)
+
+		files, err := getAllFilesRecursively(t.Context(), root)
+		require.NoError(t, err)
+
+		result, err := uz.ExtractArchivesWithProgressRecursively(t.Context(), files, nil)
+		require.NoError(t, err)
+		return result
+	}
+
+	t.Run("extracts the set and trashes every volume", func(t *testing.T) {
+		root := t.TempDir()
+		tail, parts := testutil.WriteSplitZip(t, root, "set", splitFixtureEntries(), 256)
+
+		result := run(t, root, false, true)
+		assert.Equal(t, 1, result.ExtractedArchives)
+		assert.Zero(t, result.ErrorCount)
+		require.Len(t, result.Operations, 1)
+
+		op := result.Operations[0]
+		assert.Equal(t, tail, op.ArchivePath)
+		assert.Equal(t, parts, op.Parts)
+		require.Len(t, op.TrashedParts, len(parts))
+		assert.NotEmpty(t, op.TrashedTo)
+
+		for name, want := range splitFixtureEntries() {
+			content, readErr := os.ReadFile(filepath.Join(root, filepath.FromSlash(name)))
+			require.NoError(t, readErr)
+			assert.Equal(t, want, content, name)
+		}
+		for i, part := range parts {
+			assert.NoFileExists(t, part)
+			assert.FileExists(t, op.TrashedParts[i])
+		}
+		assert.NoFileExists(t, tail)
+	})
+
+	t.Run("dry run keeps every volume", func(t *testing.T) {
+		root := t.TempDir()
+		tail, parts := testutil.WriteSplitZip(t, root, "set", splitFixtureEntries(), 256)
+
+		result := run(t, root, true, false)
+		require.Len(t, result.Operations, 1)
+		assert.Equal(t, 3, result.Operations[0].ExtractedFiles)
+		assert.Equal(t, parts, result.Operations[0].Parts)
+
+		assert.FileExists(t, tail)
+		for _, part := range parts {
+			assert.FileExists(t, part)
+		}
+	})
+
+	t.Run("missing volume skips the set", func(t *testing.T) {
+		root := t.TempDir()
+		tail, parts := testutil.WriteSplitZip(t, root, "set", splitFixtureEntries(), 256)
+		require.NoError(t, os.Remove(parts[2]))
+
+		result := run(t, root, false, false)
+		assert.Zero(t, result.ExtractedArchives)
+		assert.Equal(t, 1, result.SkippedCount)
+		require.Len(t, result.Operations, 1)
+		assert.True(t, result.Operations[0].Skipped)
+		assert.Contains(t, result.Operations[0].SkipReason, "set.z03")
+
+		assert.FileExists(t, tail)
+		for i, part := range parts {
+			if i != 2 {
+				assert.FileExists(t, part)
+			}
+		}
+		assert.NoFileExists(t, filepath.Join(root, "a.txt"))
+	})
+}
@@ -0,0 +1,197 @@
+package unzipper
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+
+	"btidy/pkg/safepath"
+	"btidy/pkg/trash"
+)
+
+// SymlinkPolicy selects what extraction does with symlink entries in zip
+// and tar archives.
+type SymlinkPolicy string
+
+const (
+	// SymlinksSkip never creates symlinks; every link entry is reported as
+	// skipped.
+	SymlinksSkip SymlinkPolicy = "skip"
+
+	// SymlinksMaterialize writes each link as a regular file holding a copy
+	// of the archive member it points to. Links whose target is not a file
+	// extracted from the same archive are skipped.
+	SymlinksMaterialize SymlinkPolicy = "materialize"
+
+	// SymlinksCreate creates real symlinks, but only when the target stays
+	// inside the root directory.
+	SymlinksCreate SymlinkPolicy = "create"
+)
+
+// maxSymlinkTargetLen bounds the link text read from a zip symlink entry,
+// whose body is the target path. It matches the Linux PATH_MAX.
+const maxSymlinkTargetLen = 4096
+
+var (
+	// errSymlinkSkipped is reported for link entries under [SymlinksSkip].
+	errSymlinkSkipped = errors.New("symlink skipped by policy")
+
+	// errSymlinkTargetTooLong is returned for zip symlink entries whose body
+	// is too long to be a path.
+	errSymlinkTargetTooLong = errors.New("symlink target too long")
+)
+
+// ParseSymlinkPolicy converts a command-line value into a [SymlinkPolicy].
+func ParseSymlinkPolicy(value string) (SymlinkPolicy, error) {
+	switch policy := SymlinkPolicy(value); policy {
+	case SymlinksSkip, SymlinksMaterialize, SymlinksCreate:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("invalid symlink policy %q (want %s, %s or %s)",
+			value, SymlinksSkip, SymlinksMaterialize, SymlinksCreate)
+	}
+}
+
+// pendingLink is a symlink entry waiting to be materialized once every
+// archive member has been extracted, since its target may come later in
+// the archive.
+type pendingLink struct {
+	entry      archiveEntry
+	targetPath string
+
+	// nested is the chain of the streamed archive the link came from.
+	nested []string
+}
+
+// zipLinkTarget reads the link text stored as the body of the zip symlink
+// entry f, opened with open. Names that are not UTF-8 are decoded like
+// entry names.
+func zipLinkTarget(f *zip.File, open func() (io.ReadCloser, error), enc NameEncoding) (string, error) {
+	rc, err := open()
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = rc.Close()
+	}()
+
+	data, err := io.ReadAll(io.LimitReader(rc, maxSymlinkTargetLen+1))
+	if err != nil {
+		return "", err
+	}
+	if len(data) > maxSymlinkTargetLen {
+		return "", errSymlinkTargetTooLong
+	}
+
+	return decodeZipText(string(data), f.Flags, enc), nil
+}
+
+// linkSourceName returns the archive member name a symlink entry points to,
+// resolved relative to the link's own directory.
+func linkSourceName(entry archiveEntry) string {
+	name := normalizeArchiveEntryPath(entry.name)
+	return path.Join(path.Dir(name), normalizeArchiveEntryPath(entry.linkTarget))
+}
+
+// materializeLinks writes every symlink deferred by [SymlinksMaterialize]
+// as a copy of its target. A link to another link is written once that
+// link has been materialized; links whose target is not a file extracted
+// by op, including cycles, are skipped and reported.
+func materializeLinks(validator *safepath.Validator, trasher *trash.Trasher, op *ExtractOperation) error {
+	pending := op.pendingLinks
+	op.pendingLinks = nil
+
+	for len(pending) > 0 {
+		var waiting []pendingLink
+		for _, link := range pending {
+			sourcePath := filepath.Join(filepath.Dir(link.targetPath), filepath.FromSlash(link.entry.linkTarget))
+			if !op.createdByThisExtraction(sourcePath) {
+				waiting = append(waiting, link)
+				continue
+			}
+
+			op.nested = link.nested
+			err := materializeLink(link, sourcePath, validator, trasher, op)
+			op.nested = nil
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(waiting) == len(pending) {
+			for _, link := range waiting {
+				recordSkippedEntry(op, link.entry.name,
+					fmt.Errorf("%w: %s is not a file in the archive", errLinkTargetMissing, link.entry.linkTarget))
+			}
+			break
+		}
+		pending = waiting
+	}
+
+	return nil
+}
+
+// materializeLink writes link as a copy of sourcePath, backing up any file
+// that existed at the link's path before the extraction.
+func materializeLink(
+	link pendingLink,
+	sourcePath string,
+	validator *safepath.Validator,
+	trasher *trash.Trasher,
+	op *ExtractOperation,
+) error {
+	parentDir := filepath.Dir(link.targetPath)
+	if err := mkdirAllTracked(parentDir, 0o755, op); err != nil {
+		return fmt.Errorf("failed to create parent directory %s: %w", parentDir, err)
+	}
+
+	if !op.createdByThisExtraction(link.targetPath) {
+		replaced, replacedFile, err := backupExistingFile(link.targetPath, trasher)
+		if err != nil {
+			return fmt.Errorf("failed to backup existing target %s: %w", link.targetPath, err)
+		}
+		if replaced {
+			op.ReplacedFiles = append(op.ReplacedFiles, replacedFile)
+		}
+	}
+
+	if err := copyLinkSource(sourcePath, link.entry.linkTarget, link.targetPath, validator); err != nil {
+		return fmt.Errorf("failed to extract %s: %w", link.entry.name, err)
+	}
+	op.ExtractedFiles++
+
+	if err := op.recordCreatedFile(link.targetPath, true); err != nil {
+		return fmt.Errorf("failed to record %s: %w", link.entry.name, err)
+	}
+
+	return nil
+}
+
+// measureLinkCopies returns the bytes that materializing links would write.
+// links maps each symlink member name to the member it points to and
+// fileSizes holds the size of every regular file member; a link to a link
+// is followed until it reaches a file, for at most len(links) hops.
+func measureLinkCopies(links map[string]string, fileSizes map[string]int64) int64 {
+	var total int64
+	for _, target := range links {
+		for hops := 0; hops < len(links); hops++ {
+			next, ok := links[target]
+			if !ok {
+				break
+			}
+			target = next
+		}
+		total = addSize(total, fileSizes[target])
+	}
+
+	return total
+}
+
+// cleanMemberName normalizes an archive member name so links and the
+// members they point to compare equal.
+func cleanMemberName(name string) string {
+	return path.Clean(normalizeArchiveEntryPath(name))
+}
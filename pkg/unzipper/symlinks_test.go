@@ -0,0 +1,210 @@
+package unzipper
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"btidy/pkg/safepath"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// zipMember is one entry of a zip fixture; link entries store body as the
+// symlink target.
+type zipMember struct {
+	name string
+	body string
+	link bool
+}
+
+func writeZipMembers(t *testing.T, zipPath string, members []zipMember) {
+	t.Helper()
+
+	f, err := os.Create(zipPath)
+	require.NoError(t, err)
+
+	zw := zip.NewWriter(f)
+	for _, m := range members {
+		hdr := &zip.FileHeader{Name: m.name, Method: zip.Deflate}
+		if m.link {
+			hdr.SetMode(os.ModeSymlink | 0o777)
+		} else {
+			hdr.SetMode(0o644)
+		}
+		w, createErr := zw.CreateHeader(hdr)
+		require.NoError(t, createErr)
+		_, err = w.Write([]byte(m.body))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	require.NoError(t, f.Close())
+}
+
+func TestParseSymlinkPolicy(t *testing.T) {
+	for _, value := range []string{"skip", "materialize", "create"} {
+		policy, err := ParseSymlinkPolicy(value)
+		require.NoError(t, err)
+		assert.Equal(t, SymlinkPolicy(value), policy)
+	}
+
+	_, err := ParseSymlinkPolicy("follow")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid symlink policy")
+}
+
+func TestExtractArchiveSymlinks(t *testing.T) {
+	run := func(t *testing.T, root string, opts ...Option) Result {
+		t.Helper()
+
+		v, err := safepath.New(root)
+		require.NoError(t, err)
+		uz, err := NewWithValidator(v, false, nil, opts...)
+		require.NoError(t, err)
+
+		files, err := getAllFilesRecursively(t.Context(), root)
+		require.NoError(t, err)
+
+		result, err := uz.ExtractArchivesWithProgressRecursively(t.Context(), files, nil)
+		require.NoError(t, err)
+		return result
+	}
+
+	members := []zipMember{
+		{name: "docs/current.txt", body: "v2.txt", link: true},
+		{name: "docs/v2.txt", body: "version two"},
+		{name: "latest.txt", body: "docs/current.txt", link: true},
+		{name: "escape.txt", body: "../../outside.txt", link: true},
+		{name: "absolute.txt", body: "/etc/passwd", link: true},
+		{name: "dangling.txt", body: "missing.txt", link: true},
+	}
+
+	t.Run("create makes contained zip symlinks", func(t *testing.T) {
+		root := t.TempDir()
+		writeZipMembers(t, filepath.Join(root, "links.zip"), members)
+
+		result := run(t, root, WithSymlinkPolicy(SymlinksCreate))
+		require.Len(t, result.Operations, 1)
+		op := result.Operations[0]
+
+		target, err := os.Readlink(filepath.Join(root, "docs", "current.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "v2.txt", target)
+
+		content, err := os.ReadFile(filepath.Join(root, "latest.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "version two", string(content))
+
+		assert.NoFileExists(t, filepath.Join(root, "escape.txt"))
+		assert.NoFileExists(t, filepath.Join(root, "absolute.txt"))
+		assert.Equal(t, 2, op.SkippedEntries)
+		require.Len(t, op.EntryErrors, 2)
+		assert.Contains(t, op.EntryErrors[0], "escape.txt")
+		assert.Contains(t, op.EntryErrors[1], "absolute.txt")
+	})
+
+	t.Run("skip is the default and creates no links", func(t *testing.T) {
+		root := t.TempDir()
+		writeZipMembers(t, filepath.Join(root, "links.zip"), members)
+
+		result := run(t, root)
+		op := result.Operations[0]
+
+		assert.FileExists(t, filepath.Join(root, "docs", "v2.txt"))
+		for _, name := range []string{"docs/current.txt", "latest.txt", "escape.txt", "absolute.txt", "dangling.txt"} {
+			_, err := os.Lstat(filepath.Join(root, filepath.FromSlash(name)))
+			assert.ErrorIs(t, err, os.ErrNotExist, name)
+		}
+		assert.Equal(t, 5, op.SkippedEntries)
+		assert.Equal(t, 1, op.ExtractedFiles)
+		for _, entryErr := range op.EntryErrors[:2] {
+			assert.Contains(t, entryErr, errSymlinkSkipped.Error())
+		}
+	})
+
+	t.Run("materialize copies linked members", func(t *testing.T) {
+		root := t.TempDir()
+		writeZipMembers(t, filepath.Join(root, "links.zip"), members)
+
+		result := run(t, root, WithSymlinkPolicy(SymlinksMaterialize))
+		op := result.Operations[0]
+
+		for _, name := range []string{"docs/current.txt", "latest.txt"} {
+			path := filepath.Join(root, filepath.FromSlash(name))
+			info, err := os.Lstat(path)
+			require.NoError(t, err)
+			assert.True(t, info.Mode().IsRegular(), name)
+
+			content, err := os.ReadFile(path)
+			require.NoError(t, err)
+			assert.Equal(t, "version two", string(content), name)
+		}
+
+		assert.Equal(t, 3, op.ExtractedFiles)
+		assert.Len(t, op.CreatedFiles, 3)
+		assert.Equal(t, 3, op.SkippedEntries)
+		assert.NoFileExists(t, filepath.Join(root, "dangling.txt"))
+		assert.Contains(t, strings.Join(op.EntryErrors, "\n"), "dangling.txt: "+errLinkTargetMissing.Error())
+	})
+
+	t.Run("materialize applies to tar symlinks", func(t *testing.T) {
+		root := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(root, "bundle.tar"), buildTar(t, []tarMember{
+			{name: "alias.txt", typeflag: tar.TypeSymlink, linkname: "real.txt"},
+			{name: "real.txt", typeflag: tar.TypeReg, body: "real"},
+		}), 0o644))
+
+		run(t, root, WithSymlinkPolicy(SymlinksMaterialize))
+
+		info, err := os.Lstat(filepath.Join(root, "alias.txt"))
+		require.NoError(t, err)
+		assert.True(t, info.Mode().IsRegular())
+	})
+
+	t.Run("materialized copies count against the archive limit", func(t *testing.T) {
+		root := t.TempDir()
+		writeZipMembers(t, filepath.Join(root, "links.zip"), []zipMember{
+			{name: "big.bin", body: strings.Repeat("x", 1000)},
+			{name: "a.bin", body: "big.bin", link: true},
+			{name: "b.bin", body: "a.bin", link: true},
+		})
+
+		limits := DefaultLimits()
+		limits.MaxArchiveBytes = 2500
+		result := run(t, root, WithSymlinkPolicy(SymlinksMaterialize), WithLimits(limits))
+
+		require.Len(t, result.Operations, 1)
+		assert.True(t, result.Operations[0].Skipped)
+		assert.Contains(t, result.Operations[0].SkipReason, "expands to 3000 bytes")
+		assert.FileExists(t, filepath.Join(root, "links.zip"))
+	})
+
+	t.Run("dry run reports skipped links", func(t *testing.T) {
+		root := t.TempDir()
+		writeZipMembers(t, filepath.Join(root, "links.zip"), members)
+
+		v, err := safepath.New(root)
+		require.NoError(t, err)
+		uz, err := NewWithValidator(v, true, nil, WithSymlinkPolicy(SymlinksSkip))
+		require.NoError(t, err)
+		files, err := getAllFilesRecursively(t.Context(), root)
+		require.NoError(t, err)
+
+		result, err := uz.ExtractArchivesWithProgressRecursively(t.Context(), files, nil)
+		require.NoError(t, err)
+		assert.Equal(t, 5, result.Operations[0].SkippedEntries)
+		assert.Equal(t, 1, result.Operations[0].ExtractedFiles)
+	})
+}
+
+func TestNewWithValidatorRejectsUnknownSymlinkPolicy(t *testing.T) {
+	v, err := safepath.New(t.TempDir())
+	require.NoError(t, err)
+
+	_, err = NewWithValidator(v, false, nil, WithSymlinkPolicy("follow"))
+	require.Error(t, err)
+}
@@ -0,0 +1,185 @@
+package unzipper
+
+import (
+	"archive/tar"
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// errNotTarArchive is returned by [openTarArchive] when the file (after
+// removing any compression wrapper) does not start with a valid tar header.
+var errNotTarArchive = errors.New("not a tar archive")
+
+// errArchiveAlreadyWalked is returned when a streaming archive is walked a
+// second time; tar members can only be read once per open.
+var errArchiveAlreadyWalked = errors.New("archive entries already read")
+
+// tarArchive is a tar stream, optionally wrapped in gzip, bzip2, xz or zstd.
+// Unlike zip, tar has no central directory, so entries are visited in a
+// single forward pass.
+type tarArchive struct {
+	decompressor io.ReadCloser
+	reader       *tar.Reader
+
+	// format is the compression wrapper around the tar stream.
+	format compressionFormat
+
+	// closeFn releases the source the stream is read from.
+	closeFn func() error
+
+	// first is the header read while probing the format.
+	first *tar.Header
+
+	walked bool
+}
+
+// openTarArchive opens filePath as a tar archive. Compression wrappers are
+// detected from magic bytes, not from the file extension, so `.tgz`,
+// `.tar.gz` and misnamed files are all recognized. Returns an error
+// wrapping [errNotTarArchive] when no valid tar header is found.
+func openTarArchive(filePath string) (*tarArchive, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := newTarArchive(f, f.Close)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// newTarArchive reads a tar archive from r, which closeFn releases when
+// the archive is closed. See [openTarArchive].
+func newTarArchive(r io.Reader, closeFn func() error) (*tarArchive, error) {
+	br := bufio.NewReader(r)
+	format := detectCompression(br)
+
+	dec, err := newDecompressor(format, br)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errNotTarArchive, err)
+	}
+
+	tr := tar.NewReader(dec)
+	first, err := tr.Next()
+	if err != nil {
+		_ = dec.Close()
+		return nil, fmt.Errorf("%w: %w", errNotTarArchive, err)
+	}
+
+	return &tarArchive{
+		decompressor: dec,
+		reader:       tr,
+		format:       format,
+		closeFn:      closeFn,
+		first:        first,
+	}, nil
+}
+
+// validate implements [archiveSource]. Tar members carry no per-entry
+// compression method, so there is nothing to reject up front.
+func (a *tarArchive) validate() error {
+	return nil
+}
+
+// walk implements [archiveSource]. It can only be called once per open.
+func (a *tarArchive) walk(fn func(archiveEntry) error) error {
+	if a.walked {
+		return errArchiveAlreadyWalked
+	}
+	a.walked = true
+
+	hdr := a.first
+	for {
+		if entry, ok := tarHeaderEntry(hdr, a.reader); ok {
+			entry.method = a.method()
+			if err := fn(entry); err != nil {
+				return err
+			}
+		}
+
+		var err error
+		hdr, err = a.reader.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar entry: %w", err)
+		}
+	}
+}
+
+// method names how member bodies are stored: the compression wrapper of
+// the whole stream, or "store" for a plain tar.
+func (a *tarArchive) method() string {
+	if a.format == compressionNone {
+		return "store"
+	}
+
+	return a.format.String()
+}
+
+// Close implements [archiveSource].
+func (a *tarArchive) Close() error {
+	decErr := a.decompressor.Close()
+	closeErr := a.closeFn()
+
+	return errors.Join(decErr, closeErr)
+}
+
+// tarHeaderEntry converts a tar header into an [archiveEntry]. It returns
+// false for headers that do not describe a filesystem object, such as PAX
+// global headers and the "./" root entry written by `tar -C dir .`.
+func tarHeaderEntry(hdr *tar.Header, body io.Reader) (archiveEntry, bool) {
+	name := trimTarCurrentDirPrefix(hdr.Name)
+	if name == "" || name == "." {
+		return archiveEntry{}, false
+	}
+
+	entry := archiveEntry{
+		name:    name,
+		mode:    fs.FileMode(hdr.Mode).Perm(), //nolint:gosec // tar mode bits are masked to permissions
+		modTime: hdr.ModTime,
+		open: func() (io.ReadCloser, error) {
+			return io.NopCloser(body), nil
+		},
+	}
+
+	switch hdr.Typeflag {
+	case tar.TypeReg, tar.TypeGNUSparse:
+		entry.kind = entryKindFile
+		entry.size = hdr.Size
+	case tar.TypeDir:
+		entry.kind = entryKindDir
+	case tar.TypeSymlink:
+		entry.kind = entryKindSymlink
+		entry.linkTarget = hdr.Linkname
+	case tar.TypeLink:
+		entry.kind = entryKindHardlink
+		entry.linkTarget = trimTarCurrentDirPrefix(hdr.Linkname)
+	case tar.TypeXGlobalHeader:
+		return archiveEntry{}, false
+	default:
+		entry.kind = entryKindOther
+	}
+
+	return entry, true
+}
+
+// trimTarCurrentDirPrefix strips leading "./" components, which GNU tar
+// emits when an archive is created from ".".
+func trimTarCurrentDirPrefix(name string) string {
+	for strings.HasPrefix(name, "./") {
+		name = strings.TrimPrefix(name, "./")
+	}
+
+	return name
+}
@@ -0,0 +1,406 @@
+package unzipper
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"btidy/pkg/collector"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ulikunitz/xz"
+)
+
+// bzip2HelloTar is a bzip2-compressed USTAR archive holding a single
+// hello.txt with the content "hello bzip2". The standard library has no
+// bzip2 writer, so the fixture is embedded.
+var bzip2HelloTar = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0x26, 0x6f,
+	0x30, 0x3b, 0x00, 0x00, 0x36, 0xdb, 0x90, 0xca, 0x80, 0x40, 0x01, 0x7d,
+	0x04, 0x00, 0x80, 0x72, 0x64, 0xde, 0x50, 0x04, 0x00, 0x01, 0x08, 0x20,
+	0x00, 0x54, 0x35, 0x09, 0xea, 0x68, 0xd0, 0x68, 0xd0, 0x03, 0x1b, 0x54,
+	0x18, 0xa6, 0xa6, 0x43, 0x40, 0x68, 0x06, 0x80, 0x42, 0x69, 0xbe, 0x22,
+	0x2f, 0x00, 0x8b, 0x26, 0xfb, 0x90, 0x68, 0xd5, 0x00, 0x42, 0x05, 0x70,
+	0x1d, 0x6a, 0x25, 0x90, 0x26, 0x4e, 0x92, 0x23, 0x10, 0x9a, 0x7b, 0x92,
+	0x35, 0x36, 0x78, 0x12, 0xc3, 0xb0, 0xa4, 0xfc, 0x28, 0x05, 0x26, 0xa4,
+	0xd5, 0x62, 0x75, 0x63, 0x3f, 0x17, 0x72, 0x45, 0x38, 0x50, 0x90, 0x26,
+	0x6f, 0x30, 0x3b,
+}
+
+// tarMember describes one entry written by buildTar.
+type tarMember struct {
+	name     string
+	typeflag byte
+	body     string
+	linkname string
+}
+
+func TestExtractTarArchives(t *testing.T) {
+	members := []tarMember{
+		{name: "./", typeflag: tar.TypeDir},
+		{name: "./docs/", typeflag: tar.TypeDir},
+		{name: "./docs/readme.txt", typeflag: tar.TypeReg, body: "read me"},
+		{name: "./top.txt", typeflag: tar.TypeReg, body: "top level"},
+	}
+
+	tests := []struct {
+		name        string
+		archiveName string
+		compress    func(t *testing.T, data []byte) []byte
+	}{
+		{name: "plain tar", archiveName: "backup.tar", compress: func(_ *testing.T, data []byte) []byte { return data }},
+		{name: "gzip tar", archiveName: "backup.tar.gz", compress: gzipBytes},
+		{name: "xz tar", archiveName: "backup.tar.xz", compress: xzBytes},
+		{name: "zstd tar", archiveName: "backup.tar.zst", compress: zstdBytes},
+		{name: "misnamed gzip tar", archiveName: "backup.bin", compress: gzipBytes},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			archivePath := filepath.Join(root, tc.archiveName)
+			require.NoError(t, os.WriteFile(archivePath, tc.compress(t, buildTar(t, members)), 0o644))
+
+			uz, err := New(root, false)
+			require.NoError(t, err)
+			files, err := getAllFilesRecursively(t.Context(), root)
+			require.NoError(t, err)
+
+			result, err := uz.ExtractArchivesWithProgressRecursively(t.Context(), files, nil)
+			require.NoError(t, err)
+
+			assert.Equal(t, 1, result.ExtractedArchives)
+			assert.Equal(t, 1, result.DeletedArchives)
+			assert.Equal(t, 2, result.ExtractedFiles)
+			assert.Equal(t, 1, result.ExtractedDirs)
+
+			content, err := os.ReadFile(filepath.Join(root, "docs", "readme.txt"))
+			require.NoError(t, err)
+			assert.Equal(t, "read me", string(content))
+
+			content, err = os.ReadFile(filepath.Join(root, "top.txt"))
+			require.NoError(t, err)
+			assert.Equal(t, "top level", string(content))
+
+			assert.NoFileExists(t, archivePath)
+		})
+	}
+
+	t.Run("bzip2 tar", func(t *testing.T) {
+		root := t.TempDir()
+		archivePath := filepath.Join(root, "backup.tar.bz2")
+		require.NoError(t, os.WriteFile(archivePath, bzip2HelloTar, 0o644))
+
+		op, err := unzip(collector.FileInfo{Dir: root, Name: "backup.tar.bz2", Path: archivePath})
+		require.NoError(t, err)
+		assert.Equal(t, 1, op.ExtractedFiles)
+
+		content, err := os.ReadFile(filepath.Join(root, "hello.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "hello bzip2", string(content))
+	})
+}
+
+func TestExtractTarArchiveLinks(t *testing.T) {
+	extract := func(t *testing.T, root string, members []tarMember) ExtractOperation {
+		t.Helper()
+
+		archiveDir := filepath.Join(root, "in")
+		require.NoError(t, os.MkdirAll(archiveDir, 0o755))
+		archivePath := filepath.Join(archiveDir, "links.tar")
+		require.NoError(t, os.WriteFile(archivePath, buildTar(t, members), 0o644))
+
+		uz, err := New(root, false)
+		require.NoError(t, err)
+
+		op, err := unzipInto(
+			t.Context(),
+			collector.FileInfo{Dir: archiveDir, Name: "links.tar", Path: archivePath},
+			archiveDir,
+			extractConfig{symlinks: SymlinksCreate},
+			uz.validator,
+			nil,
+		)
+		require.NoError(t, err)
+		return op
+	}
+
+	t.Run("creates contained relative symlink", func(t *testing.T) {
+		root := t.TempDir()
+		op := extract(t, root, []tarMember{
+			{name: "data/target.txt", typeflag: tar.TypeReg, body: "target"},
+			{name: "data/link.txt", typeflag: tar.TypeSymlink, linkname: "target.txt"},
+		})
+
+		assert.Equal(t, 2, op.ExtractedFiles)
+		assert.Equal(t, 0, op.SkippedEntries)
+
+		linkPath := filepath.Join(root, "in", "data", "link.txt")
+		linkTarget, err := os.Readlink(linkPath)
+		require.NoError(t, err)
+		assert.Equal(t, "target.txt", linkTarget)
+	})
+
+	t.Run("skips symlinks escaping root", func(t *testing.T) {
+		root := t.TempDir()
+		op := extract(t, root, []tarMember{
+			{name: "up.txt", typeflag: tar.TypeSymlink, linkname: "../../outside.txt"},
+			{name: "abs.txt", typeflag: tar.TypeSymlink, linkname: "/etc/passwd"},
+			{name: "ok.txt", typeflag: tar.TypeReg, body: "ok"},
+		})
+
+		assert.True(t, op.ExtractionComplete)
+		assert.Equal(t, 1, op.ExtractedFiles)
+		assert.Equal(t, 2, op.SkippedEntries)
+		require.Len(t, op.EntryErrors, 2)
+		assert.Contains(t, op.EntryErrors[0], "up.txt")
+		assert.Contains(t, op.EntryErrors[1], "abs.txt")
+
+		_, err := os.Lstat(filepath.Join(root, "in", "up.txt"))
+		assert.ErrorIs(t, err, os.ErrNotExist)
+		_, err = os.Lstat(filepath.Join(root, "in", "abs.txt"))
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	})
+
+	t.Run("file entry replaces earlier symlink instead of writing through it", func(t *testing.T) {
+		root := t.TempDir()
+		extract(t, root, []tarMember{
+			{name: "victim.txt", typeflag: tar.TypeReg, body: "original"},
+			{name: "alias.txt", typeflag: tar.TypeSymlink, linkname: "victim.txt"},
+			{name: "alias.txt", typeflag: tar.TypeReg, body: "overwrite"},
+		})
+
+		content, err := os.ReadFile(filepath.Join(root, "in", "victim.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "original", string(content))
+
+		content, err = os.ReadFile(filepath.Join(root, "in", "alias.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "overwrite", string(content))
+	})
+
+	t.Run("hard link copies earlier member", func(t *testing.T) {
+		root := t.TempDir()
+		op := extract(t, root, []tarMember{
+			{name: "./a/original.txt", typeflag: tar.TypeReg, body: "shared"},
+			{name: "./b/copy.txt", typeflag: tar.TypeLink, linkname: "./a/original.txt"},
+		})
+
+		assert.Equal(t, 2, op.ExtractedFiles)
+
+		content, err := os.ReadFile(filepath.Join(root, "in", "b", "copy.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "shared", string(content))
+
+		origInfo, err := os.Stat(filepath.Join(root, "in", "a", "original.txt"))
+		require.NoError(t, err)
+		copyInfo, err := os.Stat(filepath.Join(root, "in", "b", "copy.txt"))
+		require.NoError(t, err)
+		assert.False(t, os.SameFile(origInfo, copyInfo))
+	})
+
+	t.Run("hard link escaping root is skipped", func(t *testing.T) {
+		root := t.TempDir()
+		op := extract(t, root, []tarMember{
+			{name: "steal.txt", typeflag: tar.TypeLink, linkname: "../../etc/passwd"},
+		})
+
+		assert.Equal(t, 0, op.ExtractedFiles)
+		assert.Equal(t, 1, op.SkippedEntries)
+		assert.NoFileExists(t, filepath.Join(root, "in", "steal.txt"))
+	})
+
+	t.Run("special files are skipped", func(t *testing.T) {
+		root := t.TempDir()
+		op := extract(t, root, []tarMember{
+			{name: "pipe", typeflag: tar.TypeFifo},
+			{name: "regular.txt", typeflag: tar.TypeReg, body: "r"},
+		})
+
+		assert.Equal(t, 1, op.ExtractedFiles)
+		assert.Equal(t, 1, op.SkippedEntries)
+		require.Len(t, op.EntryErrors, 1)
+		assert.Contains(t, op.EntryErrors[0], "unsupported entry type")
+	})
+}
+
+func TestExtractTarArchiveRejectsTraversal(t *testing.T) {
+	root := t.TempDir()
+	archivePath := filepath.Join(root, "evil.tar.gz")
+	require.NoError(t, os.WriteFile(archivePath, gzipBytes(t, buildTar(t, []tarMember{
+		{name: "../escape.txt", typeflag: tar.TypeReg, body: "nope"},
+	})), 0o644))
+
+	uz, err := New(root, false)
+	require.NoError(t, err)
+	files, err := getAllFilesRecursively(t.Context(), root)
+	require.NoError(t, err)
+
+	_, err = uz.ExtractArchivesWithProgressRecursively(t.Context(), files, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "contains path traversal")
+
+	assert.FileExists(t, archivePath)
+	assert.NoFileExists(t, filepath.Join(filepath.Dir(root), "escape.txt"))
+}
+
+func TestExtractTarNestedInZip(t *testing.T) {
+	root := t.TempDir()
+
+	tarData := gzipBytes(t, buildTar(t, []tarMember{
+		{name: "inner.txt", typeflag: tar.TypeReg, body: "from tarball"},
+	}))
+
+	zipPath := filepath.Join(root, "outer.zip")
+	writeZipWithEntries(t, zipPath, map[string][]byte{"bundle.tgz": tarData})
+
+	uz, err := New(root, false)
+	require.NoError(t, err)
+	files, err := getAllFilesRecursively(t.Context(), root)
+	require.NoError(t, err)
+
+	result, err := uz.ExtractArchivesWithProgressRecursively(t.Context(), files, nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, result.ExtractedArchives)
+	assert.Equal(t, 0, result.ErrorCount)
+
+	content, err := os.ReadFile(filepath.Join(root, "inner.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "from tarball", string(content))
+	assert.NoFileExists(t, filepath.Join(root, "bundle.tgz"))
+}
+
+func TestInspectTarArchive(t *testing.T) {
+	root := t.TempDir()
+	archivePath := filepath.Join(root, "preview.tar.xz")
+	require.NoError(t, os.WriteFile(archivePath, xzBytes(t, buildTar(t, []tarMember{
+		{name: "dir/", typeflag: tar.TypeDir},
+		{name: "dir/file.txt", typeflag: tar.TypeReg, body: "x"},
+		{name: "dir/bad-link", typeflag: tar.TypeSymlink, linkname: "/tmp"},
+	})), 0o644))
+
+	uz, err := New(root, true)
+	require.NoError(t, err)
+
+	op, err := inspectArchiveWithValidator(
+		collector.FileInfo{Dir: root, Name: "preview.tar.xz", Path: archivePath},
+		uz.validator,
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, op.ExtractedDirs)
+	assert.Equal(t, 1, op.ExtractedFiles)
+	assert.Equal(t, 1, op.SkippedEntries)
+	assert.NoDirExists(t, filepath.Join(root, "dir"))
+}
+
+func TestIsArchiveRecognizesTarFormats(t *testing.T) {
+	root := t.TempDir()
+	data := buildTar(t, []tarMember{{name: "a.txt", typeflag: tar.TypeReg, body: "a"}})
+
+	paths := map[string][]byte{
+		"plain.tar":   data,
+		"gz.tgz":      gzipBytes(t, data),
+		"bz.tar.bz2":  bzip2HelloTar,
+		"xz.tar.xz":   xzBytes(t, data),
+		"zst.tar.zst": zstdBytes(t, data),
+	}
+	for name, content := range paths {
+		path := filepath.Join(root, name)
+		require.NoError(t, os.WriteFile(path, content, 0o644))
+		assert.True(t, isArchive(path), "isArchive(%s)", name)
+	}
+
+	gzPlain := filepath.Join(root, "notes.txt.gz")
+	require.NoError(t, os.WriteFile(gzPlain, gzipBytes(t, []byte("just some text")), 0o644))
+	assert.False(t, isArchive(gzPlain), "a gzip stream without a tar inside is not an archive")
+}
+
+func buildTar(t *testing.T, members []tarMember) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, m := range members {
+		hdr := &tar.Header{
+			Name:     m.name,
+			Typeflag: m.typeflag,
+			Linkname: m.linkname,
+			Mode:     0o644,
+		}
+		if m.typeflag == tar.TypeDir {
+			hdr.Mode = 0o755
+		}
+		if m.typeflag == tar.TypeReg {
+			hdr.Size = int64(len(m.body))
+		}
+		require.NoError(t, tw.WriteHeader(hdr))
+		if m.typeflag == tar.TypeReg {
+			_, err := io.WriteString(tw, m.body)
+			require.NoError(t, err)
+		}
+	}
+	require.NoError(t, tw.Close())
+
+	return buf.Bytes()
+}
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err := zw.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	return buf.Bytes()
+}
+
+func xzBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	xw, err := xz.NewWriter(&buf)
+	require.NoError(t, err)
+	_, err = xw.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, xw.Close())
+
+	return buf.Bytes()
+}
+
+func zstdBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+
+	zw, err := zstd.NewWriter(nil)
+	require.NoError(t, err)
+	defer zw.Close()
+
+	return zw.EncodeAll(data, nil)
+}
+
+func writeZipWithEntries(t *testing.T, zipPath string, entries map[string][]byte) {
+	t.Helper()
+
+	f, err := os.Create(zipPath)
+	require.NoError(t, err)
+
+	zw := zip.NewWriter(f)
+	for name, content := range entries {
+		w, createErr := zw.Create(name)
+		require.NoError(t, createErr)
+		_, err = w.Write(content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	require.NoError(t, f.Close())
+}
@@ -1,8 +1,9 @@
-// Package unzipper extracts zip archives safely within a root directory.
+// Package unzipper extracts zip, 7z and tar archives safely within a root directory.
 package unzipper
 
 import (
 	"archive/zip"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -16,6 +17,7 @@ import (
 	"btidy/pkg/collector"
 	"btidy/pkg/hasher"
 	"btidy/pkg/safepath"
+	"btidy/pkg/sevenzip"
 	"btidy/pkg/trash"
 )
 
@@ -42,6 +44,10 @@ var (
 	// directory.
 	errArchiveEntryPathTraversal = errors.New("contains path traversal")
 
+	// errUnsupportedEntryType is reported for archive entries such as device
+	// nodes and FIFOs that are never recreated on disk.
+	errUnsupportedEntryType = errors.New("unsupported entry type")
+
 	// errArchiveEntryInvalidPath is returned when an archive
 	// entry name is malformed: empty, contains NUL bytes,
 	// or has degenerate path segments like "." or "".
@@ -76,9 +82,16 @@ type ExtractOperation struct {
 	EntryErrors []string
 
 	// NestedArchives is the count of archive files
-	// discovered within this archive during extraction.
+	// discovered within this archive during extraction
+	// and written to disk for a later pass.
 	NestedArchives int
 
+	// StreamedArchives lists the archives nested in this
+	// archive that were extracted from memory, in the
+	// order they were finished, so an archive nested in a
+	// streamed one comes before it.
+	StreamedArchives []StreamedArchive
+
 	// ExtractionComplete indicates whether the archive
 	// was fully extracted without fatal errors.
 	ExtractionComplete bool
@@ -106,6 +119,72 @@ type ExtractOperation struct {
 	// ReplacedFiles contains files that existed before extraction and were moved
 	// to trash to prevent overwrite data loss.
 	ReplacedFiles []ReplacedFile
+
+	// Decompressed indicates that ArchivePath was a standalone compressed
+	// file (e.g. "dump.sql.gz") rather than a multi-entry archive.
+	Decompressed bool
+
+	// DestDir is the directory the archive entries were extracted into. It
+	// depends on the configured [Layout].
+	DestDir string
+
+	// OutputPath is the file written by a standalone decompression.
+	OutputPath string
+
+	// OutputHash is the content hash of OutputPath, recorded so the
+	// decompression can be verified and reversed by undo.
+	OutputHash string
+
+	// ExtractedBytes is the uncompressed size written by the extraction,
+	// as measured before it started.
+	ExtractedBytes int64
+
+	// ArchiveHash is the content hash of ArchivePath.
+	ArchiveHash string
+
+	// Duplicate indicates that ArchivePath was byte-identical to an archive
+	// already extracted in this run and was removed without extraction.
+	Duplicate bool
+
+	// DuplicateOf is the path of the extracted archive that ArchivePath
+	// duplicated.
+	DuplicateOf string
+
+	// CreatedFiles lists every file written by the extraction, in
+	// extraction order, so undo can remove them again.
+	CreatedFiles []CreatedFile
+
+	// CreatedDirs lists directories that did not exist before the
+	// extraction, parents before children.
+	CreatedDirs []string
+
+	// Salvaged indicates that ArchivePath was damaged and its entries were
+	// recovered from local file headers. The archive is kept.
+	Salvaged bool
+
+	// Parts lists the .zNN volumes of a split zip archive in order; the
+	// .zip tail is ArchivePath. It is empty for other archives.
+	Parts []string
+
+	// TrashedParts holds the trash location of each of Parts once the
+	// archive has been removed with a trasher.
+	TrashedParts []string
+
+	// createdIndex maps a path in CreatedFiles to its slice index so an
+	// archive that writes the same path twice is recorded once.
+	createdIndex map[string]int
+
+	// dirMetadata collects directory entry modes and times, applied once
+	// all entries are written.
+	dirMetadata []dirMetadata
+
+	// pendingLinks collects symlinks to materialize once all entries are
+	// written.
+	pendingLinks []pendingLink
+
+	// nested is the member chain of the streamed archive whose entries are
+	// being extracted, recorded with each created file.
+	nested []string
 }
 
 // ReplacedFile describes one pre-existing file that was moved to trash before
@@ -116,6 +195,16 @@ type ReplacedFile struct {
 	Hash         string
 }
 
+// CreatedFile describes one file written by an extraction. Hash is the
+// content hash at creation time; it is empty for symlinks. Nested is the
+// [StreamedArchive.Chain] of the nested archive the file came from, or
+// empty when it came from the archive on disk.
+type CreatedFile struct {
+	Path   string
+	Hash   string
+	Nested []string
+}
+
 // Result contains the aggregated statistics and outcomes from an unzip operation.
 // Use this to understand the overall impact of extraction: how many archives were
 // found and processed, how many files were extracted, and whether any errors occurred.
@@ -148,8 +237,31 @@ type Result struct {
 	// SkippedCount is the number of archives skipped (e.g., already extracted).
 	SkippedCount int
 
+	// DecompressedFiles is the number of standalone compressed files that were
+	// decompressed in place.
+	DecompressedFiles int
+
 	// ErrorCount is the number of archives that failed to extract.
 	ErrorCount int
+
+	// ExtractedBytes is the total uncompressed size of everything extracted
+	// and decompressed.
+	ExtractedBytes int64
+
+	// DuplicateArchives is the number of archives removed without extraction
+	// because an identical archive had already been extracted.
+	DuplicateArchives int
+
+	// SalvagedArchives is the number of damaged archives whose verified
+	// entries were extracted in salvage mode. They are counted in
+	// ExtractedArchives too, but never deleted.
+	SalvagedArchives int
+
+	// StreamedArchives is the number of nested archives extracted from
+	// memory without being written to disk. They are counted in
+	// ExtractedArchives too; byte-identical copies of an archive already
+	// extracted are counted in DuplicateArchives instead.
+	StreamedArchives int
 }
 
 // Unzipper extracts archives recursively while enforcing path containment.
@@ -204,6 +316,147 @@ type Unzipper struct {
 	// instead of being permanently deleted. This allows undo operations.
 	// If nil, archives are permanently deleted when deletion is requested.
 	trasher *trash.Trasher
+
+	// decompressSingleFiles enables in-place decompression of standalone
+	// gzip, bzip2, xz and zstd files that do not contain a tar archive.
+	decompressSingleFiles bool
+
+	// layout selects where each archive's entries are written.
+	layout Layout
+
+	// nameEncoding decodes zip entry names that are not marked as UTF-8.
+	nameEncoding NameEncoding
+
+	// limits bounds nesting depth, extracted bytes and compression ratio.
+	limits Limits
+
+	// workers is the number of archives of one nesting level that are
+	// hashed and extracted concurrently.
+	workers int
+
+	// symlinks selects how symlink entries are extracted.
+	symlinks SymlinkPolicy
+
+	// salvage enables recovery of verified entries from damaged zip
+	// archives; see [WithSalvage].
+	salvage bool
+
+	// passwords decrypt encrypted zip entries; see [WithPasswords].
+	passwords []string
+
+	// inMemoryLimit is the largest nested archive extracted from memory;
+	// see [WithInMemoryLimit].
+	inMemoryLimit int64
+}
+
+// extractConfig carries the Unzipper settings that affect how a single
+// archive is read. The zero value uses the package defaults.
+type extractConfig struct {
+	// nameEncoding decodes zip entry names that are not marked as UTF-8.
+	nameEncoding NameEncoding
+
+	// symlinks selects how symlink entries are extracted; the zero value
+	// behaves as [SymlinksSkip].
+	symlinks SymlinkPolicy
+
+	// salvage reads zip archives without a usable central directory from
+	// their local file headers.
+	salvage bool
+
+	// passwords are tried on encrypted zip entries.
+	passwords []string
+
+	// nested extracts archives found among the entries from memory; when
+	// nil they are written to disk like any other file.
+	nested *nestedExtractor
+}
+
+// Option configures an Unzipper.
+type Option func(*Unzipper)
+
+// WithInMemoryLimit sets the size up to which an archive nested in another
+// one is extracted from memory, without being written to disk. Larger
+// nested archives are written out and extracted by a later pass; zero
+// writes every nested archive to disk. The default is
+// [DefaultInMemoryLimit].
+func WithInMemoryLimit(limit int64) Option {
+	return func(u *Unzipper) {
+		u.inMemoryLimit = limit
+	}
+}
+
+// WithLayout selects where archive entries are extracted. The default is
+// [LayoutParent].
+func WithLayout(layout Layout) Option {
+	return func(u *Unzipper) {
+		u.layout = layout
+	}
+}
+
+// WithLimits replaces the zip-bomb limits. The default is [DefaultLimits].
+func WithLimits(limits Limits) Option {
+	return func(u *Unzipper) {
+		u.limits = limits
+	}
+}
+
+// WithNameEncoding selects the legacy code page used for zip entry names
+// that carry neither the UTF-8 flag nor an Info-ZIP Unicode Path extra
+// field. The default is [NameEncodingCP437].
+func WithNameEncoding(enc NameEncoding) Option {
+	return func(u *Unzipper) {
+		u.nameEncoding = enc
+	}
+}
+
+// WithPasswords supplies passwords for encrypted zip entries, using
+// traditional PKWARE (ZipCrypto) or WinZip AES encryption. Each encrypted
+// entry is decrypted with the first password that verifies before anything
+// is written; an archive with an entry none of them decrypts is skipped.
+// Without passwords, archives with encrypted entries are skipped.
+func WithPasswords(passwords []string) Option {
+	return func(u *Unzipper) {
+		u.passwords = passwords
+	}
+}
+
+// WithSalvage enables salvage mode: zip archives whose central directory is
+// damaged or missing, such as truncated copies, are scanned for local file
+// headers and every entry whose CRC-32 verifies is extracted. Entries that
+// cannot be verified are reported in [ExtractOperation.EntryErrors]. A
+// salvaged archive is never removed.
+func WithSalvage(enabled bool) Option {
+	return func(u *Unzipper) {
+		u.salvage = enabled
+	}
+}
+
+// WithSingleFileDecompression enables in-place decompression of standalone
+// compressed files such as "dump.sql.gz" or "app.log.bz2". The compressed
+// original is removed (moved to trash when a trasher is configured) after
+// the decompressed file has been written.
+func WithSingleFileDecompression(enabled bool) Option {
+	return func(u *Unzipper) {
+		u.decompressSingleFiles = enabled
+	}
+}
+
+// WithSymlinkPolicy selects how symlink entries in zip, 7z and tar archives are
+// extracted. The default is [SymlinksSkip]; [SymlinksCreate] is opt-in.
+func WithSymlinkPolicy(policy SymlinkPolicy) Option {
+	return func(u *Unzipper) {
+		u.symlinks = policy
+	}
+}
+
+// WithWorkers sets how many archives of one nesting level are extracted
+// concurrently. Values below 1 are ignored. The default is 1.
+func WithWorkers(n int) Option {
+	return func(u *Unzipper) {
+		if n > 0 {
+			u.workers = n
+		}
+	}
 }
 
 // New creates an Unzipper rooted at rootDir.
@@ -222,16 +475,49 @@ func NewWithValidator(
 	validator *safepath.Validator,
 	dryRun bool,
 	trasher *trash.Trasher,
+	opts ...Option,
 ) (*Unzipper, error) {
 	if validator == nil {
 		return nil, errors.New("validator is required")
 	}
 
-	return &Unzipper{
-		dryRun:    dryRun,
-		validator: validator,
-		trasher:   trasher,
-	}, nil
+	u := &Unzipper{
+		dryRun:       dryRun,
+		validator:    validator,
+		trasher:      trasher,
+		layout:       LayoutParent,
+		nameEncoding: NameEncodingCP437,
+		limits:       DefaultLimits(),
+		workers:      1,
+		symlinks:     SymlinksSkip,
+
+		inMemoryLimit: DefaultInMemoryLimit,
+	}
+	for _, opt := range opts {
+		opt(u)
+	}
+
+	if _, err := ParseLayout(string(u.layout)); err != nil {
+		return nil, err
+	}
+
+	if _, err := ParseNameEncoding(string(u.nameEncoding)); err != nil {
+		return nil, err
+	}
+
+	if err := u.limits.validate(); err != nil {
+		return nil, err
+	}
+
+	if _, err := ParseSymlinkPolicy(string(u.symlinks)); err != nil {
+		return nil, err
+	}
+
+	if u.inMemoryLimit < 0 {
+		return nil, errors.New("in-memory limit must not be negative")
+	}
+
+	return u, nil
 }
 
 // ExtractArchivesWithProgressRecursively extracts all archive files from the
@@ -246,11 +532,17 @@ func NewWithValidator(
 // It determines the root directory from the common ancestor of all provided files
 // and returns an empty [Result] if the file list is empty. On each iteration,
 // only archive files are selected for extraction; after extraction, the directory
-// is re-collected to find any newly revealed archives.
+// is re-collected to find any newly revealed archives. Archives that would
+// break the configured [Limits] are skipped and left in place. Once ctx is
+// done no further archive or entry is started: an archive being extracted
+// stops after its current entry and is kept, its operation recording the
+// files written so far with the context error, and the result covers the
+// archives processed so far.
 //
 // Returns the aggregated [Result] and any error encountered during extraction or
 // file collection.
 func (u *Unzipper) ExtractArchivesWithProgressRecursively(
+	ctx context.Context,
 	files []collector.FileInfo,
 	progress func(stage string, processed, total int),
 ) (Result, error) {
@@ -262,21 +554,28 @@ func (u *Unzipper) ExtractArchivesWithProgressRecursively(
 	}
 
 	processed := make(map[string]bool)
+	state := newRunState()
 
-	for {
-		archives := filterNewArchives(files, processed)
+	// Each pass extracts the archives revealed by the previous one, so the
+	// pass number is the nesting depth checked against [Limits.MaxDepth].
+	for depth := 1; ctx.Err() == nil; depth++ {
+		archives := filterNewArchives(u.filterCandidates(files), processed)
 		if len(archives) == 0 {
 			break
 		}
 
 		res.ArchivesFound += len(archives)
+		state.depth = depth
 
-		if err := u.extractBatch(archives, processed, progress, &res); err != nil {
+		if err := u.extractBatch(ctx, archives, processed, state, progress, &res); err != nil {
 			return res, err
 		}
+		if ctx.Err() != nil {
+			break
+		}
 
 		var err error
-		files, err = getAllFilesRecursively(rootDir)
+		files, err = getAllFilesRecursively(ctx, rootDir)
 		if err != nil {
 			return res, err
 		}
@@ -287,10 +586,23 @@ func (u *Unzipper) ExtractArchivesWithProgressRecursively(
 	return res, nil
 }
 
-// filterNewArchives returns archives from files that have not yet been processed.
-func filterNewArchives(files []collector.FileInfo, processed map[string]bool) []collector.FileInfo {
-	archives := filterOnlyArchives(files)
+// filterCandidates returns the files this Unzipper should process: every
+// archive, every standalone compressed file when single-file decompression
+// is enabled, and every damaged zip archive in salvage mode.
+func (u *Unzipper) filterCandidates(files []collector.FileInfo) []collector.FileInfo {
+	candidates := filterOnlyArchives(files)
+	if u.decompressSingleFiles {
+		candidates = append(candidates, filterOnlyCompressedFiles(files)...)
+	}
+	if u.salvage {
+		candidates = append(candidates, filterSalvageCandidates(files)...)
+	}
 
+	return candidates
+}
+
+// filterNewArchives returns the archives that have not yet been processed.
+func filterNewArchives(archives []collector.FileInfo, processed map[string]bool) []collector.FileInfo {
 	unprocessed := make([]collector.FileInfo, 0, len(archives))
 	for _, a := range archives {
 		key := filepath.Join(a.Dir, a.Name)
@@ -302,28 +614,69 @@ func filterNewArchives(files []collector.FileInfo, processed map[string]bool) []
 	return unprocessed
 }
 
-// extractBatch processes a batch of archives, updating the result and processed map.
+// extractBatch processes a batch of archives found at the nesting level in
+// state, updating the result and the processed map. Archives are hashed and
+// extracted with up to u.workers running at once, but archives whose write
+// scopes overlap never run together, and a later copy of an archive in the
+// same batch waits until the first copy is done so it is recognized as a
+// duplicate. Operations are recorded in batch order whatever order they
+// finish in. After an archive fails no further archives are started, and
+// the first failure in batch order is returned. The same holds once ctx is
+// done, without an error; an archive it interrupts is recorded with the
+// context error in its operation.
 func (u *Unzipper) extractBatch(
+	ctx context.Context,
 	archives []collector.FileInfo,
 	processed map[string]bool,
+	state *runState,
 	progress func(stage string, processed, total int),
 	res *Result,
 ) error {
+	if progress != nil {
+		progress(progressStageExtracting, 0, len(archives))
+	}
+
+	paths := make([]string, len(archives))
 	for i, archive := range archives {
-		if progress != nil {
-			progress(progressStageExtracting, i, len(archives))
-		}
+		paths[i] = filepath.Join(archive.Dir, archive.Name)
+		processed[paths[i]] = true
+	}
+
+	hashes := u.hashArchives(ctx, archives)
+	first, second := splitDuplicateWaves(paths, hashes)
 
-		archivePath := filepath.Join(archive.Dir, archive.Name)
-		processed[archivePath] = true
+	batch := &batchRun{
+		ops:  make([]ExtractOperation, len(archives)),
+		errs: make([]error, len(archives)),
+		ran:  make([]bool, len(archives)),
+	}
+	u.runWave(ctx, first, archives, hashes, state, batch, progress)
+	if !batch.failed {
+		u.runWave(ctx, second, archives, hashes, state, batch, progress)
+	}
 
-		op, err := u.processArchive(archive, archivePath)
+	var firstErr error
+	for i := range archives {
+		if !batch.ran[i] {
+			continue
+		}
+
+		op := batch.ops[i]
 		res.ArchivesProcessed++
 
-		if err != nil {
+		if err := batch.errs[i]; err != nil {
+			// An archive cut short by ctx is reported with what it
+			// wrote, but the run was interrupted, not failed.
+			if ctxErr := ctx.Err(); ctxErr != nil && errors.Is(err, ctxErr) {
+				res.Operations = append(res.Operations, op)
+				continue
+			}
 			res.ErrorCount++
 			res.Operations = append(res.Operations, op)
-			return err
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
 		}
 
 		if op.Skipped {
@@ -332,14 +685,48 @@ func (u *Unzipper) extractBatch(
 			continue
 		}
 
+		if op.Duplicate {
+			res.DuplicateArchives++
+			op.DeletedArchive = true
+			res.Operations = append(res.Operations, op)
+			continue
+		}
+
+		res.ExtractedBytes += op.ExtractedBytes
+
+		if op.Decompressed {
+			res.DecompressedFiles++
+			res.ExtractedFiles += op.ExtractedFiles
+			op.DeletedArchive = true
+			res.Operations = append(res.Operations, op)
+			continue
+		}
+
 		res.ExtractedArchives++
 		res.ExtractedFiles += op.ExtractedFiles
+		for _, streamed := range op.StreamedArchives {
+			if streamed.DuplicateOf != "" {
+				res.DuplicateArchives++
+				continue
+			}
+			res.ExtractedArchives++
+			res.StreamedArchives++
+		}
 		res.ExtractedDirs += op.ExtractedDirs
 
+		if op.Salvaged {
+			res.SalvagedArchives++
+			res.Operations = append(res.Operations, op)
+			continue
+		}
+
 		op.DeletedArchive = true
 		res.DeletedArchives++
 		res.Operations = append(res.Operations, op)
 	}
+	if firstErr != nil {
+		return firstErr
+	}
 
 	if progress != nil {
 		progress(progressStageExtracting, len(archives), len(archives))
@@ -348,28 +735,156 @@ func (u *Unzipper) extractBatch(
 	return nil
 }
 
-// processArchive extracts or inspects a single archive, then removes it if not in dry-run mode.
-// processArchive handles a single archive file by either inspecting it (dry-run)
+// config returns the per-archive reading settings of u.
+func (u *Unzipper) config() extractConfig {
+	return extractConfig{
+		nameEncoding: u.nameEncoding,
+		symlinks:     u.symlinks,
+		salvage:      u.salvage,
+		passwords:    u.passwords,
+	}
+}
+
+// processHashedArchive handles a single archive file by either inspecting it (dry-run)
 // or extracting its contents to the archive's parent directory. In non-dry-run mode,
 // the source archive is removed after successful extraction — moved to trash if a
-// trasher is configured, or permanently deleted otherwise.
+// trasher is configured, or permanently deleted otherwise. Standalone
+// compressed files are handed to [Unzipper.processCompressedFile] when
+// single-file decompression is enabled.
+//
+// hashed is the archive's content hash, computed before the batch started:
+// a byte-identical copy of an archive already extracted in this run is
+// removed by [Unzipper.processDuplicateArchive] instead of being extracted
+// again. Other archives are checked against the configured [Limits]; an
+// archive that would break one is returned as skipped and is not removed.
 //
 // The returned [ExtractOperation] contains extraction statistics (files, dirs,
 // nested archives) and, when applicable, the trash destination path. If extraction
 // or archive removal fails, the partial operation result is returned alongside the
 // error, with op.Error set to the cause.
-func (u *Unzipper) processArchive(archive collector.FileInfo, archivePath string) (ExtractOperation, error) {
+func (u *Unzipper) processHashedArchive(
+	ctx context.Context,
+	archive collector.FileInfo,
+	archivePath string,
+	hashed hasher.HashResult,
+	state *runState,
+) (ExtractOperation, error) {
+	format, single := compressionNone, false
+	if u.decompressSingleFiles {
+		format, single = singleFileCompression(archivePath)
+	}
+
+	if hashed.Error != nil {
+		err := fmt.Errorf("failed to hash %s: %w", archivePath, hashed.Error)
+		op := ExtractOperation{ArchivePath: archivePath, Error: err}
+		return op, err
+	}
+	archiveHash := hashed.Hash
+	if kept, ok := state.keptCopy(archiveHash); ok {
+		return u.processDuplicateArchive(archivePath, archiveHash, kept)
+	}
+
+	usage, skipReason, err := u.checkLimits(archive, archivePath, format, single, state)
+	if isUnreadableArchive(err) {
+		// The archive cannot be read in full, e.g. a zip symlink target is
+		// encrypted or uses an unsupported compression method, or a split
+		// archive lacks a volume. Skip it, keeping every file, as
+		// extraction would.
+		skipReason = err.Error()
+		err = nil
+	}
+	if err != nil {
+		op := ExtractOperation{ArchivePath: archivePath, Decompressed: single, Error: err}
+		return op, err
+	}
+	if skipReason != "" {
+		op := ExtractOperation{ArchivePath: archivePath, Skipped: true, SkipReason: skipReason}
+		return op, nil
+	}
+
 	var op ExtractOperation
-	var err error
+	if single {
+		op, err = u.processCompressedFile(archive, archivePath, format)
+	} else {
+		op, err = u.processMultiEntryArchive(ctx, archive, archivePath, state)
+	}
+
+	extracted := err == nil && !op.Skipped
+	state.finish(usage.bytes, extracted, archiveHash, archivePath)
+	if extracted {
+		op.ExtractedBytes = addSize(op.ExtractedBytes, usage.bytes)
+		op.ArchiveHash = archiveHash
+	}
+
+	return op, err
+}
+
+// processDuplicateArchive removes an archive whose content is identical to
+// the already extracted archive kept, since extracting it would only
+// recreate the same files. The archive is moved to trash when a trasher is
+// configured; in dry-run mode it is only reported.
+func (u *Unzipper) processDuplicateArchive(archivePath, archiveHash, kept string) (ExtractOperation, error) {
+	op := ExtractOperation{
+		ArchivePath: archivePath,
+		ArchiveHash: archiveHash,
+		Duplicate:   true,
+		DuplicateOf: kept,
+	}
+
+	parts, err := splitArchiveParts(archivePath)
+	if err != nil {
+		op.Error = err
+		return op, err
+	}
+	op.Parts = parts
 
 	if u.dryRun {
-		op, err = inspectArchiveWithValidator(archive, u.validator)
+		return op, nil
+	}
+
+	if err := u.removeArchiveVolumes(&op); err != nil {
+		op.Error = err
+		return op, err
+	}
+
+	return op, nil
+}
+
+// processMultiEntryArchive extracts (or, in dry-run mode, inspects) a zip or
+// tar archive into its layout destination and removes it afterwards. The
+// volumes of a split zip archive are removed with its tail; an archive
+// recovered in salvage mode is kept. Archives nested in it are extracted
+// from memory when they are small enough; see [WithInMemoryLimit].
+func (u *Unzipper) processMultiEntryArchive(
+	ctx context.Context,
+	archive collector.FileInfo,
+	archivePath string,
+	state *runState,
+) (ExtractOperation, error) {
+	destDir, err := u.destinationDir(archive, archivePath)
+	if err != nil {
+		op := ExtractOperation{ArchivePath: archivePath, Error: err}
+		return op, err
+	}
+
+	parts, err := splitArchiveParts(archivePath)
+	if err != nil {
+		op := ExtractOperation{ArchivePath: archivePath, Error: err}
+		return op, err
+	}
+
+	var op ExtractOperation
+	if u.dryRun {
+		op, err = inspectArchiveInto(archive, destDir, u.config(), u.validator)
 	} else {
-		op, err = unzipWithValidator(archive, u.validator, u.trasher)
+		cfg := u.config()
+		cfg.nested = u.newNestedExtractor(state, state.archiveDepth(archivePath))
+		op, err = unzipInto(ctx, archive, destDir, cfg, u.validator, u.trasher)
 	}
+	op.Parts = parts
 
 	if err != nil {
-		if errors.Is(err, zip.ErrAlgorithm) {
+		if isUnreadableArchive(err) {
 			op.Skipped = true
 			op.SkipReason = err.Error()
 			op.Error = nil
@@ -379,18 +894,52 @@ func (u *Unzipper) processArchive(archive collector.FileInfo, archivePath string
 		return op, err
 	}
 
-	if !u.dryRun {
-		trashedTo, rmErr := u.removeArchive(archivePath)
-		if rmErr != nil {
+	// A salvaged archive is kept: what could not be verified may still be
+	// recoverable with other tools.
+	if !u.dryRun && !op.Salvaged {
+		if rmErr := u.removeArchiveVolumes(&op); rmErr != nil {
 			op.Error = rmErr
 			return op, rmErr
 		}
-		op.TrashedTo = trashedTo
 	}
 
 	return op, nil
 }
 
+// isUnreadableArchive reports whether err means an archive cannot be read
+// as a whole: it uses an unsupported compression method, has encrypted
+// entries without a matching password, or is a split archive that lacks a
+// volume. Such archives are skipped and kept rather than counted as errors.
+func isUnreadableArchive(err error) bool {
+	return errors.Is(err, zip.ErrAlgorithm) ||
+		errors.Is(err, sevenzip.ErrAlgorithm) ||
+		errors.Is(err, errEncryptedEntries) ||
+		errors.Is(err, errWrongPassword) ||
+		errors.Is(err, errSplitVolumeMissing)
+}
+
+// removeArchiveVolumes removes the archive of op, followed by the volumes
+// of a split archive, and records where each was trashed.
+func (u *Unzipper) removeArchiveVolumes(op *ExtractOperation) error {
+	trashedTo, err := u.removeArchive(op.ArchivePath)
+	if err != nil {
+		return err
+	}
+	op.TrashedTo = trashedTo
+
+	for _, part := range op.Parts {
+		partTrashedTo, err := u.removeArchive(part)
+		if err != nil {
+			return err
+		}
+		if partTrashedTo != "" {
+			op.TrashedParts = append(op.TrashedParts, partTrashedTo)
+		}
+	}
+
+	return nil
+}
+
 // removeArchive deletes or trashes the archive at the given path.
 // Returns the trash destination path (non-empty only when using a trasher).
 func (u *Unzipper) removeArchive(archivePath string) (string, error) {
@@ -554,7 +1103,7 @@ func resolveArchiveEntryPath(
 	return targetPath, nil
 }
 
-// unzip extracts all entries from the zip archive identified by file into the
+// unzip extracts all entries from the archive identified by file into the
 // same directory that contains the archive. It creates directories as needed and
 // writes regular files with their archived content. Archive entries containing
 // path traversal components (e.g., "../") are rejected to prevent zip-slip
@@ -564,16 +1113,19 @@ func unzip(file collector.FileInfo) (ExtractOperation, error) {
 	return unzipWithValidator(file, nil, nil)
 }
 
-// unzipWithValidator extracts all entries from the zip archive identified by file
-// into the archive's parent directory, optionally enforcing path containment via
+// unzipWithValidator extracts all entries from the zip, 7z or tar archive identified
+// by file into the archive's parent directory, optionally enforcing path containment via
 // the provided [safepath.Validator]. When validator is non-nil, every resolved
 // extraction path is checked to ensure it remains within the allowed root
 // directory; a nil validator skips this additional check (basic path-traversal
 // validation is still performed by [resolveArchiveEntryPath]).
 //
 // For each archive entry, directories are created with their archived permission
-// bits (ORed with 0o755), and regular files are written via [extractFile]. Any
-// extracted file that is itself a recognized archive format increments the
+// bits (ORed with 0o755), regular files are written via [extractFile], and tar
+// link entries are handled by [extractSymlinkEntry] and [extractHardlinkEntry].
+// Files keep the entry's modification time and permission bits; directories
+// created by the extraction get theirs once every entry has been written.
+// Any extracted file that is itself a recognized archive format increments the
 // NestedArchives counter in the returned [ExtractOperation].
 //
 // Extraction stops on the first error encountered and returns both the partial
@@ -582,11 +1134,27 @@ func unzipWithValidator(
 	file collector.FileInfo,
 	validator *safepath.Validator,
 	trasher *trash.Trasher,
+) (ExtractOperation, error) {
+	return unzipInto(context.Background(), file, file.Dir, extractConfig{}, validator, trasher)
+}
+
+// unzipInto extracts the archive identified by file into destDir, which is
+// created (and recorded in CreatedDirs) when it does not exist yet. Entry
+// paths, link targets and hard link sources are all resolved relative to
+// destDir, and cfg controls how the archive is read. See
+// [unzipWithValidator] for the extraction rules.
+func unzipInto(
+	ctx context.Context,
+	file collector.FileInfo,
+	destDir string,
+	cfg extractConfig,
+	validator *safepath.Validator,
+	trasher *trash.Trasher,
 ) (ExtractOperation, error) {
 	archivePath := filepath.Join(file.Dir, file.Name)
-	op := ExtractOperation{ArchivePath: archivePath}
+	op := ExtractOperation{ArchivePath: archivePath, DestDir: destDir}
 
-	r, err := openArchiveReader(archivePath)
+	r, err := openArchive(archivePath, cfg)
 	if err != nil {
 		op.Error = fmt.Errorf("failed to open archive %s: %w", archivePath, err)
 		return op, op.Error
@@ -594,90 +1162,208 @@ func unzipWithValidator(
 	defer func() {
 		_ = r.Close()
 	}()
+	_, op.Salvaged = r.(*salvageArchive)
 
-	if methodErr := validateCompressionMethods(r.files); methodErr != nil {
+	if methodErr := r.validate(); methodErr != nil {
 		op.Error = methodErr
 		return op, op.Error
 	}
 
-	for _, entry := range r.files {
-		if entryErr := extractArchiveEntry(file, entry, validator, trasher, &op); entryErr != nil {
-			op.Error = entryErr
-			return op, op.Error
-		}
+	if extractErr := extractEntries(ctx, r, destDir, cfg, validator, trasher, &op); extractErr != nil {
+		op.Error = extractErr
+		return op, op.Error
+	}
+
+	if linkErr := materializeLinks(validator, trasher, &op); linkErr != nil {
+		op.Error = linkErr
+		return op, op.Error
+	}
+
+	if metaErr := applyDirMetadata(&op); metaErr != nil {
+		op.Error = metaErr
+		return op, op.Error
 	}
 
 	op.ExtractionComplete = true
 	return op, nil
 }
 
-// extractArchiveEntry extracts a single zip entry into the directory containing
-// the source archive. For directory entries, it creates the target directory with
-// the archived permission bits (ORed with 0o755). For regular files, it ensures
-// the parent directory exists, backs up any pre-existing file at the target path
-// to trash (when a [trash.Trasher] is configured), and writes the entry content
-// via [extractFile]. Extracted files that are themselves recognized archive
-// formats increment op.NestedArchives for later recursive processing.
+// extractEntries creates destDir and extracts every entry of r into it,
+// recording what it wrote in op. Links to materialize and directory
+// metadata are left for the caller to apply once every nested archive is
+// extracted too. Once ctx is done no further entry is extracted and the
+// context error is returned; op keeps what was written before.
+func extractEntries(
+	ctx context.Context,
+	r archiveSource,
+	destDir string,
+	cfg extractConfig,
+	validator *safepath.Validator,
+	trasher *trash.Trasher,
+	op *ExtractOperation,
+) error {
+	if err := mkdirAllTracked(destDir, 0o755, op); err != nil {
+		return fmt.Errorf("failed to create destination %s: %w", destDir, err)
+	}
+
+	return r.walk(func(entry archiveEntry) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return extractArchiveEntry(ctx, destDir, entry, cfg, validator, trasher, op)
+	})
+}
+
+// extractArchiveEntry extracts a single archive entry into destDir. For directory entries, it creates the target
+// directory with the archived permission bits (ORed with 0o755). For regular
+// files, it ensures the parent directory exists, backs up any pre-existing file
+// at the target path to trash (when a [trash.Trasher] is configured), and writes
+// the entry content via [extractFile]. Extracted files that are themselves
+// recognized archive formats increment op.NestedArchives for later recursive
+// processing. Link entries whose target cannot be contained, symlinks refused
+// by the cfg symlink policy, and special files such as device nodes, are
+// skipped and reported in op.EntryErrors, as are entries a salvage scan
+// could not verify. Symlinks to be materialized are
+// queued in op for [materializeLinks], since their target may come later.
+// A file that is itself an archive is extracted from memory instead of
+// being written when cfg has a nested extractor that accepts it.
 //
 // All resolved paths are validated through the provided [safepath.Validator] to
 // prevent path traversal and symlink escape attacks. Returns an error on the
 // first failure; the caller receives partial statistics in op.
 func extractArchiveEntry(
-	file collector.FileInfo,
-	entry *zip.File,
+	ctx context.Context,
+	destDir string,
+	entry archiveEntry,
+	cfg extractConfig,
 	validator *safepath.Validator,
 	trasher *trash.Trasher,
 	op *ExtractOperation,
 ) error {
-	// Resolve the archive entry name to a safe absolute path under the archive's
-	// parent directory, validating against path traversal and symlink escapes.
-	targetPath, pathErr := resolveArchiveEntryPath(file.Dir, entry.Name, validator)
+	// Entries a salvage scan could not verify are reported, never written.
+	if entry.damaged != nil {
+		recordSkippedEntry(op, entry.name, entry.damaged)
+		return nil
+	}
+
+	// Resolve the archive entry name to a safe absolute path under the
+	// destination directory, validating against path traversal and symlink escapes.
+	targetPath, pathErr := resolveArchiveEntryPath(destDir, entry.name, validator)
 	if pathErr != nil {
-		return fmt.Errorf("illegal entry path %q: %w", entry.Name, pathErr)
+		return fmt.Errorf("illegal entry path %q: %w", entry.name, pathErr)
 	}
 
 	// If the entry is a directory, create it with the archived permissions
 	// (ensuring at least rwxr-xr-x via OR with 0o755) and return early.
-	if entry.FileInfo().IsDir() {
-		if mkErr := os.MkdirAll(targetPath, entry.Mode().Perm()|0o755); mkErr != nil {
+	if entry.kind == entryKindDir {
+		if mkErr := mkdirAllTracked(targetPath, entry.mode|0o755, op); mkErr != nil {
 			return fmt.Errorf("failed to create directory %s: %w", targetPath, mkErr)
 		}
+		op.dirMetadata = append(op.dirMetadata, dirMetadata{path: targetPath, mode: entry.mode, modTime: entry.modTime})
 		op.ExtractedDirs++
 		return nil
 	}
 
+	// Device nodes, FIFOs and similar special files are never recreated.
+	if entry.kind == entryKindOther {
+		recordSkippedEntry(op, entry.name, errUnsupportedEntryType)
+		return nil
+	}
+
+	// Links are checked for containment before anything is written so an
+	// escaping link never reaches the filesystem.
+	if linkErr := validateLinkEntry(destDir, targetPath, entry, validator); linkErr != nil {
+		recordSkippedEntry(op, entry.name, linkErr)
+		return nil
+	}
+
+	if entry.kind == entryKindSymlink {
+		switch cfg.symlinks {
+		case SymlinksSkip, "":
+			recordSkippedEntry(op, entry.name, fmt.Errorf("%w: -> %s", errSymlinkSkipped, entry.linkTarget))
+			return nil
+		case SymlinksMaterialize:
+			op.pendingLinks = append(op.pendingLinks, pendingLink{entry: entry, targetPath: targetPath, nested: op.nested})
+			return nil
+		}
+	}
+
+	// A nested archive is extracted straight from memory when it may be,
+	// so it never reaches disk.
+	if entry.kind == entryKindFile && cfg.nested != nil {
+		streamed, body, nestedErr := cfg.nested.extract(ctx, entry, targetPath, cfg, op)
+		if nestedErr != nil {
+			return nestedErr
+		}
+		if streamed {
+			return nil
+		}
+		entry = body
+	}
+
 	// For regular files, ensure the parent directory exists before writing.
 	parentDir := filepath.Dir(targetPath)
-	if mkErr := os.MkdirAll(parentDir, 0o755); mkErr != nil {
+	if mkErr := mkdirAllTracked(parentDir, 0o755, op); mkErr != nil {
 		return fmt.Errorf("failed to create parent directory %s: %w", parentDir, mkErr)
 	}
 
 	// If a file already exists at the target path and a trasher is configured,
-	// move the existing file to trash so it can be recovered via undo.
-	replaced, replacedFile, replaceErr := backupExistingFile(targetPath, trasher)
-	if replaceErr != nil {
-		return fmt.Errorf("failed to backup existing target %s: %w", targetPath, replaceErr)
-	}
-	// Track any replaced file in the operation result for journaling/undo support.
-	if replaced {
-		op.ReplacedFiles = append(op.ReplacedFiles, replacedFile)
+	// move the existing file to trash so it can be recovered via undo. A file
+	// written earlier by this same archive is simply overwritten.
+	if !op.createdByThisExtraction(targetPath) {
+		replaced, replacedFile, replaceErr := backupExistingFile(targetPath, trasher)
+		if replaceErr != nil {
+			return fmt.Errorf("failed to backup existing target %s: %w", targetPath, replaceErr)
+		}
+		// Track any replaced file in the operation result for journaling/undo support.
+		if replaced {
+			op.ReplacedFiles = append(op.ReplacedFiles, replacedFile)
+		}
 	}
 
 	// Decompress and write the archive entry contents to the target path.
-	if writeErr := extractFile(entry, targetPath); writeErr != nil {
-		return fmt.Errorf("failed to extract %s: %w", entry.Name, writeErr)
+	var writeErr error
+	switch entry.kind {
+	case entryKindSymlink:
+		writeErr = extractSymlinkEntry(entry, targetPath, validator)
+	case entryKindHardlink:
+		writeErr = extractHardlinkEntry(destDir, entry, targetPath, validator)
+	default:
+		writeErr = extractFile(entry, targetPath)
+	}
+	if writeErr != nil {
+		return fmt.Errorf("failed to extract %s: %w", entry.name, writeErr)
 	}
 	op.ExtractedFiles++
 
+	// Restore the archived permissions and timestamp; symlinks keep their
+	// own, since changing them would follow the link to its target.
+	if entry.kind != entryKindSymlink {
+		if metaErr := applyFileMetadata(targetPath, entry.mode, entry.modTime); metaErr != nil {
+			return fmt.Errorf("failed to restore metadata of %s: %w", entry.name, metaErr)
+		}
+	}
+
+	if recordErr := op.recordCreatedFile(targetPath, entry.kind != entryKindSymlink); recordErr != nil {
+		return fmt.Errorf("failed to record %s: %w", entry.name, recordErr)
+	}
+
 	// Check if the newly extracted file is itself an archive, so the caller
 	// can schedule it for recursive extraction in a subsequent pass.
-	if isArchive(targetPath) {
+	if entry.kind == entryKindFile && isArchive(targetPath) {
 		op.NestedArchives++
 	}
 
 	return nil
 }
 
+// recordSkippedEntry counts an archive entry that was deliberately not
+// extracted and keeps the reason for the operation report.
+func recordSkippedEntry(op *ExtractOperation, entryName string, reason error) {
+	op.SkippedEntries++
+	op.EntryErrors = append(op.EntryErrors, fmt.Sprintf("%s: %v", entryName, reason))
+}
+
 // backupExistingFile moves an existing extraction target to trash before
 // overwrite so the original bytes are recoverable.
 func backupExistingFile(targetPath string, trasher *trash.Trasher) (bool, ReplacedFile, error) {
@@ -714,7 +1400,7 @@ func backupExistingFile(targetPath string, trasher *trash.Trasher) (bool, Replac
 	}, nil
 }
 
-// inspectArchiveWithValidator performs a dry-run inspection of the zip archive
+// inspectArchiveWithValidator performs a dry-run inspection of the archive
 // identified by file, validating all entry paths against the provided
 // [safepath.Validator] without extracting any content to disk. It counts the
 // number of regular files and directories contained in the archive, returning
@@ -724,10 +1410,21 @@ func backupExistingFile(targetPath string, trasher *trash.Trasher) (bool, Replac
 // returning both the partial operation result and the error. This is used in
 // dry-run mode to preview what an extraction would produce.
 func inspectArchiveWithValidator(file collector.FileInfo, validator *safepath.Validator) (ExtractOperation, error) {
+	return inspectArchiveInto(file, file.Dir, extractConfig{}, validator)
+}
+
+// inspectArchiveInto is [inspectArchiveWithValidator] with entry paths
+// resolved against destDir instead of the archive's directory.
+func inspectArchiveInto(
+	file collector.FileInfo,
+	destDir string,
+	cfg extractConfig,
+	validator *safepath.Validator,
+) (ExtractOperation, error) {
 	archivePath := filepath.Join(file.Dir, file.Name)
-	op := ExtractOperation{ArchivePath: archivePath}
+	op := ExtractOperation{ArchivePath: archivePath, DestDir: destDir}
 
-	r, err := openArchiveReader(archivePath)
+	r, err := openArchive(archivePath, cfg)
 	if err != nil {
 		op.Error = fmt.Errorf("failed to open archive %s: %w", archivePath, err)
 		return op, op.Error
@@ -735,37 +1432,59 @@ func inspectArchiveWithValidator(file collector.FileInfo, validator *safepath.Va
 	defer func() {
 		_ = r.Close()
 	}()
+	_, op.Salvaged = r.(*salvageArchive)
 
-	if methodErr := validateCompressionMethods(r.files); methodErr != nil {
+	if methodErr := r.validate(); methodErr != nil {
 		op.Error = methodErr
 		return op, op.Error
 	}
 
-	for _, entry := range r.files {
-		if _, pathErr := resolveArchiveEntryPath(file.Dir, entry.Name, validator); pathErr != nil {
-			op.Error = fmt.Errorf("illegal entry path %q: %w", entry.Name, pathErr)
-			return op, op.Error
+	walkErr := r.walk(func(entry archiveEntry) error {
+		if entry.damaged != nil {
+			recordSkippedEntry(&op, entry.name, entry.damaged)
+			return nil
 		}
 
-		if entry.FileInfo().IsDir() {
+		targetPath, pathErr := resolveArchiveEntryPath(destDir, entry.name, validator)
+		if pathErr != nil {
+			return fmt.Errorf("illegal entry path %q: %w", entry.name, pathErr)
+		}
+
+		switch entry.kind {
+		case entryKindDir:
 			op.ExtractedDirs++
-			continue
+		case entryKindOther:
+			recordSkippedEntry(&op, entry.name, errUnsupportedEntryType)
+		default:
+			if linkErr := validateLinkEntry(destDir, targetPath, entry, validator); linkErr != nil {
+				recordSkippedEntry(&op, entry.name, linkErr)
+				return nil
+			}
+			if entry.kind == entryKindSymlink && (cfg.symlinks == SymlinksSkip || cfg.symlinks == "") {
+				recordSkippedEntry(&op, entry.name, fmt.Errorf("%w: -> %s", errSymlinkSkipped, entry.linkTarget))
+				return nil
+			}
+			op.ExtractedFiles++
 		}
 
-		op.ExtractedFiles++
+		return nil
+	})
+	if walkErr != nil {
+		op.Error = walkErr
+		return op, op.Error
 	}
 
 	op.ExtractionComplete = true
 	return op, nil
 }
 
-// extractFile writes a single zip file entry to targetPath. It opens the
-// compressed entry for reading, creates the destination file, and copies the
-// content. The destination file receives the permission bits stored in the
-// archive entry. Extraction is limited to [maxDecompressedSize] bytes to
-// prevent decompression bombs.
-func extractFile(entry *zip.File, targetPath string) error {
-	rc, err := entry.Open()
+// extractFile writes a single archive file entry to targetPath. It opens the
+// entry for reading, creates the destination file, and copies the
+// decompressed content. The destination file receives the permission bits
+// stored in the archive entry. Extraction is limited to [maxDecompressedSize]
+// bytes to prevent decompression bombs.
+func extractFile(entry archiveEntry, targetPath string) error {
+	rc, err := entry.open()
 	if err != nil {
 		return fmt.Errorf("failed to open entry: %w", err)
 	}
@@ -773,12 +1492,26 @@ func extractFile(entry *zip.File, targetPath string) error {
 		_ = rc.Close()
 	}()
 
-	outFile, err := os.OpenFile(targetPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, entry.Mode().Perm())
+	// A symlink left by an earlier tar member must be replaced, not written
+	// through, so a file entry can never land on the link's target.
+	if info, lerr := os.Lstat(targetPath); lerr == nil && info.Mode()&os.ModeSymlink != 0 {
+		if rmErr := os.Remove(targetPath); rmErr != nil {
+			return fmt.Errorf("failed to replace symlink: %w", rmErr)
+		}
+	}
+
+	return writeFileFromReader(rc, targetPath, entry.mode)
+}
+
+// writeFileFromReader creates targetPath with perm and copies at most
+// [maxDecompressedSize] bytes from r into it.
+func writeFileFromReader(r io.Reader, targetPath string, perm os.FileMode) error {
+	outFile, err := os.OpenFile(targetPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
 	if err != nil {
 		return fmt.Errorf("failed to create file: %w", err)
 	}
 
-	if _, err = io.Copy(outFile, io.LimitReader(rc, maxDecompressedSize)); err != nil {
+	if _, err = io.Copy(outFile, io.LimitReader(r, maxDecompressedSize)); err != nil {
 		_ = outFile.Close()
 		return fmt.Errorf("failed to write file content: %w", err)
 	}
@@ -787,7 +1520,7 @@ func extractFile(entry *zip.File, targetPath string) error {
 }
 
 // validateCompressionMethods checks that all non-directory entries in the archive
-// use a supported compression method (Store or Deflate). It returns an error
+// use a supported compression method (Store, Deflate or Deflate64). It returns an error
 // wrapping [zip.ErrAlgorithm] for the first entry that uses an unsupported method,
 // or nil if all entries are compatible.
 func validateCompressionMethods(entries []*zip.File) error {
@@ -796,15 +1529,17 @@ func validateCompressionMethods(entries []*zip.File) error {
 			continue
 		}
 
-		if isCompressionMethodSupported(entry.Method) {
+		// AES entries record the real method in their extra field.
+		method := entryMethod(entry)
+		if isCompressionMethodSupported(method) {
 			continue
 		}
 
 		return fmt.Errorf(
 			"entry %q uses unsupported compression method %d (%s): %w",
 			entry.Name,
-			entry.Method,
-			compressionMethodName(entry.Method),
+			method,
+			compressionMethodName(method),
 			zip.ErrAlgorithm,
 		)
 	}
@@ -813,10 +1548,10 @@ func validateCompressionMethods(entries []*zip.File) error {
 }
 
 // isCompressionMethodSupported reports whether method is a zip compression
-// algorithm that this package can extract. Supported methods are [zip.Store]
-// and [zip.Deflate].
+// algorithm that this package can extract. Supported methods are [zip.Store],
+// [zip.Deflate] and Deflate64 (method 9, via [registerDecompressors]).
 func isCompressionMethodSupported(method uint16) bool {
-	return method == zip.Store || method == zip.Deflate
+	return method == zip.Store || method == zip.Deflate || method == deflate64Method
 }
 
 // compressionMethodName returns a human-readable name for a zip compression
@@ -835,13 +1570,25 @@ func compressionMethodName(method uint16) string {
 	}
 }
 
-// isArchive reports whether filePath is a valid zip archive by attempting to
-// open it with archive/zip. Returns true if the file can be opened as a zip
-// archive, false if it cannot (e.g., not a zip file or corrupted). The error
-// return is reserved for unexpected I/O failures; a file that simply isn't a
-// zip archive is not treated as an error.
+// isArchive reports whether filePath is a zip, 7z or tar archive (including
+// gzip, bzip2, xz and zstd compressed tarballs) by attempting to open it.
+// Only files with an archive extension or archive magic bytes are opened.
+// Returns true if the file can be opened as an archive, false if it cannot
+// (e.g., not an archive or corrupted). A file that simply isn't an archive
+// is not treated as an error. The tail of a split zip archive with a
+// missing volume, and a 7z archive whose header is encrypted, count as
+// archives, so processing can report them.
 func isArchive(filePath string) bool {
-	r, err := openArchiveReader(filePath)
+	if !hasArchiveSuffix(filePath) && !hasArchiveSignature(filePath) {
+		return false
+	}
+
+	r, err := openArchive(filePath, extractConfig{})
+	if isUnreadableArchive(err) {
+		// Kept as a candidate so a missing volume or an encrypted 7z
+		// header is reported.
+		return true
+	}
 	if err != nil {
 		slog.Debug("skipped a file", "path", filePath, "error", err)
 		return false
@@ -851,14 +1598,50 @@ func isArchive(filePath string) bool {
 	return true
 }
 
+// hasArchiveSuffix reports whether filePath ends in one of the archive
+// extensions of [archiveNameSuffixes].
+func hasArchiveSuffix(filePath string) bool {
+	lower := strings.ToLower(filePath)
+	for _, suffix := range archiveNameSuffixes {
+		if strings.HasSuffix(lower, suffix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasArchiveSignature reports whether the first bytes of filePath look like
+// an archive. It lets [isArchive] skip the full open of ordinary files,
+// which would otherwise scan every one of them for a zip directory.
+func hasArchiveSignature(filePath string) bool {
+	f, err := os.Open(filePath)
+	if err != nil {
+		slog.Debug("skipped a file", "path", filePath, "error", err)
+		return false
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	head := make([]byte, archiveSniffLen)
+	n, err := io.ReadFull(f, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		slog.Debug("skipped a file", "path", filePath, "error", err)
+		return false
+	}
+
+	return looksLikeArchive(head[:n])
+}
+
 // getAllFilesRecursively collects all files under rootDir, skipping the .btidy
 // metadata directory. It returns a slice of FileInfo for every regular file found.
-func getAllFilesRecursively(rootDir string) ([]collector.FileInfo, error) {
+func getAllFilesRecursively(ctx context.Context, rootDir string) ([]collector.FileInfo, error) {
 	c := collector.New(collector.Options{
 		SkipDirs: []string{".btidy"},
 	})
 
-	files, err := c.Collect(rootDir)
+	files, err := c.Collect(ctx, rootDir)
 	if err != nil {
 		return nil, fmt.Errorf("failed to collect files: %w", err)
 	}
@@ -3,6 +3,7 @@ package unzipper
 import (
 	"archive/zip"
 	"bytes"
+	"compress/flate"
 	"encoding/binary"
 	"fmt"
 	"hash/crc32"
@@ -11,6 +12,7 @@ import (
 	"math/rand/v2"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"btidy/pkg/collector"
@@ -138,7 +140,7 @@ func TestUnzip(t *testing.T) {
 		assert.Equal(t, "ok", string(content))
 	})
 
-	t.Run("allows non utf8 filename bytes", func(t *testing.T) {
+	t.Run("decodes non utf8 filename bytes as cp437", func(t *testing.T) {
 		root := t.TempDir()
 		nonUTF8Name := "Ensimm" + string([]byte{0x84}) + "inen kirjoitus.docx"
 
@@ -164,7 +166,9 @@ func TestUnzip(t *testing.T) {
 		_, err = unzip(file)
 		require.NoError(t, err)
 
-		extractedPath := filepath.Join(root, "Tiedostot", "Blog", nonUTF8Name)
+		// 0x84 is "ä" in CP437, the default code page for names without
+		// the UTF-8 flag.
+		extractedPath := filepath.Join(root, "Tiedostot", "Blog", "Ensimmäinen kirjoitus.docx")
 		content, err := os.ReadFile(extractedPath)
 		require.NoError(t, err)
 		assert.Equal(t, "doc content", string(content))
@@ -365,7 +369,7 @@ func TestExtractArchivesWithProgressRecursively(t *testing.T) {
 		t.Helper()
 		uz, err := New(root, dryRun)
 		require.NoError(t, err)
-		files, err := getAllFilesRecursively(root)
+		files, err := getAllFilesRecursively(t.Context(), root)
 		require.NoError(t, err)
 		return uz, files
 	}
@@ -374,7 +378,7 @@ func TestExtractArchivesWithProgressRecursively(t *testing.T) {
 		uz, err := New(t.TempDir(), false)
 		require.NoError(t, err)
 
-		result, err := uz.ExtractArchivesWithProgressRecursively([]collector.FileInfo{}, nil)
+		result, err := uz.ExtractArchivesWithProgressRecursively(t.Context(), []collector.FileInfo{}, nil)
 		require.NoError(t, err)
 
 		assert.Equal(t, 0, result.TotalFiles)
@@ -397,7 +401,7 @@ func TestExtractArchivesWithProgressRecursively(t *testing.T) {
 		uz, files := setup(t, root, false)
 		progress, calls := newProgressTracker()
 
-		result, err := uz.ExtractArchivesWithProgressRecursively(files, progress)
+		result, err := uz.ExtractArchivesWithProgressRecursively(t.Context(), files, progress)
 		require.NoError(t, err)
 
 		assert.Equal(t, 0, result.ArchivesFound)
@@ -422,7 +426,7 @@ func TestExtractArchivesWithProgressRecursively(t *testing.T) {
 		uz, files := setup(t, root, false)
 		progress, _ := newProgressTracker()
 
-		result, err := uz.ExtractArchivesWithProgressRecursively(files, progress)
+		result, err := uz.ExtractArchivesWithProgressRecursively(t.Context(), files, progress)
 		require.NoError(t, err)
 
 		assert.GreaterOrEqual(t, result.ArchivesFound, 1)
@@ -468,7 +472,7 @@ func TestExtractArchivesWithProgressRecursively(t *testing.T) {
 
 		uz, files := setup(t, root, false)
 
-		result, err := uz.ExtractArchivesWithProgressRecursively(files, nil)
+		result, err := uz.ExtractArchivesWithProgressRecursively(t.Context(), files, nil)
 		require.NoError(t, err)
 
 		assert.GreaterOrEqual(t, result.ArchivesFound, 1, "expected at least the outer archive")
@@ -491,7 +495,7 @@ func TestExtractArchivesWithProgressRecursively(t *testing.T) {
 		uz, files := setup(t, root, false)
 		progress, calls := newProgressTracker()
 
-		_, err := uz.ExtractArchivesWithProgressRecursively(files, progress)
+		_, err := uz.ExtractArchivesWithProgressRecursively(t.Context(), files, progress)
 		require.NoError(t, err)
 
 		assert.NotEmpty(t, *calls, "expected progress callback to be invoked at least once")
@@ -511,7 +515,7 @@ func TestExtractArchivesWithProgressRecursively(t *testing.T) {
 		uz, files := setup(t, root, false)
 
 		assert.NotPanics(t, func() {
-			_, err := uz.ExtractArchivesWithProgressRecursively(files, nil)
+			_, err := uz.ExtractArchivesWithProgressRecursively(t.Context(), files, nil)
 			require.NoError(t, err)
 		})
 	})
@@ -527,34 +531,76 @@ func TestExtractArchivesWithProgressRecursively(t *testing.T) {
 
 		uz, files := setup(t, root, false)
 
-		result, err := uz.ExtractArchivesWithProgressRecursively(files, nil)
+		result, err := uz.ExtractArchivesWithProgressRecursively(t.Context(), files, nil)
 		require.NoError(t, err)
 		assert.Equal(t, 0, result.ArchivesFound, "corrupt zip should not pass isArchive filter")
 	})
 
-	t.Run("archive with deflate64 compression method is skipped", func(t *testing.T) {
+	t.Run("archive with deflate64 compression method is extracted", func(t *testing.T) {
 		root := t.TempDir()
 		archivePath := filepath.Join(root, "deflate64.zip")
 		createDeflate64Archive(t, archivePath, "method9.txt", []byte("payload"))
 
 		uz, files := setup(t, root, false)
-		result, err := uz.ExtractArchivesWithProgressRecursively(files, nil)
+		result, err := uz.ExtractArchivesWithProgressRecursively(t.Context(), files, nil)
 		require.NoError(t, err)
 
 		require.Len(t, result.Operations, 1)
 		op := result.Operations[0]
-		assert.True(t, op.Skipped)
-		assert.Contains(t, op.SkipReason, "unsupported compression method")
-		assert.Contains(t, op.SkipReason, "deflate64")
-		assert.Equal(t, 1, result.SkippedCount)
-		assert.Equal(t, 0, result.ExtractedArchives)
-		assert.Equal(t, 0, result.DeletedArchives)
+		assert.False(t, op.Skipped)
+		assert.Equal(t, 1, op.ExtractedFiles)
+		assert.Equal(t, 1, result.ExtractedArchives)
+		assert.Equal(t, 1, result.DeletedArchives)
 
-		_, readErr := os.Stat(filepath.Join(root, "method9.txt"))
-		require.Error(t, readErr, "method 9 entry should not be extracted")
+		content, readErr := os.ReadFile(filepath.Join(root, "method9.txt"))
+		require.NoError(t, readErr)
+		assert.Equal(t, "payload", string(content))
 
 		_, statErr := os.Stat(archivePath)
-		require.NoError(t, statErr, "deflate64 archive must remain on disk when skipped")
+		require.ErrorIs(t, statErr, os.ErrNotExist, "deflate64 archive should be removed after extraction")
+	})
+
+	t.Run("deflate64 entry with huffman blocks is extracted", func(t *testing.T) {
+		root := t.TempDir()
+		archivePath := filepath.Join(root, "deflate64_huffman.zip")
+
+		// Randomly ordered words compress well with short matches only.
+		words := []string{"käyttäjä", "kirjoitti", "raportin", "varmuuskopio", "arkisto", "vuosi"}
+		rng := rand.New(rand.NewPCG(9, 9)) //nolint:gosec // deterministic test data
+		var sb strings.Builder
+		for range 500 {
+			sb.WriteString(words[rng.IntN(len(words))])
+			sb.WriteByte(' ')
+		}
+		payload := []byte(sb.String())
+		createDeflate64ArchiveWithData(t, archivePath, "report.txt", payload, deflateCompressed(t, payload))
+
+		uz, files := setup(t, root, false)
+		result, err := uz.ExtractArchivesWithProgressRecursively(t.Context(), files, nil)
+		require.NoError(t, err)
+		require.Len(t, result.Operations, 1)
+		require.NoError(t, result.Operations[0].Error)
+
+		content, readErr := os.ReadFile(filepath.Join(root, "report.txt"))
+		require.NoError(t, readErr)
+		assert.Equal(t, payload, content)
+	})
+
+	t.Run("deflate64 entry with bad checksum fails", func(t *testing.T) {
+		root := t.TempDir()
+		archivePath := filepath.Join(root, "deflate64_bad_crc.zip")
+
+		payload := []byte("payload")
+		createDeflate64ArchiveWithData(t, archivePath, "bad.txt", []byte("PAYLOAD"), deflateStoredBlock(t, payload))
+
+		uz, files := setup(t, root, false)
+		result, err := uz.ExtractArchivesWithProgressRecursively(t.Context(), files, nil)
+		require.Error(t, err)
+		assert.ErrorIs(t, err, zip.ErrChecksum)
+		assert.Equal(t, 1, result.ErrorCount)
+
+		_, statErr := os.Stat(archivePath)
+		require.NoError(t, statErr, "archive must remain on disk when extraction fails")
 	})
 
 	t.Run("archive with unsupported compression method is skipped", func(t *testing.T) {
@@ -570,7 +616,7 @@ func TestExtractArchivesWithProgressRecursively(t *testing.T) {
 		setAllZipEntryMethods(t, archivePath, 99)
 
 		uz, files := setup(t, root, false)
-		result, err := uz.ExtractArchivesWithProgressRecursively(files, nil)
+		result, err := uz.ExtractArchivesWithProgressRecursively(t.Context(), files, nil)
 		require.NoError(t, err)
 
 		require.Len(t, result.Operations, 1)
@@ -606,7 +652,7 @@ func TestExtractArchivesWithProgressRecursively(t *testing.T) {
 
 		uz, files := setup(t, root, false)
 
-		result, err := uz.ExtractArchivesWithProgressRecursively(files, nil)
+		result, err := uz.ExtractArchivesWithProgressRecursively(t.Context(), files, nil)
 		require.NoError(t, err)
 
 		assert.GreaterOrEqual(t, result.ArchivesFound, 3, "expected 3 archives")
@@ -634,7 +680,7 @@ func TestExtractArchivesWithProgressRecursively(t *testing.T) {
 
 		uz, files := setup(t, root, false)
 
-		result, err := uz.ExtractArchivesWithProgressRecursively(files, nil)
+		result, err := uz.ExtractArchivesWithProgressRecursively(t.Context(), files, nil)
 		require.NoError(t, err)
 
 		assert.GreaterOrEqual(t, result.ArchivesFound, 1)
@@ -651,7 +697,7 @@ func TestExtractArchivesWithProgressRecursively(t *testing.T) {
 		uz, files := setup(t, root, false)
 		progress, calls := newProgressTracker()
 
-		result, err := uz.ExtractArchivesWithProgressRecursively(files, progress)
+		result, err := uz.ExtractArchivesWithProgressRecursively(t.Context(), files, progress)
 		require.NoError(t, err)
 
 		assert.GreaterOrEqual(t, result.ArchivesFound, 1, "expected at least 1 archive from test structure")
@@ -671,7 +717,7 @@ func TestExtractArchivesWithProgressRecursively(t *testing.T) {
 
 		uz, files := setup(t, root, false)
 
-		result, err := uz.ExtractArchivesWithProgressRecursively(files, nil)
+		result, err := uz.ExtractArchivesWithProgressRecursively(t.Context(), files, nil)
 		require.NoError(t, err)
 
 		assert.Equal(t, len(result.Operations), result.ArchivesProcessed,
@@ -690,11 +736,71 @@ func TestExtractArchivesWithProgressRecursively(t *testing.T) {
 
 		uz, files := setup(t, root, false)
 
-		result, err := uz.ExtractArchivesWithProgressRecursively(files, nil)
+		result, err := uz.ExtractArchivesWithProgressRecursively(t.Context(), files, nil)
 		require.NoError(t, err)
 
 		assert.Equal(t, 0, result.ErrorCount)
 	})
+
+	t.Run("byte-identical archive is removed instead of extracted", func(t *testing.T) {
+		root := t.TempDir()
+		data := zipBytes(t, map[string][]byte{"photo.jpg": []byte("jpeg")})
+		require.NoError(t, os.WriteFile(filepath.Join(root, "a.zip"), data, 0o644))
+		require.NoError(t, os.WriteFile(filepath.Join(root, "b.zip"), data, 0o644))
+
+		uz, files := setup(t, root, false)
+
+		result, err := uz.ExtractArchivesWithProgressRecursively(t.Context(), files, nil)
+		require.NoError(t, err)
+
+		assert.Equal(t, 1, result.ExtractedArchives)
+		assert.Equal(t, 1, result.DuplicateArchives)
+		assert.Equal(t, 1, result.ExtractedFiles)
+		require.Len(t, result.Operations, 2)
+
+		dup := result.Operations[1]
+		assert.True(t, dup.Duplicate)
+		assert.True(t, dup.DeletedArchive)
+		assert.Equal(t, filepath.Join(root, "b.zip"), dup.ArchivePath)
+		assert.Equal(t, filepath.Join(root, "a.zip"), dup.DuplicateOf)
+		assert.Equal(t, result.Operations[0].ArchiveHash, dup.ArchiveHash)
+		assert.NoFileExists(t, filepath.Join(root, "b.zip"))
+		assert.FileExists(t, filepath.Join(root, "photo.jpg"))
+	})
+
+	t.Run("nested copy of an extracted archive is not extracted again", func(t *testing.T) {
+		root := t.TempDir()
+		inner := zipBytes(t, map[string][]byte{"deep.txt": []byte("deep")})
+		require.NoError(t, os.WriteFile(filepath.Join(root, "inner-copy.zip"), inner, 0o644))
+		writeZipWithEntries(t, filepath.Join(root, "outer.zip"), map[string][]byte{"nested/inner.zip": inner})
+
+		uz, files := setup(t, root, false)
+
+		result, err := uz.ExtractArchivesWithProgressRecursively(t.Context(), files, nil)
+		require.NoError(t, err)
+
+		assert.Equal(t, 2, result.ExtractedArchives)
+		assert.Equal(t, 1, result.DuplicateArchives)
+		assert.FileExists(t, filepath.Join(root, "deep.txt"))
+		assert.NoFileExists(t, filepath.Join(root, "nested", "deep.txt"))
+		assert.NoFileExists(t, filepath.Join(root, "nested", "inner.zip"))
+	})
+
+	t.Run("dry run reports duplicate archives without removing them", func(t *testing.T) {
+		root := t.TempDir()
+		data := zipBytes(t, map[string][]byte{"photo.jpg": []byte("jpeg")})
+		require.NoError(t, os.WriteFile(filepath.Join(root, "a.zip"), data, 0o644))
+		require.NoError(t, os.WriteFile(filepath.Join(root, "b.zip"), data, 0o644))
+
+		uz, files := setup(t, root, true)
+
+		result, err := uz.ExtractArchivesWithProgressRecursively(t.Context(), files, nil)
+		require.NoError(t, err)
+
+		assert.Equal(t, 1, result.DuplicateArchives)
+		assert.FileExists(t, filepath.Join(root, "a.zip"))
+		assert.FileExists(t, filepath.Join(root, "b.zip"))
+	})
 }
 
 func TestIsArchive(t *testing.T) {
@@ -712,6 +818,17 @@ func TestIsArchive(t *testing.T) {
 	emptyPath := filepath.Join(root, "empty.bin")
 	require.NoError(t, os.WriteFile(emptyPath, nil, 0644))
 
+	renamedPath := filepath.Join(root, "renamed.dat")
+	createZipFile(t, renamedPath)
+
+	// A zip behind a stub, like a self-extractor, is only opened when its
+	// name says it is an archive.
+	zipData, err := os.ReadFile(zipPath)
+	require.NoError(t, err)
+	stubbed := append([]byte("#!/bin/sh\nexit 0\n"), zipData...)
+	stubbedPath := filepath.Join(root, "installer.bin")
+	require.NoError(t, os.WriteFile(stubbedPath, stubbed, 0644))
+
 	tests := []struct {
 		name string
 		path string
@@ -721,6 +838,8 @@ func TestIsArchive(t *testing.T) {
 		{name: "plain text file", path: txtPath, want: false},
 		{name: "fake zip extension", path: fakeZipPath, want: false},
 		{name: "empty file", path: emptyPath, want: false},
+		{name: "zip without archive extension", path: renamedPath, want: true},
+		{name: "zip behind a stub without archive extension", path: stubbedPath, want: false},
 		{name: "non-existent file", path: filepath.Join(root, "missing.zip"), want: false},
 	}
 
@@ -890,7 +1009,7 @@ func TestGetAllFilesRecursively(t *testing.T) {
 	t.Run("traverse 1 level deep", func(t *testing.T) {
 		root := createTestFileAndFolderStructure(t, 1)
 
-		files, err := getAllFilesRecursively(root)
+		files, err := getAllFilesRecursively(t.Context(), root)
 		require.NoError(t, err)
 		assert.NotEmpty(t, files, "expected files to be returned")
 
@@ -910,7 +1029,7 @@ func TestGetAllFilesRecursively(t *testing.T) {
 	t.Run("traverse 5 level deep", func(t *testing.T) {
 		root := createTestFileAndFolderStructure(t, 5)
 
-		files, err := getAllFilesRecursively(root)
+		files, err := getAllFilesRecursively(t.Context(), root)
 		require.NoError(t, err)
 		assert.NotEmpty(t, files, "expected files to be returned")
 
@@ -930,7 +1049,7 @@ func TestGetAllFilesRecursively(t *testing.T) {
 	t.Run("traverse 10 level deep", func(t *testing.T) {
 		root := createTestFileAndFolderStructure(t, 10)
 
-		files, err := getAllFilesRecursively(root)
+		files, err := getAllFilesRecursively(t.Context(), root)
 		require.NoError(t, err)
 		assert.NotEmpty(t, files, "expected files to be returned")
 
@@ -1097,13 +1216,20 @@ func setAllZipEntryMethods(t *testing.T, archivePath string, method uint16) {
 func createDeflate64Archive(t *testing.T, archivePath, entryName string, payload []byte) {
 	t.Helper()
 
+	createDeflate64ArchiveWithData(t, archivePath, entryName, payload, deflateStoredBlock(t, payload))
+}
+
+// createDeflate64ArchiveWithData writes a single method 9 entry whose header
+// describes payload and whose body is the given compressed stream.
+func createDeflate64ArchiveWithData(t *testing.T, archivePath, entryName string, payload, compressed []byte) {
+	t.Helper()
+
 	archiveFile, err := os.Create(archivePath)
 	require.NoError(t, err)
 	defer archiveFile.Close()
 
 	zw := zip.NewWriter(archiveFile)
 
-	compressed := deflateStoredBlock(t, payload)
 	fh := &zip.FileHeader{
 		Name:               filepath.ToSlash(entryName),
 		Method:             deflate64Method,
@@ -1121,6 +1247,33 @@ func createDeflate64Archive(t *testing.T, archivePath, entryName string, payload
 	require.NoError(t, zw.Close())
 }
 
+// deflateCompressed compresses payload with compress/flate. The result is a
+// valid Deflate64 stream as long as no match reaches 258 bytes, because that
+// length is coded as 285, which Deflate64 reads as a base of 3 plus 16 extra
+// bits. A match can only be that long when some 258-byte run of payload
+// occurs twice, so payloads with such a repeat are rejected.
+func deflateCompressed(t *testing.T, payload []byte) []byte {
+	t.Helper()
+
+	const deflateMaxMatch = 258
+	seen := make(map[string]struct{}, len(payload))
+	for i := 0; i+deflateMaxMatch <= len(payload); i++ {
+		window := string(payload[i : i+deflateMaxMatch])
+		_, repeated := seen[window]
+		require.False(t, repeated, "payload repeats a %d-byte run at offset %d; flate output would not be valid Deflate64", deflateMaxMatch, i)
+		seen[window] = struct{}{}
+	}
+
+	var buf bytes.Buffer
+	fw, err := flate.NewWriter(&buf, flate.BestCompression)
+	require.NoError(t, err)
+	_, err = fw.Write(payload)
+	require.NoError(t, err)
+	require.NoError(t, fw.Close())
+
+	return buf.Bytes()
+}
+
 func deflateStoredBlock(t *testing.T, payload []byte) []byte {
 	t.Helper()
 	require.LessOrEqual(t, len(payload), 0xffff)
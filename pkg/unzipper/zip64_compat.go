@@ -18,10 +18,13 @@ package unzipper
 
 import (
 	"archive/zip"
+	"compress/flate"
 	"encoding/binary"
 	"errors"
 	"io"
 	"os"
+
+	"btidy/pkg/deflate64"
 )
 
 const (
@@ -73,6 +76,21 @@ var zip64LocatorSingleDiskValue = [4]byte{1, 0, 0, 0}
 type archiveReader struct {
 	files   []*zip.File
 	closeFn func() error
+
+	// nameEncoding decodes entry names that are not marked as UTF-8.
+	nameEncoding NameEncoding
+
+	// passwords are tried, in order, on encrypted entries. entryPasswords
+	// records the one that decrypted each entry, and lastPassword the most
+	// recent match, which is tried first on the next entry.
+	passwords      []string
+	entryPasswords map[*zip.File]string
+	lastPassword   string
+
+	// unlocked is set once [archiveReader.unlock] has run, and unlockErr
+	// keeps its result.
+	unlocked  bool
+	unlockErr error
 }
 
 // Close releases any resources held by the archiveReader.
@@ -95,9 +113,22 @@ func (r *archiveReader) Close() error {
 // file on disk.
 //
 // Any non-format error from the initial open is returned immediately.
+//
+// A .zip file that is the tail of a split archive is opened together with
+// its .zNN volumes by [openSplitArchive]; when a volume is missing, an
+// error wrapping [errSplitVolumeMissing] is returned.
 func openArchiveReader(filePath string) (*archiveReader, error) {
+	parts, splitErr := splitArchiveParts(filePath)
+	if splitErr != nil {
+		return nil, splitErr
+	}
+	if len(parts) > 0 {
+		return openSplitArchive(filePath, parts)
+	}
+
 	r, err := zip.OpenReader(filePath)
 	if err == nil {
+		registerDecompressors(&r.Reader)
 		return &archiveReader{files: r.File, closeFn: r.Close}, nil
 	}
 
@@ -118,6 +149,28 @@ func openArchiveReader(filePath string) (*archiveReader, error) {
 	return compatReader, nil
 }
 
+// registerDecompressors installs the decompressors that archive/zip lacks
+// on zr. Registration is per reader so the global archive/zip state is left
+// untouched.
+func registerDecompressors(zr *zip.Reader) {
+	zr.RegisterDecompressor(deflate64Method, deflate64.NewReader)
+}
+
+// zipDecompressor returns a reader that decompresses zip entry data r
+// stored with method.
+func zipDecompressor(method uint16, r io.Reader) (io.ReadCloser, error) {
+	switch method {
+	case zip.Store:
+		return io.NopCloser(r), nil
+	case zip.Deflate:
+		return flate.NewReader(r), nil
+	case deflate64Method:
+		return deflate64.NewReader(r), nil
+	default:
+		return nil, zip.ErrAlgorithm
+	}
+}
+
 // openArchiveReaderWithZip64Compatibility opens a ZIP archive using a patched
 // [io.ReaderAt] that corrects the ZIP64 End of Central Directory Locator
 // "total number of disks" field in memory. The locatorOffset must point to the
@@ -147,6 +200,7 @@ func openArchiveReaderWithZip64Compatibility(filePath string, locatorOffset int6
 		_ = f.Close()
 		return nil, err
 	}
+	registerDecompressors(zr)
 
 	return &archiveReader{files: zr.File, closeFn: f.Close}, nil
 }
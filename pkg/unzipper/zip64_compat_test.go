@@ -89,7 +89,7 @@ func TestOpenArchiveReaderWithZip64LocatorCompatibility(t *testing.T) {
 	assert.True(t, isArchive(archivePath))
 }
 
-func TestExtractArchivesWithZip64LocatorCompatibilityAndDeflate64IsSkipped(t *testing.T) {
+func TestExtractArchivesWithZip64LocatorCompatibilityAndDeflate64(t *testing.T) {
 	root := t.TempDir()
 	archivePath := filepath.Join(root, "zip64_disks_zero_deflate64.zip")
 	createSparseZip64Deflate64Archive(t, archivePath, []byte("hello zip64 deflate64"))
@@ -98,23 +98,21 @@ func TestExtractArchivesWithZip64LocatorCompatibilityAndDeflate64IsSkipped(t *te
 	uz, err := New(root, false)
 	require.NoError(t, err)
 
-	files, err := getAllFilesRecursively(root)
+	files, err := getAllFilesRecursively(t.Context(), root)
 	require.NoError(t, err)
 
-	result, err := uz.ExtractArchivesWithProgressRecursively(files, nil)
+	result, err := uz.ExtractArchivesWithProgressRecursively(t.Context(), files, nil)
 	require.NoError(t, err)
 
 	require.Len(t, result.Operations, 1)
 	op := result.Operations[0]
-	assert.True(t, op.Skipped)
-	assert.Contains(t, op.SkipReason, "unsupported compression method")
-	assert.Contains(t, op.SkipReason, "deflate64")
-	assert.Equal(t, 1, result.SkippedCount)
-	assert.Equal(t, 0, result.ExtractedArchives)
-	assert.Equal(t, 0, result.DeletedArchives)
-
-	_, statErr := os.Stat(archivePath)
-	require.NoError(t, statErr, "deflate64 archive must remain on disk when skipped")
+	assert.False(t, op.Skipped)
+	assert.Equal(t, 1, result.ExtractedArchives)
+	assert.Equal(t, 1, result.DeletedArchives)
+
+	content, readErr := os.ReadFile(filepath.Join(root, "hello.txt"))
+	require.NoError(t, readErr)
+	assert.Equal(t, "hello zip64 deflate64", string(content))
 }
 
 func createSparseZip64Archive(t *testing.T, archivePath string) {
@@ -0,0 +1,152 @@
+package unzipper
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+)
+
+// NameEncoding is the legacy code page used to decode zip entry names that
+// are not marked as UTF-8.
+type NameEncoding string
+
+const (
+	// NameEncodingCP437 is the original IBM PC code page. The zip
+	// specification names it as the default, and MS-DOS and older Windows
+	// tools in Western locales write it.
+	NameEncodingCP437 NameEncoding = "cp437"
+
+	// NameEncodingCP1252 is Windows-1252, written by some Windows tools
+	// that store the ANSI code page instead of the OEM one.
+	NameEncodingCP1252 NameEncoding = "cp1252"
+
+	// NameEncodingShiftJIS is Shift JIS, written by Japanese Windows tools.
+	NameEncodingShiftJIS NameEncoding = "shift-jis"
+)
+
+const (
+	// zipFlagUTF8 is general purpose bit 11 (PKZIP spec §4.4.4): the entry
+	// name and comment are UTF-8.
+	zipFlagUTF8 = 0x800
+
+	// zipUnicodePathExtraID is the Info-ZIP Unicode Path extra field
+	// (PKZIP spec §4.6.9).
+	zipUnicodePathExtraID = 0x7075
+
+	// zipUnicodePathVersion is the only defined Unicode Path field version.
+	zipUnicodePathVersion = 1
+
+	// zipUnicodePathHeaderLen is the version byte plus the CRC-32 of the
+	// header name that precede the UTF-8 name.
+	zipUnicodePathHeaderLen = 5
+)
+
+// nameEncodingAliases maps accepted spellings to a [NameEncoding].
+var nameEncodingAliases = map[string]NameEncoding{
+	"cp437":        NameEncodingCP437,
+	"ibm437":       NameEncodingCP437,
+	"cp1252":       NameEncodingCP1252,
+	"windows-1252": NameEncodingCP1252,
+	"shift-jis":    NameEncodingShiftJIS,
+	"shift_jis":    NameEncodingShiftJIS,
+	"sjis":         NameEncodingShiftJIS,
+}
+
+// ParseNameEncoding converts a command-line value into a [NameEncoding].
+// Matching is case-insensitive and accepts common aliases such as
+// "windows-1252" and "sjis".
+func ParseNameEncoding(value string) (NameEncoding, error) {
+	if enc, ok := nameEncodingAliases[strings.ToLower(value)]; ok {
+		return enc, nil
+	}
+
+	return "", fmt.Errorf("invalid zip name encoding %q (want %s, %s or %s)",
+		value, NameEncodingCP437, NameEncodingCP1252, NameEncodingShiftJIS)
+}
+
+// decoder returns the text decoder for the code page. The zero value
+// decodes as [NameEncodingCP437].
+func (e NameEncoding) decoder() *encoding.Decoder {
+	switch e {
+	case NameEncodingCP1252:
+		return charmap.Windows1252.NewDecoder()
+	case NameEncodingShiftJIS:
+		return japanese.ShiftJIS.NewDecoder()
+	default:
+		return charmap.CodePage437.NewDecoder()
+	}
+}
+
+// zipEntryName returns the UTF-8 name of a zip entry. In order of
+// preference it uses the Info-ZIP Unicode Path extra field, the stored
+// name when the entry is flagged as UTF-8 or happens to be valid UTF-8,
+// and finally the stored name decoded from the legacy code page enc.
+//
+// Names that are valid UTF-8 without the flag are kept as they are: many
+// Unix tools write UTF-8 without setting it, and a legacy name is almost
+// never valid UTF-8 by accident.
+func zipEntryName(f *zip.File, enc NameEncoding) string {
+	if name, ok := unicodePathExtra(f.Extra, f.Name); ok {
+		return name
+	}
+
+	return decodeZipText(f.Name, f.Flags, enc)
+}
+
+// decodeZipText returns text stored in a zip entry as UTF-8: unchanged when
+// flags mark it as UTF-8 or it already is valid UTF-8, and decoded from the
+// legacy code page enc otherwise.
+func decodeZipText(text string, flags uint16, enc NameEncoding) string {
+	if flags&zipFlagUTF8 != 0 || utf8.ValidString(text) {
+		return text
+	}
+
+	decoded, err := enc.decoder().String(text)
+	if err != nil {
+		return text
+	}
+
+	return decoded
+}
+
+// unicodePathExtra returns the UTF-8 name from an Info-ZIP Unicode Path
+// extra field. The field is ignored unless its CRC-32 matches the stored
+// header name, which tells whether a tool renamed the entry afterwards
+// without updating the extra field.
+func unicodePathExtra(extra []byte, headerName string) (string, bool) {
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra[0:2])
+		size := int(binary.LittleEndian.Uint16(extra[2:4]))
+		extra = extra[4:]
+		if size > len(extra) {
+			return "", false
+		}
+
+		data := extra[:size]
+		extra = extra[size:]
+
+		if id != zipUnicodePathExtraID || len(data) <= zipUnicodePathHeaderLen || data[0] != zipUnicodePathVersion {
+			continue
+		}
+
+		if binary.LittleEndian.Uint32(data[1:5]) != crc32.ChecksumIEEE([]byte(headerName)) {
+			continue
+		}
+
+		name := string(data[zipUnicodePathHeaderLen:])
+		if !utf8.ValidString(name) {
+			continue
+		}
+
+		return name, true
+	}
+
+	return "", false
+}
@@ -0,0 +1,172 @@
+package unzipper
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"btidy/pkg/safepath"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseNameEncoding(t *testing.T) {
+	tests := []struct {
+		value string
+		want  NameEncoding
+	}{
+		{value: "cp437", want: NameEncodingCP437},
+		{value: "IBM437", want: NameEncodingCP437},
+		{value: "cp1252", want: NameEncodingCP1252},
+		{value: "Windows-1252", want: NameEncodingCP1252},
+		{value: "shift-jis", want: NameEncodingShiftJIS},
+		{value: "sjis", want: NameEncodingShiftJIS},
+	}
+
+	for _, tc := range tests {
+		got, err := ParseNameEncoding(tc.value)
+		require.NoError(t, err, tc.value)
+		assert.Equal(t, tc.want, got)
+	}
+
+	_, err := ParseNameEncoding("ebcdic")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid zip name encoding")
+}
+
+func TestZipEntryName(t *testing.T) {
+	// "Käyttäjä.doc" as written by legacy tools in each code page.
+	cp437Name := "K\x84ytt\x84j\x84.doc"
+	cp1252Name := "K\xe4ytt\xe4j\xe4.doc"
+	// "日本.txt" in Shift JIS.
+	shiftJISName := "\x93\xfa\x96\x7b.txt"
+
+	tests := []struct {
+		name   string
+		header zip.FileHeader
+		enc    NameEncoding
+		want   string
+	}{
+		{
+			name:   "ascii name is unchanged",
+			header: zip.FileHeader{Name: "docs/readme.txt"},
+			enc:    NameEncodingCP437,
+			want:   "docs/readme.txt",
+		},
+		{
+			name:   "cp437 name",
+			header: zip.FileHeader{Name: cp437Name},
+			enc:    NameEncodingCP437,
+			want:   "Käyttäjä.doc",
+		},
+		{
+			name:   "zero encoding defaults to cp437",
+			header: zip.FileHeader{Name: cp437Name},
+			want:   "Käyttäjä.doc",
+		},
+		{
+			name:   "cp1252 name",
+			header: zip.FileHeader{Name: cp1252Name},
+			enc:    NameEncodingCP1252,
+			want:   "Käyttäjä.doc",
+		},
+		{
+			name:   "shift-jis name",
+			header: zip.FileHeader{Name: shiftJISName},
+			enc:    NameEncodingShiftJIS,
+			want:   "日本.txt",
+		},
+		{
+			name:   "utf8 flag wins over code page",
+			header: zip.FileHeader{Name: "Käyttäjä.doc", Flags: zipFlagUTF8},
+			enc:    NameEncodingCP437,
+			want:   "Käyttäjä.doc",
+		},
+		{
+			name:   "valid utf8 without flag is kept",
+			header: zip.FileHeader{Name: "Käyttäjä.doc"},
+			enc:    NameEncodingCP437,
+			want:   "Käyttäjä.doc",
+		},
+		{
+			name:   "unicode path extra field wins",
+			header: zip.FileHeader{Name: cp437Name, Extra: unicodePathField(cp437Name, "Käyttäjä.doc")},
+			enc:    NameEncodingShiftJIS,
+			want:   "Käyttäjä.doc",
+		},
+		{
+			name:   "stale unicode path extra field is ignored",
+			header: zip.FileHeader{Name: cp437Name, Extra: unicodePathField("renamed.doc", "Other.doc")},
+			enc:    NameEncodingCP437,
+			want:   "Käyttäjä.doc",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, zipEntryName(&zip.File{FileHeader: tc.header}, tc.enc))
+		})
+	}
+}
+
+func TestExtractWithNameEncoding(t *testing.T) {
+	root := t.TempDir()
+	archivePath := filepath.Join(root, "legacy.zip")
+
+	f, err := os.Create(archivePath)
+	require.NoError(t, err)
+	zw := zip.NewWriter(f)
+	for _, header := range []*zip.FileHeader{
+		{Name: "Asiakirjat/K\xe4ytt\xe4j\xe4.doc", NonUTF8: true},
+		{
+			Name:    "Kuvat/\x84iti.jpg",
+			NonUTF8: true,
+			Extra:   unicodePathField("Kuvat/\x84iti.jpg", "Kuvat/Äiti.jpg"),
+		},
+	} {
+		w, createErr := zw.CreateHeader(header)
+		require.NoError(t, createErr)
+		_, err = w.Write([]byte("content"))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	require.NoError(t, f.Close())
+
+	v, err := safepath.New(root)
+	require.NoError(t, err)
+	uz, err := NewWithValidator(v, false, nil, WithNameEncoding(NameEncodingCP1252))
+	require.NoError(t, err)
+	files, err := getAllFilesRecursively(t.Context(), root)
+	require.NoError(t, err)
+
+	result, err := uz.ExtractArchivesWithProgressRecursively(t.Context(), files, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.ExtractedFiles)
+
+	assert.FileExists(t, filepath.Join(root, "Asiakirjat", "Käyttäjä.doc"))
+	assert.FileExists(t, filepath.Join(root, "Kuvat", "Äiti.jpg"))
+}
+
+func TestNewWithValidatorRejectsUnknownNameEncoding(t *testing.T) {
+	v, err := safepath.New(t.TempDir())
+	require.NoError(t, err)
+
+	_, err = NewWithValidator(v, false, nil, WithNameEncoding("ebcdic"))
+	require.Error(t, err)
+}
+
+// unicodePathField builds an Info-ZIP Unicode Path extra field for an entry
+// whose header stores headerName.
+func unicodePathField(headerName, unicodeName string) []byte {
+	data := make([]byte, 4+zipUnicodePathHeaderLen, 4+zipUnicodePathHeaderLen+len(unicodeName))
+	binary.LittleEndian.PutUint16(data[0:2], zipUnicodePathExtraID)
+	binary.LittleEndian.PutUint16(data[2:4], uint16(zipUnicodePathHeaderLen+len(unicodeName))) //nolint:gosec // test names are short
+	data[4] = zipUnicodePathVersion
+	binary.LittleEndian.PutUint32(data[5:9], crc32.ChecksumIEEE([]byte(headerName)))
+
+	return append(data, unicodeName...)
+}
@@ -2,9 +2,11 @@
 package usecase
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
 	"runtime"
 	"sort"
@@ -17,12 +19,14 @@ import (
 	"btidy/pkg/flattener"
 	"btidy/pkg/hasher"
 	"btidy/pkg/journal"
+	"btidy/pkg/keeper"
 	"btidy/pkg/manifest"
 	"btidy/pkg/metadata"
 	"btidy/pkg/organizer"
 	"btidy/pkg/progress"
 	"btidy/pkg/renamer"
 	"btidy/pkg/safepath"
+	"btidy/pkg/similarity"
 	"btidy/pkg/trash"
 	"btidy/pkg/unzipper"
 )
@@ -32,6 +36,12 @@ type Options struct {
 	SkipFiles  []string
 	SkipDirs   []string
 	NoSnapshot bool
+	NoCache    bool
+	// HashAlgorithm selects the algorithm duplicate and flatten group
+	// files by, and manifests and snapshots are hashed with when it is
+	// cryptographic. Empty keeps the defaults: XXH128 for grouping and
+	// SHA-256 for manifests.
+	HashAlgorithm hasher.Algorithm
 }
 
 // ProgressCallback receives workflow stage progress updates.
@@ -43,6 +53,8 @@ type Service struct {
 	skipFiles  []string
 	skipDirs   []string
 	noSnapshot bool
+	noCache    bool
+	hashAlgo   hasher.Algorithm
 }
 
 // New creates a use-case service.
@@ -51,6 +63,8 @@ func New(opts Options) *Service {
 		skipFiles:  append([]string(nil), opts.SkipFiles...),
 		skipDirs:   append([]string(nil), opts.SkipDirs...),
 		noSnapshot: opts.NoSnapshot,
+		noCache:    opts.NoCache,
+		hashAlgo:   opts.HashAlgorithm,
 	}
 }
 
@@ -69,6 +83,8 @@ type RenameExecution struct {
 	Result          renamer.Result
 	SnapshotPath    string
 	JournalPath     string
+	Interrupted     bool
+	HashCacheErr    error
 }
 
 // FlattenRequest contains inputs for the flatten workflow.
@@ -77,6 +93,9 @@ type FlattenRequest struct {
 	DryRun     bool
 	Workers    int
 	OnProgress ProgressCallback
+	// Keep chooses which copy of duplicate content is kept; the zero value
+	// keeps the copy whose path sorts first.
+	Keep keeper.Policy
 }
 
 // FlattenExecution contains flatten workflow outputs.
@@ -87,6 +106,8 @@ type FlattenExecution struct {
 	Result          flattener.Result
 	SnapshotPath    string
 	JournalPath     string
+	Interrupted     bool
+	HashCacheErr    error
 }
 
 // DuplicateRequest contains inputs for the duplicate workflow.
@@ -95,6 +116,19 @@ type DuplicateRequest struct {
 	DryRun     bool
 	Workers    int
 	OnProgress ProgressCallback
+	// Link replaces duplicates with links to the kept file instead of
+	// removing them; empty removes them.
+	Link deduplicator.LinkMode
+	// Keep chooses which copy of duplicate content is kept; the zero value
+	// keeps the copy whose path sorts first.
+	Keep keeper.Policy
+	// Against is a reference directory or manifest file. When set, only
+	// files whose content is in the reference are removed; the reference
+	// itself is never touched.
+	Against string
+	// Directories removes whole directories identical to another one as a
+	// unit before deduplicating single files.
+	Directories bool
 }
 
 // DuplicateExecution contains duplicate workflow outputs.
@@ -105,13 +139,72 @@ type DuplicateExecution struct {
 	Result          deduplicator.Result
 	SnapshotPath    string
 	JournalPath     string
+	Interrupted     bool
+	HashCacheErr    error
 }
 
-// UnzipRequest contains inputs for the unzip workflow.
-type UnzipRequest struct {
+// SimilarRequest contains inputs for the similar workflow.
+type SimilarRequest struct {
 	TargetDir  string
 	DryRun     bool
+	Workers    int
 	OnProgress ProgressCallback
+	// Trash moves every image of a group but the kept one to trash; when
+	// false the groups are only reported and nothing is changed.
+	Trash bool
+	// Algorithm selects the perceptual hash; empty means
+	// similarity.AlgorithmPHash.
+	Algorithm similarity.Algorithm
+	// MaxDistance is the maximum Hamming distance between the hashes of an
+	// image and the kept image of its group.
+	MaxDistance int
+}
+
+// SimilarExecution contains similar workflow outputs.
+type SimilarExecution struct {
+	RootDir         string
+	FileCount       int
+	CollectDuration time.Duration
+	Result          similarity.Result
+	SnapshotPath    string
+	JournalPath     string
+	Interrupted     bool
+	HashCacheErr    error
+}
+
+// UnzipRequest contains inputs for the unzip workflow.
+type UnzipRequest struct {
+	TargetDir string
+	DryRun    bool
+	// DecompressSingleFiles also decompresses standalone .gz, .bz2, .xz and
+	// .zst files that are not tarballs.
+	DecompressSingleFiles bool
+	// Layout selects where archive entries are written; empty means
+	// unzipper.LayoutParent.
+	Layout unzipper.Layout
+	// NameEncoding is the legacy code page for zip entry names without the
+	// UTF-8 flag; empty means unzipper.NameEncodingCP437.
+	NameEncoding unzipper.NameEncoding
+	// Limits bounds nesting depth, extracted bytes and compression ratio;
+	// nil means unzipper.DefaultLimits().
+	Limits *unzipper.Limits
+	// Symlinks selects how symlink entries are extracted; empty means
+	// unzipper.SymlinksSkip.
+	Symlinks unzipper.SymlinkPolicy
+	// Workers is the number of archives of one nesting level extracted
+	// concurrently; values below 1 mean one at a time.
+	Workers int
+	// Salvage recovers verified entries from zip archives whose central
+	// directory is damaged or missing, keeping the archives.
+	Salvage bool
+	// Passwords are tried on encrypted zip entries; without any, archives
+	// with encrypted entries are skipped.
+	Passwords []string
+	// InMemoryLimit is the largest nested archive extracted from memory
+	// without being written to disk; nil means
+	// unzipper.DefaultInMemoryLimit and 0 writes every nested archive out.
+	InMemoryLimit *int64
+	OnProgress    ProgressCallback
 }
 
 // UnzipExecution contains unzip workflow outputs.
@@ -122,6 +215,8 @@ type UnzipExecution struct {
 	Result          unzipper.Result
 	SnapshotPath    string
 	JournalPath     string
+	Interrupted     bool
+	HashCacheErr    error
 }
 
 // ManifestRequest contains inputs for the manifest workflow.
@@ -134,11 +229,41 @@ type ManifestRequest struct {
 
 // ManifestExecution contains manifest workflow outputs.
 type ManifestExecution struct {
-	RootDir    string
-	Duration   time.Duration
-	Manifest   *manifest.Manifest
-	OutputPath string
-	Workers    int
+	RootDir      string
+	Duration     time.Duration
+	Manifest     *manifest.Manifest
+	OutputPath   string
+	Workers      int
+	HashCacheErr error
+}
+
+// ListArchiveRequest contains inputs for the ls-archive workflow.
+type ListArchiveRequest struct {
+	// Path is an archive file, or a directory whose archives are all
+	// listed.
+	Path string
+	// Find is a glob that reported entries must match: their base name
+	// when the pattern has no "/", their member path otherwise. Empty
+	// reports every entry.
+	Find string
+	// NameEncoding is the legacy code page for zip entry names without the
+	// UTF-8 flag; empty means unzipper.NameEncodingCP437.
+	NameEncoding unzipper.NameEncoding
+	// Passwords decrypt encrypted nested archives so their entries can be
+	// listed.
+	Passwords []string
+	// OnEntry receives each reported entry as it is read; an error stops
+	// the listing.
+	OnEntry func(unzipper.ListedEntry) error
+}
+
+// ListArchiveExecution contains ls-archive workflow outputs.
+type ListArchiveExecution struct {
+	RootDir  string
+	Duration time.Duration
+	Result   unzipper.ListResult
+	// Matched counts the entries passed to OnEntry.
+	Matched int
 }
 
 // OrganizeRequest contains inputs for the organize workflow.
@@ -156,6 +281,8 @@ type OrganizeExecution struct {
 	Result          organizer.Result
 	SnapshotPath    string
 	JournalPath     string
+	Interrupted     bool
+	HashCacheErr    error
 }
 
 // WorkflowMeta contains the common metadata fields shared by all file workflow executions.
@@ -165,6 +292,12 @@ type WorkflowMeta struct {
 	CollectDuration time.Duration
 	SnapshotPath    string
 	JournalPath     string
+	// Interrupted is set when the context was canceled while operations
+	// ran: the result and the journal only cover those performed before.
+	Interrupted bool
+	// HashCacheErr is set when the hash cache could not be saved. The run
+	// itself is unaffected; the next one hashes its files again.
+	HashCacheErr error
 }
 
 // Meta returns the common workflow metadata for rename executions.
@@ -172,6 +305,7 @@ func (e RenameExecution) Meta() WorkflowMeta {
 	return WorkflowMeta{
 		RootDir: e.RootDir, FileCount: e.FileCount,
 		CollectDuration: e.CollectDuration, SnapshotPath: e.SnapshotPath, JournalPath: e.JournalPath,
+		Interrupted: e.Interrupted, HashCacheErr: e.HashCacheErr,
 	}
 }
 
@@ -180,6 +314,7 @@ func (e FlattenExecution) Meta() WorkflowMeta {
 	return WorkflowMeta{
 		RootDir: e.RootDir, FileCount: e.FileCount,
 		CollectDuration: e.CollectDuration, SnapshotPath: e.SnapshotPath, JournalPath: e.JournalPath,
+		Interrupted: e.Interrupted, HashCacheErr: e.HashCacheErr,
 	}
 }
 
@@ -188,6 +323,16 @@ func (e DuplicateExecution) Meta() WorkflowMeta {
 	return WorkflowMeta{
 		RootDir: e.RootDir, FileCount: e.FileCount,
 		CollectDuration: e.CollectDuration, SnapshotPath: e.SnapshotPath, JournalPath: e.JournalPath,
+		Interrupted: e.Interrupted, HashCacheErr: e.HashCacheErr,
+	}
+}
+
+// Meta returns the common workflow metadata for similar executions.
+func (e SimilarExecution) Meta() WorkflowMeta {
+	return WorkflowMeta{
+		RootDir: e.RootDir, FileCount: e.FileCount,
+		CollectDuration: e.CollectDuration, SnapshotPath: e.SnapshotPath, JournalPath: e.JournalPath,
+		Interrupted: e.Interrupted, HashCacheErr: e.HashCacheErr,
 	}
 }
 
@@ -196,6 +341,7 @@ func (e UnzipExecution) Meta() WorkflowMeta {
 	return WorkflowMeta{
 		RootDir: e.RootDir, FileCount: e.FileCount,
 		CollectDuration: e.CollectDuration, SnapshotPath: e.SnapshotPath, JournalPath: e.JournalPath,
+		Interrupted: e.Interrupted, HashCacheErr: e.HashCacheErr,
 	}
 }
 
@@ -204,12 +350,14 @@ func (e OrganizeExecution) Meta() WorkflowMeta {
 	return WorkflowMeta{
 		RootDir: e.RootDir, FileCount: e.FileCount,
 		CollectDuration: e.CollectDuration, SnapshotPath: e.SnapshotPath, JournalPath: e.JournalPath,
+		Interrupted: e.Interrupted, HashCacheErr: e.HashCacheErr,
 	}
 }
 
 // RunOrganize executes the organize workflow.
-func (s *Service) RunOrganize(req OrganizeRequest) (OrganizeExecution, error) {
+func (s *Service) RunOrganize(ctx context.Context, req OrganizeRequest) (OrganizeExecution, error) {
 	return runCheckedExecution(
+		ctx,
 		s,
 		req.TargetDir,
 		req.DryRun,
@@ -227,8 +375,9 @@ func (s *Service) RunOrganize(req OrganizeRequest) (OrganizeExecution, error) {
 }
 
 // RunRename executes the rename workflow.
-func (s *Service) RunRename(req RenameRequest) (RenameExecution, error) {
+func (s *Service) RunRename(ctx context.Context, req RenameRequest) (RenameExecution, error) {
 	return runCheckedExecution(
+		ctx,
 		s,
 		req.TargetDir,
 		req.DryRun,
@@ -246,12 +395,13 @@ func (s *Service) RunRename(req RenameRequest) (RenameExecution, error) {
 }
 
 // RunFlatten executes the flatten workflow.
-func (s *Service) RunFlatten(req FlattenRequest) (FlattenExecution, error) {
+func (s *Service) RunFlatten(ctx context.Context, req FlattenRequest) (FlattenExecution, error) {
 	return runCheckedExecution(
+		ctx,
 		s,
 		req.TargetDir,
 		req.DryRun,
-		flattenExecutor(req.DryRun, req.Workers, req.OnProgress),
+		s.flattenExecutor(req.DryRun, req.Workers, req.Keep, req.OnProgress),
 		flattenExecutionFromWorkflow,
 		"flatten",
 		func(execution FlattenExecution) []flattener.MoveOperation {
@@ -265,12 +415,33 @@ func (s *Service) RunFlatten(req FlattenRequest) (FlattenExecution, error) {
 }
 
 // RunDuplicate executes the duplicate workflow.
-func (s *Service) RunDuplicate(req DuplicateRequest) (DuplicateExecution, error) {
+func (s *Service) RunDuplicate(ctx context.Context, req DuplicateRequest) (DuplicateExecution, error) {
+	if req.Directories && req.Link != deduplicator.LinkNone {
+		return DuplicateExecution{}, errors.New("duplicate directories cannot be replaced with links")
+	}
+
+	var ref *deduplicator.Reference
+	if req.Against != "" {
+		if req.Link != deduplicator.LinkNone {
+			return DuplicateExecution{}, errors.New("duplicates cannot be linked to a reference outside the target")
+		}
+		if req.Directories {
+			return DuplicateExecution{}, errors.New("directories cannot be deduplicated against a reference")
+		}
+
+		var err error
+		ref, err = s.loadReference(ctx, req.Against, req.TargetDir)
+		if err != nil {
+			return DuplicateExecution{}, err
+		}
+	}
+
 	return runCheckedExecution(
+		ctx,
 		s,
 		req.TargetDir,
 		req.DryRun,
-		duplicateExecutor(req.DryRun, req.Workers, req.OnProgress),
+		s.duplicateExecutor(req, ref),
 		duplicateExecutionFromWorkflow,
 		"duplicate",
 		func(execution DuplicateExecution) []deduplicator.DeleteOperation {
@@ -283,13 +454,37 @@ func (s *Service) RunDuplicate(req DuplicateRequest) (DuplicateExecution, error)
 	)
 }
 
+// RunSimilar executes the similar workflow. Unless req.Trash is set it runs
+// as a dry run: groups are reported, and no snapshot or journal is written.
+func (s *Service) RunSimilar(ctx context.Context, req SimilarRequest) (SimilarExecution, error) {
+	dryRun := req.DryRun || !req.Trash
+
+	return runCheckedExecution(
+		ctx,
+		s,
+		req.TargetDir,
+		dryRun,
+		similarExecutor(dryRun, req),
+		similarExecutionFromWorkflow,
+		"similar",
+		func(execution SimilarExecution) []similarity.TrashOperation {
+			return execution.Result.Operations
+		},
+		func(op similarity.TrashOperation) (string, error) {
+			return op.Path, op.Error
+		},
+		similarJournalEntries,
+	)
+}
+
 // RunUnzip executes the unzip workflow.
-func (s *Service) RunUnzip(req UnzipRequest) (UnzipExecution, error) {
+func (s *Service) RunUnzip(ctx context.Context, req UnzipRequest) (UnzipExecution, error) {
 	return runCheckedExecution(
+		ctx,
 		s,
 		req.TargetDir,
 		req.DryRun,
-		unzipExecutor(req.DryRun, req.OnProgress),
+		unzipExecutor(req),
 		unzipExecutionFromWorkflow,
 		"unzip",
 		func(execution UnzipExecution) []unzipper.ExtractOperation {
@@ -314,6 +509,10 @@ func duplicateExecutionFromWorkflow(workflowResult fileWorkflowResult[deduplicat
 	return DuplicateExecution(workflowResult)
 }
 
+func similarExecutionFromWorkflow(workflowResult fileWorkflowResult[similarity.Result]) SimilarExecution {
+	return SimilarExecution(workflowResult)
+}
+
 func unzipExecutionFromWorkflow(workflowResult fileWorkflowResult[unzipper.Result]) UnzipExecution {
 	return UnzipExecution(workflowResult)
 }
@@ -323,7 +522,7 @@ func organizeExecutionFromWorkflow(workflowResult fileWorkflowResult[organizer.R
 }
 
 // RunManifest executes the manifest workflow.
-func (s *Service) RunManifest(req ManifestRequest) (ManifestExecution, error) {
+func (s *Service) RunManifest(ctx context.Context, req ManifestRequest) (ManifestExecution, error) {
 	target, err := resolveWorkflowTarget(req.TargetDir)
 	if err != nil {
 		return ManifestExecution{}, err
@@ -334,14 +533,33 @@ func (s *Service) RunManifest(req ManifestRequest) (ManifestExecution, error) {
 		return ManifestExecution{}, err
 	}
 
+	algo, err := hasher.ParseAlgorithm(string(s.hashAlgo))
+	if err != nil {
+		return ManifestExecution{}, err
+	}
+	if !algo.Cryptographic() {
+		return ManifestExecution{}, fmt.Errorf("manifests need a cryptographic hash algorithm (sha256 or blake3), not %s", algo)
+	}
+
 	startTime := time.Now()
 
-	g, err := manifest.NewGeneratorWithValidator(target.validator, req.Workers)
+	// The manifest shares the hash cache with the other workflows, so it
+	// takes the same lock.
+	lock, err := acquireWorkflowLock(target)
+	if err != nil {
+		return ManifestExecution{}, err
+	}
+	defer lock.Close()
+
+	target.hashCache = s.openHashCache(target)
+
+	g, err := manifest.NewGeneratorWithValidator(target.validator, req.Workers,
+		hasher.WithAlgorithm(algo), hasher.WithCache(target.hashCache))
 	if err != nil {
 		return ManifestExecution{}, fmt.Errorf("failed to create manifest generator: %w", err)
 	}
 
-	generatedManifest, err := g.Generate(manifest.GenerateOptions{
+	generatedManifest, err := g.Generate(ctx, manifest.GenerateOptions{
 		SkipFiles: s.skipFileList(),
 		SkipDirs:  s.skipDirList(),
 		OnProgress: func(processed, total int, _ string) {
@@ -357,15 +575,115 @@ func (s *Service) RunManifest(req ManifestRequest) (ManifestExecution, error) {
 	}
 
 	return ManifestExecution{
-		RootDir:    target.rootDir,
-		Duration:   time.Since(startTime),
-		Manifest:   generatedManifest,
-		OutputPath: resolvedOutputPath,
-		Workers:    req.Workers,
+		RootDir:      target.rootDir,
+		Duration:     time.Since(startTime),
+		Manifest:     generatedManifest,
+		OutputPath:   resolvedOutputPath,
+		Workers:      req.Workers,
+		HashCacheErr: saveHashCache(target.hashCache),
 	}, nil
 }
 
-func (s *Service) collectFiles(rootDir string) ([]collector.FileInfo, time.Duration, error) {
+// RunListArchive executes the read-only ls-archive workflow. Nothing is
+// written, not even the .btidy metadata directory.
+func (s *Service) RunListArchive(ctx context.Context, req ListArchiveRequest) (ListArchiveExecution, error) {
+	if req.Find != "" {
+		if _, err := path.Match(req.Find, ""); err != nil {
+			return ListArchiveExecution{}, fmt.Errorf("invalid --find pattern %q: %w", req.Find, err)
+		}
+	}
+
+	info, err := os.Stat(req.Path)
+	if err != nil {
+		return ListArchiveExecution{}, fmt.Errorf("cannot access path: %w", err)
+	}
+
+	targetDir := req.Path
+	if !info.IsDir() {
+		targetDir = filepath.Dir(req.Path)
+	}
+	target, err := resolveWorkflowTarget(targetDir)
+	if err != nil {
+		return ListArchiveExecution{}, err
+	}
+
+	startTime := time.Now()
+
+	var files []collector.FileInfo
+	if info.IsDir() {
+		files, _, err = s.collectFiles(ctx, target.rootDir)
+		if err != nil {
+			return ListArchiveExecution{}, fmt.Errorf("failed to collect files: %w", err)
+		}
+	} else {
+		archivePath := filepath.Join(target.rootDir, filepath.Base(req.Path))
+		files = []collector.FileInfo{{
+			Path:    archivePath,
+			Dir:     target.rootDir,
+			Name:    filepath.Base(req.Path),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		}}
+	}
+
+	var opts []unzipper.Option
+	if req.NameEncoding != "" {
+		opts = append(opts, unzipper.WithNameEncoding(req.NameEncoding))
+	}
+	if len(req.Passwords) > 0 {
+		opts = append(opts, unzipper.WithPasswords(req.Passwords))
+	}
+
+	u, err := unzipper.NewWithValidator(target.validator, true, nil, opts...)
+	if err != nil {
+		return ListArchiveExecution{}, fmt.Errorf("failed to create unzipper: %w", err)
+	}
+
+	execution := ListArchiveExecution{RootDir: target.rootDir}
+	execution.Result, err = u.ListArchives(files, func(entry unzipper.ListedEntry) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !matchListedEntry(req.Find, entry) {
+			return nil
+		}
+
+		execution.Matched++
+		if req.OnEntry == nil {
+			return nil
+		}
+		return req.OnEntry(entry)
+	})
+	execution.Duration = time.Since(startTime)
+	if err != nil {
+		return execution, err
+	}
+
+	if !info.IsDir() && execution.Result.Archives == 0 && len(execution.Result.Errors) == 0 {
+		return execution, fmt.Errorf("%s is not a supported archive", req.Path)
+	}
+
+	return execution, nil
+}
+
+// matchListedEntry reports whether entry matches the --find glob pattern:
+// its base name when pattern has no "/", its member path otherwise.
+func matchListedEntry(pattern string, entry unzipper.ListedEntry) bool {
+	if pattern == "" {
+		return true
+	}
+
+	name := entry.Path
+	if !strings.Contains(pattern, "/") {
+		name = path.Base(name)
+	}
+
+	// The pattern was checked up front, so Match cannot fail.
+	matched, _ := path.Match(pattern, name)
+	return matched
+}
+
+func (s *Service) collectFiles(ctx context.Context, rootDir string) ([]collector.FileInfo, time.Duration, error) {
 	startTime := time.Now()
 
 	c := collector.New(collector.Options{
@@ -373,7 +691,7 @@ func (s *Service) collectFiles(rootDir string) ([]collector.FileInfo, time.Durat
 		SkipDirs:  s.skipDirList(),
 	})
 
-	files, err := c.Collect(rootDir)
+	files, err := c.Collect(ctx, rootDir)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -388,6 +706,8 @@ type fileWorkflowResult[T any] struct {
 	Result          T
 	SnapshotPath    string
 	JournalPath     string
+	Interrupted     bool
+	HashCacheErr    error
 }
 
 // Workflow invariant: no path is opened or mutated before validator approval.
@@ -395,15 +715,20 @@ type workflowTarget struct {
 	rootDir     string
 	validator   *safepath.Validator
 	undoTrasher *trash.Trasher
+
+	// hashCache is the hash cache of the target for the running workflow,
+	// nil when caching is disabled.
+	hashCache *hasher.Cache
 }
 
 func runFileWorkflow[T any](
+	ctx context.Context,
 	s *Service,
 	targetDir, command string,
 	dryRun bool,
-	execute func(rootDir string, validator *safepath.Validator, files []collector.FileInfo) (T, error),
+	execute func(ctx context.Context, target workflowTarget, files []collector.FileInfo) (T, error),
 	toJournalEntries func(T, string) []journal.Entry,
-) (fileWorkflowResult[T], error) {
+) (workflowResult fileWorkflowResult[T], err error) {
 	target, err := resolveWorkflowTarget(targetDir)
 	if err != nil {
 		return fileWorkflowResult[T]{}, err
@@ -416,12 +741,17 @@ func runFileWorkflow[T any](
 	}
 	defer lock.Close()
 
-	files, collectDuration, err := s.collectFiles(target.rootDir)
+	target.hashCache = s.openHashCache(target)
+	defer func() {
+		workflowResult.HashCacheErr = saveHashCache(target.hashCache)
+	}()
+
+	files, collectDuration, err := s.collectFiles(ctx, target.rootDir)
 	if err != nil {
 		return fileWorkflowResult[T]{}, fmt.Errorf("failed to collect files: %w", err)
 	}
 
-	workflowResult := fileWorkflowResult[T]{
+	workflowResult = fileWorkflowResult[T]{
 		RootDir:         target.rootDir,
 		FileCount:       len(files),
 		CollectDuration: collectDuration,
@@ -432,47 +762,52 @@ func runFileWorkflow[T any](
 
 	// Generate pre-operation snapshot unless disabled or in dry-run mode.
 	if !s.noSnapshot && !dryRun {
-		snapshotPath, snapshotErr := s.generateSnapshot(target, command)
+		snapshotPath, snapshotErr := s.generateSnapshot(ctx, target, command)
 		if snapshotErr != nil {
 			return fileWorkflowResult[T]{}, fmt.Errorf("failed to generate pre-operation snapshot: %w", snapshotErr)
 		}
 		workflowResult.SnapshotPath = snapshotPath
 	}
 
-	operationResult, err := execute(target.rootDir, target.validator, files)
-	if err != nil {
-		return fileWorkflowResult[T]{}, err
-	}
+	operationResult, execErr := execute(ctx, target, files)
 
+	// An interrupted or failed run still writes its journal, so the
+	// operations performed before it stopped can be undone.
 	workflowResult.Result = operationResult
+	workflowResult.Interrupted = ctx.Err() != nil
 
 	// Write operation journal unless in dry-run mode.
 	if !dryRun && toJournalEntries != nil {
 		journalPath, journalErr := writeJournal(target, command, toJournalEntries(operationResult, target.rootDir))
 		if journalErr != nil {
-			return fileWorkflowResult[T]{}, fmt.Errorf("failed to write operation journal: %w", journalErr)
+			return fileWorkflowResult[T]{}, errors.Join(execErr, fmt.Errorf("failed to write operation journal: %w", journalErr))
 		}
 		workflowResult.JournalPath = journalPath
 	}
+	if execErr != nil {
+		return workflowResult, execErr
+	}
 
 	return workflowResult, nil
 }
 
 func runCheckedExecution[T any, E any, O any](
+	ctx context.Context,
 	s *Service,
 	targetDir string,
 	dryRun bool,
-	execute func(rootDir string, validator *safepath.Validator, files []collector.FileInfo) (T, error),
+	execute func(ctx context.Context, target workflowTarget, files []collector.FileInfo) (T, error),
 	toExecution func(fileWorkflowResult[T]) E,
 	command string,
 	operations func(E) []O,
 	operationData func(O) (path string, err error),
 	toJournalEntries func(T, string) []journal.Entry,
 ) (E, error) {
-	workflowResult, err := runFileWorkflow(s, targetDir, command, dryRun, execute, toJournalEntries)
+	workflowResult, err := runFileWorkflow(ctx, s, targetDir, command, dryRun, execute, toJournalEntries)
 	if err != nil {
-		var zero E
-		return zero, err
+		// A run that failed part way still reports what it did and where
+		// its journal is.
+		return toExecution(workflowResult), err
 	}
 
 	execution := toExecution(workflowResult)
@@ -483,42 +818,68 @@ func runCheckedExecution[T any, E any, O any](
 	return execution, nil
 }
 
-func renameExecutor(dryRun bool, onProgress ProgressCallback) func(rootDir string, validator *safepath.Validator, files []collector.FileInfo) (renamer.Result, error) {
-	return func(rootDir string, validator *safepath.Validator, files []collector.FileInfo) (renamer.Result, error) {
-		trasher, err := initTrasher(rootDir, validator, "rename")
+func renameExecutor(dryRun bool, onProgress ProgressCallback) func(ctx context.Context, target workflowTarget, files []collector.FileInfo) (renamer.Result, error) {
+	return func(ctx context.Context, target workflowTarget, files []collector.FileInfo) (renamer.Result, error) {
+		trasher, err := initTrasher(target.rootDir, target.validator, "rename")
 		if err != nil {
 			return renamer.Result{}, fmt.Errorf("failed to initialize trash: %w", err)
 		}
 
-		r, err := renamer.NewWithValidator(validator, dryRun, trasher)
+		r, err := renamer.NewWithValidator(target.validator, dryRun, trasher)
 		if err != nil {
 			return renamer.Result{}, fmt.Errorf("failed to create renamer: %w", err)
 		}
 
-		return r.RenameFilesWithProgress(files, func(processed, total int) {
+		return r.RenameFilesWithProgress(ctx, files, func(processed, total int) {
 			progress.EmitStage(onProgress, "renaming", processed, total)
 		}), nil
 	}
 }
 
-func flattenExecutor(dryRun bool, workers int, onProgress ProgressCallback) func(rootDir string, validator *safepath.Validator, files []collector.FileInfo) (flattener.Result, error) {
+func (s *Service) flattenExecutor(dryRun bool, workers int, keep keeper.Policy, onProgress ProgressCallback) func(ctx context.Context, target workflowTarget, files []collector.FileInfo) (flattener.Result, error) {
 	return trashedWorkerExecutor(
 		dryRun, workers, onProgress, "flatten",
-		flattener.NewWithValidator,
+		func(target workflowTarget, dryRun bool, workers int, trasher *trash.Trasher) (*flattener.Flattener, error) {
+			return flattener.NewWithValidator(target.validator, dryRun, workers, trasher,
+				flattener.WithKeepPolicy(keep), flattener.WithHashCache(target.hashCache),
+				flattener.WithHashAlgorithm(s.hashAlgo))
+		},
 		"failed to create flattener",
-		func(f *flattener.Flattener, files []collector.FileInfo, cb func(string, int, int)) flattener.Result {
-			return f.FlattenFilesWithProgress(files, cb)
+		func(ctx context.Context, f *flattener.Flattener, files []collector.FileInfo, cb func(string, int, int)) flattener.Result {
+			return f.FlattenFilesWithProgress(ctx, files, cb)
 		},
 	)
 }
 
-func duplicateExecutor(dryRun bool, workers int, onProgress ProgressCallback) func(rootDir string, validator *safepath.Validator, files []collector.FileInfo) (deduplicator.Result, error) {
+func (s *Service) duplicateExecutor(req DuplicateRequest, ref *deduplicator.Reference) func(ctx context.Context, target workflowTarget, files []collector.FileInfo) (deduplicator.Result, error) {
 	return trashedWorkerExecutor(
-		dryRun, workers, onProgress, "duplicate",
-		deduplicator.NewWithValidator,
+		req.DryRun, req.Workers, req.OnProgress, "duplicate",
+		func(target workflowTarget, dryRun bool, workers int, trasher *trash.Trasher) (*deduplicator.Deduplicator, error) {
+			return deduplicator.NewWithValidator(target.validator, dryRun, workers, trasher,
+				deduplicator.WithKeepPolicy(req.Keep), deduplicator.WithLinkMode(req.Link),
+				deduplicator.WithDirectories(req.Directories), deduplicator.WithHashCache(target.hashCache),
+				deduplicator.WithHashAlgorithm(s.hashAlgo))
+		},
 		"failed to create deduplicator",
-		func(d *deduplicator.Deduplicator, files []collector.FileInfo, cb func(string, int, int)) deduplicator.Result {
-			return d.FindDuplicatesWithProgress(files, cb)
+		func(ctx context.Context, d *deduplicator.Deduplicator, files []collector.FileInfo, cb func(string, int, int)) deduplicator.Result {
+			if ref != nil {
+				return d.FindDuplicatesAgainst(ctx, files, ref, cb)
+			}
+			return d.FindDuplicatesWithProgress(ctx, files, cb)
+		},
+	)
+}
+
+func similarExecutor(dryRun bool, req SimilarRequest) func(ctx context.Context, target workflowTarget, files []collector.FileInfo) (similarity.Result, error) {
+	return trashedWorkerExecutor(
+		dryRun, req.Workers, req.OnProgress, "similar",
+		func(target workflowTarget, dryRun bool, workers int, trasher *trash.Trasher) (*similarity.Finder, error) {
+			return similarity.NewWithValidator(target.validator, dryRun, workers, trasher,
+				similarity.WithAlgorithm(req.Algorithm), similarity.WithMaxDistance(req.MaxDistance))
+		},
+		"failed to create similarity finder",
+		func(ctx context.Context, f *similarity.Finder, files []collector.FileInfo, cb func(string, int, int)) similarity.Result {
+			return f.FindSimilarWithProgress(ctx, files, cb)
 		},
 	)
 }
@@ -530,56 +891,84 @@ func trashedWorkerExecutor[Worker any, Result any](
 	workers int,
 	onProgress ProgressCallback,
 	command string,
-	newWorker func(*safepath.Validator, bool, int, *trash.Trasher) (Worker, error),
+	newWorker func(workflowTarget, bool, int, *trash.Trasher) (Worker, error),
 	createErrContext string,
-	run func(Worker, []collector.FileInfo, func(string, int, int)) Result,
-) func(string, *safepath.Validator, []collector.FileInfo) (Result, error) {
-	return func(rootDir string, validator *safepath.Validator, files []collector.FileInfo) (Result, error) {
-		trasher, err := initTrasher(rootDir, validator, command)
+	run func(context.Context, Worker, []collector.FileInfo, func(string, int, int)) Result,
+) func(context.Context, workflowTarget, []collector.FileInfo) (Result, error) {
+	return func(ctx context.Context, target workflowTarget, files []collector.FileInfo) (Result, error) {
+		trasher, err := initTrasher(target.rootDir, target.validator, command)
 		if err != nil {
 			var zero Result
 			return zero, fmt.Errorf("failed to initialize trash: %w", err)
 		}
 
-		w, err := newWorker(validator, dryRun, workers, trasher)
+		w, err := newWorker(target, dryRun, workers, trasher)
 		if err != nil {
 			var zero Result
 			return zero, fmt.Errorf("%s: %w", createErrContext, err)
 		}
 
-		return run(w, files, func(stage string, processed, total int) {
+		return run(ctx, w, files, func(stage string, processed, total int) {
 			progress.EmitStage(onProgress, stage, processed, total)
 		}), nil
 	}
 }
 
-func unzipExecutor(dryRun bool, onProgress ProgressCallback) func(rootDir string, validator *safepath.Validator, files []collector.FileInfo) (unzipper.Result, error) {
-	return func(rootDir string, validator *safepath.Validator, files []collector.FileInfo) (unzipper.Result, error) {
-		trasher, err := initTrasher(rootDir, validator, "unzip")
+func unzipExecutor(req UnzipRequest) func(ctx context.Context, target workflowTarget, files []collector.FileInfo) (unzipper.Result, error) {
+	return func(ctx context.Context, target workflowTarget, files []collector.FileInfo) (unzipper.Result, error) {
+		trasher, err := initTrasher(target.rootDir, target.validator, "unzip")
 		if err != nil {
 			return unzipper.Result{}, fmt.Errorf("failed to initialize trash: %w", err)
 		}
 
-		u, err := unzipper.NewWithValidator(validator, dryRun, trasher)
+		opts := []unzipper.Option{
+			unzipper.WithSingleFileDecompression(req.DecompressSingleFiles),
+		}
+		if req.Layout != "" {
+			opts = append(opts, unzipper.WithLayout(req.Layout))
+		}
+		if req.NameEncoding != "" {
+			opts = append(opts, unzipper.WithNameEncoding(req.NameEncoding))
+		}
+		if req.Limits != nil {
+			opts = append(opts, unzipper.WithLimits(*req.Limits))
+		}
+		if req.Symlinks != "" {
+			opts = append(opts, unzipper.WithSymlinkPolicy(req.Symlinks))
+		}
+		if req.Workers > 0 {
+			opts = append(opts, unzipper.WithWorkers(req.Workers))
+		}
+		if req.Salvage {
+			opts = append(opts, unzipper.WithSalvage(true))
+		}
+		if len(req.Passwords) > 0 {
+			opts = append(opts, unzipper.WithPasswords(req.Passwords))
+		}
+		if req.InMemoryLimit != nil {
+			opts = append(opts, unzipper.WithInMemoryLimit(*req.InMemoryLimit))
+		}
+
+		u, err := unzipper.NewWithValidator(target.validator, req.DryRun, trasher, opts...)
 		if err != nil {
 			return unzipper.Result{}, fmt.Errorf("failed to create unzipper: %w", err)
 		}
 
-		return u.ExtractArchivesWithProgressRecursively(files, func(stage string, processed, total int) {
-			progress.EmitStage(onProgress, stage, processed, total)
+		return u.ExtractArchivesWithProgressRecursively(ctx, files, func(stage string, processed, total int) {
+			progress.EmitStage(req.OnProgress, stage, processed, total)
 		})
 	}
 }
 
-func organizeExecutor(dryRun bool, onProgress ProgressCallback) func(rootDir string, validator *safepath.Validator, files []collector.FileInfo) (organizer.Result, error) {
+func organizeExecutor(dryRun bool, onProgress ProgressCallback) func(ctx context.Context, target workflowTarget, files []collector.FileInfo) (organizer.Result, error) {
 	return simpleExecutor(
 		dryRun,
 		onProgress,
 		organizer.NewWithValidator,
 		"failed to create organizer",
 		"organizing",
-		func(w *organizer.Organizer, files []collector.FileInfo, cb func(processed, total int)) organizer.Result {
-			return w.OrganizeFilesWithProgress(files, cb)
+		func(ctx context.Context, w *organizer.Organizer, files []collector.FileInfo, cb func(processed, total int)) organizer.Result {
+			return w.OrganizeFilesWithProgress(ctx, files, cb)
 		},
 	)
 }
@@ -590,16 +979,16 @@ func simpleExecutor[Worker any, Result any](
 	newWorker func(*safepath.Validator, bool) (Worker, error),
 	createErrContext string,
 	stageLabel string,
-	run func(Worker, []collector.FileInfo, func(processed, total int)) Result,
-) func(string, *safepath.Validator, []collector.FileInfo) (Result, error) {
-	return func(_ string, validator *safepath.Validator, files []collector.FileInfo) (Result, error) {
-		w, err := newWorker(validator, dryRun)
+	run func(context.Context, Worker, []collector.FileInfo, func(processed, total int)) Result,
+) func(context.Context, workflowTarget, []collector.FileInfo) (Result, error) {
+	return func(ctx context.Context, target workflowTarget, files []collector.FileInfo) (Result, error) {
+		w, err := newWorker(target.validator, dryRun)
 		if err != nil {
 			var zero Result
 			return zero, fmt.Errorf("%s: %w", createErrContext, err)
 		}
 
-		return run(w, files, func(processed, total int) {
+		return run(ctx, w, files, func(processed, total int) {
 			progress.EmitStage(onProgress, stageLabel, processed, total)
 		}), nil
 	}
@@ -636,7 +1025,7 @@ func (s *Service) skipDirList() []string {
 }
 
 // generateSnapshot creates a pre-operation manifest in .btidy/manifests/.
-func (s *Service) generateSnapshot(target workflowTarget, command string) (string, error) {
+func (s *Service) generateSnapshot(ctx context.Context, target workflowTarget, command string) (string, error) {
 	metaDir, err := metadata.Init(target.rootDir, target.validator)
 	if err != nil {
 		return "", fmt.Errorf("initialize metadata: %w", err)
@@ -651,12 +1040,13 @@ func (s *Service) generateSnapshot(target workflowTarget, command string) (strin
 		return "", fmt.Errorf("create manifests directory: %w", mkdirErr)
 	}
 
-	gen, err := manifest.NewGeneratorWithValidator(target.validator, runtime.NumCPU())
+	gen, err := manifest.NewGeneratorWithValidator(target.validator, runtime.NumCPU(),
+		hasher.WithAlgorithm(s.snapshotAlgorithm()), hasher.WithCache(target.hashCache))
 	if err != nil {
 		return "", fmt.Errorf("create manifest generator: %w", err)
 	}
 
-	m, err := gen.Generate(manifest.GenerateOptions{
+	m, err := gen.Generate(ctx, manifest.GenerateOptions{
 		SkipFiles: s.skipFileList(),
 		SkipDirs:  s.skipDirList(),
 	})
@@ -671,6 +1061,106 @@ func (s *Service) generateSnapshot(target workflowTarget, command string) (strin
 	return snapshotPath, nil
 }
 
+// snapshotAlgorithm returns the algorithm of pre-operation snapshots: the
+// selected one when cryptographic, SHA-256 otherwise, so a snapshot stays a
+// trustworthy inventory whatever duplicates are grouped by.
+func (s *Service) snapshotAlgorithm() hasher.Algorithm {
+	if algo, err := hasher.ParseAlgorithm(string(s.hashAlgo)); err == nil && algo.Cryptographic() {
+		return algo
+	}
+	return hasher.AlgorithmSHA256
+}
+
+// openHashCache loads the hash cache of target, or returns nil when caching
+// is disabled. A cache that cannot be opened is left out: it only saves
+// work, so the workflow runs without it.
+func (s *Service) openHashCache(target workflowTarget) *hasher.Cache {
+	if s.noCache {
+		return nil
+	}
+
+	metaDir, err := metadata.Init(target.rootDir, target.validator)
+	if err != nil {
+		return nil
+	}
+	cachePath := metaDir.HashCachePath()
+	if target.validator.ValidatePathForWrite(cachePath) != nil {
+		return nil
+	}
+
+	cache, err := hasher.OpenCache(cachePath)
+	if err != nil {
+		return nil
+	}
+	return cache
+}
+
+// saveHashCache saves cache, dry runs included, since the hashes hold for
+// the next run. Failing to save only costs that run the time to hash
+// again, so the error is reported alongside the result instead of failing
+// the workflow.
+func saveHashCache(cache *hasher.Cache) error {
+	if err := cache.Save(); err != nil {
+		return fmt.Errorf("failed to save hash cache: %w", err)
+	}
+	return nil
+}
+
+// loadReference opens the reference of a duplicate run against targetDir:
+// a manifest file, or a directory collected with the service's skip lists.
+// A reference that overlaps the target is rejected, since every file would
+// match itself.
+func (s *Service) loadReference(ctx context.Context, refPath, targetDir string) (*deduplicator.Reference, error) {
+	target, err := resolveWorkflowTarget(targetDir)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(refPath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot access reference: %w", err)
+	}
+
+	if !info.IsDir() {
+		m, loadErr := manifest.Load(refPath)
+		if loadErr != nil {
+			return nil, fmt.Errorf("load reference manifest: %w", loadErr)
+		}
+		if pathsOverlap(filepath.Clean(m.RootPath), target.rootDir) {
+			return nil, fmt.Errorf("reference manifest %s describes the target directory %s", refPath, target.rootDir)
+		}
+		return deduplicator.NewManifestReference(m), nil
+	}
+
+	refValidator, err := safepath.New(refPath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create reference path validator: %w", err)
+	}
+	if pathsOverlap(refValidator.Root(), target.rootDir) {
+		return nil, fmt.Errorf("reference directory %s overlaps the target directory %s", refPath, target.rootDir)
+	}
+
+	files, err := collector.New(collector.Options{
+		SkipFiles: s.skipFileList(),
+		SkipDirs:  s.skipDirList(),
+	}).Collect(ctx, refValidator.Root())
+	if err != nil {
+		return nil, fmt.Errorf("collect reference: %w", err)
+	}
+
+	return deduplicator.NewDirReference(files), nil
+}
+
+// pathsOverlap reports whether a and b, both absolute and clean, are the
+// same directory or one contains the other.
+func pathsOverlap(a, b string) bool {
+	within := func(parent, child string) bool {
+		rel, err := filepath.Rel(parent, child)
+		return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+	}
+	return within(a, b) || within(b, a)
+}
+
 func resolveWorkflowTarget(targetDir string) (workflowTarget, error) {
 	info, err := os.Stat(targetDir)
 	if err != nil {
@@ -866,33 +1356,94 @@ func flattenJournalEntries(result flattener.Result, rootDir string) []journal.En
 }
 
 // duplicateJournalEntries converts duplicate operations to journal entries.
+// A duplicate directory yields a "trash-dir" entry holding its Merkle hash,
+// and comes first as directories are removed before single files. A
+// duplicate replaced by a link yields a "link" entry naming the kept file
+// and the trashed duplicate. A file matched in a reference yields a "trash"
+// entry whose Kept is the absolute path of the reference file.
 func duplicateJournalEntries(result deduplicator.Result, rootDir string) []journal.Entry {
 	var entries []journal.Entry
+	for _, op := range result.DirOperations {
+		if op.Error != nil || op.Skipped || op.TrashedTo == "" {
+			continue
+		}
+		entries = append(entries, journal.Entry{
+			Type:    "trash-dir",
+			Source:  relPath(rootDir, op.Path),
+			Dest:    relPath(rootDir, op.TrashedTo),
+			Hash:    op.Hash,
+			Kept:    relPath(rootDir, op.OriginalOf),
+			Success: true,
+		})
+	}
 	for _, op := range result.Operations {
 		if op.Error != nil || op.Skipped {
 			continue
 		}
-		if op.TrashedTo != "" {
+		if op.TrashedTo == "" {
+			continue
+		}
+		if op.Link != deduplicator.LinkNone {
 			entries = append(entries, journal.Entry{
-				Type:    "trash",
+				Type:    "link",
 				Source:  relPath(rootDir, op.Path),
 				Dest:    relPath(rootDir, op.TrashedTo),
 				Hash:    op.Hash,
+				Kept:    relPath(rootDir, op.OriginalOf),
 				Success: true,
 			})
+			continue
+		}
+		entry := journal.Entry{
+			Type:    "trash",
+			Source:  relPath(rootDir, op.Path),
+			Dest:    relPath(rootDir, op.TrashedTo),
+			Hash:    op.Hash,
+			Success: true,
 		}
+		if op.Reference {
+			entry.Kept = op.OriginalOf
+		}
+		entries = append(entries, entry)
 	}
 	return entries
 }
 
-// unzipJournalEntries converts unzip operations to journal entries.
+// similarJournalEntries converts similar operations to "trash" journal
+// entries naming the image that was kept.
+func similarJournalEntries(result similarity.Result, rootDir string) []journal.Entry {
+	var entries []journal.Entry
+	for _, op := range result.Operations {
+		if op.Error != nil || op.TrashedTo == "" {
+			continue
+		}
+		entries = append(entries, journal.Entry{
+			Type:    "trash",
+			Source:  relPath(rootDir, op.Path),
+			Dest:    relPath(rootDir, op.TrashedTo),
+			Hash:    op.Hash,
+			Kept:    relPath(rootDir, op.KeptPath),
+			Success: true,
+		})
+	}
+	return entries
+}
+
+// unzipJournalEntries converts unzip operations to journal entries. Per
+// archive the order is: replaced files, created directories, created files,
+// the extract markers, then the trashed archive. An archive removed as a
+// byte-identical copy of one already extracted yields a single "duplicate"
+// entry naming the kept archive. An archive that failed or was skipped
+// keeps only its replaced, directory and created entries, so files written
+// before it stopped can still be undone. Files and markers of nested archives
+// extracted from memory name them by their nesting chain, as in
+// "backup.zip!old/photos.zip". Undo replays the journal in
+// reverse, so the archive is restored first, then created files are removed
+// before their now-empty directories, and replaced files come back last.
 func unzipJournalEntries(result unzipper.Result, rootDir string) []journal.Entry {
 	var entries []journal.Entry
 	for i := range result.Operations {
 		op := &result.Operations[i]
-		if op.Error != nil || op.Skipped {
-			continue
-		}
 		for _, replaced := range op.ReplacedFiles {
 			entries = append(entries, journal.Entry{
 				Type:    "replace",
@@ -902,13 +1453,64 @@ func unzipJournalEntries(result unzipper.Result, rootDir string) []journal.Entry
 				Success: true,
 			})
 		}
-		if op.ExtractionComplete {
+		for _, dir := range op.CreatedDirs {
+			entries = append(entries, journal.Entry{
+				Type:    "mkdir",
+				Source:  relPath(rootDir, dir),
+				Success: true,
+			})
+		}
+		for _, created := range op.CreatedFiles {
+			entries = append(entries, journal.Entry{
+				Type:    "create",
+				Source:  relPath(rootDir, created.Path),
+				Dest:    nestedArchivePath(rootDir, op.ArchivePath, created.Nested),
+				Hash:    created.Hash,
+				Success: true,
+			})
+		}
+		// A failed or skipped operation may have written files before it
+		// stopped; those are journaled above, but its archive stays put.
+		if op.Error != nil || op.Skipped {
+			continue
+		}
+		if op.Decompressed && op.ExtractionComplete {
+			entries = append(entries, journal.Entry{
+				Type:    "decompress",
+				Source:  relPath(rootDir, op.ArchivePath),
+				Dest:    relPath(rootDir, op.OutputPath),
+				Hash:    op.OutputHash,
+				Success: true,
+			})
+		} else if op.ExtractionComplete {
+			for _, streamed := range op.StreamedArchives {
+				if streamed.DuplicateOf != "" {
+					continue
+				}
+				entries = append(entries, journal.Entry{
+					Type:    "extract",
+					Source:  nestedArchivePath(rootDir, op.ArchivePath, streamed.Chain),
+					Success: true,
+				})
+			}
 			entries = append(entries, journal.Entry{
 				Type:    "extract",
 				Source:  relPath(rootDir, op.ArchivePath),
 				Success: true,
 			})
 		}
+		if op.Duplicate && op.TrashedTo != "" {
+			entries = append(entries, journal.Entry{
+				Type:    "duplicate",
+				Source:  relPath(rootDir, op.ArchivePath),
+				Dest:    relPath(rootDir, op.TrashedTo),
+				Hash:    op.ArchiveHash,
+				Kept:    relPath(rootDir, op.DuplicateOf),
+				Success: true,
+			})
+			entries = append(entries, trashedPartEntries(op, rootDir)...)
+			continue
+		}
 		if op.DeletedArchive && op.TrashedTo != "" {
 			entries = append(entries, journal.Entry{
 				Type:    "trash",
@@ -916,11 +1518,34 @@ func unzipJournalEntries(result unzipper.Result, rootDir string) []journal.Entry
 				Dest:    relPath(rootDir, op.TrashedTo),
 				Success: true,
 			})
+			entries = append(entries, trashedPartEntries(op, rootDir)...)
 		}
 	}
 	return entries
 }
 
+// nestedArchivePath returns the journal path of the archive reached from
+// archivePath through the member chain nested: the archive's relative
+// path followed by the chain, joined by [unzipper.ListLocationSeparator].
+func nestedArchivePath(rootDir, archivePath string, nested []string) string {
+	return unzipper.StreamedArchive{Chain: nested}.Location(relPath(rootDir, archivePath))
+}
+
+// trashedPartEntries journals the trashed .zNN volumes of a split archive
+// right after its tail, so undo restores the whole set.
+func trashedPartEntries(op *unzipper.ExtractOperation, rootDir string) []journal.Entry {
+	entries := make([]journal.Entry, 0, len(op.TrashedParts))
+	for i, trashedTo := range op.TrashedParts {
+		entries = append(entries, journal.Entry{
+			Type:    "trash",
+			Source:  relPath(rootDir, op.Parts[i]),
+			Dest:    relPath(rootDir, trashedTo),
+			Success: true,
+		})
+	}
+	return entries
+}
+
 // organizeJournalEntries converts organize operations to journal entries.
 func organizeJournalEntries(result organizer.Result, rootDir string) []journal.Entry {
 	var entries []journal.Entry
@@ -952,6 +1577,8 @@ type UndoRequest struct {
 const (
 	undoActionRestore       = "restore"
 	undoActionReverseRename = "reverse-rename"
+	undoActionRemove        = "remove"
+	undoActionTrash         = "trash"
 	undoActionSkip          = "skip"
 )
 
@@ -960,7 +1587,7 @@ type UndoOperation struct {
 	EntryType  string // original journal entry type
 	Source     string // original source path (relative)
 	Dest       string // original dest path (relative)
-	Action     string // undoActionRestore, undoActionReverseRename, undoActionSkip
+	Action     string // undoActionRestore, undoActionReverseRename, undoActionRemove, undoActionTrash, undoActionSkip
 	SkipReason string // why this entry was skipped
 	Error      error
 }
@@ -973,13 +1600,20 @@ type UndoExecution struct {
 	Operations    []UndoOperation
 	RestoredCount int
 	ReversedCount int
+	RemovedCount  int
+	TrashedCount  int
 	SkippedCount  int
 	ErrorCount    int
 	DryRun        bool
+	// Interrupted is set when the context was canceled before every entry
+	// was reversed. The journal is then not marked as rolled back, so
+	// running undo again reverses the rest; entries already reversed are
+	// skipped.
+	Interrupted bool
 }
 
 // RunUndo reverses the most recent (or specified) operation using its journal.
-func (s *Service) RunUndo(req UndoRequest) (UndoExecution, error) {
+func (s *Service) RunUndo(ctx context.Context, req UndoRequest) (UndoExecution, error) {
 	target, err := resolveWorkflowTarget(req.TargetDir)
 	if err != nil {
 		return UndoExecution{}, err
@@ -1026,6 +1660,11 @@ func (s *Service) RunUndo(req UndoRequest) (UndoExecution, error) {
 	}
 
 	for i, entry := range confirmed {
+		if ctx.Err() != nil {
+			exec.Interrupted = true
+			break
+		}
+
 		op := undoEntry(target, entry, req.DryRun)
 		exec.Operations = append(exec.Operations, op)
 
@@ -1038,13 +1677,17 @@ func (s *Service) RunUndo(req UndoRequest) (UndoExecution, error) {
 			exec.RestoredCount++
 		case op.Action == undoActionReverseRename:
 			exec.ReversedCount++
+		case op.Action == undoActionRemove:
+			exec.RemovedCount++
+		case op.Action == undoActionTrash:
+			exec.TrashedCount++
 		}
 
 		progress.EmitStage(req.OnProgress, "undoing", i+1, len(confirmed))
 	}
 
 	// Mark journal as rolled back by renaming to .rolled-back.jsonl.
-	if !req.DryRun && len(entries) > 0 {
+	if !req.DryRun && !exec.Interrupted && len(entries) > 0 {
 		rolledBackPath := strings.TrimSuffix(journalPath, ".jsonl") + ".rolled-back.jsonl"
 		if renameErr := os.Rename(journalPath, rolledBackPath); renameErr != nil {
 			return exec, fmt.Errorf("mark journal as rolled back: %w", renameErr)
@@ -1067,18 +1710,28 @@ func undoEntry(target workflowTarget, entry journal.Entry, dryRun bool) UndoOper
 	}
 
 	switch entry.Type {
-	case "trash":
+	case "trash", "duplicate":
 		return undoTrash(target, entry, dryRun)
+	case "trash-dir":
+		return undoTrashDir(target, entry, dryRun)
+	case "link":
+		return undoLink(target, entry, dryRun)
 	case "replace":
 		return undoReplace(target, entry, dryRun)
 	case "rename":
 		return undoRename(target, entry, dryRun)
+	case "decompress":
+		return undoDecompress(target, entry, dryRun)
+	case "create":
+		return undoCreate(target, entry, dryRun)
+	case "mkdir":
+		return undoMkdir(target, entry, dryRun)
 	case "extract":
 		return UndoOperation{
 			EntryType:  entry.Type,
 			Source:     entry.Source,
 			Action:     undoActionSkip,
-			SkipReason: "extract operations cannot be automatically undone",
+			SkipReason: "extracted files are undone through their create and mkdir entries",
 		}
 	default:
 		return UndoOperation{
@@ -1101,8 +1754,13 @@ func verifyHashBeforeUndo(path, expectedHash string) (reason string, changed boo
 		return "", false
 	}
 
-	h := hasher.New()
-	currentHash, err := h.ComputeHash(path)
+	// The hash records its algorithm, which may differ between runs.
+	algo, err := hasher.AlgorithmOf(expectedHash)
+	if err != nil {
+		return "cannot verify content: " + err.Error(), true
+	}
+
+	currentHash, err := hasher.New(hasher.WithAlgorithm(algo)).ComputeHash(path)
 	if err != nil {
 		return "cannot verify content: " + err.Error(), true
 	}
@@ -1173,6 +1831,117 @@ func undoTrash(target workflowTarget, entry journal.Entry, dryRun bool) UndoOper
 		"trashed file not found: "+entry.Dest)
 }
 
+// undoTrashDir restores a trashed directory, with everything in it, back
+// to its original location. It is skipped when the directory changed in
+// trash or its original path is taken again.
+func undoTrashDir(target workflowTarget, entry journal.Entry, dryRun bool) UndoOperation {
+	trashedAbs := filepath.Join(target.rootDir, entry.Dest)
+	sourceAbs := filepath.Join(target.rootDir, entry.Source)
+
+	base := UndoOperation{
+		EntryType: entry.Type,
+		Source:    entry.Source,
+		Dest:      entry.Dest,
+		Action:    undoActionRestore,
+	}
+
+	hash, err := deduplicator.HashDirectory(trashedAbs)
+	switch {
+	case err != nil:
+		base.Action = undoActionSkip
+		base.SkipReason = "cannot verify trashed directory: " + err.Error()
+		return base
+	case hash != entry.Hash:
+		base.Action = undoActionSkip
+		base.SkipReason = "content changed since original operation (hash mismatch)"
+		return base
+	}
+
+	if _, statErr := os.Lstat(sourceAbs); statErr == nil {
+		base.Action = undoActionSkip
+		base.SkipReason = "original path exists again: " + entry.Source
+		return base
+	}
+
+	if dryRun {
+		return base
+	}
+
+	if mkdirErr := target.validator.SafeMkdirAll(filepath.Dir(sourceAbs)); mkdirErr != nil {
+		base.Error = fmt.Errorf("create parent directory: %w", mkdirErr)
+		return base
+	}
+	if renameErr := target.validator.SafeRename(trashedAbs, sourceAbs); renameErr != nil {
+		base.Error = fmt.Errorf("%s: %w", undoActionRestore, renameErr)
+	}
+
+	return base
+}
+
+// undoLink replaces a link that stood in for a duplicate with the
+// duplicate itself, restored from trash as an independent copy. The link is
+// removed while its content still matches; when it was written to since, it
+// is moved to undo trash instead.
+func undoLink(target workflowTarget, entry journal.Entry, dryRun bool) UndoOperation {
+	trashedAbs := filepath.Join(target.rootDir, entry.Dest)
+	linkAbs := filepath.Join(target.rootDir, entry.Source)
+
+	base := UndoOperation{
+		EntryType: entry.Type,
+		Source:    entry.Source,
+		Dest:      entry.Dest,
+		Action:    undoActionRestore,
+	}
+
+	if _, statErr := os.Lstat(trashedAbs); statErr != nil {
+		base.Action = undoActionSkip
+		base.SkipReason = "trashed file not found: " + entry.Dest
+		return base
+	}
+
+	if reason, changed := verifyHashBeforeUndo(trashedAbs, entry.Hash); changed {
+		base.Action = undoActionSkip
+		base.SkipReason = reason
+		return base
+	}
+
+	if dryRun {
+		return base
+	}
+
+	if _, statErr := os.Lstat(linkAbs); statErr == nil {
+		if _, changed := verifyHashBeforeUndo(linkAbs, entry.Hash); !changed {
+			if removeErr := target.validator.SafeRemove(linkAbs); removeErr != nil {
+				base.Error = fmt.Errorf("remove link: %w", removeErr)
+				return base
+			}
+		}
+	}
+
+	skipReason, backupErr := backupUndoReplaceDestination(target, linkAbs, entry.Source)
+	if backupErr != nil {
+		base.Error = backupErr
+		return base
+	}
+	if skipReason != "" {
+		base.Action = undoActionSkip
+		base.SkipReason = skipReason
+		return base
+	}
+
+	if mkdirErr := target.validator.SafeMkdirAll(filepath.Dir(linkAbs)); mkdirErr != nil {
+		base.Error = fmt.Errorf("create parent directory: %w", mkdirErr)
+		return base
+	}
+
+	if renameErr := target.validator.SafeRename(trashedAbs, linkAbs); renameErr != nil {
+		base.Error = fmt.Errorf("restore: %w", renameErr)
+		return base
+	}
+
+	return base
+}
+
 // undoReplace restores a file that was replaced during unzip extraction.
 // If the destination path currently exists, it is first moved to undo trash
 // so the original pre-extraction file can be restored without data loss.
@@ -1269,6 +2038,129 @@ func backupUndoReplaceDestination(
 	return "", nil
 }
 
+// undoDecompress removes the output of a standalone decompression. The
+// compressed original is restored separately by the "trash" entry that
+// follows it in the journal. An output whose content changed since the
+// decompression is left in place.
+func undoDecompress(target workflowTarget, entry journal.Entry, dryRun bool) UndoOperation {
+	outputAbs := filepath.Join(target.rootDir, entry.Dest)
+
+	base := UndoOperation{
+		EntryType: entry.Type,
+		Source:    entry.Source,
+		Dest:      entry.Dest,
+		Action:    undoActionRemove,
+	}
+
+	if _, statErr := os.Lstat(outputAbs); statErr != nil {
+		base.Action = undoActionSkip
+		base.SkipReason = "decompressed file not found: " + entry.Dest
+		return base
+	}
+
+	if reason, changed := verifyHashBeforeUndo(outputAbs, entry.Hash); changed {
+		base.Action = undoActionSkip
+		base.SkipReason = reason
+		return base
+	}
+
+	if dryRun {
+		return base
+	}
+
+	if removeErr := target.validator.SafeRemove(outputAbs); removeErr != nil {
+		base.Error = fmt.Errorf("remove decompressed file: %w", removeErr)
+		return base
+	}
+
+	return base
+}
+
+// undoCreate removes a file written by archive extraction. A file whose
+// content changed since extraction is moved to undo trash instead of being
+// deleted, so edits made after the unzip are never lost.
+func undoCreate(target workflowTarget, entry journal.Entry, dryRun bool) UndoOperation {
+	createdAbs := filepath.Join(target.rootDir, entry.Source)
+
+	base := UndoOperation{
+		EntryType: entry.Type,
+		Source:    entry.Source,
+		Dest:      entry.Dest,
+		Action:    undoActionRemove,
+	}
+
+	if _, statErr := os.Lstat(createdAbs); statErr != nil {
+		base.Action = undoActionSkip
+		base.SkipReason = "extracted file not found: " + entry.Source
+		return base
+	}
+
+	if _, changed := verifyHashBeforeUndo(createdAbs, entry.Hash); changed {
+		base.Action = undoActionTrash
+	}
+
+	if dryRun {
+		return base
+	}
+
+	if base.Action == undoActionTrash {
+		if target.undoTrasher == nil {
+			base.Error = errors.New("trash changed file: undo trasher unavailable")
+			return base
+		}
+		if trashErr := target.undoTrasher.Trash(createdAbs); trashErr != nil {
+			base.Error = fmt.Errorf("trash changed file: %w", trashErr)
+		}
+		return base
+	}
+
+	if removeErr := target.validator.SafeRemove(createdAbs); removeErr != nil {
+		base.Error = fmt.Errorf("remove extracted file: %w", removeErr)
+	}
+
+	return base
+}
+
+// undoMkdir removes a directory created by archive extraction, but only
+// when it is empty; anything placed there since is left untouched.
+func undoMkdir(target workflowTarget, entry journal.Entry, dryRun bool) UndoOperation {
+	dirAbs := filepath.Join(target.rootDir, entry.Source)
+
+	base := UndoOperation{
+		EntryType: entry.Type,
+		Source:    entry.Source,
+		Action:    undoActionRemove,
+	}
+
+	info, statErr := os.Lstat(dirAbs)
+	if statErr != nil || !info.IsDir() {
+		base.Action = undoActionSkip
+		base.SkipReason = "created directory not found: " + entry.Source
+		return base
+	}
+
+	if dryRun {
+		return base
+	}
+
+	dirEntries, readErr := os.ReadDir(dirAbs)
+	if readErr != nil {
+		base.Error = fmt.Errorf("read created directory: %w", readErr)
+		return base
+	}
+	if len(dirEntries) > 0 {
+		base.Action = undoActionSkip
+		base.SkipReason = "directory not empty: " + entry.Source
+		return base
+	}
+
+	if removeErr := target.validator.SafeRemoveDir(dirAbs); removeErr != nil {
+		base.Error = fmt.Errorf("remove created directory: %w", removeErr)
+	}
+
+	return base
+}
+
 // undoRename reverses a rename by moving the file from dest back to source.
 func undoRename(target workflowTarget, entry journal.Entry, dryRun bool) UndoOperation {
 	destAbs := filepath.Join(target.rootDir, entry.Dest)
@@ -1364,10 +2256,11 @@ type PurgeExecution struct {
 	PurgedSize  int64
 	ErrorCount  int
 	DryRun      bool
+	Interrupted bool // the context was canceled before every run was purged
 }
 
 // RunPurge permanently deletes trashed files based on filter criteria.
-func (s *Service) RunPurge(req PurgeRequest) (PurgeExecution, error) {
+func (s *Service) RunPurge(ctx context.Context, req PurgeRequest) (PurgeExecution, error) {
 	target, err := resolveWorkflowTarget(req.TargetDir)
 	if err != nil {
 		return PurgeExecution{}, err
@@ -1397,6 +2290,11 @@ func (s *Service) RunPurge(req PurgeRequest) (PurgeExecution, error) {
 
 	filtered := filterTrashRuns(runs, req)
 	for i, run := range filtered {
+		if ctx.Err() != nil {
+			exec.Interrupted = true
+			break
+		}
+
 		op := purgeRun(run, req.DryRun)
 		exec.Operations = append(exec.Operations, op)
 
@@ -1525,3 +2423,69 @@ func purgeRun(run TrashRunInfo, dryRun bool) PurgeOperation {
 	op.Purged = true
 	return op
 }
+
+// CachePruneRequest contains inputs for the cache prune workflow.
+type CachePruneRequest struct {
+	TargetDir string
+	DryRun    bool
+}
+
+// CachePruneExecution contains cache prune workflow outputs.
+type CachePruneExecution struct {
+	RootDir   string
+	CachePath string
+	Entries   int // files in the cache before pruning
+	Pruned    int // entries of files that are gone or changed
+	DryRun    bool
+}
+
+// RunCachePrune drops the hash cache entries of files that no longer exist
+// or changed since they were hashed. In dry-run mode, or when ctx is done
+// before the cache is saved, the cache is left as it is. It runs with
+// --no-cache too, which only stops workflows from using the cache.
+func (s *Service) RunCachePrune(ctx context.Context, req CachePruneRequest) (CachePruneExecution, error) {
+	target, err := resolveWorkflowTarget(req.TargetDir)
+	if err != nil {
+		return CachePruneExecution{}, err
+	}
+
+	lock, lockErr := acquireWorkflowLock(target)
+	if lockErr != nil {
+		return CachePruneExecution{}, lockErr
+	}
+	defer lock.Close()
+
+	metaDir, initErr := metadata.Init(target.rootDir, target.validator)
+	if initErr != nil {
+		return CachePruneExecution{}, fmt.Errorf("initialize metadata: %w", initErr)
+	}
+
+	cachePath := metaDir.HashCachePath()
+	if err := target.validator.ValidatePathForWrite(cachePath); err != nil {
+		return CachePruneExecution{}, fmt.Errorf("unsafe hash cache path: %w", err)
+	}
+
+	cache, err := hasher.OpenCache(cachePath)
+	if err != nil {
+		return CachePruneExecution{}, err
+	}
+
+	exec := CachePruneExecution{
+		RootDir:   target.rootDir,
+		CachePath: cachePath,
+		Entries:   cache.Len(),
+		DryRun:    req.DryRun,
+	}
+	exec.Pruned = cache.Prune()
+	if err := ctx.Err(); err != nil {
+		return CachePruneExecution{}, err
+	}
+
+	if !req.DryRun {
+		if err := cache.Save(); err != nil {
+			return CachePruneExecution{}, err
+		}
+	}
+
+	return exec, nil
+}
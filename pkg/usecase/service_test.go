@@ -3,9 +3,17 @@ package usecase
 import (
 	"archive/zip"
 	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -13,9 +21,14 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"btidy/internal/testutil"
+	"btidy/pkg/deduplicator"
 	"btidy/pkg/filelock"
+	"btidy/pkg/hasher"
 	"btidy/pkg/journal"
+	"btidy/pkg/keeper"
 	"btidy/pkg/manifest"
+	"btidy/pkg/similarity"
+	"btidy/pkg/unzipper"
 )
 
 type zipFixtureEntry struct {
@@ -84,7 +97,7 @@ func TestService_RunRename_DryRun(t *testing.T) {
 	progressCalls := 0
 	lastStage := ""
 	s := New(Options{})
-	execution, err := s.RunRename(RenameRequest{
+	execution, err := s.RunRename(t.Context(), RenameRequest{
 		TargetDir: tmpDir,
 		DryRun:    true,
 		OnProgress: func(stage string, _, _ int) {
@@ -119,7 +132,7 @@ func TestService_RunFlatten_DryRun(t *testing.T) {
 	progressCalls := 0
 	lastStage := ""
 	s := New(Options{})
-	execution, err := s.RunFlatten(FlattenRequest{
+	execution, err := s.RunFlatten(t.Context(), FlattenRequest{
 		TargetDir: tmpDir,
 		DryRun:    true,
 		Workers:   3,
@@ -156,7 +169,7 @@ func TestService_RunDuplicate_DryRun(t *testing.T) {
 	progressCalls := 0
 	lastStage := ""
 	s := New(Options{})
-	execution, err := s.RunDuplicate(DuplicateRequest{
+	execution, err := s.RunDuplicate(t.Context(), DuplicateRequest{
 		TargetDir: tmpDir,
 		DryRun:    true,
 		Workers:   3,
@@ -194,7 +207,7 @@ func TestService_RunManifest_WithSkipFiles(t *testing.T) {
 
 	progressCalls := 0
 	s := New(Options{SkipFiles: []string{".DS_Store"}})
-	execution, err := s.RunManifest(ManifestRequest{
+	execution, err := s.RunManifest(t.Context(), ManifestRequest{
 		TargetDir:  tmpDir,
 		OutputPath: outputPath,
 		Workers:    2,
@@ -227,7 +240,7 @@ func TestService_RunManifest_RelativeOutputResolvesInsideTarget(t *testing.T) {
 	)
 
 	s := New(Options{})
-	execution, err := s.RunManifest(ManifestRequest{
+	execution, err := s.RunManifest(t.Context(), ManifestRequest{
 		TargetDir:  tmpDir,
 		OutputPath: "manifest.json",
 		Workers:    1,
@@ -240,6 +253,29 @@ func TestService_RunManifest_RelativeOutputResolvesInsideTarget(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestService_RunManifest_LockPreventsConflict(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	testutil.CreateFile(t, filepath.Join(tmpDir, "file.txt"), "content")
+
+	metaDir := filepath.Join(tmpDir, ".btidy")
+	require.NoError(t, os.MkdirAll(metaDir, 0o755))
+	lock, err := filelock.Acquire(filepath.Join(metaDir, "lock"))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = lock.Close()
+	})
+
+	_, err = New(Options{}).RunManifest(t.Context(), ManifestRequest{
+		TargetDir:  tmpDir,
+		OutputPath: "manifest.json",
+		Workers:    1,
+	})
+	require.ErrorContains(t, err, "another btidy process")
+	assert.NoFileExists(t, filepath.Join(tmpDir, "manifest.json"))
+}
+
 func TestService_RunManifest_OutputOutsideTargetRejected(t *testing.T) {
 	t.Parallel()
 
@@ -255,7 +291,7 @@ func TestService_RunManifest_OutputOutsideTargetRejected(t *testing.T) {
 	outsideOutputPath := filepath.Join(outsideDir, "manifest.json")
 
 	s := New(Options{})
-	_, err := s.RunManifest(ManifestRequest{
+	_, err := s.RunManifest(t.Context(), ManifestRequest{
 		TargetDir:  tmpDir,
 		OutputPath: outsideOutputPath,
 		Workers:    1,
@@ -279,7 +315,7 @@ func TestService_RunUnzip_DryRun(t *testing.T) {
 	progressCalls := 0
 	lastStage := ""
 	s := New(Options{})
-	execution, err := s.RunUnzip(UnzipRequest{
+	execution, err := s.RunUnzip(t.Context(), UnzipRequest{
 		TargetDir: tmpDir,
 		DryRun:    true,
 		OnProgress: func(stage string, _, _ int) {
@@ -324,7 +360,7 @@ func TestService_RunUnzip_RecursiveNestedArchives(t *testing.T) {
 	})
 
 	s := New(Options{})
-	execution, err := s.RunUnzip(UnzipRequest{
+	execution, err := s.RunUnzip(t.Context(), UnzipRequest{
 		TargetDir: tmpDir,
 		DryRun:    false,
 	})
@@ -332,11 +368,12 @@ func TestService_RunUnzip_RecursiveNestedArchives(t *testing.T) {
 
 	assert.Equal(t, tmpDir, execution.RootDir)
 	assert.Equal(t, 1, execution.FileCount)
-	assert.Equal(t, 2, execution.Result.ArchivesFound)
-	assert.Equal(t, 2, execution.Result.ArchivesProcessed)
+	assert.Equal(t, 1, execution.Result.ArchivesFound)
+	assert.Equal(t, 1, execution.Result.ArchivesProcessed)
 	assert.Equal(t, 2, execution.Result.ExtractedArchives)
-	assert.Equal(t, 2, execution.Result.DeletedArchives)
-	assert.Equal(t, 3, execution.Result.ExtractedFiles)
+	assert.Equal(t, 1, execution.Result.StreamedArchives)
+	assert.Equal(t, 1, execution.Result.DeletedArchives)
+	assert.Equal(t, 2, execution.Result.ExtractedFiles)
 	assert.Equal(t, 0, execution.Result.ExtractedDirs)
 	assert.Equal(t, 0, execution.Result.ErrorCount)
 
@@ -344,13 +381,31 @@ func TestService_RunUnzip_RecursiveNestedArchives(t *testing.T) {
 	assert.True(t, os.IsNotExist(err), "outer archive should be removed")
 
 	_, err = os.Stat(filepath.Join(tmpDir, "nested", "inner.zip"))
-	assert.True(t, os.IsNotExist(err), "nested archive should be removed")
+	assert.True(t, os.IsNotExist(err), "nested archive should never be written")
 
 	_, err = os.Stat(filepath.Join(tmpDir, "outer.txt"))
 	require.NoError(t, err)
 
 	_, err = os.Stat(filepath.Join(tmpDir, "nested", "inner", "final.txt"))
 	require.NoError(t, err)
+
+	entries, err := journal.NewReader(execution.JournalPath).Entries()
+	require.NoError(t, err)
+	created := make(map[string]string)
+	var extracted []string
+	for _, e := range filterConfirmed(entries) {
+		switch e.Type {
+		case "create":
+			created[e.Source] = e.Dest
+		case "extract":
+			extracted = append(extracted, e.Source)
+		}
+	}
+	assert.Equal(t, map[string]string{
+		"nested/inner/final.txt": "outer.zip!nested/inner.zip",
+		"outer.txt":              "outer.zip",
+	}, created)
+	assert.Equal(t, []string{"outer.zip!nested/inner.zip", "outer.zip"}, extracted)
 }
 
 func TestService_RunDuplicate_GeneratesSnapshot(t *testing.T) {
@@ -362,7 +417,7 @@ func TestService_RunDuplicate_GeneratesSnapshot(t *testing.T) {
 	testutil.CreateFileWithModTime(t, filepath.Join(tmpDir, "b.txt"), "same-content", modTime)
 
 	s := New(Options{})
-	execution, err := s.RunDuplicate(DuplicateRequest{
+	execution, err := s.RunDuplicate(t.Context(), DuplicateRequest{
 		TargetDir: tmpDir,
 		DryRun:    false,
 		Workers:   2,
@@ -379,6 +434,333 @@ func TestService_RunDuplicate_GeneratesSnapshot(t *testing.T) {
 	assert.Equal(t, 2, m.FileCount())
 }
 
+func TestService_RunDuplicate_AppliesKeepPolicy(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	testutil.CreateFileWithModTime(t, filepath.Join(tmpDir, "a.txt"), "same-content", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	testutil.CreateFileWithModTime(t, filepath.Join(tmpDir, "b.txt"), "same-content", time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	s := New(Options{NoSnapshot: true})
+	execution, err := s.RunDuplicate(t.Context(), DuplicateRequest{
+		TargetDir: tmpDir,
+		Workers:   2,
+		Keep:      keeper.Policy{Strategy: keeper.StrategyOldest},
+	})
+	require.NoError(t, err)
+
+	require.Len(t, execution.Result.Operations, 1)
+	op := execution.Result.Operations[0]
+	assert.Equal(t, filepath.Join(tmpDir, "a.txt"), op.Path)
+	assert.Equal(t, "oldest modification time", op.KeepReason)
+	assert.FileExists(t, filepath.Join(tmpDir, "b.txt"))
+	assert.NoFileExists(t, filepath.Join(tmpDir, "a.txt"))
+}
+
+func TestService_RunDuplicate_LinkIsJournaledAndUndone(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	modTime := time.Date(2018, 6, 15, 12, 0, 0, 0, time.UTC)
+	keptPath := filepath.Join(tmpDir, "a.txt")
+	dupPath := filepath.Join(tmpDir, "b.txt")
+	testutil.CreateFileWithModTime(t, keptPath, "same-content", modTime)
+	testutil.CreateFileWithModTime(t, dupPath, "same-content", modTime)
+
+	s := New(Options{NoSnapshot: true})
+	execution, err := s.RunDuplicate(t.Context(), DuplicateRequest{
+		TargetDir: tmpDir,
+		Workers:   2,
+		Link:      deduplicator.LinkHard,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, execution.Result.LinkedCount)
+
+	journalEntries, err := journal.NewReader(execution.JournalPath).Entries()
+	require.NoError(t, err)
+	confirmed := filterConfirmed(journalEntries)
+	require.Len(t, confirmed, 1)
+	assert.Equal(t, "link", confirmed[0].Type)
+	assert.Equal(t, "b.txt", confirmed[0].Source)
+	assert.Equal(t, "a.txt", confirmed[0].Kept)
+	assert.NotEmpty(t, confirmed[0].Dest)
+
+	keptInfo, err := os.Stat(keptPath)
+	require.NoError(t, err)
+	dupInfo, err := os.Stat(dupPath)
+	require.NoError(t, err)
+	require.True(t, os.SameFile(keptInfo, dupInfo))
+
+	undoExec, err := s.RunUndo(t.Context(), UndoRequest{TargetDir: tmpDir})
+	require.NoError(t, err)
+	assert.Equal(t, 1, undoExec.RestoredCount)
+	assert.Zero(t, undoExec.ErrorCount)
+
+	dupInfo, err = os.Stat(dupPath)
+	require.NoError(t, err)
+	assert.False(t, os.SameFile(keptInfo, dupInfo), "undo restores an independent copy")
+	content, err := os.ReadFile(dupPath)
+	require.NoError(t, err)
+	assert.Equal(t, "same-content", string(content))
+	assert.FileExists(t, keptPath)
+}
+
+func TestService_RunDuplicate_AgainstReference(t *testing.T) {
+	t.Parallel()
+
+	refDir := t.TempDir()
+	tmpDir := t.TempDir()
+	modTime := time.Date(2018, 6, 15, 12, 0, 0, 0, time.UTC)
+	refPath := filepath.Join(refDir, "archived.txt")
+	dupPath := filepath.Join(tmpDir, "copy.txt")
+	testutil.CreateFileWithModTime(t, refPath, "archived", modTime)
+	testutil.CreateFileWithModTime(t, dupPath, "archived", modTime)
+	testutil.CreateFileWithModTime(t, filepath.Join(tmpDir, "a.txt"), "same-content", modTime)
+	testutil.CreateFileWithModTime(t, filepath.Join(tmpDir, "b.txt"), "same-content", modTime)
+
+	s := New(Options{NoSnapshot: true})
+	execution, err := s.RunDuplicate(t.Context(), DuplicateRequest{
+		TargetDir: tmpDir,
+		Workers:   2,
+		Against:   refDir,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, execution.Result.DeletedCount)
+	assert.NoFileExists(t, dupPath)
+	assert.FileExists(t, filepath.Join(tmpDir, "b.txt"))
+
+	journalEntries, err := journal.NewReader(execution.JournalPath).Entries()
+	require.NoError(t, err)
+	confirmed := filterConfirmed(journalEntries)
+	require.Len(t, confirmed, 1)
+	assert.Equal(t, "trash", confirmed[0].Type)
+	assert.Equal(t, "copy.txt", confirmed[0].Source)
+	resolvedRef, err := filepath.EvalSymlinks(refPath)
+	require.NoError(t, err)
+	assert.Equal(t, resolvedRef, confirmed[0].Kept)
+
+	undoExec, err := s.RunUndo(t.Context(), UndoRequest{TargetDir: tmpDir})
+	require.NoError(t, err)
+	assert.Equal(t, 1, undoExec.RestoredCount)
+	assert.FileExists(t, dupPath)
+	assert.FileExists(t, refPath)
+}
+
+func TestService_RunDuplicate_AgainstRejectsOverlapAndLink(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	subDir := filepath.Join(tmpDir, "sub")
+	require.NoError(t, os.MkdirAll(subDir, 0o755))
+
+	s := New(Options{NoSnapshot: true})
+	_, err := s.RunDuplicate(t.Context(), DuplicateRequest{TargetDir: tmpDir, Against: subDir})
+	require.ErrorContains(t, err, "overlaps the target")
+
+	_, err = s.RunDuplicate(t.Context(), DuplicateRequest{TargetDir: subDir, Against: tmpDir})
+	require.ErrorContains(t, err, "overlaps the target")
+
+	_, err = s.RunDuplicate(t.Context(), DuplicateRequest{TargetDir: tmpDir, Against: t.TempDir(), Link: deduplicator.LinkHard})
+	require.Error(t, err)
+}
+
+func TestService_RunDuplicate_DirectoryIsTrashedAndRestoredAsUnit(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	modTime := time.Date(2019, 7, 1, 12, 0, 0, 0, time.UTC)
+	for _, dir := range []string{"2019-trip", "copy of 2019-trip"} {
+		testutil.CreateFileWithModTime(t, filepath.Join(tmpDir, dir, "a.jpg"), "beach", modTime)
+		testutil.CreateFileWithModTime(t, filepath.Join(tmpDir, dir, "day2", "b.jpg"), "mountain", modTime)
+	}
+	copyDir := filepath.Join(tmpDir, "copy of 2019-trip")
+
+	s := New(Options{NoSnapshot: true})
+	execution, err := s.RunDuplicate(t.Context(), DuplicateRequest{
+		TargetDir:   tmpDir,
+		Workers:     2,
+		Directories: true,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, execution.Result.DirsDeletedCount)
+	assert.Empty(t, execution.Result.Operations)
+	assert.NoDirExists(t, copyDir)
+
+	journalEntries, err := journal.NewReader(execution.JournalPath).Entries()
+	require.NoError(t, err)
+	confirmed := filterConfirmed(journalEntries)
+	require.Len(t, confirmed, 1)
+	assert.Equal(t, "trash-dir", confirmed[0].Type)
+	assert.Equal(t, "copy of 2019-trip", confirmed[0].Source)
+	assert.Equal(t, "2019-trip", confirmed[0].Kept)
+	assert.NotEmpty(t, confirmed[0].Hash)
+
+	undoExec, err := s.RunUndo(t.Context(), UndoRequest{TargetDir: tmpDir})
+	require.NoError(t, err)
+	assert.Equal(t, 1, undoExec.RestoredCount)
+	assert.Zero(t, undoExec.ErrorCount)
+	content, err := os.ReadFile(filepath.Join(copyDir, "day2", "b.jpg"))
+	require.NoError(t, err)
+	assert.Equal(t, "mountain", string(content))
+}
+
+func TestService_RunDuplicate_DirectoryUndoSkipsChangedTrash(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	modTime := time.Date(2019, 7, 1, 12, 0, 0, 0, time.UTC)
+	for _, dir := range []string{"a", "b"} {
+		testutil.CreateFileWithModTime(t, filepath.Join(tmpDir, dir, "x.txt"), "same", modTime)
+	}
+
+	s := New(Options{NoSnapshot: true})
+	execution, err := s.RunDuplicate(t.Context(), DuplicateRequest{TargetDir: tmpDir, Directories: true})
+	require.NoError(t, err)
+	require.Len(t, execution.Result.DirOperations, 1)
+
+	trashed := execution.Result.DirOperations[0].TrashedTo
+	require.NoError(t, os.WriteFile(filepath.Join(trashed, "extra.txt"), []byte("added"), 0o600))
+
+	undoExec, err := s.RunUndo(t.Context(), UndoRequest{TargetDir: tmpDir})
+	require.NoError(t, err)
+	assert.Equal(t, 1, undoExec.SkippedCount)
+	assert.NoDirExists(t, filepath.Join(tmpDir, "b"))
+}
+
+// writeBlockImage writes a PNG of size x size pixels made of 8x8 blocks
+// of fixed gray levels, so every size shows the same picture.
+func writeBlockImage(t *testing.T, path string, size int) {
+	t.Helper()
+
+	levels := []uint8{12, 200, 90, 240, 30, 160, 70, 220}
+	img := image.NewGray(image.Rect(0, 0, size, size))
+	for y := range size {
+		for x := range size {
+			bx, by := x*8/size, y*8/size
+			img.SetGray(x, y, color.Gray{Y: levels[(bx*3+by*5)%len(levels)]})
+		}
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0o600))
+}
+
+func TestService_RunSimilar_ReportsByDefaultAndTrashesOnRequest(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	keptPath := filepath.Join(tmpDir, "large.png")
+	smallPath := filepath.Join(tmpDir, "small.png")
+	writeBlockImage(t, keptPath, 256)
+	writeBlockImage(t, smallPath, 64)
+
+	s := New(Options{NoSnapshot: true})
+	execution, err := s.RunSimilar(t.Context(), SimilarRequest{
+		TargetDir:   tmpDir,
+		Workers:     2,
+		MaxDistance: similarity.DefaultMaxDistance,
+	})
+	require.NoError(t, err)
+	require.Len(t, execution.Result.Groups, 1)
+	assert.Equal(t, keptPath, execution.Result.Groups[0].Keep.Path)
+	assert.Empty(t, execution.JournalPath, "report-only runs are not journaled")
+	assert.FileExists(t, smallPath)
+
+	execution, err = s.RunSimilar(t.Context(), SimilarRequest{
+		TargetDir:   tmpDir,
+		Workers:     2,
+		Trash:       true,
+		MaxDistance: similarity.DefaultMaxDistance,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, execution.Result.TrashedCount)
+	assert.NoFileExists(t, smallPath)
+
+	journalEntries, err := journal.NewReader(execution.JournalPath).Entries()
+	require.NoError(t, err)
+	confirmed := filterConfirmed(journalEntries)
+	require.Len(t, confirmed, 1)
+	assert.Equal(t, "trash", confirmed[0].Type)
+	assert.Equal(t, "small.png", confirmed[0].Source)
+	assert.Equal(t, "large.png", confirmed[0].Kept)
+
+	undoExec, err := s.RunUndo(t.Context(), UndoRequest{TargetDir: tmpDir})
+	require.NoError(t, err)
+	assert.Equal(t, 1, undoExec.RestoredCount)
+	assert.FileExists(t, smallPath)
+}
+
+func TestService_HashCacheIsWrittenAndPruned(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == "windows" {
+		t.Skip("the hash cache needs device and inode numbers")
+	}
+
+	tmpDir := t.TempDir()
+	modTime := time.Date(2018, 6, 15, 12, 0, 0, 0, time.UTC)
+	testutil.CreateFileWithModTime(t, filepath.Join(tmpDir, "a.txt"), "same-content", modTime)
+	testutil.CreateFileWithModTime(t, filepath.Join(tmpDir, "b.txt"), "same-content", modTime)
+	testutil.CreateFileWithModTime(t, filepath.Join(tmpDir, "c.txt"), "other-content", modTime)
+	cachePath := filepath.Join(tmpDir, ".btidy", "hashcache")
+
+	_, err := New(Options{NoSnapshot: true, NoCache: true}).RunDuplicate(t.Context(), DuplicateRequest{TargetDir: tmpDir, DryRun: true, Workers: 2})
+	require.NoError(t, err)
+	assert.NoFileExists(t, cachePath, "--no-cache neither reads nor writes the cache")
+
+	s := New(Options{})
+	execution, err := s.RunDuplicate(t.Context(), DuplicateRequest{TargetDir: tmpDir, Workers: 2})
+	require.NoError(t, err)
+	assert.Equal(t, 1, execution.Result.DeletedCount)
+	assert.FileExists(t, cachePath)
+
+	prune, err := s.RunCachePrune(t.Context(), CachePruneRequest{TargetDir: tmpDir, DryRun: true})
+	require.NoError(t, err)
+	assert.Equal(t, 3, prune.Entries, "the snapshot hashed every file")
+	assert.Equal(t, 1, prune.Pruned, "the trashed duplicate is no longer at its path")
+
+	prune, err = s.RunCachePrune(t.Context(), CachePruneRequest{TargetDir: tmpDir})
+	require.NoError(t, err)
+	assert.Equal(t, 1, prune.Pruned)
+
+	prune, err = s.RunCachePrune(t.Context(), CachePruneRequest{TargetDir: tmpDir})
+	require.NoError(t, err)
+	assert.Equal(t, 2, prune.Entries)
+	assert.Zero(t, prune.Pruned)
+}
+
+func TestService_ConcurrentWorkflowsKeepTheirOwnHashCache(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == "windows" {
+		t.Skip("the hash cache needs device and inode numbers")
+	}
+
+	dirs := []string{t.TempDir(), t.TempDir()}
+	for _, dir := range dirs {
+		for i := range 20 {
+			testutil.CreateFile(t, filepath.Join(dir, fmt.Sprintf("f%02d.txt", i)), fmt.Sprintf("content %d", i%10))
+		}
+	}
+
+	s := New(Options{})
+	executions := make([]DuplicateExecution, len(dirs))
+	errs := make([]error, len(dirs))
+	var wg sync.WaitGroup
+	for i, dir := range dirs {
+		wg.Go(func() {
+			executions[i], errs[i] = s.RunDuplicate(t.Context(), DuplicateRequest{TargetDir: dir, Workers: 2})
+		})
+	}
+	wg.Wait()
+
+	for i, dir := range dirs {
+		require.NoError(t, errs[i])
+		assert.Equal(t, 10, executions[i].Result.DeletedCount)
+		require.NoError(t, executions[i].HashCacheErr)
+		assert.FileExists(t, filepath.Join(dir, ".btidy", "hashcache"), "each target gets its own cache")
+	}
+}
+
 func TestService_RunDuplicate_DryRunSkipsSnapshot(t *testing.T) {
 	t.Parallel()
 
@@ -388,7 +770,7 @@ func TestService_RunDuplicate_DryRunSkipsSnapshot(t *testing.T) {
 	testutil.CreateFileWithModTime(t, filepath.Join(tmpDir, "b.txt"), "same-content", modTime)
 
 	s := New(Options{})
-	execution, err := s.RunDuplicate(DuplicateRequest{
+	execution, err := s.RunDuplicate(t.Context(), DuplicateRequest{
 		TargetDir: tmpDir,
 		DryRun:    true,
 		Workers:   2,
@@ -410,7 +792,7 @@ func TestService_RunFlatten_NoSnapshotOption(t *testing.T) {
 	testutil.CreateFileWithModTime(t, filepath.Join(tmpDir, "nested", "file.txt"), "content", modTime)
 
 	s := New(Options{NoSnapshot: true})
-	execution, err := s.RunFlatten(FlattenRequest{
+	execution, err := s.RunFlatten(t.Context(), FlattenRequest{
 		TargetDir: tmpDir,
 		DryRun:    false,
 		Workers:   2,
@@ -432,7 +814,7 @@ func TestService_RunRename_GeneratesSnapshot(t *testing.T) {
 	testutil.CreateFileWithModTime(t, filepath.Join(tmpDir, "My Document.pdf"), "content", modTime)
 
 	s := New(Options{})
-	execution, err := s.RunRename(RenameRequest{
+	execution, err := s.RunRename(t.Context(), RenameRequest{
 		TargetDir: tmpDir,
 		DryRun:    false,
 	})
@@ -447,271 +829,944 @@ func TestService_RunRename_GeneratesSnapshot(t *testing.T) {
 	assert.Equal(t, 1, m.FileCount())
 }
 
-func TestService_RunDuplicate_LockReleasedAfterWorkflow(t *testing.T) {
+func TestService_RunDuplicate_LockReleasedAfterWorkflow(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	modTime := time.Date(2018, 6, 15, 12, 0, 0, 0, time.UTC)
+	testutil.CreateFileWithModTime(t, filepath.Join(tmpDir, "a.txt"), "same-content", modTime)
+	testutil.CreateFileWithModTime(t, filepath.Join(tmpDir, "b.txt"), "same-content", modTime)
+
+	s := New(Options{NoSnapshot: true})
+
+	// First run should succeed.
+	_, err := s.RunDuplicate(t.Context(), DuplicateRequest{
+		TargetDir: tmpDir,
+		DryRun:    true,
+		Workers:   2,
+	})
+	require.NoError(t, err)
+
+	// Lock should be released — second run on same directory should succeed.
+	_, err = s.RunDuplicate(t.Context(), DuplicateRequest{
+		TargetDir: tmpDir,
+		DryRun:    true,
+		Workers:   2,
+	})
+	require.NoError(t, err, "second run should succeed after lock is released")
+
+	// Lock file should be cleaned up.
+	_, err = os.Stat(filepath.Join(tmpDir, ".btidy", "lock"))
+	assert.True(t, os.IsNotExist(err), "lock file should be removed after workflow")
+}
+
+func TestService_RunFlatten_InterruptedWritesJournalAndReleasesLock(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	modTime := time.Date(2018, 6, 15, 12, 0, 0, 0, time.UTC)
+	testutil.CreateFileWithModTime(t, filepath.Join(tmpDir, "a", "one.txt"), "one", modTime)
+	testutil.CreateFileWithModTime(t, filepath.Join(tmpDir, "b", "two.txt"), "two", modTime)
+
+	s := New(Options{NoSnapshot: true})
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	exec, err := s.RunFlatten(ctx, FlattenRequest{
+		TargetDir: tmpDir,
+		Workers:   2,
+		OnProgress: func(stage string, _, _ int) {
+			if stage == "moving" {
+				cancel()
+			}
+		},
+	})
+	require.NoError(t, err)
+
+	assert.True(t, exec.Interrupted)
+	assert.True(t, exec.Meta().Interrupted)
+	assert.Equal(t, 1, exec.Result.MovedCount, "no move starts after the interrupt")
+	assert.FileExists(t, filepath.Join(tmpDir, "b", "two.txt"))
+	require.NotEmpty(t, exec.JournalPath, "the journal covers the moves performed")
+
+	_, err = os.Stat(filepath.Join(tmpDir, ".btidy", "lock"))
+	assert.True(t, os.IsNotExist(err), "lock file should be removed after an interrupted workflow")
+
+	undoExec, err := s.RunUndo(t.Context(), UndoRequest{TargetDir: tmpDir})
+	require.NoError(t, err)
+	assert.Equal(t, 1, undoExec.ReversedCount)
+	assert.FileExists(t, filepath.Join(tmpDir, "a", "one.txt"))
+}
+
+func TestService_RunDuplicate_CanceledBeforeStartChangesNothing(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	modTime := time.Date(2018, 6, 15, 12, 0, 0, 0, time.UTC)
+	testutil.CreateFileWithModTime(t, filepath.Join(tmpDir, "a.txt"), "same-content", modTime)
+	testutil.CreateFileWithModTime(t, filepath.Join(tmpDir, "b.txt"), "same-content", modTime)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+
+	_, err := New(Options{}).RunDuplicate(ctx, DuplicateRequest{TargetDir: tmpDir, Workers: 2})
+	require.ErrorIs(t, err, context.Canceled)
+
+	assert.FileExists(t, filepath.Join(tmpDir, "a.txt"))
+	assert.FileExists(t, filepath.Join(tmpDir, "b.txt"))
+	_, err = os.Stat(filepath.Join(tmpDir, ".btidy", "lock"))
+	assert.True(t, os.IsNotExist(err), "lock file should be removed")
+}
+
+func TestService_RunUndo_InterruptedKeepsJournal(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	modTime := time.Date(2018, 6, 15, 12, 0, 0, 0, time.UTC)
+	testutil.CreateFileWithModTime(t, filepath.Join(tmpDir, "a", "one.txt"), "one", modTime)
+	testutil.CreateFileWithModTime(t, filepath.Join(tmpDir, "b", "two.txt"), "two", modTime)
+
+	s := New(Options{NoSnapshot: true})
+	_, err := s.RunFlatten(t.Context(), FlattenRequest{TargetDir: tmpDir, Workers: 2})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	undoExec, err := s.RunUndo(ctx, UndoRequest{
+		TargetDir: tmpDir,
+		OnProgress: func(string, int, int) {
+			cancel()
+		},
+	})
+	require.NoError(t, err)
+	assert.True(t, undoExec.Interrupted)
+	assert.Equal(t, 1, undoExec.ReversedCount)
+	assert.FileExists(t, undoExec.JournalPath, "an interrupted undo is not marked as rolled back")
+
+	undoExec, err = s.RunUndo(t.Context(), UndoRequest{TargetDir: tmpDir})
+	require.NoError(t, err)
+	assert.False(t, undoExec.Interrupted)
+	assert.Equal(t, 1, undoExec.ReversedCount, "running undo again reverses the rest")
+	assert.FileExists(t, filepath.Join(tmpDir, "a", "one.txt"))
+	assert.FileExists(t, filepath.Join(tmpDir, "b", "two.txt"))
+}
+
+func TestService_RunRename_LockPreventsConflict(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	modTime := time.Date(2018, 6, 15, 12, 0, 0, 0, time.UTC)
+	testutil.CreateFileWithModTime(t, filepath.Join(tmpDir, "file.txt"), "content", modTime)
+
+	// Manually acquire the lock to simulate a concurrent btidy process.
+	metaDir := filepath.Join(tmpDir, ".btidy")
+	require.NoError(t, os.MkdirAll(metaDir, 0o755))
+	lockPath := filepath.Join(metaDir, "lock")
+
+	lock, err := filelock.Acquire(lockPath)
+	require.NoError(t, err, "manual lock acquisition should succeed")
+
+	t.Cleanup(func() {
+		_ = lock.Close()
+	})
+
+	s := New(Options{NoSnapshot: true})
+	_, err = s.RunRename(t.Context(), RenameRequest{
+		TargetDir: tmpDir,
+		DryRun:    true,
+	})
+	require.Error(t, err, "should fail when lock is held")
+	assert.Contains(t, err.Error(), "another btidy process")
+}
+
+func TestService_RunDuplicate_WritesJournal(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	modTime := time.Date(2018, 6, 15, 12, 0, 0, 0, time.UTC)
+	testutil.CreateFileWithModTime(t, filepath.Join(tmpDir, "a.txt"), "same-content", modTime)
+	testutil.CreateFileWithModTime(t, filepath.Join(tmpDir, "b.txt"), "same-content", modTime)
+
+	s := New(Options{NoSnapshot: true})
+	execution, err := s.RunDuplicate(t.Context(), DuplicateRequest{
+		TargetDir: tmpDir,
+		DryRun:    false,
+		Workers:   2,
+	})
+	require.NoError(t, err)
+
+	assert.NotEmpty(t, execution.JournalPath, "journal path should be set")
+	_, err = os.Stat(execution.JournalPath)
+	require.NoError(t, err, "journal file should exist")
+
+	reader := journal.NewReader(execution.JournalPath)
+	entries, err := reader.Entries()
+	require.NoError(t, err)
+
+	// Filter for confirmed entries (write-ahead journal has intent+confirmation pairs).
+	confirmed := filterConfirmed(entries)
+
+	require.Len(t, confirmed, 1, "should have one trash entry for the duplicate")
+	assert.Equal(t, "trash", confirmed[0].Type)
+	assert.True(t, confirmed[0].Success)
+	assert.NotEmpty(t, confirmed[0].Hash, "trash entry should include content hash")
+	assert.NotEmpty(t, confirmed[0].Source, "source path should not be empty")
+	assert.NotEmpty(t, confirmed[0].Dest, "dest (trash path) should not be empty")
+
+	// Verify paths are relative (not absolute).
+	assert.False(t, filepath.IsAbs(confirmed[0].Source), "source should be a relative path")
+	assert.False(t, filepath.IsAbs(confirmed[0].Dest), "dest should be a relative path")
+}
+
+func TestService_RunFlatten_WritesJournal(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	modTime := time.Date(2018, 6, 15, 12, 0, 0, 0, time.UTC)
+	testutil.CreateFileWithModTime(t, filepath.Join(tmpDir, "sub", "file.txt"), "content", modTime)
+
+	s := New(Options{NoSnapshot: true})
+	execution, err := s.RunFlatten(t.Context(), FlattenRequest{
+		TargetDir: tmpDir,
+		DryRun:    false,
+		Workers:   2,
+	})
+	require.NoError(t, err)
+
+	assert.NotEmpty(t, execution.JournalPath, "journal path should be set")
+
+	reader := journal.NewReader(execution.JournalPath)
+	entries, err := reader.Entries()
+	require.NoError(t, err)
+
+	confirmed := filterConfirmed(entries)
+
+	require.Len(t, confirmed, 1, "should have one rename entry for the moved file")
+	assert.Equal(t, "rename", confirmed[0].Type)
+	assert.True(t, confirmed[0].Success)
+	assert.Equal(t, filepath.Join("sub", "file.txt"), confirmed[0].Source)
+	assert.Equal(t, "file.txt", confirmed[0].Dest)
+}
+
+func TestService_RunRename_DryRunSkipsJournal(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	modTime := time.Date(2018, 6, 15, 12, 0, 0, 0, time.UTC)
+	testutil.CreateFileWithModTime(t, filepath.Join(tmpDir, "My Document.pdf"), "content", modTime)
+
+	s := New(Options{NoSnapshot: true})
+	execution, err := s.RunRename(t.Context(), RenameRequest{
+		TargetDir: tmpDir,
+		DryRun:    true,
+	})
+	require.NoError(t, err)
+
+	assert.Empty(t, execution.JournalPath, "journal path should be empty for dry-run")
+
+	// Verify no journal directory was created.
+	_, err = os.Stat(filepath.Join(tmpDir, ".btidy", "journal"))
+	assert.True(t, os.IsNotExist(err), "no journal directory should be created in dry-run")
+}
+
+func TestService_RunRename_WritesJournal(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	modTime := time.Date(2018, 6, 15, 12, 0, 0, 0, time.UTC)
+	testutil.CreateFileWithModTime(t, filepath.Join(tmpDir, "My Document.pdf"), "content", modTime)
+
+	s := New(Options{NoSnapshot: true})
+	execution, err := s.RunRename(t.Context(), RenameRequest{
+		TargetDir: tmpDir,
+		DryRun:    false,
+	})
+	require.NoError(t, err)
+
+	assert.NotEmpty(t, execution.JournalPath, "journal path should be set")
+
+	reader := journal.NewReader(execution.JournalPath)
+	entries, err := reader.Entries()
+	require.NoError(t, err)
+
+	confirmed := filterConfirmed(entries)
+
+	require.Len(t, confirmed, 1, "should have one rename entry")
+	assert.Equal(t, "rename", confirmed[0].Type)
+	assert.True(t, confirmed[0].Success)
+	assert.Equal(t, "My Document.pdf", confirmed[0].Source)
+	assert.Equal(t, "2018-06-15_my_document.pdf", confirmed[0].Dest)
+}
+
+func TestService_RunOrganize_WritesJournal(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	modTime := time.Date(2018, 6, 15, 12, 0, 0, 0, time.UTC)
+	testutil.CreateFileWithModTime(t, filepath.Join(tmpDir, "photo.jpg"), "image-data", modTime)
+	testutil.CreateFileWithModTime(t, filepath.Join(tmpDir, "notes.txt"), "text-data", modTime)
+
+	s := New(Options{NoSnapshot: true})
+	execution, err := s.RunOrganize(t.Context(), OrganizeRequest{
+		TargetDir: tmpDir,
+		DryRun:    false,
+	})
+	require.NoError(t, err)
+
+	assert.NotEmpty(t, execution.JournalPath, "journal path should be set")
+
+	reader := journal.NewReader(execution.JournalPath)
+	entries, err := reader.Entries()
+	require.NoError(t, err)
+
+	confirmed := filterConfirmed(entries)
+
+	require.Len(t, confirmed, 2, "should have two rename entries")
+
+	// Collect entries by source for stable assertions.
+	entryBySource := make(map[string]journal.Entry, len(confirmed))
+	for _, e := range confirmed {
+		entryBySource[e.Source] = e
+	}
+
+	jpgEntry, ok := entryBySource["photo.jpg"]
+	require.True(t, ok, "should have entry for photo.jpg")
+	assert.Equal(t, "rename", jpgEntry.Type)
+	assert.True(t, jpgEntry.Success)
+	assert.Equal(t, filepath.Join("jpg", "photo.jpg"), jpgEntry.Dest)
+
+	txtEntry, ok := entryBySource["notes.txt"]
+	require.True(t, ok, "should have entry for notes.txt")
+	assert.Equal(t, "rename", txtEntry.Type)
+	assert.True(t, txtEntry.Success)
+	assert.Equal(t, filepath.Join("txt", "notes.txt"), txtEntry.Dest)
+}
+
+func TestService_RunUnzip_WritesJournal(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	archivePath := filepath.Join(tmpDir, "docs.zip")
+	writeZipArchive(t, archivePath, []zipFixtureEntry{
+		{name: "readme.txt", content: []byte("hello")},
+	})
+
+	s := New(Options{NoSnapshot: true})
+	execution, err := s.RunUnzip(t.Context(), UnzipRequest{
+		TargetDir: tmpDir,
+		DryRun:    false,
+	})
+	require.NoError(t, err)
+
+	assert.NotEmpty(t, execution.JournalPath, "journal path should be set")
+
+	reader := journal.NewReader(execution.JournalPath)
+	entries, err := reader.Entries()
+	require.NoError(t, err)
+
+	confirmed := filterConfirmed(entries)
+
+	// Should have a create entry for the extracted file, an extract entry
+	// and a trash entry (deleted archive).
+	require.Len(t, confirmed, 3, "should have create + extract + trash entries")
+
+	// Collect entries by type for stable assertions.
+	entryByType := make(map[string]journal.Entry, len(confirmed))
+	for _, e := range confirmed {
+		entryByType[e.Type] = e
+	}
+
+	extractEntry, ok := entryByType["extract"]
+	require.True(t, ok, "should have extract entry")
+	assert.True(t, extractEntry.Success)
+	assert.Equal(t, "docs.zip", extractEntry.Source)
+
+	createEntry, ok := entryByType["create"]
+	require.True(t, ok, "should have create entry for extracted file")
+	assert.Equal(t, "readme.txt", createEntry.Source)
+	assert.Equal(t, "docs.zip", createEntry.Dest)
+	assert.NotEmpty(t, createEntry.Hash)
+
+	trashEntry, ok := entryByType["trash"]
+	require.True(t, ok, "should have trash entry for deleted archive")
+	assert.True(t, trashEntry.Success)
+	assert.Equal(t, "docs.zip", trashEntry.Source)
+	assert.NotEmpty(t, trashEntry.Dest)
+}
+
+func TestService_RunUnzip_JournalsDuplicateArchive(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	entries := []zipFixtureEntry{{name: "photo.jpg", content: []byte("jpeg")}}
+	writeZipArchive(t, filepath.Join(tmpDir, "a.zip"), entries)
+	writeZipArchive(t, filepath.Join(tmpDir, "copies", "a-copy.zip"), entries)
+	duplicatePath := filepath.Join(tmpDir, "copies", "a-copy.zip")
+
+	s := New(Options{NoSnapshot: true})
+	execution, err := s.RunUnzip(t.Context(), UnzipRequest{TargetDir: tmpDir})
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, execution.Result.ExtractedArchives)
+	assert.Equal(t, 1, execution.Result.DuplicateArchives)
+	assert.NoFileExists(t, duplicatePath)
+
+	journalEntries, err := journal.NewReader(execution.JournalPath).Entries()
+	require.NoError(t, err)
+
+	var duplicate *journal.Entry
+	confirmed := filterConfirmed(journalEntries)
+	for i := range confirmed {
+		if confirmed[i].Type == "duplicate" {
+			duplicate = &confirmed[i]
+		}
+	}
+	require.NotNil(t, duplicate, "duplicate archive should be journaled")
+	assert.Equal(t, filepath.Join("copies", "a-copy.zip"), duplicate.Source)
+	assert.Equal(t, "a.zip", duplicate.Kept)
+	assert.NotEmpty(t, duplicate.Hash)
+	assert.NotEmpty(t, duplicate.Dest)
+
+	undoExec, err := s.RunUndo(t.Context(), UndoRequest{TargetDir: tmpDir})
+	require.NoError(t, err)
+
+	assert.Equal(t, 0, undoExec.ErrorCount)
+	assert.FileExists(t, duplicatePath)
+	assert.FileExists(t, filepath.Join(tmpDir, "a.zip"))
+	assert.NoFileExists(t, filepath.Join(tmpDir, "photo.jpg"))
+}
+
+func TestService_RunUnzip_ParallelKeepsJournalOrder(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	var archives []string
+	for i := range 6 {
+		name := fmt.Sprintf("part-%d.zip", i)
+		archives = append(archives, name)
+		writeZipArchive(t, filepath.Join(tmpDir, name), []zipFixtureEntry{
+			{name: "a.txt", content: fmt.Appendf(nil, "a%d", i)},
+			{name: "b/c.txt", content: fmt.Appendf(nil, "c%d", i)},
+		})
+	}
+
+	s := New(Options{NoSnapshot: true})
+	execution, err := s.RunUnzip(t.Context(), UnzipRequest{
+		TargetDir: tmpDir,
+		Layout:    unzipper.LayoutArchiveName,
+		Workers:   4,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 6, execution.Result.ExtractedArchives)
+
+	journalEntries, err := journal.NewReader(execution.JournalPath).Entries()
+	require.NoError(t, err)
+
+	// Each archive's entries form one block ending with its trash entry,
+	// and the blocks follow the order the archives were found in.
+	var trashed []string
+	current := ""
+	for _, e := range filterConfirmed(journalEntries) {
+		switch e.Type {
+		case "create":
+			if current == "" {
+				current = e.Dest
+			}
+			assert.Equal(t, current, e.Dest, "create entries of different archives interleave")
+		case "trash":
+			trashed = append(trashed, e.Source)
+			current = ""
+		}
+	}
+	assert.Equal(t, archives, trashed)
+
+	undoExec, err := s.RunUndo(t.Context(), UndoRequest{TargetDir: tmpDir})
+	require.NoError(t, err)
+	assert.Equal(t, 0, undoExec.ErrorCount)
+	for _, name := range archives {
+		assert.FileExists(t, filepath.Join(tmpDir, name))
+		assert.NoDirExists(t, filepath.Join(tmpDir, strings.TrimSuffix(name, ".zip")))
+	}
+}
+
+func TestService_RunUnzip_JournalsSplitArchiveVolumes(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	tail, parts := testutil.WriteSplitZip(t, tmpDir, "backup", map[string][]byte{
+		"photos/a.jpg": bytes.Repeat([]byte("a"), 400),
+		"photos/b.jpg": bytes.Repeat([]byte("b"), 400),
+	}, 400)
+	require.Len(t, parts, 2)
+
+	s := New(Options{NoSnapshot: true})
+	execution, err := s.RunUnzip(t.Context(), UnzipRequest{TargetDir: tmpDir})
+	require.NoError(t, err)
+	assert.Equal(t, 1, execution.Result.ExtractedArchives)
+	assert.FileExists(t, filepath.Join(tmpDir, "photos", "b.jpg"))
+
+	journalEntries, err := journal.NewReader(execution.JournalPath).Entries()
+	require.NoError(t, err)
+
+	var trashed []string
+	for _, e := range filterConfirmed(journalEntries) {
+		if e.Type == "trash" {
+			trashed = append(trashed, e.Source)
+		}
+	}
+	assert.Equal(t, []string{"backup.zip", "backup.z01", "backup.z02"}, trashed)
+
+	undoExec, err := s.RunUndo(t.Context(), UndoRequest{TargetDir: tmpDir})
+	require.NoError(t, err)
+	assert.Equal(t, 0, undoExec.ErrorCount)
+	for _, path := range append(parts, tail) {
+		assert.FileExists(t, path)
+	}
+	assert.NoDirExists(t, filepath.Join(tmpDir, "photos"))
+}
+
+func TestService_RunUnzip_SalvageKeepsArchive(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	whole := zipBytes(t, []zipFixtureEntry{
+		{name: "notes/a.txt", content: []byte("first")},
+		{name: "notes/b.txt", content: []byte("second")},
+	})
+	// A copy that stopped before the central directory was written.
+	truncated := whole[:bytes.Index(whole, []byte("PK\x01\x02"))]
+	archivePath := filepath.Join(tmpDir, "notes.zip")
+	require.NoError(t, os.WriteFile(archivePath, truncated, 0o644))
+
+	s := New(Options{NoSnapshot: true})
+	execution, err := s.RunUnzip(t.Context(), UnzipRequest{TargetDir: tmpDir, Salvage: true})
+	require.NoError(t, err)
+	assert.Equal(t, 1, execution.Result.SalvagedArchives)
+	assert.FileExists(t, filepath.Join(tmpDir, "notes", "b.txt"))
+
+	journalEntries, err := journal.NewReader(execution.JournalPath).Entries()
+	require.NoError(t, err)
+	for _, e := range filterConfirmed(journalEntries) {
+		assert.NotEqual(t, "trash", e.Type, "a salvaged archive must not be trashed")
+	}
+
+	undoExec, err := s.RunUndo(t.Context(), UndoRequest{TargetDir: tmpDir})
+	require.NoError(t, err)
+	assert.Equal(t, 0, undoExec.ErrorCount)
+	assert.FileExists(t, archivePath)
+	assert.NoDirExists(t, filepath.Join(tmpDir, "notes"))
+}
+
+func TestService_RunUnzip_EncryptedArchive(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	archivePath := filepath.Join(tmpDir, "private.zip")
+	testutil.WriteZipCryptoZip(t, archivePath, map[string][]byte{
+		"private/tax.txt": []byte("2019 return"),
+	}, "hunter2")
+
+	s := New(Options{NoSnapshot: true})
+	execution, err := s.RunUnzip(t.Context(), UnzipRequest{TargetDir: tmpDir})
+	require.NoError(t, err)
+	assert.Equal(t, 1, execution.Result.SkippedCount)
+	assert.FileExists(t, archivePath)
+	assert.NoDirExists(t, filepath.Join(tmpDir, "private"))
+
+	execution, err = s.RunUnzip(t.Context(), UnzipRequest{TargetDir: tmpDir, Passwords: []string{"letmein", "hunter2"}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, execution.Result.ExtractedArchives)
+	assert.NoFileExists(t, archivePath)
+
+	content, err := os.ReadFile(filepath.Join(tmpDir, "private", "tax.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "2019 return", string(content))
+}
+
+func TestService_RunUndo_ReversesSevenZipExtraction(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	archivePath := filepath.Join(tmpDir, "scans.7z")
+	testutil.WriteSevenZip(t, archivePath, []testutil.SevenZipEntry{
+		{Name: "scans", Dir: true},
+		{Name: "scans/2018.pdf", Body: []byte("pdf 2018")},
+		{Name: "scans/2019.pdf", Body: []byte("pdf 2019")},
+	}, testutil.SevenZipOptions{Solid: true, Password: "hunter2"})
+	originalArchive, err := os.ReadFile(archivePath)
+	require.NoError(t, err)
+
+	s := New(Options{NoSnapshot: true})
+	execution, err := s.RunUnzip(t.Context(), UnzipRequest{TargetDir: tmpDir, Passwords: []string{"hunter2"}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, execution.Result.ExtractedArchives)
+	assert.NoFileExists(t, archivePath)
+
+	content, err := os.ReadFile(filepath.Join(tmpDir, "scans", "2019.pdf"))
+	require.NoError(t, err)
+	assert.Equal(t, "pdf 2019", string(content))
+
+	entries, err := journal.NewReader(execution.JournalPath).Entries()
+	require.NoError(t, err)
+	var trashed []string
+	for _, e := range filterConfirmed(entries) {
+		if e.Type == "trash" {
+			trashed = append(trashed, e.Source)
+		}
+	}
+	assert.Equal(t, []string{"scans.7z"}, trashed)
+
+	undoExec, err := s.RunUndo(t.Context(), UndoRequest{TargetDir: tmpDir})
+	require.NoError(t, err)
+	assert.Equal(t, 0, undoExec.ErrorCount)
+
+	restored, err := os.ReadFile(archivePath)
+	require.NoError(t, err)
+	assert.Equal(t, originalArchive, restored)
+	assert.NoDirExists(t, filepath.Join(tmpDir, "scans"))
+}
+
+func TestService_RunListArchive_FindsNestedEntriesWithoutWriting(t *testing.T) {
 	t.Parallel()
 
 	tmpDir := t.TempDir()
-	modTime := time.Date(2018, 6, 15, 12, 0, 0, 0, time.UTC)
-	testutil.CreateFileWithModTime(t, filepath.Join(tmpDir, "a.txt"), "same-content", modTime)
-	testutil.CreateFileWithModTime(t, filepath.Join(tmpDir, "b.txt"), "same-content", modTime)
-
-	s := New(Options{NoSnapshot: true})
+	innerArchive := zipBytes(t, []zipFixtureEntry{
+		{name: "tax/2019/return.pdf", content: []byte("return")},
+		{name: "tax/2019/notes.txt", content: []byte("notes")},
+	})
+	archivePath := filepath.Join(tmpDir, "backup", "docs.zip")
+	writeZipArchive(t, archivePath, []zipFixtureEntry{
+		{name: "readme.pdf", content: []byte("readme")},
+		{name: "old/archive.zip", content: innerArchive},
+	})
 
-	// First run should succeed.
-	_, err := s.RunDuplicate(DuplicateRequest{
-		TargetDir: tmpDir,
-		DryRun:    true,
-		Workers:   2,
+	s := New(Options{})
+	var found []string
+	execution, err := s.RunListArchive(t.Context(), ListArchiveRequest{
+		Path: tmpDir,
+		Find: "*.pdf",
+		OnEntry: func(entry unzipper.ListedEntry) error {
+			found = append(found, entry.Location())
+			return nil
+		},
 	})
 	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"backup/docs.zip!readme.pdf",
+		"backup/docs.zip!old/archive.zip!tax/2019/return.pdf",
+	}, found)
+	assert.Equal(t, 2, execution.Matched)
+	assert.Equal(t, 2, execution.Result.Archives)
+	assert.Equal(t, 4, execution.Result.Entries)
+	assert.NoDirExists(t, filepath.Join(tmpDir, ".btidy"))
 
-	// Lock should be released — second run on same directory should succeed.
-	_, err = s.RunDuplicate(DuplicateRequest{
-		TargetDir: tmpDir,
-		DryRun:    true,
-		Workers:   2,
-	})
-	require.NoError(t, err, "second run should succeed after lock is released")
+	execution, err = s.RunListArchive(t.Context(), ListArchiveRequest{Path: archivePath, Find: "tax/*/*.txt"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, execution.Matched)
+	assert.Equal(t, filepath.Join(tmpDir, "backup"), execution.RootDir)
 
-	// Lock file should be cleaned up.
-	_, err = os.Stat(filepath.Join(tmpDir, ".btidy", "lock"))
-	assert.True(t, os.IsNotExist(err), "lock file should be removed after workflow")
+	_, err = s.RunListArchive(t.Context(), ListArchiveRequest{Path: tmpDir, Find: "[unterminated"})
+	require.ErrorContains(t, err, "invalid --find pattern")
 }
 
-func TestService_RunRename_LockPreventsConflict(t *testing.T) {
+func TestService_RunListArchive_RejectsNonArchiveFile(t *testing.T) {
 	t.Parallel()
 
 	tmpDir := t.TempDir()
-	modTime := time.Date(2018, 6, 15, 12, 0, 0, 0, time.UTC)
-	testutil.CreateFileWithModTime(t, filepath.Join(tmpDir, "file.txt"), "content", modTime)
-
-	// Manually acquire the lock to simulate a concurrent btidy process.
-	metaDir := filepath.Join(tmpDir, ".btidy")
-	require.NoError(t, os.MkdirAll(metaDir, 0o755))
-	lockPath := filepath.Join(metaDir, "lock")
-
-	lock, err := filelock.Acquire(lockPath)
-	require.NoError(t, err, "manual lock acquisition should succeed")
-
-	t.Cleanup(func() {
-		_ = lock.Close()
-	})
+	notesPath := filepath.Join(tmpDir, "notes.txt")
+	require.NoError(t, os.WriteFile(notesPath, []byte("plain text"), 0o600))
 
-	s := New(Options{NoSnapshot: true})
-	_, err = s.RunRename(RenameRequest{
-		TargetDir: tmpDir,
-		DryRun:    true,
-	})
-	require.Error(t, err, "should fail when lock is held")
-	assert.Contains(t, err.Error(), "another btidy process")
+	_, err := New(Options{}).RunListArchive(t.Context(), ListArchiveRequest{Path: notesPath})
+	require.ErrorContains(t, err, "is not a supported archive")
 }
 
-func TestService_RunDuplicate_WritesJournal(t *testing.T) {
+func TestService_RunUndo_ReversesUnzip(t *testing.T) {
 	t.Parallel()
 
 	tmpDir := t.TempDir()
-	modTime := time.Date(2018, 6, 15, 12, 0, 0, 0, time.UTC)
-	testutil.CreateFileWithModTime(t, filepath.Join(tmpDir, "a.txt"), "same-content", modTime)
-	testutil.CreateFileWithModTime(t, filepath.Join(tmpDir, "b.txt"), "same-content", modTime)
+	require.NoError(t, os.MkdirAll(filepath.Join(tmpDir, "existing"), 0o755))
 
-	s := New(Options{NoSnapshot: true})
-	execution, err := s.RunDuplicate(DuplicateRequest{
-		TargetDir: tmpDir,
-		DryRun:    false,
-		Workers:   2,
+	innerArchive := zipBytes(t, []zipFixtureEntry{
+		{name: "inner/deep.txt", content: []byte("deep")},
 	})
+	archivePath := filepath.Join(tmpDir, "backup.zip")
+	writeZipArchive(t, archivePath, []zipFixtureEntry{
+		{name: "existing/kept.txt", content: []byte("kept")},
+		{name: "new/a/unchanged.txt", content: []byte("unchanged")},
+		{name: "new/a/edited.txt", content: []byte("before edit")},
+		{name: "new/b/inner.zip", content: innerArchive},
+	})
+	originalArchive, err := os.ReadFile(archivePath)
 	require.NoError(t, err)
 
-	assert.NotEmpty(t, execution.JournalPath, "journal path should be set")
-	_, err = os.Stat(execution.JournalPath)
-	require.NoError(t, err, "journal file should exist")
+	s := New(Options{NoSnapshot: true})
+	_, err = s.RunUnzip(t.Context(), UnzipRequest{TargetDir: tmpDir})
+	require.NoError(t, err)
 
-	reader := journal.NewReader(execution.JournalPath)
-	entries, err := reader.Entries()
+	assert.FileExists(t, filepath.Join(tmpDir, "new", "b", "inner", "deep.txt"))
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "new", "a", "edited.txt"), []byte("after edit"), 0o644))
+
+	undoExec, err := s.RunUndo(t.Context(), UndoRequest{TargetDir: tmpDir})
 	require.NoError(t, err)
 
-	// Filter for confirmed entries (write-ahead journal has intent+confirmation pairs).
-	confirmed := filterConfirmed(entries)
+	assert.Equal(t, 0, undoExec.ErrorCount)
+	assert.Equal(t, 1, undoExec.TrashedCount, "edited file should be trashed, not deleted")
+	assert.Equal(t, 1, undoExec.RestoredCount, "only the outer archive was on disk to restore")
 
-	require.Len(t, confirmed, 1, "should have one trash entry for the duplicate")
-	assert.Equal(t, "trash", confirmed[0].Type)
-	assert.True(t, confirmed[0].Success)
-	assert.NotEmpty(t, confirmed[0].Hash, "trash entry should include content hash")
-	assert.NotEmpty(t, confirmed[0].Source, "source path should not be empty")
-	assert.NotEmpty(t, confirmed[0].Dest, "dest (trash path) should not be empty")
+	restored, err := os.ReadFile(archivePath)
+	require.NoError(t, err)
+	assert.Equal(t, originalArchive, restored)
 
-	// Verify paths are relative (not absolute).
-	assert.False(t, filepath.IsAbs(confirmed[0].Source), "source should be a relative path")
-	assert.False(t, filepath.IsAbs(confirmed[0].Dest), "dest should be a relative path")
+	assert.NoFileExists(t, filepath.Join(tmpDir, "existing", "kept.txt"))
+	assert.DirExists(t, filepath.Join(tmpDir, "existing"), "pre-existing directory must be kept")
+	assert.NoDirExists(t, filepath.Join(tmpDir, "new"), "directories created by the extraction should be removed")
+
+	var trashedEdit string
+	walkErr := filepath.WalkDir(filepath.Join(tmpDir, ".btidy", "trash"), func(path string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && d.Name() == "edited.txt" {
+			trashedEdit = path
+		}
+		return err
+	})
+	require.NoError(t, walkErr)
+	require.NotEmpty(t, trashedEdit, "edited file should be in undo trash")
+	content, err := os.ReadFile(trashedEdit)
+	require.NoError(t, err)
+	assert.Equal(t, "after edit", string(content))
 }
 
-func TestService_RunFlatten_WritesJournal(t *testing.T) {
+func TestService_RunUndo_ReversesFailedUnzip(t *testing.T) {
 	t.Parallel()
 
 	tmpDir := t.TempDir()
-	modTime := time.Date(2018, 6, 15, 12, 0, 0, 0, time.UTC)
-	testutil.CreateFileWithModTime(t, filepath.Join(tmpDir, "sub", "file.txt"), "content", modTime)
 
-	s := New(Options{NoSnapshot: true})
-	execution, err := s.RunFlatten(FlattenRequest{
-		TargetDir: tmpDir,
-		DryRun:    false,
-		Workers:   2,
-	})
-	require.NoError(t, err)
+	// Stored entries keep their bytes verbatim, so corrupting the last
+	// payload byte fails the second entry's checksum after the first entry
+	// has been written.
+	var buffer bytes.Buffer
+	writer := zip.NewWriter(&buffer)
+	for _, name := range []string{"docs/good.txt", "docs/bad.txt"} {
+		entryWriter, err := writer.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+		require.NoError(t, err)
+		_, err = entryWriter.Write([]byte("content of " + name))
+		require.NoError(t, err)
+	}
+	require.NoError(t, writer.Close())
+	data := buffer.Bytes()
+	badAt := bytes.Index(data, []byte("content of docs/bad.txt"))
+	require.Positive(t, badAt)
+	data[badAt] ^= 0xff
+	archivePath := filepath.Join(tmpDir, "broken.zip")
+	require.NoError(t, os.WriteFile(archivePath, data, 0o644))
 
-	assert.NotEmpty(t, execution.JournalPath, "journal path should be set")
+	s := New(Options{NoSnapshot: true})
+	execution, err := s.RunUnzip(t.Context(), UnzipRequest{TargetDir: tmpDir})
+	require.ErrorContains(t, err, "checksum error")
+	require.NotEmpty(t, execution.JournalPath, "a failed run still writes its journal")
+	require.Len(t, execution.Result.Operations, 1)
+	require.Error(t, execution.Result.Operations[0].Error)
+	require.FileExists(t, filepath.Join(tmpDir, "docs", "good.txt"))
+
+	entries, err := journal.NewReader(execution.JournalPath).Entries()
+	require.NoError(t, err)
+	var types []string
+	for _, e := range filterConfirmed(entries) {
+		types = append(types, e.Type)
+	}
+	assert.Contains(t, types, "create", "files written before the failure are journaled")
+	assert.Contains(t, types, "mkdir")
+	assert.NotContains(t, types, "extract", "a failed archive is not marked extracted")
+	assert.NotContains(t, types, "trash", "a failed archive is kept")
 
-	reader := journal.NewReader(execution.JournalPath)
-	entries, err := reader.Entries()
+	_, err = s.RunUndo(t.Context(), UndoRequest{TargetDir: tmpDir})
 	require.NoError(t, err)
 
-	confirmed := filterConfirmed(entries)
-
-	require.Len(t, confirmed, 1, "should have one rename entry for the moved file")
-	assert.Equal(t, "rename", confirmed[0].Type)
-	assert.True(t, confirmed[0].Success)
-	assert.Equal(t, filepath.Join("sub", "file.txt"), confirmed[0].Source)
-	assert.Equal(t, "file.txt", confirmed[0].Dest)
+	assert.NoDirExists(t, filepath.Join(tmpDir, "docs"), "undo removes what the failed extraction wrote")
+	assert.FileExists(t, archivePath)
 }
 
-func TestService_RunRename_DryRunSkipsJournal(t *testing.T) {
+func TestService_RunUndo_ReversesUnzipIntoArchiveNameLayout(t *testing.T) {
 	t.Parallel()
 
 	tmpDir := t.TempDir()
-	modTime := time.Date(2018, 6, 15, 12, 0, 0, 0, time.UTC)
-	testutil.CreateFileWithModTime(t, filepath.Join(tmpDir, "My Document.pdf"), "content", modTime)
+	innerArchive := zipBytes(t, []zipFixtureEntry{
+		{name: "deep.txt", content: []byte("deep")},
+	})
+	writeZipArchive(t, filepath.Join(tmpDir, "photos-2019.zip"), []zipFixtureEntry{
+		{name: "pic.jpg", content: []byte("jpeg")},
+		{name: "inner.zip", content: innerArchive},
+	})
 
 	s := New(Options{NoSnapshot: true})
-	execution, err := s.RunRename(RenameRequest{
+	execution, err := s.RunUnzip(t.Context(), UnzipRequest{
 		TargetDir: tmpDir,
-		DryRun:    true,
+		Layout:    unzipper.LayoutArchiveName,
 	})
 	require.NoError(t, err)
+	assert.Equal(t, 2, execution.Result.ExtractedArchives)
+	assert.FileExists(t, filepath.Join(tmpDir, "photos-2019", "pic.jpg"))
+	assert.FileExists(t, filepath.Join(tmpDir, "photos-2019", "inner", "deep.txt"))
 
-	assert.Empty(t, execution.JournalPath, "journal path should be empty for dry-run")
+	entries, err := journal.NewReader(execution.JournalPath).Entries()
+	require.NoError(t, err)
+	var mkdirs []string
+	for _, e := range filterConfirmed(entries) {
+		if e.Type == "mkdir" {
+			mkdirs = append(mkdirs, e.Source)
+		}
+	}
+	assert.Equal(t, []string{"photos-2019", filepath.Join("photos-2019", "inner")}, mkdirs)
 
-	// Verify no journal directory was created.
-	_, err = os.Stat(filepath.Join(tmpDir, ".btidy", "journal"))
-	assert.True(t, os.IsNotExist(err), "no journal directory should be created in dry-run")
+	undoExec, err := s.RunUndo(t.Context(), UndoRequest{TargetDir: tmpDir})
+	require.NoError(t, err)
+	assert.Equal(t, 0, undoExec.ErrorCount)
+
+	assert.FileExists(t, filepath.Join(tmpDir, "photos-2019.zip"))
+	assert.NoDirExists(t, filepath.Join(tmpDir, "photos-2019"))
 }
 
-func TestService_RunRename_WritesJournal(t *testing.T) {
+func TestService_RunUndo_UnzipKeepsDirectoriesWithNewContent(t *testing.T) {
 	t.Parallel()
 
 	tmpDir := t.TempDir()
-	modTime := time.Date(2018, 6, 15, 12, 0, 0, 0, time.UTC)
-	testutil.CreateFileWithModTime(t, filepath.Join(tmpDir, "My Document.pdf"), "content", modTime)
+	writeZipArchive(t, filepath.Join(tmpDir, "photos.zip"), []zipFixtureEntry{
+		{name: "album/photo.jpg", content: []byte("jpeg")},
+	})
 
 	s := New(Options{NoSnapshot: true})
-	execution, err := s.RunRename(RenameRequest{
-		TargetDir: tmpDir,
-		DryRun:    false,
-	})
+	_, err := s.RunUnzip(t.Context(), UnzipRequest{TargetDir: tmpDir})
 	require.NoError(t, err)
 
-	assert.NotEmpty(t, execution.JournalPath, "journal path should be set")
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "album", "added-later.jpg"), []byte("new"), 0o644))
 
-	reader := journal.NewReader(execution.JournalPath)
-	entries, err := reader.Entries()
+	undoExec, err := s.RunUndo(t.Context(), UndoRequest{TargetDir: tmpDir})
 	require.NoError(t, err)
 
-	confirmed := filterConfirmed(entries)
-
-	require.Len(t, confirmed, 1, "should have one rename entry")
-	assert.Equal(t, "rename", confirmed[0].Type)
-	assert.True(t, confirmed[0].Success)
-	assert.Equal(t, "My Document.pdf", confirmed[0].Source)
-	assert.Equal(t, "2018-06-15_my_document.pdf", confirmed[0].Dest)
+	assert.Equal(t, 0, undoExec.ErrorCount)
+	assert.NoFileExists(t, filepath.Join(tmpDir, "album", "photo.jpg"))
+	assert.FileExists(t, filepath.Join(tmpDir, "album", "added-later.jpg"))
+	assert.FileExists(t, filepath.Join(tmpDir, "photos.zip"))
+
+	var sawNotEmpty bool
+	for _, op := range undoExec.Operations {
+		if op.EntryType == "mkdir" && op.Action == undoActionSkip {
+			sawNotEmpty = strings.Contains(op.SkipReason, "not empty")
+		}
+	}
+	assert.True(t, sawNotEmpty, "non-empty created directory should be skipped")
 }
 
-func TestService_RunOrganize_WritesJournal(t *testing.T) {
+func TestService_RunUndo_ReversesDecompress(t *testing.T) {
 	t.Parallel()
 
 	tmpDir := t.TempDir()
-	modTime := time.Date(2018, 6, 15, 12, 0, 0, 0, time.UTC)
-	testutil.CreateFileWithModTime(t, filepath.Join(tmpDir, "photo.jpg"), "image-data", modTime)
-	testutil.CreateFileWithModTime(t, filepath.Join(tmpDir, "notes.txt"), "text-data", modTime)
+	compressed := gzipFixture(t, []byte("CREATE TABLE t (id int);"))
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "dump.sql.gz"), compressed, 0o644))
 
 	s := New(Options{NoSnapshot: true})
-	execution, err := s.RunOrganize(OrganizeRequest{
-		TargetDir: tmpDir,
-		DryRun:    false,
+	execution, err := s.RunUnzip(t.Context(), UnzipRequest{
+		TargetDir:             tmpDir,
+		DryRun:                false,
+		DecompressSingleFiles: true,
 	})
 	require.NoError(t, err)
+	assert.Equal(t, 1, execution.Result.DecompressedFiles)
 
-	assert.NotEmpty(t, execution.JournalPath, "journal path should be set")
-
-	reader := journal.NewReader(execution.JournalPath)
-	entries, err := reader.Entries()
+	content, err := os.ReadFile(filepath.Join(tmpDir, "dump.sql"))
 	require.NoError(t, err)
+	assert.Equal(t, "CREATE TABLE t (id int);", string(content))
+	assert.NoFileExists(t, filepath.Join(tmpDir, "dump.sql.gz"))
 
+	entries, err := journal.NewReader(execution.JournalPath).Entries()
+	require.NoError(t, err)
 	confirmed := filterConfirmed(entries)
+	require.Len(t, confirmed, 2, "should have decompress + trash entries")
+	assert.Equal(t, "decompress", confirmed[0].Type)
+	assert.Equal(t, "dump.sql.gz", confirmed[0].Source)
+	assert.Equal(t, "dump.sql", confirmed[0].Dest)
+	assert.NotEmpty(t, confirmed[0].Hash)
+	assert.Equal(t, "trash", confirmed[1].Type)
 
-	require.Len(t, confirmed, 2, "should have two rename entries")
+	undoExec, err := s.RunUndo(t.Context(), UndoRequest{TargetDir: tmpDir})
+	require.NoError(t, err)
 
-	// Collect entries by source for stable assertions.
-	entryBySource := make(map[string]journal.Entry, len(confirmed))
-	for _, e := range confirmed {
-		entryBySource[e.Source] = e
-	}
+	assert.Equal(t, 1, undoExec.RestoredCount)
+	assert.Equal(t, 1, undoExec.RemovedCount)
+	assert.Equal(t, 0, undoExec.ErrorCount)
 
-	jpgEntry, ok := entryBySource["photo.jpg"]
-	require.True(t, ok, "should have entry for photo.jpg")
-	assert.Equal(t, "rename", jpgEntry.Type)
-	assert.True(t, jpgEntry.Success)
-	assert.Equal(t, filepath.Join("jpg", "photo.jpg"), jpgEntry.Dest)
+	restored, err := os.ReadFile(filepath.Join(tmpDir, "dump.sql.gz"))
+	require.NoError(t, err)
+	assert.Equal(t, compressed, restored)
+	assert.NoFileExists(t, filepath.Join(tmpDir, "dump.sql"))
+}
 
-	txtEntry, ok := entryBySource["notes.txt"]
-	require.True(t, ok, "should have entry for notes.txt")
-	assert.Equal(t, "rename", txtEntry.Type)
-	assert.True(t, txtEntry.Success)
-	assert.Equal(t, filepath.Join("txt", "notes.txt"), txtEntry.Dest)
+func TestService_RunUnzip_DecompressDisabledByDefault(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "app.log.gz"), gzipFixture(t, []byte("log line")), 0o644))
+
+	s := New(Options{NoSnapshot: true})
+	execution, err := s.RunUnzip(t.Context(), UnzipRequest{TargetDir: tmpDir})
+	require.NoError(t, err)
+
+	assert.Equal(t, 0, execution.Result.ArchivesFound)
+	assert.Equal(t, 0, execution.Result.DecompressedFiles)
+	assert.FileExists(t, filepath.Join(tmpDir, "app.log.gz"))
 }
 
-func TestService_RunUnzip_WritesJournal(t *testing.T) {
+func TestService_RunUnzip_LimitsKeepOversizedArchive(t *testing.T) {
 	t.Parallel()
 
 	tmpDir := t.TempDir()
-	archivePath := filepath.Join(tmpDir, "docs.zip")
+	archivePath := filepath.Join(tmpDir, "big.zip")
 	writeZipArchive(t, archivePath, []zipFixtureEntry{
-		{name: "readme.txt", content: []byte("hello")},
+		{name: "big.txt", content: bytes.Repeat([]byte("x"), 2048)},
 	})
 
 	s := New(Options{NoSnapshot: true})
-	execution, err := s.RunUnzip(UnzipRequest{
+	execution, err := s.RunUnzip(t.Context(), UnzipRequest{
 		TargetDir: tmpDir,
-		DryRun:    false,
+		Limits:    &unzipper.Limits{MaxArchiveBytes: 1024},
 	})
 	require.NoError(t, err)
 
-	assert.NotEmpty(t, execution.JournalPath, "journal path should be set")
-
-	reader := journal.NewReader(execution.JournalPath)
-	entries, err := reader.Entries()
-	require.NoError(t, err)
-
-	confirmed := filterConfirmed(entries)
+	assert.Equal(t, 1, execution.Result.SkippedCount)
+	assert.Equal(t, 0, execution.Result.ExtractedArchives)
+	assert.FileExists(t, archivePath)
+	assert.NoFileExists(t, filepath.Join(tmpDir, "big.txt"))
+}
 
-	// Should have an extract entry and a trash entry (deleted archive).
-	require.Len(t, confirmed, 2, "should have extract + trash entries")
+func TestService_RunUnzip_SkipsSymlinksByDefault(t *testing.T) {
+	t.Parallel()
 
-	// Collect entries by type for stable assertions.
-	entryByType := make(map[string]journal.Entry, len(confirmed))
-	for _, e := range confirmed {
-		entryByType[e.Type] = e
-	}
+	tmpDir := t.TempDir()
+	archivePath := filepath.Join(tmpDir, "links.zip")
+	file, err := os.Create(archivePath)
+	require.NoError(t, err)
+	writer := zip.NewWriter(file)
+	target, err := writer.Create("target.txt")
+	require.NoError(t, err)
+	_, err = target.Write([]byte("target"))
+	require.NoError(t, err)
+	header := &zip.FileHeader{Name: "link.txt", Method: zip.Store}
+	header.SetMode(os.ModeSymlink | 0o777)
+	link, err := writer.CreateHeader(header)
+	require.NoError(t, err)
+	_, err = link.Write([]byte("target.txt"))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	require.NoError(t, file.Close())
 
-	extractEntry, ok := entryByType["extract"]
-	require.True(t, ok, "should have extract entry")
-	assert.True(t, extractEntry.Success)
-	assert.Equal(t, "docs.zip", extractEntry.Source)
+	s := New(Options{NoSnapshot: true})
+	execution, err := s.RunUnzip(t.Context(), UnzipRequest{TargetDir: tmpDir})
+	require.NoError(t, err)
 
-	trashEntry, ok := entryByType["trash"]
-	require.True(t, ok, "should have trash entry for deleted archive")
-	assert.True(t, trashEntry.Success)
-	assert.Equal(t, "docs.zip", trashEntry.Source)
-	assert.NotEmpty(t, trashEntry.Dest)
+	require.Len(t, execution.Result.Operations, 1)
+	assert.Equal(t, 1, execution.Result.Operations[0].SkippedEntries)
+	assert.FileExists(t, filepath.Join(tmpDir, "target.txt"))
+	_, err = os.Lstat(filepath.Join(tmpDir, "link.txt"))
+	assert.ErrorIs(t, err, os.ErrNotExist, "an unset policy must not create symlinks")
 }
 
 func TestService_RunUndo_ReversesDuplicate(t *testing.T) {
@@ -725,7 +1780,7 @@ func TestService_RunUndo_ReversesDuplicate(t *testing.T) {
 	s := New(Options{NoSnapshot: true})
 
 	// Run duplicate to trash one of the files.
-	dupExec, err := s.RunDuplicate(DuplicateRequest{
+	dupExec, err := s.RunDuplicate(t.Context(), DuplicateRequest{
 		TargetDir: tmpDir,
 		DryRun:    false,
 		Workers:   2,
@@ -743,7 +1798,7 @@ func TestService_RunUndo_ReversesDuplicate(t *testing.T) {
 	assert.Equal(t, 1, remaining, "only one file should remain after dedup")
 
 	// Run undo.
-	undoExec, err := s.RunUndo(UndoRequest{
+	undoExec, err := s.RunUndo(t.Context(), UndoRequest{
 		TargetDir: tmpDir,
 		DryRun:    false,
 	})
@@ -770,7 +1825,7 @@ func TestService_RunUndo_ReversesFlatten(t *testing.T) {
 	s := New(Options{NoSnapshot: true})
 
 	// Run flatten to move file to root.
-	flatExec, err := s.RunFlatten(FlattenRequest{
+	flatExec, err := s.RunFlatten(t.Context(), FlattenRequest{
 		TargetDir: tmpDir,
 		DryRun:    false,
 		Workers:   2,
@@ -783,7 +1838,7 @@ func TestService_RunUndo_ReversesFlatten(t *testing.T) {
 	require.NoError(t, err, "file should be at root after flatten")
 
 	// Run undo.
-	undoExec, err := s.RunUndo(UndoRequest{
+	undoExec, err := s.RunUndo(t.Context(), UndoRequest{
 		TargetDir: tmpDir,
 		DryRun:    false,
 	})
@@ -811,7 +1866,7 @@ func TestService_RunUndo_ReversesRename(t *testing.T) {
 	s := New(Options{NoSnapshot: true})
 
 	// Run rename.
-	renameExec, err := s.RunRename(RenameRequest{
+	renameExec, err := s.RunRename(t.Context(), RenameRequest{
 		TargetDir: tmpDir,
 		DryRun:    false,
 	})
@@ -823,7 +1878,7 @@ func TestService_RunUndo_ReversesRename(t *testing.T) {
 	require.NoError(t, err)
 
 	// Run undo.
-	undoExec, err := s.RunUndo(UndoRequest{
+	undoExec, err := s.RunUndo(t.Context(), UndoRequest{
 		TargetDir: tmpDir,
 		DryRun:    false,
 	})
@@ -852,7 +1907,7 @@ func TestService_RunUndo_DryRunNoChanges(t *testing.T) {
 	s := New(Options{NoSnapshot: true})
 
 	// Run duplicate to trash one file.
-	_, err := s.RunDuplicate(DuplicateRequest{
+	_, err := s.RunDuplicate(t.Context(), DuplicateRequest{
 		TargetDir: tmpDir,
 		DryRun:    false,
 		Workers:   2,
@@ -864,7 +1919,7 @@ func TestService_RunUndo_DryRunNoChanges(t *testing.T) {
 	require.NoError(t, readErr)
 
 	// Run undo in dry-run mode.
-	undoExec, err := s.RunUndo(UndoRequest{
+	undoExec, err := s.RunUndo(t.Context(), UndoRequest{
 		TargetDir: tmpDir,
 		DryRun:    true,
 	})
@@ -903,7 +1958,7 @@ func TestService_RunUndo_SpecificRunID(t *testing.T) {
 	s := New(Options{NoSnapshot: true})
 
 	// Run rename.
-	renameExec, err := s.RunRename(RenameRequest{
+	renameExec, err := s.RunRename(t.Context(), RenameRequest{
 		TargetDir: tmpDir,
 		DryRun:    false,
 	})
@@ -914,7 +1969,7 @@ func TestService_RunUndo_SpecificRunID(t *testing.T) {
 	runID := extractRunID(renameExec.JournalPath)
 
 	// Run undo with specific run ID.
-	undoExec, err := s.RunUndo(UndoRequest{
+	undoExec, err := s.RunUndo(t.Context(), UndoRequest{
 		TargetDir: tmpDir,
 		RunID:     runID,
 		DryRun:    false,
@@ -939,7 +1994,7 @@ func TestService_RunUndo_NoJournalError(t *testing.T) {
 
 	s := New(Options{NoSnapshot: true})
 
-	_, err := s.RunUndo(UndoRequest{
+	_, err := s.RunUndo(t.Context(), UndoRequest{
 		TargetDir: tmpDir,
 		DryRun:    false,
 	})
@@ -958,7 +2013,7 @@ func TestService_RunPurge_PurgesSpecificRun(t *testing.T) {
 	s := New(Options{NoSnapshot: true})
 
 	// Run duplicate to trash one file.
-	dupExec, err := s.RunDuplicate(DuplicateRequest{
+	dupExec, err := s.RunDuplicate(t.Context(), DuplicateRequest{
 		TargetDir: tmpDir,
 		DryRun:    false,
 		Workers:   2,
@@ -986,7 +2041,7 @@ func TestService_RunPurge_PurgesSpecificRun(t *testing.T) {
 	require.NoError(t, err, "trash directory should exist before purge")
 
 	// Purge the specific run.
-	purgeExec, err := s.RunPurge(PurgeRequest{
+	purgeExec, err := s.RunPurge(t.Context(), PurgeRequest{
 		TargetDir: tmpDir,
 		RunID:     trashRunID,
 		DryRun:    false,
@@ -1013,7 +2068,7 @@ func TestService_RunPurge_DryRunDoesNotDelete(t *testing.T) {
 	s := New(Options{NoSnapshot: true})
 
 	// Run duplicate to trash one file.
-	_, err := s.RunDuplicate(DuplicateRequest{
+	_, err := s.RunDuplicate(t.Context(), DuplicateRequest{
 		TargetDir: tmpDir,
 		DryRun:    false,
 		Workers:   2,
@@ -1021,7 +2076,7 @@ func TestService_RunPurge_DryRunDoesNotDelete(t *testing.T) {
 	require.NoError(t, err)
 
 	// Purge all in dry-run mode.
-	purgeExec, err := s.RunPurge(PurgeRequest{
+	purgeExec, err := s.RunPurge(t.Context(), PurgeRequest{
 		TargetDir: tmpDir,
 		All:       true,
 		DryRun:    true,
@@ -1050,7 +2105,7 @@ func TestService_RunPurge_PurgesAll(t *testing.T) {
 	s := New(Options{NoSnapshot: true})
 
 	// Run duplicate to trash one file.
-	_, err := s.RunDuplicate(DuplicateRequest{
+	_, err := s.RunDuplicate(t.Context(), DuplicateRequest{
 		TargetDir: tmpDir,
 		DryRun:    false,
 		Workers:   2,
@@ -1058,7 +2113,7 @@ func TestService_RunPurge_PurgesAll(t *testing.T) {
 	require.NoError(t, err)
 
 	// Purge all.
-	purgeExec, err := s.RunPurge(PurgeRequest{
+	purgeExec, err := s.RunPurge(t.Context(), PurgeRequest{
 		TargetDir: tmpDir,
 		All:       true,
 		DryRun:    false,
@@ -1084,7 +2139,7 @@ func TestService_RunPurge_NoTrashReturnsEmpty(t *testing.T) {
 
 	s := New(Options{NoSnapshot: true})
 
-	purgeExec, err := s.RunPurge(PurgeRequest{
+	purgeExec, err := s.RunPurge(t.Context(), PurgeRequest{
 		TargetDir: tmpDir,
 		All:       true,
 		DryRun:    false,
@@ -1107,7 +2162,7 @@ func TestService_RunPurge_OlderThanFilter(t *testing.T) {
 	s := New(Options{NoSnapshot: true})
 
 	// Run duplicate to trash one file.
-	_, err := s.RunDuplicate(DuplicateRequest{
+	_, err := s.RunDuplicate(t.Context(), DuplicateRequest{
 		TargetDir: tmpDir,
 		DryRun:    false,
 		Workers:   2,
@@ -1115,7 +2170,7 @@ func TestService_RunPurge_OlderThanFilter(t *testing.T) {
 	require.NoError(t, err)
 
 	// Purge with OlderThan = 1000 hours (the trash is seconds old, so it won't match).
-	purgeExec, err := s.RunPurge(PurgeRequest{
+	purgeExec, err := s.RunPurge(t.Context(), PurgeRequest{
 		TargetDir: tmpDir,
 		OlderThan: 1000 * time.Hour,
 		DryRun:    false,
@@ -1127,7 +2182,7 @@ func TestService_RunPurge_OlderThanFilter(t *testing.T) {
 
 	// Purge with OlderThan = 0 seconds (everything is older than 0s effectively, but
 	// we need Age > OlderThan, and OlderThan = 1ns should match anything).
-	purgeExec2, err := s.RunPurge(PurgeRequest{
+	purgeExec2, err := s.RunPurge(t.Context(), PurgeRequest{
 		TargetDir: tmpDir,
 		OlderThan: time.Nanosecond,
 		DryRun:    false,
@@ -1148,7 +2203,7 @@ func TestService_RunPurge_NoFilterReturnsNothing(t *testing.T) {
 	s := New(Options{NoSnapshot: true})
 
 	// Run duplicate to trash one file.
-	_, err := s.RunDuplicate(DuplicateRequest{
+	_, err := s.RunDuplicate(t.Context(), DuplicateRequest{
 		TargetDir: tmpDir,
 		DryRun:    false,
 		Workers:   2,
@@ -1156,7 +2211,7 @@ func TestService_RunPurge_NoFilterReturnsNothing(t *testing.T) {
 	require.NoError(t, err)
 
 	// Purge with no filter — should match nothing.
-	purgeExec, err := s.RunPurge(PurgeRequest{
+	purgeExec, err := s.RunPurge(t.Context(), PurgeRequest{
 		TargetDir: tmpDir,
 		DryRun:    false,
 	})
@@ -1167,6 +2222,47 @@ func TestService_RunPurge_NoFilterReturnsNothing(t *testing.T) {
 	assert.Empty(t, purgeExec.Operations, "no operations with no filter")
 }
 
+func TestService_HashAlgorithmIsRecordedAndVerifiedByUndo(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	modTime := time.Date(2018, 6, 15, 12, 0, 0, 0, time.UTC)
+	testutil.CreateFileWithModTime(t, filepath.Join(tmpDir, "a.txt"), "same-content", modTime)
+	testutil.CreateFileWithModTime(t, filepath.Join(tmpDir, "b.txt"), "same-content", modTime)
+
+	s := New(Options{HashAlgorithm: hasher.AlgorithmBLAKE3})
+	dupExec, err := s.RunDuplicate(t.Context(), DuplicateRequest{TargetDir: tmpDir, Workers: 2})
+	require.NoError(t, err)
+	require.Equal(t, 1, dupExec.Result.DeletedCount)
+
+	snapshot, err := manifest.Load(dupExec.SnapshotPath)
+	require.NoError(t, err)
+	assert.Equal(t, []hasher.Algorithm{hasher.AlgorithmBLAKE3}, snapshot.Algorithms())
+
+	entries, err := journal.NewReader(dupExec.JournalPath).Entries()
+	require.NoError(t, err)
+	confirmed := filterConfirmed(entries)
+	require.Len(t, confirmed, 1)
+	assert.Regexp(t, `^blake3:[0-9a-f]{64}$`, confirmed[0].Hash)
+
+	// Undo runs with the default algorithms and still verifies the hash.
+	undoExec, err := New(Options{}).RunUndo(t.Context(), UndoRequest{TargetDir: tmpDir})
+	require.NoError(t, err)
+	assert.Equal(t, 1, undoExec.RestoredCount)
+	assert.Zero(t, undoExec.SkippedCount)
+}
+
+func TestService_RunManifest_RejectsNonCryptographicAlgorithm(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	testutil.CreateFileWithModTime(t, filepath.Join(tmpDir, "a.txt"), "content", time.Now())
+
+	_, err := New(Options{HashAlgorithm: hasher.AlgorithmXXH128}).RunManifest(t.Context(), ManifestRequest{TargetDir: tmpDir, OutputPath: "manifest.json"})
+	require.Error(t, err)
+	assert.NoFileExists(t, filepath.Join(tmpDir, "manifest.json"))
+}
+
 func TestService_RunUndo_MarksJournalRolledBack(t *testing.T) {
 	t.Parallel()
 
@@ -1177,7 +2273,7 @@ func TestService_RunUndo_MarksJournalRolledBack(t *testing.T) {
 	s := New(Options{NoSnapshot: true})
 
 	// Run rename to create a journal.
-	renameExec, err := s.RunRename(RenameRequest{
+	renameExec, err := s.RunRename(t.Context(), RenameRequest{
 		TargetDir: tmpDir,
 		DryRun:    false,
 	})
@@ -1189,7 +2285,7 @@ func TestService_RunUndo_MarksJournalRolledBack(t *testing.T) {
 	require.NoError(t, err)
 
 	// Run undo.
-	_, err = s.RunUndo(UndoRequest{
+	_, err = s.RunUndo(t.Context(), UndoRequest{
 		TargetDir: tmpDir,
 		DryRun:    false,
 	})
@@ -1205,7 +2301,7 @@ func TestService_RunUndo_MarksJournalRolledBack(t *testing.T) {
 	require.NoError(t, err, "rolled-back journal should exist")
 
 	// Running undo again should fail (no active journals).
-	_, err = s.RunUndo(UndoRequest{
+	_, err = s.RunUndo(t.Context(), UndoRequest{
 		TargetDir: tmpDir,
 		DryRun:    false,
 	})
@@ -1224,7 +2320,7 @@ func TestService_RunUndo_SkipsWhenHashChanged(t *testing.T) {
 	s := New(Options{NoSnapshot: true})
 
 	// Run duplicate to trash one file.
-	dupExec, err := s.RunDuplicate(DuplicateRequest{
+	dupExec, err := s.RunDuplicate(t.Context(), DuplicateRequest{
 		TargetDir: tmpDir,
 		DryRun:    false,
 		Workers:   2,
@@ -1244,7 +2340,7 @@ func TestService_RunUndo_SkipsWhenHashChanged(t *testing.T) {
 	require.NoError(t, os.WriteFile(trashedAbs, []byte("modified-content"), 0o644))
 
 	// Run undo — should skip the entry due to hash mismatch.
-	undoExec, err := s.RunUndo(UndoRequest{
+	undoExec, err := s.RunUndo(t.Context(), UndoRequest{
 		TargetDir: tmpDir,
 		DryRun:    false,
 	})
@@ -1267,7 +2363,7 @@ func TestService_RunUndo_ProceedsWhenNoHash(t *testing.T) {
 	s := New(Options{NoSnapshot: true})
 
 	// Run flatten — rename entries don't have hashes.
-	flatExec, err := s.RunFlatten(FlattenRequest{
+	flatExec, err := s.RunFlatten(t.Context(), FlattenRequest{
 		TargetDir: tmpDir,
 		DryRun:    false,
 		Workers:   2,
@@ -1292,7 +2388,7 @@ func TestService_RunUndo_ProceedsWhenNoHash(t *testing.T) {
 	assert.True(t, hasRenameWithoutHash, "flatten should produce rename entries without hashes")
 
 	// Run undo — should proceed normally since no hash to verify.
-	undoExec, err := s.RunUndo(UndoRequest{
+	undoExec, err := s.RunUndo(t.Context(), UndoRequest{
 		TargetDir: tmpDir,
 		DryRun:    false,
 	})
@@ -1313,7 +2409,7 @@ func TestService_RunUndo_RefusesOverwriteOnRestore(t *testing.T) {
 	s := New(Options{NoSnapshot: true})
 
 	// Run duplicate to trash one file.
-	dupExec, err := s.RunDuplicate(DuplicateRequest{
+	dupExec, err := s.RunDuplicate(t.Context(), DuplicateRequest{
 		TargetDir: tmpDir,
 		DryRun:    false,
 		Workers:   2,
@@ -1335,7 +2431,7 @@ func TestService_RunUndo_RefusesOverwriteOnRestore(t *testing.T) {
 	testutil.CreateFile(t, filepath.Join(tmpDir, trashedName), newContent)
 
 	// Run undo — restore should fail for this file because target exists.
-	undoExec, err := s.RunUndo(UndoRequest{
+	undoExec, err := s.RunUndo(t.Context(), UndoRequest{
 		TargetDir: tmpDir,
 		DryRun:    false,
 	})
@@ -1361,7 +2457,7 @@ func TestService_WriteAheadJournal_IntentConfirmationPairs(t *testing.T) {
 
 	s := New(Options{NoSnapshot: true})
 
-	dupExec, err := s.RunDuplicate(DuplicateRequest{
+	dupExec, err := s.RunDuplicate(t.Context(), DuplicateRequest{
 		TargetDir: tmpDir,
 		DryRun:    false,
 		Workers:   2,
@@ -1398,7 +2494,7 @@ func TestService_WriteAheadJournal_ValidatePassesForCompleteJournal(t *testing.T
 
 	s := New(Options{NoSnapshot: true})
 
-	renameExec, err := s.RunRename(RenameRequest{
+	renameExec, err := s.RunRename(t.Context(), RenameRequest{
 		TargetDir: tmpDir,
 		DryRun:    false,
 	})
@@ -1409,3 +2505,15 @@ func TestService_WriteAheadJournal_ValidatePassesForCompleteJournal(t *testing.T
 	reader := journal.NewReader(renameExec.JournalPath)
 	require.NoError(t, reader.Validate(), "complete write-ahead journal should pass validation")
 }
+
+func gzipFixture(t *testing.T, content []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err := zw.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	return buf.Bytes()
+}